@@ -0,0 +1,280 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"errors"
+	"fmt"
+)
+
+// aggregateFuncs 为聚合函数及其参数个数的上限；count 的参数可以省略
+var aggregateFuncs = map[string]int{
+	"sum":   1,
+	"count": 1,
+	"avg":   1,
+	"min":   1,
+	"max":   1,
+}
+
+// aggregateCall 是规则中的一处聚合调用，arg 为逐行求值的参数（count() 为 nil）
+type aggregateCall struct {
+	fn  string
+	arg Expression
+}
+
+// aggregatePlan 把规则拆为逐行求值的聚合参数与最终求值的外层规则。
+// 外层规则中的聚合调用被改写为对同名占位函数的无参调用，占位函数返回上下文中以其命名的聚合结果
+type aggregatePlan struct {
+	calls  []aggregateCall
+	names  []string
+	funcs  []*FunctionLiteral // 规则内函数，供聚合参数调用
+	result *Program
+}
+
+// planAggregates 解析规则源码并找出聚合调用。聚合调用只能出现在规则主体中，不能嵌套，
+// 参数只能读取行中的字段，不能引用外层的 let 绑定或 lambda 参数
+func planAggregates(source string) (*aggregatePlan, error) {
+	l := NewLexer(source)
+	defer lexerPool.Put(l)
+	p := NewParser(l)
+	defer parserPool.Put(p)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors: %v", p.Errors())
+	}
+
+	plan := &aggregatePlan{}
+	body := program
+	if prog, ok := program.(*Program); ok {
+		plan.funcs, body = prog.Functions, prog.Body
+	}
+	ruleFns := make(map[string]bool, len(plan.funcs))
+	for _, f := range plan.funcs {
+		ruleFns[f.Name.Value] = true
+	}
+	aggregate := func(n Node) (*CallExpression, string, bool) {
+		call, ok := n.(*CallExpression)
+		if !ok {
+			return nil, "", false
+		}
+		ident, ok := call.Function.(*Identifier)
+		if !ok || ruleFns[ident.Value] {
+			return nil, "", false
+		}
+		_, ok = aggregateFuncs[ident.Value]
+		return call, ident.Value, ok
+	}
+
+	var err error
+	fail := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+	for _, f := range plan.funcs {
+		walk(f.Body, func(n Node) {
+			if _, name, ok := aggregate(n); ok {
+				fail(fmt.Errorf("aggregate %s cannot be used inside fn %s", name, f.Name.Value))
+			}
+		})
+	}
+	bound := declaredNames(body)
+	var calls []*CallExpression
+	walk(body, func(n Node) {
+		call, name, ok := aggregate(n)
+		if !ok {
+			return
+		}
+		calls = append(calls, call)
+		if len(call.Arguments) > aggregateFuncs[name] || (name != "count" && len(call.Arguments) == 0) {
+			fail(fmt.Errorf("aggregate %s expects 1 argument, got %d", name, len(call.Arguments)))
+			return
+		}
+		for _, arg := range call.Arguments {
+			inner := declaredNames(arg)
+			walk(arg, func(n Node) {
+				if _, nested, ok := aggregate(n); ok {
+					fail(fmt.Errorf("aggregate %s cannot be nested inside %s", nested, name))
+				}
+				if ident, ok := n.(*Identifier); ok && bound[ident.Value] && !inner[ident.Value] {
+					fail(fmt.Errorf("aggregate %s cannot use let binding or parameter %s: its argument is evaluated per row", name, ident.Value))
+				}
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fns := append([]*FunctionLiteral(nil), plan.funcs...)
+	for i, call := range calls {
+		ac := aggregateCall{fn: call.Function.(*Identifier).Value}
+		if len(call.Arguments) == 1 {
+			ac.arg = call.Arguments[0]
+		}
+		// 占位名含 '#'，不会与规则中的标识符重名
+		name := fmt.Sprintf("%s#%d", ac.fn, i)
+		plan.calls = append(plan.calls, ac)
+		plan.names = append(plan.names, name)
+		call.Function, call.Arguments = &Identifier{Value: name}, nil
+		fns = append(fns, &FunctionLiteral{Name: &Identifier{Value: name}, Body: &Identifier{Value: name}})
+	}
+	plan.result = &Program{Functions: fns, Body: body}
+	return plan, nil
+}
+
+// declaredNames 收集 node 中 let 绑定与 lambda 参数的名称
+func declaredNames(node Node) map[string]bool {
+	names := map[string]bool{}
+	walk(node, func(n Node) {
+		switch n := n.(type) {
+		case *LetExpression:
+			names[n.Name.Value] = true
+		case *LambdaLiteral:
+			for _, param := range n.Parameters {
+				names[param.Value] = true
+			}
+		}
+	})
+	return names
+}
+
+// Aggregator 在行流上求值含聚合函数的规则，由 Engine.NewAggregator 创建。
+// 它累积状态，不能被多个协程同时使用
+type Aggregator struct {
+	plan     *aggregatePlan
+	err      error
+	maxBytes int
+	states   []aggregateState
+	vals     []any
+}
+
+// aggregateState 是一处聚合调用的累积值：sum 为数值之和，n 为计入的行数，best 为 min/max 的当前值
+type aggregateState struct {
+	sum  Value
+	n    int64
+	best any
+}
+
+// NewAggregator 返回以本规则为聚合表达式的聚合器。规则中的 sum(x)、count()、count(cond)、
+// avg(x)、min(x)、max(x) 为聚合调用：Feed 以每一行为上下文求出各调用的参数并累积，
+// Result 以累积结果代替各调用，求出规则其余部分的值，例如 `avg(latency) > 200 && count() >= 10`。
+//
+// 聚合按 AST 解释器的语义求值，与创建引擎时选择的后端无关。从字节码包加载的引擎没有规则源码，
+// 其聚合器的 Feed 与 Result 均返回错误
+func (e *Engine) NewAggregator() *Aggregator {
+	a := &Aggregator{maxBytes: e.maxConcatBytes}
+	if e.source == "" {
+		a.err = errors.New("aggregate: engine has no rule source")
+		return a
+	}
+	a.plan, a.err = planAggregates(e.source)
+	if a.err == nil {
+		a.states = make([]aggregateState, len(a.plan.calls))
+		a.vals = make([]any, len(a.plan.calls))
+		a.Reset()
+	}
+	return a
+}
+
+// Reset 清空累积的状态，开始新的窗口
+func (a *Aggregator) Reset() {
+	for i := range a.states {
+		a.states[i] = aggregateState{sum: Value{Type: ValInt}}
+	}
+}
+
+func (a *Aggregator) context(ctx Context) Context {
+	if a.maxBytes > 0 {
+		return &concatBudgetContext{Context: ctx, budget: newConcatBudget(a.maxBytes)}
+	}
+	return ctx
+}
+
+// Feed 以 row 为上下文求出各聚合调用的参数并计入。任一参数求值出错或类型不符时
+// 整行都不计入，返回错误
+func (a *Aggregator) Feed(row map[string]any) error {
+	if a.err != nil {
+		return a.err
+	}
+	if row == nil {
+		row = make(map[string]any)
+	}
+	ctx := a.context(&funcContext{Context: &MapContext{vars: row}, funcs: a.plan.funcs})
+	for i, call := range a.plan.calls {
+		a.vals[i] = nil
+		if call.arg == nil {
+			continue
+		}
+		v, err := Eval(call.arg, ctx)
+		if err != nil {
+			return fmt.Errorf("aggregate %s: %w", call.fn, err)
+		}
+		if call.fn != "count" && v != nil {
+			if t := FromInterface(v).Type; t != ValInt && t != ValFloat {
+				return fmt.Errorf("aggregate %s: expected number, got %T", call.fn, v)
+			}
+		}
+		a.vals[i] = v
+	}
+	for i, call := range a.plan.calls {
+		a.states[i].feed(call, a.vals[i])
+	}
+	return nil
+}
+
+func (s *aggregateState) feed(call aggregateCall, v any) {
+	switch call.fn {
+	case "count":
+		if call.arg == nil || isTruthy(v) {
+			s.n++
+		}
+		return
+	}
+	if v == nil {
+		return
+	}
+	s.n++
+	switch call.fn {
+	case "sum", "avg":
+		s.sum = AddAny(s.sum.ToInterface(), v)
+	case "min":
+		if s.best == nil || LessAny(v, s.best) {
+			s.best = v
+		}
+	case "max":
+		if s.best == nil || GreaterAny(v, s.best) {
+			s.best = v
+		}
+	}
+}
+
+func (s *aggregateState) result(fn string) any {
+	switch fn {
+	case "count":
+		return s.n
+	case "sum":
+		return s.sum.ToInterface()
+	case "avg":
+		if s.n == 0 {
+			return nil
+		}
+		sum, _ := valToFloat64(s.sum)
+		return sum / float64(s.n)
+	}
+	return s.best
+}
+
+// Result 以目前累积的聚合结果求出规则的值，可在窗口中途多次调用。空窗口中 sum 为 0、count 为 0，
+// avg、min、max 为 nil。聚合调用之外的变量不来自任何一行，读取时为 nil
+func (a *Aggregator) Result() (any, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	vars := make(map[string]any, len(a.plan.calls))
+	for i, call := range a.plan.calls {
+		vars[a.plan.names[i]] = a.states[i].result(call.fn)
+	}
+	return Eval(a.plan.result, a.context(&MapContext{vars: vars}))
+}
@@ -0,0 +1,106 @@
+package uwasa
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAggregator(t *testing.T) {
+	rows := []map[string]any{
+		{"latency": int64(120), "status": int64(200), "path": "/a"},
+		{"latency": int64(340), "status": int64(500), "path": "/b"},
+		{"latency": 95.5, "status": int64(200), "path": "/a"},
+		{"status": int64(404), "path": "/c"},
+	}
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`count()`, int64(4)},
+		{`count(status >= 500)`, int64(1)},
+		{`sum(latency)`, 555.5},
+		{`sum(status / 100)`, int64(13)},
+		{`avg(latency)`, 555.5 / 3},
+		{`min(latency), max(latency)`, []any{95.5, int64(340)}},
+		{`avg(latency) > 150 && count(status >= 500) >= 1`, true},
+		{`if count(path == "/a") * 2 >= count() is "hot" else is "cold"`, "hot"},
+		{`let limit = 300 => max(latency) > limit`, true},
+		{`fn half(x) => x / 2; sum(half(status))`, int64(652)},
+		{`sum(len(filter([status, 600], x -> x > 300)))`, int64(6)},
+		{`concat(count(), " requests")`, "4 requests"},
+		{`1 + 2`, int64(3)},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			agg := engine.NewAggregator()
+			for _, row := range rows {
+				if err := agg.Feed(row); err != nil {
+					t.Errorf("%s %s: feed error: %v", name, tt.input, err)
+				}
+			}
+			got, err := agg.Result()
+			if err != nil {
+				t.Errorf("%s %s: result error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+
+		// 空窗口与 Reset
+		engine, _ := newEngine(`sum(latency), count(), avg(latency), max(latency)`)
+		agg := engine.NewAggregator()
+		agg.Feed(rows[1])
+		agg.Reset()
+		if got, err := agg.Result(); err != nil || !reflect.DeepEqual(got, []any{int64(0), int64(0), nil, nil}) {
+			t.Errorf("%s: expected empty window, got %v (%v)", name, got, err)
+		}
+
+		// 出错的行整行不计入
+		engine, _ = newEngine(`count(), sum(latency)`)
+		agg = engine.NewAggregator()
+		agg.Feed(rows[0])
+		if err := agg.Feed(map[string]any{"latency": "slow"}); err == nil || !strings.Contains(err.Error(), "expected number") {
+			t.Errorf("%s: expected type error, got %v", name, err)
+		}
+		if got, _ := agg.Result(); !reflect.DeepEqual(got, []any{int64(1), int64(120)}) {
+			t.Errorf("%s: expected failing row to be skipped, got %v", name, got)
+		}
+
+		for _, bad := range []string{
+			`sum(max(latency))`,
+			`sum()`,
+			`count(a, b)`,
+			`let k = 2 => sum(latency * k)`,
+			`fn f(x) => sum(x); f(1)`,
+		} {
+			engine, err := newEngine(bad)
+			if err != nil {
+				continue
+			}
+			agg := engine.NewAggregator()
+			if err := agg.Feed(rows[0]); err == nil {
+				t.Errorf("%s %s: expected feed error", name, bad)
+			}
+			if _, err := agg.Result(); err == nil {
+				t.Errorf("%s %s: expected result error", name, bad)
+			}
+		}
+	}
+
+	// 规则内函数与聚合函数重名时按普通调用处理
+	engine, _ := NewEngine(`fn sum(x) => x; sum(latency)`)
+	agg := engine.NewAggregator()
+	agg.Feed(rows[0])
+	if got, err := agg.Result(); err != nil || got != nil {
+		t.Errorf("expected shadowed sum to read nil latency, got %v (%v)", got, err)
+	}
+}
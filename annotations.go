@@ -0,0 +1,85 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+)
+
+// Metadata 是规则开头注解块解析出的元数据，例如
+//
+//	@name("vip-check") @owner("growth") @tag("pricing") @tag("vip")
+//	if level >= 3 is true else is false
+//
+// name 与 owner 至多出现一次，tag 可重复；其他注解名同样保留在 Annotations 中。
+type Metadata struct {
+	Name  string
+	Owner string
+	Tags  []string
+	// Annotations 按注解名保存全部注解值（包括 name、owner、tag），同名注解按出现顺序排列
+	Annotations map[string][]string
+}
+
+// Metadata 返回编译时从规则注解块解析出的元数据；规则没有注解时为零值
+func (e *Engine) Metadata() Metadata {
+	return e.meta
+}
+
+// ParseRule 解析规则源码，返回去掉注解后的语法树与注解元数据，供代码生成等外部工具使用。
+// 返回的语法树未经常量折叠。
+func ParseRule(input string) (Expression, Metadata, error) {
+	meta, body, err := parseAnnotations(input)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	l := NewLexer(body)
+	defer lexerPool.Put(l)
+	p := NewParser(l)
+	defer parserPool.Put(p)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, Metadata{}, fmt.Errorf("parser errors: %v", p.Errors())
+	}
+	return program, meta, nil
+}
+
+// parseAnnotations 解析规则开头的 `@key("value")` 注解，返回元数据与去掉注解后的规则正文。
+// 注解只能出现在正文之前，参数必须是单个字符串字面量。
+func parseAnnotations(input string) (Metadata, string, error) {
+	var meta Metadata
+	l := NewLexer(input)
+	defer lexerPool.Put(l)
+	body := input
+	for tok := l.NextToken(); tok.Type == TokenAt; tok = l.NextToken() {
+		key := l.NextToken()
+		if key.Type != TokenIdent {
+			return Metadata{}, "", fmt.Errorf("annotation error: expected name after @, got %s", key.Type)
+		}
+		if tok := l.NextToken(); tok.Type != TokenLParen {
+			return Metadata{}, "", fmt.Errorf("annotation error: expected ( after @%s, got %s", key.Literal, tok.Type)
+		}
+		val := l.NextToken()
+		if val.Type != TokenString {
+			return Metadata{}, "", fmt.Errorf("annotation error: @%s expects a string argument, got %s", key.Literal, val.Type)
+		}
+		if tok := l.NextToken(); tok.Type != TokenRParen {
+			return Metadata{}, "", fmt.Errorf("annotation error: expected ) after @%s argument, got %s", key.Literal, tok.Type)
+		}
+		switch key.Literal {
+		case "name", "owner":
+			if len(meta.Annotations[key.Literal]) > 0 {
+				return Metadata{}, "", fmt.Errorf("annotation error: duplicate @%s", key.Literal)
+			}
+			if key.Literal == "name" { meta.Name = val.Literal } else { meta.Owner = val.Literal }
+		case "tag":
+			meta.Tags = append(meta.Tags, val.Literal)
+		}
+		if meta.Annotations == nil {
+			meta.Annotations = make(map[string][]string)
+		}
+		meta.Annotations[key.Literal] = append(meta.Annotations[key.Literal], val.Literal)
+		body = input[l.position:]
+	}
+	return meta, body, nil
+}
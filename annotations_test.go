@@ -0,0 +1,49 @@
+package uwasa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnnotations(t *testing.T) {
+	const rule = `@name("vip-check") @owner("growth") @tag("pricing") @tag("vip") @since("2026-10")
+	if level >= 3 is "vip" else is "regular"`
+	want := Metadata{
+		Name:  "vip-check",
+		Owner: "growth",
+		Tags:  []string{"pricing", "vip"},
+		Annotations: map[string][]string{
+			"name": {"vip-check"}, "owner": {"growth"}, "tag": {"pricing", "vip"}, "since": {"2026-10"},
+		},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		engine, err := newEngine(rule)
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", name, err)
+		}
+		if got := engine.Metadata(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected metadata %+v, got %+v", name, want, got)
+		}
+		if got, err := engine.Execute(map[string]any{"level": int64(4)}); err != nil || got != "vip" {
+			t.Errorf("%s: expected vip, got %v (%v)", name, got, err)
+		}
+
+		plain, _ := newEngine(`1 + 1`)
+		if got := plain.Metadata(); !reflect.DeepEqual(got, Metadata{}) {
+			t.Errorf("%s: expected empty metadata, got %+v", name, got)
+		}
+
+		for _, bad := range []string{
+			`@name("a") @name("b") 1`,
+			`@owner(growth) 1`,
+			`@tag("a" 1`,
+			`@("a") 1`,
+			`1 + @tag("a")`,
+		} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
@@ -7,6 +7,8 @@ import "strings"
 
 import "fmt"
 
+import "time"
+
 type Node interface {
 	String() string
 }
@@ -44,6 +46,33 @@ type StringLiteral struct {
 func (s *StringLiteral) expressionNode() {}
 func (s *StringLiteral) String() string  { return s.Value }
 
+// TimeLiteral 为 `@2024-01-01T00:00:00Z` 形式的时间字面量，Literal 为 @ 之后的原文
+type TimeLiteral struct {
+	Value   time.Time
+	Literal string
+}
+
+func (t *TimeLiteral) expressionNode() {}
+func (t *TimeLiteral) String() string  { return "@" + t.Literal }
+
+// DurationLiteral 为 `2h30m` 形式的时长字面量，Literal 为原文
+type DurationLiteral struct {
+	Value   time.Duration
+	Literal string
+}
+
+func (d *DurationLiteral) expressionNode() {}
+func (d *DurationLiteral) String() string  { return d.Literal }
+
+// DecimalLiteral 为 `1.23d` 形式的十进制数字面量，Literal 为原文
+type DecimalLiteral struct {
+	Value   Decimal
+	Literal string
+}
+
+func (d *DecimalLiteral) expressionNode() {}
+func (d *DecimalLiteral) String() string  { return d.Literal }
+
 type BooleanLiteral struct {
 	Value bool
 }
@@ -70,6 +99,9 @@ type InfixExpression struct {
 	Left     Expression
 	Operator string
 	Right    Expression
+	// ReturnsOperand 只用于 `&&` 与 `||`：为 true 时返回决定结果的操作数本身而不是布尔值，
+	// 见 EngineOptions.OperandLogic
+	ReturnsOperand bool
 }
 
 func (ie *InfixExpression) expressionNode() {}
@@ -83,6 +115,9 @@ type IfExpression struct {
 	Alternative Expression // for 'else'
 	IsThen      bool       // true if 'then', false if 'is'
 	IsSimple    bool       // true if only 'if <cond>'
+	// ConsequenceSpan 与 AlternativeSpan 为两个分支在源码中的位置，分别从 is/then 与 else/elif 起，
+	// 供 Engine.DeadBranches 报告；由 match 展开等方式构造的 if 为零值
+	ConsequenceSpan, AlternativeSpan Span
 }
 
 func (ie *IfExpression) expressionNode() {}
@@ -118,9 +153,123 @@ func (ae *AssignExpression) String() string {
 	return "(" + ae.Name.String() + " = " + ae.Value.String() + ")"
 }
 
+// DestructureExpression 是 `{a, b} = m => body`：把映射 m 中与各名称同名的成员依次写入 Context
+// （m 不是映射或缺少该键时写入 nil），再求值 body。省略 `=> body` 时值为 m
+type DestructureExpression struct {
+	Names []*Identifier
+	Value Expression
+	Body  Expression
+}
+
+func (de *DestructureExpression) expressionNode() {}
+func (de *DestructureExpression) String() string {
+	names := make([]string, len(de.Names))
+	for i, name := range de.Names {
+		names[i] = name.String()
+	}
+	out := "({" + strings.Join(names, ", ") + "} = " + de.Value.String()
+	if de.Body != nil {
+		out += " => " + de.Body.String()
+	}
+	return out + ")"
+}
+
+// LetExpression 是 `let name = value => body`：name 只在 body 内可见，
+// 保存在 VM 的栈槽或寄存器中，不会写入 Context
+type LetExpression struct {
+	Name  *Identifier
+	Value Expression
+	Body  Expression
+}
+
+func (le *LetExpression) expressionNode() {}
+func (le *LetExpression) String() string {
+	return "(let " + le.Name.String() + " = " + le.Value.String() + " => " + le.Body.String() + ")"
+}
+
+// LambdaLiteral 是 `x -> body` 或 `(x, y) -> body` 定义的匿名函数，求值得到 *Closure，
+// 供高阶内置函数调用。函数体可以读取创建处可见的 let 绑定与参数（按值捕获）
+type LambdaLiteral struct {
+	Parameters []*Identifier
+	Body       Expression
+}
+
+func (ll *LambdaLiteral) expressionNode() {}
+func (ll *LambdaLiteral) String() string {
+	var out strings.Builder
+	out.WriteString("(")
+	if len(ll.Parameters) == 1 {
+		out.WriteString(ll.Parameters[0].String())
+	} else {
+		out.WriteString("(")
+		for i, p := range ll.Parameters {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			out.WriteString(p.String())
+		}
+		out.WriteString(")")
+	}
+	out.WriteString(" -> " + ll.Body.String() + ")")
+	return out.String()
+}
+
+// FunctionLiteral 是 `fn name(params) => body` 定义的规则内函数。函数体只能看到参数、
+// 全局变量与先于它定义的函数，因此不存在递归
+type FunctionLiteral struct {
+	Name       *Identifier
+	Parameters []*Identifier
+	Body       Expression
+}
+
+func (fl *FunctionLiteral) expressionNode() {}
+func (fl *FunctionLiteral) String() string {
+	var out strings.Builder
+	out.WriteString("fn " + fl.Name.String() + "(")
+	for i, p := range fl.Parameters {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(p.String())
+	}
+	out.WriteString(") => " + fl.Body.String())
+	return out.String()
+}
+
+// Program 是以函数定义开头的规则：Functions 按定义顺序排列，Body 为其后的规则主体。
+// 没有函数定义的规则直接解析为主体表达式
+type Program struct {
+	Functions []*FunctionLiteral
+	Body      Expression
+}
+
+func (p *Program) expressionNode() {}
+func (p *Program) String() string {
+	var out strings.Builder
+	for _, fn := range p.Functions {
+		out.WriteString(fn.String() + "; ")
+	}
+	out.WriteString(p.Body.String())
+	return out.String()
+}
+
+// IndexAssignExpression 是 `a[i] = v`，原地修改数组元素并返回 v
+type IndexAssignExpression struct {
+	Left  Expression
+	Index Expression
+	Value Expression
+}
+
+func (ia *IndexAssignExpression) expressionNode() {}
+func (ia *IndexAssignExpression) String() string {
+	return "(" + ia.Left.String() + "[" + ia.Index.String() + "] = " + ia.Value.String() + ")"
+}
+
 type CallExpression struct {
 	Function  Expression
 	Arguments []Expression
+	// Comprehension 表示这是数组推导式改写出的、遍历 in 之后的值的那次 map 或 filter，仅用于报错
+	Comprehension bool
 }
 
 func (ce *CallExpression) expressionNode() {}
@@ -136,3 +285,166 @@ func (ce *CallExpression) String() string {
 	out.WriteString(")")
 	return out.String()
 }
+
+// TupleExpression 是顶层以逗号分隔的多个表达式，按顺序求值并以 []any 返回
+type TupleExpression struct {
+	Elements []Expression
+}
+
+func (te *TupleExpression) expressionNode() {}
+func (te *TupleExpression) String() string {
+	var out strings.Builder
+	for i, el := range te.Elements {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(el.String())
+	}
+	return out.String()
+}
+
+// RangeExpression 是区间字面量 `start..end`，两端均包含在内，求值得到 Range
+type RangeExpression struct {
+	Start Expression
+	End   Expression
+}
+
+func (re *RangeExpression) expressionNode() {}
+func (re *RangeExpression) String() string {
+	return "(" + re.Start.String() + ".." + re.End.String() + ")"
+}
+
+// ScoreExpression 是 `score { cond: weight, ... }`：条件为真的各项权重之和，不短路。
+// 权重为数字字面量；全部为整数时结果为整数，否则为浮点数
+type ScoreExpression struct {
+	Conditions []Expression
+	Weights    []*NumberLiteral
+}
+
+func (se *ScoreExpression) expressionNode() {}
+func (se *ScoreExpression) String() string {
+	items := make([]string, len(se.Conditions))
+	for i, cond := range se.Conditions {
+		items[i] = cond.String() + ": " + se.Weights[i].String()
+	}
+	return "score {" + strings.Join(items, ", ") + "}"
+}
+
+// SequenceExpression 是以分号或换行分隔的多条语句，按顺序求值，值为最后一条语句的值。
+// 只有最后一条语句可以是 TupleExpression
+type SequenceExpression struct {
+	Statements []Expression
+}
+
+func (se *SequenceExpression) expressionNode() {}
+func (se *SequenceExpression) String() string {
+	var out strings.Builder
+	for i, stmt := range se.Statements {
+		if i > 0 {
+			out.WriteString("; ")
+		}
+		out.WriteString(stmt.String())
+	}
+	return out.String()
+}
+
+// ArrayLiteral 是 `[a, b, c]`，求值结果为 []any
+type ArrayLiteral struct {
+	Elements []Expression
+}
+
+func (al *ArrayLiteral) expressionNode() {}
+func (al *ArrayLiteral) String() string {
+	var out strings.Builder
+	out.WriteString("[")
+	for i, el := range al.Elements {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(el.String())
+	}
+	out.WriteString("]")
+	return out.String()
+}
+
+// SpreadElement 是数组字面量中的 `...a`，把数组 a 的元素依次展开到所在位置，只能作为数组字面量的元素
+type SpreadElement struct {
+	Value Expression
+}
+
+func (se *SpreadElement) expressionNode() {}
+func (se *SpreadElement) String() string { return "..." + se.Value.String() }
+
+// spreadIndex 返回 elements 中从 from 起第一个展开元素的下标，没有时返回 len(elements)
+func spreadIndex(elements []Expression, from int) int {
+	for i := from; i < len(elements); i++ {
+		if _, ok := elements[i].(*SpreadElement); ok {
+			return i
+		}
+	}
+	return len(elements)
+}
+
+// IndexExpression 是 `a[i]`，下标必须为整数
+type IndexExpression struct {
+	Left  Expression
+	Index Expression
+}
+
+func (ie *IndexExpression) expressionNode() {}
+func (ie *IndexExpression) String() string {
+	return "(" + ie.Left.String() + "[" + ie.Index.String() + "])"
+}
+
+// MapLiteral 是 `{"k": v, ...}`，键按出现顺序求值且必须为字符串，求值结果为 map[string]any
+type MapLiteral struct {
+	Keys   []Expression
+	Values []Expression
+}
+
+func (ml *MapLiteral) expressionNode() {}
+func (ml *MapLiteral) String() string {
+	var out strings.Builder
+	out.WriteString("{")
+	for i, key := range ml.Keys {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(key.String() + ": " + ml.Values[i].String())
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
+// MethodCallExpression 是 `recv.method(args)`，接收者可以是任意表达式
+type MethodCallExpression struct {
+	Receiver  Expression
+	Method    string
+	Arguments []Expression
+}
+
+func (mc *MethodCallExpression) expressionNode() {}
+func (mc *MethodCallExpression) String() string {
+	var out strings.Builder
+	out.WriteString(mc.Receiver.String() + "." + mc.Method + "(")
+	for i, arg := range mc.Arguments {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(arg.String())
+	}
+	out.WriteString(")")
+	return out.String()
+}
+
+// OptionalMemberExpression 是可选链 `recv?.name`：接收者是映射时取其成员 name（缺失时为 nil），
+// 否则短路为 nil 而不报错，因此 `user?.address?.city` 在任何一级缺失时都得到 nil
+type OptionalMemberExpression struct {
+	Receiver Expression
+	Name     string
+}
+
+func (om *OptionalMemberExpression) expressionNode() {}
+func (om *OptionalMemberExpression) String() string {
+	return "(" + om.Receiver.String() + "?." + om.Name + ")"
+}
@@ -117,6 +117,18 @@ func BenchmarkEngineExecute_VM(b *testing.B) {
 	}
 }
 
+func BenchmarkEngineExecuteWithState_VM(b *testing.B) {
+	input := `if a == 0 is "yes" else if a == 1 is concat("ok:", a) else is "bad"`
+	engine, _ := NewEngineVM(input)
+	vars := map[string]any{"a": 1}
+	st := NewRunState()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.ExecuteWithState(st, vars)
+	}
+}
+
 func BenchmarkEngineExecute_OptNone(b *testing.B) {
 	input := `if a == 0 is "yes" else if a == 1 is "ok" else is "bad"`
 	engine, _ := NewEngineWithOptions(input, EngineOptions{OptimizationLevel: OptNone})
@@ -0,0 +1,448 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// 字节码包格式：
+//
+//	bundle   = "UWBC" version:byte count:uvarint { name:string program }
+//	program  = resultCount:uvarint maxConcatBytes:uvarint locals:uvarint
+//	           insts consts:uvarint { value } functions:uvarint { function }
+//	function = name:string params:uvarint locals:uvarint insts   // 与主程序共用常量池
+//	insts    = count:uvarint { op:byte arg:varint }
+//	value    = type:byte payload   // int/float/bool 为 uvarint，string 为 string，array/map 递归，object 见下
+//	object   = 0:byte start:varint end:varint                       // 区间
+//	         | 1:byte builtin:string index:uvarint source:value       // 内置函数预处理的常量实参，加载时重新预处理
+//	string   = len:uvarint bytes
+//
+// 签名信封："UWSB" signature[64] bundle，签名覆盖整个 bundle。
+// 指令编码与 NeoOpCode 的取值一一对应，调整操作码编号时须提升 bundleVersion。
+const (
+	bundleMagic   = "UWBC"
+	envelopeMagic = "UWSB"
+	bundleVersion = 4
+)
+
+// ErrBundleSignature 表示签名信封校验失败
+var ErrBundleSignature = errors.New("bundle signature verification failed")
+
+// MarshalBundle 将以 NeoVM 编译的引擎序列化为字节码包，供 SignBundle 签名后分发。
+// 边缘节点用 OpenSignedBundle 加载，无需携带编译器。规则按名称排序写入，输出可复现。
+func MarshalBundle(rules map[string]*Engine) ([]byte, error) {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString(bundleMagic)
+	buf.WriteByte(bundleVersion)
+	putUvarint(&buf, uint64(len(names)))
+	for _, name := range names {
+		e := rules[name]
+		bc := e.neoBytecode
+		if e.isConstant {
+			// 常量程序没有保留字节码，按 PUSH c; RET 写出
+			bc = &NeoBytecode{
+				Instructions: []neoInstruction{{Op: NeoOpPush}, {Op: NeoOpReturn}},
+				Constants:    []Value{FromInterface(e.constantResult)},
+			}
+		}
+		if bc == nil {
+			return nil, fmt.Errorf("bundle: rule %q was not compiled with NeoVM", name)
+		}
+		putString(&buf, name)
+		putUvarint(&buf, uint64(bc.ResultCount))
+		putUvarint(&buf, uint64(bc.MaxConcatBytes))
+		putUvarint(&buf, uint64(bc.Locals))
+		putInstructions(&buf, bc.Instructions)
+		putUvarint(&buf, uint64(len(bc.Constants)))
+		for _, v := range bc.Constants {
+			if err := putValue(&buf, v); err != nil {
+				return nil, fmt.Errorf("bundle: rule %q: %w", name, err)
+			}
+		}
+		putUvarint(&buf, uint64(len(bc.Functions)))
+		for _, fn := range bc.Functions {
+			putString(&buf, fn.Name)
+			putUvarint(&buf, uint64(fn.Params))
+			putUvarint(&buf, uint64(fn.Locals))
+			putInstructions(&buf, fn.Instructions)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// SignBundle 用 ed25519 私钥为字节码包加上签名信封
+func SignBundle(bundle []byte, key ed25519.PrivateKey) []byte {
+	env := make([]byte, 0, len(envelopeMagic)+ed25519.SignatureSize+len(bundle))
+	env = append(env, envelopeMagic...)
+	env = append(env, ed25519.Sign(key, bundle)...)
+	return append(env, bundle...)
+}
+
+// OpenSignedBundle 校验签名信封并加载其中的规则。签名不符时返回 ErrBundleSignature；
+// 字节码做格式与操作数检查，常量下标、跳转目标与槽位越界的包被拒绝，其余正确性由签名方保证。
+func OpenSignedBundle(env []byte, pub ed25519.PublicKey) (map[string]*Engine, error) {
+	if len(env) < len(envelopeMagic)+ed25519.SignatureSize || string(env[:len(envelopeMagic)]) != envelopeMagic {
+		return nil, errors.New("bundle: not a signed bundle")
+	}
+	sig := env[len(envelopeMagic) : len(envelopeMagic)+ed25519.SignatureSize]
+	bundle := env[len(envelopeMagic)+ed25519.SignatureSize:]
+	if len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, bundle, sig) {
+		return nil, ErrBundleSignature
+	}
+	return unmarshalBundle(bundle)
+}
+
+func unmarshalBundle(data []byte) (rules map[string]*Engine, err error) {
+	r := &bundleReader{data: data}
+	// 读取越界时 bundleReader 以 panic 中止，统一转为格式错误
+	defer func() {
+		if rec := recover(); rec != nil {
+			if e, ok := rec.(bundleError); ok {
+				rules, err = nil, e
+				return
+			}
+			panic(rec)
+		}
+	}()
+	if string(r.bytes(len(bundleMagic))) != bundleMagic {
+		return nil, bundleError("bad magic")
+	}
+	if v := r.byte(); v != bundleVersion {
+		return nil, fmt.Errorf("bundle: unsupported version %d", v)
+	}
+	n := r.count()
+	rules = make(map[string]*Engine, n)
+	for range n {
+		name := r.string()
+		bc := &NeoBytecode{ResultCount: r.count(), MaxConcatBytes: r.count(), Locals: r.count()}
+		if bc.Locals > maxLetBindings {
+			return nil, fmt.Errorf("bundle: rule %q uses %d let slots (max %d)", name, bc.Locals, maxLetBindings)
+		}
+		bc.Instructions = r.instructions()
+		bc.Constants = make([]Value, r.count())
+		for i := range bc.Constants {
+			bc.Constants[i] = FromInterface(r.value())
+		}
+		if len(bc.Instructions) == 0 || bc.Instructions[len(bc.Instructions)-1].Op != NeoOpReturn {
+			return nil, fmt.Errorf("bundle: rule %q does not end with RET", name)
+		}
+		nf := r.count()
+		if nf > 0 {
+			if nf > maxFunctions+maxLambdas {
+				return nil, fmt.Errorf("bundle: rule %q defines %d functions (max %d)", name, nf, maxFunctions+maxLambdas)
+			}
+			bc.Functions = make([]*NeoBytecode, nf)
+			for i := range bc.Functions {
+				fn := &NeoBytecode{Name: r.string(), Params: r.count(), Locals: r.count(), Constants: bc.Constants}
+				if fn.Locals > maxLetBindings || fn.Params > fn.Locals {
+					return nil, fmt.Errorf("bundle: rule %q: function %s uses %d slots for %d parameters (max %d)", name, fn.Name, fn.Locals, fn.Params, maxLetBindings)
+				}
+				fn.Instructions = r.instructions()
+				if len(fn.Instructions) == 0 || fn.Instructions[len(fn.Instructions)-1].Op != NeoOpReturnLocal {
+					return nil, fmt.Errorf("bundle: rule %q: function %s does not end with RETL", name, fn.Name)
+				}
+				bc.Functions[i] = fn
+			}
+		}
+		// 被调函数的下标来自指令参数，越界会在执行时 panic，加载时一并检查；函数只能调用更早定义的函数。
+		// lambda 的捕获值取自当前栈帧的 let 槽位，不能超出本块的槽位数与 lambda 的参数数
+		for i, chunk := range append(bc.Functions, bc) {
+			if err := checkOperands(chunk, len(bc.Constants)); err != nil {
+				return nil, fmt.Errorf("bundle: rule %q: %w", name, err)
+			}
+			for _, inst := range chunk.Instructions {
+				if inst.Op == NeoOpCallLocal && (inst.Arg < 0 || int(inst.Arg) >= min(i, nf)) {
+					return nil, fmt.Errorf("bundle: rule %q calls undefined function %d", name, inst.Arg)
+				}
+				if inst.Op == NeoOpMakeClosure {
+					fn, k := int(inst.Arg&0xFFFF), int(inst.Arg>>16)
+					if inst.Arg < 0 || fn >= min(i, nf) || k > chunk.Locals || k > bc.Functions[fn].Params {
+						return nil, fmt.Errorf("bundle: rule %q creates invalid lambda %d", name, fn)
+					}
+				}
+			}
+		}
+		if len(bc.Instructions) == 2 && bc.Instructions[0].Op == NeoOpPush && int(bc.Instructions[0].Arg) < len(bc.Constants) {
+			rules[name] = &Engine{constantResult: bc.Constants[bc.Instructions[0].Arg].ToInterface(), isConstant: true}
+			continue
+		}
+		rules[name] = &Engine{neoBytecode: bc}
+	}
+	if r.off != len(r.data) {
+		return nil, bundleError("trailing data")
+	}
+	return rules, nil
+}
+
+// checkOperands 检查块中每条指令的操作数：NeoVM 不做边界检查，直接按下标读取常量池与指令，
+// 常量下标、跳转目标与 let 槽位越界会读到块外的内存，加载时须全部拒绝。跳转目标可以等于指令数，即结束执行
+func checkOperands(chunk *NeoBytecode, nConsts int) error {
+	n := int32(len(chunk.Instructions))
+	constant := func(i int32) bool { return i >= 0 && int(i) < nConsts }
+	jump := func(target int32) bool { return target >= 0 && target <= n }
+	for pc, inst := range chunk.Instructions {
+		hi, lo := inst.Arg>>16, inst.Arg&0xFFFF
+		ok := inst.Arg >= 0
+		switch inst.Op {
+		case NeoOpPush, NeoOpCopyConst, NeoOpGetGlobal, NeoOpSetGlobal, NeoOpEqualConst, NeoOpEqualC, NeoOpNotEqualC,
+			NeoOpGreaterC, NeoOpLessC, NeoOpAddC, NeoOpSubC, NeoOpMulC, NeoOpDivC, NeoOpMapGetConst, NeoOpInRange,
+			NeoOpScore, NeoOpDefined:
+			ok = constant(inst.Arg)
+		case NeoOpEqualGlobalConst, NeoOpNotEqualGlobalConst, NeoOpAddGlobal, NeoOpAddGC, NeoOpAddConstGlobal,
+			NeoOpSubGC, NeoOpMulGC, NeoOpDivGC, NeoOpSubCG, NeoOpMulCG, NeoOpDivCG, NeoOpGreaterGlobalConst,
+			NeoOpLessGlobalConst, NeoOpAddGlobalGlobal, NeoOpSubGlobalGlobal, NeoOpMulGlobalGlobal,
+			NeoOpConcatGC, NeoOpConcatCG:
+			ok = ok && constant(hi) && constant(lo)
+		case NeoOpCall, NeoOpCallMethod:
+			ok = ok && constant(lo)
+		case NeoOpFusedCompareGlobalConstJumpIfFalse, NeoOpFusedGreaterGlobalConstJumpIfFalse, NeoOpFusedLessGlobalConstJumpIfFalse:
+			ok = constant(inst.Arg>>22&0x3FF) && constant(inst.Arg>>12&0x3FF) && jump(inst.Arg&0xFFF)
+		case NeoOpGetGlobalJumpIfFalse, NeoOpGetGlobalJumpIfTrue:
+			ok = ok && constant(hi) && jump(lo)
+		case NeoOpJump, NeoOpJumpIfFalse, NeoOpJumpIfTrue, NeoOpJumpIfNotMap, NeoOpJumpIfFalseOrPop,
+			NeoOpJumpIfTrueOrPop, NeoOpIterNext, NeoOpTry, NeoOpEndTry:
+			ok = jump(inst.Arg)
+		case NeoOpGetLocal, NeoOpSetLocal:
+			ok = ok && int(inst.Arg) < chunk.Locals
+		default:
+			ok = inst.Op <= NeoOpEqualFold
+		}
+		if !ok {
+			return fmt.Errorf("invalid operand %d of %s at %d", inst.Arg, inst.Op, pc)
+		}
+	}
+	return nil
+}
+
+type bundleError string
+
+func (e bundleError) Error() string { return "bundle: malformed data: " + string(e) }
+
+func putUvarint(buf *bytes.Buffer, v uint64) { buf.Write(binary.AppendUvarint(nil, v)) }
+func putVarint(buf *bytes.Buffer, v int64)   { buf.Write(binary.AppendVarint(nil, v)) }
+
+func putInstructions(buf *bytes.Buffer, insts []neoInstruction) {
+	putUvarint(buf, uint64(len(insts)))
+	for _, inst := range insts {
+		buf.WriteByte(byte(inst.Op))
+		putVarint(buf, int64(inst.Arg))
+	}
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	putUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func putValue(buf *bytes.Buffer, v Value) error {
+	buf.WriteByte(byte(v.Type))
+	switch v.Type {
+	case ValNil:
+	case ValInt, ValFloat, ValBool:
+		putUvarint(buf, v.Num)
+	case ValString:
+		putString(buf, v.Str)
+	case ValTime:
+		b, err := v.Obj.(time.Time).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		putString(buf, string(b))
+	case ValDuration:
+		putUvarint(buf, v.Num)
+	case ValDecimal:
+		putString(buf, v.Obj.(Decimal).String())
+	case ValBytes:
+		putString(buf, string(v.Obj.([]byte)))
+	case ValSet:
+		set := v.Obj.(map[string]struct{})
+		keys := make([]string, 0, len(set))
+		for k := range set {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		putUvarint(buf, uint64(len(keys)))
+		for _, k := range keys {
+			putString(buf, k)
+		}
+	case ValArray:
+		arr := v.Obj.([]any)
+		putUvarint(buf, uint64(len(arr)))
+		for _, el := range arr {
+			if err := putValue(buf, FromInterface(el)); err != nil {
+				return err
+			}
+		}
+	case ValMap:
+		m := v.Obj.(map[string]any)
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		putUvarint(buf, uint64(len(keys)))
+		for _, k := range keys {
+			putString(buf, k)
+			if err := putValue(buf, FromInterface(m[k])); err != nil {
+				return err
+			}
+		}
+	case ValObject:
+		// 编译器只会把区间与预处理的实参放入常量池
+		switch obj := v.Obj.(type) {
+		case Range:
+			buf.WriteByte(0)
+			putVarint(buf, obj.Start)
+			putVarint(buf, obj.End)
+		case preparedArg:
+			name, index, src := obj.preparedFrom()
+			buf.WriteByte(1)
+			putString(buf, name)
+			putUvarint(buf, uint64(index))
+			return putValue(buf, FromInterface(src))
+		default:
+			return fmt.Errorf("cannot serialize constant of type %T", v.Obj)
+		}
+	default:
+		return fmt.Errorf("cannot serialize constant of type %s", v.Type)
+	}
+	return nil
+}
+
+type bundleReader struct {
+	data []byte
+	off  int
+}
+
+func (r *bundleReader) bytes(n int) []byte {
+	if n < 0 || n > len(r.data)-r.off {
+		panic(bundleError("unexpected end of data"))
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *bundleReader) instructions() []neoInstruction {
+	insts := make([]neoInstruction, r.count())
+	for i := range insts {
+		op := NeoOpCode(r.byte())
+		arg := r.varint()
+		if arg < math.MinInt32 || arg > math.MaxInt32 {
+			panic(bundleError("instruction argument out of range"))
+		}
+		insts[i] = neoInstruction{Op: op, Arg: int32(arg)}
+	}
+	return insts
+}
+
+func (r *bundleReader) byte() byte { return r.bytes(1)[0] }
+
+func (r *bundleReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.off:])
+	if n <= 0 {
+		panic(bundleError("bad varint"))
+	}
+	r.off += n
+	return v
+}
+
+func (r *bundleReader) varint() int64 {
+	v, n := binary.Varint(r.data[r.off:])
+	if n <= 0 {
+		panic(bundleError("bad varint"))
+	}
+	r.off += n
+	return v
+}
+
+// count 读取长度字段；长度不可能超过剩余字节数，据此拒绝伪造的超大长度
+func (r *bundleReader) count() int {
+	v := r.uvarint()
+	if v > uint64(len(r.data)-r.off) {
+		panic(bundleError("length exceeds data"))
+	}
+	return int(v)
+}
+
+func (r *bundleReader) string() string { return string(r.bytes(r.count())) }
+
+func (r *bundleReader) value() any {
+	switch t := ValueType(r.byte()); t {
+	case ValNil:
+		return nil
+	case ValInt, ValFloat, ValBool:
+		return Value{Type: t, Num: r.uvarint()}.ToInterface()
+	case ValString:
+		return r.string()
+	case ValTime:
+		var t time.Time
+		if err := t.UnmarshalBinary(r.bytes(r.count())); err != nil {
+			panic(bundleError("invalid time: " + err.Error()))
+		}
+		return t
+	case ValDuration:
+		return time.Duration(r.uvarint())
+	case ValDecimal:
+		s := r.string()
+		d, err := ParseDecimal(s)
+		if err != nil {
+			panic(bundleError("invalid decimal " + s))
+		}
+		return d
+	case ValBytes:
+		return []byte(r.string())
+	case ValSet:
+		n := r.count()
+		set := make(map[string]struct{}, n)
+		for range n {
+			set[r.string()] = struct{}{}
+		}
+		return set
+	case ValArray:
+		arr := make([]any, r.count())
+		for i := range arr {
+			arr[i] = r.value()
+		}
+		return arr
+	case ValMap:
+		n := r.count()
+		m := make(map[string]any, n)
+		for range n {
+			k := r.string()
+			m[k] = r.value()
+		}
+		return m
+	case ValObject:
+		switch kind := r.byte(); kind {
+		case 0:
+			return Range{Start: r.varint(), End: r.varint()}
+		case 1:
+			name, index := r.string(), int(r.uvarint())
+			p, ok := prepareArg(name, index, r.value())
+			if !ok {
+				panic(bundleError(fmt.Sprintf("invalid prepared argument %d of %s", index, name)))
+			}
+			return p
+		default:
+			panic(bundleError(fmt.Sprintf("unknown object kind %d", kind)))
+		}
+	default:
+		panic(bundleError(fmt.Sprintf("unknown value type %d", byte(t))))
+	}
+}
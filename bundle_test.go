@@ -0,0 +1,142 @@
+package uwasa
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSignedBundle(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]string{
+		"discount": `if vip is price * 0.8 else is price`,
+		"label":    `concat("sku-", id, "-", ["a", 1][1])`,
+		"lookup":   `k in {"x": 1, "y": [2.5, nil, true]}`,
+		"constant": `1 << 4 | 1`,
+		"let":      `let p = price * 2 => if vip is p - 1 else is p`,
+		"fn":       `fn off(p, r) => p - p * r; fn vipOff(p) => off(p, 0.2); if vip is vipOff(price) else is off(price, 0.05)`,
+		"lambda":   `let p = price / 10 => filter([price, id, 3], x -> x > p) == [price]`,
+		"range":    `if price in 1..100 && id in -1..id is -2..2 else is 0`,
+		"geo":      `inPolygon(id, price, [[0, 90], [0, 110], [10, 110], [10, 90]]) && geoDistance(0, 0, id, 0) > 700000`,
+		"labels":   `matchLabels({"metadata": {"labels": {"k": k}}}, "k in (x, y), !legacy")`,
+		"time":     `if k == "y" is @2024-01-02 - @2024-01-01 else is @2024-01-01T09:00:00+09:00`,
+		"glob":     `k like "?" && concat("img_", k, ".png") like "img_*.png"`,
+		"set":      `k in set("x", "y", "z") && len(set("a", "b") | set("b")) == 2`,
+		"decimal":  `decimal("19.99") * id + decimal("0.01") == decimal("139.94")`,
+	}
+	rules := make(map[string]*Engine, len(sources))
+	for name, src := range sources {
+		e, err := NewEngineVMNeo(src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		rules[name] = e
+	}
+	payload, err := MarshalBundle(rules)
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	if again, _ := MarshalBundle(rules); string(again) != string(payload) {
+		t.Errorf("bundle encoding should be deterministic")
+	}
+	env := SignBundle(payload, priv)
+
+	loaded, err := OpenSignedBundle(env, pub)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	vars := func() map[string]any {
+		return map[string]any{"vip": true, "price": int64(100), "id": int64(7), "k": "y"}
+	}
+	for name, e := range rules {
+		want, werr := e.Execute(vars())
+		got, gerr := loaded[name].Execute(vars())
+		if got != want || (werr == nil) != (gerr == nil) {
+			t.Errorf("%s: loaded rule returned %v (%v), want %v (%v)", name, got, gerr, want, werr)
+		}
+	}
+	// 预处理的多边形在加载时重新解析
+	if !slices.ContainsFunc(loaded["geo"].neoBytecode.Constants, func(v Value) bool { _, ok := v.Obj.(*geoPolygon); return ok }) {
+		t.Errorf("expected the loaded geo rule to keep its prepared polygon")
+	}
+	// 字节码包不含规则源码，无法拆分聚合调用
+	if _, err := loaded["discount"].NewAggregator().Result(); err == nil {
+		t.Errorf("expected aggregator error for a rule loaded from a bundle")
+	}
+
+	tampered := append([]byte(nil), env...)
+	tampered[len(tampered)-3] ^= 1
+	if _, err := OpenSignedBundle(tampered, pub); !errors.Is(err, ErrBundleSignature) {
+		t.Errorf("expected signature error for tampered bundle, got %v", err)
+	}
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if _, err := OpenSignedBundle(env, otherPub); !errors.Is(err, ErrBundleSignature) {
+		t.Errorf("expected signature error for wrong key, got %v", err)
+	}
+	// 签名有效但内容被截断
+	if _, err := OpenSignedBundle(SignBundle(payload[:len(payload)-1], priv), pub); err == nil {
+		t.Errorf("expected format error for truncated bundle")
+	}
+
+	ast, _ := NewEngine(`a + 1`)
+	if _, err := MarshalBundle(map[string]*Engine{"ast": ast}); err == nil {
+		t.Errorf("expected error for a non-NeoVM engine")
+	}
+}
+
+func TestCorruptedBundle(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := func(insts []neoInstruction, locals int) error {
+		bc := &NeoBytecode{
+			Instructions: append(insts, neoInstruction{Op: NeoOpReturn}),
+			Constants:    []Value{{Type: ValString, Str: "a"}, {Type: ValInt, Num: 1}},
+			Locals:       locals,
+		}
+		payload, err := MarshalBundle(map[string]*Engine{"r": {neoBytecode: bc}})
+		if err != nil {
+			t.Fatalf("marshal error: %v", err)
+		}
+		_, err = OpenSignedBundle(SignBundle(payload, priv), pub)
+		return err
+	}
+
+	if err := open([]neoInstruction{{Op: NeoOpGetGlobalJumpIfFalse, Arg: 0<<16 | 2}, {Op: NeoOpAddC, Arg: 1}, {Op: NeoOpJump, Arg: 3}, {Op: NeoOpGetLocal, Arg: 0}}, 1); err != nil {
+		t.Fatalf("expected a well-formed bundle to load, got %v", err)
+	}
+	// 签名有效但操作数越界的包在加载时被拒绝，而不是在执行时越界读取
+	for name, insts := range map[string][]neoInstruction{
+		"constant":        {{Op: NeoOpPush, Arg: 2}},
+		"negative":        {{Op: NeoOpGetGlobal, Arg: -1}},
+		"packed constant": {{Op: NeoOpEqualGlobalConst, Arg: 0<<16 | 9}},
+		"packed global":   {{Op: NeoOpAddGlobalGlobal, Arg: 7<<16 | 0}},
+		"call name":       {{Op: NeoOpCall, Arg: 1<<16 | 5}},
+		"fused constant":  {{Op: NeoOpFusedCompareGlobalConstJumpIfFalse, Arg: 0<<22 | 3<<12 | 1}},
+		"fused jump":      {{Op: NeoOpFusedLessGlobalConstJumpIfFalse, Arg: 0<<22 | 1<<12 | 9}},
+		"jump":            {{Op: NeoOpJump, Arg: 3}},
+		"backward jump":   {{Op: NeoOpJumpIfFalse, Arg: -2}},
+		"try":             {{Op: NeoOpTry, Arg: 100}},
+		"global jump":     {{Op: NeoOpGetGlobalJumpIfTrue, Arg: 0<<16 | 40}},
+		"local":           {{Op: NeoOpGetLocal, Arg: 1}},
+		"opcode":          {{Op: NeoOpEqualFold + 1}},
+	} {
+		if err := open(insts, 1); err == nil || !strings.Contains(err.Error(), "invalid operand") {
+			t.Errorf("%s: expected an invalid operand error, got %v", name, err)
+		}
+	}
+
+	// 函数块与主程序共用常量池，同样检查
+	e, _ := NewEngineVMNeo(`fn f(x) => x + 1; f(a)`)
+	e.neoBytecode.Functions[0].Instructions[0] = neoInstruction{Op: NeoOpGetLocal, Arg: 4}
+	payload, _ := MarshalBundle(map[string]*Engine{"fn": e})
+	if _, err := OpenSignedBundle(SignBundle(payload, priv), pub); err == nil {
+		t.Errorf("expected an error for a function reading past its slots")
+	}
+}
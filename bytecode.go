@@ -6,6 +6,10 @@ package uwasa
 import (
 	"fmt"
 	"math"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type OpCode byte
@@ -43,8 +47,57 @@ const (
 	OpGetGlobalJumpIfFalse
 	OpGetGlobalJumpIfTrue
 	OpConcat
+	OpInSetGlobal
+	OpJumpTableGlobal
+	OpToBool
+	OpMakeArray
+	OpIndex
+	OpSetIndex
+	OpMakeMap
+	OpCopyConst
+	OpCallMethod
+	OpIn
+	OpBitAnd // 位运算指令的顺序与 TokenBitAnd 至 TokenShr 一致
+	OpBitOr
+	OpBitXor
+	OpShl
+	OpShr
+	OpGetLocal // 读取 let 绑定所在的栈底槽位
+	OpSetLocal // 弹出栈顶写入 let 绑定的栈底槽位
+	OpCallLocal // 调用 Functions[Arg]，栈顶的实参成为被调函数栈帧的最低槽位
+	OpReturnLocal // 函数块结束：弹出返回值、丢弃栈帧并回到调用处
+	OpMakeClosure // 以 Functions[Arg&0xFFFF] 构造闭包，捕获当前栈帧最低的 Arg>>16 个槽位
+	OpJumpIfNotMap // 栈顶不是映射时将其替换为 nil 并跳转到 Arg，用于可选链 `a?.b`
+	OpMapGetConst // 以常量 Arg 为键读取栈顶映射的成员，缺失时为 nil
+	OpMakeRange // 以栈顶两个整数为两端构造区间 `a..b`
+	OpInRange // 栈顶是否落在常量区间 Arg 内，用于 `x in 1..10`
+	OpSpread // 弹出数组并把其元素追加到下方 MKARR 新建的数组，用于 `[...a]`
+	OpCast // 把栈顶转换为 castKind(Arg) 类型，用于单参数的 int、float、str、bool
+	OpScore // 弹出条件，为真时把常量 Arg 加到下方的累加值上，用于 `score { ... }`
+	OpJumpIfFalseOrPop // 栈顶为假时保留并跳转到 Arg，否则弹出，用于返回操作数的 `&&`
+	OpJumpIfTrueOrPop // 栈顶为真时保留并跳转到 Arg，否则弹出，用于返回操作数的 `||`
+	OpDefined // 压入上下文中是否存在名为常量 Arg 的变量（值为 nil 也算存在），用于 `defined(x)`
+	OpTry // 登记错误处理现场：此后出错时恢复栈顶并跳转到 Arg 处求备用值，用于 `try(expr, fallback)`
+	OpEndTry // 撤销最近一次 TRY 的登记并跳转到 Arg，跳过备用值
+	OpSlice // 按打包在 Arg 中的常量边界截取栈顶的数组或字符串，用于 `slice(x, start, end)`
+	OpIter // 检查栈顶为数组并在其上压入下标 0，开始内联的 map、filter、reduce 循环，Arg 为 iterKind
+	OpIterNext // 栈顶依次为累加值、下标与数组：下标未越界时压入当前元素并把下标加一，否则跳转到 Arg
+	OpAppend // 弹出栈顶并追加到其下循环新建的数组
+	OpIterEnd // 丢弃累加值之下的下标与数组，结束循环
+	OpEqualFold // 弹出两个操作数，字符串之间不区分大小写比较，其余同 EQ，用于 `a ==* b`
 )
 
+// maxLetBindings 限制同时可见的 let 绑定数量；各 VM 在栈底或低位寄存器中为其预留槽位
+const maxLetBindings = 16
+
+// maxFunctions 限制一条规则中 fn 定义的数量。函数只能调用先于自己定义的函数，
+// 因此调用深度不超过该值，各 VM 以定长数组保存调用帧
+const maxFunctions = 16
+
+// maxLambdas 限制一条规则中 lambda 的数量。lambda 编译为 Functions 中的匿名块，
+// 与规则内函数共用下标空间
+const maxLambdas = 64
+
 func (o OpCode) String() string {
 	switch o {
 	case OpPush: return "PUSH"
@@ -78,6 +131,44 @@ func (o OpCode) String() string {
 	case OpGetGlobalJumpIfFalse: return "GG JIF"
 	case OpGetGlobalJumpIfTrue: return "GG JIT"
 	case OpConcat: return "CONCAT"
+	case OpInSetGlobal: return "INSG"
+	case OpJumpTableGlobal: return "JTG"
+	case OpToBool: return "TOBOOL"
+	case OpMakeArray: return "MKARR"
+	case OpIndex: return "INDEX"
+	case OpSetIndex: return "SETIDX"
+	case OpMakeMap: return "MKMAP"
+	case OpCopyConst: return "COPYC"
+	case OpCallMethod: return "CALLM"
+	case OpIn: return "IN"
+	case OpBitAnd: return "BAND"
+	case OpBitOr: return "BOR"
+	case OpBitXor: return "BXOR"
+	case OpShl: return "SHL"
+	case OpShr: return "SHR"
+	case OpGetLocal: return "GETL"
+	case OpSetLocal: return "SETL"
+	case OpCallLocal: return "CALLL"
+	case OpReturnLocal: return "RETL"
+	case OpMakeClosure: return "MKCLOS"
+	case OpJumpIfNotMap: return "JNMAP"
+	case OpMapGetConst: return "MGETC"
+	case OpMakeRange: return "MKRANGE"
+	case OpInRange: return "INRANGE"
+	case OpSpread: return "SPREAD"
+	case OpCast: return "CAST"
+	case OpScore: return "SCORE"
+	case OpJumpIfFalseOrPop: return "JIFOP"
+	case OpJumpIfTrueOrPop: return "JITOP"
+	case OpDefined: return "DEFINED"
+	case OpTry: return "TRY"
+	case OpEndTry: return "ENDTRY"
+	case OpSlice: return "SLICE"
+	case OpIter: return "ITER"
+	case OpIterNext: return "ITERNEXT"
+	case OpAppend: return "APPEND"
+	case OpIterEnd: return "ITEREND"
+	case OpEqualFold: return "EQFOLD"
 	default: return fmt.Sprintf("UNKNOWN(%d)", o)
 	}
 }
@@ -90,12 +181,22 @@ const (
 	ValFloat
 	ValBool
 	ValString
+	ValArray
+	ValMap
+	ValFunc
+	ValObject   // 宿主传入的其他 Go 值，如 uuid.UUID
+	ValTime     // time.Time，放在 Obj 中；时间字面量与宿主传入的 time.Time 均为此类型
+	ValDuration // time.Duration，Num 为纳秒数；两个时间相减得到
+	ValSet      // 字符串集合，Obj 为 map[string]struct{}；由 set(...) 创建，也可以由宿主传入
+	ValDecimal  // 任意精度的十进制数，Obj 为 Decimal；由 1.23d 字面量或 decimal(x) 创建，也可以由宿主传入
+	ValBytes    // 字节串，Obj 为 []byte，与宿主共享；由宿主传入或由 bytes、unhex、unbase64 创建
 )
 
 type Value struct {
 	Type ValueType
 	Num  uint64
 	Str  string
+	Obj  any // ValArray 时为 []any，ValMap 时为 map[string]any，均与宿主共享（[]string 等切片则为复制出的 []any）；ValFunc 时为 *Closure；ValTime 时为 time.Time；ValSet 时为 map[string]struct{}；ValDecimal 时为 Decimal；ValBytes 时为 []byte；ValObject 时为原值
 }
 
 func (v Value) ToInterface() any {
@@ -108,6 +209,10 @@ func (v Value) ToInterface() any {
 		return v.Num != 0
 	case ValString:
 		return v.Str
+	case ValArray, ValMap, ValFunc, ValObject, ValTime, ValSet, ValDecimal, ValBytes:
+		return v.Obj
+	case ValDuration:
+		return time.Duration(v.Num)
 	default:
 		return nil
 	}
@@ -128,8 +233,31 @@ func FromInterface(v any) Value {
 		return Value{Type: ValBool, Num: 0}
 	case string:
 		return Value{Type: ValString, Str: val}
-	default:
+	case []any:
+		return Value{Type: ValArray, Obj: val}
+	case map[string]any:
+		return Value{Type: ValMap, Obj: val}
+	case []string, []int64, []int, []float64, []bool, []map[string]any:
+		return Value{Type: ValArray, Obj: hostSlice(val)}
+	case *Closure:
+		return Value{Type: ValFunc, Obj: val}
+	case time.Time:
+		return Value{Type: ValTime, Obj: val}
+	case time.Duration:
+		return Value{Type: ValDuration, Num: uint64(val)}
+	case map[string]struct{}:
+		return Value{Type: ValSet, Obj: val}
+	case Decimal:
+		return Value{Type: ValDecimal, Obj: val}
+	case []byte:
+		return Value{Type: ValBytes, Obj: val}
+	case nil:
 		return Value{Type: ValNil}
+	default:
+		if fn, ok := adapters.get(reflect.TypeOf(v)); ok {
+			return fn(v)
+		}
+		return Value{Type: ValObject, Obj: val}
 	}
 }
 
@@ -139,6 +267,450 @@ type vmInstruction struct {
 }
 
 type RenderedBytecode struct {
-	Instructions []vmInstruction
-	Constants    []Value
+	Instructions   []vmInstruction
+	Constants      []Value
+	Sets           []*ValueSet
+	JumpTables     []*JumpTable
+	ResultCount    int // >1 表示程序为多值元组，结果以 []any 返回
+	MaxConcatBytes int // 单次执行拼接输出的累计字节上限，0 表示不限制
+	Locals         int // let 绑定占用的栈底槽位数，操作数栈从其上方开始
+	// Functions 为规则内 fn 定义编译出的独立字节码块，下标即 CallLocal 的操作数。
+	// 函数块的 Name 为函数名，Params 个实参占据其栈帧最低的槽位，与 let 绑定一样以 GETL 读取
+	Functions []*RenderedBytecode
+	Name      string
+	Params    int
+	// concat 仅由闭包入口块设置，使 lambda 调用沿用创建它的那次执行的拼接计数
+	concat *concatBudget
+}
+
+// JumpTable 是稠密 else-if 整数分支的跳转表。Targets[i] 对应键 Min+i，
+// 空洞与非整数值均跳转到 Default。
+type JumpTable struct {
+	Min     int64
+	Targets []int32
+	Default int32
+}
+
+func (t *JumpTable) Lookup(v Value) int32 {
+	var key int64
+	switch v.Type {
+	case ValInt:
+		key = int64(v.Num)
+	case ValFloat:
+		f := math.Float64frombits(v.Num)
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return t.Default
+		}
+		key = int64(f)
+	case ValDecimal:
+		k := decimalKey(v.Obj.(Decimal))
+		if k.Type != ValInt {
+			return t.Default
+		}
+		key = int64(k.Num)
+	default:
+		return t.Default
+	}
+	off := uint64(key - t.Min)
+	if off >= uint64(len(t.Targets)) {
+		return t.Default
+	}
+	return t.Targets[off]
+}
+
+// ValueSet 是编译期构建的常量集合，用于将 `x == c1 || x == c2 || ...` 链
+// 折叠为一次哈希查找。数值键会被归一化，保证 1 与 1.0 命中同一项。
+type ValueSet struct {
+	items map[Value]struct{}
+}
+
+func NewValueSet(vals []Value) *ValueSet {
+	s := &ValueSet{items: make(map[Value]struct{}, len(vals))}
+	for _, v := range vals {
+		if k, ok := setKey(v); ok {
+			s.items[k] = struct{}{}
+		}
+	}
+	return s
+}
+
+func (s *ValueSet) Contains(v Value) bool {
+	k, ok := setKey(v)
+	if !ok {
+		return false
+	}
+	_, found := s.items[k]
+	return found
+}
+
+func (s *ValueSet) Len() int {
+	return len(s.items)
+}
+
+func setKey(v Value) (Value, bool) {
+	if v.Type == ValArray || v.Type == ValMap {
+		return Value{}, false
+	}
+	if v.Type == ValBytes {
+		// []byte 不能作为 map 键；Equal 中字节串只与字节串相等，以内容区分即可
+		return Value{Type: ValBytes, Str: string(v.Obj.([]byte))}, true
+	}
+	if v.Type == ValFloat {
+		f := math.Float64frombits(v.Num)
+		if f != f {
+			return Value{}, false
+		}
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return Value{Type: ValInt, Num: uint64(int64(f))}, true
+		}
+	}
+	if v.Type == ValDecimal {
+		return decimalKey(v.Obj.(Decimal)), true
+	}
+	return v, true
+}
+
+// seqIndex 将长为 n 的数组或字符串的下标归一化为 int；只接受整数或整值浮点数。
+// 负下标从末尾数起，-1 为最后一个元素；归一化后越界返回错误
+func seqIndex(kind string, n int, idx any) (int, error) {
+	var i int64
+	switch v := idx.(type) {
+	case int64:
+		i = v
+	case int:
+		i = int64(v)
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("%s index must be an integer, got %g", kind, v)
+		}
+		i = int64(v)
+	default:
+		return 0, fmt.Errorf("%s index must be an integer, got %T", kind, idx)
+	}
+	j := i
+	if j < 0 {
+		j += int64(n)
+	}
+	if j < 0 || j >= int64(n) {
+		return 0, fmt.Errorf("%s index %d out of range (len %d)", kind, i, n)
+	}
+	return int(j), nil
+}
+
+// stringIndex 返回字符串 s 中下标为 idx 的字符。与 len 一致，下标按字符而不是字节计
+func stringIndex(s string, idx any) (string, error) {
+	i, err := seqIndex("string", utf8.RuneCountInString(s), idx)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range s {
+		if i == 0 {
+			return string(r), nil
+		}
+		i--
+	}
+	return "", nil
+}
+
+// mapKey 校验 map 的键，只接受字符串
+func mapKey(key any) (string, error) {
+	k, ok := key.(string)
+	if !ok {
+		return "", fmt.Errorf("map key must be a string, got %T", key)
+	}
+	return k, nil
+}
+
+// IndexAny 返回 coll[idx]，coll 必须为 []any、string 或 map[string]any；map 中缺失的键返回 nil。
+// 数组与字符串的负下标从末尾数起，字符串的下标按字符计，结果为单个字符的字符串
+func IndexAny(coll, idx any) (any, error) {
+	switch c := coll.(type) {
+	case []any:
+		i, err := seqIndex("array", len(c), idx)
+		if err != nil {
+			return nil, err
+		}
+		return c[i], nil
+	case string:
+		return stringIndex(c, idx)
+	case map[string]any:
+		k, err := mapKey(idx)
+		if err != nil {
+			return nil, err
+		}
+		return c[k], nil
+	}
+	return nil, fmt.Errorf("index operator not supported on %T", coll)
+}
+
+// SetIndexAny 原地执行 coll[idx] = val，修改对共享该容器的宿主可见
+func SetIndexAny(coll, idx, val any) error {
+	switch c := coll.(type) {
+	case []any:
+		i, err := seqIndex("array", len(c), idx)
+		if err != nil {
+			return err
+		}
+		c[i] = val
+		return nil
+	case map[string]any:
+		k, err := mapKey(idx)
+		if err != nil {
+			return err
+		}
+		c[k] = val
+		return nil
+	}
+	return fmt.Errorf("index assignment not supported on %T", coll)
+}
+
+func (v Value) Index(idx Value) (Value, error) {
+	res, err := IndexAny(v.ToInterface(), idx.ToInterface())
+	if err != nil {
+		return Value{}, err
+	}
+	return FromInterface(res), nil
+}
+
+func (v Value) SetIndex(idx, val Value) error {
+	return SetIndexAny(v.ToInterface(), idx.ToInterface(), val.ToInterface())
+}
+
+// makeArray 将栈或寄存器上的连续值收集为新数组
+func makeArray(vals []Value) Value {
+	arr := make([]any, len(vals))
+	for i, v := range vals {
+		arr[i] = v.ToInterface()
+	}
+	return Value{Type: ValArray, Obj: arr}
+}
+
+// concatArrays 返回 l 与 r 依次拼接而成的新数组，不修改两者
+func concatArrays(l, r []any) []any {
+	out := make([]any, 0, len(l)+len(r))
+	return append(append(out, l...), r...)
+}
+
+// spreadInto 实现 SPREAD：把数组 v 的元素追加到 arr 末尾。arr 是同一数组字面量中 MKARR 新建的数组，
+// 不与其他值共享，可以原地追加
+func spreadInto(arr, v Value) (Value, error) {
+	items, ok := v.Obj.([]any)
+	if v.Type != ValArray || !ok {
+		return Value{}, fmt.Errorf("spread expects an array, got %T", v.ToInterface())
+	}
+	return Value{Type: ValArray, Obj: append(arr.Obj.([]any), items...)}, nil
+}
+
+// hostSlice 把宿主传入的 []string、[]int64、[]int、[]float64、[]bool、[]map[string]any 复制为 []any，
+// 规则中的下标、in、len、相等比较等数组操作因此同样作用于它们，不必经由反射；[]int 的元素转换为 int64。
+// 其他类型返回 nil
+func hostSlice(v any) []any {
+	switch s := v.(type) {
+	case []string:
+		return toAnySlice(s, func(e string) any { return e })
+	case []int64:
+		return toAnySlice(s, func(e int64) any { return e })
+	case []int:
+		return toAnySlice(s, func(e int) any { return int64(e) })
+	case []float64:
+		return toAnySlice(s, func(e float64) any { return e })
+	case []bool:
+		return toAnySlice(s, func(e bool) any { return e })
+	case []map[string]any:
+		return toAnySlice(s, func(e map[string]any) any { return e })
+	}
+	return nil
+}
+
+// toAnySlice 以 conv 逐一转换 s 的元素
+func toAnySlice[T any](s []T, conv func(T) any) []any {
+	arr := make([]any, len(s))
+	for i, e := range s {
+		arr[i] = conv(e)
+	}
+	return arr
+}
+
+// arrayEqual 按元素逐一比较，元素相等性与 EqualAny 一致
+func arrayEqual(l, r []any) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	for i := range l {
+		if !EqualAny(l[i], r[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// InAny 实现 `needle in haystack`：数组按 EqualAny 查找元素，映射检查键是否存在，集合检查元素是否存在，
+// 字符串检查子串，区间比较两端。映射、集合与字符串要求 needle 为字符串，区间要求 needle 为数字，否则返回错误。
+func InAny(needle, haystack any) (bool, error) {
+	switch h := haystack.(type) {
+	case []any:
+		for _, el := range h {
+			if EqualAny(needle, el) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]any:
+		k, err := mapKey(needle)
+		if err != nil {
+			return false, err
+		}
+		_, found := h[k]
+		return found, nil
+	case map[string]struct{}:
+		k, err := mapKey(needle)
+		if err != nil {
+			return false, err
+		}
+		_, found := h[k]
+		return found, nil
+	case string:
+		s, ok := needle.(string)
+		if !ok {
+			return false, fmt.Errorf("substring must be a string, got %T", needle)
+		}
+		return strings.Contains(h, s), nil
+	case Range:
+		return h.contains(FromInterface(needle))
+	}
+	return false, fmt.Errorf("'in' not supported on %T", haystack)
+}
+
+func (v Value) In(coll Value) (Value, error) {
+	found, err := InAny(v.ToInterface(), coll.ToInterface())
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Type: ValBool, Num: boolToUint64(found)}, nil
+}
+
+// bitwiseOps 将中缀运算符映射到位运算的词法记号，供 AST 求值与常量折叠使用
+var bitwiseOps = map[string]TokenType{"&": TokenBitAnd, "|": TokenBitOr, "^": TokenBitXor, "<<": TokenShl, ">>": TokenShr}
+
+// Bitwise 对两个整数执行位运算 op（TokenBitAnd、TokenShl 等）。右移为算术右移；
+// 移位数为负时报错，不小于 64 时按 Go 的移位语义得到 0 或 -1。两个集合的 | & ^ 为并集、交集与对称差。
+func (v Value) Bitwise(op TokenType, r Value) (Value, error) {
+	if v.Type == ValSet && r.Type == ValSet {
+		if s, ok := setOp(op, v.Obj.(map[string]struct{}), r.Obj.(map[string]struct{})); ok {
+			return Value{Type: ValSet, Obj: s}, nil
+		}
+	}
+	if v.Type != ValInt || r.Type != ValInt {
+		return Value{}, fmt.Errorf("bitwise operator %s supports only integers, got %s %s %s", op, v.Type, op, r.Type)
+	}
+	a, b := int64(v.Num), int64(r.Num)
+	switch op {
+	case TokenBitAnd: a &= b
+	case TokenBitOr: a |= b
+	case TokenBitXor: a ^= b
+	case TokenShl, TokenShr:
+		if b < 0 { return Value{}, fmt.Errorf("negative shift count %d", b) }
+		if op == TokenShl { a <<= uint64(b) } else { a >>= uint64(b) }
+	default:
+		return Value{}, fmt.Errorf("unknown bitwise operator: %s", op)
+	}
+	return Value{Type: ValInt, Num: uint64(a)}, nil
+}
+
+// CallMethodAny 执行 recv.method(args...)。目前只有 map[string]any 提供方法：
+// get(k[, default])、has(k)、set(k, v)、del(k)；set 与 del 原地修改并返回该 map，便于链式调用
+func CallMethodAny(recv any, method string, args []any) (any, error) {
+	m, ok := recv.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("method %s not supported on %T", method, recv)
+	}
+	switch method {
+	case "get":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("get expects 1 or 2 arguments, got %d", len(args))
+		}
+	case "has", "del":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument, got %d", method, len(args))
+		}
+	case "set":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("set expects 2 arguments, got %d", len(args))
+		}
+	default:
+		return nil, fmt.Errorf("unknown map method: %s", method)
+	}
+	k, err := mapKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	switch method {
+	case "get":
+		if v, found := m[k]; found || len(args) == 1 {
+			return v, nil
+		}
+		return args[1], nil
+	case "has":
+		_, found := m[k]
+		return found, nil
+	case "set":
+		m[k] = args[1]
+	case "del":
+		delete(m, k)
+	}
+	return m, nil
+}
+
+// makeMap 将连续的 键、值、键、值… 收集为新 map，重复的键以后者为准
+func makeMap(vals []Value) (Value, error) {
+	m := make(map[string]any, len(vals)/2)
+	for i := 0; i+1 < len(vals); i += 2 {
+		k, err := mapKey(vals[i].ToInterface())
+		if err != nil {
+			return Value{}, err
+		}
+		m[k] = vals[i+1].ToInterface()
+	}
+	return Value{Type: ValMap, Obj: m}, nil
+}
+
+// mapEqual 要求键集合相同且对应值按 EqualAny 相等
+func mapEqual(l, r map[string]any) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	for k, lv := range l {
+		rv, ok := r[k]
+		if !ok || !EqualAny(lv, rv) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyConst 深拷贝常量池中的容器。折叠后的 map 常量每次执行都得到独立副本，
+// 因此下标赋值或宿主对结果的修改不会污染后续执行。
+func copyConst(v Value) Value {
+	if v.Type == ValMap || v.Type == ValArray {
+		return Value{Type: v.Type, Obj: deepCopyContainer(v.Obj)}
+	}
+	return v
+}
+
+func deepCopyContainer(v any) any {
+	switch c := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(c))
+		for k, el := range c {
+			m[k] = deepCopyContainer(el)
+		}
+		return m
+	case []any:
+		arr := make([]any, len(c))
+		for i, el := range c {
+			arr[i] = deepCopyContainer(el)
+		}
+		return arr
+	}
+	return v
 }
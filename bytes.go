@@ -0,0 +1,95 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// bytesEqual 判断两个字节串是否相等
+func bytesEqual(l, r Value) bool {
+	return bytes.Equal(l.Obj.([]byte), r.Obj.([]byte))
+}
+
+// bytesOf 返回字节串或字符串的字节，供编码函数同时接受两者；字符串按其 UTF-8 字节
+func bytesOf(name string, args []any) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+	}
+	switch v := args[0].(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("%s expects bytes or a string, got %s", name, typeName(args[0]))
+}
+
+// stringArg 返回唯一的字符串实参，供解码函数使用
+func stringArg(name string, args []any) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return "", fmt.Errorf("%s expects a string, got %s", name, typeName(args[0]))
+	}
+	return s, nil
+}
+
+// toBytes 实现内置函数 bytes(x)：字符串复制为字节串，字节串原样返回
+func toBytes(args ...any) (any, error) {
+	return bytesOf("bytes", args)
+}
+
+// hexEncode 实现内置函数 hex(x)：字节串或字符串的小写十六进制文本
+func hexEncode(args ...any) (any, error) {
+	b, err := bytesOf("hex", args)
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hexDecode 实现内置函数 unhex(s)：把十六进制文本解码为字节串，大小写均可
+func hexDecode(args ...any) (any, error) {
+	s, err := stringArg("unhex", args)
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("unhex: %w", err)
+	}
+	return b, nil
+}
+
+// base64Encode 实现内置函数 base64(x)：字节串或字符串的标准 Base64 文本（带填充）
+func base64Encode(args ...any) (any, error) {
+	b, err := bytesOf("base64", args)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// base64Encodings 为 unbase64 依次尝试的编码：标准与 URL 安全的字母表，各自带或不带填充
+var base64Encodings = [...]*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding}
+
+// base64Decode 实现内置函数 unbase64(s)：把 Base64 文本解码为字节串，标准与 URL 安全的字母表、有无填充均可
+func base64Decode(args ...any) (any, error) {
+	s, err := stringArg("unbase64", args)
+	if err != nil {
+		return nil, err
+	}
+	for _, enc := range base64Encodings {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("unbase64: invalid base64 %q", s)
+}
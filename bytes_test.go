@@ -0,0 +1,61 @@
+package uwasa
+
+import "testing"
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`len(body)`, int64(5)},
+		{`typeof(body)`, "bytes"},
+		{`body == bytes("hello")`, true},
+		{`body == same && body != other`, true},
+		{`body == "hello"`, false},
+		{`hex(body)`, "68656c6c6f"},
+		{`base64(body)`, "aGVsbG8="},
+		{`unhex("68656C6C6F") == body`, true},
+		{`unbase64("aGVsbG8") == body && unbase64("-_8") == unhex("fbff")`, true},
+		{`hex(slice(body, 1, 3))`, "656c"},
+		{`len(slice(body, -2, 10))`, int64(2)},
+		{`str(slice(body, 0, 4))`, "hell"},
+		{`concat("body=", body)`, "body=hello"},
+		{`hex("hi")`, "6869"},
+		{`if len(body) > 3 is hex(body) else is ""`, "68656c6c6f"},
+		{`body == "a" || body == "b" || body == "hello"`, false},
+	}
+	vars := func() map[string]any {
+		return map[string]any{"body": []byte("hello"), "same": []byte("hello"), "other": []byte("world")}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				if got, err := engine.ExecuteWithContext(ctx); err != nil || got != tt.expected {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+		for _, bad := range []string{`unhex("xyz")`, `unbase64("%%")`, `hex(1)`, `unhex(body)`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+
+	// 截取结果与宿主的字节串共享底层数组
+	body := []byte("hello")
+	engine, _ := NewEngineVMNeo(`slice(body, 1, 3)`)
+	got, err := engine.Execute(map[string]any{"body": body})
+	if b, ok := got.([]byte); err != nil || !ok || &b[0] != &body[1] {
+		t.Errorf("expected a sub-slice of body, got %v (%v)", got, err)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxCallMemos 限制每个字节码块中暂存调用结果的槽位数
+const maxCallMemos = 8
+
+// callMemoPlan 描述一个字节码块内可复用的调用。produce 中的调用照常求值，并把结果暂存到
+// 对应的暂存位；reuse 中的调用直接读取暂存位，前提是记录的那次 produce 已经编译。
+// 暂存位从 0 开始编号，由各编译器映射到自己的栈槽或寄存器。
+type callMemoPlan struct {
+	produce map[*CallExpression]int
+	reuse   map[*CallExpression]*CallExpression
+	slots   int
+	emitted map[*CallExpression]bool
+}
+
+// reused 返回 call 可直接读取的暂存位。规划为 nil 或对应的 produce 未被编译时返回 false，
+// 调用照常编译
+func (p *callMemoPlan) reused(call *CallExpression) (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+	prev, ok := p.reuse[call]
+	if !ok || !p.emitted[prev] {
+		return 0, false
+	}
+	return p.produce[prev], true
+}
+
+// produced 返回 call 的结果需要写入的暂存位，并记录该 produce 已编译
+func (p *callMemoPlan) produced(call *CallExpression) (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+	slot, ok := p.produce[call]
+	if ok {
+		p.emitted[call] = true
+	}
+	return slot, ok
+}
+
+// memoBarriers 收集整条规则（含规则内函数与 lambda）中被赋值的变量。规则中存在下标赋值、
+// set/del 方法调用或对非纯内置函数的调用时，任何容器参数都可能在两次调用之间被修改，
+// 返回 false 表示不做复用。
+func memoBarriers(program Node) (map[string]bool, bool) {
+	written := make(map[string]bool)
+	ok := true
+	walk(program, func(n Node) {
+		switch n := n.(type) {
+		case *AssignExpression:
+			written[n.Name.Value] = true
+		case *DestructureExpression:
+			for _, name := range n.Names {
+				written[name.Value] = true
+			}
+		case *IndexAssignExpression:
+			ok = false
+		case *MethodCallExpression:
+			if n.Method == "set" || n.Method == "del" {
+				ok = false
+			}
+		case *CallExpression:
+			// 规则内函数不能与内置函数重名，其函数体已在遍历之中
+			if ident, isIdent := n.Function.(*Identifier); isIdent {
+				if _, builtin := builtins.get(ident.Value); builtin && !pureBuiltins.load()[ident.Value] {
+					ok = false
+				}
+			}
+		}
+	})
+	return written, ok
+}
+
+// planCallMemo 为字节码块 body 规划调用复用。参数只能是字面量或从未被赋值、也不是 locals
+// 与 let 绑定的变量，因此同一文本的调用在一次执行中结果不变。
+//
+// 较早的调用只有在必然先于较晚的调用执行时才能被复用：按求值顺序遍历时记录条件区域的路径
+// （&&、|| 的右侧，if 的各分支），前者的路径须是后者路径的前缀。lambda 是独立的字节码块，不参与。
+func planCallMemo(body Node, locals []string, written map[string]bool) *callMemoPlan {
+	p := &memoPlanner{written: written, banned: make(map[string]bool)}
+	for _, name := range locals {
+		p.banned[name] = true
+	}
+	walk(body, func(n Node) {
+		if let, ok := n.(*LetExpression); ok {
+			p.banned[let.Name.Value] = true
+		}
+	})
+	p.counts = make(map[string]int)
+	p.visit(body, func(call *CallExpression, key string) { p.counts[key]++ })
+
+	plan := &callMemoPlan{
+		produce: make(map[*CallExpression]int),
+		reuse:   make(map[*CallExpression]*CallExpression),
+		emitted: make(map[*CallExpression]bool),
+	}
+	// 同一文本的各次 produce 写入同一暂存位，写入的值相同，任一必然先执行的 produce 都可复用
+	type producer struct {
+		call *CallExpression
+		path []int
+	}
+	slots := make(map[string]int)
+	producers := make(map[string][]producer)
+	used := make(map[*CallExpression]bool)
+	p.visit(body, func(call *CallExpression, key string) {
+		if p.counts[key] < 2 {
+			return
+		}
+		for _, prev := range producers[key] {
+			if len(prev.path) <= len(p.path) && slices.Equal(prev.path, p.path[:len(prev.path)]) {
+				plan.reuse[call] = prev.call
+				used[prev.call] = true
+				return
+			}
+		}
+		slot, ok := slots[key]
+		if !ok {
+			if plan.slots >= maxCallMemos {
+				return
+			}
+			slot = plan.slots
+			slots[key] = slot
+			plan.slots++
+		}
+		plan.produce[call] = slot
+		producers[key] = append(producers[key], producer{call: call, path: slices.Clone(p.path)})
+	})
+	for call := range plan.produce {
+		if !used[call] {
+			delete(plan.produce, call)
+		}
+	}
+	if len(plan.reuse) == 0 {
+		return nil
+	}
+	return plan
+}
+
+type memoPlanner struct {
+	written map[string]bool
+	banned  map[string]bool
+	counts  map[string]int
+	// path 为当前所在的条件区域，regions 为已分配的区域编号
+	path    []int
+	regions int
+}
+
+// visit 按求值顺序访问 node 中可复用的调用，key 为调用的文本
+func (p *memoPlanner) visit(node Node, fn func(*CallExpression, string)) {
+	switch n := node.(type) {
+	case *PrefixExpression:
+		p.visit(n.Right, fn)
+	case *InfixExpression:
+		p.visit(n.Left, fn)
+		if n.Operator == "&&" || n.Operator == "||" {
+			p.branch(n.Right, fn)
+		} else {
+			p.visit(n.Right, fn)
+		}
+	case *IfExpression:
+		p.visit(n.Condition, fn)
+		if n.IsSimple {
+			return
+		}
+		p.branch(n.Consequence, fn)
+		p.branch(n.Alternative, fn)
+	case *AssignExpression:
+		p.visit(n.Value, fn)
+	case *LetExpression:
+		p.visit(n.Value, fn)
+		p.visit(n.Body, fn)
+	case *DestructureExpression:
+		p.visit(n.Value, fn)
+		p.visit(n.Body, fn)
+	case *TupleExpression:
+		for _, el := range n.Elements {
+			p.visit(el, fn)
+		}
+	case *SequenceExpression:
+		for _, stmt := range n.Statements {
+			p.visit(stmt, fn)
+		}
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			p.visit(el, fn)
+		}
+	case *SpreadElement:
+		p.visit(n.Value, fn)
+	case *MapLiteral:
+		for i := range n.Keys {
+			p.visit(n.Keys[i], fn)
+			p.visit(n.Values[i], fn)
+		}
+	case *MethodCallExpression:
+		p.visit(n.Receiver, fn)
+		for _, arg := range n.Arguments {
+			p.visit(arg, fn)
+		}
+	case *OptionalMemberExpression:
+		p.visit(n.Receiver, fn)
+	case *RangeExpression:
+		p.visit(n.Start, fn)
+		p.visit(n.End, fn)
+	case *ScoreExpression:
+		for _, cond := range n.Conditions {
+			p.visit(cond, fn)
+		}
+	case *IndexExpression:
+		p.visit(n.Left, fn)
+		p.visit(n.Index, fn)
+	case *IndexAssignExpression:
+		p.visit(n.Left, fn)
+		p.visit(n.Index, fn)
+		p.visit(n.Value, fn)
+	case *CallExpression:
+		if expr, fallback, ok := tryArgs(n); ok {
+			// expr 可能中途出错，其后的调用未必执行；fallback 只在出错时执行
+			p.branch(expr, fn)
+			p.branch(fallback, fn)
+			return
+		}
+		for _, arg := range n.Arguments {
+			p.visit(arg, fn)
+		}
+		if key, ok := p.memoKey(n); ok {
+			fn(n, key)
+		}
+	}
+}
+
+func (p *memoPlanner) branch(node Node, fn func(*CallExpression, string)) {
+	if node == nil {
+		return
+	}
+	p.regions++
+	p.path = append(p.path, p.regions)
+	p.visit(node, fn)
+	p.path = p.path[:len(p.path)-1]
+}
+
+// memoKey 返回可复用调用的文本；字面量带上类型，避免 len("a") 与 len(a) 混同
+func (p *memoPlanner) memoKey(n *CallExpression) (string, bool) {
+	ident, ok := n.Function.(*Identifier)
+	if !ok || !pureBuiltins.load()[ident.Value] || len(n.Arguments) == 0 {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString(ident.Value)
+	for _, arg := range n.Arguments {
+		switch arg := arg.(type) {
+		case *NumberLiteral, *BooleanLiteral:
+			b.WriteString(" #" + arg.String())
+		case *StringLiteral:
+			b.WriteString(" " + strconv.Quote(arg.Value))
+		case *Identifier:
+			if p.written[arg.Value] || p.banned[arg.Value] {
+				return "", false
+			}
+			b.WriteString(" " + arg.Value)
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// castKind 为类型转换内置函数的目标类型，也是各 VM 中 CAST 指令的参数
+type castKind int32
+
+const (
+	castInt castKind = iota
+	castFloat
+	castStr
+	castBool
+)
+
+// castNames 按 castKind 排列类型转换内置函数的名称
+var castNames = [...]string{"int", "float", "str", "bool"}
+
+// castKindOf 返回名为 name 的类型转换内置函数的目标类型。编译器以此把单参数的调用编译为 CAST 指令
+func castKindOf(name string) (castKind, bool) {
+	i := slices.Index(castNames[:], name)
+	return castKind(i), i >= 0
+}
+
+// castValue 把 v 转换为 kind 类型，由内置函数与各 VM 的 CAST 指令共用。
+// 字符串按十进制整数、浮点数或 strconv.ParseBool 的格式解析，首尾空白被忽略；
+// 浮点数与十进制数转整数时向零取整；str 按 concat 的格式取得文本；bool 对数字判断是否非零，对其余值取其真值
+func castValue(kind castKind, v Value) (Value, error) {
+	if v.Type == ValObject {
+		// 宿主传入的 int32 与 float32 与 typeof 一致地视为整数与浮点数
+		switch o := v.Obj.(type) {
+		case int32:
+			v = Value{Type: ValInt, Num: uint64(int64(o))}
+		case float32:
+			v = Value{Type: ValFloat, Num: math.Float64bits(float64(o))}
+		}
+	}
+	switch kind {
+	case castInt:
+		switch v.Type {
+		case ValInt:
+			return v, nil
+		case ValFloat:
+			f := math.Trunc(math.Float64frombits(v.Num))
+			if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return Value{}, fmt.Errorf("int: %v is out of range", math.Float64frombits(v.Num))
+			}
+			return Value{Type: ValInt, Num: uint64(int64(f))}, nil
+		case ValString:
+			n, err := strconv.ParseInt(strings.TrimSpace(v.Str), 10, 64)
+			if err != nil {
+				return Value{}, fmt.Errorf("int: invalid integer %q", v.Str)
+			}
+			return Value{Type: ValInt, Num: uint64(n)}, nil
+		case ValBool:
+			return Value{Type: ValInt, Num: v.Num}, nil
+		case ValDecimal:
+			n, ok := v.Obj.(Decimal).truncInt()
+			if !ok {
+				return Value{}, fmt.Errorf("int: %v is out of range", v.Obj)
+			}
+			return Value{Type: ValInt, Num: uint64(n)}, nil
+		}
+	case castFloat:
+		switch v.Type {
+		case ValInt:
+			return Value{Type: ValFloat, Num: math.Float64bits(float64(int64(v.Num)))}, nil
+		case ValFloat:
+			return v, nil
+		case ValString:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v.Str), 64)
+			if err != nil {
+				return Value{}, fmt.Errorf("float: invalid number %q", v.Str)
+			}
+			return Value{Type: ValFloat, Num: math.Float64bits(f)}, nil
+		case ValBool:
+			return Value{Type: ValFloat, Num: math.Float64bits(float64(v.Num))}, nil
+		case ValDecimal:
+			return Value{Type: ValFloat, Num: math.Float64bits(v.Obj.(Decimal).Float64())}, nil
+		}
+	case castStr:
+		if v.Type == ValString {
+			return v, nil
+		}
+		return Value{Type: ValString, Str: concatText(v.ToInterface())}, nil
+	case castBool:
+		switch v.Type {
+		case ValInt:
+			return Value{Type: ValBool, Num: boolToUint64(v.Num != 0)}, nil
+		case ValFloat:
+			return Value{Type: ValBool, Num: boolToUint64(math.Float64frombits(v.Num) != 0)}, nil
+		case ValDecimal:
+			return Value{Type: ValBool, Num: boolToUint64(v.Obj.(Decimal).Sign() != 0)}, nil
+		case ValString:
+			b, err := strconv.ParseBool(strings.TrimSpace(v.Str))
+			if err != nil {
+				return Value{}, fmt.Errorf("bool: invalid boolean %q", v.Str)
+			}
+			return Value{Type: ValBool, Num: boolToUint64(b)}, nil
+		}
+		return Value{Type: ValBool, Num: boolToUint64(isValTruthy(v))}, nil
+	}
+	return Value{}, fmt.Errorf("%s: cannot convert %s", castNames[kind], typeName(v.ToInterface()))
+}
+
+// castBuiltin 返回类型转换内置函数，如 int(x)
+func castBuiltin(kind castKind) BuiltinFunc {
+	return func(args ...any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument, got %d", castNames[kind], len(args))
+		}
+		v, err := castValue(kind, FromInterface(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		return v.ToInterface(), nil
+	}
+}
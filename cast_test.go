@@ -0,0 +1,62 @@
+package uwasa
+
+import "testing"
+
+func TestCast(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`int("42")`, int64(42)},
+		{`int(s) + 1`, int64(43)},
+		{`int(" -7 ")`, int64(-7)},
+		{`int(f)`, int64(2)},
+		{`int(-2.9)`, int64(-2)},
+		{`int(true) + int(false)`, int64(1)},
+		{`int(n32)`, int64(5)},
+		{`float(i)`, 3.0},
+		{`float("2.5") * 2`, 5.0},
+		{`float(f32)`, 0.5},
+		{`typeof(float(i))`, "float"},
+		{`str(i)`, "3"},
+		{`str(f) + "!"`, "2.75!"},
+		{`str(nil)`, "<nil>"},
+		{`str(s)`, "42"},
+		{`len(str(i * 100))`, int64(3)},
+		{`bool(i)`, true},
+		{`bool(0)`, false},
+		{`bool(0.0) || bool(-1)`, true},
+		{`bool("false")`, false},
+		{`bool(" TRUE ")`, true},
+		{`bool(arr)`, true},
+		{`bool(nil)`, false},
+		{`s |> int`, int64(42)},
+		{`int(s) == int("42") && int(s) > 40`, true},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"s": "42", "i": int64(3), "f": 2.75, "n32": int32(5), "f32": float32(0.5), "arr": []any{}}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`int("4.2")`, `int(arr)`, `int(nil)`, `float("x")`, `float(arr)`, `bool("yes")`, `int(1e30)`, `int(s, 10)`, `str()`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
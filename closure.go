@@ -0,0 +1,106 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// lambdaName 是 lambda 函数块的名称，出现在 RuntimeError.Function 中
+const lambdaName = "<lambda>"
+
+// maxClosureDepth 限制同一闭包的嵌套调用层数。闭包经由上下文变量可以把自己再次传给内置函数，
+// 每层调用都会重新进入 VM，不加限制会耗尽 Go 栈
+const maxClosureDepth = 32
+
+// Closure 是 lambda 表达式求值得到的函数值，作为参数传给内置函数，由内置函数通过 Call 调用。
+// 闭包按值捕获创建处可见的 let 绑定与参数；读写变量时使用创建它的那次执行的 Context，
+// 因此只应在该次执行期间调用。
+type Closure struct {
+	params int
+	call   func(args []any) (any, error)
+	depth  atomic.Int32
+}
+
+// Params 返回 lambda 声明的参数个数
+func (c *Closure) Params() int { return c.params }
+
+// Call 以 args 调用 lambda，参数个数必须与声明一致
+func (c *Closure) Call(args ...any) (any, error) {
+	if len(args) != c.params {
+		return nil, fmt.Errorf("lambda expects %d arguments, got %d", c.params, len(args))
+	}
+	defer c.depth.Add(-1)
+	if c.depth.Add(1) > maxClosureDepth {
+		return nil, fmt.Errorf("lambda call depth exceeds %d", maxClosureDepth)
+	}
+	return c.call(args)
+}
+
+// 各 VM 通过一个只含 CALLL 的入口块执行 lambda：捕获值与实参依次预置在入口块的 let 槽位
+// （寄存器 VM 为 CALLL 之上的寄存器）中，恰好成为函数块栈帧最低的槽位，调用约定与规则内函数一致。
+// 入口块携带创建闭包那次执行的拼接计数，lambda 中的拼接与调用方共用 MaxConcatBytes 的额度。
+
+func newVMClosure(functions []*RenderedBytecode, concat *concatBudget, fn int, captured []Value, ctx Context) *Closure {
+	chunk := functions[fn]
+	entry := &RenderedBytecode{
+		Instructions: []vmInstruction{{Op: OpCallLocal, Arg: int32(fn)}},
+		Locals:       chunk.Params,
+		concat:       concat,
+		Functions:    functions,
+	}
+	return &Closure{params: chunk.Params - len(captured), call: func(args []any) (any, error) {
+		var stack [64]Value
+		n := copy(stack[:], captured)
+		for i, arg := range args {
+			stack[n+i] = FromInterface(arg)
+		}
+		return runVM(entry, ctx, &stack, nil)
+	}}
+}
+
+func newNeoClosure(functions []*NeoBytecode, concat *concatBudget, fn int, captured []Value, ctx Context) *Closure {
+	chunk := functions[fn]
+	// NeoVM 的函数块与主程序共用常量池，CALLL 不切换常量池，入口块须持有同一个
+	entry := &NeoBytecode{
+		Instructions: []neoInstruction{{Op: NeoOpCallLocal, Arg: int32(fn)}, {Op: NeoOpReturn}},
+		Constants:    chunk.Constants,
+		Locals:       chunk.Params,
+		concat:       concat,
+		Functions:    functions,
+	}
+	return &Closure{params: chunk.Params - len(captured), call: func(args []any) (any, error) {
+		var stack [64]Value
+		n := copy(stack[:], captured)
+		for i, arg := range args {
+			stack[n+i] = FromInterface(arg)
+		}
+		if m, ok := ctx.(*MapContext); ok {
+			return runNeoVMMapped(entry, m.vars, &stack, nil)
+		}
+		return runNeoVMGeneral(entry, ctx, &stack, nil)
+	}}
+}
+
+func newRegisterClosure(functions []*RegisterBytecode, concat *concatBudget, fn int, captured []Value, ctx Context) *Closure {
+	chunk := functions[fn]
+	entry := &RegisterBytecode{
+		Instructions: []regInstruction{
+			{Op: ROpCallLocal, Dest: 0, Src1: 1, Src2: uint8(chunk.Params), Arg: int32(fn)},
+			{Op: ROpReturn, Src1: 0},
+		},
+		MaxRegisters: uint8(chunk.Params + 1),
+		concat:       concat,
+		Functions:    functions,
+	}
+	return &Closure{params: chunk.Params - len(captured), call: func(args []any) (any, error) {
+		var registers [256]Value
+		n := copy(registers[1:], captured)
+		for i, arg := range args {
+			registers[1+n+i] = FromInterface(arg)
+		}
+		return runRegisterVM(entry, ctx, &registers, nil)
+	}}
+}
@@ -0,0 +1,117 @@
+package uwasa
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLambda(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`filter(items, x -> x > 3)`, []any{int64(5), int64(6)}},
+		{`filter(items, x -> x > lim)`, []any{int64(5), int64(6)}},
+		{`let t = a + 0 => let u = t * 1 => filter(items, x -> x > t && x < u + 3)`, []any{int64(5)}},
+		{`let k = a => filter(items, x -> concat("v", x, k) == "v53")`, []any{int64(5)}},
+		{`fn big(xs, n) => filter(xs, x -> x > n); big(items, 4)`, []any{int64(5), int64(6)}},
+		{`filter(items, x -> filter([x, x + 1], y -> y % 2 == 0) != [])`, []any{int64(1), int64(3), int64(5), int64(6)}},
+		{`filter(items, x -> hits = hits + x), hits`, []any{[]any{int64(1), int64(3), int64(5), int64(6)}, int64(20)}},
+		{`filter([], x -> x / 0)`, []any{}},
+	}
+
+	newVars := func() map[string]any {
+		return map[string]any{"a": int64(3), "hits": int64(5), "lim": int64(4), "items": []any{int64(1), int64(3), int64(5), int64(6)}}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := newVars()
+			got, err := engine.Execute(vars)
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+			if _, ok := vars["x"]; ok {
+				t.Errorf("%s %s: parameter leaked into vars: %v", name, tt.input, vars)
+			}
+		}
+
+		// lambda 本身是可以交给调用方的函数值
+		engine, err := newEngine(`let k = a => (x, y) -> x * y + k`)
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", name, err)
+		}
+		got, err := engine.Execute(newVars())
+		if c, ok := got.(*Closure); err != nil || !ok || c.Params() != 2 {
+			t.Fatalf("%s: expected a two-argument closure, got %v, %v", name, got, err)
+		}
+		if r, err := got.(*Closure).Call(int64(4), int64(5)); err != nil || r != int64(23) {
+			t.Errorf("%s: expected 23, got %v, %v", name, r, err)
+		}
+
+		engine, _ = newEngine(`let f = x -> 10 / (x - 5) => filter(items, f)`)
+		_, err = engine.Execute(newVars())
+		// 外层是调用内置函数的 CALL 出错，lambda 内的出错位置在其包裹的错误中
+		var re, inner *RuntimeError
+		if name != "AST" && (!errors.As(err, &re) || !errors.As(re.Err, &inner) || inner.Function != lambdaName) {
+			t.Errorf("%s: expected RuntimeError in lambda, got %v", name, err)
+		}
+		// 内联为循环的 lambda 在当前栈帧中出错，错误不再经由 CALL 包裹
+		engine, _ = newEngine(`filter(items, x -> 10 / (x - 5))`)
+		_, err = engine.Execute(newVars())
+		if name != "AST" && (!errors.As(err, &re) || re.Function != "" || errors.As(re.Err, &inner)) {
+			t.Errorf("%s: expected RuntimeError in main chunk, got %v", name, err)
+		}
+
+		for _, in := range []string{
+			`f = x -> filter(items, f), filter(items, f)`,
+			`filter(items, () -> true)`,
+			`filter(a, x -> true)`,
+			`filter(items, 1)`,
+		} {
+			engine, err := newEngine(in)
+			if err == nil {
+				_, err = engine.Execute(newVars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, in)
+			}
+		}
+
+		for _, bad := range []string{
+			`(x, x) -> x`,
+			`x -> x = 1`,
+			`let t = 1 => filter(items, x -> t = x)`,
+			`(x, y)`,
+			`(1, y) -> y`,
+			`x ->`,
+		} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+
+		// 调用 let 绑定或参数在编译期报错，同名的内置函数也不会被调用
+		for _, bad := range []string{
+			`let f = x -> x + 1 => f(2)`,
+			`let len = x -> 0 => len("abc")`,
+			`let f = x -> x => 2 |> f`,
+			`map(items, len -> len("ab"))`,
+			`fn g(len) => len("a"); g(1)`,
+		} {
+			if _, err := newEngine(bad); err == nil || !strings.Contains(err.Error(), "not a function") {
+				t.Errorf("%s %s: expected a local call error, got %v", name, bad, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// 代码生成只覆盖能静态确定类型的子集：四种标量类型、算术/比较/逻辑/位运算、
+// if/elif/match 分支、多值返回与 concat。赋值、数组、映射、in 与方法调用不在其中。
+var goTypes = map[string]bool{"int64": true, "float64": true, "bool": true, "string": true}
+
+var zeroValues = map[string]string{"int64": "0", "float64": "0", "bool": "false", "string": `""`}
+
+const zeroPlaceholder = "\x00zero\x00" // 字符串字面量经 strconv.Quote 转义，不会出现 NUL
+
+type param struct {
+	name, goName, typ string
+}
+
+// rule 是一条待生成的规则：函数名取自 @name（缺省为文件名），参数由 @param("name type") 按顺序声明
+type rule struct {
+	file   string
+	source string
+	fn     string
+	params []param
+	body   uwasa.Expression
+}
+
+func parseRule(file, src string) (*rule, error) {
+	body, meta, err := uwasa.ParseRule(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	name := meta.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	r := &rule{file: file, source: strings.TrimSpace(src), fn: exportedName(name), body: body}
+	seen := map[string]bool{}
+	for _, decl := range meta.Annotations["param"] {
+		f := strings.Fields(decl)
+		if len(f) != 2 || !goTypes[f[1]] {
+			return nil, fmt.Errorf("%s: @param(%q): expected \"<name> <int64|float64|bool|string>\"", file, decl)
+		}
+		if seen[f[0]] {
+			return nil, fmt.Errorf("%s: duplicate @param %s", file, f[0])
+		}
+		seen[f[0]] = true
+		r.params = append(r.params, param{name: f[0], goName: goIdent(f[0]), typ: f[1]})
+	}
+	return r, nil
+}
+
+// exportedName 把 vip-check、vip_check 之类的规则名转为导出的 Go 标识符 VipCheck
+func exportedName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, c := range name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		b.WriteRune(c)
+	}
+	s := b.String()
+	if s == "" || !unicode.IsUpper([]rune(s)[0]) {
+		s = "Rule" + s
+	}
+	return s
+}
+
+// goIdent 避开 Go 关键字以及生成代码使用的临时变量与包名
+func goIdent(name string) string {
+	if token.IsKeyword(name) || strings.HasPrefix(name, "_") || name == "fmt" || name == "strconv" {
+		return "p_" + name
+	}
+	return name
+}
+
+func generate(pkg string, rules []*rule) ([]byte, error) {
+	imports := map[string]bool{}
+	var out strings.Builder
+	fns := map[string]string{}
+	for _, r := range rules {
+		if prev, ok := fns[r.fn]; ok {
+			return nil, fmt.Errorf("%s: function %s already generated from %s", r.file, r.fn, prev)
+		}
+		fns[r.fn] = r.file
+		g := &funcGen{vars: map[string]param{}, imports: imports}
+		for _, p := range r.params {
+			g.vars[p.name] = p
+		}
+		if err := g.function(&out, r); err != nil {
+			return nil, fmt.Errorf("%s: %w", r.file, err)
+		}
+	}
+
+	var head strings.Builder
+	head.WriteString("// Code generated by uwasagen. DO NOT EDIT.\n\npackage " + pkg + "\n\n")
+	if len(imports) > 0 {
+		names := make([]string, 0, len(imports))
+		for name := range imports {
+			names = append(names, strconv.Quote(name))
+		}
+		sort.Strings(names)
+		head.WriteString("import (\n" + strings.Join(names, "\n") + "\n)\n\n")
+	}
+	src, err := format.Source([]byte(head.String() + out.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// funcGen 为一条规则生成函数体。表达式生成返回无副作用的 Go 表达式，
+// 除零、负移位等检查作为语句提前写入 body，短路与分支中的检查写在对应的块内。
+type funcGen struct {
+	vars    map[string]param
+	imports map[string]bool
+	body    *strings.Builder
+	tmp     int
+	results []string
+}
+
+func (g *funcGen) function(out *strings.Builder, r *rule) error {
+	g.body = &strings.Builder{}
+	exprs := []uwasa.Expression{r.body}
+	if t, ok := r.body.(*uwasa.TupleExpression); ok {
+		exprs = t.Elements
+	}
+	var values []string
+	for _, e := range exprs {
+		code, typ, err := g.expr(e)
+		if err != nil {
+			return err
+		}
+		values = append(values, code)
+		g.results = append(g.results, typ)
+	}
+
+	fmt.Fprintf(out, "// %s 由规则 %s 生成：\n//\n", r.fn, filepath.Base(r.file))
+	for _, line := range strings.Split(r.source, "\n") {
+		fmt.Fprintf(out, "//\t%s\n", line)
+	}
+	params := make([]string, len(r.params))
+	for i, p := range r.params {
+		params[i] = p.goName + " " + p.typ
+	}
+	fmt.Fprintf(out, "func %s(%s) (%s, error) {\n", r.fn, strings.Join(params, ", "), strings.Join(g.results, ", "))
+	zeros := make([]string, len(g.results))
+	for i, typ := range g.results {
+		zeros[i] = zeroValues[typ]
+	}
+	out.WriteString(strings.ReplaceAll(g.body.String(), zeroPlaceholder, strings.Join(zeros, ", ")))
+	fmt.Fprintf(out, "return %s, nil\n}\n\n", strings.Join(values, ", "))
+	return nil
+}
+
+func (g *funcGen) bind(code string) string {
+	g.tmp++
+	name := fmt.Sprintf("_%d", g.tmp)
+	fmt.Fprintf(g.body, "%s := %s\n", name, code)
+	return name
+}
+
+// fail 写出失败时的提前返回，format 与解释器的错误信息一致
+func (g *funcGen) fail(cond, format string, args ...string) {
+	g.imports["fmt"] = true
+	// 此时结果类型尚未全部确定，先写占位符，函数生成结束后替换为各结果的零值
+	fmt.Fprintf(g.body, "if %s {\nreturn %s, fmt.Errorf(%s)\n}\n", cond, zeroPlaceholder, strings.Join(append([]string{strconv.Quote(format)}, args...), ", "))
+}
+
+// block 在独立的缓冲区中生成 e，返回其前置语句
+func (g *funcGen) block(e uwasa.Expression) (stmts, code, typ string, err error) {
+	saved := g.body
+	g.body = &strings.Builder{}
+	code, typ, err = g.expr(e)
+	stmts = g.body.String()
+	g.body = saved
+	return
+}
+
+func (g *funcGen) expr(e uwasa.Expression) (string, string, error) {
+	switch n := e.(type) {
+	case *uwasa.Identifier:
+		p, ok := g.vars[n.Value]
+		if !ok {
+			return "", "", fmt.Errorf("undeclared variable %s: add @param(\"%s <type>\")", n.Value, n.Value)
+		}
+		return p.goName, p.typ, nil
+	case *uwasa.NumberLiteral:
+		if n.IsInt {
+			return strconv.FormatInt(n.Int64Value, 10), "int64", nil
+		}
+		s := strconv.FormatFloat(n.Float64Value, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, "float64", nil
+	case *uwasa.StringLiteral:
+		return strconv.Quote(n.Value), "string", nil
+	case *uwasa.BooleanLiteral:
+		return strconv.FormatBool(n.Value), "bool", nil
+	case *uwasa.PrefixExpression:
+		code, typ, err := g.expr(n.Right)
+		if err != nil {
+			return "", "", err
+		}
+		switch {
+		case n.Operator == "-" && (typ == "int64" || typ == "float64"):
+			return "(-" + code + ")", typ, nil
+		case n.Operator == "!" && typ == "bool":
+			return "(!" + code + ")", typ, nil
+		}
+		return "", "", fmt.Errorf("unsupported operand %s%s", n.Operator, typ)
+	case *uwasa.InfixExpression:
+		return g.infix(n)
+	case *uwasa.IfExpression:
+		return g.ifExpr(n)
+	case *uwasa.CallExpression:
+		if fn, ok := n.Function.(*uwasa.Identifier); ok && fn.Value == "concat" {
+			return g.concat(n.Arguments)
+		}
+		return "", "", fmt.Errorf("unsupported call %s", n.Function)
+	}
+	return "", "", fmt.Errorf("%s is not supported by uwasagen", e)
+}
+
+func (g *funcGen) infix(n *uwasa.InfixExpression) (string, string, error) {
+	if n.Operator == "&&" || n.Operator == "||" {
+		return g.logical(n)
+	}
+	l, lt, err := g.expr(n.Left)
+	if err != nil {
+		return "", "", err
+	}
+	r, rt, err := g.expr(n.Right)
+	if err != nil {
+		return "", "", err
+	}
+	numeric := isNumeric(lt) && isNumeric(rt)
+	mismatch := fmt.Errorf("unsupported operands %s %s %s", lt, n.Operator, rt)
+	switch n.Operator {
+	case "+", "-", "*", "/":
+		if n.Operator == "+" && lt == "string" && rt == "string" {
+			return "(" + l + " + " + r + ")", "string", nil
+		}
+		if !numeric {
+			return "", "", mismatch
+		}
+		typ := "int64"
+		if lt != rt || lt == "float64" {
+			l, r, typ = toFloat(l, lt), toFloat(r, rt), "float64"
+		}
+		if n.Operator == "/" {
+			if r, err = g.checkNonZero(n.Right, r); err != nil {
+				return "", "", err
+			}
+		}
+		return "(" + l + " " + n.Operator + " " + r + ")", typ, nil
+	case "%":
+		if lt != "int64" || rt != "int64" {
+			return "", "", mismatch
+		}
+		if r, err = g.checkNonZero(n.Right, r); err != nil {
+			return "", "", err
+		}
+		return "(" + l + " % " + r + ")", "int64", nil
+	case "==", "!=", ">", "<", ">=", "<=":
+		if numeric && lt != rt {
+			l, r = toFloat(l, lt), toFloat(r, rt)
+		} else if lt != rt || (!numeric && n.Operator != "==" && n.Operator != "!=") {
+			return "", "", mismatch
+		}
+		return "(" + l + " " + n.Operator + " " + r + ")", "bool", nil
+	case "&", "|", "^", "<<", ">>":
+		if lt != "int64" || rt != "int64" {
+			return "", "", mismatch
+		}
+		if n.Operator == "<<" || n.Operator == ">>" {
+			if lit, ok := n.Right.(*uwasa.NumberLiteral); !ok || lit.Int64Value < 0 {
+				r = g.simple(n.Right, r)
+				g.fail(r+" < 0", "negative shift count %d", r)
+			}
+			r = "uint64(" + r + ")"
+		}
+		return "(" + l + " " + n.Operator + " " + r + ")", "int64", nil
+	}
+	return "", "", fmt.Errorf("operator %s is not supported by uwasagen", n.Operator)
+}
+
+// logical 生成短路的 && 与 ||；右侧带检查语句时展开为 if 块
+func (g *funcGen) logical(n *uwasa.InfixExpression) (string, string, error) {
+	l, lt, err := g.expr(n.Left)
+	if err != nil {
+		return "", "", err
+	}
+	stmts, r, rt, err := g.block(n.Right)
+	if err != nil {
+		return "", "", err
+	}
+	if lt != "bool" || rt != "bool" {
+		return "", "", fmt.Errorf("unsupported operands %s %s %s: logical operators require bool", lt, n.Operator, rt)
+	}
+	if stmts == "" {
+		return "(" + l + " " + n.Operator + " " + r + ")", "bool", nil
+	}
+	v := g.bind(l)
+	if n.Operator == "&&" {
+		fmt.Fprintf(g.body, "if %s {\n%s%s = %s\n}\n", v, stmts, v, r)
+	} else {
+		fmt.Fprintf(g.body, "if !%s {\n%s%s = %s\n}\n", v, stmts, v, r)
+	}
+	return v, "bool", nil
+}
+
+func (g *funcGen) ifExpr(n *uwasa.IfExpression) (string, string, error) {
+	cond, ct, err := g.expr(n.Condition)
+	if err != nil {
+		return "", "", err
+	}
+	if ct != "bool" {
+		return "", "", fmt.Errorf("if condition must be bool, got %s", ct)
+	}
+	if n.IsSimple {
+		return cond, "bool", nil
+	}
+	if n.Alternative == nil {
+		return "", "", fmt.Errorf("if without else is not supported by uwasagen: the result would be nil")
+	}
+	cs, c, cty, err := g.block(n.Consequence)
+	if err != nil {
+		return "", "", err
+	}
+	as, a, aty, err := g.block(n.Alternative)
+	if err != nil {
+		return "", "", err
+	}
+	typ := cty
+	if cty != aty {
+		if !isNumeric(cty) || !isNumeric(aty) {
+			return "", "", fmt.Errorf("if branches have different types %s and %s", cty, aty)
+		}
+		c, a, typ = toFloat(c, cty), toFloat(a, aty), "float64"
+	}
+	g.tmp++
+	v := fmt.Sprintf("_%d", g.tmp)
+	fmt.Fprintf(g.body, "var %s %s\nif %s {\n%s%s = %s\n} else {\n%s%s = %s\n}\n", v, typ, cond, cs, v, c, as, v, a)
+	return v, typ, nil
+}
+
+func (g *funcGen) concat(args []uwasa.Expression) (string, string, error) {
+	if len(args) == 0 {
+		return `""`, "string", nil
+	}
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		code, typ, err := g.expr(arg)
+		if err != nil {
+			return "", "", err
+		}
+		switch typ {
+		case "string":
+			parts[i] = code
+		case "int64":
+			parts[i] = "strconv.FormatInt(" + code + ", 10)"
+		case "float64":
+			parts[i] = "strconv.FormatFloat(" + code + ", 'g', -1, 64)"
+		case "bool":
+			parts[i] = "strconv.FormatBool(" + code + ")"
+		}
+		if typ != "string" {
+			g.imports["strconv"] = true
+		}
+	}
+	return "(" + strings.Join(parts, " + ") + ")", "string", nil
+}
+
+// checkNonZero 为除数写出运行期检查；非零字面量无需检查，字面量 0 在生成期报错
+func (g *funcGen) checkNonZero(e uwasa.Expression, code string) (string, error) {
+	if lit, ok := e.(*uwasa.NumberLiteral); ok {
+		if (lit.IsInt && lit.Int64Value == 0) || (!lit.IsInt && lit.Float64Value == 0) {
+			return "", fmt.Errorf("division by zero")
+		}
+		return code, nil
+	}
+	code = g.simple(e, code)
+	g.fail(code+" == 0", "division by zero")
+	return code, nil
+}
+
+// simple 保证 code 可被重复求值而不重复计算：标识符与字面量原样返回，其余绑定到临时变量
+func (g *funcGen) simple(e uwasa.Expression, code string) string {
+	switch e.(type) {
+	case *uwasa.Identifier, *uwasa.NumberLiteral:
+		return code
+	}
+	return g.bind(code)
+}
+
+func isNumeric(typ string) bool { return typ == "int64" || typ == "float64" }
+
+func toFloat(code, typ string) string {
+	if typ == "float64" {
+		return code
+	}
+	return "float64(" + code + ")"
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{`a + 1`, "undeclared variable a"},
+		{`@param("a int")` + "\n" + `a`, `expected "<name> <int64|float64|bool|string>"`},
+		{`@param("a int64") @param("a bool")` + "\n" + `a`, "duplicate @param a"},
+		{`@param("a float64")` + "\n" + `a % 2`, "unsupported operands float64 % int64"},
+		{`@param("a string")` + "\n" + `a < "b"`, "unsupported operands string < string"},
+		{`@param("a int64")` + "\n" + `a / 0`, "division by zero"},
+		{`@param("a int64")` + "\n" + `if a > 1 is "x"`, "if without else"},
+		{`@param("a int64")` + "\n" + `if a > 1 then a = 2`, "is not supported by uwasagen"},
+		{`@param("a string")` + "\n" + `a in "abc"`, "operator in is not supported"},
+		{`@param("a int64")` + "\n" + `if a is 1 else is 2`, "if condition must be bool"},
+	}
+	for _, tt := range tests {
+		r, err := parseRule("r.uwasa", tt.src)
+		if err == nil {
+			_, err = generate("rules", []*rule{r})
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.src, tt.err, err)
+		}
+	}
+
+	a, _ := parseRule("a/x.uwasa", `1`)
+	b, _ := parseRule("b/x.uwasa", `2`)
+	if _, err := generate("rules", []*rule{a, b}); err == nil {
+		t.Errorf("expected error for duplicate function names")
+	}
+}
+
+func TestExportedName(t *testing.T) {
+	for in, want := range map[string]string{"vip-check": "VipCheck", "vip_check": "VipCheck", "discount": "Discount", "2fa": "Rule2fa", "": "Rule"} {
+		if got := exportedName(in); got != want {
+			t.Errorf("exportedName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestGeneratedMatchesEngine 编译并运行生成的代码，结果与错误须与解释器一致
+func TestGeneratedMatchesEngine(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+	type call struct {
+		args string         // 生成函数的实参
+		vars map[string]any // 解释器的变量
+	}
+	rules := []struct {
+		src   string
+		calls []call
+	}{
+		{`@name("discount") @param("price float64") @param("vip bool") @param("qty int64")
+if vip && price / qty > 10 is price * 0.8 elif qty >= 3 is qty * 1.0 else is price`, []call{
+			{"100, true, 2", map[string]any{"price": 100.0, "vip": true, "qty": int64(2)}},
+			{"100, true, 0", map[string]any{"price": 100.0, "vip": true, "qty": int64(0)}},
+			{"5, false, 4", map[string]any{"price": 5.0, "vip": false, "qty": int64(4)}},
+			{"5, false, 1", map[string]any{"price": 5.0, "vip": false, "qty": int64(1)}},
+		}},
+		{`@name("mix") @param("a int64") @param("b int64") @param("s string")
+concat(s, "-", a << b, 1.5, a > b), a % b, match a { 1 => "one", 2 => "two", _ => "many" }, 1 <= a < 10`, []call{
+			{`1, 3, "k"`, map[string]any{"a": int64(1), "b": int64(3), "s": "k"}},
+			{`-7, 2, ""`, map[string]any{"a": int64(-7), "b": int64(2), "s": ""}},
+			{`2, -1, "x"`, map[string]any{"a": int64(2), "b": int64(-1), "s": "x"}},
+			{`12, 0, "x"`, map[string]any{"a": int64(12), "b": int64(0), "s": "x"}},
+		}},
+		{`@name("bits") @param("x int64") @param("f float64")
+(x & 12 | 1) ^ 3, -f / 4, !(x == 3) || f / x > 1, 7 / 2`, []call{
+			{"3, 9", map[string]any{"x": int64(3), "f": 9.0}},
+			{"0, 1", map[string]any{"x": int64(0), "f": 1.0}},
+		}},
+	}
+
+	dir := t.TempDir()
+	var parsed []*rule
+	var mainSrc strings.Builder
+	mainSrc.WriteString("package main\n\nimport \"fmt\"\n\nfunc show(v ...any) {\n\tif err := v[len(v)-1]; err != nil {\n\t\tfmt.Println(\"error:\", err)\n\t\treturn\n\t}\n\tfor _, x := range v[:len(v)-1] {\n\t\tfmt.Printf(\"%v(%T) \", x, x)\n\t}\n\tfmt.Println()\n}\n\nfunc main() {\n")
+	var want strings.Builder
+	for _, tt := range rules {
+		r, err := parseRule("rule.uwasa", tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, r)
+		engine, err := uwasa.NewEngine(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range tt.calls {
+			fmt.Fprintf(&mainSrc, "\tshow(%s(%s))\n", r.fn, c.args)
+			res, err := engine.Execute(c.vars)
+			if err != nil {
+				fmt.Fprintln(&want, "error:", err)
+				continue
+			}
+			vals, ok := res.([]any)
+			if !ok {
+				vals = []any{res}
+			}
+			for _, v := range vals {
+				fmt.Fprintf(&want, "%v(%T) ", v, v)
+			}
+			fmt.Fprintln(&want)
+		}
+	}
+	mainSrc.WriteString("}\n")
+	code, err := generate("main", parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"go.mod":       "module gentest\n\ngo 1.26\n",
+		"rules_gen.go": string(code),
+		"main.go":      mainSrc.String(),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run failed: %v\n%s\n%s", err, out, code)
+	}
+	if string(out) != want.String() {
+		t.Errorf("generated code disagrees with the interpreter:\n got:\n%s\nwant:\n%s", out, want.String())
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// uwasagen 把构建期已知的规则编译为原生 Go 函数，每条规则生成一个带类型参数的函数，
+// 执行时不经过解释器。
+//
+//	uwasagen -pkg rules -o rules_gen.go discount.uwasa vip.uwasa
+//
+// 规则文件通过注解声明函数名与参数：
+//
+//	@name("discount")
+//	@param("price float64")
+//	@param("vip bool")
+//	if vip is price * 0.8 else is price
+//
+// 生成 func Discount(price float64, vip bool) (float64, error)。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	pkg := flag.String("pkg", "rules", "package name of the generated file")
+	out := flag.String("o", "", "output file (default stdout)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: uwasagen [-pkg name] [-o file] rule.uwasa...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*pkg, *out, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "uwasagen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(pkg, out string, files []string) error {
+	rules := make([]*rule, 0, len(files))
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		r, err := parseRule(file, string(src))
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+	}
+	code, err := generate(pkg, rules)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return os.WriteFile(out, code, 0o644)
+}
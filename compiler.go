@@ -3,7 +3,10 @@
 
 package uwasa
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+)
 
 // Recompiler 进行更激进的代数简化和静态检查
 type Recompiler struct {
@@ -56,6 +59,91 @@ func (o *Recompiler) simplify(node Node) Node {
 		}
 		return n
 
+	case *LetExpression:
+		n.Value = o.simplify(n.Value).(Expression)
+		n.Body = o.simplify(n.Body).(Expression)
+		return n
+
+	case *DestructureExpression:
+		n.Value = o.simplify(n.Value).(Expression)
+		if n.Body != nil {
+			n.Body = o.simplify(n.Body).(Expression)
+		}
+		return n
+
+	case *Program:
+		for _, fn := range n.Functions {
+			fn.Body = o.simplify(fn.Body).(Expression)
+		}
+		n.Body = o.simplify(n.Body).(Expression)
+		return n
+
+	case *LambdaLiteral:
+		n.Body = o.simplify(n.Body).(Expression)
+		return n
+
+	case *TupleExpression:
+		for i, el := range n.Elements {
+			n.Elements[i] = o.simplify(el).(Expression)
+		}
+		return n
+
+	case *SequenceExpression:
+		for i, stmt := range n.Statements {
+			n.Statements[i] = o.simplify(stmt).(Expression)
+		}
+		return n
+
+	case *ArrayLiteral:
+		for i, el := range n.Elements {
+			n.Elements[i] = o.simplify(el).(Expression)
+		}
+		return n
+
+	case *SpreadElement:
+		n.Value = o.simplify(n.Value).(Expression)
+		return n
+
+	case *MapLiteral:
+		for i := range n.Keys {
+			n.Keys[i] = o.simplify(n.Keys[i]).(Expression)
+			n.Values[i] = o.simplify(n.Values[i]).(Expression)
+		}
+		return n
+
+	case *MethodCallExpression:
+		n.Receiver = o.simplify(n.Receiver).(Expression)
+		for i, arg := range n.Arguments {
+			n.Arguments[i] = o.simplify(arg).(Expression)
+		}
+		return n
+
+	case *OptionalMemberExpression:
+		n.Receiver = o.simplify(n.Receiver).(Expression)
+		return n
+
+	case *RangeExpression:
+		n.Start = o.simplify(n.Start).(Expression)
+		n.End = o.simplify(n.End).(Expression)
+		return n
+
+	case *ScoreExpression:
+		for i, cond := range n.Conditions {
+			n.Conditions[i] = o.simplify(cond).(Expression)
+		}
+		return n
+
+	case *IndexExpression:
+		n.Left = o.simplify(n.Left).(Expression)
+		n.Index = o.simplify(n.Index).(Expression)
+		return n
+
+	case *IndexAssignExpression:
+		n.Left = o.simplify(n.Left).(Expression)
+		n.Index = o.simplify(n.Index).(Expression)
+		n.Value = o.simplify(n.Value).(Expression)
+		return n
+
 	default:
 		return n
 	}
@@ -121,7 +209,7 @@ func (o *Recompiler) simplifyInfix(ie *InfixExpression) Node {
 				}
 			}
 		}
-	case "!=": // 虽然还没定义这个 Token，但为了完整性考虑
+	case "!=":
 		if isSameIdentifier(left, right) && !hasSideEffects(left) {
 			return &BooleanLiteral{Value: false}
 		}
@@ -141,7 +229,15 @@ func (o *Recompiler) checkTypeMismatch(ie *InfixExpression) {
 	_, okRB := right.(*BooleanLiteral)
 
 	switch ie.Operator {
-	case "-", "*", "/", "%", ">", "<", ">=", "<=":
+	case ">", "<", ">=", "<=":
+		// 字符串之间按字节序比较，只有字符串与数字或布尔值的比较一定不成立
+		if (okLS && (okRN || okRB)) || (okRS && (okLN || okLB)) {
+			o.errors = append(o.errors, fmt.Sprintf("invalid comparison: string %s number/boolean", ie.Operator))
+		}
+		if (okLB || okRB) && !okLS && !okRS {
+			o.errors = append(o.errors, fmt.Sprintf("invalid operation: boolean %s boolean/number", ie.Operator))
+		}
+	case "-", "*", "/", "%", "&", "|", "^", "<<", ">>":
 		if okLS || okRS {
 			o.errors = append(o.errors, fmt.Sprintf("invalid operation: string %s string/number", ie.Operator))
 		}
@@ -156,7 +252,10 @@ func (o *Recompiler) checkTypeMismatch(ie *InfixExpression) {
 			o.errors = append(o.errors, "invalid operation: boolean + any")
 		}
 	case "&&", "||":
-		// Flag obvious non-boolean types in logic
+		// Flag obvious non-boolean types in logic; operand logic returns them as values, e.g. `a || "default"`
+		if ie.ReturnsOperand {
+			break
+		}
 		if okLN || okRN || okLS || okRS {
 			o.errors = append(o.errors, fmt.Sprintf("invalid logic operation: %s used with non-boolean literal", ie.Operator))
 		}
@@ -180,18 +279,15 @@ func (o *Recompiler) checkUnreachable(ie *IfExpression) {
 	}
 }
 
+// isZero 与 isOne 只匹配整数字面量：`x * 1.0` 会把整数 x 转为浮点数，不能化简为 x
 func isZero(n Node) bool {
 	lit, ok := n.(*NumberLiteral)
-	if !ok { return false }
-	if lit.IsInt { return lit.Int64Value == 0 }
-	return lit.Float64Value == 0
+	return ok && lit.IsInt && lit.Int64Value == 0
 }
 
 func isOne(n Node) bool {
 	lit, ok := n.(*NumberLiteral)
-	if !ok { return false }
-	if lit.IsInt { return lit.Int64Value == 1 }
-	return lit.Float64Value == 1
+	return ok && lit.IsInt && lit.Int64Value == 1
 }
 
 func isSameIdentifier(left, right Node) bool {
@@ -202,17 +298,27 @@ func isSameIdentifier(left, right Node) bool {
 }
 
 func hasSideEffects(n Node) bool {
-	// 目前只有 AssignExpression 有副作用
+	// 赋值（含下标赋值）与可能原地修改接收者的方法调用有副作用
 	// 递归检查
 	var found bool
 	walk(n, func(node Node) {
-		if _, ok := node.(*AssignExpression); ok {
+		switch node.(type) {
+		case *AssignExpression, *DestructureExpression, *IndexAssignExpression, *MethodCallExpression:
 			found = true
 		}
 	})
 	return found
 }
 
+// markOperandLogic 让 node 中所有的 `&&` 与 `||` 返回操作数，见 EngineOptions.OperandLogic
+func markOperandLogic(node Node) {
+	walk(node, func(n Node) {
+		if ie, ok := n.(*InfixExpression); ok && (ie.Operator == "&&" || ie.Operator == "||") {
+			ie.ReturnsOperand = true
+		}
+	})
+}
+
 func walk(node Node, fn func(Node)) {
 	if node == nil { return }
 	fn(node)
@@ -227,10 +333,85 @@ func walk(node Node, fn func(Node)) {
 		walk(n.Alternative, fn)
 	case *AssignExpression:
 		walk(n.Value, fn)
+	case *LetExpression:
+		walk(n.Name, fn)
+		walk(n.Value, fn)
+		walk(n.Body, fn)
+	case *DestructureExpression:
+		walk(n.Value, fn)
+		walk(n.Body, fn)
+	case *Program:
+		// 函数名与参数不是表达式，只访问函数体
+		for _, f := range n.Functions {
+			walk(f.Body, fn)
+		}
+		walk(n.Body, fn)
+	case *LambdaLiteral:
+		walk(n.Body, fn)
 	case *CallExpression:
 		walk(n.Function, fn)
 		for _, arg := range n.Arguments {
 			walk(arg, fn)
 		}
+	case *TupleExpression:
+		for _, el := range n.Elements {
+			walk(el, fn)
+		}
+	case *SequenceExpression:
+		for _, stmt := range n.Statements {
+			walk(stmt, fn)
+		}
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			walk(el, fn)
+		}
+	case *SpreadElement:
+		walk(n.Value, fn)
+	case *MapLiteral:
+		for i := range n.Keys {
+			walk(n.Keys[i], fn)
+			walk(n.Values[i], fn)
+		}
+	case *MethodCallExpression:
+		walk(n.Receiver, fn)
+		for _, arg := range n.Arguments {
+			walk(arg, fn)
+		}
+	case *OptionalMemberExpression:
+		walk(n.Receiver, fn)
+	case *RangeExpression:
+		walk(n.Start, fn)
+		walk(n.End, fn)
+	case *ScoreExpression:
+		// 权重不是单独求值的表达式，只访问条件
+		for _, cond := range n.Conditions {
+			walk(cond, fn)
+		}
+	case *IndexExpression:
+		walk(n.Left, fn)
+		walk(n.Index, fn)
+	case *IndexAssignExpression:
+		walk(n.Left, fn)
+		walk(n.Index, fn)
+		walk(n.Value, fn)
 	}
 }
+
+// chunkTable 登记一条规则的全部函数块，编译规则内函数与 lambda 的子编译器共享同一张表。
+// 块按编译完成的顺序登记，下标即 CALLL 与 MKCLOS 的操作数，因此任一块只会引用更早登记的块；
+// lambda 没有名称，不会被按名调用。
+type chunkTable[T any] struct {
+	names  []string
+	chunks []T
+}
+
+func (t *chunkTable[T]) add(name string, chunk T) int {
+	t.names = append(t.names, name)
+	t.chunks = append(t.chunks, chunk)
+	return len(t.chunks) - 1
+}
+
+// index 返回已编译的名为 name 的规则内函数的下标，未定义时返回 -1
+func (t *chunkTable[T]) index(name string) int {
+	return slices.Index(t.names, name)
+}
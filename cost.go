@@ -0,0 +1,277 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import "fmt"
+
+// 静态开销的计量单位：一次栈/寄存器搬运或整数运算记 1。
+// 估算把所有指令（包括互斥分支）累加，是单次执行开销的上界而非精确耗时，
+// 适合用于调度时比较、排序规则以及拒绝过重的规则。
+const (
+	costStep      = 1  // 搬运、跳转、算术与比较
+	costDivide    = 2  // 除法与取模需要额外检查
+	costGlobal    = 2  // 从上下文读取变量
+	costSetGlobal = 3  // 写回上下文
+	costIndex     = 2  // 下标读取与 in 判断
+	costSetIndex  = 3  // 下标写入
+	costAlloc     = 8  // 构造数组/映射或复制容器常量
+	costCall      = 6  // 调用内置函数或方法的固定开销（参数装箱），每个参数另计 costStep
+	costBuiltin   = 10 // builtinCosts 中未列出的内置函数
+)
+
+// builtinCosts 为内置函数自身的开销，不含调用与参数的开销
+var builtinCosts = map[string]int{
+	"concat": 4,
+	"len":    1,
+	"slice":  2,
+	"int":    1,
+	"float":  1,
+	"str":    2,
+	"bool":   1,
+}
+
+// CostLimitError 表示规则的静态开销超出了 EngineOptions.MaxCost
+type CostLimitError struct {
+	Limit int
+	Cost  int
+}
+
+func (e *CostLimitError) Error() string {
+	return fmt.Sprintf("estimated rule cost %d exceeds limit of %d", e.Cost, e.Limit)
+}
+
+// EstimatedCost 按编译产物的指令构成估算单次执行的静态开销，供调度方预算与排序。
+// 常量规则的开销为 0；不同后端的指令构成不同，只有同一后端编译的规则之间可以直接比较。
+func (e *Engine) EstimatedCost() int {
+	switch {
+	case e.isConstant:
+		return 0
+	case e.neoBytecode != nil:
+		return neoCost(e.neoBytecode)
+	case e.registerBytecode != nil:
+		return registerCost(e.registerBytecode)
+	case e.bytecode != nil:
+		return vmCost(e.bytecode)
+	}
+	return astCost(e.program)
+}
+
+func builtinCost(name string, numArgs int) int {
+	c, ok := builtinCosts[name]
+	if !ok {
+		c = costBuiltin
+	}
+	return costCall + c + numArgs*costStep
+}
+
+// 规则内函数的开销在每个调用处计入一次函数体；函数只能调用更早定义的函数，按定义顺序即可求出。
+// lambda 在创建处计入一次函数体，内置函数实际调用的次数无法静态得知
+func vmCost(bc *RenderedBytecode) int {
+	fns := make([]int, len(bc.Functions))
+	for i, f := range bc.Functions {
+		fns[i] = vmChunkCost(f, bc.Functions, fns)
+	}
+	return vmChunkCost(bc, bc.Functions, fns)
+}
+
+func vmChunkCost(bc *RenderedBytecode, functions []*RenderedBytecode, fns []int) int {
+	total := 0
+	for _, inst := range bc.Instructions {
+		switch inst.Op {
+		case OpDiv, OpMod:
+			total += costDivide
+		case OpGetGlobal, OpGetGlobalJumpIfFalse, OpGetGlobalJumpIfTrue, OpJumpTableGlobal, OpInSetGlobal,
+			OpAddGlobal, OpEqualGlobalConst, OpGreaterGlobalConst, OpLessGlobalConst, OpFusedCompareGlobalConstJumpIfFalse:
+			total += costGlobal + costStep
+		case OpAddGlobalGlobal:
+			total += 2*costGlobal + costStep
+		case OpSetGlobal:
+			total += costSetGlobal
+		case OpIndex, OpIn, OpMapGetConst:
+			total += costIndex
+		case OpSetIndex:
+			total += costSetIndex
+		case OpMakeArray, OpMakeMap, OpCopyConst, OpMakeRange, OpSpread:
+			total += costAlloc
+		case OpConcat:
+			total += builtinCost("concat", int(inst.Arg)) - costCall
+		case OpCast:
+			total += builtinCost(castNames[inst.Arg], 1) - costCall
+		case OpSlice:
+			total += builtinCost("slice", 3) - costCall
+		case OpCall:
+			total += builtinCost(bc.Constants[inst.Arg&0xFFFF].Str, int(inst.Arg>>16))
+		case OpCallMethod:
+			total += costCall + int(inst.Arg>>16)*costStep
+		case OpCallLocal:
+			total += costCall + functions[inst.Arg].Params*costStep + fns[inst.Arg]
+		case OpMakeClosure:
+			total += costAlloc + fns[inst.Arg&0xFFFF]
+		default:
+			total += costStep
+		}
+	}
+	return total
+}
+
+func registerCost(bc *RegisterBytecode) int {
+	fns := make([]int, len(bc.Functions))
+	for i, f := range bc.Functions {
+		fns[i] = registerChunkCost(f, fns)
+	}
+	return registerChunkCost(bc, fns)
+}
+
+func registerChunkCost(bc *RegisterBytecode, fns []int) int {
+	total := 0
+	for _, inst := range bc.Instructions {
+		switch inst.Op {
+		case ROpDiv, ROpMod:
+			total += costDivide
+		case ROpGetGlobal:
+			total += costGlobal
+		case ROpSetGlobal:
+			total += costSetGlobal
+		case ROpIndex, ROpIn, ROpInSet, ROpMapGetConst:
+			total += costIndex
+		case ROpSetIndex:
+			total += costSetIndex
+		case ROpMakeArray, ROpMakeMap, ROpCopyConst, ROpMakeRange, ROpSpread:
+			total += costAlloc
+		case ROpConcat:
+			total += builtinCost("concat", int(inst.Src2)) - costCall
+		case ROpCast:
+			total += builtinCost(castNames[inst.Arg], 1) - costCall
+		case ROpSlice:
+			total += builtinCost("slice", 3) - costCall
+		case ROpCall:
+			total += builtinCost(bc.Constants[inst.Arg].Str, int(inst.Src2))
+		case ROpCallMethod:
+			total += costCall + int(inst.Src2)*costStep
+		case ROpCallLocal:
+			total += costCall + int(inst.Src2)*costStep + fns[inst.Arg]
+		case ROpMakeClosure:
+			total += costAlloc + fns[inst.Arg]
+		default:
+			total += costStep
+		}
+	}
+	return total
+}
+
+func neoCost(bc *NeoBytecode) int {
+	fns := make([]int, len(bc.Functions))
+	for i, f := range bc.Functions {
+		fns[i] = neoChunkCost(f, bc.Functions, fns)
+	}
+	return neoChunkCost(bc, bc.Functions, fns)
+}
+
+func neoChunkCost(bc *NeoBytecode, functions []*NeoBytecode, fns []int) int {
+	total := 0
+	for _, inst := range bc.Instructions {
+		switch inst.Op {
+		case NeoOpDiv, NeoOpMod, NeoOpDivC:
+			total += costDivide
+		case NeoOpDivGC, NeoOpDivCG:
+			total += costGlobal + costDivide
+		case NeoOpGetGlobal, NeoOpGetGlobalJumpIfFalse, NeoOpGetGlobalJumpIfTrue,
+			NeoOpAddGlobal, NeoOpAddConstGlobal, NeoOpEqualGlobalConst, NeoOpGreaterGlobalConst, NeoOpLessGlobalConst, NeoOpNotEqualGlobalConst,
+			NeoOpFusedCompareGlobalConstJumpIfFalse, NeoOpFusedGreaterGlobalConstJumpIfFalse, NeoOpFusedLessGlobalConstJumpIfFalse,
+			NeoOpAddGC, NeoOpSubGC, NeoOpMulGC, NeoOpSubCG, NeoOpMulCG:
+			total += costGlobal + costStep
+		case NeoOpAddGlobalGlobal, NeoOpSubGlobalGlobal, NeoOpMulGlobalGlobal:
+			total += 2*costGlobal + costStep
+		case NeoOpSetGlobal:
+			total += costSetGlobal
+		case NeoOpIndex, NeoOpIn, NeoOpMapGet, NeoOpMapHas, NeoOpMapGetConst:
+			total += costIndex
+		case NeoOpSetIndex, NeoOpMapSet, NeoOpMapDel:
+			total += costSetIndex
+		case NeoOpMakeArray, NeoOpMakeMap, NeoOpCopyConst, NeoOpMakeRange, NeoOpSpread:
+			total += costAlloc
+		case NeoOpConcat:
+			total += builtinCost("concat", int(inst.Arg)) - costCall
+		case NeoOpConcatGC, NeoOpConcatCG:
+			total += costGlobal + builtinCost("concat", 2) - costCall
+		case NeoOpCast:
+			total += builtinCost(castNames[inst.Arg], 1) - costCall
+		case NeoOpSlice:
+			total += builtinCost("slice", 3) - costCall
+		case NeoOpCall:
+			total += builtinCost(bc.Constants[inst.Arg&0xFFFF].Str, int(inst.Arg>>16))
+		case NeoOpCallMethod:
+			total += costCall + int(inst.Arg>>16)*costStep
+		case NeoOpCallLocal:
+			total += costCall + functions[inst.Arg].Params*costStep + fns[inst.Arg]
+		case NeoOpMakeClosure:
+			total += costAlloc + fns[inst.Arg&0xFFFF]
+		case NeoOpReturn:
+		default:
+			total += costStep
+		}
+	}
+	return total
+}
+
+// astCost 按语法树节点估算 AST 解释器的开销，节点与指令的对应关系与 VMCompiler 一致
+func astCost(program Expression) int {
+	fns := map[string]int{}
+	if p, ok := program.(*Program); ok {
+		for _, f := range p.Functions {
+			fns[f.Name.Value] = astChunkCost(f.Body, fns)
+		}
+		program = p.Body
+	}
+	return astChunkCost(program, fns)
+}
+
+func astChunkCost(program Expression, fns map[string]int) int {
+	total := 0
+	walk(program, func(node Node) {
+		switch n := node.(type) {
+		case *Identifier:
+			total += costGlobal
+		case *NumberLiteral, *StringLiteral, *TimeLiteral, *DurationLiteral, *DecimalLiteral, *BooleanLiteral, *PrefixExpression, *IfExpression, *TupleExpression:
+			total += costStep
+		case *InfixExpression:
+			if n.Operator == "/" || n.Operator == "%" {
+				total += costDivide
+			} else if n.Operator == "in" {
+				total += costIndex
+			} else {
+				total += costStep
+			}
+		case *AssignExpression:
+			total += costSetGlobal
+		case *ScoreExpression:
+			total += costStep * (len(n.Conditions) + 1)
+		case *DestructureExpression:
+			total += len(n.Names) * (costIndex + costSetGlobal)
+		case *LetExpression:
+			// walk 会访问绑定名，它不是一次上下文读取
+			total += costStep - costGlobal
+		case *IndexExpression, *OptionalMemberExpression:
+			total += costIndex
+		case *IndexAssignExpression:
+			total += costSetIndex
+		case *ArrayLiteral, *MapLiteral, *LambdaLiteral, *RangeExpression, *SpreadElement:
+			total += costAlloc
+		case *CallExpression:
+			// 同上，函数名不是一次上下文读取
+			name := ""
+			if ident, ok := n.Function.(*Identifier); ok {
+				name = ident.Value
+			}
+			if c, ok := fns[name]; ok {
+				total += costCall + len(n.Arguments)*costStep + c - costGlobal
+				break
+			}
+			total += builtinCost(name, len(n.Arguments)) - costGlobal
+		case *MethodCallExpression:
+			total += costCall + len(n.Arguments)*costStep
+		}
+	})
+	return total
+}
@@ -0,0 +1,34 @@
+package uwasa
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEstimatedCost(t *testing.T) {
+	const light, heavy = `a + 1`, `if a / b > 1 is concat("x", a, b) else is [a, {"k": b}][0]`
+	opts := EngineOptions{OptimizationLevel: OptBasic}
+	for name, newEngine := range backends(opts) {
+		constant, _ := newEngine(`1 + 2 * 3`)
+		l, _ := newEngine(light)
+		h, _ := newEngine(heavy)
+		if c := constant.EstimatedCost(); c != 0 {
+			t.Errorf("%s: constant rule should cost 0, got %d", name, c)
+		}
+		if l.EstimatedCost() <= 0 || l.EstimatedCost() >= h.EstimatedCost() {
+			t.Errorf("%s: expected 0 < cost(%s)=%d < cost(%s)=%d", name, light, l.EstimatedCost(), heavy, h.EstimatedCost())
+		}
+
+		limited := opts
+		limited.MaxCost = h.EstimatedCost() - 1
+		newLimited := backends(limited)[name]
+		if _, err := newLimited(light); err != nil {
+			t.Errorf("%s: light rule should be within MaxCost: %v", name, err)
+		}
+		_, err := newLimited(heavy)
+		var limitErr *CostLimitError
+		if !errors.As(err, &limitErr) || limitErr.Cost != h.EstimatedCost() || limitErr.Limit != limited.MaxCost {
+			t.Errorf("%s: expected *CostLimitError, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Span 为规则源码中的一段，Start 与 End 为字节偏移（不含 End）
+type Span struct {
+	Start, End int
+}
+
+// DeadBranch 是编译期因条件为常量而被移除的分支
+type DeadBranch struct {
+	// Span 为分支在传给 NewEngine 等函数的源码中的位置，注解计算在内
+	Span Span
+	// Source 为该段源码，从 is、then、else 或 elif 起，如 `else is "legacy"`
+	Source string
+}
+
+// DeadBranches 返回编译期常量折叠移除的 if 分支，按在源码中的位置排列，便于规则作者确认哪些写法不会执行：
+// `if false is a else is b` 移除 `is a`，`if 1 < 2 is a else is b` 移除 `else is b`。
+// 被移除的分支中再次移除的分支不单独列出。AST 解释器与两种标准 VM 在 OptimizationLevel 为 OptBasic 及以上时折叠，
+// NeoVM 总是折叠且按真值判断任意常量条件（如 `if 1 is`）；从字节码包加载的引擎返回 nil
+func (e *Engine) DeadBranches() []DeadBranch {
+	return e.deadBranches
+}
+
+// foldedBranches 返回 ifs 中条件已被 Fold 折叠为布尔字面量的 if 所移除的分支。
+// Fold 原地改写 if 的条件，因此在折叠之后检查解析时记下的 if 即可，无需改动 Fold
+func foldedBranches(ifs []*IfExpression) []Span {
+	var spans []Span
+	for _, n := range ifs {
+		cond, ok := n.Condition.(*BooleanLiteral)
+		if !ok {
+			continue
+		}
+		if !cond.Value && n.Consequence != nil {
+			spans = append(spans, n.ConsequenceSpan)
+		} else if cond.Value && n.Alternative != nil {
+			spans = append(spans, n.AlternativeSpan)
+		}
+	}
+	return spans
+}
+
+// deadBranchesOf 把 spans 整理为按位置排列的 DeadBranch：去掉零值与被其他分支包含的分支，并从 src 中截取源码
+func deadBranchesOf(src string, spans []Span) []DeadBranch {
+	slices.SortFunc(spans, func(a, b Span) int {
+		return cmp.Or(cmp.Compare(a.Start, b.Start), cmp.Compare(b.End, a.End))
+	})
+	var out []DeadBranch
+	for _, s := range spans {
+		if s.End <= s.Start {
+			continue
+		}
+		if n := len(out); n > 0 && s.End <= out[n-1].Span.End {
+			continue
+		}
+		out = append(out, DeadBranch{Span: s, Source: src[s.Start:s.End]})
+	}
+	return out
+}
+
+// recordDeadBranches 在编译结束后为 *e 记下常量折叠移除的分支，由 newEngineAST 与 newEngineVM 以 defer 调用。
+// 未开启 OptBasic 时不做折叠，也就没有被移除的分支
+func (p *Parser) recordDeadBranches(e **Engine, input string, opts EngineOptions) {
+	if *e != nil && opts.OptimizationLevel >= OptBasic {
+		(*e).deadBranches = deadBranchesOf(input, foldedBranches(p.branches))
+	}
+}
@@ -0,0 +1,50 @@
+package uwasa
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDeadBranches(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`if false is "a" else is "b"`, []string{`is "a"`}},
+		{`if 1 < 2 is "a" else is "b"`, []string{`else is "b"`}},
+		{`if false then x = 1`, []string{`then x = 1`}},
+		{`if x > 1 is "a" elif true is "b" else if y is "c" else is "d"`, []string{`else if y is "c" else is "d"`}},
+		{"if false is (if true is 1 else is 2)\nelse is 3", []string{`is (if true is 1 else is 2)`}},
+		{`if x > 1 is "a" else is "b"`, nil},
+		{`if true is "a"`, nil},
+	}
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			var got []string
+			for _, d := range engine.DeadBranches() {
+				if tt.input[d.Span.Start:d.Span.End] != d.Source {
+					t.Errorf("%s %s: span %v does not match %q", name, tt.input, d.Span, d.Source)
+				}
+				got = append(got, d.Source)
+			}
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("%s %s: expected %q, got %q", name, tt.input, tt.expected, got)
+			}
+		}
+	}
+
+	// 位置相对于包含注解的完整源码
+	input := "@name(\"legacy\")\nif false is \"old\" else is \"new\""
+	engine, err := NewEngine(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dead := engine.DeadBranches(); len(dead) != 1 || input[dead[0].Span.Start:dead[0].Span.End] != `is "old"` {
+		t.Errorf("expected is \"old\" in the full source, got %+v", dead)
+	}
+	// 未开启 OptBasic 时不折叠
+	engine, _ = NewEngineWithOptions(`if false is 1 else is 2`, EngineOptions{})
+	if dead := engine.DeadBranches(); dead != nil {
+		t.Errorf("expected no dead branches without folding, got %+v", dead)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+// VarWrite 是调试执行中的一次全局变量写入
+type VarWrite struct {
+	Step  int    // 本次执行中的第几次写入，从 0 开始
+	Name  string // 被写入的变量名
+	Value any    // 写入时的值；数组与映射为深拷贝，不受之后的修改影响
+	// PC 为写入指令在所在字节码块中的位置，与 RuntimeError.PC 的约定一致；AST 解释器没有指令，为 -1
+	PC int
+	// Function 为写入所在的规则内函数，lambda 中为 "<lambda>"，主程序中为空
+	Function string
+}
+
+// DebugTrace 是 Engine.Debug 的结果：执行结果与按发生顺序排列的全部全局写入
+type DebugTrace struct {
+	Result any
+	Writes []VarWrite
+}
+
+// History 返回对 name 的全部写入，按发生顺序排列，可用于查看某个变量在一长串语句中的演变
+func (t *DebugTrace) History(name string) []VarWrite {
+	var out []VarWrite
+	for _, w := range t.Writes {
+		if w.Name == name {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// debugContext 在 MapContext 之上记录每次写入。它不是 *MapContext，各 VM 因此走通用路径，
+// 全局写入都经过 setGlobal，由其在 Set 之前留下写入指令的位置
+type debugContext struct {
+	MapContext
+	pc     int
+	fn     string
+	writes []VarWrite
+}
+
+func (c *debugContext) Set(name string, value any) error {
+	c.writes = append(c.writes, VarWrite{Step: len(c.writes), Name: name, Value: deepCopyContainer(value), PC: c.pc, Function: c.fn})
+	c.pc, c.fn = -1, ""
+	return c.MapContext.Set(name, value)
+}
+
+// setGlobal 是各 VM 通用路径中写入全局变量的唯一入口：ctx 为调试上下文时先记下写入指令所在的块与位置
+func setGlobal(ctx Context, fn string, pc int, name string, value any) error {
+	if d, ok := ctx.(*debugContext); ok {
+		d.fn, d.pc = fn, pc
+	}
+	return ctx.Set(name, value)
+}
+
+// Debug 以调试模式执行规则：与 Execute 相同地读写 vars，同时记录每次全局写入及写入它的指令位置。
+// 调试执行不走各 VM 的映射快速路径，也不会被录制，只应用于排查规则
+func (e *Engine) Debug(vars map[string]any) (*DebugTrace, error) {
+	if vars == nil {
+		vars = make(map[string]any)
+	}
+	ctx := &debugContext{MapContext: MapContext{vars: vars}, pc: -1}
+	res, err := e.ExecuteWithContext(ctx)
+	return &DebugTrace{Result: res, Writes: ctx.writes}, err
+}
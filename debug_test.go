@@ -0,0 +1,56 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDebug(t *testing.T) {
+	const rule = `fn bump(x) => score = score + x; score = 1; score = score * 10; bump(5); tags = ["a"]; tags[0] = "b"; score`
+	for name, engine := range allEngines(t, rule, EngineOptions{OptimizationLevel: OptBasic}) {
+		vars := map[string]any{"score": int64(0)}
+		trace, err := engine.Debug(vars)
+		if err != nil || trace.Result != int64(15) || vars["score"] != int64(15) {
+			t.Fatalf("%s: expected 15, got %v (%v, vars %v)", name, trace.Result, err, vars)
+		}
+		var scores []any
+		for i, w := range trace.History("score") {
+			scores = append(scores, w.Value)
+			if fn := w.Function; (i == 2) != (fn == "bump") && name != "AST" {
+				t.Errorf("%s: write %d reported in function %q", name, i, fn)
+			}
+		}
+		if !reflect.DeepEqual(scores, []any{int64(1), int64(10), int64(15)}) {
+			t.Errorf("%s: unexpected score history %v", name, scores)
+		}
+		// 下标赋值原地修改数组、不是全局写入；记录中的数组是写入时的副本，不受其影响
+		tags := trace.History("tags")
+		if len(tags) != 1 || !reflect.DeepEqual(tags[0].Value, []any{"a"}) || !reflect.DeepEqual(vars["tags"], []any{"b"}) {
+			t.Errorf("%s: unexpected tags history %+v", name, tags)
+		}
+		for i, w := range trace.Writes {
+			if w.Step != i {
+				t.Errorf("%s: write %d has step %d", name, i, w.Step)
+			}
+			if (w.PC < 0) != (name == "AST") {
+				t.Errorf("%s: write %d has pc %d", name, i, w.PC)
+			}
+		}
+	}
+
+	// 写入的 pc 指向所在块中的 SETG
+	vm, _ := NewEngineVMWithOptions(`score = 1; score = score + 2`, EngineOptions{OptimizationLevel: OptBasic})
+	trace, _ := vm.Debug(nil)
+	for _, w := range trace.Writes {
+		if op := vm.bytecode.Instructions[w.PC].Op; op != OpSetGlobal {
+			t.Errorf("expected pc %d to be %s, got %s", w.PC, OpSetGlobal, op)
+		}
+	}
+	constant, _ := NewEngineVM(`1 + 2`)
+	if trace, err := constant.Debug(nil); err != nil || trace.Result != int64(3) || len(trace.Writes) != 0 {
+		t.Errorf("expected a constant rule to record no writes, got %+v (%v)", trace, err)
+	}
+}
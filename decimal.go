@@ -0,0 +1,299 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal 是任意精度的十进制数，值为 coef × 10^-scale。金额等不能容忍 float64 舍入误差的数值以它在规则中流转：
+// 加减乘的结果是精确的，小数位数按参与运算的数保留（1.50 + 1.25 为 3.75，1.5 × 1.5 为 2.25）；
+// 除法不能整除时保留 DivisionPrecision 位小数并四舍五入。零值为 0。
+type Decimal struct {
+	coef  *big.Int // nil 即 0；运算总是创建新的 big.Int，不修改已有的值
+	scale int32
+}
+
+// DivisionPrecision 为十进制数除法不能整除时保留的小数位数，两侧中小数位数更多的一方超过它时以其为准
+const DivisionPrecision = 16
+
+// maxDecimalExponent 为 ParseDecimal 接受的指数的最大绝对值，避免 1e999999999 这样的文本构造出巨大的数
+const maxDecimalExponent = 1000
+
+// NewDecimal 返回 unscaled × 10^-scale，如 NewDecimal(1999, 2) 为 19.99
+func NewDecimal(unscaled int64, scale int32) Decimal {
+	d := Decimal{coef: big.NewInt(unscaled), scale: scale}
+	if scale < 0 {
+		d.coef.Mul(d.coef, pow10(-scale))
+		d.scale = 0
+	}
+	return d
+}
+
+// ParseDecimal 解析十进制数的文本，如 "19.99"、"-0.5"、"1.2e3"，小数位数按原文保留（"1.50" 为两位）
+func ParseDecimal(s string) (Decimal, error) {
+	mant, exp := s, int64(0)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		var err error
+		mant = s[:i]
+		if exp, err = strconv.ParseInt(s[i+1:], 10, 32); err != nil || exp < -maxDecimalExponent || exp > maxDecimalExponent {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+	}
+	digits := strings.TrimLeft(mant, "+-")
+	if len(mant)-len(digits) > 1 {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	intPart, frac, _ := strings.Cut(digits, ".")
+	digits = intPart + frac
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	coef, _ := new(big.Int).SetString(digits, 10)
+	if strings.HasPrefix(mant, "-") {
+		coef.Neg(coef)
+	}
+	scale := int64(len(frac)) - exp
+	if scale < 0 {
+		coef.Mul(coef, pow10(int32(-scale)))
+		scale = 0
+	}
+	if scale > math.MaxInt32 {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal{coef: coef, scale: int32(scale)}, nil
+}
+
+// pow10 返回 10^n
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// int 返回系数，零值返回 0
+func (d Decimal) int() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// rescale 返回 d 在 scale 位小数下的系数，scale 不小于 d.scale
+func (d Decimal) rescale(scale int32) *big.Int {
+	if scale == d.scale {
+		return d.int()
+	}
+	return new(big.Int).Mul(d.int(), pow10(scale-d.scale))
+}
+
+// Add 返回 d + o
+func (d Decimal) Add(o Decimal) Decimal {
+	scale := max(d.scale, o.scale)
+	return Decimal{coef: new(big.Int).Add(d.rescale(scale), o.rescale(scale)), scale: scale}
+}
+
+// Sub 返回 d - o
+func (d Decimal) Sub(o Decimal) Decimal {
+	scale := max(d.scale, o.scale)
+	return Decimal{coef: new(big.Int).Sub(d.rescale(scale), o.rescale(scale)), scale: scale}
+}
+
+// Mul 返回 d × o，小数位数为两侧之和
+func (d Decimal) Mul(o Decimal) Decimal {
+	return Decimal{coef: new(big.Int).Mul(d.int(), o.int()), scale: d.scale + o.scale}
+}
+
+// Div 返回 d ÷ o。结果保留 DivisionPrecision 位小数并四舍五入，再去掉多余的 0，
+// 但不少于两侧中较多的小数位数，如 10.00 ÷ 4 为 2.50，1 ÷ 3 为 0.3333333333333333。o 为 0 时 panic
+func (d Decimal) Div(o Decimal) Decimal {
+	if o.Sign() == 0 {
+		panic("uwasa: decimal division by zero")
+	}
+	keep := max(d.scale, o.scale)
+	scale := max(keep, DivisionPrecision)
+	num := new(big.Int).Mul(d.int(), pow10(scale-d.scale+o.scale))
+	den := o.int()
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() != 0 && new(big.Int).Lsh(r, 1).CmpAbs(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign()*den.Sign())))
+	}
+	ten, rem := big.NewInt(10), new(big.Int)
+	for scale > keep {
+		if t, _ := new(big.Int).QuoRem(q, ten, rem); rem.Sign() == 0 {
+			q, scale = t, scale-1
+			continue
+		}
+		break
+	}
+	return Decimal{coef: q, scale: scale}
+}
+
+// Neg 返回 -d
+func (d Decimal) Neg() Decimal {
+	return Decimal{coef: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+// Sign 返回 -1、0 或 1
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+// Cmp 比较 d 与 o，返回 -1、0 或 1。小数位数不影响大小，1.5 与 1.50 相等
+func (d Decimal) Cmp(o Decimal) int {
+	scale := max(d.scale, o.scale)
+	return d.rescale(scale).Cmp(o.rescale(scale))
+}
+
+// String 按原有的小数位数返回十进制文本，如 19.90、-0.05，不使用指数
+func (d Decimal) String() string {
+	c := d.int()
+	digits := new(big.Int).Abs(c).String()
+	sign := ""
+	if c.Sign() < 0 {
+		sign = "-"
+	}
+	if d.scale == 0 {
+		return sign + digits
+	}
+	if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	n := len(digits) - int(d.scale)
+	return sign + digits[:n] + "." + digits[n:]
+}
+
+// Float64 返回最接近 d 的浮点数
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// truncInt 向零取整为 int64，超出范围时返回 false
+func (d Decimal) truncInt() (int64, bool) {
+	i := d.int()
+	if d.scale > 0 {
+		i = new(big.Int).Quo(i, pow10(d.scale))
+	}
+	return i.Int64(), i.IsInt64()
+}
+
+// parseDecimalLiteral 解析 1.23d 形式的十进制数字面量，两种前端共用；数字中可以用 _ 分隔。
+// 十进制数字面量须在 EngineOptions.Decimal 开启时使用
+func parseDecimalLiteral(lit string, enabled bool) (*DecimalLiteral, error) {
+	if !enabled {
+		return nil, fmt.Errorf("decimal literal %s requires EngineOptions.Decimal", lit)
+	}
+	d, err := ParseDecimal(strings.ReplaceAll(strings.TrimSuffix(lit, "d"), "_", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid decimal literal %s", lit)
+	}
+	return &DecimalLiteral{Value: d, Literal: lit}, nil
+}
+
+// toDecimal 把十进制数、整数或有限的浮点数转换为十进制数。浮点数按其最短的十进制表示转换，0.1 即 0.1d
+func toDecimal(v Value) (Decimal, bool) {
+	switch v.Type {
+	case ValDecimal:
+		return v.Obj.(Decimal), true
+	case ValInt:
+		return NewDecimal(int64(v.Num), 0), true
+	case ValFloat:
+		f := math.Float64frombits(v.Num)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return Decimal{}, false
+		}
+		d, err := ParseDecimal(strconv.FormatFloat(f, 'g', -1, 64))
+		return d, err == nil
+	}
+	return Decimal{}, false
+}
+
+// decimalOperands 在至少一侧为十进制数、另一侧为十进制数或数字时返回两侧的十进制值，
+// 供各引擎的算术与比较分派；两侧都不是十进制数时返回 false，不影响原有的整数与浮点数路径
+func decimalOperands(l, r Value) (Decimal, Decimal, bool) {
+	if l.Type != ValDecimal && r.Type != ValDecimal {
+		return Decimal{}, Decimal{}, false
+	}
+	a, okL := toDecimal(l)
+	b, okR := toDecimal(r)
+	return a, b, okL && okR
+}
+
+// arithmeticOps 将四则运算的中缀运算符映射到词法记号，供 AST 求值与常量折叠调用 arithDecimal
+var arithmeticOps = map[string]TokenType{"+": TokenPlus, "-": TokenMinus, "*": TokenAsterisk, "/": TokenSlash}
+
+// arithDecimal 计算十进制数的 + - * /（op 为 TokenPlus 等），两侧不构成十进制运算时返回 false。除数为 0 时返回错误
+func arithDecimal(op TokenType, l, r Value) (Value, bool, error) {
+	a, b, ok := decimalOperands(l, r)
+	if !ok {
+		return Value{}, false, nil
+	}
+	var d Decimal
+	switch op {
+	case TokenPlus:
+		d = a.Add(b)
+	case TokenMinus:
+		d = a.Sub(b)
+	case TokenAsterisk:
+		d = a.Mul(b)
+	case TokenSlash:
+		if b.Sign() == 0 {
+			return Value{}, true, fmt.Errorf("division by zero")
+		}
+		d = a.Div(b)
+	default:
+		return Value{}, false, nil
+	}
+	return Value{Type: ValDecimal, Obj: d}, true, nil
+}
+
+// isDecimalAny 判断 AST 解释器中的值是否为十进制数
+func isDecimalAny(v any) bool {
+	_, ok := v.(Decimal)
+	return ok
+}
+
+// decimalKey 返回十进制数在 ValueSet 与跳转表中的归一化键，与 Equal 的比较结果一致：
+// 整数值为 ValInt；等于某个浮点数的最短十进制表示时为该 ValFloat；其余为去掉末尾 0 的十进制文本
+func decimalKey(d Decimal) Value {
+	if i, ok := d.truncInt(); ok && NewDecimal(i, 0).Cmp(d) == 0 {
+		return Value{Type: ValInt, Num: uint64(i)}
+	}
+	f := Value{Type: ValFloat, Num: math.Float64bits(d.Float64())}
+	if fd, ok := toDecimal(f); ok && fd.Cmp(d) == 0 {
+		return f
+	}
+	s := d.String()
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return Value{Type: ValDecimal, Str: s}
+}
+
+// decimalEqual 判断两个十进制数是否相等
+func decimalEqual(l, r Value) bool {
+	return l.Obj.(Decimal).Cmp(r.Obj.(Decimal)) == 0
+}
+
+// decimalBuiltin 实现内置函数 decimal(x)：把字符串、整数、浮点数转换为十进制数，
+// 便于处理以文本传入的金额（如 JSON 中的 "19.99"）
+func decimalBuiltin(args ...any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("decimal expects 1 argument, got %d", len(args))
+	}
+	if s, ok := args[0].(string); ok {
+		d, err := ParseDecimal(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	if d, ok := toDecimal(FromInterface(args[0])); ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("decimal: cannot convert %s", typeName(args[0]))
+}
@@ -0,0 +1,93 @@
+package uwasa
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`0.1d + 0.2d == 0.3d`, true},
+		{`0.1d + 0.2d`, "0.3"},
+		{`1.50d + 1.25d`, "2.75"},
+		{`price * qty`, "59.97"},
+		{`price * 0.8d`, "15.992"},
+		{`10.00d / 4`, "2.50"},
+		{`1d / 3`, "0.3333333333333333"},
+		{`2d / 3`, "0.6666666666666667"},
+		{`-1.50d`, "-1.50"},
+		{`-price`, "-19.99"},
+		{`1.5e2d`, "150"},
+		{`1_000.50d - 0.5d`, "1000.00"},
+		{`12345678901234567890.12d * 100`, "1234567890123456789012.00"},
+		{`decimal(amount) - 0.01d`, "99.99"},
+		{`price + 0.1`, "20.09"},
+		{`price > 19.98d && price <= 20`, true},
+		{`price == 19.99 && price == 19.990d && price != 20`, true},
+		{`if price * qty >= 50 is "bulk" else is "retail"`, "bulk"},
+		{`typeof(1.5d)`, "decimal"},
+		{`is_number(price)`, true},
+		{`concat("¥", price)`, "¥19.99"},
+		{`int(price)`, int64(19)},
+		{`float(price)`, 19.99},
+		{`bool(0.00d)`, false},
+		{`price in [19.99d, 29.99d]`, true},
+		// 集合查找与跳转表按 Equal 的语义匹配十进制数
+		{`two == 1 || two == 2 || two == 3`, true},
+		{`price == 1 || price == 2 || price == 3`, false},
+		{`price == 19.99 || price == 1.5 || price == 2.5`, true},
+		{`two == 1.5d || two == 2.0d || two == 3d`, true},
+		{`price == 0.5d || price == 19.990d || price == 20d`, true},
+		{`if two == 1 is "one" else if two == 2 is "two" else if two == 3 is "three" else is "other"`, "two"},
+		{`if price == 1 is "one" else if price == 2 is "two" else if price == 3 is "three" else is "other"`, "other"},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"price": NewDecimal(1999, 2), "qty": int64(3), "amount": "100.00", "two": NewDecimal(200, 2)}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic, Decimal: true}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				got, err := engine.ExecuteWithContext(ctx)
+				if d, ok := got.(Decimal); ok {
+					got = d.String()
+				}
+				if err != nil || got != tt.expected {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+		for _, bad := range []string{`price % 2`, `price / (qty - 3)`, `price / (price - price)`, `decimal("abc")`, `1.2.3d`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+
+	// 未开启 EngineOptions.Decimal 时十进制数字面量是编译错误，decimal(x) 仍然可用
+	for name, newEngine := range map[string]func(string) (*Engine, error){"AST": NewEngine, "VM": NewEngineVM, "NeoVM": NewEngineVMNeo} {
+		if _, err := newEngine(`price * 0.8d`); err == nil || !strings.Contains(err.Error(), "EngineOptions.Decimal") {
+			t.Errorf("%s: expected a decimal literal error, got %v", name, err)
+		}
+		engine, err := newEngine(`price * decimal("0.8")`)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err := engine.Execute(vars()); err != nil || fmt.Sprint(got) != "15.992" {
+			t.Errorf("%s: expected 15.992, got %v (%v)", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package uwasa 是一个规则引擎：规则以专用 DSL 编写，可由 AST 解释器、栈 VM、寄存器 VM 或 NeoVM 执行。
+//
+// # 注册与并发
+//
+// RegisterBuiltin、RegisterStringer、RegisterAdapter、RegisterOverloads 与 RegisterOperator 修改进程级的全局注册表，
+// 对此后编译与执行的所有引擎生效。注册表为写时复制：各 Register 函数彼此串行，可以与规则的编译和执行并发调用，
+// 编译与执行期无锁读取注册表的快照。
+//
+// 注册不会影响已经编译的引擎对编译期决定的部分：内置函数、运算符与 Pure 标记在编译时解析，
+// 之后注册的内置函数或运算符只对新编译的规则可见。格式化函数、适配器与运算符重载在执行期查找，
+// 注册后立即作用于所有引擎。为使规则行为可预期，仍建议在 init 或创建任何引擎之前完成注册。
+package uwasa
@@ -0,0 +1,81 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isEmailAddress 判断 s 是否为 `local@domain` 形式的邮箱地址。本地部分为 RFC 5322 的 dot-atom
+// （不支持带引号的写法），域名至少两级，各级由字母、数字与连字符组成，顶级域名至少两个字母
+func isEmailAddress(s string) bool {
+	if len(s) > 254 {
+		return false
+	}
+	local, domain, ok := strings.Cut(s, "@")
+	if !ok || len(local) == 0 || len(local) > 64 || !isDotAtom(local) {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if !isDomainLabel(label) {
+			return false
+		}
+	}
+	tld := labels[len(labels)-1]
+	if len(tld) < 2 {
+		return false
+	}
+	for i := 0; i < len(tld); i++ {
+		if isDigit(tld[i]) || tld[i] == '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// isDotAtom 判断 s 是否由点分隔的非空 atext 串组成
+func isDotAtom(s string) bool {
+	for _, atom := range strings.Split(s, ".") {
+		if atom == "" {
+			return false
+		}
+		for i := 0; i < len(atom); i++ {
+			if c := atom[i]; !isAlnum(c) && !strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", rune(c)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isDomainLabel 判断 s 是否为 1 到 63 个字母、数字或连字符，且不以连字符开头或结尾
+func isDomainLabel(s string) bool {
+	if len(s) == 0 || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !isAlnum(c) && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c)
+}
+
+// isEmail 实现内置函数 isEmail(s)：s 是否为邮箱地址，不是字符串时为 false
+func isEmail(args ...any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("isEmail expects 1 argument, got %d", len(args))
+	}
+	s, ok := args[0].(string)
+	return ok && isEmailAddress(s), nil
+}
@@ -0,0 +1,28 @@
+package uwasa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsEmail(t *testing.T) {
+	valid := []string{"madoka@example.com", "a.b+tag@mail.example.co.jp", "x_y@sub-domain.example.org", "o'neil@example.io"}
+	invalid := []string{"", "madoka", "@example.com", "madoka@", "madoka@example", "madoka@@example.com", "a..b@example.com",
+		".a@example.com", "madoka@-example.com", "madoka@example.c", "madoka@example.123", "mado ka@example.com",
+		"madoka@exa_mple.com", "Madoka <madoka@example.com>", strings.Repeat("a", 65) + "@example.com"}
+	for name, engine := range allEngines(t, `isEmail(email)`, EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, cases := range []struct {
+			emails []string
+			want   bool
+		}{{valid, true}, {invalid, false}} {
+			for _, email := range cases.emails {
+				if got, err := engine.Execute(map[string]any{"email": email}); err != nil || got != cases.want {
+					t.Errorf("%s isEmail(%q): expected %v, got %v (%v)", name, email, cases.want, got, err)
+				}
+			}
+		}
+		if got, err := engine.Execute(map[string]any{"email": int64(1)}); err != nil || got != false {
+			t.Errorf("%s isEmail(1): expected false, got %v (%v)", name, got, err)
+		}
+	}
+}
@@ -4,6 +4,7 @@
 package uwasa
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -14,10 +15,49 @@ const (
 	OptBasic
 )
 
+// BranchHint 描述条件分支的预期走向，用于 VM 编译期的代码块重排。
+// 默认布局已让 consequence 顺序执行，因此只提供 BranchLikelyFalse，不另设“更可能为真”的提示。
+type BranchHint int
+
+const (
+	BranchUnknown BranchHint = iota
+	BranchLikelyFalse
+)
+
 type EngineOptions struct {
 	OptimizationLevel OptimizationLevel
 	UseRecompiler     bool
 	UseRegisterVM     bool // Experimental: use register-based VM
+	// BranchHints 以条件表达式的源码为键（如 "a > 10"），标记为 BranchLikelyFalse 的分支会将 else 分支
+	// 排布为顺序执行路径。键与规则一样经过解析和常量折叠后才与条件比较，写法不必逐字一致；无法解析的键是编译错误。
+	// 栈 VM 与寄存器 VM 支持分支提示，AST 解释器没有代码布局，忽略它们；NeoVM 在设置了提示时返回错误。
+	BranchHints map[string]BranchHint
+	// MaxConcatBytes 限制单次执行中拼接产生的字符串累计字节数，concat 的输出与结果为字符串的 `+`
+	// 都计入，执行中调用的 lambda 与创建它的执行共用同一份额度；超出时返回 *ConcatLimitError，0 表示不限制。
+	MaxConcatBytes int
+	// Replay 配置执行录制，零值表示不录制，见 ReplayOptions
+	Replay ReplayOptions
+	// MaxCost 限制规则的静态开销（见 Engine.EstimatedCost），超出时编译返回 *CostLimitError；
+	// 0 表示不限制。
+	MaxCost int
+	// HashSeed 为 bucket 与 inRollout 的哈希种子，在编译期作为最后一个实参写入调用。
+	// 不同实验使用不同的种子，同一批用户在各实验中的分桶互不相关；空串即默认种子。
+	HashSeed string
+	// OperandLogic 为 true 时 `&&` 与 `||` 像 JavaScript、Python 一样返回操作数而不是布尔值：
+	// `a || "默认"` 在 a 为真时返回 a 本身，否则返回 "默认"；`a && b` 在 a 为假（nil 或 false）时返回 a，否则返回 b。
+	// 短路求值不变，默认关闭时两者总是返回 true 或 false。
+	OperandLogic bool
+	// InputDocument 为 true 时按 OPA/Rego 的 input 文档书写规则：上下文即输入文档，`input.name` 读取变量 name，
+	// 不带括号的 `.name` 是成员访问，如 `input.request.user.role == "admin"`。与 Rego 的 undefined 类似，
+	// 路径中途缺失或不是映射时结果为 nil 而不是错误。input 被 let 绑定或参数遮蔽时按普通变量处理。
+	InputDocument bool
+	// Decimal 为 true 时接受 `1.23d` 形式的十进制数字面量（见 Decimal），金额等数值以十进制精确运算，
+	// 不经过 float64。未开启时这样的字面量是编译错误；宿主传入的 Decimal 与 decimal(x) 不受此选项限制。
+	Decimal bool
+	// NumberFormat 为数字字面量的书写格式，供规则作者直接粘贴按地区习惯书写的数字：NumberGrouped 接受 1,234,567.89，
+	// NumberDecimalComma 接受 1.234.567,89。两者都以 , 为分隔符，开启后函数实参与数组元素之间的逗号应后跟空格，
+	// 如 `max(1, 234)`；`max(1,234)` 在 NumberGrouped 中是 max(1234)。零值 NumberDefault 不改变原有的写法。
+	NumberFormat NumberFormat
 }
 
 type Engine struct {
@@ -27,6 +67,20 @@ type Engine struct {
 	neoBytecode      *NeoBytecode
 	constantResult   any
 	isConstant       bool
+	maxConcatBytes   int
+	// bound 与 boundState 由 Bind 设置，供 ExecuteBound 反复使用
+	bound      Context
+	boundState *RunState
+	// hash 为规则源码的哈希，recorder 非 nil 时按采样录制执行
+	hash     string
+	recorder *recorder
+	meta     Metadata
+	// source 为去掉注解后的规则源码，供 NewAggregator 拆分聚合调用；从字节码包加载的引擎为空
+	source string
+	// opts 为编译时的选项，NewRulePlan 按它重新解析 source
+	opts EngineOptions
+	// deadBranches 为常量折叠移除的分支，见 DeadBranches
+	deadBranches []DeadBranch
 }
 
 func NewEngine(input string) (*Engine, error) {
@@ -34,15 +88,49 @@ func NewEngine(input string) (*Engine, error) {
 }
 
 func NewEngineWithOptions(input string, opts EngineOptions) (*Engine, error) {
+	return newEngine(input, opts, newEngineAST)
+}
+
+// newEngine 剥离规则开头的注解块后交给 compile 编译，再附加元数据与执行录制
+func newEngine(input string, opts EngineOptions, compile func(string, EngineOptions) (*Engine, error)) (*Engine, error) {
+	meta, body, err := parseAnnotations(input)
+	if err != nil {
+		return nil, err
+	}
+	e, err := compile(body, opts)
+	if err != nil {
+		return nil, err
+	}
+	if cost := e.EstimatedCost(); opts.MaxCost > 0 && cost > opts.MaxCost {
+		return nil, &CostLimitError{Limit: opts.MaxCost, Cost: cost}
+	}
+	e.meta, e.source, e.opts, e.maxConcatBytes = meta, body, opts, opts.MaxConcatBytes
+	// 编译时的位置相对于去掉注解后的正文，换算为相对于完整源码
+	for i := range e.deadBranches {
+		e.deadBranches[i].Span.Start += len(input) - len(body)
+		e.deadBranches[i].Span.End += len(input) - len(body)
+	}
+	e.attachReplay(input, opts.Replay)
+	return e, nil
+}
+
+func newEngineAST(input string, opts EngineOptions) (e *Engine, err error) {
 	l := NewLexer(input)
 	defer lexerPool.Put(l)
+	l.numbers = opts.NumberFormat
 	p := NewParser(l)
 	defer parserPool.Put(p)
+	defer p.recordDeadBranches(&e, input, opts)
+	p.inputDocument, p.decimal = opts.InputDocument, opts.Decimal
 
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
 		return nil, fmt.Errorf("parser errors: %v", p.Errors())
 	}
+	seedHashCalls(program, opts.HashSeed)
+	if opts.OperandLogic {
+		markOperandLogic(program)
+	}
 
 	var optimized Node = program
 	if opts.OptimizationLevel >= OptBasic {
@@ -62,7 +150,7 @@ func NewEngineWithOptions(input string, opts EngineOptions) (*Engine, error) {
 		return &Engine{program: nil, isConstant: true}, nil
 	}
 
-	engine := &Engine{program: optimized.(Expression)}
+	engine := &Engine{program: optimized.(Expression), maxConcatBytes: opts.MaxConcatBytes}
 
 	switch n := optimized.(type) {
 	case *NumberLiteral, *StringLiteral, *BooleanLiteral:
@@ -75,16 +163,34 @@ func NewEngineWithOptions(input string, opts EngineOptions) (*Engine, error) {
 }
 
 func NewEngineVMNeo(input string) (*Engine, error) {
-	c := NewNeoCompiler(input)
-	bc, err := c.Compile()
+	return NewEngineVMNeoWithOptions(input, EngineOptions{})
+}
+
+// NewEngineVMNeoWithOptions 使用 NeoVM 编译规则。NeoCompiler 自带单趟优化，
+// 因此 opts 中只有与执行期相关的选项（如 MaxConcatBytes）、HashSeed、OperandLogic、InputDocument、Decimal 与 NumberFormat 生效；设置 BranchHints 会返回错误。
+func NewEngineVMNeoWithOptions(input string, opts EngineOptions) (*Engine, error) {
+	return newEngine(input, opts, newEngineNeo)
+}
+
+func newEngineNeo(input string, opts EngineOptions) (*Engine, error) {
+	if len(opts.BranchHints) > 0 {
+		return nil, errors.New("BranchHints are not supported by NeoVM: its single-pass compiler has no condition text to match them against")
+	}
+	c := newNeoCompiler(input, opts.NumberFormat)
+	c.hashSeed, c.operandLogic, c.inputDocument, c.decimal = opts.HashSeed, opts.OperandLogic, opts.InputDocument, opts.Decimal
+	c.noConcatFuse = opts.MaxConcatBytes > 0
+	bc, err := c.compile()
+	dead := deadBranchesOf(input, c.dead)
+	c.Close()
 	if err != nil {
 		return nil, err
 	}
+	bc.MaxConcatBytes = opts.MaxConcatBytes
 	// Constant detection
 	if len(bc.Instructions) == 2 && bc.Instructions[0].Op == NeoOpPush && bc.Instructions[1].Op == NeoOpReturn {
-		return &Engine{constantResult: bc.Constants[bc.Instructions[0].Arg].ToInterface(), isConstant: true}, nil
+		return &Engine{constantResult: bc.Constants[bc.Instructions[0].Arg].ToInterface(), isConstant: true, deadBranches: dead}, nil
 	}
-	return &Engine{neoBytecode: bc}, nil
+	return &Engine{neoBytecode: bc, deadBranches: dead}, nil
 }
 
 func NewEngineVM(input string) (*Engine, error) {
@@ -92,18 +198,32 @@ func NewEngineVM(input string) (*Engine, error) {
 }
 
 func NewEngineVMWithOptions(input string, opts EngineOptions) (*Engine, error) {
+	return newEngine(input, opts, newEngineVM)
+}
+
+func newEngineVM(input string, opts EngineOptions) (e *Engine, err error) {
 	l := NewLexer(input)
 	defer lexerPool.Put(l)
+	l.numbers = opts.NumberFormat
 	p := NewParser(l)
 	defer parserPool.Put(p)
+	defer p.recordDeadBranches(&e, input, opts)
+	p.inputDocument, p.decimal = opts.InputDocument, opts.Decimal
 
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
 		return nil, fmt.Errorf("parser errors: %v", p.Errors())
 	}
+	seedHashCalls(program, opts.HashSeed)
+	if opts.OperandLogic {
+		markOperandLogic(program)
+	}
 
 	if opts.UseRegisterVM {
 		c := NewRegisterCompiler()
+		if c.branchHints, err = branchHintKeys(opts); err != nil {
+			return nil, err
+		}
 		// For now, register VM compiler doesn't have the full optimized pipeline like VMCompiler
 		// But we can manually fold
 		var optimized Node = program
@@ -114,6 +234,9 @@ func NewEngineVMWithOptions(input string, opts EngineOptions) (*Engine, error) {
 		if err != nil {
 			return nil, err
 		}
+		if bc != nil {
+			bc.MaxConcatBytes = opts.MaxConcatBytes
+		}
 		// If the resulting bytecode is just returning a single constant, optimize it
 		if bc != nil && len(bc.Instructions) == 2 && bc.Instructions[0].Op == ROpLoadConst && bc.Instructions[1].Op == ROpReturn {
 			return &Engine{constantResult: bc.Constants[bc.Instructions[0].Arg].ToInterface(), isConstant: true}, nil
@@ -137,6 +260,15 @@ func NewEngineVMWithOptions(input string, opts EngineOptions) (*Engine, error) {
 }
 
 func (e *Engine) Execute(vars map[string]any) (any, error) {
+	if e.recorder != nil {
+		if seed, ok := e.recorder.sample(); ok {
+			return e.record(seed, vars, e.execute)
+		}
+	}
+	return e.execute(vars)
+}
+
+func (e *Engine) execute(vars map[string]any) (any, error) {
 	if e.isConstant {
 		return e.constantResult, nil
 	}
@@ -156,7 +288,7 @@ func (e *Engine) Execute(vars map[string]any) (any, error) {
 	if e.bytecode != nil {
 		return RunVM(e.bytecode, ctx)
 	}
-	return Eval(e.program, ctx)
+	return e.eval(ctx)
 }
 
 func (e *Engine) ExecuteWithContext(ctx Context) (any, error) {
@@ -173,5 +305,96 @@ func (e *Engine) ExecuteWithContext(ctx Context) (any, error) {
 	if e.bytecode != nil {
 		return RunVM(e.bytecode, ctx)
 	}
+	return e.eval(ctx)
+}
+
+// ExecuteWithState 与 Execute 相同，但栈、寄存器帧与各类暂存区均取自调用方持有的 st，
+// 适合在工作协程中为每个协程分配一个 RunState 并反复复用。st 为 nil 时等价于 Execute。
+func (e *Engine) ExecuteWithState(st *RunState, vars map[string]any) (any, error) {
+	if e.isConstant {
+		return e.constantResult, nil
+	}
+	if st == nil {
+		return e.Execute(vars)
+	}
+	if e.recorder != nil {
+		if seed, ok := e.recorder.sample(); ok {
+			return e.record(seed, vars, func(vars map[string]any) (any, error) { return e.executeWithState(st, vars) })
+		}
+	}
+	return e.executeWithState(st, vars)
+}
+
+func (e *Engine) executeWithState(st *RunState, vars map[string]any) (any, error) {
+	if vars == nil {
+		vars = make(map[string]any)
+	}
+	ctx := &st.ctx
+	ctx.vars = vars
+	defer func() { ctx.vars = nil }()
+	return e.executeState(st, ctx)
+}
+
+// Bind 为引擎绑定一个持久的 Context，之后可反复调用 ExecuteBound，
+// 省去每次将变量映射包装为 MapContext 的开销。绑定后执行使用引擎独占的 RunState，
+// 因此同一引擎的 ExecuteBound 不能被多个协程同时调用。
+// 传入 nil 解除绑定。
+func (e *Engine) Bind(ctx Context) {
+	e.bound = ctx
+	if ctx == nil {
+		e.boundState = nil
+		return
+	}
+	if mc, ok := ctx.(*MapContext); ok && mc.vars == nil {
+		mc.vars = make(map[string]any)
+	}
+	if e.boundState == nil {
+		e.boundState = NewRunState()
+	}
+}
+
+// ExecuteBound 以 Bind 绑定的上下文执行规则；尚未绑定时返回错误
+func (e *Engine) ExecuteBound() (any, error) {
+	if e.bound == nil {
+		return nil, fmt.Errorf("no context bound: call Bind first")
+	}
+	if e.isConstant {
+		return e.constantResult, nil
+	}
+	return e.executeState(e.boundState, e.bound)
+}
+
+// executeState 使用 st 中的栈与暂存区执行；*MapContext 走各 VM 的映射快速路径
+func (e *Engine) executeState(st *RunState, ctx Context) (any, error) {
+	mapCtx, isMapCtx := ctx.(*MapContext)
+	if e.neoBytecode != nil {
+		if len(e.neoBytecode.Instructions) == 0 {
+			return nil, nil
+		}
+		if isMapCtx {
+			return runNeoVMMapped(e.neoBytecode, mapCtx.vars, &st.stack, st)
+		}
+		return runNeoVMGeneral(e.neoBytecode, ctx, &st.stack, st)
+	}
+	if e.registerBytecode != nil {
+		if len(e.registerBytecode.Instructions) == 0 {
+			return nil, nil
+		}
+		return runRegisterVM(e.registerBytecode, ctx, &st.registers, st)
+	}
+	if e.bytecode != nil {
+		if len(e.bytecode.Instructions) == 0 {
+			return nil, nil
+		}
+		return runVM(e.bytecode, ctx, &st.stack, st)
+	}
+	return e.eval(ctx)
+}
+
+// eval 以 AST 解释器执行规则，按需附加 concat 输出计数
+func (e *Engine) eval(ctx Context) (any, error) {
+	if e.maxConcatBytes > 0 {
+		return Eval(e.program, &concatBudgetContext{Context: ctx, budget: newConcatBudget(e.maxConcatBytes)})
+	}
 	return Eval(e.program, ctx)
 }
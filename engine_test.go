@@ -0,0 +1,246 @@
+package uwasa
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTupleResult(t *testing.T) {
+	input := `total = a + b, a * b, if a > 1 is "big" else is "small", concat("x", a), if false then 1`
+	expected := []any{int64(5), int64(6), "big", "x2", nil}
+
+	for name, engine := range allEngines(t, input, EngineOptions{OptimizationLevel: OptBasic}) {
+		vars := map[string]any{"a": int64(2), "b": int64(3)}
+		got, err := engine.Execute(vars)
+		if err != nil {
+			t.Errorf("%s: execute error: %v", name, err)
+			continue
+		}
+		res, ok := got.([]any)
+		if !ok || len(res) != len(expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+			continue
+		}
+		for i := range expected {
+			if res[i] != expected[i] {
+				t.Errorf("%s: element %d expected %v, got %v", name, i, expected[i], res[i])
+			}
+		}
+		if vars["total"] != int64(5) {
+			t.Errorf("%s: expected total=5, got %v", name, vars["total"])
+		}
+	}
+}
+
+func TestExecuteBound(t *testing.T) {
+	input := `count = count + 1, if count > 2 is concat("n=", count) else is count`
+	for name, engine := range allEngines(t, input, EngineOptions{OptimizationLevel: OptBasic}) {
+		if _, err := engine.ExecuteBound(); err == nil {
+			t.Errorf("%s: expected error before Bind", name)
+		}
+
+		// The bound context persists across calls: both the map fast path and a custom Context
+		vars := map[string]any{"count": int64(0)}
+		for _, ctx := range []Context{&MapContext{vars: vars}, &benchContext{vars: map[string]any{"count": int64(0)}}} {
+			engine.Bind(ctx)
+			var got any
+			var err error
+			for i := 0; i < 3; i++ {
+				got, err = engine.ExecuteBound()
+				if err != nil {
+					t.Fatalf("%s: execute error: %v", name, err)
+				}
+			}
+			if want := []any{int64(3), "n=3"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %T: expected %v, got %v", name, ctx, want, got)
+			}
+		}
+		if vars["count"] != int64(3) {
+			t.Errorf("%s: expected bound map to hold count=3, got %v", name, vars["count"])
+		}
+
+		// A zero MapContext is usable once bound
+		zero := &MapContext{}
+		engine.Bind(zero)
+		engine.ExecuteBound()
+		if zero.vars == nil {
+			t.Errorf("%s: expected Bind to initialise a zero MapContext", name)
+		}
+
+		engine.Bind(nil)
+		if _, err := engine.ExecuteBound(); err == nil {
+			t.Errorf("%s: expected error after unbinding", name)
+		}
+	}
+}
+
+func TestMaxConcatBytes(t *testing.T) {
+	opts := EngineOptions{OptimizationLevel: OptBasic, MaxConcatBytes: 16}
+	for name, engine := range allEngines(t, `concat(s, concat(s, s, "!"))`, opts) {
+		// Each concat stays below the cap, but the per-execution total exceeds it
+		if got, err := engine.Execute(map[string]any{"s": "ab"}); err != nil || got != "ababab!" {
+			t.Errorf("%s: expected ababab!, got %v (%v)", name, got, err)
+		}
+		_, err := engine.Execute(map[string]any{"s": "abcd"})
+		var limitErr *ConcatLimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != 16 {
+			t.Errorf("%s: expected ConcatLimitError, got %v", name, err)
+		}
+
+		// The budget is reset for every execution
+		if _, err := engine.ExecuteWithState(NewRunState(), map[string]any{"s": "a"}); err != nil {
+			t.Errorf("%s: unexpected error after reset: %v", name, err)
+		}
+	}
+
+	// 字符串 + 与 concat 计入同一份额度，lambda 中的拼接沿用调用方的计数；各后端的总量一致
+	tests := []struct {
+		input string
+		used  int // s = "abcd" 时本次执行拼接产生的总字节数
+	}{
+		{`s + s + s + s`, 8 + 12 + 16},
+		{`"<" + s + ">"`, 5 + 6},
+		{`concat(s, s) + s`, 8 + 12},
+		{`reduce([1, 2, 3], (acc, x) -> acc + s, "")`, 4 + 8 + 12},
+		{`len(map([1, 2, 3], x -> s + s)) + len(s + s)`, 8 * 4},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{tt.used, tt.used - 1} {
+			opts := EngineOptions{OptimizationLevel: OptBasic, MaxConcatBytes: limit}
+			for name, engine := range allEngines(t, tt.input, opts) {
+				_, err := engine.Execute(map[string]any{"s": "abcd"})
+				var limitErr *ConcatLimitError
+				if limit == tt.used && err != nil {
+					t.Errorf("%s %s: expected to fit in %d bytes, got %v", name, tt.input, limit, err)
+				}
+				if limit < tt.used && (!errors.As(err, &limitErr) || limitErr.Size != tt.used) {
+					t.Errorf("%s %s: expected ConcatLimitError at %d bytes, got %v", name, tt.input, tt.used, err)
+				}
+			}
+		}
+	}
+}
+
+func TestOperandLogic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`name || "guest"`, "kyoko"},
+		{`nickname || "guest"`, "guest"},
+		{`nickname || name || "guest"`, "kyoko"},
+		{`off || "default"`, "default"},
+		{`zero || 5`, int64(0)},
+		{`user && user?.name`, "sayaka"},
+		{`nobody && nobody?.name`, nil},
+		{`off && x`, false},
+		{`name && 1`, int64(1)},
+		{`nickname || false`, false},
+		{`nickname && true`, nil},
+		{`"a" || x`, "a"},
+		{`"a" && name`, "kyoko"},
+		{`false || nickname`, nil},
+		{`concat("hi ", nickname || name)`, "hi kyoko"},
+		{`(nickname || 2) * 3`, int64(6)},
+		{`if nickname || name is "yes" else is "no"`, "yes"},
+		{`x == 1 || x == 2 || x == 3`, true},
+		{`1 < zero < 2`, false},
+		{`nickname || (n = 7); n`, int64(7)},
+		{`name || (n = 7); n`, nil},
+	}
+
+	engines := map[string]func(string, EngineOptions) (*Engine, error){
+		"Recompiler": func(s string, opts EngineOptions) (*Engine, error) {
+			opts.UseRecompiler = true
+			return NewEngineWithOptions(s, opts)
+		},
+	}
+	for name := range backends(EngineOptions{}) {
+		engines[name] = func(s string, opts EngineOptions) (*Engine, error) {
+			opts.OptimizationLevel = OptBasic
+			return backends(opts)[name](s)
+		}
+	}
+	for name, newEngine := range engines {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input, EngineOptions{OperandLogic: true})
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{
+				"name": "kyoko", "off": false, "zero": int64(0), "x": int64(3),
+				"user": map[string]any{"name": "sayaka"},
+			}
+			got, err := engine.Execute(vars)
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		// 默认仍返回布尔值
+		engine, err := newEngine(`name || off`, EngineOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err := engine.Execute(map[string]any{"name": "kyoko"}); err != nil || got != true {
+			t.Errorf("%s: expected boolean result without OperandLogic, got %v (%v)", name, got, err)
+		}
+	}
+}
+
+func TestInputDocument(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`input.request.user.role == "admin"`, true},
+		{`input.request.method == "GET" && input.request.path[0] == "api"`, true},
+		{`input.request.user.missing.deeper`, nil},
+		{`input.request.method.length`, nil},
+		{`input.absent.user == nil`, true},
+		{`"admin" in input.roles`, true},
+		{`input.request.user["role"]`, "admin"},
+		{`input.request.user.has("role") && input.request.user.get("role") == "admin"`, true},
+		{`let u = input.request.user => u.role`, "admin"},
+		{`let input = {"x": 1} => input.x`, int64(1)},
+		{`filter(input.roles, r -> r == "ops") |> len`, int64(1)},
+	}
+	opts := EngineOptions{OptimizationLevel: OptBasic, InputDocument: true}
+	vars := func() map[string]any {
+		return map[string]any{
+			"request": map[string]any{"method": "GET", "path": []any{"api", "v1"}, "user": map[string]any{"role": "admin"}},
+			"roles":   []any{"admin", "ops"},
+		}
+	}
+	for name, newEngine := range backends(opts) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+			got, err = engine.ExecuteWithContext(&benchContext{vars: vars()})
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s (context): expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		// 单独的 input、被遮蔽的字段以及把字段当作函数调用都是编译错误
+		for _, bad := range []string{`input`, `input == nil`, `let roles = 1 => input.roles`, `input.len(roles)`} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected compile error", name, bad)
+			}
+		}
+	}
+	// 未开启时 .name 仍须是方法调用
+	if _, err := NewEngineVMNeo(`input.request`); err == nil {
+		t.Errorf("expected an error for member access without InputDocument")
+	}
+	if _, err := NewEngine(`input.request`); err == nil {
+		t.Errorf("expected an error for member access without InputDocument")
+	}
+}
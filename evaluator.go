@@ -5,8 +5,15 @@ package uwasa
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"html"
+	"net/url"
+	"reflect"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -25,7 +32,7 @@ func Eval(node Node, ctx Context) (any, error) {
 	switch n := node.(type) {
 	case *Identifier:
 		val, _ := ctx.Get(n.Value)
-		return val, nil
+		return adaptAny(val), nil
 	case *NumberLiteral:
 		if n.IsInt {
 			return n.Int64Value, nil
@@ -33,6 +40,12 @@ func Eval(node Node, ctx Context) (any, error) {
 		return n.Float64Value, nil
 	case *StringLiteral:
 		return n.Value, nil
+	case *TimeLiteral:
+		return n.Value, nil
+	case *DurationLiteral:
+		return n.Value, nil
+	case *DecimalLiteral:
+		return n.Value, nil
 	case *BooleanLiteral:
 		return boolToAny(n.Value), nil
 	case *PrefixExpression:
@@ -48,12 +61,18 @@ func Eval(node Node, ctx Context) (any, error) {
 				return nil, err
 			}
 			if !isTruthy(left) {
+				if n.ReturnsOperand {
+					return left, nil
+				}
 				return falseVal, nil
 			}
 			right, err := Eval(n.Right, ctx)
 			if err != nil {
 				return nil, err
 			}
+			if n.ReturnsOperand {
+				return right, nil
+			}
 			return boolToAny(isTruthy(right)), nil
 		}
 		if n.Operator == "||" {
@@ -62,12 +81,18 @@ func Eval(node Node, ctx Context) (any, error) {
 				return nil, err
 			}
 			if isTruthy(left) {
+				if n.ReturnsOperand {
+					return left, nil
+				}
 				return trueVal, nil
 			}
 			right, err := Eval(n.Right, ctx)
 			if err != nil {
 				return nil, err
 			}
+			if n.ReturnsOperand {
+				return right, nil
+			}
 			return boolToAny(isTruthy(right)), nil
 		}
 		left, err := Eval(n.Left, ctx)
@@ -78,7 +103,13 @@ func Eval(node Node, ctx Context) (any, error) {
 		if err != nil {
 			return nil, err
 		}
-		return evalInfixExpression(n.Operator, left, right)
+		res, err := evalInfixExpression(n.Operator, left, right)
+		if s, ok := res.(string); ok && n.Operator == "+" {
+			if err := concatBudgetOf(ctx).charge(len(s)); err != nil {
+				return nil, err
+			}
+		}
+		return res, err
 	case *IfExpression:
 		return evalIfExpression(n, ctx)
 	case *AssignExpression:
@@ -88,7 +119,163 @@ func Eval(node Node, ctx Context) (any, error) {
 		}
 		err = ctx.Set(n.Name.Value, val)
 		return val, err
+	case *DestructureExpression:
+		val, err := Eval(n.Value, ctx)
+		if err != nil {
+			return nil, err
+		}
+		m, _ := val.(map[string]any)
+		for _, name := range n.Names {
+			if err := ctx.Set(name.Value, m[name.Value]); err != nil {
+				return nil, err
+			}
+		}
+		if n.Body == nil {
+			return val, nil
+		}
+		return Eval(n.Body, ctx)
+	case *Program:
+		return Eval(n.Body, &funcContext{Context: ctx, funcs: n.Functions})
+	case *LetExpression:
+		val, err := Eval(n.Value, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return Eval(n.Body, &letContext{Context: ctx, name: n.Name.Value, val: val})
+	case *LambdaLiteral:
+		// 外层的 let 绑定与参数位于 ctx 的绑定层中，且不可再赋值，直接引用即为按值捕获
+		return &Closure{params: len(n.Parameters), call: func(args []any) (any, error) {
+			body := ctx
+			for i, param := range n.Parameters {
+				body = &letContext{Context: body, name: param.Value, val: args[i]}
+			}
+			return Eval(n.Body, body)
+		}}, nil
+	case *ArrayLiteral:
+		arr := make([]any, 0, len(n.Elements))
+		for _, el := range n.Elements {
+			spread, ok := el.(*SpreadElement)
+			if ok {
+				el = spread.Value
+			}
+			val, err := Eval(el, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				arr = append(arr, val)
+				continue
+			}
+			items, isArr := val.([]any)
+			if !isArr {
+				return nil, fmt.Errorf("spread expects an array, got %T", val)
+			}
+			arr = append(arr, items...)
+		}
+		return arr, nil
+	case *MapLiteral:
+		m := make(map[string]any, len(n.Keys))
+		for i, keyNode := range n.Keys {
+			key, err := Eval(keyNode, ctx)
+			if err != nil {
+				return nil, err
+			}
+			k, err := mapKey(key)
+			if err != nil {
+				return nil, err
+			}
+			val, err := Eval(n.Values[i], ctx)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = val
+		}
+		return m, nil
+	case *MethodCallExpression:
+		recv, err := Eval(n.Receiver, ctx)
+		if err != nil {
+			return nil, err
+		}
+		args := make([]any, len(n.Arguments))
+		for i, arg := range n.Arguments {
+			val, err := Eval(arg, ctx)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = val
+		}
+		return CallMethodAny(recv, n.Method, args)
+	case *preparedLiteral:
+		return n.Value, nil
+	case *ScoreExpression:
+		acc := scoreZero(n.Weights)
+		for i, cond := range n.Conditions {
+			c, err := Eval(cond, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if isTruthy(c) {
+				w, _ := literalValue(n.Weights[i])
+				acc = acc.Add(w)
+			}
+		}
+		return acc.ToInterface(), nil
+	case *RangeExpression:
+		start, err := Eval(n.Start, ctx)
+		if err != nil {
+			return nil, err
+		}
+		end, err := Eval(n.End, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return makeRange(start, end)
+	case *OptionalMemberExpression:
+		recv, err := Eval(n.Receiver, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := recv.(map[string]any); ok {
+			return adaptAny(m[n.Name]), nil
+		}
+		return nil, nil
+	case *IndexExpression:
+		coll, err := Eval(n.Left, ctx)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := Eval(n.Index, ctx)
+		if err != nil {
+			return nil, err
+		}
+		v, err := IndexAny(coll, idx)
+		return adaptAny(v), err
+	case *IndexAssignExpression:
+		coll, err := Eval(n.Left, ctx)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := Eval(n.Index, ctx)
+		if err != nil {
+			return nil, err
+		}
+		val, err := Eval(n.Value, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return val, SetIndexAny(coll, idx, val)
 	case *CallExpression:
+		if name, ok := definedName(n); ok {
+			_, ok := ctx.Get(name)
+			return boolToAny(ok), nil
+		}
+		if expr, fallback, ok := tryArgs(n); ok {
+			val, err := Eval(expr, ctx)
+			if err != nil {
+				return Eval(fallback, ctx)
+			}
+			return val, nil
+		}
 		args := make([]any, len(n.Arguments))
 		for i, arg := range n.Arguments {
 			val, err := Eval(arg, ctx)
@@ -97,13 +284,50 @@ func Eval(node Node, ctx Context) (any, error) {
 			}
 			args[i] = val
 		}
+		if n.Comprehension {
+			if _, ok := args[0].([]any); !ok {
+				return nil, errComprehensionSource(args[0])
+			}
+		}
 		if ident, ok := n.Function.(*Identifier); ok {
-			if builtin, ok := builtins[ident.Value]; ok {
-				return builtin(args...)
+			if fc, ok := funcsOf(ctx); ok {
+				if i := fc.lookup(ident.Value); i >= 0 {
+					return fc.call(i, args)
+				}
+			}
+			if builtin, ok := builtins.get(ident.Value); ok {
+				res, err := callBuiltin(ident.Value, builtin, args)
+				if s, ok := res.(string); ok && err == nil && ident.Value == "concat" {
+					if err := concatBudgetOf(ctx).charge(len(s)); err != nil {
+						return nil, err
+					}
+				}
+				return adaptAny(res), err
 			}
 			return nil, fmt.Errorf("builtin function not found: %s", ident.Value)
 		}
 		return nil, fmt.Errorf("not a function: %s", n.Function.String())
+	case *TupleExpression:
+		res := make([]any, len(n.Elements))
+		for i, el := range n.Elements {
+			val, err := Eval(el, ctx)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = val
+		}
+		return res, nil
+	case *SequenceExpression:
+		var val any
+		for _, stmt := range n.Statements {
+			var err error
+			if val, err = Eval(stmt, ctx); err != nil {
+				return nil, err
+			}
+		}
+		return val, nil
+	case *sharedRef:
+		return n.eval(ctx)
 	}
 	return nil, nil
 }
@@ -118,6 +342,10 @@ func evalPrefixExpression(operator string, right any) (any, error) {
 			return -r, nil
 		case int:
 			return -int64(r), nil
+		case time.Duration:
+			return -r, nil
+		case Decimal:
+			return r.Neg(), nil
 		}
 		return nil, fmt.Errorf("unknown operator: -%T", right)
 	case "!":
@@ -133,6 +361,31 @@ func evalInfixExpression(operator string, left, right any) (any, error) {
 		return evalArithmetic(operator, left, right)
 	case "==", ">", "<", ">=", "<=":
 		return evalComparison(operator, left, right)
+	case "==*":
+		ls, okL := left.(string)
+		rs, okR := right.(string)
+		if okL && okR {
+			return boolToAny(strings.EqualFold(ls, rs)), nil
+		}
+		return evalComparison("==", left, right)
+	case "!=":
+		eq, err := evalComparison("==", left, right)
+		if err != nil {
+			return nil, err
+		}
+		return boolToAny(!eq.(bool)), nil
+	case "&", "|", "^", "<<", ">>":
+		v, err := FromInterface(left).Bitwise(bitwiseOps[operator], FromInterface(right))
+		if err != nil {
+			return nil, err
+		}
+		return v.ToInterface(), nil
+	case "in":
+		found, err := InAny(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return boolToAny(found), nil
 	}
 	return nil, fmt.Errorf("unknown operator: %T %s %T", left, operator, right)
 }
@@ -162,6 +415,64 @@ func evalArithmetic(operator string, left, right any) (any, error) {
 		if okSL && okSR {
 			return sl + sr, nil
 		}
+		al, okAL := left.([]any)
+		ar, okAR := right.([]any)
+		if okAL && okAR {
+			return concatArrays(al, ar), nil
+		}
+	}
+
+	// 集合之差
+	if operator == "-" {
+		sl, okSL := left.(map[string]struct{})
+		sr, okSR := right.(map[string]struct{})
+		if okSL && okSR {
+			s, _ := setOp(TokenMinus, sl, sr)
+			return s, nil
+		}
+	}
+
+	// 时间与时长的加减与 VM 共用 addTemporal、subTemporal
+	if isTemporalAny(left) || isTemporalAny(right) {
+		var v Value
+		var ok bool
+		switch operator {
+		case "+":
+			v, ok = addTemporal(FromInterface(left), FromInterface(right))
+		case "-":
+			v, ok = subTemporal(FromInterface(left), FromInterface(right))
+		}
+		if ok {
+			return v.ToInterface(), nil
+		}
+		return nil, fmt.Errorf("invalid arithmetic: %T %s %T", left, operator, right)
+	}
+
+	// 注册了 Overloads 的宿主类型与 VM 共用 addHost、subHost
+	if len(overloads.load()) > 0 {
+		var v Value
+		var ok bool
+		switch operator {
+		case "+":
+			v, ok = addHost(FromInterface(left), FromInterface(right))
+		case "-":
+			v, ok = subHost(FromInterface(left), FromInterface(right))
+		}
+		if ok {
+			return v.ToInterface(), nil
+		}
+	}
+
+	// 十进制数与数字的四则运算与 VM 共用 arithDecimal
+	if isDecimalAny(left) || isDecimalAny(right) {
+		v, ok, err := arithDecimal(arithmeticOps[operator], FromInterface(left), FromInterface(right))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return v.ToInterface(), nil
+		}
+		return nil, fmt.Errorf("invalid arithmetic: %T %s %T", left, operator, right)
 	}
 
 	// Mixed or float
@@ -208,7 +519,76 @@ func evalComparison(operator string, left, right any) (any, error) {
 		}
 	}
 
+	// 字符串按字节序比较，与 Go 的字符串比较相同
+	ls, okLS := left.(string)
+	rs, okRS := right.(string)
+	if okLS && okRS {
+		switch operator {
+		case "==": return boolToAny(ls == rs), nil
+		case ">":  return boolToAny(ls > rs), nil
+		case "<":  return boolToAny(ls < rs), nil
+		case ">=": return boolToAny(ls >= rs), nil
+		case "<=": return boolToAny(ls <= rs), nil
+		}
+	}
+
+	// 时间按先后、时长按长短比较，时区不同的同一时刻相等
+	if isTemporalAny(left) && isTemporalAny(right) {
+		if c, ok := compareTemporal(FromInterface(left), FromInterface(right)); ok {
+			switch operator {
+			case "==":
+				return boolToAny(c == 0), nil
+			case ">":
+				return boolToAny(c > 0), nil
+			case "<":
+				return boolToAny(c < 0), nil
+			case ">=":
+				return boolToAny(c >= 0), nil
+			case "<=":
+				return boolToAny(c <= 0), nil
+			}
+		}
+	}
+
+	// 注册了 Compare 的宿主类型、十进制数与数字之间与 VM 共用 compareExtended
+	if len(overloads.load()) > 0 || isDecimalAny(left) || isDecimalAny(right) {
+		if c, ok := compareExtended(FromInterface(left), FromInterface(right)); ok {
+			switch operator {
+			case "==":
+				return boolToAny(c == 0), nil
+			case ">":
+				return boolToAny(c > 0), nil
+			case "<":
+				return boolToAny(c < 0), nil
+			case ">=":
+				return boolToAny(c >= 0), nil
+			case "<=":
+				return boolToAny(c <= 0), nil
+			}
+		}
+	}
+
 	if operator == "==" {
+		la, okLA := left.([]any)
+		ra, okRA := right.([]any)
+		if okLA || okRA {
+			return boolToAny(okLA && okRA && arrayEqual(la, ra)), nil
+		}
+		lm, okLM := left.(map[string]any)
+		rm, okRM := right.(map[string]any)
+		if okLM || okRM {
+			return boolToAny(okLM && okRM && mapEqual(lm, rm)), nil
+		}
+		ls, okLS := left.(map[string]struct{})
+		rs, okRS := right.(map[string]struct{})
+		if okLS || okRS {
+			return boolToAny(okLS && okRS && setEqual(ls, rs)), nil
+		}
+		lb, okLB := left.([]byte)
+		rb, okRB := right.([]byte)
+		if okLB || okRB {
+			return boolToAny(okLB && okRB && bytes.Equal(lb, rb)), nil
+		}
 		return boolToAny(left == right), nil
 	}
 
@@ -221,40 +601,464 @@ var bufferPool = sync.Pool{
 	},
 }
 
+// maxPooledBufferSize 以上的缓冲区用完后直接丢弃，避免超长拼接结果长期占用池内存
+const maxPooledBufferSize = 64 << 10
+
+// joinPooled 使用池化缓冲区拼接字符串。缓冲区由 defer 归还，
+// 即使拼接中途 panic 也恰好归还一次，不会泄漏或被重复放回池中。
+func joinPooled(pool *sync.Pool, parts []string, totalLen int) string {
+	buf := pool.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			pool.Put(buf)
+		}
+	}()
+	buf.Reset()
+	buf.Grow(totalLen)
+	for _, s := range parts {
+		buf.WriteString(s)
+	}
+	return buf.String()
+}
+
+// ConcatLimitError 表示单次执行中拼接产生的字符串累计字节数超过了 EngineOptions.MaxConcatBytes
+type ConcatLimitError struct {
+	Limit int
+	Size  int
+}
+
+func (e *ConcatLimitError) Error() string {
+	return fmt.Sprintf("concat output of %d bytes exceeds limit of %d bytes", e.Size, e.Limit)
+}
+
+// concatBudget 记录一次执行中拼接产生的字符串字节数。concat 与结果为字符串的 + 都经由它计数，
+// 四种后端共用；闭包调用沿用创建它的那次执行的计数。nil 表示不限制
+type concatBudget struct {
+	limit int
+	used  int
+}
+
+// newConcatBudget 在 limit > 0 时返回新的计数，否则返回 nil
+func newConcatBudget(limit int) *concatBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &concatBudget{limit: limit}
+}
+
+// charge 将 n 字节计入本次执行的拼接输出总量
+func (b *concatBudget) charge(n int) error {
+	if b == nil {
+		return nil
+	}
+	b.used += n
+	if b.used > b.limit {
+		return &ConcatLimitError{Limit: b.limit, Size: b.used}
+	}
+	return nil
+}
+
+// chargeString 在 v 为字符串时计入其长度，用于 + 的结果
+func (b *concatBudget) chargeString(v *Value) error {
+	if b == nil || v.Type != ValString {
+		return nil
+	}
+	return b.charge(len(v.Str))
+}
+
+// concatBudgetContext 为 AST 解释器附加单次执行的拼接计数
+type concatBudgetContext struct {
+	Context
+	budget *concatBudget
+}
+
+// letContext 在外层 Context 之上叠加一个 let 绑定；解析器已拒绝对绑定赋值，Set 直接交给外层
+type letContext struct {
+	Context
+	name string
+	val  any
+}
+
+func (c *letContext) Get(name string) (any, bool) {
+	if name == c.name {
+		return c.val, true
+	}
+	return c.Context.Get(name)
+}
+
+// concatBudgetOf 越过 let 绑定层与函数表找到拼接计数，没有时返回 nil
+func concatBudgetOf(ctx Context) *concatBudget {
+	for {
+		switch c := ctx.(type) {
+		case *concatBudgetContext:
+			return c.budget
+		case *letContext:
+			ctx = c.Context
+		case *funcContext:
+			ctx = c.Context
+		default:
+			return nil
+		}
+	}
+}
+
+// funcContext 携带当前可调用的规则内函数。调用函数时函数体只能看到参数与全局变量，
+// 以及先于该函数定义的函数，与各 VM 的静态解析一致
+type funcContext struct {
+	Context
+	funcs []*FunctionLiteral
+}
+
+func (c *funcContext) lookup(name string) int {
+	for i, fn := range c.funcs {
+		if fn.Name.Value == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *funcContext) call(i int, args []any) (any, error) {
+	fn := c.funcs[i]
+	var body Context = &funcContext{Context: c.Context, funcs: c.funcs[:i]}
+	for j, param := range fn.Parameters {
+		body = &letContext{Context: body, name: param.Value, val: args[j]}
+	}
+	return Eval(fn.Body, body)
+}
+
+// funcsOf 越过 let 绑定层找到函数表
+func funcsOf(ctx Context) (*funcContext, bool) {
+	for {
+		switch c := ctx.(type) {
+		case *funcContext:
+			return c, true
+		case *letContext:
+			ctx = c.Context
+		default:
+			return nil, false
+		}
+	}
+}
+
 type BuiltinFunc func(args ...any) (any, error)
 
-var builtins = map[string]BuiltinFunc{
+// definedName 在 call 为 `defined(x)` 时返回变量名 x。各后端据此直接查询 Context.Get 的第二个返回值，
+// 而不是先求出 x 的值：缺失的变量与值为 nil 的变量求值结果相同
+func definedName(call *CallExpression) (string, bool) {
+	if fn, ok := call.Function.(*Identifier); !ok || fn.Value != "defined" || len(call.Arguments) != 1 {
+		return "", false
+	}
+	arg, ok := call.Arguments[0].(*Identifier)
+	if !ok {
+		return "", false
+	}
+	return arg.Value, true
+}
+
+// tryArgs 在 call 为 `try(expr, fallback)` 时返回两个实参。各后端先只求 expr，出错时丢弃错误改求 fallback，
+// 而不是像普通调用那样先求出全部实参
+func tryArgs(call *CallExpression) (Expression, Expression, bool) {
+	if fn, ok := call.Function.(*Identifier); !ok || fn.Value != "try" || len(call.Arguments) != 2 {
+		return nil, nil, false
+	}
+	return call.Arguments[0], call.Arguments[1], true
+}
+
+// callBuiltin 调用内置函数并将其 panic 转换为普通错误，
+// 保证单条规则的异常不会打断调用方或破坏执行期共享的池化资源。
+func callBuiltin(name string, fn BuiltinFunc, args []any) (res any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("builtin %s panicked: %v", name, r)
+		}
+	}()
+	return fn(args...)
+}
+
+var builtins = newTable(map[string]BuiltinFunc{
 	"concat": func(args ...any) (any, error) {
 		// 1. Pre-calculate total length
 		totalLen := 0
 		argStrings := make([]string, len(args))
 		for i, arg := range args {
-			switch v := arg.(type) {
-			case string:
-				argStrings[i] = v
-			case int64:
-				argStrings[i] = fmt.Sprintf("%d", v)
-			case float64:
-				argStrings[i] = fmt.Sprintf("%g", v)
-			case bool:
-				argStrings[i] = fmt.Sprintf("%v", v)
-			default:
-				argStrings[i] = fmt.Sprintf("%v", v)
-			}
+			argStrings[i] = concatText(arg)
 			totalLen += len(argStrings[i])
 		}
 
 		// 2. Use pooled buffer
-		buf := bufferPool.Get().(*bytes.Buffer)
-		buf.Reset()
-		buf.Grow(totalLen)
-		for _, s := range argStrings {
-			buf.WriteString(s)
-		}
-		res := buf.String()
-		bufferPool.Put(buf)
-		return res, nil
+		return joinPooled(&bufferPool, argStrings, totalLen), nil
+	},
+	// len 返回字符串的字符数或数组、映射的元素个数
+	"len": func(args ...any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("len expects 1 argument, got %d", len(args))
+		}
+		switch v := args[0].(type) {
+		case string:
+			return int64(utf8.RuneCountInString(v)), nil
+		case []any:
+			return int64(len(v)), nil
+		case map[string]any:
+			return int64(len(v)), nil
+		case Range:
+			return v.Len(), nil
+		case map[string]struct{}:
+			return int64(len(v)), nil
+		case []byte:
+			return int64(len(v)), nil
+		}
+		return nil, fmt.Errorf("len expects a string, array, map, set, range or bytes, got %T", args[0])
 	},
+	// slice(x, start, end) 截取数组或字符串，负数边界从末尾倒数，越界时截断。边界为整数常量时由各 VM 的 SLICE 指令直接执行
+	"slice": sliceBuiltin,
+	// t(key, args...) 从 SetMessageCatalog 设置的消息目录中取出本地化文本
+	"t": translate,
+	// rate(key, window)、countDistinct(key, value, window) 通过 SetStateStore 设置的存储统计滑动窗口内的事件
+	"rate":          rate,
+	"countDistinct": countDistinct,
+	// geoDistance(lat1, lon1, lat2, lon2) 返回两点间的球面距离（米）；inPolygon(lat, lon, polygon) 判断点是否在多边形内
+	"geoDistance": geoDistance,
+	"inPolygon":   inPolygon,
+	// ipInCIDR(ip, cidr) 判断 IP 地址是否属于网段；isPrivateIP(ip) 判断是否为内网地址
+	"ipInCIDR":    ipInCIDR,
+	"isPrivateIP": isPrivateIP,
+	// glob(s, pattern) 判断 s 是否匹配 * 与 ? 通配模式，亦可写作 s like pattern
+	"glob": glob,
+	// set(a, b, ...) 或 set(array) 创建字符串集合，支持 in、|、&、^ 与 -
+	"set": makeSet,
+	// decimal(x) 把字符串、整数或浮点数转换为任意精度的十进制数
+	"decimal": decimalBuiltin,
+	// bytes(s) 把字符串转换为字节串；hex、base64 编码字节串或字符串，unhex、unbase64 解码为字节串
+	"bytes":    toBytes,
+	"hex":      hexEncode,
+	"unhex":    hexDecode,
+	"base64":   base64Encode,
+	"unbase64": base64Decode,
+	// levenshtein(a, b) 返回两个字符串的编辑距离；similarity(a, b) 返回按编辑距离计的相似度（0 到 1）
+	"levenshtein": levenshtein,
+	"similarity":  similarity,
+	// typeof(x) 返回 x 的类型名；is_int(x) 等判断 x 是否为该类型，is_number 对 int、float 与 decimal 均为 true
+	"typeof":    typeOf,
+	"is_int":    typeIs("is_int", "int"),
+	"is_float":  typeIs("is_float", "float"),
+	"is_number": typeIs("is_number", "int", "float", "decimal"),
+	"is_string": typeIs("is_string", "string"),
+	"is_bool":   typeIs("is_bool", "bool"),
+	"is_array":  typeIs("is_array", "array"),
+	"is_map":    typeIs("is_map", "map"),
+	"is_nil":    typeIs("is_nil", "nil"),
+	// int(x)、float(x)、str(x)、bool(x) 转换 x 的类型，单参数的调用由各 VM 的 CAST 指令直接执行
+	"int":   castBuiltin(castInt),
+	"float": castBuiltin(castFloat),
+	"str":   castBuiltin(castStr),
+	"bool":  castBuiltin(castBool),
+	// isEmail(s) 判断是否为邮箱地址；电话号码的规范化由 phone 子包注册
+	"isEmail": isEmail,
+	// defined(x) 判断上下文中是否存在变量 x，值为 nil 的变量也算存在。实参为变量名时由各后端直接编译，
+	// 其余写法调用到这里时报错
+	"defined": func(args ...any) (any, error) {
+		return nil, fmt.Errorf("defined expects a variable name, e.g. defined(x)")
+	},
+	// try(expr, fallback) 在 expr 执行出错时返回 fallback。两个实参时由各后端直接编译，其余写法调用到这里时报错
+	"try": func(args ...any) (any, error) {
+		return nil, fmt.Errorf("try expects 2 arguments: try(expr, fallback)")
+	},
+	// matchLabels(obj, selector) 判断清单的 metadata.labels 是否满足选择器；hasAnnotation(obj, key) 判断是否有该注解
+	"matchLabels":   matchLabels,
+	"hasAnnotation": hasAnnotation,
+	// bucket(key, n) 把 key 稳定地哈希到 0 到 n-1；inRollout(key, percent) 判断 key 是否在放量比例之内。
+	// 种子由 EngineOptions.HashSeed 配置，见 seedHashCalls
+	"bucket":    bucket,
+	"inRollout": inRollout,
+	// escape_html、escape_url、escape_json 按 concat 的格式取得参数的文本后转义，分别用于
+	// HTML 文本与属性、URL 查询参数、JSON 字符串的引号之内
+	"escape_html": func(args ...any) (any, error) { return escapeText("escape_html", args, html.EscapeString) },
+	"escape_url":  func(args ...any) (any, error) { return escapeText("escape_url", args, url.QueryEscape) },
+	"escape_json": func(args ...any) (any, error) { return escapeText("escape_json", args, jsonStringBody) },
+	// map(items, x -> expr) 返回各元素经 expr 变换后的新数组；filter(items, x -> pred) 返回 pred 为真的元素组成的新数组；
+	// reduce(items, (acc, x) -> expr, init) 从 init 起依次以 expr 的结果更新累加值。lambda 为字面量时由各 VM 内联为循环
+	"map":    iterBuiltin(iterMap),
+	"filter": iterBuiltin(iterFilter),
+	"reduce": iterBuiltin(iterReduce),
+})
+
+// stringers 为 RegisterStringer 注册的格式化函数，键为宿主值的动态类型
+var stringers = newTable(map[reflect.Type]func(Value) string{})
+
+// RegisterStringer 为 Go 类型 T 注册 concat 使用的格式化函数，取代默认的 %v 输出，
+// 例如让 time.Time、decimal 或 []byte 以面向用户的格式拼入字符串。fn 收到的 Value 中 Obj 为原值。
+// 重复注册时后者覆盖前者，已编译的引擎随即改用新的格式。
+func RegisterStringer[T any](fn func(Value) string) {
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	stringers.put(reflect.TypeFor[T](), fn)
+}
+
+// adapters 为 RegisterAdapter 注册的转换函数，键为宿主值的动态类型
+var adapters = newTable(map[reflect.Type]func(any) Value{})
+
+// RegisterAdapter 为 Go 类型 T 注册转换函数：FromInterface 遇到 T 时以其结果作为引擎中的值，
+// 而不是把原值作为 ValObject 原样传递，例如把 decimal.Decimal 转为浮点数、uuid.UUID 与 netip.Addr 转为字符串，
+// 规则因此可以直接对其运算与比较。T 须为具体类型；int64、string、time.Time 等内置支持的类型不经过适配器。
+// 重复注册时后者覆盖前者
+func RegisterAdapter[T any](fn func(T) Value) {
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	adapters.put(reflect.TypeFor[T](), func(v any) Value { return fn(v.(T)) })
+}
+
+// adaptAny 对注册了适配器的宿主值套用适配器、把 []string 等切片转换为 []any（见 hostSlice），
+// 使 AST 解释器读到的值与 VM 经 FromInterface 得到的一致
+func adaptAny(v any) any {
+	if arr := hostSlice(v); arr != nil {
+		return arr
+	}
+	if len(adapters.load()) == 0 {
+		return v
+	}
+	if fn, ok := adapters.get(reflect.TypeOf(v)); ok {
+		return fn(v).ToInterface()
+	}
+	return v
+}
+
+// FormatValue 返回 v 被 concat 拼接时的文本：数字与布尔值按字面输出，
+// 通过 RegisterStringer 注册的类型使用其格式化函数，其余按 %v 输出。
+// 供在引擎之外输出规则结果的代码（如 project 子包写 CSV）与 concat 保持一致
+func FormatValue(v any) string {
+	return concatText(v)
+}
+
+// concatText 返回 v 在 concat 中的文本
+func concatText(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return fmt.Sprintf("%d", v)
+	case float64:
+		return fmt.Sprintf("%g", v)
+	case bool:
+		return fmt.Sprintf("%v", v)
+	}
+	return formatAny(v)
+}
+
+func escapeText(name string, args []any, escape func(string) string) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+	}
+	return escape(concatText(args[0])), nil
+}
+
+// jsonStringBody 返回 s 作为 JSON 字符串时引号之间的部分，<、>、& 同样被转义
+func jsonStringBody(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// formatAny 返回 concat 拼接非字符串、数字、布尔值时使用的文本
+func formatAny(x any) string {
+	if fn, ok := stringers.get(reflect.TypeOf(x)); ok {
+		// 时长等以 Num 存放的类型同样在 Obj 中带上原值
+		v := FromInterface(x)
+		v.Obj = x
+		return fn(v)
+	}
+	switch x := x.(type) {
+	case map[string]struct{}:
+		return setString(x)
+	case []byte:
+		return string(x)
+	case []any:
+		// 与 %v 的 [a b] 格式相同，元素按 concat 的规则格式化，数组中的集合、十进制数等因此与单独拼接时一致
+		parts := make([]string, len(x))
+		for i, e := range x {
+			parts[i] = concatText(e)
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	}
+	return fmt.Sprintf("%v", x)
+}
+
+// pureBuiltins 为无副作用、结果只取决于参数的内置函数。一次执行中参数相同的两次调用结果相同，
+// VM 编译器据此复用重复调用的结果，如 `len(name) > 3 && len(name) < 20` 只调用一次 len；
+// 其余内置函数可能修改参数或上下文，规则中出现对它们的调用时不做复用
+var pureBuiltins = newTable(map[string]bool{
+	"concat":        true,
+	"len":           true,
+	"slice":         true,
+	"escape_html":   true,
+	"escape_url":    true,
+	"escape_json":   true,
+	"geoDistance":   true,
+	"inPolygon":     true,
+	"ipInCIDR":      true,
+	"isPrivateIP":   true,
+	"glob":          true,
+	"set":           true,
+	"decimal":       true,
+	"bytes":         true,
+	"hex":           true,
+	"unhex":         true,
+	"base64":        true,
+	"unbase64":      true,
+	"levenshtein":   true,
+	"similarity":    true,
+	"typeof":        true,
+	"is_int":        true,
+	"is_float":      true,
+	"is_number":     true,
+	"is_string":     true,
+	"is_bool":       true,
+	"is_array":      true,
+	"is_map":        true,
+	"is_nil":        true,
+	"int":           true,
+	"float":         true,
+	"str":           true,
+	"bool":          true,
+	"isEmail":       true,
+	"defined":       true,
+	"try":           true,
+	"matchLabels":   true,
+	"hasAnnotation": true,
+	"bucket":        true,
+	"inRollout":     true,
+})
+
+// BuiltinOptions 为 RegisterBuiltin 注册的内置函数的属性
+type BuiltinOptions struct {
+	// Pure 声明函数无副作用，且结果只取决于参数（同样的参数总是得到同样的结果，
+	// 不读取时间、随机数或外部状态，也不修改传入的数组与映射）。无法保证时保持为 false
+	Pure bool
+}
+
+// RegisterBuiltin 注册名为 name 的内置函数，此后编译的规则可以像 concat 一样调用它。
+// 名称须为合法的标识符，且不能与已有的内置函数重名；注册后只对此后编译的规则可见。
+// 注册表为进程级全局状态，并发约定见包文档
+func RegisterBuiltin(name string, fn BuiltinFunc, opts BuiltinOptions) error {
+	if fn == nil {
+		return fmt.Errorf("builtin %s has no function", name)
+	}
+	l := NewLexer(name)
+	tok, next := l.NextToken(), l.NextToken()
+	lexerPool.Put(l)
+	if tok.Type != TokenIdent || tok.Literal != name || next.Type != TokenEOF {
+		return fmt.Errorf("invalid builtin name %q", name)
+	}
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	if _, ok := builtins.get(name); ok {
+		return fmt.Errorf("builtin %s already registered", name)
+	}
+	// 先发布 Pure 标记：读到函数的编译器随即能读到它
+	if opts.Pure {
+		pureBuiltins.put(name, true)
+	}
+	builtins.put(name, fn)
+	return nil
 }
 
 func toFloat64(v any) (float64, bool) {
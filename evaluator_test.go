@@ -1,9 +1,27 @@
 package uwasa
 
 import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+// del 从注册表中移除 k，供测试撤销注册
+func (t *table[K, V]) del(k K) {
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	m := maps.Clone(t.load())
+	delete(m, k)
+	t.p.Store(&m)
+}
+
 func TestEvaluator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -131,3 +149,225 @@ func TestEvaluatorTypeConversion(t *testing.T) {
 		}
 	}
 }
+
+func TestBuiltinPanicRecovered(t *testing.T) {
+	builtins.put("explode", func(args ...any) (any, error) {
+		panic("boom")
+	})
+	defer builtins.del("explode")
+
+	input := `concat("a", explode(1))`
+	for name, engine := range allEngines(t, input, EngineOptions{}) {
+		_, err := engine.Execute(nil)
+		// VM 后端将原始错误包装在 RuntimeError 中
+		var re *RuntimeError
+		if errors.As(err, &re) {
+			err = re.Err
+		}
+		if err == nil || err.Error() != "builtin explode panicked: boom" {
+			t.Errorf("%s: expected recovered panic error, got %v", name, err)
+		}
+	}
+
+	// The shared buffer pool must still produce correct results afterwards
+	concat, _ := builtins.get("concat")
+	got, err := concat("x", int64(1), true)
+	if err != nil || got != "x1true" {
+		t.Errorf("concat after panic: expected x1true, got %v (%v)", got, err)
+	}
+}
+
+type testTag string
+
+// 注册与编译、执行并发进行时不应出现数据竞争（以 -race 运行）
+func TestRegisterConcurrentWithExecution(t *testing.T) {
+	defer stringers.del(reflect.TypeFor[testTag]())
+	defer adapters.del(reflect.TypeFor[testCelsius]())
+	defer overloads.del(reflect.TypeFor[testTag]())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 50 {
+			RegisterStringer[testTag](func(v Value) string { return "#" + string(v.Obj.(testTag)) })
+			RegisterAdapter(func(c testCelsius) Value { return Value{Type: ValString, Str: fmt.Sprint(float64(c))} })
+			RegisterOverloads(Overloads[testTag]{Compare: func(a, b testTag) int { return strings.Compare(string(a), string(b)) }})
+			RegisterBuiltin(fmt.Sprintf("concurrent%d", i), func(args ...any) (any, error) { return nil, nil }, BuiltinOptions{Pure: true})
+			RegisterOperator(fmt.Sprintf("concurrentop%d", i), OperatorOptions{Builtin: "len", Prefix: true})
+		}
+	}()
+	cmp, err := NewEngineVM(`tag == other`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 50 {
+		e, err := NewEngineVM(`concat(tag, len("ab"))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmp.Execute(map[string]any{"tag": testTag("x"), "other": testTag("y")})
+		res, err := e.Execute(map[string]any{"tag": testTag("x"), "c": testCelsius(1)})
+		if err != nil || (res != "x2" && res != "#x2") {
+			t.Fatalf("unexpected %v, %v", res, err)
+		}
+	}
+	wg.Wait()
+	for i := range 50 {
+		builtins.del(fmt.Sprintf("concurrent%d", i))
+		pureBuiltins.del(fmt.Sprintf("concurrent%d", i))
+		operators.del(fmt.Sprintf("concurrentop%d", i))
+	}
+}
+
+type testCelsius float64
+
+// registerTestBuiltin 注册仅在当前测试中可见的内置函数
+func registerTestBuiltin(t *testing.T, name string, fn BuiltinFunc, opts BuiltinOptions) {
+	t.Helper()
+	if err := RegisterBuiltin(name, fn, opts); err != nil {
+		t.Fatalf("register %s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		builtins.del(name)
+		pureBuiltins.del(name)
+	})
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	registerTestBuiltin(t, "abs", func(args ...any) (any, error) {
+		if len(args) != 1 {
+			return nil, errors.New("abs expects 1 argument")
+		}
+		if v, ok := args[0].(int64); ok && v < 0 {
+			return -v, nil
+		}
+		return args[0], nil
+	}, BuiltinOptions{Pure: true})
+	registerTestBuiltin(t, "bump", func(args ...any) (any, error) {
+		m := args[0].(map[string]any)
+		m["n"] = m["n"].(int64) + 1
+		return m["n"], nil
+	}, BuiltinOptions{})
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		engine, err := newEngine(`abs(a - 5) + (a |> abs)`)
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", name, err)
+		}
+		if got, err := engine.Execute(map[string]any{"a": int64(3)}); err != nil || got != int64(5) {
+			t.Errorf("%s: expected 5, got %v (%v)", name, got, err)
+		}
+
+		// 非纯函数原地修改 m，之后的读取看到新值
+		engine, _ = newEngine(`len(m) + m["n"] + bump(m) + m["n"]`)
+		if got, err := engine.Execute(map[string]any{"m": map[string]any{"n": int64(1)}}); err != nil || got != int64(6) {
+			t.Errorf("%s: expected 6, got %v (%v)", name, got, err)
+		}
+
+		if _, err := newEngine(`fn abs(x) => x; abs(1)`); err == nil {
+			t.Errorf("%s: expected fn shadowing a registered builtin to fail", name)
+		}
+	}
+
+	noop := func(args ...any) (any, error) { return nil, nil }
+	for _, bad := range []string{"", "a b", "1x", "if", "abs", "concat", "x.y"} {
+		if err := RegisterBuiltin(bad, noop, BuiltinOptions{}); err == nil {
+			t.Errorf("%q: expected registration to fail", bad)
+		}
+	}
+	if err := RegisterBuiltin("nilfn", nil, BuiltinOptions{}); err == nil {
+		t.Errorf("expected nil function to be rejected")
+	}
+}
+
+type testMoney struct{ cents int64 }
+
+func TestRegisterStringer(t *testing.T) {
+	RegisterStringer[time.Time](func(v Value) string { return v.Obj.(time.Time).Format("2006-01-02") })
+	RegisterStringer[testMoney](func(v Value) string {
+		m := v.Obj.(testMoney)
+		return fmt.Sprintf("¥%d.%02d", m.cents/100, m.cents%100)
+	})
+	t.Cleanup(func() {
+		stringers.del(reflect.TypeFor[time.Time]())
+		stringers.del(reflect.TypeFor[testMoney]())
+	})
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`concat("due ", day, ", pay ", price)`, "due 2026-03-01, pay ¥12.05"},
+		{`concat(day, "!")`, "2026-03-01!"},
+		{`concat("#", price)`, "#¥12.05"},
+		{`concat(day, price)`, "2026-03-01¥12.05"},
+		{`concat("raw ", raw)`, "raw {7}"},
+		{`kept = day, concat(kept)`, []any{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), "2026-03-01"}},
+	}
+
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			vars := map[string]any{
+				"day":   time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+				"price": testMoney{1205},
+				"raw":   struct{ n int }{7},
+			}
+			got, err := engine.Execute(vars)
+			if err != nil || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+	}
+}
+
+type testDecimal struct {
+	units int64
+	exp   int
+}
+
+func TestRegisterAdapter(t *testing.T) {
+	RegisterAdapter(func(d testDecimal) Value {
+		return FromInterface(float64(d.units) / math.Pow10(d.exp))
+	})
+	RegisterAdapter(func(a netip.Addr) Value { return Value{Type: ValString, Str: a.String()} })
+	t.Cleanup(func() {
+		adapters.del(reflect.TypeFor[testDecimal]())
+		adapters.del(reflect.TypeFor[netip.Addr]())
+	})
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`price * 2`, 25.0},
+		{`price > 12 && price < 13`, true},
+		{`typeof(price)`, "float"},
+		{`addr == "10.0.0.1"`, true},
+		{`addr`, "10.0.0.1"},
+		{`ipInCIDR(addr, "10.0.0.0/8")`, true},
+		{`order["total"] + 1`, 3.5},
+		{`order?.total`, 2.5},
+		{`[x * 10 for x in prices]`, []any{15.0, 2.0}},
+		{`typeof(raw)`, "object"},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{
+			"price":  testDecimal{1250, 2},
+			"addr":   netip.MustParseAddr("10.0.0.1"),
+			"order":  map[string]any{"total": testDecimal{25, 1}},
+			"prices": []any{testDecimal{15, 1}, testDecimal{2, 1}},
+			"raw":    testMoney{1},
+		}
+	}
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				got, err := engine.ExecuteWithContext(ctx)
+				if err != nil || !reflect.DeepEqual(got, tt.expected) {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+}
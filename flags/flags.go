@@ -0,0 +1,213 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package flags 在 uwasa 之上实现功能开关：每个开关有一组取值（variation），
+// 按顺序匹配的定向规则以 uwasa 规则书写，求值结果附带原因码，便于排查某个用户为何得到某个取值：
+//
+//	set, err := flags.Compile(flags.Flag{
+//		Key:        "new-checkout",
+//		Variations: []any{false, true},
+//		Rules: []flags.Rule{
+//			{ID: "staff", When: `role == "staff"`, Variation: 1},
+//			{ID: "rollout", When: `inRollout(user_id, 25)`, Variation: 1},
+//		},
+//	})
+//	ev := set.Evaluate("new-checkout", map[string]any{"user_id": "u-42", "role": "guest"})
+//	// ev.Value, ev.Reason, ev.RuleID
+//
+// 规则中的 bucket 与 inRollout 默认以开关的键为哈希种子，不同开关的放量分组互不相关。
+package flags
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// Flag 定义一个开关
+type Flag struct {
+	// Key 为开关的唯一键，Evaluate 以其查找开关
+	Key string
+	// Variations 为开关可取的值，须为同一类型：bool、int64、float64 或 string（int 视为 int64）
+	Variations []any
+	// Rules 按顺序匹配，第一个条件为真的规则决定取值
+	Rules []Rule
+	// Fallthrough 为没有规则命中时取值的下标
+	Fallthrough int
+	// Off 为 true 时开关关闭，不再匹配规则，总是取 OffVariation
+	Off bool
+	// OffVariation 为开关关闭时取值的下标
+	OffVariation int
+}
+
+// Rule 是一条定向规则
+type Rule struct {
+	// ID 标识规则，命中时记录在 Evaluation.RuleID 中；可以为空
+	ID string
+	// When 为以求值上下文执行的 uwasa 规则，结果为真（不是 nil 或 false）时命中
+	When string
+	// Variation 为命中时取值的下标
+	Variation int
+}
+
+// Reason 说明求值结果的来源
+type Reason string
+
+const (
+	// ReasonOff 表示开关已关闭，取 OffVariation
+	ReasonOff Reason = "OFF"
+	// ReasonRuleMatch 表示命中了一条定向规则
+	ReasonRuleMatch Reason = "RULE_MATCH"
+	// ReasonFallthrough 表示没有规则命中，取 Fallthrough
+	ReasonFallthrough Reason = "FALLTHROUGH"
+	// ReasonFlagNotFound 表示没有该键的开关
+	ReasonFlagNotFound Reason = "FLAG_NOT_FOUND"
+	// ReasonError 表示规则执行出错，错误见 Evaluation.Err
+	ReasonError Reason = "ERROR"
+)
+
+// Evaluation 是一次求值的结果
+type Evaluation struct {
+	// Value 为取值；ReasonFlagNotFound 与 ReasonError 时为 nil
+	Value any
+	// Variation 为取值的下标；ReasonFlagNotFound 与 ReasonError 时为 -1
+	Variation int
+	Reason    Reason
+	// RuleIndex 与 RuleID 为命中的规则，仅在 ReasonRuleMatch 与 ReasonError 时有效，否则 RuleIndex 为 -1
+	RuleIndex int
+	RuleID    string
+	Err       error
+}
+
+// Options 配置开关规则的编译
+type Options struct {
+	// Engine 交给 uwasa.NewEngineVMNeoWithOptions。HashSeed 为空时以各开关的键为种子
+	Engine uwasa.EngineOptions
+}
+
+// Set 是编译好的一组开关，可被多个协程同时使用
+type Set struct {
+	flags map[string]*compiledFlag
+}
+
+type compiledFlag struct {
+	Flag
+	engines []*uwasa.Engine
+}
+
+// Compile 使用默认选项编译各开关
+func Compile(flags ...Flag) (*Set, error) {
+	return CompileWithOptions(flags, Options{})
+}
+
+// CompileWithOptions 按 opts 编译各开关。键为空或重复、取值类型不一致、下标越界以及规则编译失败时返回带开关键的错误
+func CompileWithOptions(flags []Flag, opts Options) (*Set, error) {
+	s := &Set{flags: make(map[string]*compiledFlag, len(flags))}
+	for _, f := range flags {
+		if f.Key == "" {
+			return nil, errors.New("flags: flag with empty key")
+		}
+		if _, ok := s.flags[f.Key]; ok {
+			return nil, fmt.Errorf("flags: duplicate flag %q", f.Key)
+		}
+		cf, err := compileFlag(f, opts)
+		if err != nil {
+			return nil, fmt.Errorf("flags: flag %q: %w", f.Key, err)
+		}
+		s.flags[f.Key] = cf
+	}
+	return s, nil
+}
+
+func compileFlag(f Flag, opts Options) (*compiledFlag, error) {
+	if len(f.Variations) == 0 {
+		return nil, errors.New("no variations")
+	}
+	vars := make([]any, len(f.Variations))
+	for i, v := range f.Variations {
+		if n, ok := v.(int); ok {
+			v = int64(n)
+		}
+		switch v.(type) {
+		case bool, int64, float64, string:
+		default:
+			return nil, fmt.Errorf("variation %d has unsupported type %T", i, v)
+		}
+		if i > 0 && fmt.Sprintf("%T", v) != fmt.Sprintf("%T", vars[0]) {
+			return nil, fmt.Errorf("variation %d is %T, want %T", i, v, vars[0])
+		}
+		vars[i] = v
+	}
+	f.Variations = vars
+	inRange := func(i int) bool { return i >= 0 && i < len(vars) }
+	if !inRange(f.Fallthrough) || !inRange(f.OffVariation) {
+		return nil, fmt.Errorf("variation index out of range (have %d)", len(vars))
+	}
+	engineOpts := opts.Engine
+	if engineOpts.HashSeed == "" {
+		engineOpts.HashSeed = f.Key
+	}
+	cf := &compiledFlag{Flag: f, engines: make([]*uwasa.Engine, len(f.Rules))}
+	cf.Rules = slices.Clone(f.Rules)
+	for i, r := range f.Rules {
+		if !inRange(r.Variation) {
+			return nil, fmt.Errorf("rule %d: variation index %d out of range (have %d)", i, r.Variation, len(vars))
+		}
+		engine, err := uwasa.NewEngineVMNeoWithOptions(r.When, engineOpts)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		cf.engines[i] = engine
+	}
+	return cf, nil
+}
+
+// Keys 按字典序返回所有开关的键
+func (s *Set) Keys() []string {
+	keys := make([]string, 0, len(s.flags))
+	for k := range s.flags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Evaluate 以 ctx 为上下文求值开关 key。规则出错时不再匹配后续规则，返回 ReasonError
+func (s *Set) Evaluate(key string, ctx map[string]any) Evaluation {
+	f, ok := s.flags[key]
+	if !ok {
+		return Evaluation{Variation: -1, Reason: ReasonFlagNotFound, RuleIndex: -1}
+	}
+	if f.Off {
+		return f.result(f.OffVariation, ReasonOff, -1)
+	}
+	for i, engine := range f.engines {
+		v, err := engine.Execute(ctx)
+		if err != nil {
+			return Evaluation{Variation: -1, Reason: ReasonError, RuleIndex: i, RuleID: f.Rules[i].ID,
+				Err: fmt.Errorf("flags: flag %q rule %d: %w", key, i, err)}
+		}
+		if v != nil && v != false {
+			return f.result(f.Rules[i].Variation, ReasonRuleMatch, i)
+		}
+	}
+	return f.result(f.Fallthrough, ReasonFallthrough, -1)
+}
+
+func (f *compiledFlag) result(variation int, reason Reason, rule int) Evaluation {
+	ev := Evaluation{Value: f.Variations[variation], Variation: variation, Reason: reason, RuleIndex: rule}
+	if rule >= 0 {
+		ev.RuleID = f.Rules[rule].ID
+	}
+	return ev
+}
+
+// Get 求值开关 key 并以 T 类型返回取值；开关不存在、规则出错或取值不是 T 类型时返回 fallback
+func Get[T bool | int64 | float64 | string](s *Set, key string, ctx map[string]any, fallback T) T {
+	if v, ok := s.Evaluate(key, ctx).Value.(T); ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,106 @@
+package flags
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	set, err := Compile(
+		Flag{
+			Key:        "new-checkout",
+			Variations: []any{false, true},
+			Rules: []Rule{
+				{ID: "blocked", When: `country == "KP"`, Variation: 0},
+				{ID: "staff", When: `role == "staff"`, Variation: 1},
+				{ID: "rollout", When: `inRollout(user_id, 50)`, Variation: 1},
+			},
+		},
+		Flag{Key: "theme", Variations: []any{"light", "dark", "auto"}, Fallthrough: 2, Off: true, OffVariation: 0},
+		Flag{Key: "limit", Variations: []any{10, 100}, Rules: []Rule{{When: `tier`, Variation: 1}}},
+		Flag{Key: "broken", Variations: []any{1.5, 2.5}, Rules: []Rule{{ID: "call", When: `no_such_fn(x)`, Variation: 1}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key       string
+		ctx       map[string]any
+		value     any
+		reason    Reason
+		ruleID    string
+		variation int
+	}{
+		{"new-checkout", map[string]any{"role": "staff", "country": "KP"}, false, ReasonRuleMatch, "blocked", 0},
+		{"new-checkout", map[string]any{"role": "staff", "country": "JP"}, true, ReasonRuleMatch, "staff", 1},
+		{"theme", map[string]any{}, "light", ReasonOff, "", 0},
+		{"limit", map[string]any{"tier": "gold"}, int64(100), ReasonRuleMatch, "", 1},
+		{"limit", map[string]any{"tier": nil}, int64(10), ReasonFallthrough, "", 0},
+		{"missing", nil, nil, ReasonFlagNotFound, "", -1},
+	}
+	for _, tt := range tests {
+		ev := set.Evaluate(tt.key, tt.ctx)
+		if ev.Value != tt.value || ev.Reason != tt.reason || ev.RuleID != tt.ruleID || ev.Variation != tt.variation || ev.Err != nil {
+			t.Errorf("%s %v: got %+v", tt.key, tt.ctx, ev)
+		}
+	}
+
+	ev := set.Evaluate("broken", map[string]any{"x": int64(0)})
+	if ev.Reason != ReasonError || ev.RuleID != "call" || ev.Err == nil || ev.Value != nil {
+		t.Errorf("expected rule error, got %+v", ev)
+	}
+
+	// 放量以开关的键为种子，同一用户的结果稳定，约一半用户命中
+	matched := 0
+	for i := range 1000 {
+		ctx := map[string]any{"user_id": int64(i)}
+		ev := set.Evaluate("new-checkout", ctx)
+		if ev.Reason == ReasonRuleMatch {
+			matched++
+		}
+		if again := set.Evaluate("new-checkout", ctx); again.Value != ev.Value {
+			t.Fatalf("user %d: unstable result", i)
+		}
+	}
+	if matched < 400 || matched > 600 {
+		t.Errorf("expected about half of users in rollout, got %d", matched)
+	}
+
+	if got := Get(set, "theme", nil, "fallback"); got != "light" {
+		t.Errorf("Get theme: %s", got)
+	}
+	if got := Get(set, "limit", nil, int64(1)); got != 10 {
+		t.Errorf("Get limit: %d", got)
+	}
+	if got := Get(set, "limit", nil, "x"); got != "x" {
+		t.Errorf("Get with mismatched type should fall back, got %s", got)
+	}
+	if got := Get(set, "missing", nil, true); !got {
+		t.Errorf("Get missing flag should fall back")
+	}
+	if keys := set.Keys(); strings.Join(keys, ",") != "broken,limit,new-checkout,theme" {
+		t.Errorf("Keys: %v", keys)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		flags []Flag
+		want  string
+	}{
+		{[]Flag{{Variations: []any{true}}}, "empty key"},
+		{[]Flag{{Key: "a", Variations: []any{true}}, {Key: "a", Variations: []any{true}}}, `duplicate flag "a"`},
+		{[]Flag{{Key: "a"}}, "no variations"},
+		{[]Flag{{Key: "a", Variations: []any{true, "on"}}}, "variation 1 is string"},
+		{[]Flag{{Key: "a", Variations: []any{[]any{}}}}, "unsupported type"},
+		{[]Flag{{Key: "a", Variations: []any{true}, Fallthrough: 1}}, "out of range"},
+		{[]Flag{{Key: "a", Variations: []any{true}, Rules: []Rule{{When: "x", Variation: 2}}}}, "rule 0"},
+		{[]Flag{{Key: "a", Variations: []any{true}, Rules: []Rule{{When: "x ==", Variation: 0}}}}, `flag "a": rule 0`},
+	}
+	for _, tt := range tests {
+		if _, err := Compile(tt.flags...); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.flags, tt.want, err)
+		}
+	}
+}
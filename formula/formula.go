@@ -0,0 +1,543 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package formula 把电子表格（Excel 风格）的公式翻译为 uwasa 规则，业务人员可以直接粘贴表格中的公式：
+//
+//	engine, err := formula.Compile(`=IF(AND(B2>10, C2<>"closed"), "big", "small")`)
+//	size, err := engine.Execute(map[string]any{"B2": 12, "C2": "open"})
+//
+// 单元格引用与名称即上下文变量：B2、$B$2 与 b2 都读取变量 B2，其他名称（如 TaxRate）按原样读取，
+// Options.Names 可以把它们改映射为其他变量名。区域 A1:B2 按行展开为 A1、B1、A2、B2，只能作为函数实参。
+//
+// 支持的运算（优先级从高到低）：一元 -、百分号 %、乘方 ^、* /、+ -、连接 &、比较 = <> < <= > >=。
+// 支持的函数（不区分大小写）：
+//
+//	IF(条件, 值, [否则])       条件为假且省略否则时为 FALSE，只求值选中的分支
+//	AND(...)、OR(...)、NOT(x)  短路求值
+//	SUM、AVERAGE、MIN、MAX     跳过空单元格（nil），AVERAGE 没有数值时出错，MIN 与 MAX 没有数值时为 0
+//	ROUND(x, 位数)、ABS(x)、POWER(x, y)、LEN(s)
+//
+// 除法总是按浮点数计算，与表格一致；其余算术沿用 uwasa 的整数与浮点数规则。字符串比较区分大小写。
+// SUM 等聚合函数由本包注册为同名（大写）的纯内置函数，公式被翻译为 uwasa 源码（见 Translate）后由 NeoVM 编译。
+package formula
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kamihama-railway/uwasa"
+	"github.com/kamihama-railway/uwasa/internal/lex"
+)
+
+func init() {
+	for name, fn := range map[string]uwasa.BuiltinFunc{
+		"SUM": sum, "AVERAGE": average, "MIN": minimum, "MAX": maximum,
+		"ROUND": round, "ABS": abs, "POWER": power,
+	} {
+		if err := uwasa.RegisterBuiltin(name, fn, uwasa.BuiltinOptions{Pure: true}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// maxRangeCells 限制单个区域展开的单元格数
+const maxRangeCells = 4096
+
+// Options 配置公式的编译
+type Options struct {
+	// Names 把公式中的单元格或名称映射为上下文变量名，如 {"B2": "order_total"}。
+	// 单元格的键为大写且不带 $ 的形式，未列出的单元格与名称直接作为变量名
+	Names map[string]string
+	// Engine 交给 uwasa.NewEngineVMNeoWithOptions
+	Engine uwasa.EngineOptions
+}
+
+// Compile 使用默认选项编译公式
+func Compile(formula string) (*uwasa.Engine, error) {
+	return CompileWithOptions(formula, Options{})
+}
+
+// CompileWithOptions 按 opts 编译公式
+func CompileWithOptions(formula string, opts Options) (*uwasa.Engine, error) {
+	source, err := Translate(formula, opts.Names)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := uwasa.NewEngineVMNeoWithOptions(source, opts.Engine)
+	if err != nil {
+		return nil, fmt.Errorf("formula: %w", err)
+	}
+	return engine, nil
+}
+
+// Translate 把公式翻译为等价的 uwasa 源码，开头的 = 可以省略；names 的含义同 Options.Names。
+// 如 `IF(A1>10, "big", "small")` 翻译为 `(if (A1 > 10) is "big" else is "small")`
+func Translate(formula string, names map[string]string) (string, error) {
+	off := 0
+	if s := strings.TrimLeft(formula, " \t\r\n"); strings.HasPrefix(s, "=") {
+		off = len(formula) - len(s) + 1
+	}
+	p := &parser{Scanner: lex.New("formula", formula, off, scan), names: names}
+	p.Next()
+	out, err := p.parseComparison()
+	if err != nil {
+		return "", err
+	}
+	if p.Err() != nil || p.Tok.Kind != lex.EOF {
+		return "", p.Fail("unexpected %s", p.Tok)
+	}
+	return out, nil
+}
+
+// parser 以递归下降解析公式，每个 parse 方法返回对应子表达式的 uwasa 源码
+type parser struct {
+	lex.Scanner
+	names map[string]string
+}
+
+var operators = []string{"<>", "<=", ">=", "=", "<", ">", "+", "-", "*", "/", "^", "%", "&", "(", ")", ",", ":"}
+
+// scan 读取一个记号：字符串只用双引号；单元格与名称可以含 $ 与 .
+func scan(s *lex.Scanner, c byte) {
+	switch {
+	case c == '"':
+		s.Quoted(lex.String, "string")
+	case isDigit(c) || c == '.' && isDigit(s.Peek(1)):
+		s.Skip(func(c byte) bool { return isDigit(c) || c == '.' })
+		text := s.Text()
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			s.Errorf("invalid number %q", text)
+			return
+		}
+		if text[0] == '.' {
+			text = "0" + text
+		}
+		s.Emit(lex.Number, strings.TrimSuffix(text, "."))
+	case isLetter(c) || c == '$':
+		s.Skip(func(c byte) bool { return isLetter(c) || isDigit(c) || c == '$' || c == '.' })
+		s.Emit(lex.Name, s.Text())
+	default:
+		s.Ops(operators)
+	}
+}
+
+func isDigit(c byte) bool  { return '0' <= c && c <= '9' }
+func isLetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' }
+
+var comparisons = map[string]string{"=": "==", "<>": "!=", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+
+// binary 解析以 ops 中的运算符连接的左结合表达式，operand 解析操作数，lower 把一次运算翻译为源码
+func (p *parser) binary(ops []string, operand func() (string, error), lower func(op, left, right string) string) (string, error) {
+	left, err := operand()
+	for err == nil && p.Tok.Kind == lex.Op && slices.Contains(ops, p.Tok.Text) {
+		op := p.Tok.Text
+		p.Next()
+		var right string
+		right, err = operand()
+		left = lower(op, left, right)
+	}
+	return left, err
+}
+
+func (p *parser) parseComparison() (string, error) {
+	return p.binary([]string{"=", "<>", "<", "<=", ">", ">="}, p.parseConcat, func(op, l, r string) string {
+		return "(" + l + " " + comparisons[op] + " " + r + ")"
+	})
+}
+
+func (p *parser) parseConcat() (string, error) {
+	return p.binary([]string{"&"}, p.parseAdditive, func(_, l, r string) string {
+		return "concat(" + l + ", " + r + ")"
+	})
+}
+
+func (p *parser) parseAdditive() (string, error) {
+	return p.binary([]string{"+", "-"}, p.parseTerm, func(op, l, r string) string {
+		return "(" + l + " " + op + " " + r + ")"
+	})
+}
+
+func (p *parser) parseTerm() (string, error) {
+	return p.binary([]string{"*", "/"}, p.parsePower, func(op, l, r string) string {
+		if op == "/" {
+			// 表格中的除法总是得到浮点数
+			return "(float(" + l + ") / " + r + ")"
+		}
+		return "(" + l + " * " + r + ")"
+	})
+}
+
+func (p *parser) parsePower() (string, error) {
+	return p.binary([]string{"^"}, p.parsePercent, func(_, l, r string) string {
+		return "POWER(" + l + ", " + r + ")"
+	})
+}
+
+func (p *parser) parsePercent() (string, error) {
+	operand, err := p.parseUnary()
+	for err == nil && p.Is("%") {
+		p.Next()
+		operand = "(float(" + operand + ") / 100)"
+	}
+	return operand, err
+}
+
+func (p *parser) parseUnary() (string, error) {
+	switch {
+	case p.Is("-"):
+		p.Next()
+		operand, err := p.parseUnary()
+		return "(-" + operand + ")", err
+	case p.Is("+"):
+		p.Next()
+		return p.parseUnary()
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (string, error) {
+	tok := p.Tok
+	switch tok.Kind {
+	case lex.Number:
+		p.Next()
+		return tok.Text, nil
+	case lex.String:
+		p.Next()
+		return uwasa.Quote(tok.Text), nil
+	case lex.Op:
+		if tok.Text != "(" {
+			break
+		}
+		p.Next()
+		inner, err := p.parseComparison()
+		if err != nil {
+			return "", err
+		}
+		return inner, p.Expect(")")
+	case lex.Name:
+		p.Next()
+		if p.Is("(") {
+			return p.parseCall(tok)
+		}
+		switch strings.ToUpper(tok.Text) {
+		case "TRUE":
+			return "true", nil
+		case "FALSE":
+			return "false", nil
+		}
+		if p.Is(":") {
+			return "", p.Fail("range %s: can only be used as a function argument", tok.Text)
+		}
+		return p.variable(tok)
+	}
+	return "", p.Fail("unexpected %s", tok)
+}
+
+// variable 返回单元格或名称对应的上下文变量名
+func (p *parser) variable(tok lex.Token) (string, error) {
+	name := tok.Text
+	if col, row, ok := parseCell(name); ok {
+		name = cellName(col, row)
+	} else if strings.Contains(name, "$") {
+		return "", fmt.Errorf("formula: invalid reference %q at offset %d", tok.Text, tok.Pos)
+	}
+	if mapped, ok := p.names[name]; ok {
+		name = mapped
+	}
+	if !uwasa.IsIdentifier(name) {
+		return "", fmt.Errorf("formula: %q is not a valid uwasa variable name at offset %d", name, tok.Pos)
+	}
+	return name, nil
+}
+
+// parseCell 解析 A1、$A$1 形式的单元格引用，返回从 1 开始的列号与行号
+func parseCell(ref string) (col, row int, ok bool) {
+	s := strings.TrimPrefix(ref, "$")
+	i := 0
+	for i < len(s) && i < 3 && isLetter(s[i]) && s[i] != '_' {
+		col = col*26 + int(s[i]|0x20-'a') + 1
+		i++
+	}
+	if i == 0 {
+		return 0, 0, false
+	}
+	s = strings.TrimPrefix(s[i:], "$")
+	if s == "" || s[0] == '0' {
+		return 0, 0, false
+	}
+	for j := 0; j < len(s); j++ {
+		if !isDigit(s[j]) || row > 1<<20 {
+			return 0, 0, false
+		}
+		row = row*10 + int(s[j]-'0')
+	}
+	return col, row, true
+}
+
+// cellName 返回列号 col、行号 row 的单元格的规范名称，如 (2, 3) 为 B3
+func cellName(col, row int) string {
+	var letters []byte
+	for ; col > 0; col = (col - 1) / 26 {
+		letters = append([]byte{byte('A' + (col-1)%26)}, letters...)
+	}
+	return string(letters) + strconv.Itoa(row)
+}
+
+// parseArgument 解析函数实参，区域展开为其中各单元格对应的变量
+func (p *parser) parseArgument() ([]string, error) {
+	if p.Tok.Kind == lex.Name {
+		start := p.Tok
+		if c1, r1, ok := parseCell(start.Text); ok && p.peekRange() {
+			p.Next()
+			p.Next()
+			end := p.Tok
+			c2, r2, ok := parseCell(end.Text)
+			if end.Kind != lex.Name || !ok {
+				return nil, p.Fail("expected a cell after %s:, got %s", start.Text, end)
+			}
+			p.Next()
+			c1, c2 = min(c1, c2), max(c1, c2)
+			r1, r2 = min(r1, r2), max(r1, r2)
+			if (c2-c1+1)*(r2-r1+1) > maxRangeCells {
+				return nil, fmt.Errorf("formula: range %s:%s has more than %d cells", start.Text, end.Text, maxRangeCells)
+			}
+			var cells []string
+			for r := r1; r <= r2; r++ {
+				for c := c1; c <= c2; c++ {
+					v, err := p.variable(lex.Token{Kind: lex.Name, Text: cellName(c, r), Pos: start.Pos})
+					if err != nil {
+						return nil, err
+					}
+					cells = append(cells, v)
+				}
+			}
+			return cells, nil
+		}
+	}
+	arg, err := p.parseComparison()
+	return []string{arg}, err
+}
+
+// peekRange 判断当前的单元格之后是否紧跟 :，即区域的开头
+func (p *parser) peekRange() bool {
+	rest := strings.TrimLeft(p.Rest(), " \t\r\n")
+	return strings.HasPrefix(rest, ":")
+}
+
+// parseCall 解析函数调用，name 为函数名，当前记号为 (
+func (p *parser) parseCall(name lex.Token) (string, error) {
+	p.Next()
+	var args []string
+	if !p.Is(")") {
+		for {
+			arg, err := p.parseArgument()
+			if err != nil {
+				return "", err
+			}
+			args = append(args, arg...)
+			if !p.Is(",") {
+				break
+			}
+			p.Next()
+		}
+	}
+	if err := p.Expect(")"); err != nil {
+		return "", err
+	}
+	fn := strings.ToUpper(name.Text)
+	arity := func(lo, hi int) error {
+		if len(args) < lo || len(args) > hi {
+			return fmt.Errorf("formula: %s expects %d to %d arguments, got %d at offset %d", fn, lo, hi, len(args), name.Pos)
+		}
+		return nil
+	}
+	switch fn {
+	case "IF":
+		if err := arity(2, 3); err != nil {
+			return "", err
+		}
+		otherwise := "false"
+		if len(args) == 3 {
+			otherwise = args[2]
+		}
+		return "(if " + args[0] + " is " + args[1] + " else is " + otherwise + ")", nil
+	case "AND", "OR":
+		if err := arity(1, maxRangeCells); err != nil {
+			return "", err
+		}
+		op := " && "
+		if fn == "OR" {
+			op = " || "
+		}
+		return "(" + strings.Join(args, op) + ")", nil
+	case "NOT":
+		if err := arity(1, 1); err != nil {
+			return "", err
+		}
+		return "!" + args[0], nil
+	case "LEN":
+		if err := arity(1, 1); err != nil {
+			return "", err
+		}
+		return "len(" + args[0] + ")", nil
+	case "SUM", "AVERAGE", "MIN", "MAX":
+		if err := arity(1, maxRangeCells); err != nil {
+			return "", err
+		}
+	case "ROUND", "POWER":
+		if err := arity(2, 2); err != nil {
+			return "", err
+		}
+	case "ABS":
+		if err := arity(1, 1); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("formula: unknown function %s at offset %d", name.Text, name.Pos)
+	}
+	return fn + "(" + strings.Join(args, ", ") + ")", nil
+}
+
+// numbers 收集 name 的数值实参，跳过 nil；allInt 表示收集到的数值均为整数
+func numbers(name string, args []any) (nums []float64, allInt bool, err error) {
+	allInt = true
+	for _, a := range args {
+		switch v := a.(type) {
+		case nil:
+		case int64:
+			nums = append(nums, float64(v))
+		case float64:
+			nums, allInt = append(nums, v), false
+		default:
+			return nil, false, fmt.Errorf("%s expects numbers, got %T", name, a)
+		}
+	}
+	return nums, allInt, nil
+}
+
+// number 把单个数值实参转换为 float64
+func number(name string, a any) (float64, error) {
+	switch v := a.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return 0, fmt.Errorf("%s expects a number, got %T", name, a)
+}
+
+// result 在 allInt 为真且 f 可精确表示为 int64 时返回 int64，否则返回 float64
+func result(f float64, allInt bool) any {
+	if allInt && f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return int64(f)
+	}
+	return f
+}
+
+// sum 实现内置函数 SUM
+func sum(args ...any) (any, error) {
+	nums, allInt, err := numbers("SUM", args)
+	if err != nil {
+		return nil, err
+	}
+	var s float64
+	for _, n := range nums {
+		s += n
+	}
+	return result(s, allInt), nil
+}
+
+// average 实现内置函数 AVERAGE
+func average(args ...any) (any, error) {
+	nums, _, err := numbers("AVERAGE", args)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) == 0 {
+		return nil, fmt.Errorf("AVERAGE: no numbers to average")
+	}
+	var s float64
+	for _, n := range nums {
+		s += n
+	}
+	return s / float64(len(nums)), nil
+}
+
+// extremum 实现 MIN 与 MAX，没有数值时为 0
+func extremum(name string, args []any, better func(a, b float64) bool) (any, error) {
+	nums, allInt, err := numbers(name, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) == 0 {
+		return int64(0), nil
+	}
+	m := nums[0]
+	for _, n := range nums[1:] {
+		if better(n, m) {
+			m = n
+		}
+	}
+	return result(m, allInt), nil
+}
+
+func minimum(args ...any) (any, error) {
+	return extremum("MIN", args, func(a, b float64) bool { return a < b })
+}
+
+func maximum(args ...any) (any, error) {
+	return extremum("MAX", args, func(a, b float64) bool { return a > b })
+}
+
+// round 实现内置函数 ROUND(x, digits)，与表格一致，远离零舍入；digits 可以为负
+func round(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("ROUND expects 2 arguments, got %d", len(args))
+	}
+	x, err := number("ROUND", args[0])
+	if err != nil {
+		return nil, err
+	}
+	d, ok := args[1].(int64)
+	if !ok {
+		return nil, fmt.Errorf("ROUND expects integer digits, got %T", args[1])
+	}
+	scale := math.Pow(10, float64(d))
+	return math.Round(x*scale) / scale, nil
+}
+
+// abs 实现内置函数 ABS
+func abs(args ...any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("ABS expects 1 argument, got %d", len(args))
+	}
+	switch v := args[0].(type) {
+	case int64:
+		if v < 0 {
+			return -v, nil
+		}
+		return v, nil
+	case float64:
+		return math.Abs(v), nil
+	}
+	return nil, fmt.Errorf("ABS expects a number, got %T", args[0])
+}
+
+// power 实现内置函数 POWER 与运算符 ^
+func power(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("POWER expects 2 arguments, got %d", len(args))
+	}
+	x, err := number("POWER", args[0])
+	if err != nil {
+		return nil, err
+	}
+	y, err := number("POWER", args[1])
+	if err != nil {
+		return nil, err
+	}
+	return math.Pow(x, y), nil
+}
@@ -0,0 +1,90 @@
+package formula
+
+import (
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+	"github.com/kamihama-railway/uwasa/internal/enginetest"
+)
+
+func sheet() map[string]any {
+	return map[string]any{
+		"A":       int64(12),
+		"A1":      int64(1),
+		"A2":      int64(2),
+		"A3":      2.5,
+		"B1":      int64(10),
+		"B2":      nil,
+		"B3":      int64(-4),
+		"C2":      "open",
+		"TaxRate": 0.1,
+		"total":   int64(200),
+	}
+}
+
+func TestFormulas(t *testing.T) {
+	enginetest.Run(t, enginetest.Table{
+		{`IF(A>10, "big", "small")`, "big"},
+		{`=if(a1 > 10, "big", "small")`, "small"},
+		{`IF(A1 > 10, "big")`, false},
+		{`IF(AND(B1>5, C2<>"closed"), "ok", SUM(C2))`, "ok"},
+		{`OR(A1 = 2, NOT(C2 = "open"))`, false},
+		{`SUM(A1:A3)`, 5.5},
+		{`SUM(A1:B2, 100)`, int64(113)},
+		{`SUM(A1, A2) * 2`, int64(6)},
+		{`AVERAGE(A1:A2)`, 1.5},
+		{`MIN(A1:B3)`, -4.0},
+		{`MAX($A$1:A3, B1)`, 10.0},
+		{`MIN(A1:A2, B1)`, int64(1)},
+		{`MAX(B2)`, int64(0)},
+		{`ABS(B3) + ROUND(2.345, 2)`, 6.35},
+		{`ROUND(1234, -2)`, 1200.0},
+		{`7 / 2`, 3.5},
+		{`2^3^2`, 64.0},
+		{`-2^2`, 4.0},
+		{`50% * total`, 100.0},
+		{`total * (1 + TaxRate)`, 220.0},
+		{`"Total: " & total & " yen"`, "Total: 200 yen"},
+		{`"say ""hi"""`, `say "hi"`},
+		{`LEN(C2) = 4`, true},
+		{`TRUE`, true},
+		{`missing`, nil},
+	}, func(s string) (string, error) { return Translate(s, nil) }, func(engine *uwasa.Engine) (any, error) {
+		return engine.Execute(sheet())
+	})
+}
+
+func TestNamesAndErrors(t *testing.T) {
+	engine, err := CompileWithOptions(`IF(B2 > 100, "vip", "normal")`, Options{Names: map[string]string{"B2": "order_total"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := engine.Execute(map[string]any{"order_total": int64(150)}); err != nil || got != "vip" {
+		t.Errorf("expected vip, got %v (%v)", got, err)
+	}
+	if got, _ := Translate(`IF(A1>10, "big", "small")`, nil); got != `(if (A1 > 10) is "big" else is "small")` {
+		t.Errorf("unexpected translation %s", got)
+	}
+
+	for _, bad := range []string{
+		`IF(A1 > 1)`,
+		`A1:A3 + 1`,
+		`SUM(A1:ZZZ100000)`,
+		`VLOOKUP(A1, B1:B3, 1)`,
+		`"open`,
+		`A1 # 2`,
+		`1.2.3`,
+		`(A1 + 1`,
+		`if`,
+		`A$`,
+	} {
+		if _, err := Compile(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+	// 聚合函数只接受数值
+	engine, _ = Compile(`SUM(C2)`)
+	if _, err := engine.Execute(sheet()); err == nil {
+		t.Errorf("expected an error for SUM of a string")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// fuzzyStackRunes 为编辑距离在栈上计算的最大长度（字符数），更长的字符串才分配堆内存
+const fuzzyStackRunes = 64
+
+// fuzzyArgs 检查模糊匹配内置函数的两个字符串参数
+func fuzzyArgs(name string, args []any) (string, string, error) {
+	if len(args) != 2 {
+		return "", "", fmt.Errorf("%s expects 2 arguments, got %d", name, len(args))
+	}
+	a, ok1 := args[0].(string)
+	b, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return "", "", fmt.Errorf("%s expects two strings, got %T and %T", name, args[0], args[1])
+	}
+	return a, b, nil
+}
+
+// editDistance 返回 a 与 b 按字符计的 Levenshtein 距离。去掉公共前后缀后以单行动态规划计算，
+// 两者均不超过 fuzzyStackRunes 个字符时不分配内存
+func editDistance(a, b string) int {
+	var bufA, bufB [fuzzyStackRunes]rune
+	x, y := bufA[:0], bufB[:0]
+	for _, r := range a {
+		x = append(x, r)
+	}
+	for _, r := range b {
+		y = append(y, r)
+	}
+	for len(x) > 0 && len(y) > 0 && x[0] == y[0] {
+		x, y = x[1:], y[1:]
+	}
+	for len(x) > 0 && len(y) > 0 && x[len(x)-1] == y[len(y)-1] {
+		x, y = x[:len(x)-1], y[:len(y)-1]
+	}
+	if len(x) < len(y) {
+		x, y = y, x
+	}
+	if len(y) == 0 {
+		return len(x)
+	}
+	var rowBuf [fuzzyStackRunes + 1]int
+	row := rowBuf[:]
+	if len(y)+1 > len(row) {
+		row = make([]int, len(y)+1)
+	}
+	row = row[:len(y)+1]
+	for j := range row {
+		row[j] = j
+	}
+	for i, rx := range x {
+		diag := row[0]
+		row[0] = i + 1
+		for j, ry := range y {
+			cost := 1
+			if rx == ry {
+				cost = 0
+			}
+			next := min(row[j+1]+1, row[j]+1, diag+cost)
+			diag, row[j+1] = row[j+1], next
+		}
+	}
+	return row[len(y)]
+}
+
+// levenshtein 实现内置函数 levenshtein(a, b)：把 a 变为 b 所需的最少单字符插入、删除与替换次数
+func levenshtein(args ...any) (any, error) {
+	a, b, err := fuzzyArgs("levenshtein", args)
+	if err != nil {
+		return nil, err
+	}
+	return int64(editDistance(a, b)), nil
+}
+
+// similarity 实现内置函数 similarity(a, b)：1 - 编辑距离 / 较长者的字符数，取值 0 到 1，两者均为空时为 1
+func similarity(args ...any) (any, error) {
+	a, b, err := fuzzyArgs("similarity", args)
+	if err != nil {
+		return nil, err
+	}
+	n := max(utf8.RuneCountInString(a), utf8.RuneCountInString(b))
+	if n == 0 {
+		return 1.0, nil
+	}
+	return 1 - float64(editDistance(a, b))/float64(n), nil
+}
@@ -0,0 +1,57 @@
+package uwasa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFuzzy(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`levenshtein("kitten", "sitting")`, int64(3)},
+		{`levenshtein(name, "Madoka Kaname")`, int64(0)},
+		{`levenshtein(name, "madoka kaname")`, int64(2)},
+		{`levenshtein("", name)`, int64(13)},
+		{`levenshtein("鹿目まどか", "鹿目まどな")`, int64(1)},
+		{`levenshtein("flaw", "lawn") == levenshtein("lawn", "flaw")`, true},
+		{`similarity(name, name)`, 1.0},
+		{`similarity("", "")`, 1.0},
+		{`similarity("abcd", "abce")`, 0.75},
+		{`similarity("abc", "xyz")`, 0.0},
+		{`similarity(name, "Madoka Kanme") > 0.9`, true},
+		{`name |> levenshtein("Homura")`, int64(11)},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"name": "Madoka Kaname"})
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`levenshtein(name)`, `levenshtein(name, 1)`, `similarity(nil, name)`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(map[string]any{"name": "Madoka Kaname"})
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+
+	long := strings.Repeat("ab", 50)
+	if d := editDistance(long, long[1:]+"c"); d != 2 {
+		t.Errorf("expected distance 2 for long strings, got %d", d)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { editDistance("Madoka Kaname", "Homura Akemi") }); allocs != 0 {
+		t.Errorf("expected no allocations for short strings, got %v", allocs)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"math"
+)
+
+// earthRadiusMeters 为地球的平均半径
+const earthRadiusMeters = 6371008.8
+
+// geoArgs 把经纬度实参转为浮点数，整数与浮点数均可
+func geoArgs(name string, args []any) ([]float64, error) {
+	out := make([]float64, len(args))
+	for i, arg := range args {
+		f, ok := toFloat64(arg)
+		if !ok {
+			return nil, fmt.Errorf("%s expects numeric coordinates, got %T", name, arg)
+		}
+		out[i] = f
+	}
+	return out, nil
+}
+
+// geoDistance 实现内置函数 geoDistance(lat1, lon1, lat2, lon2)：按球面（haversine）公式返回两点间的距离，单位为米
+func geoDistance(args ...any) (any, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("geoDistance expects 4 arguments, got %d", len(args))
+	}
+	c, err := geoArgs("geoDistance", args)
+	if err != nil {
+		return nil, err
+	}
+	lat1, lat2 := c[0]*math.Pi/180, c[2]*math.Pi/180
+	dLat, dLon := lat2-lat1, (c[3]-c[1])*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(min(h, 1))), nil
+}
+
+// geoPolygon 是解析后的多边形，lats 与 lons 为各顶点的纬度与经度，外接矩形用于快速排除
+type geoPolygon struct {
+	src                            []any
+	lats, lons                     []float64
+	minLat, maxLat, minLon, maxLon float64
+}
+
+// parsePolygon 解析 `[[lat, lon], ...]` 形式的多边形，至少需要 3 个顶点，首尾不必重复
+func parsePolygon(v any) (*geoPolygon, error) {
+	points, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("inPolygon expects an array of [lat, lon] points, got %T", v)
+	}
+	if len(points) < 3 {
+		return nil, fmt.Errorf("inPolygon expects at least 3 points, got %d", len(points))
+	}
+	p := &geoPolygon{src: points, lats: make([]float64, len(points)), lons: make([]float64, len(points))}
+	for i, pt := range points {
+		pair, ok := pt.([]any)
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("inPolygon point %d must be [lat, lon], got %v", i, pt)
+		}
+		c, err := geoArgs("inPolygon", pair)
+		if err != nil {
+			return nil, err
+		}
+		p.lats[i], p.lons[i] = c[0], c[1]
+	}
+	p.minLat, p.maxLat = minMax(p.lats)
+	p.minLon, p.maxLon = minMax(p.lons)
+	return p, nil
+}
+
+func minMax(xs []float64) (float64, float64) {
+	lo, hi := xs[0], xs[0]
+	for _, x := range xs[1:] {
+		lo, hi = min(lo, x), max(hi, x)
+	}
+	return lo, hi
+}
+
+// contains 以射线法判断点是否在多边形内，经纬度按平面坐标处理，不考虑跨越 180° 经线的多边形
+func (p *geoPolygon) contains(lat, lon float64) bool {
+	if lat < p.minLat || lat > p.maxLat || lon < p.minLon || lon > p.maxLon {
+		return false
+	}
+	inside := false
+	for i, j := 0, len(p.lats)-1; i < len(p.lats); j, i = i, i+1 {
+		if (p.lats[i] > lat) != (p.lats[j] > lat) &&
+			lon < (p.lons[j]-p.lons[i])*(lat-p.lats[i])/(p.lats[j]-p.lats[i])+p.lons[i] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func (p *geoPolygon) preparedFrom() (string, int, any) { return "inPolygon", 2, p.src }
+
+// preparePolygon 在编译期解析 inPolygon 的常量多边形
+func preparePolygon(i int, v any) (preparedArg, bool) {
+	if i != 2 {
+		return nil, false
+	}
+	p, err := parsePolygon(v)
+	if err != nil {
+		return nil, false
+	}
+	return p, true
+}
+
+// inPolygon 实现内置函数 inPolygon(lat, lon, polygon)：点是否在多边形内。多边形为常量时在编译期解析，
+// 来自上下文时每次调用都重新解析
+func inPolygon(args ...any) (any, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("inPolygon expects 3 arguments, got %d", len(args))
+	}
+	c, err := geoArgs("inPolygon", args[:2])
+	if err != nil {
+		return nil, err
+	}
+	p, ok := args[2].(*geoPolygon)
+	if !ok {
+		if p, err = parsePolygon(args[2]); err != nil {
+			return nil, err
+		}
+	}
+	return p.contains(c[0], c[1]), nil
+}
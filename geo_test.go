@@ -0,0 +1,60 @@
+package uwasa
+
+import "testing"
+
+func TestGeo(t *testing.T) {
+	// 大致为东京都心的矩形与一个凹多边形
+	const square = `[[35.6, 139.6], [35.6, 139.9], [35.8, 139.9], [35.8, 139.6]]`
+	const notch = `[[0, 0], [0, 10], [10, 10], [10, 0], [5, 5]]`
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`geoDistance(lat, lon, 34.6937, 135.5023) > 390000 && geoDistance(lat, lon, 34.6937, 135.5023) < 410000`, true},
+		{`geoDistance(lat, lon, lat, lon)`, 0.0},
+		{`geoDistance(0, 0, 0, 1) > 111000`, true},
+		{`inPolygon(lat, lon, ` + square + `)`, true},
+		{`inPolygon(34.6937, 135.5023, ` + square + `)`, false},
+		{`inPolygon(lat, lon, zone)`, true},
+		{`inPolygon(2, 5, ` + notch + `)`, true},
+		{`inPolygon(8, 5, ` + notch + `)`, true},
+		{`inPolygon(5, 1, ` + notch + `)`, false},
+		{`[lat, 139.7] |> inPolygon(lon, ` + square + `)`, nil},
+		{`lat |> inPolygon(lon, ` + square + `)`, true},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{
+			"lat": 35.6812, "lon": 139.7671,
+			"zone": []any{[]any{35.6, 139.6}, []any{35.6, 139.9}, []any{35.8, 139.9}, []any{35.8, int64(139)}},
+		}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if tt.expected == nil {
+				if err == nil {
+					t.Errorf("%s %s: expected execute error, got %v", name, tt.input, got)
+				}
+				continue
+			}
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`geoDistance(1, 2, 3)`, `geoDistance(lat, lon, "a", 0)`, `inPolygon(lat, lon, [[0, 0], [1, 1]])`, `inPolygon(lat, lon, [[0, 0], [1, 1], [2]])`, `inPolygon(lat, lon, lat)`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// isLike 判断 tok 是否为中缀运算符 like。like 不是保留字，只有跟在操作数之后且不在行首时才是运算符，
+// 因此仍可以用作变量名与函数名，如 sqlwhere 注册的内置函数 like
+func isLike(tok Token) bool {
+	return tok.Type == TokenIdent && tok.Literal == "like" && !tok.Newline
+}
+
+// globAny 为模式段中 ? 的占位字符
+const globAny = -1
+
+// globPattern 是解析后的通配模式，src 为原字符串。模式以 * 分隔为若干段：无 * 时唯一的段须匹配整个字符串，
+// 否则首段匹配开头、末段匹配结尾，中间各段依次取最左的匹配。各段长度（字符数）固定，最左匹配即可保证正确
+type globPattern struct {
+	src  string
+	segs []globSegment
+	star bool
+}
+
+// globSegment 是模式中不含 * 的一段。不含 ? 时 runes 为 nil，按 lit 做字节比较
+type globSegment struct {
+	lit   string
+	runes []rune
+}
+
+func (p *globPattern) preparedFrom() (string, int, any) { return "glob", 1, p.src }
+
+// parseGlob 解析通配模式：* 匹配任意个字符，? 匹配一个字符，\ 转义其后的字符
+func parseGlob(v any) (*globPattern, error) {
+	src, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("glob expects a string pattern, got %T", v)
+	}
+	p := &globPattern{src: src}
+	var seg []rune
+	wild := false
+	flush := func() {
+		s := globSegment{runes: seg}
+		if !wild {
+			s = globSegment{lit: string(seg)}
+		}
+		p.segs = append(p.segs, s)
+		seg, wild = nil, false
+	}
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		i += size
+		switch r {
+		case '*':
+			p.star = true
+			flush()
+			continue
+		case '?':
+			r, wild = globAny, true
+		case '\\':
+			if i == len(src) {
+				return nil, fmt.Errorf("glob: pattern %q ends with an escape", src)
+			}
+			r, size = utf8.DecodeRuneInString(src[i:])
+			i += size
+		}
+		seg = append(seg, r)
+	}
+	flush()
+	return p, nil
+}
+
+// prepareGlob 在编译期解析 glob 的常量模式
+func prepareGlob(i int, v any) (preparedArg, bool) {
+	if i != 1 {
+		return nil, false
+	}
+	p, err := parseGlob(v)
+	if err != nil {
+		return nil, false
+	}
+	return p, true
+}
+
+// match 判断 s 是否匹配整个模式
+func (p *globPattern) match(s string) bool {
+	first := p.segs[0]
+	n, ok := first.matchAt(s)
+	if !ok {
+		return false
+	}
+	if !p.star {
+		return n == len(s)
+	}
+	s = s[n:]
+	last := p.segs[len(p.segs)-1]
+	for _, seg := range p.segs[1 : len(p.segs)-1] {
+		end, ok := seg.index(s)
+		if !ok {
+			return false
+		}
+		s = s[end:]
+	}
+	return last.matchSuffix(s)
+}
+
+// matchAt 判断 s 是否以该段开头，返回匹配的字节数
+func (g globSegment) matchAt(s string) (int, bool) {
+	if g.runes == nil {
+		return len(g.lit), strings.HasPrefix(s, g.lit)
+	}
+	n := 0
+	for _, want := range g.runes {
+		if n == len(s) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if want != globAny && want != r {
+			return 0, false
+		}
+		n += size
+	}
+	return n, true
+}
+
+// index 在 s 中查找该段最左的匹配，返回匹配结束的位置
+func (g globSegment) index(s string) (int, bool) {
+	if g.runes == nil {
+		i := strings.Index(s, g.lit)
+		return i + len(g.lit), i >= 0
+	}
+	for i := 0; i <= len(s); {
+		if n, ok := g.matchAt(s[i:]); ok {
+			return i + n, true
+		}
+		if i == len(s) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return 0, false
+}
+
+// matchSuffix 判断 s 是否以该段结尾
+func (g globSegment) matchSuffix(s string) bool {
+	if g.runes == nil {
+		return strings.HasSuffix(s, g.lit)
+	}
+	i := len(s)
+	for range g.runes {
+		if i == 0 {
+			return false
+		}
+		_, size := utf8.DecodeLastRuneInString(s[:i])
+		i -= size
+	}
+	_, ok := g.matchAt(s[i:])
+	return ok
+}
+
+// glob 实现内置函数 glob(s, pattern) 与运算符 `s like pattern`：s 是否匹配通配模式 pattern，区分大小写。
+// 模式为常量时在编译期解析，来自上下文时每次调用都重新解析。s 为 nil 时不匹配
+func glob(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("glob expects 2 arguments, got %d", len(args))
+	}
+	p, ok := args[1].(*globPattern)
+	if !ok {
+		var err error
+		if p, err = parseGlob(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	if args[0] == nil {
+		return false, nil
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("glob expects a string, got %T", args[0])
+	}
+	return p.match(s), nil
+}
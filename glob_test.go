@@ -0,0 +1,57 @@
+package uwasa
+
+import "testing"
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`name like "img_*.png"`, true},
+		{`name like "img_*.jpg"`, false},
+		{`name like "img_??.png"`, true},
+		{`name like "img_?.png"`, false},
+		{`name like "*"`, true},
+		{`name like "img*_*png"`, true},
+		{`name like "*g_*.*"`, true},
+		{`name like "IMG_*"`, false},
+		{`"" like "*"`, true},
+		{`"" like "?"`, false},
+		{`"a*b" like "a\\*b"`, true},
+		{`"axb" like "a\\*b"`, false},
+		{`"鹿目まどか" like "鹿目??か"`, true},
+		{`"aaa" like "*a?a"`, true},
+		{`"abcab" like "*ab?"`, false},
+		{`name like pattern`, true},
+		{`missing like "*"`, false},
+		{`glob(name, "*.png") && !(name like "*.gif")`, true},
+		{`name like "img" + "_*" == true`, true},
+		{`like + 1`, int64(2)},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"name": "img_01.png", "pattern": "img_0?.*", "missing": nil, "like": int64(1)}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`name like 1`, `1 like "*"`, `name like "a\\"`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
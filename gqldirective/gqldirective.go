@@ -0,0 +1,179 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package gqldirective 把 uwasa 表达式接入 GraphQL schema 中的自定义指令，指令参数是表达式源码，
+// 解析每个字段时以该字段的上下文求值：
+//
+//	directive @visible(if: String!) on FIELD_DEFINITION
+//	type Employee { salary: Int @visible(if: "viewer?.role == \"hr\" || parent?.id == viewer?.id") }
+//
+// 包不依赖具体的 GraphQL 库，由 resolver 中间件取出指令参数后调用：
+//
+//	ev := gqldirective.New()
+//	site := gqldirective.Site{Type: "Employee", Field: "salary", Directive: "visible", Arg: "if"}
+//	ok, err := ev.Bool(site, source, &gqldirective.Field{Type: "Employee", Name: "salary", Parent: obj, Request: req})
+//
+// 同一指令位置（Site）只编译一次，此后各字段直接执行缓存的规则；位置上的源码变化（如重新加载 schema）时重新编译。
+// 编译失败同样缓存，直到源码变化。
+//
+// 规则的上下文中可用的变量：
+//
+//	parent  父对象，即 resolver 的 source
+//	args    字段参数，未设置时为空映射
+//	vars    操作变量，未设置时为空映射
+//	field   字段名
+//	type    父类型名
+//	path    响应路径，如 ["orders", 0, "total"]
+//
+// 其他名称在 Field.Request 中查找，例如当前用户 viewer。字段上下文是只读的。
+package gqldirective
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// ErrReadOnly 为规则向字段上下文赋值时返回的错误
+var ErrReadOnly = errors.New("gqldirective: field context is read-only")
+
+// Site 是 schema 中的一个指令位置：类型 Type 的字段 Field 上，指令 Directive 的参数 Arg
+type Site struct {
+	Type      string
+	Field     string
+	Directive string
+	Arg       string
+}
+
+// String 返回形如 "Employee.salary@visible(if)" 的位置描述
+func (s Site) String() string {
+	return fmt.Sprintf("%s.%s@%s(%s)", s.Type, s.Field, s.Directive, s.Arg)
+}
+
+// Field 是正在解析的字段，实现 uwasa.Context
+type Field struct {
+	Type      string
+	Name      string
+	Parent    any
+	Args      map[string]any
+	Variables map[string]any
+	Path      []any
+	// Request 为请求级的值，如当前用户，其中的键可以直接作为变量读取
+	Request map[string]any
+}
+
+// Get 实现 uwasa.Context，返回上述变量；其他名称在 Request 中查找
+func (f *Field) Get(name string) (any, bool) {
+	switch name {
+	case "parent":
+		return f.Parent, true
+	case "args":
+		return orEmpty(f.Args), true
+	case "vars":
+		return orEmpty(f.Variables), true
+	case "field":
+		return f.Name, true
+	case "type":
+		return f.Type, true
+	case "path":
+		if f.Path == nil {
+			return []any{}, true
+		}
+		return f.Path, true
+	}
+	v, ok := f.Request[name]
+	return v, ok
+}
+
+// Set 实现 uwasa.Context。字段上下文是只读的，总是返回 ErrReadOnly
+func (f *Field) Set(name string, value any) error {
+	return ErrReadOnly
+}
+
+func orEmpty(m map[string]any) map[string]any {
+	if m == nil {
+		return map[string]any{}
+	}
+	return m
+}
+
+// Options 配置指令表达式的编译
+type Options struct {
+	// Engine 交给 uwasa.NewEngineVMNeoWithOptions
+	Engine uwasa.EngineOptions
+}
+
+// Evaluator 按指令位置缓存编译好的表达式，可被多个协程同时使用
+type Evaluator struct {
+	opts  Options
+	mu    sync.RWMutex
+	sites map[Site]*compiled
+}
+
+type compiled struct {
+	source string
+	engine *uwasa.Engine
+	err    error
+}
+
+// New 返回使用默认选项的 Evaluator
+func New() *Evaluator {
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions 返回按 opts 编译表达式的 Evaluator
+func NewWithOptions(opts Options) *Evaluator {
+	return &Evaluator{opts: opts, sites: make(map[Site]*compiled)}
+}
+
+// Compile 返回 site 上源码为 source 的表达式编译结果，同一位置与源码只编译一次
+func (e *Evaluator) Compile(site Site, source string) (*uwasa.Engine, error) {
+	e.mu.RLock()
+	c, ok := e.sites[site]
+	e.mu.RUnlock()
+	if !ok || c.source != source {
+		e.mu.Lock()
+		if c, ok = e.sites[site]; !ok || c.source != source {
+			c = &compiled{source: source}
+			c.engine, c.err = uwasa.NewEngineVMNeoWithOptions(source, e.opts.Engine)
+			if c.err != nil {
+				c.err = fmt.Errorf("gqldirective: %s: %w", site, c.err)
+			}
+			e.sites[site] = c
+		}
+		e.mu.Unlock()
+	}
+	return c.engine, c.err
+}
+
+// Eval 以字段 f 为上下文执行 site 上的表达式
+func (e *Evaluator) Eval(site Site, source string, f *Field) (any, error) {
+	engine, err := e.Compile(site, source)
+	if err != nil {
+		return nil, err
+	}
+	v, err := engine.ExecuteWithContext(f)
+	if err != nil {
+		return nil, fmt.Errorf("gqldirective: %s: %w", site, err)
+	}
+	return v, nil
+}
+
+// Bool 执行 site 上的表达式并判断结果是否为真（不是 nil 或 false），用于 @include(if:) 一类的条件指令。
+// 出错时返回 false 与错误，由调用方决定隐藏字段还是报告错误
+func (e *Evaluator) Bool(site Site, source string, f *Field) (bool, error) {
+	v, err := e.Eval(site, source, f)
+	if err != nil {
+		return false, err
+	}
+	return v != nil && v != false, nil
+}
+
+// Forget 丢弃 site 上缓存的编译结果，例如 schema 中删除了该指令
+func (e *Evaluator) Forget(site Site) {
+	e.mu.Lock()
+	delete(e.sites, site)
+	e.mu.Unlock()
+}
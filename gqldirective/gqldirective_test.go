@@ -0,0 +1,120 @@
+package gqldirective
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+func newField() *Field {
+	return &Field{
+		Type:      "Employee",
+		Name:      "salary",
+		Parent:    map[string]any{"id": "e-1", "dept": "ops"},
+		Args:      map[string]any{"currency": "JPY"},
+		Variables: map[string]any{"full": true},
+		Path:      []any{"employees", int64(0), "salary"},
+		Request:   map[string]any{"viewer": map[string]any{"id": "e-1", "role": "staff"}},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`parent?.dept`, "ops"},
+		{`args["currency"]`, "JPY"},
+		{`vars?.full`, true},
+		{`concat(type, ".", field)`, "Employee.salary"},
+		{`path[1]`, int64(0)},
+		{`viewer?.role == "hr" || parent?.id == viewer?.id`, true},
+		{`defined(missing)`, false},
+	}
+	ev := New()
+	for i, tt := range tests {
+		site := Site{Type: "Employee", Field: "salary", Directive: "visible", Arg: string(rune('a' + i))}
+		got, err := ev.Eval(site, tt.input, newField())
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.input, tt.expected, got, err)
+		}
+	}
+
+	empty, err := ev.Eval(Site{Arg: "empty"}, `[len(args), len(vars), len(path)]`, &Field{})
+	if err != nil || !reflect.DeepEqual(empty, []any{int64(0), int64(0), int64(0)}) {
+		t.Errorf("expected empty args, vars and path, got %v (%v)", empty, err)
+	}
+	if _, err := ev.Eval(Site{Arg: "set"}, `field = "x"`, newField()); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestBool(t *testing.T) {
+	ev := New()
+	site := Site{Type: "Employee", Field: "salary", Directive: "visible", Arg: "if"}
+	f := newField()
+	if ok, err := ev.Bool(site, `viewer?.role == "hr"`, f); ok || err != nil {
+		t.Errorf("expected false, got %v (%v)", ok, err)
+	}
+	f.Request["viewer"] = map[string]any{"role": "hr"}
+	if ok, err := ev.Bool(site, `viewer?.role == "hr"`, f); !ok || err != nil {
+		t.Errorf("expected true, got %v (%v)", ok, err)
+	}
+	if ok, err := ev.Bool(site, `parent?.dept`, f); !ok || err != nil {
+		t.Errorf("expected a non-nil value to be true, got %v (%v)", ok, err)
+	}
+	if ok, err := ev.Bool(site, `parent?.boss`, f); ok || err != nil {
+		t.Errorf("expected nil to be false, got %v (%v)", ok, err)
+	}
+	var re *uwasa.RuntimeError
+	if ok, err := ev.Bool(site, `path[9]`, f); ok || !errors.As(err, &re) {
+		t.Errorf("expected a RuntimeError, got %v (%v)", ok, err)
+	}
+}
+
+func TestCompileCache(t *testing.T) {
+	ev := New()
+	site := Site{Type: "Query", Field: "orders", Directive: "limit", Arg: "when"}
+	first, err := ev.Compile(site, `len(args) > 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if e, _ := ev.Compile(site, `len(args) > 0`); e != first {
+				t.Error("expected the cached engine")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 同一位置的源码变化时重新编译，其他位置互不影响
+	if e, _ := ev.Compile(site, `len(args) > 1`); e == first {
+		t.Error("expected a recompiled engine for a changed source")
+	}
+	other := site
+	other.Arg = "unless"
+	if e, _ := ev.Compile(other, `len(args) > 1`); e == nil {
+		t.Error("expected an engine for another site")
+	}
+	ev.Forget(site)
+	if e, _ := ev.Compile(site, `len(args) > 1`); e == first {
+		t.Error("expected a fresh engine after Forget")
+	}
+
+	bad := Site{Type: "Query", Field: "orders", Directive: "visible", Arg: "if"}
+	_, err1 := ev.Compile(bad, `viewer ==`)
+	_, err2 := ev.Eval(bad, `viewer ==`, newField())
+	if err1 == nil || err1 != err2 {
+		t.Errorf("expected the cached compile error, got %v and %v", err1, err2)
+	}
+	if _, err := ev.Compile(bad, `viewer == nil`); err != nil {
+		t.Errorf("expected the fixed source to compile, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package httpctx 把 *http.Request 包装为只读的 uwasa.Context，网关的路由与鉴权规则可以直接读取请求，
+// 无需为每个请求手工构造映射：
+//
+//	ctx := httpctx.NewWithOptions(r, httpctx.Options{Claims: verifyJWT})
+//	allowed, err := engine.ExecuteWithContext(ctx)
+//
+// 规则中可用的变量：
+//
+//	method   请求方法，如 "GET"
+//	path     URL 路径，如 "/api/orders/42"
+//	host     Host 头
+//	scheme   "https"（TLS 连接）或 "http"
+//	ip       对端地址中的 IP，不解析 X-Forwarded-For 等代理头
+//	headers  请求头，键为小写的头名，值为第一个值，如 headers["user-agent"]
+//	query    查询参数，值为第一个值，如 query["page"]
+//	cookies  Cookie，键为名称
+//	claims   Options.Claims 返回的身份声明，未配置或解析失败时为 nil
+//
+// headers、query、cookies 与 claims 在首次读取时构造并缓存，同一个 Context 可供多条规则依次使用，
+// 但不能在多个协程中同时使用。
+package httpctx
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrReadOnly 为规则向请求上下文赋值时返回的错误
+var ErrReadOnly = errors.New("httpctx: request context is read-only")
+
+// Options 配置请求上下文
+type Options struct {
+	// Claims 从请求中解析身份声明，通常校验 Authorization 头中的 JWT 并返回其 claims。
+	// 只在规则首次读取 claims 时调用一次；返回错误时 claims 为 nil，错误可由 Context.Err 取得
+	Claims func(r *http.Request) (map[string]any, error)
+}
+
+// Context 是一个请求的只读上下文，实现 uwasa.Context
+type Context struct {
+	r    *http.Request
+	opts Options
+
+	headers, query, cookies, claims map[string]any
+	claimsDone                      bool
+	err                             error
+}
+
+// New 返回 r 的请求上下文，不解析身份声明
+func New(r *http.Request) *Context {
+	return NewWithOptions(r, Options{})
+}
+
+// NewWithOptions 返回 r 的请求上下文
+func NewWithOptions(r *http.Request, opts Options) *Context {
+	return &Context{r: r, opts: opts}
+}
+
+// Get 实现 uwasa.Context，返回上述变量；其他名称不存在
+func (c *Context) Get(name string) (any, bool) {
+	switch name {
+	case "method":
+		return c.r.Method, true
+	case "path":
+		return c.r.URL.Path, true
+	case "host":
+		return c.r.Host, true
+	case "scheme":
+		if c.r.TLS != nil {
+			return "https", true
+		}
+		return "http", true
+	case "ip":
+		host, _, err := net.SplitHostPort(c.r.RemoteAddr)
+		if err != nil {
+			return c.r.RemoteAddr, true
+		}
+		return host, true
+	case "headers":
+		if c.headers == nil {
+			c.headers = make(map[string]any, len(c.r.Header))
+			for k, v := range c.r.Header {
+				if len(v) > 0 {
+					c.headers[strings.ToLower(k)] = v[0]
+				}
+			}
+		}
+		return c.headers, true
+	case "query":
+		if c.query == nil {
+			q := c.r.URL.Query()
+			c.query = make(map[string]any, len(q))
+			for k, v := range q {
+				if len(v) > 0 {
+					c.query[k] = v[0]
+				}
+			}
+		}
+		return c.query, true
+	case "cookies":
+		if c.cookies == nil {
+			c.cookies = make(map[string]any)
+			for _, ck := range c.r.Cookies() {
+				if _, ok := c.cookies[ck.Name]; !ok {
+					c.cookies[ck.Name] = ck.Value
+				}
+			}
+		}
+		return c.cookies, true
+	case "claims":
+		if !c.claimsDone && c.opts.Claims != nil {
+			c.claims, c.err = c.opts.Claims(c.r)
+			if c.err != nil {
+				c.claims = nil
+			}
+		}
+		c.claimsDone = true
+		if c.claims == nil {
+			return nil, true
+		}
+		return c.claims, true
+	}
+	return nil, false
+}
+
+// Set 实现 uwasa.Context。请求上下文是只读的，总是返回 ErrReadOnly
+func (c *Context) Set(name string, value any) error {
+	return ErrReadOnly
+}
+
+// Err 返回解析身份声明时的错误，claims 尚未被读取或解析成功时为 nil
+func (c *Context) Err() error {
+	return c.err
+}
@@ -0,0 +1,87 @@
+package httpctx
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+	"github.com/kamihama-railway/uwasa/internal/enginetest"
+)
+
+func newRequest() *http.Request {
+	r := httptest.NewRequest("POST", "https://api.example.com/admin/users?page=2&page=3&q=kyoko", nil)
+	r.RemoteAddr = "203.0.113.7:51234"
+	r.Header.Set("User-Agent", "curl/8.0")
+	r.Header.Set("Authorization", "Bearer token-admin")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	return r
+}
+
+func claims(r *http.Request) (map[string]any, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token != "token-admin" {
+		return nil, errors.New("invalid token")
+	}
+	return map[string]any{"sub": "u-1", "role": "admin"}, nil
+}
+
+func TestGet(t *testing.T) {
+	enginetest.Run(t, enginetest.Table{
+		{`method`, "POST"},
+		{`path`, "/admin/users"},
+		{`host`, "api.example.com"},
+		{`scheme`, "https"},
+		{`ip`, "203.0.113.7"},
+		{`ipInCIDR(ip, "203.0.113.0/24")`, true},
+		{`headers["user-agent"]`, "curl/8.0"},
+		{`headers?.missing`, nil},
+		{`query["page"]`, "2"},
+		{`int(query["page"]) + 1`, int64(3)},
+		{`cookies["session"]`, "s1"},
+		{`claims?.role`, "admin"},
+		{`method == "POST" && len(path) > 6 && claims?.role == "admin"`, true},
+		{`missing`, nil},
+	}, nil, func(engine *uwasa.Engine) (any, error) {
+		r := newRequest()
+		r.TLS = &tls.ConnectionState{}
+		return engine.ExecuteWithContext(NewWithOptions(r, Options{Claims: claims}))
+	})
+}
+
+func TestClaimsAndReadOnly(t *testing.T) {
+	calls := 0
+	r := newRequest()
+	r.Header.Set("Authorization", "Bearer forged")
+	ctx := NewWithOptions(r, Options{Claims: func(r *http.Request) (map[string]any, error) {
+		calls++
+		return claims(r)
+	}})
+	engine, _ := uwasa.NewEngineVMNeo(`claims == nil`)
+	for range 2 {
+		if got, err := engine.ExecuteWithContext(ctx); err != nil || got != true {
+			t.Errorf("expected nil claims for a forged token, got %v (%v)", got, err)
+		}
+	}
+	if calls != 1 || ctx.Err() == nil {
+		t.Errorf("expected the hook to run once and record its error, got %d calls, %v", calls, ctx.Err())
+	}
+
+	plain := New(httptest.NewRequest("GET", "/", nil))
+	if v, _ := plain.Get("claims"); v != nil {
+		t.Errorf("expected nil claims without a hook, got %v", v)
+	}
+	if v, _ := plain.Get("scheme"); v != "http" {
+		t.Errorf("expected http scheme, got %v", v)
+	}
+
+	// 各后端都把 Set 的错误作为执行错误返回
+	for name, assign := range enginetest.All(t, `method = "GET"; method`, uwasa.EngineOptions{OptimizationLevel: uwasa.OptBasic}) {
+		if _, err := assign.ExecuteWithContext(plain); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: expected ErrReadOnly, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package enginetest 供子包的测试在四种后端上编译并执行规则，与根包测试中的 backends、allEngines 对应。
+// 各子包只保留自己的用例表与执行方式。
+package enginetest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// Backends 返回四种后端以 opts 编译规则的函数；寄存器 VM 另设 UseRegisterVM，NeoVM 总是做常量折叠
+func Backends(opts uwasa.EngineOptions) map[string]func(string) (*uwasa.Engine, error) {
+	reg := opts
+	reg.UseRegisterVM = true
+	return map[string]func(string) (*uwasa.Engine, error){
+		"AST":        func(s string) (*uwasa.Engine, error) { return uwasa.NewEngineWithOptions(s, opts) },
+		"VM":         func(s string) (*uwasa.Engine, error) { return uwasa.NewEngineVMWithOptions(s, opts) },
+		"RegisterVM": func(s string) (*uwasa.Engine, error) { return uwasa.NewEngineVMWithOptions(s, reg) },
+		"NeoVM":      func(s string) (*uwasa.Engine, error) { return uwasa.NewEngineVMNeoWithOptions(s, opts) },
+	}
+}
+
+// All 以 opts 在四种后端上编译 src，按后端名返回；编译失败的后端记为测试错误并略去
+func All(t *testing.T, src string, opts uwasa.EngineOptions) map[string]*uwasa.Engine {
+	t.Helper()
+	engines := map[string]*uwasa.Engine{}
+	for name, compile := range Backends(opts) {
+		engine, err := compile(src)
+		if err != nil {
+			t.Errorf("%s %s: compile error: %v", name, src, err)
+			continue
+		}
+		engines[name] = engine
+	}
+	return engines
+}
+
+// Table 是用例表：每条的 Input 翻译为 uwasa 源码后在各后端执行，结果应等于 Expected
+type Table []struct {
+	Input    string
+	Expected any
+}
+
+// Run 逐条翻译 tests（translate 为 nil 时 Input 即 uwasa 源码），以 OptBasic 在四种后端上编译，用 exec 执行并比较结果。
+// 浮点结果先舍入到 1e-9，用例不必写出 0.1 × 3 这类运算的舍入误差
+func Run(t *testing.T, tests Table, translate func(string) (string, error), exec func(*uwasa.Engine) (any, error)) {
+	t.Helper()
+	for _, tt := range tests {
+		source := tt.Input
+		if translate != nil {
+			var err error
+			if source, err = translate(tt.Input); err != nil {
+				t.Errorf("%s: %v", tt.Input, err)
+				continue
+			}
+		}
+		for name, engine := range All(t, source, uwasa.EngineOptions{OptimizationLevel: uwasa.OptBasic}) {
+			got, err := exec(engine)
+			if f, ok := got.(float64); ok {
+				got = math.Round(f*1e9) / 1e9
+			}
+			if err != nil || got != tt.Expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.Input, tt.Expected, got, err)
+			}
+		}
+	}
+}
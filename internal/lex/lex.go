@@ -0,0 +1,170 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package lex 是把其他语言翻译为 uwasa 源码的子包（sqlwhere、formula）共用的记号读取器。
+// 各语言只提供识别单个记号的函数，空白、带引号的字符串、运算符与词法错误的处理在此完成。
+package lex
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Kind int
+
+const (
+	EOF Kind = iota
+	// Name 为名称或关键字
+	Name
+	// QuotedName 为带引号的名称，如 SQL 中的 "col"
+	QuotedName
+	String
+	Number
+	Op
+)
+
+type Token struct {
+	Kind Kind
+	Text string
+	Pos  int
+}
+
+func (t Token) String() string {
+	if t.Kind == EOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+// Scanner 逐个读取记号，当前记号为 Tok。遇到词法错误时记录错误并以 EOF 结束输入，
+// 其后 Fail 返回该词法错误，而不是由输入被截断引起的语法错误
+type Scanner struct {
+	Tok    Token
+	src    string
+	off    int
+	start  int
+	err    error
+	prefix string
+	scan   func(s *Scanner, c byte)
+}
+
+// New 创建从 src[off:] 开始读取的 Scanner。prefix 为错误信息的前缀（如 "sqlwhere"）；
+// scan 读取以 c 开头的一个记号，须经 Emit、Quoted、Ops 或 Errorf 设置 Tok
+func New(prefix, src string, off int, scan func(s *Scanner, c byte)) Scanner {
+	return Scanner{src: src, off: off, prefix: prefix, scan: scan}
+}
+
+// Next 跳过空白后读取下一个记号
+func (s *Scanner) Next() {
+	for s.off < len(s.src) && strings.IndexByte(" \t\r\n", s.src[s.off]) >= 0 {
+		s.off++
+	}
+	s.start = s.off
+	if s.off >= len(s.src) {
+		s.Tok = Token{Kind: EOF, Pos: s.start}
+		return
+	}
+	s.scan(s, s.src[s.off])
+}
+
+// Peek 返回当前位置之后第 i 个字节，越过输入结尾时返回 0
+func (s *Scanner) Peek(i int) byte {
+	if s.off+i < len(s.src) {
+		return s.src[s.off+i]
+	}
+	return 0
+}
+
+// Skip 跳过满足 pred 的字节
+func (s *Scanner) Skip(pred func(c byte) bool) {
+	for s.off < len(s.src) && pred(s.src[s.off]) {
+		s.off++
+	}
+}
+
+// Advance 跳过 n 个字节
+func (s *Scanner) Advance(n int) {
+	s.off = min(s.off+n, len(s.src))
+}
+
+// Text 返回当前记号已读取的原文
+func (s *Scanner) Text() string {
+	return s.src[s.start:s.off]
+}
+
+// Rest 返回尚未读取的输入
+func (s *Scanner) Rest() string {
+	return s.src[s.off:]
+}
+
+// Emit 以 text 结束当前记号
+func (s *Scanner) Emit(kind Kind, text string) {
+	s.Tok = Token{Kind: kind, Text: text, Pos: s.start}
+}
+
+// Quoted 读取以当前字节为引号的字符串，连写两个引号表示引号本身。what 为未闭合时错误信息中的名称
+func (s *Scanner) Quoted(kind Kind, what string) {
+	q := s.src[s.off]
+	var b strings.Builder
+	for s.off++; ; s.off++ {
+		if s.off >= len(s.src) {
+			s.Errorf("unterminated %s", what)
+			return
+		}
+		if s.src[s.off] == q {
+			if s.off+1 < len(s.src) && s.src[s.off+1] == q {
+				s.off++
+			} else {
+				break
+			}
+		}
+		b.WriteByte(s.src[s.off])
+	}
+	s.off++
+	s.Emit(kind, b.String())
+}
+
+// Ops 在当前位置依次尝试 ops 中的运算符，都不匹配时为词法错误。较长的运算符须排在其前缀之前
+func (s *Scanner) Ops(ops []string) {
+	for _, op := range ops {
+		if strings.HasPrefix(s.src[s.off:], op) {
+			s.off += len(op)
+			s.Emit(Op, op)
+			return
+		}
+	}
+	s.Errorf("unexpected character %q", s.src[s.off])
+}
+
+// Errorf 记录当前记号处的词法错误并结束输入
+func (s *Scanner) Errorf(format string, args ...any) {
+	s.err = fmt.Errorf("%s: %s at offset %d", s.prefix, fmt.Sprintf(format, args...), s.start)
+	s.Tok = Token{Kind: EOF, Pos: s.start}
+}
+
+// Err 返回记录的词法错误
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Is 判断当前记号是否为运算符 op
+func (s *Scanner) Is(op string) bool {
+	return s.Tok.Kind == Op && s.Tok.Text == op
+}
+
+// Expect 要求当前记号为运算符 op 并读取下一个记号
+func (s *Scanner) Expect(op string) error {
+	if !s.Is(op) {
+		return s.Fail("expected %q, got %s", op, s.Tok)
+	}
+	s.Next()
+	return nil
+}
+
+// Fail 返回当前记号处的语法错误；输入已因词法错误结束时返回该词法错误
+func (s *Scanner) Fail(format string, args ...any) error {
+	if s.err != nil {
+		return s.err
+	}
+	return fmt.Errorf("%s: %s at offset %d", s.prefix, fmt.Sprintf(format, args...), s.Tok.Pos)
+}
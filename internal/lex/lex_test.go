@@ -0,0 +1,54 @@
+package lex
+
+import "testing"
+
+func scanTest(s *Scanner, c byte) {
+	switch {
+	case c == '\'':
+		s.Quoted(String, "'")
+	case 'a' <= c && c <= 'z':
+		s.Skip(func(c byte) bool { return 'a' <= c && c <= 'z' })
+		s.Emit(Name, s.Text())
+	default:
+		s.Ops([]string{"<=", "<", "("})
+	}
+}
+
+func TestScanner(t *testing.T) {
+	s := New("test", `  ab <= 'it''s' <(`, 0, scanTest)
+	var got []Token
+	for s.Next(); s.Tok.Kind != EOF; s.Next() {
+		got = append(got, s.Tok)
+	}
+	want := []Token{{Name, "ab", 2}, {Op, "<=", 5}, {String, "it's", 8}, {Op, "<", 16}, {Op, "(", 17}}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("token %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+	if s.Err() != nil {
+		t.Errorf("unexpected error %v", s.Err())
+	}
+
+	// 词法错误截断输入后，Fail 返回词法错误而不是语法错误
+	for src, msg := range map[string]string{
+		`ab 'open`: "test: unterminated ' at offset 3",
+		`ab # c`:   `test: unexpected character '#' at offset 3`,
+	} {
+		s := New("test", src, 0, scanTest)
+		s.Next()
+		if err := s.Expect("("); err == nil || err.Error() != `test: expected "(", got "ab" at offset 0` {
+			t.Errorf("%s: unexpected syntax error %v", src, err)
+		}
+		s.Next()
+		if s.Tok.Kind != EOF {
+			t.Fatalf("%s: expected EOF after a lexical error, got %v", src, s.Tok)
+		}
+		if err := s.Fail("unexpected %s", s.Tok); err == nil || err.Error() != msg {
+			t.Errorf("%s: expected %q, got %v", src, msg, err)
+		}
+	}
+}
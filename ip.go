@@ -0,0 +1,87 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// ipArg 解析 IP 地址实参。IPv4 映射的 IPv6 地址（::ffff:a.b.c.d）按 IPv4 处理
+func ipArg(name string, v any) (netip.Addr, error) {
+	s, ok := v.(string)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("%s expects an IP address string, got %T", name, v)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%s: invalid IP address %q", name, s)
+	}
+	return addr.Unmap(), nil
+}
+
+// cidrPrefix 是解析后的 CIDR 网段，src 为原字符串
+type cidrPrefix struct {
+	src    string
+	prefix netip.Prefix
+}
+
+func (c *cidrPrefix) preparedFrom() (string, int, any) { return "ipInCIDR", 1, c.src }
+
+// parseCIDR 解析 `10.0.0.0/8` 形式的网段，主机位不必为 0
+func parseCIDR(v any) (*cidrPrefix, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("ipInCIDR expects a CIDR string, got %T", v)
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return nil, fmt.Errorf("ipInCIDR: invalid CIDR %q", s)
+	}
+	return &cidrPrefix{src: s, prefix: prefix.Masked()}, nil
+}
+
+// prepareCIDR 在编译期解析 ipInCIDR 的常量网段
+func prepareCIDR(i int, v any) (preparedArg, bool) {
+	if i != 1 {
+		return nil, false
+	}
+	c, err := parseCIDR(v)
+	if err != nil {
+		return nil, false
+	}
+	return c, true
+}
+
+// ipInCIDR 实现内置函数 ipInCIDR(ip, cidr)：ip 是否属于网段 cidr。网段为常量时在编译期解析，
+// 来自上下文时每次调用都重新解析。IPv4 地址不属于任何 IPv6 网段，反之亦然
+func ipInCIDR(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("ipInCIDR expects 2 arguments, got %d", len(args))
+	}
+	addr, err := ipArg("ipInCIDR", args[0])
+	if err != nil {
+		return nil, err
+	}
+	c, ok := args[1].(*cidrPrefix)
+	if !ok {
+		if c, err = parseCIDR(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	return c.prefix.Contains(addr), nil
+}
+
+// isPrivateIP 实现内置函数 isPrivateIP(ip)：ip 是否为内网地址，即 RFC 1918 与 RFC 4193 的私有地址、
+// 回环地址或链路本地地址
+func isPrivateIP(args ...any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("isPrivateIP expects 1 argument, got %d", len(args))
+	}
+	addr, err := ipArg("isPrivateIP", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast(), nil
+}
@@ -0,0 +1,48 @@
+package uwasa
+
+import "testing"
+
+func TestIP(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`ipInCIDR(ip, "10.0.0.0/8")`, true},
+		{`ipInCIDR(ip, "10.1.2.0/24")`, false},
+		{`ipInCIDR(ip, "10.1.3.4/16")`, true},
+		{`ipInCIDR("::ffff:10.1.3.4", "10.0.0.0/8")`, true},
+		{`ipInCIDR(ip, "::/0")`, false},
+		{`ipInCIDR("2001:db8::1", "2001:db8::/32")`, true},
+		{`ipInCIDR(ip, net)`, true},
+		{`ip |> ipInCIDR("192.168.0.0/16")`, false},
+		{`isPrivateIP(ip)`, true},
+		{`isPrivateIP("192.168.1.1") && isPrivateIP("172.16.0.1") && isPrivateIP("127.0.0.1") && isPrivateIP("fd00::1")`, true},
+		{`isPrivateIP("8.8.8.8") || isPrivateIP("172.32.0.1") || isPrivateIP("2001:4860::8888")`, false},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"ip": "10.1.3.4", "net": "10.1.0.0/16"}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`ipInCIDR(ip)`, `ipInCIDR("10.0.0.256", "10.0.0.0/8")`, `ipInCIDR(ip, "10.0.0.0/33")`, `ipInCIDR(ip, 8)`, `isPrivateIP(1)`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"slices"
+)
+
+// iterKind 为以 lambda 遍历数组的内置函数，也是各 VM 中 ITER 指令的参数
+type iterKind int32
+
+const (
+	iterMap iterKind = iota
+	iterFilter
+	iterReduce
+)
+
+// iterComprehension 与 iterKind 按位或，表示 ITER 遍历的是数组推导式 in 之后的值，只影响报错
+const iterComprehension iterKind = 1 << 8
+
+// iterNames 按 iterKind 排列遍历函数的名称
+var iterNames = [...]string{"map", "filter", "reduce"}
+
+// iterKindOf 返回名为 name 的遍历函数。编译器以此把 lambda 字面量为实参的调用内联为循环
+func iterKindOf(name string) (iterKind, bool) {
+	i := slices.Index(iterNames[:], name)
+	return iterKind(i), i >= 0
+}
+
+// params 为 lambda 的参数个数：reduce 为累加值与元素，其余为元素
+func (k iterKind) params() int {
+	if k == iterReduce {
+		return 2
+	}
+	return 1
+}
+
+// args 为调用的实参个数：reduce 另有累加初值
+func (k iterKind) args() int {
+	if k == iterReduce {
+		return 3
+	}
+	return 2
+}
+
+// iterBuiltin 返回遍历函数的内置实现，供 AST 解释器与 lambda 不是字面量的调用使用，
+// 每个元素都经由 Closure.Call 重新进入解释器。各 VM 内联的循环与其结果、报错一致
+func iterBuiltin(kind iterKind) BuiltinFunc {
+	name := iterNames[kind]
+	return func(args ...any) (any, error) {
+		if len(args) != kind.args() {
+			return nil, fmt.Errorf("%s expects %d arguments, got %d", name, kind.args(), len(args))
+		}
+		items, err := iterItems(kind, args[0])
+		if err != nil {
+			return nil, err
+		}
+		fn, ok := args[1].(*Closure)
+		if !ok || fn.Params() != kind.params() {
+			if kind == iterReduce {
+				return nil, fmt.Errorf("reduce expects a two-argument lambda")
+			}
+			return nil, fmt.Errorf("%s expects a one-argument lambda", name)
+		}
+		if kind == iterReduce {
+			acc := args[2]
+			for _, el := range items {
+				if acc, err = fn.Call(acc, el); err != nil {
+					return nil, err
+				}
+			}
+			return acc, nil
+		}
+		out := make([]any, 0, len(items))
+		for _, el := range items {
+			v, err := fn.Call(el)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case kind == iterMap:
+				out = append(out, v)
+			case isTruthy(v):
+				out = append(out, el)
+			}
+		}
+		return out, nil
+	}
+}
+
+// iterItems 取出被遍历的数组，由内置实现与各 VM 的 ITER 指令共用
+func iterItems(kind iterKind, v any) ([]any, error) {
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s expects an array, got %T", iterNames[kind], v)
+	}
+	return items, nil
+}
+
+// errComprehensionSource 报告数组推导式 in 之后的值不是数组。区间只用于 `x in a..b` 的比较，不展开为数组，同样被拒绝
+func errComprehensionSource(v any) error {
+	return fmt.Errorf("comprehension expects an array after in, got %T", v)
+}
+
+// iterStart 执行 ITER：检查被遍历的值是数组，返回循环使用的数组与初始下标
+func iterStart(kind iterKind, v Value) (Value, Value, error) {
+	if v.Type != ValArray {
+		if kind&iterComprehension != 0 {
+			return Value{}, Value{}, errComprehensionSource(v.ToInterface())
+		}
+		_, err := iterItems(kind, v.ToInterface())
+		return Value{}, Value{}, err
+	}
+	return v, Value{Type: ValInt}, nil
+}
+
+// iterNext 执行 ITERNEXT：下标未越界时返回当前元素并把下标加一，否则返回 false 结束循环
+func iterNext(items Value, idx *Value) (Value, bool) {
+	arr := items.Obj.([]any)
+	i := int(idx.Num)
+	if i >= len(arr) {
+		return Value{}, false
+	}
+	idx.Num++
+	return FromInterface(arr[i]), true
+}
+
+// appendValue 执行 APPEND：把 v 追加到循环新建的数组 acc 末尾。该数组不与其他值共享，可以原地追加
+func appendValue(acc, v Value) Value {
+	acc.Obj = append(acc.Obj.([]any), v.ToInterface())
+	return acc
+}
+
+// comprehensionAhead 判断以 `[` 开始的数组字面量是否为推导式 `[expr for x in items if cond]`：
+// 在词法分析器的副本 l 上从第一个元素的记号 tok 起向前查看，第一个元素之后、同一层括号中出现 `for x in` 时
+// 返回变量名 x。解析器与 NeoVM 编译器都需要在解析 expr 之前得知 x 是 lambda 参数
+func comprehensionAhead(l Lexer, tok Token) (string, bool) {
+	if tok.Type == TokenSpread {
+		return "", false
+	}
+	for depth := 0; ; tok = l.NextToken() {
+		switch tok.Type {
+		case TokenLParen, TokenLBracket, TokenLBrace:
+			depth++
+		case TokenRParen, TokenRBracket, TokenRBrace:
+			if depth == 0 {
+				return "", false
+			}
+			depth--
+		case TokenComma:
+			if depth == 0 {
+				return "", false
+			}
+		case TokenFor:
+			if depth == 0 {
+				name := l.NextToken()
+				return name.Literal, name.Type == TokenIdent && l.NextToken().Type == TokenIn
+			}
+		case TokenEOF:
+			return "", false
+		}
+	}
+}
+
+// iterCall 在 call 是以 lambda 字面量为实参、参数个数相符的 map、filter、reduce 调用时返回其种类与 lambda，
+// 供按 AST 编译的标准 VM 与寄存器 VM 内联为循环
+func iterCall(call *CallExpression) (iterKind, *LambdaLiteral, bool) {
+	ident, ok := call.Function.(*Identifier)
+	if !ok {
+		return 0, nil, false
+	}
+	kind, ok := iterKindOf(ident.Value)
+	if !ok || len(call.Arguments) != kind.args() {
+		return 0, nil, false
+	}
+	fn, ok := call.Arguments[1].(*LambdaLiteral)
+	if !ok || len(fn.Parameters) != kind.params() {
+		return 0, nil, false
+	}
+	return kind, fn, true
+}
@@ -0,0 +1,138 @@
+package uwasa
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIteration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`map(items, x -> x * 2)`, []any{int64(2), int64(6), int64(10)}, false},
+		{`map(items, x -> 1)`, []any{int64(1), int64(1), int64(1)}, false},
+		{`map(items, x -> concat("#", x))`, []any{"#1", "#3", "#5"}, false},
+		{`reduce(items, (s, x) -> s + x, 0)`, int64(9), false},
+		{`reduce(items, (s, x) -> s * 10 + x, a)`, int64(3135), false},
+		{`reduce([], (s, x) -> s + x, a)`, int64(3), false},
+		{`map([], x -> x / 0)`, []any{}, false},
+		{`items |> map(x -> x + a) |> len`, int64(3), false},
+		{`items |> reduce((s, x) -> s + x * x, 0)`, int64(35), false},
+		{`filter(map(items, x -> x * 2), x -> x > a * 2)`, []any{int64(10)}, false},
+		{`map([1, 2], x -> map([10, 20], y -> x * y))`, []any{[]any{int64(10), int64(20)}, []any{int64(20), int64(40)}}, false},
+		{`reduce(items, (s, x) -> s + reduce(items, (t, y) -> t + x * y, 0), 0)`, int64(81), false},
+		{`let k = a => map(items, x -> x + k)`, []any{int64(4), int64(6), int64(8)}, false},
+		{`fn dbl(xs) => map(xs, x -> x * 2); dbl(items)`, []any{int64(2), int64(6), int64(10)}, false},
+		{`let f = x -> x - 1 => map(items, f)`, []any{int64(0), int64(2), int64(4)}, false},
+		{`map(items, (x) -> x)`, nil, true},
+		{`map(a, x -> x)`, nil, true},
+		{`reduce(nothing, (s, x) -> s, 0)`, nil, true},
+		{`reduce(items, x -> x, 0)`, nil, true},
+		{`map(items, (x, y) -> x)`, nil, true},
+		{`map(items)`, nil, true},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"a": int64(3), "items": []any{int64(1), int64(3), int64(5)}, "nothing": nil}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				if !tt.err {
+					t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				}
+				continue
+			}
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				got, err := engine.ExecuteWithContext(ctx)
+				if tt.err {
+					if err == nil {
+						t.Errorf("%s %s: expected error, got %v", name, tt.input, got)
+					}
+					continue
+				}
+				if err != nil || !reflect.DeepEqual(got, tt.expected) {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+}
+
+func TestComprehension(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`[x * 2 for x in items]`, []any{int64(2), int64(6), int64(10)}, false},
+		{`[x for x in items if x > 1]`, []any{int64(3), int64(5)}, false},
+		{`[x * a for x in items if x > 1]`, []any{int64(9), int64(15)}, false},
+		{`[1 for x in items]`, []any{int64(1), int64(1), int64(1)}, false},
+		{`[x for x in []]`, []any{}, false},
+		{`[[y + x for y in [10, 20]] for x in items if x != 3]`, []any{[]any{int64(11), int64(21)}, []any{int64(15), int64(25)}}, false},
+		{`let k = 2 => [x + k for x in items if x % k == 1]`, []any{int64(3), int64(5), int64(7)}, false},
+		{`[concat(x, "!") for x in ["a", "b"]] |> len`, int64(2), false},
+		{`[x for x in items] == items`, true, false},
+		{`len([x for x in items if x > a]) + a`, int64(4), false},
+		{`[x for x in items if (x = 1) > 0]`, nil, true},
+		{`[x = 1 for x in items]`, nil, true},
+		{`[x for x in a]`, nil, true},
+		{`[for x in items]`, nil, true},
+		{`[x for x in items, 1]`, nil, true},
+		{`[x for 1 in items]`, nil, true},
+		{`for = 1`, nil, true},
+		// 先对全部元素求值条件，再对保留的元素求值表达式
+		{`[hits = hits + x for x in items if (hits = hits * 2) > 0], hits`, []any{[]any{int64(9), int64(12), int64(17)}, int64(17)}, false},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"a": int64(3), "hits": int64(1), "items": []any{int64(1), int64(3), int64(5)}}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				if !tt.err {
+					t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				}
+				continue
+			}
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				got, err := engine.ExecuteWithContext(ctx)
+				if tt.err {
+					if err == nil {
+						t.Errorf("%s %s: expected error, got %v", name, tt.input, got)
+					}
+					continue
+				}
+				if err != nil || !reflect.DeepEqual(got, tt.expected) {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+
+	// in 之后不是数组时按推导式报错；区间不展开为数组，同样被拒绝
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, in := range []string{`[x for x in a]`, `[x * 2 for x in 1..3]`, `[x for x in 1..3 if x > 1]`, `[x * 2 for x in a..5 if x > 1]`} {
+			engine, err := newEngine(in)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil || !strings.Contains(err.Error(), "comprehension expects an array after in") {
+				t.Errorf("%s %s: expected comprehension source error, got %v", name, in, err)
+			}
+		}
+	}
+
+	// 推导式改写为内联的 map 与 filter
+	ast, _ := NewEngine(`[x * 2 for x in items if x > 1]`)
+	if got := ast.program.String(); got != "map(filter(items, (x -> (x > 1))), (x -> (x * 2)))" {
+		t.Errorf("unexpected rewrite %s", got)
+	}
+}
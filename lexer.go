@@ -4,7 +4,9 @@
 package uwasa
 
 import (
+	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -38,11 +40,47 @@ const (
 	TokenRParen    // )
 	TokenComma     // ,
 	TokenBang      // !
+	TokenLBracket  // [
+	TokenRBracket  // ]
+	TokenLBrace    // {
+	TokenRBrace    // }
+	TokenColon     // :
+	TokenDot       // .
+	TokenIn        // in
+	TokenNotEq     // !=
+	// TokenBitAnd 至 TokenShr 的顺序与各 VM 的位运算指令一致，VM 据此换算运算符
+	TokenBitAnd    // &
+	TokenBitOr     // |
+	TokenBitXor    // ^
+	TokenShl       // <<
+	TokenShr       // >>
+	TokenAt        // @
+	TokenElif      // elif
+	TokenMatch     // match
+	TokenArrow     // =>
+	TokenLet       // let
+	TokenFn        // fn
+	TokenSemicolon // ;
+	TokenLambda    // ->
+	TokenPipe      // |>
+	TokenOptDot    // ?.
+	TokenRange     // ..
+	TokenSpread    // ...
+	TokenFor       // for
+	TokenTime      // @2024-01-01T00:00:00Z
+	TokenDuration  // 2h30m
+	TokenEqFold    // ==*
+	TokenDecimal   // 1.23d
+	TokenOperator  // RegisterOperator 注册的运算符，字面值为其符号
 )
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	// Newline 表示记号与上一个记号之间有换行，且不在任何括号之内；解析器据此分隔语句
+	Newline bool
+	// Pos 与 End 为记号在源码中的字节偏移（不含 End），供报告源码位置
+	Pos, End int
 }
 
 type Lexer struct {
@@ -50,6 +88,11 @@ type Lexer struct {
 	position     int
 	readPosition int
 	ch           byte
+	// depth 为当前未闭合的括号层数，newline 表示上一个记号之后跳过了换行
+	depth   int
+	newline bool
+	// numbers 为数字字面量的书写格式，见 EngineOptions.NumberFormat
+	numbers NumberFormat
 }
 
 var lexerPool = sync.Pool{
@@ -64,11 +107,31 @@ func NewLexer(input string) *Lexer {
 	return l
 }
 
+// IsIdentifier 判断 name 能否在规则源码中原样作为变量名或成员名，即恰为一个标识符记号；
+// 关键字与注册的单词运算符不是标识符
+func IsIdentifier(name string) bool {
+	l := NewLexer(name)
+	defer lexerPool.Put(l)
+	tok := l.NextToken()
+	return tok.Type == TokenIdent && tok.Literal == name && l.NextToken().Type == TokenEOF
+}
+
+var quoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "\x00", `\u{0}`)
+
+// Quote 把 s 写为规则源码中的字符串字面量，供把其他查询语言翻译为规则的工具使用。
+// 词法分析器把 NUL 当作输入结尾，NUL 因此同样被转义
+func Quote(s string) string {
+	return `"` + quoter.Replace(s) + `"`
+}
+
 func (l *Lexer) Reset(input string) {
 	l.input = input
 	l.position = 0
 	l.readPosition = 0
 	l.ch = 0
+	l.depth = 0
+	l.newline = false
+	l.numbers = NumberDefault
 	l.readChar()
 }
 
@@ -90,22 +153,57 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) NextToken() Token {
+	l.skipWhitespace()
+	newline := l.newline && l.depth == 0
+	l.newline = false
+	start := min(l.position, len(l.input))
+	tok := l.nextToken()
+	tok.Newline = newline
+	tok.Pos, tok.End = start, min(l.position, len(l.input))
+	switch tok.Type {
+	case TokenLParen, TokenLBracket, TokenLBrace:
+		l.depth++
+	case TokenRParen, TokenRBracket, TokenRBrace:
+		if l.depth > 0 {
+			l.depth--
+		}
+	}
+	return tok
+}
+
+func (l *Lexer) nextToken() Token {
 	var tok Token
 
-	l.skipWhitespace()
+	if symbolOperators.Load() != nil {
+		if tok, ok := l.readOperator(); ok {
+			return tok
+		}
+	}
 
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
 			l.readChar()
 			tok = Token{Type: TokenEq, Literal: "=="}
+			if l.peekChar() == '*' {
+				l.readChar()
+				tok = Token{Type: TokenEqFold, Literal: "==*"}
+			}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = Token{Type: TokenArrow, Literal: "=>"}
 		} else {
 			tok = Token{Type: TokenAssign, Literal: "="}
 		}
 	case '+':
 		tok = Token{Type: TokenPlus, Literal: "+"}
 	case '-':
-		tok = Token{Type: TokenMinus, Literal: "-"}
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok = Token{Type: TokenLambda, Literal: "->"}
+		} else {
+			tok = Token{Type: TokenMinus, Literal: "-"}
+		}
 	case '*':
 		tok = Token{Type: TokenAsterisk, Literal: "*"}
 	case '/':
@@ -116,6 +214,9 @@ func (l *Lexer) NextToken() Token {
 		if l.peekChar() == '=' {
 			l.readChar()
 			tok = Token{Type: TokenGe, Literal: ">="}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = Token{Type: TokenShr, Literal: ">>"}
 		} else {
 			tok = Token{Type: TokenGt, Literal: ">"}
 		}
@@ -123,6 +224,9 @@ func (l *Lexer) NextToken() Token {
 		if l.peekChar() == '=' {
 			l.readChar()
 			tok = Token{Type: TokenLe, Literal: "<="}
+		} else if l.peekChar() == '<' {
+			l.readChar()
+			tok = Token{Type: TokenShl, Literal: "<<"}
 		} else {
 			tok = Token{Type: TokenLt, Literal: "<"}
 		}
@@ -131,37 +235,107 @@ func (l *Lexer) NextToken() Token {
 			l.readChar()
 			tok = Token{Type: TokenAnd, Literal: "&&"}
 		} else {
-			tok = Token{Type: TokenIllegal, Literal: string(l.ch)}
+			tok = Token{Type: TokenBitAnd, Literal: "&"}
 		}
 	case '|':
 		if l.peekChar() == '|' {
 			l.readChar()
 			tok = Token{Type: TokenOr, Literal: "||"}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = Token{Type: TokenPipe, Literal: "|>"}
 		} else {
-			tok = Token{Type: TokenIllegal, Literal: string(l.ch)}
+			tok = Token{Type: TokenBitOr, Literal: "|"}
+		}
+	case '^':
+		tok = Token{Type: TokenBitXor, Literal: "^"}
+	case '@':
+		if isDigit(l.peekChar()) {
+			// @ 之后紧跟数字的是时间字面量，记号的字面值不含 @
+			l.readChar()
+			return Token{Type: TokenTime, Literal: l.readTime()}
 		}
+		tok = Token{Type: TokenAt, Literal: "@"}
 	case '(':
 		tok = Token{Type: TokenLParen, Literal: "("}
 	case ')':
 		tok = Token{Type: TokenRParen, Literal: ")"}
 	case ',':
 		tok = Token{Type: TokenComma, Literal: ","}
+	case ';':
+		tok = Token{Type: TokenSemicolon, Literal: ";"}
 	case '!':
-		tok = Token{Type: TokenBang, Literal: "!"}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = Token{Type: TokenNotEq, Literal: "!="}
+		} else {
+			tok = Token{Type: TokenBang, Literal: "!"}
+		}
+	case '[':
+		tok = Token{Type: TokenLBracket, Literal: "["}
+	case ']':
+		tok = Token{Type: TokenRBracket, Literal: "]"}
+	case '{':
+		tok = Token{Type: TokenLBrace, Literal: "{"}
+	case '}':
+		tok = Token{Type: TokenRBrace, Literal: "}"}
+	case ':':
+		tok = Token{Type: TokenColon, Literal: ":"}
+	case '.':
+		if l.peekChar() == '.' {
+			l.readChar()
+			if l.peekChar() == '.' {
+				l.readChar()
+				tok = Token{Type: TokenSpread, Literal: "..."}
+			} else {
+				tok = Token{Type: TokenRange, Literal: ".."}
+			}
+		} else {
+			tok = Token{Type: TokenDot, Literal: "."}
+		}
+	case '?':
+		if l.peekChar() == '.' {
+			l.readChar()
+			tok = Token{Type: TokenOptDot, Literal: "?."}
+		} else {
+			tok = Token{Type: TokenIllegal, Literal: "?"}
+		}
 	case '"':
-		tok.Type = TokenString
-		tok.Literal = l.readString()
+		tok = l.readString()
+	case '`':
+		tok = l.readRawString()
 	case 0:
 		tok.Literal = ""
 		tok.Type = TokenEOF
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
+			if op, ok := wordOperators[tok.Literal]; ok {
+				tok.Type, tok.Literal = op.Type, op.Literal
+				return tok
+			}
+			if _, ok := operators.get(tok.Literal); ok {
+				tok.Type = TokenOperator
+				return tok
+			}
 			tok.Type = lookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
+			if l.numbers == NumberDefault {
+				tok.Literal = l.readNumber()
+			} else if lit, ok := l.readLocaleNumber(); ok {
+				tok.Literal = lit
+			} else {
+				return Token{Type: TokenIllegal, Literal: lit}
+			}
 			tok.Type = TokenNumber
+			position := l.position
+			if l.readDurationTail() {
+				tok = Token{Type: TokenDuration, Literal: tok.Literal + l.input[position:l.position]}
+			} else if l.ch == 'd' && !isLetter(l.peekChar()) && !isDigit(l.peekChar()) {
+				l.readChar()
+				tok = Token{Type: TokenDecimal, Literal: tok.Literal + "d"}
+			}
 			return tok
 		} else {
 			tok = Token{Type: TokenIllegal, Literal: string(l.ch)}
@@ -174,6 +348,9 @@ func (l *Lexer) NextToken() Token {
 
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+		if l.ch == '\n' {
+			l.newline = true
+		}
 		l.readChar()
 	}
 }
@@ -186,24 +363,176 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
+// readNumber 读取数字字面量。0x、0b、0o 开头的整数读到字母数字串结束，由解析器校验各位；
+// 十进制数中可以用 _ 分隔数字，如 1_000_000，并可带有 1.5e6、2E-3 形式的指数
 func (l *Lexer) readNumber() string {
 	position := l.position
-	for isDigit(l.ch) || l.ch == '.' {
+	if l.ch == '0' && isBasePrefix(l.peekChar()) {
 		l.readChar()
+		l.readChar()
+		for isLetter(l.ch) || isDigit(l.ch) {
+			l.readChar()
+		}
+		return l.input[position:l.position]
 	}
+	for isDigit(l.ch) || l.ch == '_' || l.ch == '.' {
+		// `1..10` 中的 .. 是区间运算符，不属于数字
+		if l.ch == '.' && l.peekChar() == '.' {
+			break
+		}
+		l.readChar()
+	}
+	l.readExponent()
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readString() string {
+// readExponent 读取数字之后的指数部分。e 或 E 之后（可带符号）紧跟数字时才是指数，否则 e 属于其后的标识符
+func (l *Lexer) readExponent() {
+	if l.ch == 'e' || l.ch == 'E' {
+		i := l.readPosition
+		if i < len(l.input) && (l.input[i] == '+' || l.input[i] == '-') {
+			i++
+		}
+		if i < len(l.input) && isDigit(l.input[i]) {
+			for l.position < i {
+				l.readChar()
+			}
+			for isDigit(l.ch) || l.ch == '_' {
+				l.readChar()
+			}
+		}
+	}
+}
+
+// readTime 读取时间字面量中的数字、日期与时间的分隔符以及时区，由解析器按 RFC 3339 校验
+func (l *Lexer) readTime() string {
+	position := l.position
+	for isDigit(l.ch) || l.ch != 0 && strings.IndexByte("-:.+TZtz", l.ch) >= 0 {
+		l.readChar()
+	}
+	return l.input[position:l.position]
+}
+
+// durationUnits 为时长字面量的单位，较长的单位在前
+var durationUnits = [...]string{"ns", "us", "µs", "ms", "h", "m", "s"}
+
+// readDurationTail 在刚读完的数字之后紧跟时长单位时读完整个时长，如 5m、2h30m、1.5s，
+// 返回 true；单位之后还有字母数字（如 5min）或没有单位时不移动位置，返回 false
+func (l *Lexer) readDurationTail() bool {
+	i := l.position
+	for {
+		n := 0
+		for _, u := range durationUnits {
+			if strings.HasPrefix(l.input[i:], u) {
+				n = len(u)
+				break
+			}
+		}
+		if n == 0 {
+			return false
+		}
+		i += n
+		if i >= len(l.input) || !isDigit(l.input[i]) {
+			break
+		}
+		for i < len(l.input) && (isDigit(l.input[i]) || l.input[i] == '_' || l.input[i] == '.') {
+			i++
+		}
+	}
+	if i < len(l.input) && (isLetter(l.input[i]) || isDigit(l.input[i])) {
+		return false
+	}
+	for l.position < i {
+		l.readChar()
+	}
+	return true
+}
+
+// readString 读取双引号字符串并解码转义序列 \n、\t、\r、\"、\\ 与 \u{十六进制码点}。
+// 未闭合的字符串或无法识别的转义返回 TokenIllegal，结束时 l.ch 停在闭合的引号上
+func (l *Lexer) readString() Token {
+	start := l.position
 	l.readChar() // skip "
 	position := l.position
-	for l.ch != '"' && l.ch != 0 {
+	for l.ch != '"' && l.ch != '\\' && l.ch != 0 {
+		l.readChar()
+	}
+	// 不含转义的字符串直接引用输入，不产生分配
+	if l.ch == '"' {
+		return Token{Type: TokenString, Literal: l.input[position:l.position]}
+	}
+	var b strings.Builder
+	b.WriteString(l.input[position:l.position])
+	for l.ch != '"' {
+		switch l.ch {
+		case 0:
+			return Token{Type: TokenIllegal, Literal: l.input[start:]}
+		case '\\':
+			l.readChar()
+			switch l.ch {
+			case 'n': b.WriteByte('\n')
+			case 't': b.WriteByte('\t')
+			case 'r': b.WriteByte('\r')
+			case '"': b.WriteByte('"')
+			case '\\': b.WriteByte('\\')
+			case 'u':
+				r, ok := l.readCodePoint()
+				if !ok {
+					return Token{Type: TokenIllegal, Literal: l.input[start:min(l.readPosition, len(l.input))]}
+				}
+				b.WriteRune(r)
+			default:
+				return Token{Type: TokenIllegal, Literal: l.input[start:min(l.readPosition, len(l.input))]}
+			}
+		default:
+			b.WriteByte(l.ch)
+		}
+		l.readChar()
+	}
+	return Token{Type: TokenString, Literal: b.String()}
+}
+
+// readCodePoint 读取 \u 之后的 {十六进制码点}，当前字符为 u，结束时停在 }
+func (l *Lexer) readCodePoint() (rune, bool) {
+	if l.peekChar() != '{' {
+		return 0, false
+	}
+	l.readChar()
+	var r rune
+	for digits := 0; ; digits++ {
+		l.readChar()
+		var d byte
+		switch {
+		case l.ch == '}' && digits > 0:
+			return r, utf8.ValidRune(r)
+		case isDigit(l.ch):
+			d = l.ch - '0'
+		case 'a' <= l.ch && l.ch <= 'f':
+			d = l.ch - 'a' + 10
+		case 'A' <= l.ch && l.ch <= 'F':
+			d = l.ch - 'A' + 10
+		default:
+			return 0, false
+		}
+		if digits == 6 {
+			return 0, false
+		}
+		r = r<<4 | rune(d)
+	}
+}
+
+// readRawString 读取反引号字符串，内容原样保留（可跨行），不处理任何转义
+func (l *Lexer) readRawString() Token {
+	start := l.position
+	l.readChar() // skip `
+	position := l.position
+	for l.ch != '`' && l.ch != 0 {
 		l.readChar()
 	}
-	str := l.input[position:l.position]
-	// l.readChar() // would be done by caller or next read?
-	// Actually we should skip the closing quote
-	return str
+	if l.ch == 0 {
+		return Token{Type: TokenIllegal, Literal: l.input[start:]}
+	}
+	return Token{Type: TokenString, Literal: l.input[position:l.position]}
 }
 
 func isLetter(ch byte) bool {
@@ -214,6 +543,15 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// isBasePrefix 判断 ch 是否为 0x、0b、0o 整数前缀中 0 之后的字母
+func isBasePrefix(ch byte) bool {
+	switch ch {
+	case 'x', 'X', 'b', 'B', 'o', 'O':
+		return true
+	}
+	return false
+}
+
 var keywords = map[string]TokenType{
 	"if":    TokenIf,
 	"is":    TokenIs,
@@ -221,6 +559,20 @@ var keywords = map[string]TokenType{
 	"then":  TokenThen,
 	"true":  TokenTrue,
 	"false": TokenFalse,
+	"in":    TokenIn,
+	"elif":  TokenElif,
+	"match": TokenMatch,
+	"let":   TokenLet,
+	"fn":    TokenFn,
+	"for":   TokenFor,
+}
+
+// wordOperators 是逻辑运算符的单词写法，在词法分析时即转换为对应的运算符记号，
+// 因此 `a and not b` 与 `a && !b` 的编译结果完全相同；这些单词不能再用作变量名或成员名
+var wordOperators = map[string]Token{
+	"and": {Type: TokenAnd, Literal: "&&"},
+	"or":  {Type: TokenOr, Literal: "||"},
+	"not": {Type: TokenBang, Literal: "!"},
 }
 
 func lookupIdent(ident string) TokenType {
@@ -260,6 +612,37 @@ func (t TokenType) String() string {
 	case TokenRParen: return ")"
 	case TokenComma: return ","
 	case TokenBang: return "!"
+	case TokenLBracket: return "["
+	case TokenRBracket: return "]"
+	case TokenLBrace: return "{"
+	case TokenRBrace: return "}"
+	case TokenColon: return ":"
+	case TokenDot: return "."
+	case TokenIn: return "in"
+	case TokenNotEq: return "!="
+	case TokenBitAnd: return "&"
+	case TokenBitOr: return "|"
+	case TokenBitXor: return "^"
+	case TokenShl: return "<<"
+	case TokenShr: return ">>"
+	case TokenAt: return "@"
+	case TokenElif: return "elif"
+	case TokenMatch: return "match"
+	case TokenArrow: return "=>"
+	case TokenLet: return "let"
+	case TokenFn: return "fn"
+	case TokenSemicolon: return ";"
+	case TokenLambda: return "->"
+	case TokenPipe: return "|>"
+	case TokenOptDot: return "?."
+	case TokenRange: return ".."
+	case TokenSpread: return "..."
+	case TokenFor: return "for"
+	case TokenTime: return "TIME"
+	case TokenDuration: return "DURATION"
+	case TokenEqFold: return "==*"
+	case TokenDecimal: return "DECIMAL"
+	case TokenOperator: return "OPERATOR"
 	default: return "UNKNOWN"
 	}
 }
@@ -1,6 +1,8 @@
 package uwasa
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -104,6 +106,37 @@ func TestLexerNumbersAndIdents(t *testing.T) {
 	lexerPool.Put(l)
 }
 
+func TestLexerNumberForms(t *testing.T) {
+	type want struct {
+		typ TokenType
+		lit string
+	}
+	tests := []struct {
+		input    string
+		expected []want
+	}{
+		{`1..10`, []want{{TokenNumber, "1"}, {TokenRange, ".."}, {TokenNumber, "10"}}},
+		{`1.5..x`, []want{{TokenNumber, "1.5"}, {TokenRange, ".."}, {TokenIdent, "x"}}},
+		{`[...a]`, []want{{TokenLBracket, "["}, {TokenSpread, "..."}, {TokenIdent, "a"}, {TokenRBracket, "]"}}},
+		{`0xFF`, []want{{TokenNumber, "0xFF"}}},
+		{`0b1010+0o755`, []want{{TokenNumber, "0b1010"}, {TokenPlus, "+"}, {TokenNumber, "0o755"}}},
+		{`1_000.5`, []want{{TokenNumber, "1_000.5"}}},
+		{`1.5e6`, []want{{TokenNumber, "1.5e6"}}},
+		{`2E-3-1e+2`, []want{{TokenNumber, "2E-3"}, {TokenMinus, "-"}, {TokenNumber, "1e+2"}}},
+		{`3else`, []want{{TokenNumber, "3"}, {TokenElse, "else"}}},
+	}
+	for _, tt := range tests {
+		l := NewLexer(tt.input)
+		for i, w := range append(tt.expected, want{TokenEOF, ""}) {
+			if tok := l.NextToken(); tok.Type != w.typ || tok.Literal != w.lit {
+				t.Errorf("%s: token %d: expected %s %q, got %s %q", tt.input, i, w.typ, w.lit, tok.Type, tok.Literal)
+				break
+			}
+		}
+		lexerPool.Put(l)
+	}
+}
+
 func TestLexer2(t *testing.T) {
 	input := `if a == 0 && b >= 1 then b = b + 10`
 	tests := []struct {
@@ -140,14 +173,66 @@ func TestLexer2(t *testing.T) {
 	}
 }
 
+func TestLexerStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{`"plain"`, TokenString, "plain"},
+		{`"a\nb\tc\rd"`, TokenString, "a\nb\tc\rd"},
+		{`"say \"hi\" \\ ok"`, TokenString, `say "hi" \ ok`},
+		{`"\u{41}\u{e9}\u{1F600}"`, TokenString, "Aé😀"},
+		{"`a\\d+\\n\"`", TokenString, `a\d+\n"`},
+		{"`two\nlines`", TokenString, "two\nlines"},
+		{`""`, TokenString, ""},
+		{"``", TokenString, ""},
+		{`"open`, TokenIllegal, `"open`},
+		{"`open", TokenIllegal, "`open"},
+		{`"bad \q"`, TokenIllegal, `"bad \q`},
+		{`"\u{}"`, TokenIllegal, `"\u{}`},
+		{`"\u{110000}"`, TokenIllegal, `"\u{110000}`},
+		{`"\u{1234567}"`, TokenIllegal, `"\u{1234567`},
+		{`"\u41"`, TokenIllegal, `"\u`},
+		{`"end\`, TokenIllegal, `"end\`},
+	}
+	for _, tt := range tests {
+		l := NewLexer(tt.input)
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("%s: expected %s %q, got %s %q", tt.input, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tt.expectedType == TokenString {
+			if tok := l.NextToken(); tok.Type != TokenEOF {
+				t.Errorf("%s: expected EOF after string, got %s %q", tt.input, tok.Type, tok.Literal)
+			}
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"", "plain", `say "hi" \ ok`, "a\nb\tc\rd", "x\x00y", "é😀", `\u{41}`} {
+		l := NewLexer(Quote(s))
+		tok := l.NextToken()
+		if tok.Type != TokenString || tok.Literal != s || l.NextToken().Type != TokenEOF {
+			t.Errorf("%q: Quote gave %s, which lexes as %s %q", s, Quote(s), tok.Type, tok.Literal)
+		}
+	}
+	for name, want := range map[string]bool{"a": true, "_x1": true, "if": false, "a.b": false, "1a": false, "": false, "a b": false} {
+		if got := IsIdentifier(name); got != want {
+			t.Errorf("IsIdentifier(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
 func TestLexerIllegal(t *testing.T) {
-	input := `a & b`
+	input := `a $ b`
 	tests := []struct {
 		expectedType    TokenType
 		expectedLiteral string
 	}{
 		{TokenIdent, "a"},
-		{TokenIllegal, "&"},
+		{TokenIllegal, "$"},
 		{TokenIdent, "b"},
 		{TokenEOF, ""},
 	}
@@ -164,3 +249,332 @@ func TestLexerIllegal(t *testing.T) {
 		}
 	}
 }
+
+func TestLexerNotEq(t *testing.T) {
+	input := `a != !b ==* c == *d`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TokenIdent, "a"},
+		{TokenNotEq, "!="},
+		{TokenBang, "!"},
+		{TokenIdent, "b"},
+		{TokenEqFold, "==*"},
+		{TokenIdent, "c"},
+		{TokenEq, "=="},
+		{TokenAsterisk, "*"},
+		{TokenIdent, "d"},
+		{TokenEOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestLexerWordOperators(t *testing.T) {
+	input := `a and not b or android`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TokenIdent, "a"},
+		{TokenAnd, "&&"},
+		{TokenBang, "!"},
+		{TokenIdent, "b"},
+		{TokenOr, "||"},
+		{TokenIdent, "android"},
+		{TokenEOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestLexerBitwise(t *testing.T) {
+	input := `a & b | c ^ d << 2 >> 1 && e || f <= g >= h |> k?.m`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TokenIdent, "a"},
+		{TokenBitAnd, "&"},
+		{TokenIdent, "b"},
+		{TokenBitOr, "|"},
+		{TokenIdent, "c"},
+		{TokenBitXor, "^"},
+		{TokenIdent, "d"},
+		{TokenShl, "<<"},
+		{TokenNumber, "2"},
+		{TokenShr, ">>"},
+		{TokenNumber, "1"},
+		{TokenAnd, "&&"},
+		{TokenIdent, "e"},
+		{TokenOr, "||"},
+		{TokenIdent, "f"},
+		{TokenLe, "<="},
+		{TokenIdent, "g"},
+		{TokenGe, ">="},
+		{TokenIdent, "h"},
+		{TokenPipe, "|>"},
+		{TokenIdent, "k"},
+		{TokenOptDot, "?."},
+		{TokenIdent, "m"},
+		{TokenEOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestLexerNewline(t *testing.T) {
+	input := "a\n(b\n c)\n  [d]\n"
+	tests := []struct {
+		expectedType TokenType
+		newline      bool
+	}{
+		{TokenIdent, false},
+		{TokenLParen, true},
+		{TokenIdent, false},
+		{TokenIdent, false},
+		{TokenRParen, false},
+		{TokenLBracket, true},
+		{TokenIdent, false},
+		{TokenRBracket, false},
+		{TokenEOF, true},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Newline != tt.newline {
+			t.Fatalf("tests[%d] - expected %s (newline %v), got %s (newline %v)",
+				i, tt.expectedType, tt.newline, tok.Type, tok.Newline)
+		}
+	}
+}
+
+func TestLexerTemporal(t *testing.T) {
+	input := `@2024-01-01T09:00:00+09:00 - 2h30m > 1.5s @name 5min 5m3 5ms 10µs`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TokenTime, "2024-01-01T09:00:00+09:00"},
+		{TokenMinus, "-"},
+		{TokenDuration, "2h30m"},
+		{TokenGt, ">"},
+		{TokenDuration, "1.5s"},
+		{TokenAt, "@"},
+		{TokenIdent, "name"},
+		{TokenNumber, "5"},
+		{TokenIdent, "min"},
+		{TokenNumber, "5"},
+		{TokenIdent, "m3"},
+		{TokenDuration, "5ms"},
+		{TokenDuration, "10µs"},
+		{TokenEOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestLexerDecimal(t *testing.T) {
+	input := `1.23d * 2d - 1_000.5d 0x1d 5days 3d2`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TokenDecimal, "1.23d"},
+		{TokenAsterisk, "*"},
+		{TokenDecimal, "2d"},
+		{TokenMinus, "-"},
+		{TokenDecimal, "1_000.5d"},
+		{TokenNumber, "0x1d"},
+		{TokenNumber, "5"},
+		{TokenIdent, "days"},
+		{TokenNumber, "3"},
+		{TokenIdent, "d2"},
+		{TokenEOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestLexerNumberFormat(t *testing.T) {
+	tests := []struct {
+		numbers NumberFormat
+		input   string
+		tokens  []Token
+	}{
+		{NumberGrouped, `1,234,567.89 max(1,5) 1,2345 12,345d 0x1f 1.5e3`, []Token{
+			{Type: TokenNumber, Literal: "1234567.89"},
+			{Type: TokenIdent, Literal: "max"}, {Type: TokenLParen, Literal: "("}, {Type: TokenNumber, Literal: "1"},
+			{Type: TokenComma, Literal: ","}, {Type: TokenNumber, Literal: "5"}, {Type: TokenRParen, Literal: ")"},
+			{Type: TokenNumber, Literal: "1"}, {Type: TokenComma, Literal: ","}, {Type: TokenNumber, Literal: "2345"},
+			{Type: TokenDecimal, Literal: "12345d"}, {Type: TokenNumber, Literal: "0x1f"}, {Type: TokenNumber, Literal: "1.5e3"},
+		}},
+		{NumberDecimalComma, `1.234.567,89 1,5s [1,5, 2] 1..10 1.5 3`, []Token{
+			{Type: TokenNumber, Literal: "1234567.89"}, {Type: TokenDuration, Literal: "1.5s"},
+			{Type: TokenLBracket, Literal: "["}, {Type: TokenNumber, Literal: "1.5"}, {Type: TokenComma, Literal: ","},
+			{Type: TokenNumber, Literal: "2"}, {Type: TokenRBracket, Literal: "]"},
+			{Type: TokenNumber, Literal: "1"}, {Type: TokenRange, Literal: ".."}, {Type: TokenNumber, Literal: "10"},
+			{Type: TokenIllegal, Literal: "1.5"}, {Type: TokenNumber, Literal: "3"},
+		}},
+	}
+	for _, tt := range tests {
+		l := NewLexer(tt.input)
+		l.numbers = tt.numbers
+		for i, want := range append(tt.tokens, Token{Type: TokenEOF}) {
+			if tok := l.NextToken(); tok.Type != want.Type || tok.Literal != want.Literal {
+				t.Fatalf("%q tokens[%d] - expected %s %q, got %s %q", tt.input, i, want.Type, want.Literal, tok.Type, tok.Literal)
+			}
+		}
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`0xFF`, int64(255)},
+		{`0Xff + 1`, int64(256)},
+		{`0b1010`, int64(10)},
+		{`0o755`, int64(493)},
+		{`1_000_000`, int64(1000000)},
+		{`1_000.5`, 1000.5},
+		{`-0x10`, int64(-16)},
+		{`0x7FFF_FFFF_FFFF_FFFF`, int64(9223372036854775807)},
+		{`010`, int64(10)},
+		{`flags & 0b0100 != 0`, true},
+		{`flags | 0x100`, int64(262)},
+		{`x == 0o17`, true},
+		{`[0x1, 0b1, 0o1, 1_0]`, []any{int64(1), int64(1), int64(1), int64(10)}},
+		{`1.5e6`, 1.5e6},
+		{`2E-3`, 0.002},
+		{`1e3`, 1000.0},
+		{`typeof(1e3)`, "float"},
+		{`1_000e-3 + 1E+1`, 11.0},
+		{`x * 1e2`, 1500.0},
+		{`-2.5e-1`, -0.25},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"flags": int64(6), "x": int64(15)})
+			if err != nil || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`0x`, `0xFG`, `0b102`, `0o8`, `0x1.5`, `1__000`, `1_`, `1_.5`, `0x8000_0000_0000_0000`, `1e`, `1e_3`, `1e3_`} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected compile error", name, bad)
+			}
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`concat("say \"", name, "\"")`, `say "uwasa"`},
+		{`name == "uw\u{61}sa"`, true},
+		{"len(`a\\d+`) + len(\"\\t\")", int64(5)},
+		{"concat(`C:\\dir\\`, name)", `C:\dir\uwasa`},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"name": "uwasa"})
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %q, got %q (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+
+		for _, bad := range []string{`"open`, "`open", `name == "\q"`, `"\u{d800}"`} {
+			if _, err := newEngine(bad); err == nil || !strings.Contains(err.Error(), "illegal token") {
+				t.Errorf("%s %s: expected illegal token error, got %v", name, bad, err)
+			}
+		}
+	}
+}
+
+func TestWordOperators(t *testing.T) {
+	// 单词写法与符号写法编译结果相同
+	tests := []struct{ words, symbols string }{
+		{`a > 1 and b < 5`, `a > 1 && b < 5`},
+		{`a > 10 or not flag`, `a > 10 || !flag`},
+		{`not (a > 1 and b > 1) or flag`, `!(a > 1 && b > 1) || flag`},
+		{`if a > 1 and not flag is "ok" else is "no"`, `if a > 1 && !flag is "ok" else is "no"`},
+	}
+	vars := map[string]any{"a": int64(3), "b": int64(2), "flag": false}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			we, err := newEngine(tt.words)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.words, err)
+				continue
+			}
+			se, _ := newEngine(tt.symbols)
+			got, err := we.Execute(vars)
+			want, _ := se.Execute(vars)
+			if err != nil || got != want {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.words, want, got, err)
+			}
+		}
+	}
+	a, _, _ := ParseRule(`a and not b`)
+	b, _, _ := ParseRule(`a && !b`)
+	if a == nil || b == nil || a.String() != b.String() {
+		t.Errorf("expected identical trees, got %v and %v", a, b)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// MessageCatalog 是内置函数 t 查询的消息目录，可以接入 go-i18n 等本地化库
+type MessageCatalog interface {
+	// Message 返回 key 对应、以 args 填充后的文本。args 为规则中 t 的其余参数；
+	// 找不到 key 时应返回错误
+	Message(key string, args []any) (string, error)
+}
+
+// catalogRef 包装 MessageCatalog，使不同实现可以存入同一个 atomic.Pointer
+type catalogRef struct {
+	catalog MessageCatalog
+}
+
+var messageCatalog atomic.Pointer[catalogRef]
+
+// SetMessageCatalog 设置内置函数 t 使用的消息目录，传入 nil 清除。
+// 可以在规则执行期间并发调用，用于热更新翻译；正在执行的调用使用调用时的目录。
+func SetMessageCatalog(c MessageCatalog) {
+	if c == nil {
+		messageCatalog.Store(nil)
+		return
+	}
+	messageCatalog.Store(&catalogRef{catalog: c})
+}
+
+// translate 实现内置函数 t(key, args...)。目录可能在两次执行之间被替换，t 不是纯函数
+func translate(args ...any) (any, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("t expects a message key")
+	}
+	key, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("t expects a string key, got %T", args[0])
+	}
+	ref := messageCatalog.Load()
+	if ref == nil {
+		return nil, fmt.Errorf("t: no message catalog set")
+	}
+	return ref.catalog.Message(key, args[1:])
+}
+
+// MapCatalog 是以消息键到模板的映射实现的 MessageCatalog。模板中的 {0}、{1} 依次替换为
+// 参数的文本（格式与 concat 相同），超出参数个数的占位符原样保留。
+// 需要按语言区分时可以把语言放进键中，如 t(concat(lang, ".welcome"), name)。
+type MapCatalog map[string]string
+
+func (m MapCatalog) Message(key string, args []any) (string, error) {
+	tmpl, ok := m[key]
+	if !ok {
+		return "", fmt.Errorf("message %q not found", key)
+	}
+	if len(args) == 0 || !strings.Contains(tmpl, "{") {
+		return tmpl, nil
+	}
+	var b strings.Builder
+	for {
+		open := strings.IndexByte(tmpl, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(tmpl[open:], '}')
+		if end < 0 {
+			break
+		}
+		end += open
+		b.WriteString(tmpl[:open])
+		if i, err := strconv.Atoi(tmpl[open+1 : end]); err == nil && i >= 0 && i < len(args) {
+			b.WriteString(concatText(args[i]))
+		} else {
+			b.WriteString(tmpl[open : end+1])
+		}
+		tmpl = tmpl[end+1:]
+	}
+	b.WriteString(tmpl)
+	return b.String(), nil
+}
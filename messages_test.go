@@ -0,0 +1,64 @@
+package uwasa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMessageCatalog(t *testing.T) {
+	catalog := MapCatalog{
+		"zh.welcome": "欢迎，{0}！您有 {1} 条新消息",
+		"en.welcome": "Welcome, {0}! You have {1} new messages",
+		"en.total":   "Total: {0} ({2})",
+		"plain":      "{0} stays",
+	}
+	SetMessageCatalog(catalog)
+	t.Cleanup(func() { SetMessageCatalog(nil) })
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`t(concat(lang, ".welcome"), name, n)`, "欢迎，小明！您有 3 条新消息"},
+		{`t("en.welcome", name, n + 1)`, "Welcome, 小明! You have 4 new messages"},
+		{`t("en.total", 1.5)`, "Total: 1.5 ({2})"},
+		{`t("plain")`, "{0} stays"},
+		{`lang |> concat(".welcome") |> t(name, 0)`, "欢迎，小明！您有 0 条新消息"},
+	}
+
+	vars := func() map[string]any { return map[string]any{"lang": "zh", "name": "小明", "n": int64(3)} }
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			if got, err := engine.Execute(vars()); err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %q, got %q (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+
+		for _, in := range []string{`t("missing")`, `t()`, `t(1)`} {
+			engine, err := newEngine(in)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, in)
+			}
+		}
+
+		// 目录可以在编译之后替换，t 的结果不会在编译期折叠
+		engine, _ := newEngine(`t("plain")`)
+		SetMessageCatalog(MapCatalog{"plain": "replaced"})
+		if got, err := engine.Execute(vars()); err != nil || got != "replaced" {
+			t.Errorf("%s: expected replaced catalog, got %v (%v)", name, got, err)
+		}
+		SetMessageCatalog(nil)
+		if _, err := engine.Execute(vars()); err == nil || !strings.Contains(err.Error(), "no message catalog") {
+			t.Errorf("%s: expected missing catalog error, got %v", name, err)
+		}
+		SetMessageCatalog(catalog)
+	}
+}
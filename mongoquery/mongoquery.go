@@ -0,0 +1,370 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package mongoquery 把 MongoDB 风格的 JSON 查询条件编译为 uwasa 规则，已经以这种格式保存过滤条件的服务
+// 可以直接在 uwasa 中执行它们：
+//
+//	engine, err := mongoquery.Compile([]byte(`{"age": {"$gte": 18}, "country": {"$in": ["DE", "FR"]}}`))
+//	matched, err := engine.Execute(doc)
+//
+// 支持的运算：
+//
+//	{"f": v}、$eq、$ne       相等与不等；v 为标量时，数组字段包含 v 也算相等，与 MongoDB 相同
+//	$gt、$gte、$lt、$lte     大小比较，只有字段与 v 同为数字或同为字符串时才可能成立，不会因类型不同而出错
+//	$in、$nin                等于（不等于）列表中的任一值
+//	$exists                  字段值是否不为 null，缺失与值为 null 的字段都视为不存在
+//	$size                    数组字段的长度
+//	$not                     对同一字段的运算取反，如 {"age": {"$not": {"$gt": 60}}}
+//	$and、$or、$nor          以条件文档的数组组合
+//
+// 同一文档中的多个字段、同一字段的多个运算都须成立。字段名 "a.b" 读取嵌套文档的成员，路径中途缺失时为 null；
+// 各段须是合法的 uwasa 标识符，不支持数组下标段与 $regex、$elemMatch 等其余运算，遇到时编译报错。
+// 超出 float64 范围的数字（如 1e400）与 NaN 没有对应的 uwasa 字面量，同样报错。
+// 条件被翻译为 uwasa 源码（见 Translate），再由 NeoVM 编译为字节码。
+package mongoquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// Compile 使用默认选项编译 JSON 查询条件
+func Compile(data []byte) (*uwasa.Engine, error) {
+	return CompileWithOptions(data, uwasa.EngineOptions{})
+}
+
+// CompileWithOptions 解码 JSON 查询条件并按 opts 编译，见 CompileFilter
+func CompileWithOptions(data []byte, opts uwasa.EngineOptions) (*uwasa.Engine, error) {
+	filter, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return CompileFilter(filter, opts)
+}
+
+// CompileFilter 翻译已解码的查询条件并以 opts 交给 uwasa.NewEngineVMNeoWithOptions 编译
+func CompileFilter(filter map[string]any, opts uwasa.EngineOptions) (*uwasa.Engine, error) {
+	source, err := Translate(filter)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := uwasa.NewEngineVMNeoWithOptions(source, opts)
+	if err != nil {
+		return nil, fmt.Errorf("mongoquery: %w", err)
+	}
+	return engine, nil
+}
+
+// Decode 解码 JSON 查询条件。整数解码为 int64，其余数字为 float64，与 uwasa 的数值类型一致
+func Decode(data []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("mongoquery: %w", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("mongoquery: unexpected data after the query document")
+	}
+	filter, ok := normalize(v).(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("mongoquery: query must be a JSON object, got %T", v)
+	}
+	return filter, nil
+}
+
+// normalize 把解码得到的 json.Number 转换为 int64 或 float64
+func normalize(v any) any {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case []any:
+		for i := range x {
+			x[i] = normalize(x[i])
+		}
+	case map[string]any:
+		for k := range x {
+			x[k] = normalize(x[k])
+		}
+	}
+	return v
+}
+
+// Translate 把查询条件翻译为等价的 uwasa 源码，如 {"age": {"$gte": 18}} 翻译为
+// `(is_number(age) && age >= 18)`。键按字典序翻译，结果是确定的
+func Translate(filter map[string]any) (string, error) {
+	return translateDoc(filter)
+}
+
+// translateDoc 翻译一个条件文档，各项须同时成立
+func translateDoc(doc map[string]any) (string, error) {
+	var terms []string
+	for _, key := range slices.Sorted(maps.Keys(doc)) {
+		var term string
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			term, err = translateLogical(key, doc[key])
+		default:
+			if strings.HasPrefix(key, "$") {
+				return "", fmt.Errorf("mongoquery: unsupported top-level operator %s", key)
+			}
+			term, err = translateField(key, doc[key])
+		}
+		if err != nil {
+			return "", err
+		}
+		terms = append(terms, term)
+	}
+	return join(terms, " && ", "true"), nil
+}
+
+// join 以 sep 连接 terms 并加括号，terms 为空时返回 empty
+func join(terms []string, sep, empty string) string {
+	switch len(terms) {
+	case 0:
+		return empty
+	case 1:
+		return terms[0]
+	}
+	return "(" + strings.Join(terms, sep) + ")"
+}
+
+func translateLogical(op string, v any) (string, error) {
+	docs, ok := v.([]any)
+	if !ok || len(docs) == 0 {
+		return "", fmt.Errorf("mongoquery: %s expects a non-empty array of documents", op)
+	}
+	terms := make([]string, len(docs))
+	for i, d := range docs {
+		doc, ok := d.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("mongoquery: %s expects documents, got %T", op, d)
+		}
+		var err error
+		if terms[i], err = translateDoc(doc); err != nil {
+			return "", err
+		}
+	}
+	switch op {
+	case "$and":
+		return join(terms, " && ", "true"), nil
+	case "$or":
+		return join(terms, " || ", "false"), nil
+	}
+	return "!" + join(terms, " || ", "false"), nil
+}
+
+// translateField 翻译对字段 path 的条件：以 $ 开头的键组成的文档为运算，其余值为相等比较
+func translateField(path string, cond any) (string, error) {
+	field, err := fieldExpr(path)
+	if err != nil {
+		return "", err
+	}
+	if err := checkFinite(path, cond); err != nil {
+		return "", err
+	}
+	ops, ok := cond.(map[string]any)
+	if !ok || len(ops) == 0 || !isOperatorDoc(ops) {
+		return equal(field, cond)
+	}
+	return translateOps(path, field, ops)
+}
+
+// checkFinite 拒绝条件中的 NaN 与 ±Inf：uwasa 没有对应的字面量，超出 float64 范围的 JSON 数字（如 1e400）解码后即为 ±Inf
+func checkFinite(path string, v any) error {
+	switch x := v.(type) {
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return fmt.Errorf("mongoquery: %s: number %v is not finite", path, x)
+		}
+	case []any:
+		for _, el := range x {
+			if err := checkFinite(path, el); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, el := range x {
+			if err := checkFinite(path, el); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isOperatorDoc 判断文档是否含以 $ 开头的键；混用运算与普通键时按运算处理，由 translateOps 报告未知运算
+func isOperatorDoc(doc map[string]any) bool {
+	for k := range doc {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+func translateOps(path, field string, ops map[string]any) (string, error) {
+	var terms []string
+	for _, op := range slices.Sorted(maps.Keys(ops)) {
+		v := ops[op]
+		var term string
+		var err error
+		switch op {
+		case "$eq":
+			term, err = equal(field, v)
+		case "$ne":
+			term, err = equal(field, v)
+			term = "!" + term
+		case "$gt", "$gte", "$lt", "$lte":
+			term, err = compare(field, op, v)
+		case "$in", "$nin":
+			term, err = in(field, op, v)
+		case "$exists":
+			b, ok := v.(bool)
+			if !ok {
+				return "", fmt.Errorf("mongoquery: %s: $exists expects a boolean, got %T", path, v)
+			}
+			term = "(" + field + " != nil)"
+			if !b {
+				term = "(" + field + " == nil)"
+			}
+		case "$size":
+			n, ok := v.(int64)
+			if !ok {
+				return "", fmt.Errorf("mongoquery: %s: $size expects an integer, got %T", path, v)
+			}
+			term = "(is_array(" + field + ") && len(" + field + ") == " + strconv.FormatInt(n, 10) + ")"
+		case "$not":
+			inner, ok := v.(map[string]any)
+			if !ok || len(inner) == 0 || !isOperatorDoc(inner) {
+				return "", fmt.Errorf("mongoquery: %s: $not expects an operator document", path)
+			}
+			term, err = translateOps(path, field, inner)
+			term = "!" + term
+		default:
+			return "", fmt.Errorf("mongoquery: %s: unsupported operator %s", path, op)
+		}
+		if err != nil {
+			return "", err
+		}
+		terms = append(terms, term)
+	}
+	return join(terms, " && ", "true"), nil
+}
+
+// fieldExpr 把以 . 分隔的字段路径翻译为可选链，如 a.b.c 为 a?.b?.c
+func fieldExpr(path string) (string, error) {
+	segments := strings.Split(path, ".")
+	for _, s := range segments {
+		if !uwasa.IsIdentifier(s) {
+			return "", fmt.Errorf("mongoquery: field %q: segment %q is not a valid uwasa identifier", path, s)
+		}
+	}
+	return strings.Join(segments, "?."), nil
+}
+
+// equal 翻译相等比较。标量值与数组字段比较时，数组包含该值也算相等
+func equal(field string, v any) (string, error) {
+	lit, err := literal(v)
+	if err != nil {
+		return "", err
+	}
+	switch v.(type) {
+	case []any, map[string]any, nil:
+		return "(" + field + " == " + lit + ")", nil
+	}
+	return "(" + field + " == " + lit + " || is_array(" + field + ") && " + lit + " in " + field + ")", nil
+}
+
+var comparisons = map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}
+
+// compare 翻译大小比较，先确认字段与 v 属于同一类型，与 MongoDB 一样类型不同时不成立
+func compare(field, op string, v any) (string, error) {
+	lit, err := literal(v)
+	if err != nil {
+		return "", err
+	}
+	var guard string
+	switch v.(type) {
+	case int64, float64:
+		guard = "is_number"
+	case string:
+		guard = "is_string"
+	default:
+		return "", fmt.Errorf("mongoquery: %s expects a number or string, got %T", op, v)
+	}
+	return "(" + guard + "(" + field + ") && " + field + " " + comparisons[op] + " " + lit + ")", nil
+}
+
+func in(field, op string, v any) (string, error) {
+	values, ok := v.([]any)
+	if !ok {
+		return "", fmt.Errorf("mongoquery: %s expects an array, got %T", op, v)
+	}
+	terms := make([]string, len(values))
+	for i, value := range values {
+		var err error
+		if terms[i], err = equal(field, value); err != nil {
+			return "", err
+		}
+	}
+	if op == "$nin" {
+		return "!" + join(terms, " || ", "false"), nil
+	}
+	return join(terms, " || ", "false"), nil
+}
+
+// literal 把查询中的值写为 uwasa 字面量
+func literal(v any) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "nil", nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case int:
+		return strconv.Itoa(x), nil
+	case float64:
+		s := strconv.FormatFloat(x, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s, nil
+	case string:
+		return uwasa.Quote(x), nil
+	case []any:
+		items := make([]string, len(x))
+		for i, el := range x {
+			var err error
+			if items[i], err = literal(el); err != nil {
+				return "", err
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]any:
+		var pairs []string
+		for _, k := range slices.Sorted(maps.Keys(x)) {
+			val, err := literal(x[k])
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, uwasa.Quote(k)+": "+val)
+		}
+		return "{" + strings.Join(pairs, ", ") + "}", nil
+	}
+	return "", fmt.Errorf("mongoquery: unsupported value of type %T", v)
+}
@@ -0,0 +1,119 @@
+package mongoquery
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+	"github.com/kamihama-railway/uwasa/internal/enginetest"
+)
+
+func doc() map[string]any {
+	return map[string]any{
+		"age":     int64(30),
+		"score":   7.5,
+		"country": "DE",
+		"tags":    []any{"vip", "beta"},
+		"note":    nil,
+		"profile": map[string]any{"city": "Berlin", "zip": int64(10115)},
+		"active":  true,
+	}
+}
+
+// translateJSON 解码 JSON 查询条件并翻译为 uwasa 源码
+func translateJSON(query string) (string, error) {
+	filter, err := Decode([]byte(query))
+	if err != nil {
+		return "", err
+	}
+	return Translate(filter)
+}
+
+func TestQueries(t *testing.T) {
+	enginetest.Run(t, enginetest.Table{
+		{`{"age": {"$gte": 18}, "country": {"$in": ["DE", "FR"]}}`, true},
+		{`{"age": {"$gte": 18}, "country": {"$in": ["US"]}}`, false},
+		{`{}`, true},
+		{`{"country": "DE", "active": true}`, true},
+		{`{"age": {"$gt": 18, "$lt": 30}}`, false},
+		{`{"age": {"$lte": 30.0}, "score": {"$gt": 7}}`, true},
+		{`{"age": {"$gt": "18"}}`, false},
+		{`{"country": {"$gte": "A", "$lt": "E"}}`, true},
+		{`{"missing": {"$lt": 10}}`, false},
+		{`{"tags": "vip"}`, true},
+		{`{"tags": ["vip", "beta"]}`, true},
+		{`{"tags": {"$in": ["alpha", "beta"]}}`, true},
+		{`{"tags": {"$nin": ["vip"]}}`, false},
+		{`{"tags": {"$size": 2}}`, true},
+		{`{"country": {"$ne": "FR"}, "tags": {"$ne": "vip"}}`, false},
+		{`{"profile.city": "Berlin", "profile.zip": {"$gte": 10000}}`, true},
+		{`{"profile": {"city": "Berlin", "zip": 10115}}`, true},
+		{`{"profile.street.no": {"$exists": false}}`, true},
+		{`{"note": null, "missing": null}`, true},
+		{`{"note": {"$exists": true}}`, false},
+		{`{"age": {"$exists": true}}`, true},
+		{`{"age": {"$not": {"$gt": 60}}}`, true},
+		{`{"$or": [{"age": {"$lt": 18}}, {"country": "DE"}]}`, true},
+		{`{"$and": [{"age": 30}, {"score": 7.5}]}`, true},
+		{`{"$nor": [{"age": 30}, {"active": false}]}`, false},
+		{`{"score": {"$in": [7.5, 8]}, "age": {"$in": []}}`, false},
+	}, translateJSON, func(engine *uwasa.Engine) (any, error) {
+		return engine.Execute(doc())
+	})
+}
+
+func TestTranslateAndErrors(t *testing.T) {
+	got, err := Translate(map[string]any{"age": map[string]any{"$gte": int64(18)}, "name": "x"})
+	if want := `((is_number(age) && age >= 18) && (name == "x" || is_array(name) && "x" in name))`; err != nil || got != want {
+		t.Errorf("expected %s, got %s (%v)", want, got, err)
+	}
+	engine, err := Compile([]byte(`{"age": {"$gte": 18}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := engine.Execute(map[string]any{"age": int64(12)}); err != nil || got != false {
+		t.Errorf("expected false, got %v (%v)", got, err)
+	}
+
+	// NUL 被转义，不会在生成的源码中截断字符串
+	engine, err = Compile([]byte(`{"a": "x\u0000y"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := engine.Execute(map[string]any{"a": "x\x00y"}); err != nil || got != true {
+		t.Errorf("expected true, got %v (%v)", got, err)
+	}
+
+	// 超出 float64 范围的数字解码为 ±Inf，报告所在字段而不是生成无法解析的源码
+	for _, bad := range []string{`{"a": {"$gte": 1e400}}`, `{"a": {"$in": [1, -1e400]}}`, `{"a": 1e999}`} {
+		if _, err := Compile([]byte(bad)); err == nil || !strings.Contains(err.Error(), "mongoquery: a: number") {
+			t.Errorf("%s: expected a non-finite number error naming the field, got %v", bad, err)
+		}
+	}
+	if _, err := Translate(map[string]any{"a": map[string]any{"$lt": math.NaN()}}); err == nil || !strings.Contains(err.Error(), "a: number NaN") {
+		t.Errorf("expected a NaN error naming the field, got %v", err)
+	}
+
+	for _, bad := range []string{
+		`[]`,
+		`{"age": 1`,
+		`{"age": 1} {}`,
+		`{"$where": "1"}`,
+		`{"name": {"$regex": "^A"}}`,
+		`{"age": {"$gt": true}}`,
+		`{"age": {"$in": 1}}`,
+		`{"age": {"$exists": 1}}`,
+		`{"tags": {"$size": 1.5}}`,
+		`{"age": {"$not": 5}}`,
+		`{"$or": []}`,
+		`{"$and": [1]}`,
+		`{"tags.0": "vip"}`,
+		`{"if": 1}`,
+		`{"a..b": 1}`,
+	} {
+		if _, err := Compile([]byte(bad)); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
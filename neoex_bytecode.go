@@ -68,6 +68,49 @@ const (
 	NeoOpMulC
 	NeoOpDivC
 	NeoOpReturn // New for NeoEx to signal end of execution if needed
+	NeoOpToBool
+	NeoOpMakeArray
+	NeoOpIndex
+	NeoOpSetIndex
+	NeoOpMakeMap
+	NeoOpCopyConst
+	NeoOpCallMethod
+	NeoOpMapGet
+	NeoOpMapSet
+	NeoOpMapHas
+	NeoOpMapDel
+	NeoOpIn
+	NeoOpNotEqual
+	NeoOpNotEqualC
+	NeoOpNotEqualGlobalConst
+	NeoOpBitAnd // 位运算指令的顺序与 TokenBitAnd 至 TokenShr 一致
+	NeoOpBitOr
+	NeoOpBitXor
+	NeoOpShl
+	NeoOpShr
+	NeoOpGetLocal // 读取 let 绑定所在的栈底槽位
+	NeoOpSetLocal // 弹出栈顶写入 let 绑定的栈底槽位
+	NeoOpCallLocal // 调用 Functions[Arg]，栈顶的实参成为被调函数栈帧的最低槽位
+	NeoOpReturnLocal // 函数块结束：弹出返回值、丢弃栈帧并回到调用处
+	NeoOpMakeClosure // 以 Functions[Arg&0xFFFF] 构造闭包，捕获当前栈帧最低的 Arg>>16 个槽位
+	NeoOpJumpIfNotMap // 栈顶不是映射时将其替换为 nil 并跳转到 Arg，用于可选链 `a?.b`
+	NeoOpMapGetConst // 以常量 Arg 为键读取栈顶映射的成员，缺失时为 nil
+	NeoOpMakeRange // 以栈顶两个整数为两端构造区间 `a..b`
+	NeoOpInRange // 栈顶是否落在常量区间 Arg 内，用于 `x in 1..10`
+	NeoOpSpread // 弹出数组并把其元素追加到下方 MKARR 新建的数组，用于 `[...a]`
+	NeoOpCast // 把栈顶转换为 castKind(Arg) 类型，用于单参数的 int、float、str、bool
+	NeoOpScore // 弹出条件，为真时把常量 Arg 加到下方的累加值上，用于 `score { ... }`
+	NeoOpJumpIfFalseOrPop // 栈顶为假时保留并跳转到 Arg，否则弹出，用于返回操作数的 `&&`
+	NeoOpJumpIfTrueOrPop // 栈顶为真时保留并跳转到 Arg，否则弹出，用于返回操作数的 `||`
+	NeoOpDefined // 压入上下文中是否存在名为常量 Arg 的变量（值为 nil 也算存在），用于 `defined(x)`
+	NeoOpTry // 登记错误处理现场：此后出错时恢复栈顶并跳转到 Arg 处求备用值，用于 `try(expr, fallback)`
+	NeoOpEndTry // 撤销最近一次 TRY 的登记并跳转到 Arg，跳过备用值
+	NeoOpSlice // 按打包在 Arg 中的常量边界截取栈顶的数组或字符串，用于 `slice(x, start, end)`
+	NeoOpIter // 检查栈顶为数组并在其上压入下标 0，开始内联的 map、filter、reduce 循环，Arg 为 iterKind
+	NeoOpIterNext // 栈顶依次为累加值、下标与数组：下标未越界时压入当前元素并把下标加一，否则跳转到 Arg
+	NeoOpAppend // 弹出栈顶并追加到其下循环新建的数组
+	NeoOpIterEnd // 丢弃累加值之下的下标与数组，结束循环
+	NeoOpEqualFold // 弹出两个操作数，字符串之间不区分大小写比较，其余同 EQ，用于 `a ==* b`
 )
 
 func (o NeoOpCode) String() string {
@@ -132,6 +175,49 @@ func (o NeoOpCode) String() string {
 	case NeoOpMulC: return "MULC"
 	case NeoOpDivC: return "DIVC"
 	case NeoOpReturn: return "RET"
+	case NeoOpToBool: return "TOBOOL"
+	case NeoOpMakeArray: return "MKARR"
+	case NeoOpIndex: return "INDEX"
+	case NeoOpSetIndex: return "SETIDX"
+	case NeoOpMakeMap: return "MKMAP"
+	case NeoOpCopyConst: return "COPYC"
+	case NeoOpCallMethod: return "CALLM"
+	case NeoOpMapGet: return "MGET"
+	case NeoOpMapSet: return "MSET"
+	case NeoOpMapHas: return "MHAS"
+	case NeoOpMapDel: return "MDEL"
+	case NeoOpIn: return "IN"
+	case NeoOpNotEqual: return "NEQ"
+	case NeoOpNotEqualC: return "NEQC"
+	case NeoOpNotEqualGlobalConst: return "NEQGC"
+	case NeoOpBitAnd: return "BAND"
+	case NeoOpBitOr: return "BOR"
+	case NeoOpBitXor: return "BXOR"
+	case NeoOpShl: return "SHL"
+	case NeoOpShr: return "SHR"
+	case NeoOpGetLocal: return "GETL"
+	case NeoOpSetLocal: return "SETL"
+	case NeoOpCallLocal: return "CALLL"
+	case NeoOpReturnLocal: return "RETL"
+	case NeoOpMakeClosure: return "MKCLOS"
+	case NeoOpJumpIfNotMap: return "JNMAP"
+	case NeoOpMapGetConst: return "MGETC"
+	case NeoOpMakeRange: return "MKRANGE"
+	case NeoOpInRange: return "INRANGE"
+	case NeoOpSpread: return "SPREAD"
+	case NeoOpCast: return "CAST"
+	case NeoOpScore: return "SCORE"
+	case NeoOpJumpIfFalseOrPop: return "JIFOP"
+	case NeoOpJumpIfTrueOrPop: return "JITOP"
+	case NeoOpDefined: return "DEFINED"
+	case NeoOpTry: return "TRY"
+	case NeoOpEndTry: return "ENDTRY"
+	case NeoOpSlice: return "SLICE"
+	case NeoOpIter: return "ITER"
+	case NeoOpIterNext: return "ITERNEXT"
+	case NeoOpAppend: return "APPEND"
+	case NeoOpIterEnd: return "ITEREND"
+	case NeoOpEqualFold: return "EQFOLD"
 	default: return fmt.Sprintf("NEO_UNKNOWN(%d)", o)
 	}
 }
@@ -142,6 +228,16 @@ type neoInstruction struct {
 }
 
 type NeoBytecode struct {
-	Instructions []neoInstruction
-	Constants    []Value
+	Instructions   []neoInstruction
+	Constants      []Value
+	ResultCount    int // >1 表示程序为多值元组
+	MaxConcatBytes int // 单次执行拼接输出的累计字节上限，0 表示不限制
+	Locals         int // let 绑定占用的栈底槽位数，操作数栈从其上方开始
+	// Functions 为规则内 fn 定义编译出的独立指令块，与主程序共用常量池；下标即 CALLL 的操作数。
+	// 函数块的 Name 为函数名，Params 个实参占据其栈帧最低的槽位
+	Functions []*NeoBytecode
+	Name      string
+	Params    int
+	// concat 仅由闭包入口块设置，使 lambda 调用沿用创建它的那次执行的拼接计数
+	concat *concatBudget
 }
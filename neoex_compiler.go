@@ -6,7 +6,7 @@ package uwasa
 import (
 	"fmt"
 	"math"
-	"strconv"
+	"slices"
 	"sync"
 )
 
@@ -14,12 +14,38 @@ type compilationValue struct {
 	isConst  bool
 	val      Value
 	isString bool
+	lvalue   lvalueKind
+	array    bool // 值由数组字面量构造，最后一条指令是它的 MKARR
+}
+
+// lvalueKind 标记表达式能否作为赋值目标。单趟编译没有 AST，
+// 只能随值向上传递；任何运算（包括被代数化简掉的运算）都会清除该标记。
+type lvalueKind byte
+
+const (
+	lvalueNone lvalueKind = iota
+	lvalueIdent
+	lvalueIndex
+	lvalueLocal // let 绑定，不可赋值
+)
+
+// neoLocal 是一个可见的 let 绑定。常量绑定直接内联为常量，不占用栈槽
+type neoLocal struct {
+	name  string
+	slot  int32
+	value compilationValue
 }
 
 type NeoCompiler struct {
-	lexer     *Lexer
-	curToken  Token
-	peekToken Token
+	lexer        *Lexer
+	curToken     Token
+	peekToken    Token
+	tokens       int    // 已读取的记号数，用于判断链式比较的中间操作数是否只有一个记号
+	hashSeed     string // EngineOptions.HashSeed，见 seedArg
+	operandLogic bool   // EngineOptions.OperandLogic：`&&` 与 `||` 返回操作数
+	inputDocument bool  // EngineOptions.InputDocument：input.name 即变量 name，.name 为成员访问
+	decimal      bool   // EngineOptions.Decimal：接受 1.23d 十进制数字面量
+	noConcatFuse bool   // 设置了 EngineOptions.MaxConcatBytes：每个字符串 + 单独计数，不融合为多参数 CONCAT
 	
 	instructions []neoInstruction
 	constants    []Value
@@ -31,7 +57,24 @@ type NeoCompiler struct {
 	constMapOther  map[any]int32
 	
 	discard bool // New: discard emitted instructions
-	errors  []string
+	// fuseFloor 之前的指令不参与向后查看的融合：它们属于已完成的元组元素，
+	// 或位于某个跳转目标之前（跨越跳转目标融合会让跳转落到错误的位置）
+	fuseFloor   int
+	resultCount int
+	errors      []string
+	// locals 为当前可见的 let 绑定；slots 为其中占用栈槽的数量，maxSlots 为其峰值
+	locals    []neoLocal
+	slots     int
+	maxSlots  int
+	lastLocal string // 最近一次解析到的 let 绑定名，用于赋值报错
+	// letStatement 表示当前的 let 位于语句开头；openLet 表示刚编译完一条 let 语句，其绑定对其后的全部语句可见
+	letStatement, openLet bool
+	// fns 为已编译的规则内函数与 lambda 的指令块，lambdas 为其中 lambda 的数量；defining 为正在编译函数体的函数名
+	fns      chunkTable[*NeoBytecode]
+	lambdas  int
+	defining string
+	// dead 为常量条件移除的 if 分支在源码中的位置，见 Engine.DeadBranches
+	dead []Span
 }
 
 var neoCompilerPool = sync.Pool{
@@ -47,8 +90,14 @@ var neoCompilerPool = sync.Pool{
 }
 
 func NewNeoCompiler(input string) *NeoCompiler {
+	return newNeoCompiler(input, NumberDefault)
+}
+
+// newNeoCompiler 与 NewNeoCompiler 相同，数字字面量按 numbers 的格式读取
+func newNeoCompiler(input string, numbers NumberFormat) *NeoCompiler {
 	l := lexerPool.Get().(*Lexer)
 	l.Reset(input)
+	l.numbers = numbers
 	c := neoCompilerPool.Get().(*NeoCompiler)
 	c.lexer = l
 	c.Reset()
@@ -64,7 +113,15 @@ func (c *NeoCompiler) Reset() {
 	for k := range c.constMapString { delete(c.constMapString, k) }
 	for k := range c.constMapOther { delete(c.constMapOther, k) }
 	c.errors = c.errors[:0]
-	c.discard = false
+	c.discard, c.noConcatFuse = false, false
+	c.fuseFloor = 0
+	c.resultCount = 0
+	c.tokens = 0
+	c.locals = c.locals[:0]
+	c.slots, c.maxSlots = 0, 0
+	c.letStatement, c.openLet = false, false
+	c.fns, c.lambdas, c.defining = chunkTable[*NeoBytecode]{}, 0, ""
+	c.dead = nil
 	c.nextToken()
 	c.nextToken()
 }
@@ -76,21 +133,23 @@ func (c *NeoCompiler) Close() {
 }
 
 func (c *NeoCompiler) nextToken() {
+	c.tokens++
 	c.curToken = c.peekToken
 	c.peekToken = c.lexer.NextToken()
 }
 
 func (c *NeoCompiler) Compile() (*NeoBytecode, error) {
 	defer c.Close()
-	val, err := c.parseExpression(LOWEST)
-	if err != nil {
-		return nil, err
-	}
-	
-	if val.isConst {
-		c.emitPush(val.val)
+	return c.compile()
+}
+
+// compile 为 Compile 的主体但不归还编译器，newEngineNeo 在归还之前取出被移除的分支
+func (c *NeoCompiler) compile() (*NeoBytecode, error) {
+	for c.curToken.Type == TokenFn {
+		if err := c.compileFunction(); err != nil { return nil, err }
 	}
-	
+	if err := c.compileStatements(); err != nil { return nil, err }
+
 	if len(c.errors) > 0 {
 		return nil, fmt.Errorf("compile errors: %v", c.errors)
 	}
@@ -98,15 +157,68 @@ func (c *NeoCompiler) Compile() (*NeoBytecode, error) {
 	c.peephole()
 	c.emit(NeoOpReturn, 0)
 	
-	return &NeoBytecode{
+	bc := &NeoBytecode{
 		Instructions: c.instructions,
 		Constants:    c.constants,
-	}, nil
+		ResultCount:  c.resultCount,
+		Locals:       c.maxSlots,
+		Functions:    c.fns.chunks,
+	}
+	// 函数块与主程序共用最终的常量池
+	for _, chunk := range c.fns.chunks { chunk.Constants = c.constants }
+	// 字节码接管指令与常量切片；编译器回池后若继续复用，下一次编译会覆盖已交出的字节码
+	c.instructions, c.constants = nil, nil
+	return bc, nil
 }
 
+// compileStatements 编译以分号或换行分隔的语句，规则的值为最后一条语句的值：其余语句的值被 POP 丢弃，
+// 常量语句不产生指令。let 语句的绑定一直可见到编译结束；元组只能是最后一条语句，且不能位于 let 语句之后
+func (c *NeoCompiler) compileStatements() error {
+	lets := 0
+	for {
+		c.letStatement = c.curToken.Type == TokenLet
+		val, err := c.parseExpression(LOWEST)
+		if err != nil { return err }
+		if c.openLet {
+			c.openLet = false
+			lets++
+			more, err := nextStatement(c, TokenEOF)
+			if err != nil { return err }
+			if !more { return fmt.Errorf("let %s must be followed by a statement", c.lastLocal) }
+			c.fuseFloor = len(c.instructions)
+			continue
+		}
+		if c.peekToken.Type == TokenComma {
+			if lets > 0 { return fmt.Errorf("tuple cannot follow let %s statement", c.locals[len(c.locals)-1].name) }
+			if val.isConst { c.emitPush(val.val) }
+			c.resultCount = 1
+			for c.peekToken.Type == TokenComma {
+				c.nextToken(); c.nextToken()
+				c.fuseFloor = len(c.instructions)
+				val, err = c.parseExpression(LOWEST)
+				if err != nil { return err }
+				if val.isConst { c.emitPush(val.val) }
+				c.resultCount++
+			}
+		}
+		more, err := nextStatement(c, TokenEOF)
+		if err != nil { return err }
+		if !more {
+			if val.isConst && c.resultCount == 0 { c.emitPush(val.val) }
+			return nil
+		}
+		if c.resultCount > 0 { return fmt.Errorf("tuple must be the last statement") }
+		if !val.isConst { c.emit(NeoOpPop, 0) }
+		c.fuseFloor = len(c.instructions)
+	}
+}
+
+func (c *NeoCompiler) peek() Token { return c.peekToken }
+
 func (c *NeoCompiler) parseExpression(precedence int) (compilationValue, error) {
 	prefix := c.getPrefixFn(c.curToken.Type)
 	if prefix == nil {
+		if c.curToken.Type == TokenIllegal { return compilationValue{}, fmt.Errorf("illegal token %q", c.curToken.Literal) }
 		return compilationValue{}, fmt.Errorf("no prefix parsing function for %s", c.curToken.Type)
 	}
 	
@@ -115,7 +227,7 @@ func (c *NeoCompiler) parseExpression(precedence int) (compilationValue, error)
 		return compilationValue{}, err
 	}
 	
-	for c.peekToken.Type != TokenEOF && precedence < c.peekPrecedence() {
+	for c.peekToken.Type != TokenEOF && precedence < c.peekPrecedence() && !newStatement(c.peekToken) {
 		infix := c.getInfixFn(c.peekToken.Type)
 		if infix == nil {
 			return left, nil
@@ -132,11 +244,11 @@ func (c *NeoCompiler) parseExpression(precedence int) (compilationValue, error)
 }
 
 func (c *NeoCompiler) curPrecedence() int {
-	return getPrecedence(c.curToken.Type)
+	return tokenPrecedence(c.curToken)
 }
 
 func (c *NeoCompiler) peekPrecedence() int {
-	return getPrecedence(c.peekToken.Type)
+	return tokenPrecedence(c.peekToken)
 }
 
 func (c *NeoCompiler) getPrefixFn(t TokenType) func() (compilationValue, error) {
@@ -144,10 +256,18 @@ func (c *NeoCompiler) getPrefixFn(t TokenType) func() (compilationValue, error)
 	case TokenIdent: return c.parseIdentifier
 	case TokenNumber: return c.parseNumberLiteral
 	case TokenString: return c.parseStringLiteral
+	case TokenTime: return c.parseTimeLiteral
+	case TokenDuration: return c.parseDurationLiteral
+	case TokenDecimal: return c.parseDecimalLiteral
 	case TokenTrue, TokenFalse: return c.parseBooleanLiteral
 	case TokenBang, TokenMinus: return c.parsePrefixExpression
 	case TokenLParen: return c.parseGroupedExpression
 	case TokenIf: return c.parseIfExpression
+	case TokenMatch: return c.parseMatchExpression
+	case TokenLet: return c.parseLetExpression
+	case TokenLBracket: return c.parseArrayLiteral
+	case TokenLBrace: return c.parseMapLiteral
+	case TokenOperator: return c.parseOperatorPrefix
 	default: return nil
 	}
 }
@@ -155,45 +275,103 @@ func (c *NeoCompiler) getPrefixFn(t TokenType) func() (compilationValue, error)
 func (c *NeoCompiler) getInfixFn(t TokenType) func(compilationValue) (compilationValue, error) {
 	switch t {
 	case TokenPlus, TokenMinus, TokenAsterisk, TokenSlash, TokenPercent,
-		TokenEq, TokenGt, TokenLt, TokenGe, TokenLe, TokenAnd, TokenOr:
+		TokenEq, TokenNotEq, TokenEqFold, TokenGt, TokenLt, TokenGe, TokenLe, TokenIn, TokenAnd, TokenOr,
+		TokenBitAnd, TokenBitOr, TokenBitXor, TokenShl, TokenShr:
 		return c.parseInfixExpression
 	case TokenAssign:
 		return c.parseAssignExpression
 	case TokenLParen:
 		return c.parseCallExpression
+	case TokenLBracket:
+		return c.parseIndexExpression
+	case TokenDot:
+		return c.parseMemberCallExpression
+	case TokenOptDot:
+		return c.parseOptionalMemberExpression
+	case TokenPipe:
+		return c.parsePipeExpression
+	case TokenRange:
+		return c.parseRangeExpression
+	case TokenOperator:
+		return c.parseOperatorInfix
+	case TokenIdent:
+		return c.parseLikeExpression
 	default:
 		return nil
 	}
 }
 
 func (c *NeoCompiler) parseIdentifier() (compilationValue, error) {
+	if c.curToken.Literal == "score" && c.peekToken.Type == TokenLBrace && !c.peekToken.Newline { return c.parseScore() }
+	if c.peekToken.Type == TokenLParen {
+		if c.curToken.Literal == c.defining { return compilationValue{}, errNotDefinedYet(c.defining) }
+		if i := c.fns.index(c.curToken.Literal); i >= 0 { return c.parseLocalCall(i) }
+		if _, ok := c.local(c.curToken.Literal); ok { return compilationValue{}, errLocalCall(c.curToken.Literal) }
+		if c.curToken.Literal == "defined" { return c.parseDefined() }
+		if c.curToken.Literal == "try" { return c.parseTry() }
+	}
+	if c.inputDocument && c.curToken.Literal == "input" {
+		if _, ok := c.local("input"); !ok { return c.parseInputPath() }
+	}
+	if c.peekToken.Type == TokenLambda {
+		name := c.curToken.Literal
+		c.nextToken()
+		return c.parseLambda([]string{name})
+	}
+	if l, ok := c.local(c.curToken.Literal); ok {
+		c.lastLocal = l.name
+		if l.value.isConst {
+			v := l.value
+			v.lvalue = lvalueLocal
+			return v, nil
+		}
+		c.emit(NeoOpGetLocal, l.slot)
+		return compilationValue{isConst: false, lvalue: lvalueLocal}, nil
+	}
 	c.emit(NeoOpGetGlobal, c.addConstant(Value{Type: ValString, Str: c.curToken.Literal}))
-	return compilationValue{isConst: false}, nil
+	return compilationValue{isConst: false, lvalue: lvalueIdent}, nil
 }
 
-func neoContainsDot(s string) bool {
-	for i := 0; i < len(s); i++ {
-		if s[i] == '.' {
-			return true
-		}
-	}
-	return false
+// parseInputPath 编译输入文档模式下的 `input.name`，即读取全局变量 name，不受同名局部变量与函数影响
+func (c *NeoCompiler) parseInputPath() (compilationValue, error) {
+	if c.peekToken.Type != TokenDot { return compilationValue{}, fmt.Errorf("input must be followed by a field, e.g. input.user") }
+	c.nextToken()
+	if c.peekToken.Type != TokenIdent { return compilationValue{}, fmt.Errorf("expected next token to be %s, got %s instead", TokenIdent, c.peekToken.Type) }
+	c.nextToken()
+	name := c.curToken.Literal
+	_, shadowed := c.local(name)
+	if err := inputPathError(name, shadowed, c.peekToken.Type == TokenLParen); err != nil { return compilationValue{}, err }
+	c.emit(NeoOpGetGlobal, c.addConstant(Value{Type: ValString, Str: name}))
+	return compilationValue{isConst: false, lvalue: lvalueIdent}, nil
 }
 
 func (c *NeoCompiler) parseNumberLiteral() (compilationValue, error) {
-	v, err := strconv.ParseFloat(c.curToken.Literal, 64)
+	n, err := parseNumber(c.curToken.Literal)
 	if err != nil {
 		return compilationValue{}, err
 	}
-	var val Value
-	if !neoContainsDot(c.curToken.Literal) {
-		val = Value{Type: ValInt, Num: uint64(int64(v))}
-	} else {
-		val = Value{Type: ValFloat, Num: math.Float64bits(v)}
-	}
+	val, _ := literalValue(n)
 	return compilationValue{isConst: true, val: val}, nil
 }
 
+func (c *NeoCompiler) parseTimeLiteral() (compilationValue, error) {
+	t, err := parseTime(c.curToken.Literal)
+	if err != nil { return compilationValue{}, err }
+	return compilationValue{isConst: true, val: Value{Type: ValTime, Obj: t.Value}}, nil
+}
+
+func (c *NeoCompiler) parseDurationLiteral() (compilationValue, error) {
+	d, err := parseDuration(c.curToken.Literal)
+	if err != nil { return compilationValue{}, err }
+	return compilationValue{isConst: true, val: Value{Type: ValDuration, Num: uint64(d.Value)}}, nil
+}
+
+func (c *NeoCompiler) parseDecimalLiteral() (compilationValue, error) {
+	d, err := parseDecimalLiteral(c.curToken.Literal, c.decimal)
+	if err != nil { return compilationValue{}, err }
+	return compilationValue{isConst: true, val: Value{Type: ValDecimal, Obj: d.Value}}, nil
+}
+
 func (c *NeoCompiler) parseStringLiteral() (compilationValue, error) {
 	return compilationValue{isConst: true, val: Value{Type: ValString, Str: c.curToken.Literal}, isString: true}, nil
 }
@@ -207,16 +385,27 @@ func (c *NeoCompiler) parseBooleanLiteral() (compilationValue, error) {
 func (c *NeoCompiler) parsePrefixExpression() (compilationValue, error) {
 	op := c.curToken.Literal
 	c.nextToken()
+	// -x 编译为 0 - x：被减数须先于操作数入栈，操作数折叠为常量时再撤回
+	mark := len(c.instructions)
+	if op == "-" { c.emit(NeoOpPush, c.addConstant(Value{Type: ValInt, Num: 0})) }
 	right, err := c.parseExpression(PREFIX)
 	if err != nil { return compilationValue{}, err }
 	
 	if right.isConst {
 		if op == "-" {
 			if right.val.Type == ValInt {
+				c.instructions = c.instructions[:mark]
 				return compilationValue{isConst: true, val: Value{Type: ValInt, Num: uint64(-int64(right.val.Num))}}, nil
 			} else if right.val.Type == ValFloat {
+				c.instructions = c.instructions[:mark]
 				fv := math.Float64frombits(right.val.Num)
 				return compilationValue{isConst: true, val: Value{Type: ValFloat, Num: math.Float64bits(-fv)}}, nil
+			} else if right.val.Type == ValDuration {
+				c.instructions = c.instructions[:mark]
+				return compilationValue{isConst: true, val: Value{Type: ValDuration, Num: -right.val.Num}}, nil
+			} else if right.val.Type == ValDecimal {
+				c.instructions = c.instructions[:mark]
+				return compilationValue{isConst: true, val: Value{Type: ValDecimal, Obj: right.val.Obj.(Decimal).Neg()}}, nil
 			}
 		} else if op == "!" {
 			return compilationValue{isConst: true, val: Value{Type: ValBool, Num: boolToUint64(!isValTruthy(right.val))}}, nil
@@ -228,7 +417,6 @@ func (c *NeoCompiler) parsePrefixExpression() (compilationValue, error) {
 	}
 	
 	if op == "-" {
-		c.emit(NeoOpPush, c.addConstant(Value{Type: ValInt, Num: 0}))
 		c.emit(NeoOpSub, 0)
 	} else if op == "!" {
 		c.emit(NeoOpNot, 0)
@@ -238,6 +426,10 @@ func (c *NeoCompiler) parsePrefixExpression() (compilationValue, error) {
 
 func (c *NeoCompiler) parseGroupedExpression() (compilationValue, error) {
 	c.nextToken()
+	// `()` 与 `(x, ...` 不是合法的分组表达式，只能是 lambda 的参数表
+	if c.curToken.Type == TokenRParen || (c.curToken.Type == TokenIdent && c.peekToken.Type == TokenComma) {
+		return c.parseLambdaParameters()
+	}
 	val, err := c.parseExpression(LOWEST)
 	if err != nil { return compilationValue{}, err }
 	if c.peekToken.Type != TokenRParen {
@@ -253,12 +445,81 @@ func (c *NeoCompiler) peekTokenIsLiteral() bool {
 }
 
 func (c *NeoCompiler) parseInfixExpression(left compilationValue) (compilationValue, error) {
+	if isOrderingToken(c.curToken.Type) {
+		return c.compileComparisonChain(left)
+	}
+	res, err := c.compileInfix(left)
+	res.lvalue = lvalueNone
+	return res, err
+}
+
+// compileComparisonChain 编译 `lo <= x < hi` 这类链式比较，语义与 `(lo <= x) && (x < hi)` 相同：
+// 每段比较为假时跳到末尾压入 false。中间操作数须为单个变量或字面量，重新求值时按其记号再编译一次。
+func (c *NeoCompiler) compileComparisonChain(left compilationValue) (compilationValue, error) {
+	middle, start := c.peekToken, c.tokens
+	res, err := c.compileInfix(left)
+	if err != nil { return compilationValue{}, err }
+	if !isOrderingToken(c.peekToken.Type) { return res, nil }
+	var jumps []int
+	for isOrderingToken(c.peekToken.Type) {
+		if c.tokens != start+1 || !isChainOperandToken(middle.Type) {
+			return compilationValue{}, fmt.Errorf("chained comparison operand must be a variable or literal")
+		}
+		if res.isConst { c.emitPush(res.val) }
+		jumps = append(jumps, c.emit(NeoOpJumpIfFalse, 0))
+		cur := c.curToken
+		c.curToken = middle
+		mid, err := c.getPrefixFn(middle.Type)()
+		if err != nil { return compilationValue{}, err }
+		c.curToken = cur
+		c.nextToken()
+		middle, start = c.peekToken, c.tokens
+		if res, err = c.compileInfix(mid); err != nil { return compilationValue{}, err }
+	}
+	if res.isConst { c.emitPush(res.val) }
+	jumpEnd := c.emit(NeoOpJump, 0)
+	for _, j := range jumps {
+		c.patch(j, int32(len(c.instructions)))
+	}
+	c.emit(NeoOpPush, c.addConstant(Value{Type: ValBool, Num: 0}))
+	c.patch(jumpEnd, int32(len(c.instructions)))
+	return compilationValue{isConst: false}, nil
+}
+
+// compileOperandLogic 编译返回操作数的 `&&` 或 `||` 的右侧：左操作数已在栈上，决定结果时由 jumpOp 保留并跳到末尾，
+// 否则弹出后求值右操作数
+func (c *NeoCompiler) compileOperandLogic(precedence int, jumpOp NeoOpCode) (compilationValue, error) {
+	jump := c.emit(jumpOp, 0)
+	c.nextToken()
+	right, err := c.parseExpression(precedence)
+	if err != nil { return compilationValue{}, err }
+	if right.isConst { c.emitPush(right.val) }
+	c.patch(jump, int32(len(c.instructions)))
+	return compilationValue{isConst: false}, nil
+}
+
+// compileSurvivingOperand 编译左操作数为常量且未决定结果时的右操作数；不返回操作数时结果转为布尔值，与其他后端一致
+func (c *NeoCompiler) compileSurvivingOperand(precedence int) (compilationValue, error) {
+	right, err := c.parseExpression(precedence)
+	if err != nil || c.operandLogic { return right, err }
+	if right.isConst { return c.logicResult(right), nil }
+	c.emit(NeoOpToBool, 0)
+	return compilationValue{isConst: false}, nil
+}
+
+// logicResult 返回由常量 v 决定的 `&&`/`||` 结果：返回操作数时为 v 本身，否则为其真值
+func (c *NeoCompiler) logicResult(v compilationValue) compilationValue {
+	if c.operandLogic { return v }
+	return compilationValue{isConst: true, val: Value{Type: ValBool, Num: boolToUint64(isValTruthy(v.val))}}
+}
+
+func (c *NeoCompiler) compileInfix(left compilationValue) (compilationValue, error) {
 	op := c.curToken.Literal
 	precedence := c.curPrecedence()
 
 	if op == "+" && left.isString {
 		lastIdx := len(c.instructions) - 1
-		canFuse := lastIdx >= 0 && c.instructions[lastIdx].Op == NeoOpConcat
+		canFuse := !c.noConcatFuse && lastIdx >= c.fuseFloor && c.instructions[lastIdx].Op == NeoOpConcat
 		var nArgs int32
 		if canFuse {
 			nArgs = c.instructions[lastIdx].Arg
@@ -285,23 +546,20 @@ func (c *NeoCompiler) parseInfixExpression(left compilationValue) (compilationVa
 		if left.isConst {
 			if isValTruthy(left.val) {
 				c.nextToken()
-				return c.parseExpression(precedence)
+				return c.compileSurvivingOperand(precedence)
 			} else {
-				oldDiscard := c.discard
-				c.discard = true
 				c.nextToken()
-				c.parseExpression(precedence)
-				c.discard = oldDiscard
-				return left, nil
+				if err := c.discardExpression(precedence); err != nil { return compilationValue{}, err }
+				return c.logicResult(left), nil
 			}
 		}
+		if c.operandLogic { return c.compileOperandLogic(precedence, NeoOpJumpIfFalseOrPop) }
 		jumpFalse := c.emit(NeoOpJumpIfFalse, 0)
 		c.nextToken()
 		right, err := c.parseExpression(precedence)
 		if err != nil { return compilationValue{}, err }
 		if right.isConst { c.emitPush(right.val) }
-		c.emit(NeoOpNot, 0)
-		c.emit(NeoOpNot, 0)
+		c.emit(NeoOpToBool, 0)
 		jumpEnd := c.emit(NeoOpJump, 0)
 		c.patch(jumpFalse, int32(len(c.instructions)))
 		c.emit(NeoOpPush, c.addConstant(Value{Type: ValBool, Num: 0}))
@@ -313,23 +571,20 @@ func (c *NeoCompiler) parseInfixExpression(left compilationValue) (compilationVa
 		if left.isConst {
 			if !isValTruthy(left.val) {
 				c.nextToken()
-				return c.parseExpression(precedence)
+				return c.compileSurvivingOperand(precedence)
 			} else {
-				oldDiscard := c.discard
-				c.discard = true
 				c.nextToken()
-				c.parseExpression(precedence)
-				c.discard = oldDiscard
-				return left, nil
+				if err := c.discardExpression(precedence); err != nil { return compilationValue{}, err }
+				return c.logicResult(left), nil
 			}
 		}
+		if c.operandLogic { return c.compileOperandLogic(precedence, NeoOpJumpIfTrueOrPop) }
 		jumpTrue := c.emit(NeoOpJumpIfTrue, 0)
 		c.nextToken()
 		right, err := c.parseExpression(precedence)
 		if err != nil { return compilationValue{}, err }
 		if right.isConst { c.emitPush(right.val) }
-		c.emit(NeoOpNot, 0)
-		c.emit(NeoOpNot, 0)
+		c.emit(NeoOpToBool, 0)
 		jumpEnd := c.emit(NeoOpJump, 0)
 		c.patch(jumpTrue, int32(len(c.instructions)))
 		c.emit(NeoOpPush, c.addConstant(Value{Type: ValBool, Num: 1}))
@@ -337,10 +592,15 @@ func (c *NeoCompiler) parseInfixExpression(left compilationValue) (compilationVa
 		return compilationValue{isConst: false}, nil
 	}
 
+	if op == "in" {
+		return c.compileIn(left, precedence)
+	}
+
 	if left.isConst && !c.peekTokenIsLiteral() {
 		c.emitPush(left.val)
 		left.isConst = false
 	}
+	mark := len(c.instructions)
 	c.nextToken()
 	right, err := c.parseExpression(precedence)
 	if err != nil { return compilationValue{}, err }
@@ -348,6 +608,15 @@ func (c *NeoCompiler) parseInfixExpression(left compilationValue) (compilationVa
 		res, ok := c.foldInfix(left.val, right.val, op)
 		if ok { return compilationValue{isConst: true, val: res}, nil }
 	}
+	if op == "+" && left.array && right.array && !c.discard && c.instructions[mark-1].Op == NeoOpMakeArray {
+		// 右侧数组的元素均为常量时，撤回左侧的 MKARR，两侧元素由右侧的 MKARR 一并收集
+		if items, ok := c.constOperand(mark); ok {
+			n := c.instructions[mark-1].Arg + int32(len(items.([]any)))
+			c.instructions = append(c.instructions[:mark-1], c.instructions[mark:]...)
+			c.instructions[len(c.instructions)-1].Arg = n
+			return compilationValue{isConst: false, array: true}, nil
+		}
+	}
 	if (left.isString || right.isString) && op == "+" {
 		if left.isConst { c.emitPush(left.val) }
 		if right.isConst { c.emitPush(right.val) }
@@ -381,7 +650,7 @@ func (c *NeoCompiler) parseInfixExpression(left compilationValue) (compilationVa
 	case "+":
 		if left.isString || right.isString {
 			lastIdx := len(c.instructions) - 1
-			if lastIdx >= 0 && c.instructions[lastIdx].Op == NeoOpConcat {
+			if !c.noConcatFuse && lastIdx >= c.fuseFloor && c.instructions[lastIdx].Op == NeoOpConcat {
 				nArgs := c.instructions[lastIdx].Arg
 				c.instructions = c.instructions[:lastIdx]
 				if right.isConst { c.emitPush(right.val) }
@@ -399,15 +668,144 @@ func (c *NeoCompiler) parseInfixExpression(left compilationValue) (compilationVa
 	case "/": c.emit(NeoOpDiv, 0)
 	case "%": c.emit(NeoOpMod, 0)
 	case "==": c.emit(NeoOpEqual, 0)
+	case "==*": c.emit(NeoOpEqualFold, 0)
+	case "!=": c.emit(NeoOpNotEqual, 0)
 	case ">": c.emit(NeoOpGreater, 0)
 	case "<": c.emit(NeoOpLess, 0)
 	case ">=": c.emit(NeoOpGreaterEqual, 0)
 	case "<=": c.emit(NeoOpLessEqual, 0)
+	case "&": c.emit(NeoOpBitAnd, 0)
+	case "|": c.emit(NeoOpBitOr, 0)
+	case "^": c.emit(NeoOpBitXor, 0)
+	case "<<": c.emit(NeoOpShl, 0)
+	case ">>": c.emit(NeoOpShr, 0)
 	}
 	return compilationValue{isConst: false}, nil
 }
 
+// compileIn 编译 `needle in haystack`。左侧为常量且右侧只生成了常量（字符串、全常量数组、
+// 折叠为 COPYC 的映射）时，撤回已生成的指令，在编译期求出结果；求值出错则留待运行期报错。
+func (c *NeoCompiler) compileIn(left compilationValue, precedence int) (compilationValue, error) {
+	if left.isConst { c.emitPush(left.val) }
+	mark := len(c.instructions)
+	c.fuseFloor = max(c.fuseFloor, mark)
+	c.nextToken()
+	right, err := c.parseExpression(precedence)
+	if err != nil { return compilationValue{}, err }
+	if left.isConst && !c.discard {
+		haystack, ok := right.val.ToInterface(), right.isConst
+		if !ok { haystack, ok = c.constOperand(mark) }
+		if ok {
+			if found, err := InAny(left.val.ToInterface(), haystack); err == nil {
+				c.instructions = c.instructions[:mark-1]
+				return compilationValue{isConst: true, val: Value{Type: ValBool, Num: boolToUint64(found)}}, nil
+			}
+		}
+	}
+	if rng, ok := right.val.Obj.(Range); ok && right.isConst {
+		// `x in 1..10` 只比较两端，不构造区间
+		c.emit(NeoOpInRange, c.addConstant(Value{Type: ValObject, Obj: rng}))
+		return compilationValue{isConst: false}, nil
+	}
+	if right.isConst { c.emitPush(right.val) }
+	c.emit(NeoOpIn, 0)
+	return compilationValue{isConst: false}, nil
+}
+
+// parseRangeExpression 编译 `start..end`。两端均为整数常量时区间本身是常量，不生成指令，
+// 作为 in 的右侧时编译为 INRANGE；否则两端依次入栈后由 MKRANGE 构造
+func (c *NeoCompiler) parseRangeExpression(left compilationValue) (compilationValue, error) {
+	if _, ok := left.val.Obj.(Range); ok && left.isConst { return compilationValue{}, fmt.Errorf("range bound cannot be a range") }
+	if left.isConst { c.emitPush(left.val) }
+	mark := len(c.instructions)
+	c.fuseFloor = max(c.fuseFloor, mark)
+	c.nextToken()
+	right, err := c.parseExpression(RANGE)
+	if err != nil { return compilationValue{}, err }
+	if c.peekToken.Type == TokenRange { return compilationValue{}, fmt.Errorf("range bound cannot be a range") }
+	if left.isConst && right.isConst && !c.discard {
+		if rng, err := makeRange(left.val.ToInterface(), right.val.ToInterface()); err == nil {
+			c.instructions = c.instructions[:mark-1]
+			return compilationValue{isConst: true, val: Value{Type: ValObject, Obj: rng}}, nil
+		}
+	}
+	if right.isConst { c.emitPush(right.val) }
+	c.emit(NeoOpMakeRange, 0)
+	return compilationValue{isConst: false}, nil
+}
+
+// constOperand 判断 mark 之后的指令是否只构造了一个常量值：由 PUSH、COPYC 以及收集它们的 MKARR 组成，
+// 如常量数组（含嵌套数组）与折叠为 COPYC 的映射
+func (c *NeoCompiler) constOperand(mark int) (any, bool) { return c.constOperandIn(mark, len(c.instructions)) }
+
+// constOperandIn 与 constOperand 相同，只检查 [from, to) 之间的指令
+func (c *NeoCompiler) constOperandIn(from, to int) (any, bool) {
+	var vals []any
+	for _, inst := range c.instructions[from:to] {
+		switch inst.Op {
+		case NeoOpPush: vals = append(vals, c.constants[inst.Arg].ToInterface())
+		case NeoOpCopyConst: vals = append(vals, c.constants[inst.Arg].Obj)
+		case NeoOpMakeArray:
+			n := int(inst.Arg)
+			if n > len(vals) { return nil, false }
+			arr := make([]any, n)
+			copy(arr, vals[len(vals)-n:])
+			vals = append(vals[:len(vals)-n], arr)
+		default: return nil, false
+		}
+	}
+	if len(vals) != 1 { return nil, false }
+	return vals[0], true
+}
+
+// prepareArg 在内置函数 name 的第 i 个实参只生成了常量时，撤回这些指令，改为压入预处理结果
+func (c *NeoCompiler) prepareArg(name string, i, mark int) {
+	if _, ok := argPreparers[name]; !ok || c.discard { return }
+	v, ok := c.constOperand(mark)
+	if !ok { return }
+	if p, ok := prepareArg(name, i, v); ok {
+		c.instructions = c.instructions[:mark]
+		c.emitPush(Value{Type: ValObject, Obj: p})
+	}
+}
+
 func (c *NeoCompiler) foldInfix(l, r Value, op string) (Value, bool) {
+	// 时间与时长只折叠加减与同类之间的比较，其他组合留待运行期
+	if isTemporal(l) || isTemporal(r) {
+		switch op {
+		case "+": return addTemporal(l, r)
+		case "-": return subTemporal(l, r)
+		case "==", "!=", ">", "<", ">=", "<=":
+			if l.Type != r.Type { return Value{}, false }
+		default: return Value{}, false
+		}
+	}
+	// 十进制数只折叠四则运算与比较，除数为 0 时留待运行期报错
+	if l.Type == ValDecimal || r.Type == ValDecimal {
+		if v, ok, err := arithDecimal(arithmeticOps[op], l, r); ok { return v, err == nil }
+		if c, ok := compareExtended(l, r); ok {
+			switch op {
+			case "==": return Value{Type: ValBool, Num: boolToUint64(c == 0)}, true
+			case "!=": return Value{Type: ValBool, Num: boolToUint64(c != 0)}, true
+			case ">": return Value{Type: ValBool, Num: boolToUint64(c > 0)}, true
+			case "<": return Value{Type: ValBool, Num: boolToUint64(c < 0)}, true
+			case ">=": return Value{Type: ValBool, Num: boolToUint64(c >= 0)}, true
+			case "<=": return Value{Type: ValBool, Num: boolToUint64(c <= 0)}, true
+			}
+		}
+		return Value{}, false
+	}
+	// 集合只折叠集合运算与相等比较
+	if l.Type == ValSet || r.Type == ValSet {
+		switch op {
+		case "==", "!=": return Value{Type: ValBool, Num: boolToUint64(l.Equal(r) == (op == "=="))}, true
+		case "-": if l.Type == r.Type { return l.Sub(r), true }
+		case "&", "|", "^":
+			v, err := l.Bitwise(bitwiseOps[op], r)
+			return v, err == nil
+		}
+		return Value{}, false
+	}
 	switch op {
 	case "+":
 		if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: l.Num + r.Num}, true }
@@ -429,23 +827,27 @@ func (c *NeoCompiler) foldInfix(l, r Value, op string) (Value, bool) {
 			return Value{Type: ValFloat, Num: math.Float64bits(lf * rf)}, true
 		}
 	case "/":
-		if (r.Type == ValInt && r.Num == 0) || (r.Type == ValFloat && math.Float64frombits(r.Num) == 0) {
-			c.errors = append(c.errors, "division by zero")
-			return Value{}, false
-		}
-		if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: l.Num / r.Num}, true }
+		// 除数为 0 不折叠也不报告编译错误，与其他后端一样在运行期出错，try 才能接住
+		if neoIsZeroDivisor(r) { return Value{}, false }
+		if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: uint64(int64(l.Num) / int64(r.Num))}, true }
 		if (l.Type == ValInt || l.Type == ValFloat) && (r.Type == ValInt || r.Type == ValFloat) {
 			lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 			return Value{Type: ValFloat, Num: math.Float64bits(lf / rf)}, true
 		}
 	case "%":
-		if r.Type == ValInt && r.Num == 0 { c.errors = append(c.errors, "division by zero"); return Value{}, false }
-		if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: l.Num % r.Num}, true }
+		if r.Type == ValInt && r.Num == 0 { return Value{}, false }
+		if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: uint64(int64(l.Num) % int64(r.Num))}, true }
 	case "==": return Value{Type: ValBool, Num: boolToUint64(c.compare(l, r) == 0)}, true
+	case "!=": return Value{Type: ValBool, Num: boolToUint64(c.compare(l, r) != 0)}, true
+	case "==*": return Value{Type: ValBool, Num: boolToUint64(l.EqualFold(r))}, true
 	case ">": return Value{Type: ValBool, Num: boolToUint64(c.compare(l, r) > 0)}, true
 	case "<": return Value{Type: ValBool, Num: boolToUint64(c.compare(l, r) < 0)}, true
 	case ">=": return Value{Type: ValBool, Num: boolToUint64(c.compare(l, r) >= 0)}, true
 	case "<=": return Value{Type: ValBool, Num: boolToUint64(c.compare(l, r) <= 0)}, true
+	case "&", "|", "^", "<<", ">>":
+		// 非整数或负移位数不折叠，留待运行期报错
+		v, err := l.Bitwise(bitwiseOps[op], r)
+		return v, err == nil
 	}
 	return Value{}, false
 }
@@ -473,6 +875,7 @@ func (c *NeoCompiler) compare(l, r Value) int {
 		case ValNil: return 0
 		}
 	}
+	if c, ok := compareTemporal(l, r); ok { return c }
 	lf, okL := valToFloat64(l); rf, okR := valToFloat64(r)
 	if okL && okR {
 		if lf < rf { return -1 }
@@ -482,32 +885,200 @@ func (c *NeoCompiler) compare(l, r Value) int {
 	return 0
 }
 
+// parseDefined 编译 `defined(x)`，实参只能是变量名
+func (c *NeoCompiler) parseDefined() (compilationValue, error) {
+	c.nextToken()
+	if c.peekToken.Type != TokenIdent { return compilationValue{}, fmt.Errorf("defined expects a variable name, e.g. defined(x)") }
+	c.nextToken()
+	name := c.curToken.Literal
+	if c.peekToken.Type != TokenRParen { return compilationValue{}, fmt.Errorf("defined expects a variable name, e.g. defined(x)") }
+	c.nextToken()
+	return c.compileDefined(name), nil
+}
+
+// compileDefined 编译对变量 name 的 defined：let 绑定与参数总是存在，其余由 DEFINED 查询上下文
+func (c *NeoCompiler) compileDefined(name string) compilationValue {
+	if _, ok := c.local(name); ok { return compilationValue{isConst: true, val: Value{Type: ValBool, Num: 1}} }
+	c.emit(NeoOpDefined, c.addConstant(Value{Type: ValString, Str: name}))
+	return compilationValue{isConst: false}
+}
+
+// pipeDefined 编译 `x |> defined`：撤回读取 x 的指令，改为对变量名的 defined
+func (c *NeoCompiler) pipeDefined(left compilationValue) (compilationValue, error) {
+	c.nextToken()
+	if c.peekToken.Type == TokenLParen { return compilationValue{}, fmt.Errorf("defined expects a variable name, e.g. defined(x)") }
+	if c.discard { return compilationValue{isConst: false}, nil }
+	if left.isConst { return compilationValue{isConst: true, val: Value{Type: ValBool, Num: 1}}, nil }
+	last := c.instructions[len(c.instructions)-1]
+	if last.Op != NeoOpGetGlobal && last.Op != NeoOpGetLocal { return compilationValue{}, fmt.Errorf("defined expects a variable name, e.g. defined(x)") }
+	c.instructions = c.instructions[:len(c.instructions)-1]
+	if last.Op == NeoOpGetLocal { return compilationValue{isConst: true, val: Value{Type: ValBool, Num: 1}}, nil }
+	c.emit(NeoOpDefined, last.Arg)
+	return compilationValue{isConst: false}, nil
+}
+
+// parseTry 编译 `try(expr, fallback)`：TRY 登记备用值的入口，expr 出错时 VM 恢复栈顶后从入口继续；
+// expr 正常求值后 ENDTRY 跳过备用值。expr 为常量时不会出错，备用值只做检查不生成代码
+func (c *NeoCompiler) parseTry() (compilationValue, error) {
+	c.nextToken()
+	c.nextToken()
+	try := c.emit(NeoOpTry, 0)
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	val, err := c.parseExpression(LOWEST)
+	if err != nil { return compilationValue{}, err }
+	if c.peekToken.Type != TokenComma { return compilationValue{}, fmt.Errorf("try expects 2 arguments: try(expr, fallback)") }
+	c.nextToken()
+	c.nextToken()
+	if val.isConst && try >= 0 && try == len(c.instructions)-1 {
+		c.instructions = c.instructions[:try]
+		if err := c.discardExpression(LOWEST); err != nil { return compilationValue{}, err }
+	} else {
+		if val.isConst { c.emitPush(val.val) }
+		end := c.emit(NeoOpEndTry, 0)
+		c.patch(try, int32(len(c.instructions)))
+		fallback, err := c.parseExpression(LOWEST)
+		if err != nil { return compilationValue{}, err }
+		if fallback.isConst { c.emitPush(fallback.val) }
+		c.patch(end, int32(len(c.instructions)))
+		val = compilationValue{isConst: false}
+	}
+	if c.peekToken.Type != TokenRParen { return compilationValue{}, fmt.Errorf("try expects 2 arguments: try(expr, fallback)") }
+	c.nextToken()
+	return val, nil
+}
+
+// parseScore 编译 `score { cond: weight, ... }`：先压入累加初值，每个条件之后由 SCORE 弹出条件并在其为真时加上权重。
+// 常量条件在编译期计入初值，条件全为常量时整个表达式即为常量
+func (c *NeoCompiler) parseScore() (compilationValue, error) {
+	c.nextToken()
+	start := len(c.instructions)
+	initIdx := c.emitPush(Value{Type: ValInt})
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	var bias []Value
+	isFloat, allConst := false, true
+	for n := 0; c.peekToken.Type != TokenRBrace; n++ {
+		if n > 0 {
+			if c.peekToken.Type != TokenComma { return compilationValue{}, fmt.Errorf("expected , or } in score, got %s", c.peekToken.Type) }
+			c.nextToken()
+		}
+		c.nextToken()
+		cond, err := c.parseExpression(LOWEST)
+		if err != nil { return compilationValue{}, err }
+		if c.peekToken.Type != TokenColon { return compilationValue{}, fmt.Errorf("expected : after score condition, got %s", c.peekToken.Type) }
+		c.nextToken(); c.nextToken()
+		neg := c.curToken.Type == TokenMinus
+		if neg { c.nextToken() }
+		if c.curToken.Type != TokenNumber { return compilationValue{}, fmt.Errorf("score weight must be a number, got %s", c.curToken.Type) }
+		wv, err := c.parseNumberLiteral()
+		if err != nil { return compilationValue{}, err }
+		w := wv.val
+		if neg {
+			if w.Type == ValInt { w.Num = uint64(-int64(w.Num)) } else { w.Num = math.Float64bits(-math.Float64frombits(w.Num)) }
+		}
+		isFloat = isFloat || w.Type == ValFloat
+		if cond.isConst {
+			if isValTruthy(cond.val) { bias = append(bias, w) }
+			continue
+		}
+		allConst = false
+		c.emit(NeoOpScore, c.addConstant(w))
+		c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	}
+	c.nextToken()
+	acc := Value{Type: ValInt}
+	if isFloat { acc = Value{Type: ValFloat} }
+	for _, w := range bias { acc = acc.Add(w) }
+	if allConst {
+		if !c.discard { c.instructions = c.instructions[:start] }
+		return compilationValue{isConst: true, val: acc}, nil
+	}
+	if initIdx >= 0 { c.instructions[initIdx].Arg = c.addConstant(acc) }
+	return compilationValue{isConst: false}, nil
+}
+
+// parseDestructure 编译 `{a, b} = m` 与 `{a, b} = m => body`，当前记号为第一个名称。
+// m 暂存在一个隐藏的栈槽中，每个名称编译为 GETL + MGETC + SETG + POP
+func (c *NeoCompiler) parseDestructure() (compilationValue, error) {
+	var names []string
+	for {
+		name := c.curToken.Literal
+		if _, ok := c.local(name); ok { return compilationValue{}, fmt.Errorf("cannot assign to let binding %s", name) }
+		if slices.Contains(names, name) { return compilationValue{}, fmt.Errorf("duplicate name %s in destructuring", name) }
+		names = append(names, name)
+		if c.peekToken.Type != TokenComma { break }
+		c.nextToken()
+		if c.peekToken.Type != TokenIdent { return compilationValue{}, fmt.Errorf("expected identifier in destructuring, got %s", c.peekToken.Type) }
+		c.nextToken()
+	}
+	if c.peekToken.Type != TokenRBrace { return compilationValue{}, fmt.Errorf("expected }, got %s", c.peekToken.Type) }
+	c.nextToken()
+	if c.peekToken.Type != TokenAssign { return compilationValue{}, fmt.Errorf("expected = after destructuring, got %s", c.peekToken.Type) }
+	c.nextToken(); c.nextToken()
+	val, err := c.parseExpression(LOWEST)
+	if err != nil { return compilationValue{}, err }
+	if val.isConst { c.emitPush(val.val) }
+	if c.slots >= maxLetBindings { return compilationValue{}, fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings) }
+	slot := int32(c.slots)
+	c.emit(NeoOpSetLocal, slot)
+	c.slots++
+	c.maxSlots = max(c.maxSlots, c.slots)
+	defer func() { c.slots-- }()
+	for _, name := range names {
+		idx := c.addConstant(Value{Type: ValString, Str: name})
+		c.emit(NeoOpGetLocal, slot)
+		c.emit(NeoOpMapGetConst, idx)
+		c.emit(NeoOpSetGlobal, idx)
+		c.emit(NeoOpPop, 0)
+	}
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	if c.peekToken.Type != TokenArrow {
+		c.emit(NeoOpGetLocal, slot)
+		return compilationValue{isConst: false}, nil
+	}
+	c.nextToken(); c.nextToken()
+	body, err := c.parseExpression(LOWEST)
+	if err != nil { return compilationValue{}, err }
+	body.lvalue = lvalueNone
+	return body, nil
+}
+
+// parseAssignExpression 编译 `x = v` 与 `a[i] = v`。赋值为右结合，其值即所赋的值，
+// 可继续参与外层运算，例如 `if (x = compute()) > 0 is x`。
 func (c *NeoCompiler) parseAssignExpression(left compilationValue) (compilationValue, error) {
-	if left.isConst { return compilationValue{}, fmt.Errorf("left side of assignment must be an identifier") }
+	if left.lvalue == lvalueLocal { return compilationValue{}, fmt.Errorf("cannot assign to let binding %s", c.lastLocal) }
+	if left.lvalue == lvalueNone { return compilationValue{}, fmt.Errorf("left side of assignment must be an identifier or index expression") }
 	if c.discard {
 		c.nextToken()
-		_, err := c.parseExpression(ASSIGN)
+		_, err := c.parseExpression(LOWEST)
 		return compilationValue{isConst: false}, err
 	}
+	// 左值标记保证最后一条指令正是标识符的 GETG 或下标的 INDEX，撤回它后再写回
 	lastInst := c.instructions[len(c.instructions)-1]
-	if lastInst.Op != NeoOpGetGlobal { return compilationValue{}, fmt.Errorf("left side of assignment must be an identifier") }
-	identIdx := lastInst.Arg
 	c.instructions = c.instructions[:len(c.instructions)-1]
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
 	c.nextToken()
-	val, err := c.parseExpression(ASSIGN)
+	val, err := c.parseExpression(LOWEST)
 	if err != nil { return compilationValue{}, err }
 	if val.isConst { c.emitPush(val.val) }
-	c.emit(NeoOpSetGlobal, identIdx)
+	if left.lvalue == lvalueIndex {
+		// 栈上保留数组与下标，SETIDX 写回后留下新值
+		c.emit(NeoOpSetIndex, 0)
+	} else {
+		c.emit(NeoOpSetGlobal, lastInst.Arg)
+	}
 	return compilationValue{isConst: false}, nil
 }
 
 func (c *NeoCompiler) parseCallExpression(left compilationValue) (compilationValue, error) {
 	if left.isConst { return compilationValue{}, fmt.Errorf("function call must be on an identifier") }
 	if c.discard {
-		numArgs := 0
 		if c.peekToken.Type != TokenRParen {
-			c.nextToken(); c.parseExpression(LOWEST); numArgs++
-			for c.peekToken.Type == TokenComma { c.nextToken(); c.nextToken(); c.parseExpression(LOWEST); numArgs++ }
+			c.nextToken()
+			if _, err := c.parseExpression(LOWEST); err != nil { return compilationValue{}, err }
+			for c.peekToken.Type == TokenComma {
+				c.nextToken(); c.nextToken()
+				if _, err := c.parseExpression(LOWEST); err != nil { return compilationValue{}, err }
+			}
 		}
 		if c.peekToken.Type != TokenRParen { return compilationValue{}, fmt.Errorf("expected ), got %s", c.peekToken.Type) }
 		c.nextToken(); return compilationValue{isConst: false}, nil
@@ -516,37 +1087,522 @@ func (c *NeoCompiler) parseCallExpression(left compilationValue) (compilationVal
 	if lastInst.Op != NeoOpGetGlobal { return compilationValue{}, fmt.Errorf("function call must be on an identifier") }
 	funcNameIdx := lastInst.Arg
 	c.instructions = c.instructions[:len(c.instructions)-1]
+	start := len(c.instructions)
+	c.fuseFloor = max(c.fuseFloor, start)
 	numArgs := 0
+	var consts []any
+	var marks []int
+	funcName := c.constants[funcNameIdx].Str
 	if c.peekToken.Type != TokenRParen {
+		mark := len(c.instructions)
 		c.nextToken(); val, err := c.parseExpression(LOWEST)
 		if err != nil { return compilationValue{}, err }
-		if val.isConst { c.emitPush(val.val) }
-		numArgs++
+		if val.isConst { c.emitPush(val.val); consts = append(consts, val.val.ToInterface()) }
+		c.prepareArg(funcName, numArgs, mark)
+		numArgs++; marks = append(marks, mark)
 		for c.peekToken.Type == TokenComma {
-			c.nextToken(); c.nextToken(); val, err = c.parseExpression(LOWEST)
+			mark = len(c.instructions)
+			c.nextToken(); c.nextToken()
+			if kind, params, ok := c.iterationAhead(funcName, numArgs); ok { return c.parseIteration(kind, params) }
+			val, err = c.parseExpression(LOWEST)
 			if err != nil { return compilationValue{}, err }
-			if val.isConst { c.emitPush(val.val) }
-			numArgs++
+			if val.isConst { c.emitPush(val.val); consts = append(consts, val.val.ToInterface()) }
+			c.prepareArg(funcName, numArgs, mark)
+			numArgs++; marks = append(marks, mark)
 		}
 	}
 	if c.peekToken.Type != TokenRParen { return compilationValue{}, fmt.Errorf("expected ), got %s", c.peekToken.Type) }
 	c.nextToken()
-	funcName := c.constants[funcNameIdx].Str
+	numArgs, consts = c.seedArg(funcName, numArgs, consts)
+	if len(consts) == numArgs {
+		if v, ok := c.foldCall(funcName, start, consts); ok { return v, nil }
+	}
+	if kind, ok := castKindOf(funcName); ok && numArgs == 1 {
+		c.emit(NeoOpCast, int32(kind))
+		return compilationValue{isConst: false, isString: kind == castStr}, nil
+	}
+	if c.emitSlice(funcName, numArgs, marks) { return compilationValue{isConst: false}, nil }
 	if funcName == "concat" {
 		if numArgs == 2 { c.emit(NeoOpConcat2, 0) } else { c.emit(NeoOpConcat, int32(numArgs)) }
 	} else { c.emit(NeoOpCall, funcNameIdx | int32(numArgs << 16)) }
 	return compilationValue{isConst: false}, nil
 }
 
+// parsePipeExpression 编译 `value |> f(args)`：左值已在栈上，作为 f 的第一个实参，
+// 随后压入其余实参，与 `f(value, args)` 生成相同的调用指令
+func (c *NeoCompiler) parsePipeExpression(left compilationValue) (compilationValue, error) {
+	if c.peekToken.Literal == "defined" && c.fns.index("defined") < 0 && (left.lvalue == lvalueIdent || left.lvalue == lvalueLocal) {
+		return c.pipeDefined(left)
+	}
+	if c.peekToken.Literal == "try" { return compilationValue{}, errTryPipe }
+	start := len(c.instructions)
+	var consts []any
+	if left.isConst {
+		c.fuseFloor = max(c.fuseFloor, start)
+		c.emitPush(left.val)
+		consts = append(consts, left.val.ToInterface())
+	}
+	if c.peekToken.Type != TokenIdent { return compilationValue{}, fmt.Errorf("expected function name after |>, got %s", c.peekToken.Type) }
+	c.nextToken()
+	name := c.curToken.Literal
+	if name == c.defining { return compilationValue{}, errNotDefinedYet(name) }
+	if _, ok := c.local(name); ok && c.fns.index(name) < 0 { return compilationValue{}, errLocalCall(name) }
+	numArgs := 1
+	var marks []int
+	if c.peekToken.Type == TokenLParen {
+		c.nextToken()
+		if c.peekToken.Type != TokenRParen {
+			for {
+				c.nextToken()
+				if kind, params, ok := c.iterationAhead(name, numArgs); ok { return c.parseIteration(kind, params) }
+				mark := len(c.instructions)
+				c.fuseFloor = max(c.fuseFloor, mark)
+				val, err := c.parseExpression(LOWEST)
+				if err != nil { return compilationValue{}, err }
+				if val.isConst { c.emitPush(val.val); consts = append(consts, val.val.ToInterface()) }
+				c.prepareArg(name, numArgs, mark)
+				numArgs++; marks = append(marks, mark)
+				if c.peekToken.Type != TokenComma { break }
+				c.nextToken()
+			}
+		}
+		if c.peekToken.Type != TokenRParen { return compilationValue{}, fmt.Errorf("expected ), got %s", c.peekToken.Type) }
+		c.nextToken()
+	}
+	numArgs, consts = c.seedArg(name, numArgs, consts)
+	if len(consts) == numArgs {
+		if v, ok := c.foldCall(name, start, consts); ok { return v, nil }
+	}
+	if kind, ok := castKindOf(name); ok && numArgs == 1 {
+		c.emit(NeoOpCast, int32(kind))
+		return compilationValue{isConst: false, isString: kind == castStr}, nil
+	}
+	if c.emitSlice(name, numArgs, marks) { return compilationValue{isConst: false}, nil }
+	switch i := c.fns.index(name); {
+	case i >= 0:
+		if params := c.fns.chunks[i].Params; numArgs != params { return compilationValue{}, fmt.Errorf("function %s expects %d arguments, got %d", name, params, numArgs) }
+		c.emit(NeoOpCallLocal, int32(i))
+	case name == "concat" && numArgs == 2: c.emit(NeoOpConcat2, 0)
+	case name == "concat": c.emit(NeoOpConcat, int32(numArgs))
+	default: c.emit(NeoOpCall, c.addConstant(Value{Type: ValString, Str: name})|int32(numArgs<<16))
+	}
+	return compilationValue{isConst: false}, nil
+}
+
+// parseOperatorPrefix 编译注册的前缀运算符，与 `builtin(x)` 生成相同的调用指令
+func (c *NeoCompiler) parseOperatorPrefix() (compilationValue, error) {
+	op, _ := operators.get(c.curToken.Literal)
+	if op.prefix == "" { return compilationValue{}, fmt.Errorf("%s is not a prefix operator", c.curToken.Literal) }
+	c.nextToken()
+	start := len(c.instructions)
+	c.fuseFloor = max(c.fuseFloor, start)
+	right, err := c.parseExpression(PREFIX)
+	if err != nil { return compilationValue{}, err }
+	return c.compileOperatorCall(op.prefix, 1, start, nil, right)
+}
+
+// parseOperatorInfix 编译注册的中缀运算符，与 `builtin(a, b)` 生成相同的调用指令
+func (c *NeoCompiler) parseOperatorInfix(left compilationValue) (compilationValue, error) {
+	op, _ := operators.get(c.curToken.Literal)
+	start := len(c.instructions)
+	var consts []any
+	if left.isConst { c.fuseFloor = max(c.fuseFloor, start); c.emitPush(left.val); consts = append(consts, left.val.ToInterface()) }
+	c.nextToken()
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	right, err := c.parseExpression(op.precedence)
+	if err != nil { return compilationValue{}, err }
+	return c.compileOperatorCall(op.infix, 2, start, consts, right)
+}
+
+// parseLikeExpression 编译 `s like pattern`，与 `glob(s, pattern)` 相同：常量模式在编译期解析后放入常量池
+func (c *NeoCompiler) parseLikeExpression(left compilationValue) (compilationValue, error) {
+	start := len(c.instructions)
+	var consts []any
+	if left.isConst { c.fuseFloor = max(c.fuseFloor, start); c.emitPush(left.val); consts = append(consts, left.val.ToInterface()) }
+	c.nextToken()
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	right, err := c.parseExpression(LESSGREATER)
+	if err != nil { return compilationValue{}, err }
+	return c.compileOperatorCall("glob", 2, start, consts, right)
+}
+
+// compileOperatorCall 压入运算符的最后一个操作数并以 numArgs 个实参调用 name。consts 为此前的常量实参，
+// 全部实参均为常量时撤回 start 之后的指令并折叠
+func (c *NeoCompiler) compileOperatorCall(name string, numArgs, start int, consts []any, last compilationValue) (compilationValue, error) {
+	if last.isConst {
+		mark := len(c.instructions)
+		c.emitPush(last.val)
+		if consts = append(consts, last.val.ToInterface()); len(consts) == numArgs {
+			if v, ok := c.foldCall(name, start, consts); ok { return v, nil }
+		}
+		c.prepareArg(name, numArgs-1, mark)
+	}
+	c.emit(NeoOpCall, c.addConstant(Value{Type: ValString, Str: name})|int32(numArgs<<16))
+	return compilationValue{isConst: false}, nil
+}
+
+// seedArg 在 hashSeed 非空时为 bucket 与 inRollout 的调用压入种子作为最后一个实参，与 seedHashCalls 一致
+func (c *NeoCompiler) seedArg(name string, numArgs int, consts []any) (int, []any) {
+	if arity, ok := hashSeedArity[name]; !ok || numArgs != arity || c.hashSeed == "" || c.discard { return numArgs, consts }
+	c.emitPush(Value{Type: ValString, Str: c.hashSeed})
+	return numArgs + 1, append(consts, c.hashSeed)
+}
+
+// iterationAhead 判断当前记号起的第 i 个实参能否内联：name 为 map、filter、reduce，i 为 1，
+// 且该实参是参数个数相符的 lambda 字面量（`x -> ...` 或 `(acc, x) -> ...`）。只向前查看，不消耗记号
+func (c *NeoCompiler) iterationAhead(name string, i int) (iterKind, []string, bool) {
+	kind, ok := iterKindOf(name)
+	if !ok || i != 1 || c.discard || c.fns.index(name) >= 0 { return 0, nil, false }
+	var params []string
+	switch c.curToken.Type {
+	case TokenIdent:
+		if c.peekToken.Type != TokenLambda { return 0, nil, false }
+		params = []string{c.curToken.Literal}
+	case TokenLParen:
+		// 在词法分析器的副本上读取括号中的参数表；`(x) ->` 不是合法写法，不在此处理
+		l, tok := *c.lexer, c.peekToken
+		for tok.Type == TokenIdent {
+			params = append(params, tok.Literal)
+			if tok = l.NextToken(); tok.Type != TokenComma { break }
+			tok = l.NextToken()
+		}
+		if tok.Type != TokenRParen || len(params) == 1 || l.NextToken().Type != TokenLambda { return 0, nil, false }
+	default:
+		return 0, nil, false
+	}
+	return kind, params, len(params) == kind.params()
+}
+
+// parseIteration 把 map、filter、reduce 的 lambda 字面量内联为循环，当前记号为 lambda 的第一个记号，
+// 被遍历的数组已在栈上。ITER 在其上压入下标，随后压入累加值；lambda 的参数绑定到新的栈槽，
+// 函数体直接在当前栈帧中求值，不为每个元素重新进入 VM。reduce 的初值写在 lambda 之后，
+// 先跳过循环体求出初值，再跳回循环开头：
+//
+//	ITER; JUMP init; loop: ITERNEXT end; SETL x; SETL acc; <body>; JUMP loop; end: ITEREND; JUMP done; init: <init>; JUMP loop; done:
+func (c *NeoCompiler) parseIteration(kind iterKind, params []string) (compilationValue, error) {
+	for c.curToken.Type != TokenLambda { c.nextToken() }
+	if slices.Contains(params[1:], params[0]) { return compilationValue{}, fmt.Errorf("duplicate lambda parameter %s", params[0]) }
+	if c.slots+len(params) > maxLetBindings { return compilationValue{}, fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings) }
+	c.emit(NeoOpIter, int32(kind))
+	init := -1
+	if kind == iterReduce { init = c.emit(NeoOpJump, 0) } else { c.emit(NeoOpMakeArray, 0) }
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	loop := c.emit(NeoOpIterNext, 0)
+	n, slot := len(c.locals), c.slots
+	for i, name := range params { c.locals = append(c.locals, neoLocal{name: name, slot: int32(slot + i)}) }
+	c.slots += len(params)
+	c.maxSlots = max(c.maxSlots, c.slots)
+	// 元素在栈顶，reduce 的累加值在其下，依次写入从后往前的参数槽位
+	for i := len(params) - 1; i >= 0; i-- { c.emit(NeoOpSetLocal, int32(slot+i)) }
+	c.nextToken()
+	val, err := c.parseExpression(LOWEST)
+	c.locals, c.slots = c.locals[:n], slot
+	if err != nil { return compilationValue{}, err }
+	if val.isConst { c.emitPush(val.val) }
+	switch kind {
+	case iterMap: c.emit(NeoOpAppend, 0)
+	case iterFilter:
+		skip := c.emit(NeoOpJumpIfFalse, 0)
+		c.emit(NeoOpGetLocal, int32(slot))
+		c.emit(NeoOpAppend, 0)
+		c.patch(skip, int32(len(c.instructions)))
+	}
+	c.emit(NeoOpJump, int32(loop))
+	c.patch(loop, int32(len(c.instructions)))
+	c.emit(NeoOpIterEnd, 0)
+	if kind == iterReduce {
+		if c.peekToken.Type != TokenComma { return compilationValue{}, fmt.Errorf("reduce expects 3 arguments: reduce(items, (acc, x) -> expr, init)") }
+		c.nextToken(); c.nextToken()
+		done := c.emit(NeoOpJump, 0)
+		c.patch(init, int32(len(c.instructions)))
+		val, err := c.parseExpression(LOWEST)
+		if err != nil { return compilationValue{}, err }
+		if val.isConst { c.emitPush(val.val) }
+		c.emit(NeoOpJump, int32(loop))
+		c.patch(done, int32(len(c.instructions)))
+	}
+	if c.peekToken.Type != TokenRParen { return compilationValue{}, fmt.Errorf("%s expects %d arguments", iterNames[kind], kind.args()) }
+	c.nextToken()
+	return compilationValue{isConst: false}, nil
+}
+
+// parseComprehension 编译数组推导式 `[expr for x in items if cond]`，当前记号为 `[`，name 为 for 之后的变量名。
+// 与解析器的改写 `map(filter(items, x -> cond), x -> expr)` 一致，先以 filter 循环求值全部 cond，再以 map 循环求值 expr。
+// expr 写在 items 之前，因此先编译 expr 并跳过，map 循环每次取出元素后跳回：
+//
+//	JUMP src; body: <expr>; APPEND; JUMP loop; src: <items>; [filter 循环]; ITER map; MKARR 0; loop: ITERNEXT end; SETL x; JUMP body; end: ITEREND
+func (c *NeoCompiler) parseComprehension(name string) (compilationValue, error) {
+	if c.slots+1 > maxLetBindings { return compilationValue{}, fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings) }
+	n, slot := len(c.locals), int32(c.slots)
+	bind := func() { c.locals = append(c.locals, neoLocal{name: name, slot: slot}); c.slots++; c.maxSlots = max(c.maxSlots, c.slots) }
+	unbind := func() { c.locals, c.slots = c.locals[:n], int(slot) }
+	src := c.emit(NeoOpJump, 0)
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	body := len(c.instructions)
+	c.nextToken()
+	bind()
+	val, err := c.parseExpression(LOWEST)
+	unbind()
+	if err != nil { return compilationValue{}, err }
+	if val.isConst { c.emitPush(val.val) }
+	c.emit(NeoOpAppend, 0)
+	next := c.emit(NeoOpJump, 0)
+	if c.peekToken.Type != TokenFor { return compilationValue{}, fmt.Errorf("expected for, got %s", c.peekToken.Type) }
+	c.nextToken(); c.nextToken(); c.nextToken()
+	c.patch(src, int32(len(c.instructions)))
+	c.nextToken()
+	if val, err = c.parseExpression(LOWEST); err != nil { return compilationValue{}, err }
+	if val.isConst { c.emitPush(val.val) }
+	filtered := c.peekToken.Type == TokenIf
+	if filtered {
+		c.nextToken()
+		c.emit(NeoOpIter, int32(iterFilter|iterComprehension))
+		c.emit(NeoOpMakeArray, 0)
+		c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+		loop := c.emit(NeoOpIterNext, 0)
+		c.emit(NeoOpSetLocal, slot)
+		c.nextToken()
+		bind()
+		val, err := c.parseExpression(LOWEST)
+		unbind()
+		if err != nil { return compilationValue{}, err }
+		if val.isConst { c.emitPush(val.val) }
+		c.emit(NeoOpJumpIfFalse, int32(loop))
+		c.emit(NeoOpGetLocal, slot)
+		c.emit(NeoOpAppend, 0)
+		c.emit(NeoOpJump, int32(loop))
+		c.patch(loop, int32(len(c.instructions)))
+		c.emit(NeoOpIterEnd, 0)
+	}
+	if c.peekToken.Type != TokenRBracket { return compilationValue{}, fmt.Errorf("expected ], got %s", c.peekToken.Type) }
+	c.nextToken()
+	// 有 if 时 map 遍历的是 filter 的结果，in 之后的值已由 filter 循环检查
+	arg := iterMap
+	if !filtered { arg |= iterComprehension }
+	c.emit(NeoOpIter, int32(arg))
+	c.emit(NeoOpMakeArray, 0)
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	loop := c.emit(NeoOpIterNext, 0)
+	c.patch(next, int32(loop))
+	c.emit(NeoOpSetLocal, slot)
+	c.emit(NeoOpJump, int32(body))
+	c.patch(loop, int32(len(c.instructions)))
+	c.emit(NeoOpIterEnd, 0)
+	return compilationValue{isConst: false}, nil
+}
+
+// emitSlice 在 `slice(x, start, end)` 的两个边界只生成了整数常量时撤回压入边界的指令，改为一条 SLICE。
+// marks 为各个括号内实参的起始位置，边界总是最后两个
+func (c *NeoCompiler) emitSlice(name string, numArgs int, marks []int) bool {
+	if name != "slice" || numArgs != 3 || len(marks) < 2 || c.discard || c.fns.index(name) >= 0 { return false }
+	lo, hi := marks[len(marks)-2], marks[len(marks)-1]
+	start, ok1 := c.constOperandIn(lo, hi)
+	end, ok2 := c.constOperandIn(hi, len(c.instructions))
+	if !ok1 || !ok2 { return false }
+	arg, ok := sliceBoundsArg(start, end)
+	if !ok { return false }
+	c.instructions = c.instructions[:lo]
+	c.emit(NeoOpSlice, arg)
+	return true
+}
+
+// foldCall 在实参均为常量时于编译期求出纯内置函数调用，并撤回 start 之后压入实参的指令。
+// 调用方须已把 fuseFloor 提升到 start，保证这些指令没有与更早的指令融合
+func (c *NeoCompiler) foldCall(name string, start int, args []any) (compilationValue, bool) {
+	if c.discard || c.fns.index(name) >= 0 { return compilationValue{}, false }
+	v, ok := foldBuiltin(name, args)
+	if !ok { return compilationValue{}, false }
+	c.instructions = c.instructions[:start]
+	return compilationValue{isConst: true, val: v, isString: v.Type == ValString}, true
+}
+
+// parseMemberCallExpression 编译 `recv.method(args)`。接收者已作为普通值留在栈上，
+// 因此可以是标识符以外的任意表达式，例如 `m["a"].has("x")`。
+func (c *NeoCompiler) parseMemberCallExpression(left compilationValue) (compilationValue, error) {
+	if c.peekToken.Type != TokenIdent { return compilationValue{}, fmt.Errorf("expected method name, got %s", c.peekToken.Type) }
+	c.nextToken()
+	method := c.curToken.Literal
+	// 输入文档模式下不带括号的 .name 是成员访问，与 ?.name 相同
+	if c.inputDocument && c.peekToken.Type != TokenLParen { return c.compileOptionalMember(left), nil }
+	if left.isConst { c.emitPush(left.val) }
+	if c.peekToken.Type != TokenLParen { return compilationValue{}, fmt.Errorf("expected (, got %s", c.peekToken.Type) }
+	c.nextToken()
+	numArgs := 0
+	if c.peekToken.Type != TokenRParen {
+		for {
+			c.nextToken()
+			c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+			val, err := c.parseExpression(LOWEST)
+			if err != nil { return compilationValue{}, err }
+			if val.isConst { c.emitPush(val.val) }
+			numArgs++
+			if c.peekToken.Type != TokenComma { break }
+			c.nextToken()
+		}
+	}
+	if c.peekToken.Type != TokenRParen { return compilationValue{}, fmt.Errorf("expected ), got %s", c.peekToken.Type) }
+	c.nextToken()
+	// 常用的映射方法有专用指令，直接操作栈上的值，免去参数装箱
+	switch {
+	case method == "get" && numArgs == 1: c.emit(NeoOpMapGet, 0)
+	case method == "has" && numArgs == 1: c.emit(NeoOpMapHas, 0)
+	case method == "set" && numArgs == 2: c.emit(NeoOpMapSet, 0)
+	case method == "del" && numArgs == 1: c.emit(NeoOpMapDel, 0)
+	default: c.emit(NeoOpCallMethod, c.addConstant(Value{Type: ValString, Str: method})|int32(numArgs<<16))
+	}
+	return compilationValue{isConst: false}, nil
+}
+
+// parseOptionalMemberExpression 编译 `recv?.name`：接收者不是映射时 JNMAP 把它替换为 nil
+// 并跳过 MGETC。常量接收者只能是标量，结果直接折叠为 nil
+func (c *NeoCompiler) parseOptionalMemberExpression(left compilationValue) (compilationValue, error) {
+	if c.peekToken.Type != TokenIdent { return compilationValue{}, fmt.Errorf("expected member name, got %s", c.peekToken.Type) }
+	c.nextToken()
+	return c.compileOptionalMember(left), nil
+}
+
+// compileOptionalMember 编译当前记号所命名的可选成员访问，接收者 left 已编译
+func (c *NeoCompiler) compileOptionalMember(left compilationValue) compilationValue {
+	if left.isConst { return compilationValue{isConst: true, val: Value{Type: ValNil}} }
+	skip := c.emit(NeoOpJumpIfNotMap, 0)
+	c.emit(NeoOpMapGetConst, c.addConstant(Value{Type: ValString, Str: c.curToken.Literal}))
+	c.patch(skip, int32(len(c.instructions)))
+	return compilationValue{isConst: false}
+}
+
+// parseBlock 编译块表达式 `{ 语句; ... }`，值为最后一条语句的值，其余语句的值被 POP 丢弃。
+// parsed 为 true 时第一条语句已编译，其值为 val。块内 let 语句的绑定与槽位在块结束时释放
+func (c *NeoCompiler) parseBlock(val compilationValue, parsed bool) (compilationValue, error) {
+	n, slots := len(c.locals), c.slots
+	for {
+		if !parsed {
+			c.letStatement = c.curToken.Type == TokenLet
+			c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+			var err error
+			if val, err = c.parseExpression(LOWEST); err != nil { return compilationValue{}, err }
+		}
+		parsed = false
+		more, err := nextStatement(c, TokenRBrace)
+		if err != nil { return compilationValue{}, err }
+		if c.openLet {
+			c.openLet = false
+			if !more { return compilationValue{}, fmt.Errorf("let %s must be followed by a statement", c.lastLocal) }
+			continue
+		}
+		if !more { break }
+		if !val.isConst { c.emit(NeoOpPop, 0) }
+	}
+	if c.peekToken.Type != TokenRBrace { return compilationValue{}, fmt.Errorf("expected }, got %s", c.peekToken.Type) }
+	c.nextToken()
+	c.locals, c.slots = c.locals[:n], slots
+	val.lvalue = lvalueNone
+	return val, nil
+}
+
+// parseArrayLiteral 依次压入各元素，由 MKARR 收集为数组；数组不参与常量折叠。
+// 含展开时，第一个展开之前的元素由 MKARR 收集，其后每个展开的数组，以及每段普通元素由 MKARR 收集后，依次由 SPREAD 追加
+func (c *NeoCompiler) parseArrayLiteral() (compilationValue, error) {
+	numElems, spread := 0, false
+	flush := func() {
+		if !spread { c.emit(NeoOpMakeArray, int32(numElems)); spread = true } else if numElems > 0 { c.emit(NeoOpMakeArray, int32(numElems)); c.emit(NeoOpSpread, 0) }
+		numElems = 0
+	}
+	if name, ok := comprehensionAhead(*c.lexer, c.peekToken); ok { return c.parseComprehension(name) }
+	if c.peekToken.Type != TokenRBracket {
+		for {
+			c.nextToken()
+			isSpread := c.curToken.Type == TokenSpread
+			if isSpread { flush(); c.nextToken() }
+			// 元素之间不能跨界融合，例如 ["a" + x, "b" + y] 的第二个 CONCAT 不能并入第一个
+			c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+			val, err := c.parseExpression(LOWEST)
+			if err != nil { return compilationValue{}, err }
+			if val.isConst { c.emitPush(val.val) }
+			if isSpread { c.emit(NeoOpSpread, 0) } else { numElems++ }
+			if c.peekToken.Type != TokenComma { break }
+			c.nextToken()
+		}
+	}
+	if c.peekToken.Type != TokenRBracket { return compilationValue{}, fmt.Errorf("expected ], got %s", c.peekToken.Type) }
+	c.nextToken()
+	if spread { flush(); return compilationValue{isConst: false}, nil }
+	c.emit(NeoOpMakeArray, int32(numElems))
+	return compilationValue{isConst: false, array: true}, nil
+}
+
+// parseMapLiteral 依次压入 键、值 对，由 MKMAP 收集为 map。键为字符串常量且值均为常量
+// （含已折叠为 COPYC 的嵌套 map）时，撤回这些压栈，整体折叠为常量池中的一个 map，以 COPYC 取副本。
+func (c *NeoCompiler) parseMapLiteral() (compilationValue, error) {
+	start := len(c.instructions)
+	proto := make(map[string]any)
+	foldable := true
+	numPairs := 0
+	if c.peekToken.Type != TokenRBrace {
+		c.nextToken()
+		// `{a, b} = m` 是解构赋值：第一个成员是其后紧跟 , 或 } 的名称
+		if c.curToken.Type == TokenIdent && (c.peekToken.Type == TokenComma || c.peekToken.Type == TokenRBrace) { return c.parseDestructure() }
+		// 以 let 开头、或第一项之后不是 : 的是块表达式
+		if c.curToken.Type == TokenLet { return c.parseBlock(compilationValue{}, false) }
+		for {
+			c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+			key, err := c.parseExpression(LOWEST)
+			if err != nil { return compilationValue{}, err }
+			if numPairs == 0 && c.peekToken.Type != TokenColon { return c.parseBlock(key, true) }
+			if key.isConst { c.emitPush(key.val) }
+			foldable = foldable && key.isConst && key.val.Type == ValString
+			if c.peekToken.Type != TokenColon { return compilationValue{}, fmt.Errorf("expected :, got %s", c.peekToken.Type) }
+			c.nextToken(); c.nextToken()
+			c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+			mark := len(c.instructions)
+			val, err := c.parseExpression(LOWEST)
+			if err != nil { return compilationValue{}, err }
+			switch {
+			case val.isConst:
+				c.emitPush(val.val)
+				if foldable { proto[key.val.Str] = val.val.ToInterface() }
+			case foldable && len(c.instructions) == mark+1 && c.instructions[mark].Op == NeoOpCopyConst:
+				proto[key.val.Str] = c.constants[c.instructions[mark].Arg].Obj
+			default:
+				foldable = false
+			}
+			numPairs++
+			if c.peekToken.Type != TokenComma { break }
+			c.nextToken(); c.nextToken()
+		}
+	}
+	if c.peekToken.Type != TokenRBrace { return compilationValue{}, fmt.Errorf("expected }, got %s", c.peekToken.Type) }
+	c.nextToken()
+	if foldable && !c.discard {
+		c.instructions = c.instructions[:start]
+		c.emit(NeoOpCopyConst, c.addConstant(Value{Type: ValMap, Obj: proto}))
+		return compilationValue{isConst: false}, nil
+	}
+	c.emit(NeoOpMakeMap, int32(numPairs))
+	return compilationValue{isConst: false}, nil
+}
+
+func (c *NeoCompiler) parseIndexExpression(left compilationValue) (compilationValue, error) {
+	if left.isConst { c.emitPush(left.val) }
+	c.nextToken()
+	c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+	idx, err := c.parseExpression(LOWEST)
+	if err != nil { return compilationValue{}, err }
+	if idx.isConst { c.emitPush(idx.val) }
+	if c.peekToken.Type != TokenRBracket { return compilationValue{}, fmt.Errorf("expected ], got %s", c.peekToken.Type) }
+	c.nextToken()
+	c.emit(NeoOpIndex, 0)
+	return compilationValue{isConst: false, lvalue: lvalueIndex}, nil
+}
+
 func (c *NeoCompiler) parseIfExpression() (compilationValue, error) {
 	c.nextToken(); cond, err := c.parseExpression(LOWEST)
 	if err != nil { return compilationValue{}, err }
 	if c.peekToken.Type == TokenThen {
+		start := c.peekToken.Pos
 		c.nextToken(); c.nextToken()
 		if cond.isConst {
 			if isValTruthy(cond.val) { return c.parseExpression(LOWEST) } else {
-				oldDiscard := c.discard; c.discard = true; _, err = c.parseExpression(LOWEST); c.discard = oldDiscard
-				return compilationValue{isConst: true, val: Value{Type: ValNil}}, err
+				return compilationValue{isConst: true, val: Value{Type: ValNil}}, c.discardBranch(start)
 			}
 		}
 		jumpFalse := c.emit(NeoOpJumpIfFalse, 0)
@@ -560,12 +1616,13 @@ func (c *NeoCompiler) parseIfExpression() (compilationValue, error) {
 		var jumpEndTargets []int
 		for {
 			if c.peekToken.Type != TokenIs { return compilationValue{}, fmt.Errorf("expected is after if condition, got %s", c.peekToken.Type) }
+			start := c.peekToken.Pos
 			c.nextToken(); c.nextToken(); var jumpFalse int; var tookBranch bool
 			if cond.isConst {
 				if isValTruthy(cond.val) {
 					cons, err := c.parseExpression(LOWEST); if err != nil { return compilationValue{}, err }
 					if cons.isConst { c.emitPush(cons.val) }; tookBranch = true
-				} else { oldDiscard := c.discard; c.discard = true; c.parseExpression(LOWEST); c.discard = oldDiscard }
+				} else if err := c.discardBranch(start); err != nil { return compilationValue{}, err }
 			} else {
 				jumpFalse = c.emit(NeoOpJumpIfFalse, 0)
 				cons, err := c.parseExpression(LOWEST); if err != nil { return compilationValue{}, err }
@@ -573,18 +1630,30 @@ func (c *NeoCompiler) parseIfExpression() (compilationValue, error) {
 				jumpEndTargets = append(jumpEndTargets, c.emit(NeoOpJump, 0)); c.patch(jumpFalse, int32(len(c.instructions)))
 			}
 			if tookBranch {
-				for c.peekToken.Type == TokenElse {
+				start := c.peekToken.Pos
+				for c.peekToken.Type == TokenElse || c.peekToken.Type == TokenElif {
 					c.nextToken()
-					if c.peekToken.Type == TokenIf {
-						c.nextToken(); c.nextToken(); oldDiscard := c.discard; c.discard = true; c.parseExpression(LOWEST)
-						if c.peekToken.Type == TokenIs { c.nextToken(); c.nextToken(); c.parseExpression(LOWEST) }
-						c.discard = oldDiscard
+					if c.curToken.Type == TokenElif || c.peekToken.Type == TokenIf {
+						if c.curToken.Type == TokenElse { c.nextToken() }
+						c.nextToken()
+						if err := c.discardExpression(LOWEST); err != nil { return compilationValue{}, err }
+						if c.peekToken.Type == TokenIs {
+							c.nextToken(); c.nextToken()
+							if err := c.discardExpression(LOWEST); err != nil { return compilationValue{}, err }
+						}
 					} else if c.peekToken.Type == TokenIs {
-						c.nextToken(); c.nextToken(); oldDiscard := c.discard; c.discard = true; c.parseExpression(LOWEST); c.discard = oldDiscard; break
+						c.nextToken(); c.nextToken()
+						if err := c.discardExpression(LOWEST); err != nil { return compilationValue{}, err }
+						break
 					}
 				}
+				if c.curToken.End > start { c.dead = append(c.dead, Span{Start: start, End: c.curToken.End}) }
 				break
 			}
+			// `elif` 与 `else if` 编译为相同的跳转结构
+			if c.peekToken.Type == TokenElif { c.nextToken(); c.nextToken(); cond, err = c.parseExpression(LOWEST); if err != nil { return compilationValue{}, err }
+				continue
+			}
 			if c.peekToken.Type != TokenElse { c.emitPush(Value{Type: ValNil}); break }
 			c.nextToken()
 			if c.peekToken.Type == TokenIf { c.nextToken(); c.nextToken(); cond, err = c.parseExpression(LOWEST); if err != nil { return compilationValue{}, err }
@@ -602,6 +1671,265 @@ func (c *NeoCompiler) parseIfExpression() (compilationValue, error) {
 	return compilationValue{}, fmt.Errorf("expected then or is after if condition, got %s", c.peekToken.Type)
 }
 
+// parseMatchExpression 编译 `match x { 1 => "a", _ => "c" }`，跳转结构与等价的 else if 链相同：
+// 每个分支生成 GETG/PUSH/EQ/JIF，由 emit 与 peephole 融合为带跳转的比较指令。
+// 被匹配的值不是变量时与 AST 前端一样先求值一次，存入隐含的 let 槽位，各分支以 GETL 读取。
+func (c *NeoCompiler) parseMatchExpression() (compilationValue, error) {
+	c.nextToken()
+	subject := c.curToken
+	if subject.Type == TokenIdent && c.peekToken.Type == TokenLBrace {
+		c.nextToken()
+		return c.compileMatchArms(subject)
+	}
+	val, err := c.parseExpression(LOWEST)
+	if err != nil { return compilationValue{}, err }
+	if c.peekToken.Type != TokenLBrace { return compilationValue{}, fmt.Errorf("expected { after match subject, got %s", c.peekToken.Type) }
+	c.nextToken()
+	l := neoLocal{name: matchSubject, value: compilationValue{isConst: val.isConst, val: val.val, isString: val.isString}}
+	if !val.isConst {
+		if c.slots >= maxLetBindings { return compilationValue{}, fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings) }
+		l.slot = int32(c.slots)
+		c.emit(NeoOpSetLocal, l.slot)
+		c.slots++
+		c.maxSlots = max(c.maxSlots, c.slots)
+	}
+	n := len(c.locals)
+	c.locals = append(c.locals, l)
+	res, err := c.compileMatchArms(Token{Type: TokenIdent, Literal: matchSubject})
+	c.locals = c.locals[:n]
+	if !val.isConst { c.slots-- }
+	return res, err
+}
+
+// compileMatchArms 编译 match 的分支，当前记号为 `{`，各分支把 subject 作为标识符重新编译后比较
+func (c *NeoCompiler) compileMatchArms(subject Token) (compilationValue, error) {
+	var jumpEndTargets []int
+	arms, hasDefault := 0, false
+	for c.peekToken.Type != TokenRBrace {
+		if hasDefault { return compilationValue{}, fmt.Errorf("match default arm _ must be the last arm") }
+		c.nextToken()
+		isDefault := c.curToken.Type == TokenIdent && c.curToken.Literal == "_"
+		jumpFalse := -1
+		if !isDefault {
+			cur := c.curToken
+			c.curToken = subject
+			if sub, _ := c.parseIdentifier(); sub.isConst { c.emitPush(sub.val) }
+			c.curToken = cur
+			pat, err := c.parseExpression(LOWEST)
+			if err != nil { return compilationValue{}, err }
+			if pat.isConst { c.emitPush(pat.val) }
+			c.emit(NeoOpEqual, 0)
+			jumpFalse = c.emit(NeoOpJumpIfFalse, 0)
+		}
+		if c.peekToken.Type != TokenArrow { return compilationValue{}, fmt.Errorf("expected => in match arm, got %s", c.peekToken.Type) }
+		c.nextToken(); c.nextToken()
+		val, err := c.parseExpression(LOWEST)
+		if err != nil { return compilationValue{}, err }
+		if val.isConst { c.emitPush(val.val) }
+		if isDefault { hasDefault = true } else {
+			jumpEndTargets = append(jumpEndTargets, c.emit(NeoOpJump, 0))
+			c.patch(jumpFalse, int32(len(c.instructions)))
+		}
+		arms++
+		if c.peekToken.Type == TokenComma { c.nextToken() } else if c.peekToken.Type != TokenRBrace {
+			return compilationValue{}, fmt.Errorf("expected , or } in match, got %s", c.peekToken.Type)
+		}
+	}
+	c.nextToken()
+	if arms == 0 { return compilationValue{}, fmt.Errorf("match requires at least one arm") }
+	if !hasDefault { c.emitPush(Value{Type: ValNil}) }
+	for _, target := range jumpEndTargets { c.patch(target, int32(len(c.instructions))) }
+	return compilationValue{isConst: false}, nil
+}
+
+// parseLetExpression 编译 `let name = value => body`：value 由 SETL 写入栈底槽位，body 中的 name
+// 编译为 GETL，不经过 Context。value 为常量时直接内联到 body，不占用槽位。
+func (c *NeoCompiler) parseLetExpression() (compilationValue, error) {
+	statement := c.letStatement
+	c.letStatement = false
+	if c.peekToken.Type != TokenIdent { return compilationValue{}, fmt.Errorf("expected identifier after let, got %s", c.peekToken.Type) }
+	c.nextToken()
+	name := c.curToken.Literal
+	if c.peekToken.Type != TokenAssign { return compilationValue{}, fmt.Errorf("expected = after let %s, got %s", name, c.peekToken.Type) }
+	c.nextToken(); c.nextToken()
+	val, err := c.parseExpression(LOWEST)
+	if err != nil { return compilationValue{}, err }
+	// let 语句：绑定留在 locals 中，由 compileStatements 编译其后的语句
+	open := statement && (c.peekToken.Type == TokenSemicolon || c.peekToken.Type == TokenEOF || c.peekToken.Newline)
+	if !open {
+		if c.peekToken.Type != TokenArrow { return compilationValue{}, fmt.Errorf("expected => after let value, got %s", c.peekToken.Type) }
+		c.nextToken(); c.nextToken()
+	}
+	l := neoLocal{name: name, value: compilationValue{isConst: val.isConst, val: val.val, isString: val.isString}}
+	if !val.isConst {
+		if c.slots >= maxLetBindings { return compilationValue{}, fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings) }
+		l.slot = int32(c.slots)
+		c.emit(NeoOpSetLocal, l.slot)
+		c.slots++
+		c.maxSlots = max(c.maxSlots, c.slots)
+	}
+	n := len(c.locals)
+	c.locals = append(c.locals, l)
+	if open {
+		c.lastLocal, c.openLet = name, true
+		return compilationValue{}, nil
+	}
+	body, err := c.parseExpression(LOWEST)
+	c.locals = c.locals[:n]
+	if !val.isConst { c.slots-- }
+	if err != nil { return compilationValue{}, err }
+	body.lvalue = lvalueNone
+	return body, nil
+}
+
+// errNotDefinedYet 报告函数体中对自身的调用：函数名在函数体编译完成后才绑定
+func errNotDefinedYet(name string) error { return fmt.Errorf("fn %s is not defined yet; recursion is not supported", name) }
+
+// compileFunction 编译 `fn name(params) => body;`：函数体编译为独立的指令块，参数依次占据其栈帧
+// 最低的槽位。函数体只能调用此前定义的函数，编译完成后才登记 name，因此不存在递归。
+func (c *NeoCompiler) compileFunction() error {
+	if c.peekToken.Type != TokenIdent { return fmt.Errorf("expected function name after fn, got %s", c.peekToken.Type) }
+	c.nextToken()
+	name := c.curToken.Literal
+	if _, ok := builtins.get(name); ok { return fmt.Errorf("function %s shadows a builtin", name) }
+	if c.fns.index(name) >= 0 { return fmt.Errorf("function %s already defined", name) }
+	if len(c.fns.chunks)-c.lambdas >= maxFunctions { return fmt.Errorf("too many functions (max %d)", maxFunctions) }
+	if c.peekToken.Type != TokenLParen { return fmt.Errorf("expected ( after fn %s, got %s", name, c.peekToken.Type) }
+	c.nextToken()
+	for c.peekToken.Type != TokenRParen {
+		if len(c.locals) > 0 {
+			if c.peekToken.Type != TokenComma { return fmt.Errorf("expected , or ), got %s", c.peekToken.Type) }
+			c.nextToken()
+		}
+		if c.peekToken.Type != TokenIdent { return fmt.Errorf("expected parameter name, got %s", c.peekToken.Type) }
+		c.nextToken()
+		if _, dup := c.local(c.curToken.Literal); dup { return fmt.Errorf("duplicate parameter %s in function %s", c.curToken.Literal, name) }
+		c.locals = append(c.locals, neoLocal{name: c.curToken.Literal, slot: int32(len(c.locals))})
+	}
+	c.nextToken()
+	params := len(c.locals)
+	if params > maxLetBindings { return fmt.Errorf("too many parameters in function %s (max %d)", name, maxLetBindings) }
+	if c.peekToken.Type != TokenArrow { return fmt.Errorf("expected => after fn %s parameters, got %s", name, c.peekToken.Type) }
+	c.nextToken(); c.nextToken()
+	c.slots, c.maxSlots = params, params
+	c.defining = name
+	val, err := c.parseExpression(LOWEST)
+	c.defining = ""
+	if err != nil { return err }
+	if val.isConst { c.emitPush(val.val) }
+	// 函数定义以分号或换行结束
+	if c.peekToken.Type == TokenSemicolon { c.nextToken() } else if !c.peekToken.Newline { return fmt.Errorf("expected ; after function %s, got %s", name, c.peekToken.Type) }
+	c.nextToken()
+	c.peephole()
+	c.emit(NeoOpReturnLocal, 0)
+	c.fns.add(name, &NeoBytecode{Instructions: c.instructions, Locals: c.maxSlots, Name: name, Params: params})
+	// 主程序从空的指令流开始编译
+	c.instructions, c.fuseFloor = nil, 0
+	c.locals, c.slots, c.maxSlots = c.locals[:0], 0, 0
+	return nil
+}
+
+// parseLocalCall 编译对第 i 个规则内函数的调用：实参依次入栈后由 CALLL 转入函数块
+func (c *NeoCompiler) parseLocalCall(i int) (compilationValue, error) {
+	name, params := c.fns.names[i], c.fns.chunks[i].Params
+	c.nextToken()
+	numArgs := 0
+	if c.peekToken.Type != TokenRParen {
+		for {
+			c.nextToken()
+			c.fuseFloor = max(c.fuseFloor, len(c.instructions))
+			val, err := c.parseExpression(LOWEST)
+			if err != nil { return compilationValue{}, err }
+			if val.isConst { c.emitPush(val.val) }
+			numArgs++
+			if c.peekToken.Type != TokenComma { break }
+			c.nextToken()
+		}
+	}
+	if c.peekToken.Type != TokenRParen { return compilationValue{}, fmt.Errorf("expected ), got %s", c.peekToken.Type) }
+	c.nextToken()
+	if numArgs != params { return compilationValue{}, fmt.Errorf("function %s expects %d arguments, got %d", name, params, numArgs) }
+	c.emit(NeoOpCallLocal, int32(i))
+	return compilationValue{isConst: false}, nil
+}
+
+// parseLambdaParameters 编译括号中的 lambda 参数表，当前记号为 `)` 或第一个参数
+func (c *NeoCompiler) parseLambdaParameters() (compilationValue, error) {
+	var params []string
+	if c.curToken.Type == TokenIdent {
+		params = append(params, c.curToken.Literal)
+		for c.peekToken.Type == TokenComma {
+			c.nextToken()
+			if c.peekToken.Type != TokenIdent { return compilationValue{}, fmt.Errorf("expected lambda parameter, got %s", c.peekToken.Type) }
+			c.nextToken()
+			params = append(params, c.curToken.Literal)
+		}
+		if c.peekToken.Type != TokenRParen { return compilationValue{}, fmt.Errorf("expected ), got %s", c.peekToken.Type) }
+		c.nextToken()
+	}
+	if c.peekToken.Type != TokenLambda { return compilationValue{}, fmt.Errorf("expected -> after lambda parameters, got %s", c.peekToken.Type) }
+	c.nextToken()
+	return c.parseLambda(params)
+}
+
+// parseLambda 把 `->` 之后的函数体编译为匿名指令块：当前占用栈槽的 let 绑定按原槽位捕获，
+// 参数紧随其后，常量绑定照常内联。函数体编译完成后恢复外层的指令流，在其中生成 MKCLOS。
+func (c *NeoCompiler) parseLambda(params []string) (compilationValue, error) {
+	if c.lambdas >= maxLambdas { return compilationValue{}, fmt.Errorf("too many lambdas (max %d)", maxLambdas) }
+	captured := c.slots
+	if captured+len(params) > maxLetBindings { return compilationValue{}, fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings) }
+	n := len(c.locals)
+	defer func() { c.locals = c.locals[:n] }()
+	for i, name := range params {
+		if slices.Contains(params[:i], name) { return compilationValue{}, fmt.Errorf("duplicate lambda parameter %s", name) }
+		c.locals = append(c.locals, neoLocal{name: name, slot: int32(captured + i)})
+	}
+	c.nextToken()
+	if c.discard { return compilationValue{isConst: false}, c.discardExpression(LOWEST) }
+
+	insts, fuseFloor, slots, maxSlots := c.instructions, c.fuseFloor, c.slots, c.maxSlots
+	c.instructions, c.fuseFloor = nil, 0
+	c.slots = captured + len(params)
+	c.maxSlots = c.slots
+	val, err := c.parseExpression(LOWEST)
+	if err == nil {
+		if val.isConst { c.emitPush(val.val) }
+		c.peephole()
+		c.emit(NeoOpReturnLocal, 0)
+	}
+	chunk := &NeoBytecode{Instructions: c.instructions, Locals: c.maxSlots, Name: lambdaName, Params: c.slots}
+	c.instructions, c.fuseFloor, c.slots, c.maxSlots = insts, fuseFloor, slots, maxSlots
+	if err != nil { return compilationValue{}, err }
+	c.lambdas++
+	i := c.fns.add("", chunk)
+	c.emit(NeoOpMakeClosure, int32(i)|int32(captured)<<16)
+	return compilationValue{isConst: false}, nil
+}
+
+// local 查找 name 对应的 let 绑定，内层绑定优先
+func (c *NeoCompiler) local(name string) (neoLocal, bool) {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].name == name { return c.locals[i], true }
+	}
+	return neoLocal{}, false
+}
+
+// discardExpression 解析一个不会被执行的分支：不生成代码，但仍报告其中的编译错误
+func (c *NeoCompiler) discardExpression(precedence int) error {
+	oldDiscard := c.discard
+	c.discard = true
+	_, err := c.parseExpression(precedence)
+	c.discard = oldDiscard
+	return err
+}
+
+// discardBranch 以 discardExpression 丢弃常量条件不会选中的分支，并记下从 start（其 is 或 then）起的源码位置
+func (c *NeoCompiler) discardBranch(start int) error {
+	if err := c.discardExpression(LOWEST); err != nil { return err }
+	c.dead = append(c.dead, Span{Start: start, End: c.curToken.End})
+	return nil
+}
+
 func (c *NeoCompiler) emit(op NeoOpCode, arg int32) int {
 	if c.discard {
 		return -1
@@ -609,12 +1937,18 @@ func (c *NeoCompiler) emit(op NeoOpCode, arg int32) int {
 
 	n := len(c.instructions)
 
+	// 常量 0 作除数时不融合，留一条 DIV 在运行期报告除零
+	if op == NeoOpDiv && n > 0 && c.instructions[n-1].Op == NeoOpPush && neoIsZeroDivisor(c.constants[c.instructions[n-1].Arg]) {
+		c.instructions = append(c.instructions, neoInstruction{Op: op, Arg: arg})
+		return n
+	}
+
 	// 3rd-order patterns (GC, CG, GG) and 2nd-order (C)
 	// We skip Jump patterns here because they require knowing the target range,
 	// which is not known during emit (patched later). Jumps are handled in peephole.
 	switch op {
-	case NeoOpAdd, NeoOpSub, NeoOpMul, NeoOpDiv, NeoOpEqual, NeoOpGreater, NeoOpLess, NeoOpConcat2:
-		if n >= 2 {
+	case NeoOpAdd, NeoOpSub, NeoOpMul, NeoOpDiv, NeoOpEqual, NeoOpNotEqual, NeoOpGreater, NeoOpLess, NeoOpConcat2:
+		if n-2 >= c.fuseFloor {
 			i1 := c.instructions[n-2]
 			i2 := c.instructions[n-1]
 			if i1.Op == NeoOpGetGlobal && i2.Op == NeoOpPush {
@@ -626,6 +1960,7 @@ func (c *NeoCompiler) emit(op NeoOpCode, arg int32) int {
 					case NeoOpMul: newOp = NeoOpMulGC
 					case NeoOpDiv: newOp = NeoOpDivGC
 					case NeoOpEqual: newOp = NeoOpEqualGlobalConst
+					case NeoOpNotEqual: newOp = NeoOpNotEqualGlobalConst
 					case NeoOpGreater: newOp = NeoOpGreaterGlobalConst
 					case NeoOpLess: newOp = NeoOpLessGlobalConst
 					case NeoOpConcat2: newOp = NeoOpConcatGC
@@ -645,6 +1980,7 @@ func (c *NeoCompiler) emit(op NeoOpCode, arg int32) int {
 					case NeoOpMul: newOp = NeoOpMulCG
 					case NeoOpDiv: newOp = NeoOpDivCG
 					case NeoOpEqual: newOp = NeoOpEqualGlobalConst
+					case NeoOpNotEqual: newOp = NeoOpNotEqualGlobalConst
 					case NeoOpConcat2: newOp = NeoOpConcatCG
 					}
 					if newOp != 0 {
@@ -679,7 +2015,7 @@ func (c *NeoCompiler) emit(op NeoOpCode, arg int32) int {
 			}
 		}
 		// 2nd-order (OpC)
-		if n >= 1 {
+		if n-1 >= c.fuseFloor {
 			prev := c.instructions[n-1]
 			if prev.Op == NeoOpPush {
 				newOp := NeoOpCode(0)
@@ -689,6 +2025,7 @@ func (c *NeoCompiler) emit(op NeoOpCode, arg int32) int {
 				case NeoOpMul: newOp = NeoOpMulC
 				case NeoOpDiv: newOp = NeoOpDivC
 				case NeoOpEqual: newOp = NeoOpEqualC
+				case NeoOpNotEqual: newOp = NeoOpNotEqualC
 				case NeoOpGreater: newOp = NeoOpGreaterC
 				case NeoOpLess: newOp = NeoOpLessC
 				}
@@ -701,7 +2038,7 @@ func (c *NeoCompiler) emit(op NeoOpCode, arg int32) int {
 	}
 
 	// Final check for ADD following CONCAT
-	if op == NeoOpAdd && n > 0 && c.instructions[n-1].Op == NeoOpConcat {
+	if op == NeoOpAdd && !c.noConcatFuse && n-1 >= c.fuseFloor && c.instructions[n-1].Op == NeoOpConcat {
 		c.instructions[n-1].Arg++
 		return n - 1
 	}
@@ -717,6 +2054,7 @@ func opToString(op NeoOpCode) string {
 	case NeoOpMul: return "*"
 	case NeoOpDiv: return "/"
 	case NeoOpEqual: return "=="
+	case NeoOpNotEqual: return "!="
 	case NeoOpGreater: return ">"
 	case NeoOpLess: return "<"
 	case NeoOpGreaterEqual: return ">="
@@ -728,25 +2066,30 @@ func opToString(op NeoOpCode) string {
 
 func (c *NeoCompiler) emitPush(v Value) int { return c.emit(NeoOpPush, c.addConstant(v)) }
 
-func (c *NeoCompiler) patch(pos int, arg int32) { c.instructions[pos].Arg = arg }
-
-func neoIsZero(v Value) bool {
-	switch v.Type {
-	case ValInt: return v.Num == 0
-	case ValFloat: return math.Float64frombits(v.Num) == 0
-	}
-	return false
+// patch 回填跳转目标并将其记录为融合下界。discard 模式下 emit 返回 -1，此时无需回填。
+func (c *NeoCompiler) patch(pos int, arg int32) {
+	if pos < 0 { return }
+	c.instructions[pos].Arg = arg
+	if int(arg) > c.fuseFloor { c.fuseFloor = int(arg) }
 }
 
-func neoIsOne(v Value) bool {
-	switch v.Type {
-	case ValInt: return v.Num == 1
-	case ValFloat: return math.Float64frombits(v.Num) == 1
-	}
-	return false
+// neoIsZero 与 neoIsOne 只匹配整数常量：`x * 1.0` 会把整数 x 转为浮点数，不能化简为 x
+func neoIsZero(v Value) bool { return v.Type == ValInt && v.Num == 0 }
+
+func neoIsOne(v Value) bool { return v.Type == ValInt && v.Num == 1 }
+
+// neoIsZeroDivisor 判断常量是否为整数、浮点数或十进制数 0，作除数时留待运行期报告除零
+func neoIsZeroDivisor(v Value) bool {
+	if v.Type == ValDecimal { return v.Obj.(Decimal).Sign() == 0 }
+	return neoIsZero(v) || (v.Type == ValFloat && math.Float64frombits(v.Num) == 0)
 }
 
 func (c *NeoCompiler) addConstant(v Value) int32 {
+	if v.Obj != nil {
+		// 容器常量按引用区分，不参与去重
+		c.constants = append(c.constants, v)
+		return int32(len(c.constants) - 1)
+	}
 	// Optimization: check first few constants linearly to avoid map overhead for tiny expressions
 	n := len(c.constants)
 	if n > 0 {
@@ -807,12 +2150,20 @@ func (c *NeoCompiler) peephole() {
 		oldToNew = make([]int, 0, len(c.instructions)+1)
 	}
 
+	targets := make([]bool, len(c.instructions)+1)
+	for _, inst := range c.instructions {
+		switch inst.Op {
+		case NeoOpJump, NeoOpJumpIfFalse, NeoOpJumpIfTrue, NeoOpJumpIfNotMap, NeoOpJumpIfFalseOrPop, NeoOpJumpIfTrueOrPop, NeoOpTry, NeoOpEndTry, NeoOpIterNext:
+			targets[inst.Arg] = true
+		}
+	}
+
 	for i := 0; i < len(c.instructions); i++ {
 		oldToNew = append(oldToNew, len(newInsts))
 		inst := c.instructions[i]
 
-		// Catch remaining jump fusions (FCG, GGJ)
-		if i+1 < len(c.instructions) {
+		// Catch remaining jump fusions (FCG, GGJ); a jump landing on the jump itself must keep it unfused
+		if i+1 < len(c.instructions) && fusible(targets, i, 1) {
 			next := c.instructions[i+1]
 			if next.Op == NeoOpJumpIfFalse {
 				jTarget := next.Arg
@@ -855,7 +2206,7 @@ func (c *NeoCompiler) peephole() {
 	// Update jump targets
 	for i := range newInsts {
 		switch newInsts[i].Op {
-		case NeoOpJump, NeoOpJumpIfFalse, NeoOpJumpIfTrue:
+		case NeoOpJump, NeoOpJumpIfFalse, NeoOpJumpIfTrue, NeoOpJumpIfNotMap, NeoOpJumpIfFalseOrPop, NeoOpJumpIfTrueOrPop, NeoOpTry, NeoOpEndTry, NeoOpIterNext:
 			newInsts[i].Arg = int32(oldToNew[newInsts[i].Arg])
 		case NeoOpFusedCompareGlobalConstJumpIfFalse, NeoOpFusedGreaterGlobalConstJumpIfFalse, NeoOpFusedLessGlobalConstJumpIfFalse:
 			gIdx := (newInsts[i].Arg >> 22) & 0x3FF; cIdx := (newInsts[i].Arg >> 12) & 0x3FF; jTarget := newInsts[i].Arg & 0xFFF
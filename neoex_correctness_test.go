@@ -3,10 +3,7 @@
 
 package uwasa
 
-import (
-	"math"
-	"testing"
-)
+import "testing"
 
 func TestNeoExVM_Correctness(t *testing.T) {
 	tests := []struct {
@@ -34,7 +31,6 @@ func TestNeoExVM_Correctness(t *testing.T) {
 		{"Complex", "(a + b) * (c - d) / e", map[string]any{"a": 10, "b": 20, "c": 30, "d": 10, "e": 2}, int64(300)},
 		{"Const Global Sub", "100 - a", map[string]any{"a": int64(30)}, int64(70)},
 		{"Const Global Div", "100 / a", map[string]any{"a": int64(2)}, int64(50)},
-		{"Infinity Div (Var)", "1 / a", map[string]any{"a": 0}, math.Inf(1)},
 	}
 
 	for _, tt := range tests {
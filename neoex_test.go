@@ -4,6 +4,9 @@
 package uwasa
 
 import (
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -72,6 +75,23 @@ func TestNeoExVM_ShortCircuit(t *testing.T) {
 	if vars2["a"] != int64(0) {
 		t.Errorf("Short-circuit || side effect failed: expected 0, got %v", vars2["a"])
 	}
+
+	// The right operand is coerced with a single TOBOOL instead of NOT NOT
+	engine3, _ := NewEngineVMNeo("a > 1 && b")
+	for _, inst := range engine3.neoBytecode.Instructions {
+		if inst.Op == NeoOpNot {
+			t.Fatalf("unexpected %s in && coercion", inst.Op)
+		}
+	}
+	for _, tt := range []struct {
+		b        any
+		expected bool
+	}{{"x", true}, {false, false}, {int64(0), true}, {nil, false}} {
+		got, _ := engine3.Execute(map[string]any{"a": int64(2), "b": tt.b})
+		if got != tt.expected {
+			t.Errorf("b=%v: expected %v, got %v", tt.b, tt.expected, got)
+		}
+	}
 }
 
 func TestNeoExVMStackOverflow(t *testing.T) {
@@ -85,7 +105,7 @@ func TestNeoExVMStackOverflow(t *testing.T) {
 		t.Fatalf("NewEngineVMNeo failed: %v", err)
 	}
 	_, err = engine.Execute(map[string]any{"a": 1})
-	if err == nil || err.Error() != "NeoVM stack overflow" {
+	if err == nil || err.Error() != "NeoVM stack overflow [GETG at pc 64, variable a]" {
 		t.Errorf("Expected stack overflow error, got: %v", err)
 	}
 }
@@ -135,3 +155,313 @@ func TestNeoExVM_ConstantFold(t *testing.T) {
 		t.Errorf("Expected foobarbaz, got %s", res)
 	}
 }
+
+func TestNeoExVM_CallFold(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Value
+	}{
+		{`len("héllo")`, Value{Type: ValInt, Num: 5}},
+		{`len(concat("ab", 1)) > 2`, Value{Type: ValBool, Num: 1}},
+		{`"ab" |> concat("c") |> len`, Value{Type: ValInt, Num: 3}},
+		{`concat("v", 1 + 1) + "!"`, Value{Type: ValString, Str: "v2!"}},
+	}
+	for _, tt := range tests {
+		c := NewNeoCompiler(tt.input)
+		bc, err := c.Compile()
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", tt.input, err)
+		}
+		if len(bc.Instructions) != 2 { // Push, Return
+			t.Errorf("%s: expected 2 instructions, got %d", tt.input, len(bc.Instructions))
+			continue
+		}
+		if v := bc.Constants[bc.Instructions[0].Arg]; v.Type != tt.expected.Type || v.Num != tt.expected.Num || v.Str != tt.expected.Str {
+			t.Errorf("%s: expected folded %v, got %v", tt.input, tt.expected, v)
+		}
+	}
+
+	// 出错的调用留待运行期报错；变量实参与非纯函数不折叠
+	for _, input := range []string{`len(1)`, `a + len(b)`, `filter("a", 1)`} {
+		c := NewNeoCompiler(input)
+		bc, err := c.Compile()
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", input, err)
+		}
+		if !slices.ContainsFunc(bc.Instructions, func(inst neoInstruction) bool { return inst.Op == NeoOpCall }) {
+			t.Errorf("%s: expected the call to remain", input)
+		}
+	}
+}
+
+func TestNeoExVM_InFold(t *testing.T) {
+	for _, input := range []string{`"b" in ["a", "b"]`, `"a" in {"a": 1}`, `"ell" in "hello"`} {
+		c := NewNeoCompiler(input)
+		bc, err := c.Compile()
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", input, err)
+		}
+		if len(bc.Instructions) != 2 { // Push, Return
+			t.Errorf("%s: expected 2 instructions, got %d", input, len(bc.Instructions))
+			continue
+		}
+		if v := bc.Constants[bc.Instructions[0].Arg]; v.Type != ValBool || v.Num != 1 {
+			t.Errorf("%s: expected folded true, got %v", input, v)
+		}
+	}
+}
+
+func TestNeoExVM_BitwiseFold(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`12 & 10`, 8},
+		{`12 | 3`, 15},
+		{`12 ^ 4`, 8},
+		{`1 << 10`, 1024},
+		{`1024 >> 3 & 255`, 128},
+	}
+	for _, tt := range tests {
+		c := NewNeoCompiler(tt.input)
+		bc, err := c.Compile()
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", tt.input, err)
+		}
+		if len(bc.Instructions) != 2 { // Push, Return
+			t.Errorf("%s: expected 2 instructions, got %d", tt.input, len(bc.Instructions))
+			continue
+		}
+		if v := bc.Constants[bc.Instructions[0].Arg]; v.Type != ValInt || int64(v.Num) != tt.expected {
+			t.Errorf("%s: expected folded %d, got %v", tt.input, tt.expected, v)
+		}
+	}
+}
+
+func TestNeoExVM_ElifAndMatchLikeElseIf(t *testing.T) {
+	// 编译器来自对象池，指令切片会被下一次编译复用，因此先复制
+	compile := func(input string) []neoInstruction {
+		c := NewNeoCompiler(input)
+		bc, err := c.Compile()
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", input, err)
+		}
+		return append([]neoInstruction(nil), bc.Instructions...)
+	}
+	elif := compile(`if a > 2 is "x" elif a > 1 is "y" elif b is "z" else is "w"`)
+	elseIf := compile(`if a > 2 is "x" else if a > 1 is "y" else if b is "z" else is "w"`)
+	if !reflect.DeepEqual(elif, elseIf) {
+		t.Errorf("elif should compile like else if:\n got %v\nwant %v", elif, elseIf)
+	}
+
+	match := compile(`match a { 1 => "x", 2 => "y", _ => "z" }`)
+	matchChain := compile(`if a == 1 is "x" else if a == 2 is "y" else is "z"`)
+	if !reflect.DeepEqual(match, matchChain) {
+		t.Errorf("match should compile like an else if chain:\n got %v\nwant %v", match, matchChain)
+	}
+}
+
+func TestNeoExVM_NotEqualFusion(t *testing.T) {
+	tests := []struct {
+		input string
+		op    NeoOpCode
+	}{
+		{`a != 1`, NeoOpNotEqualGlobalConst},
+		{`1 != a`, NeoOpNotEqualGlobalConst},
+		{`(a + b) != 1`, NeoOpNotEqualC},
+		{`a != b`, NeoOpNotEqual},
+	}
+	for _, tt := range tests {
+		c := NewNeoCompiler(tt.input)
+		bc, err := c.Compile()
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", tt.input, err)
+		}
+		if op := bc.Instructions[len(bc.Instructions)-2].Op; op != tt.op {
+			t.Errorf("%s: expected %v before RET, got %v", tt.input, tt.op, op)
+		}
+	}
+}
+
+func TestNeoExVM_MapOps(t *testing.T) {
+	input := `m.set("a", m.get("b")).has("a") && !m.del("b").has("b") && m.get("c", 7) == 7`
+	c := NewNeoCompiler(input)
+	bc, err := c.Compile()
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	ops := map[NeoOpCode]int{}
+	for _, inst := range bc.Instructions {
+		ops[inst.Op]++
+	}
+	for _, op := range []NeoOpCode{NeoOpMapGet, NeoOpMapSet, NeoOpMapHas, NeoOpMapDel} {
+		if ops[op] == 0 {
+			t.Errorf("expected %s in %v", op, bc.Instructions)
+		}
+	}
+	// get with a default has no dedicated opcode
+	if ops[NeoOpCallMethod] != 1 {
+		t.Errorf("expected exactly one CALLM, got %d", ops[NeoOpCallMethod])
+	}
+
+	// Both the map fast path and the generic Context path
+	m1 := map[string]any{"b": int64(2)}
+	res, err := RunNeoVMWithMap(bc, map[string]any{"m": m1})
+	if err != nil || res != true {
+		t.Errorf("mapped: expected true, got %v (err: %v)", res, err)
+	}
+	m2 := map[string]any{"b": int64(2)}
+	res, err = RunNeoVM(bc, NewMapContext(map[string]any{"m": m2}))
+	if err != nil || res != true {
+		t.Errorf("general: expected true, got %v (err: %v)", res, err)
+	}
+	for _, m := range []map[string]any{m1, m2} {
+		if len(m) != 1 || m["a"] != int64(2) {
+			t.Errorf("expected map {a: 2}, got %v", m)
+		}
+	}
+
+	engine, err := NewEngineVMNeo(`m.get(1)`)
+	if err != nil {
+		t.Fatalf("NewEngineVMNeo failed: %v", err)
+	}
+	if _, err := engine.Execute(map[string]any{"m": map[string]any{}}); err == nil || err.Error() != "map key must be a string, got int64 [MGET at pc 2, operands map, int]" {
+		t.Errorf("expected key type error, got %v", err)
+	}
+	if _, err := engine.Execute(map[string]any{"m": "x"}); err == nil || err.Error() != "method get not supported on string [MGET at pc 2, operands string, int]" {
+		t.Errorf("expected receiver type error, got %v", err)
+	}
+}
+
+// 常量边界编译为一条 SLICE；边界为变量时仍调用内置函数
+func TestNeoExVM_Slice(t *testing.T) {
+	neo, _ := NewEngineVMNeo(`a |> slice(0 - 2, 3)`)
+	if ops := neo.neoBytecode.Instructions; len(ops) != 3 || ops[1].Op != NeoOpSlice {
+		t.Errorf("expected GETG; SLICE; RET, got %v", ops)
+	}
+	if neo, _ = NewEngineVMNeo(`slice(a, 0, n)`); slices.ContainsFunc(neo.neoBytecode.Instructions, func(inst neoInstruction) bool { return inst.Op == NeoOpSlice }) {
+		t.Errorf("expected a CALL for a variable bound, got %v", neo.neoBytecode.Instructions)
+	}
+}
+
+// lambda 字面量与推导式内联为循环，不再创建闭包并调用内置函数
+func TestNeoExVM_InlineIteration(t *testing.T) {
+	for name, src := range map[string]string{"map": `map(items, x -> x + a)`, "reduce": `items |> reduce((s, x) -> s + x, 0)`} {
+		neo, _ := NewEngineVMNeo(src)
+		if !slices.ContainsFunc(neo.neoBytecode.Instructions, func(inst neoInstruction) bool { return inst.Op == NeoOpIter }) ||
+			slices.ContainsFunc(neo.neoBytecode.Instructions, func(inst neoInstruction) bool { return inst.Op == NeoOpMakeClosure }) {
+			t.Errorf("%s: expected an inline loop, got %v", name, neo.neoBytecode.Instructions)
+		}
+	}
+	neo, _ := NewEngineVMNeo(`[x * 2 for x in items if x > 1]`)
+	if slices.ContainsFunc(neo.neoBytecode.Instructions, func(inst neoInstruction) bool { return inst.Op == NeoOpMakeClosure || inst.Op == NeoOpCall }) {
+		t.Errorf("comprehension: expected inline loops, got %v", neo.neoBytecode.Instructions)
+	}
+}
+
+// 十进制数常量之间的运算折叠为一个常量
+func TestNeoExVM_DecimalFold(t *testing.T) {
+	neo, _ := NewEngineVMNeoWithOptions(`price * (1d - 0.15d)`, EngineOptions{Decimal: true})
+	if ops := neo.neoBytecode.Instructions; len(ops) != 2 || ops[0].Op != NeoOpMulGC {
+		t.Errorf("expected MULGC; RET, got %v", ops)
+	} else if c := neo.neoBytecode.Constants[ops[0].Arg&0xFFFF]; c.Type != ValDecimal || c.Obj.(Decimal).String() != "0.85" {
+		t.Errorf("expected the constant 0.85, got %v", c)
+	}
+}
+
+// 十进制数除以 0 与其他后端一样报告除零：常量 0d 不融合进 DIVC，运行期算出的 0 同样出错，try 能接住
+func TestNeoExVM_DecimalDivisionByZero(t *testing.T) {
+	opts, vars := EngineOptions{Decimal: true}, map[string]any{"d": NewDecimal(1, 0)}
+	for _, in := range []string{`10d / 0d`, `decimal("10") / 0d`, `10 / 0d`, `10d / (1d - 1d)`, `d / (d - d)`, `10d / (d - 1d)`} {
+		neo, err := NewEngineVMNeoWithOptions(in, opts)
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", in, err)
+		}
+		if got, err := neo.Execute(vars); err == nil || !strings.Contains(err.Error(), "division by zero") {
+			t.Errorf("%s: expected division by zero, got %v, %v", in, got, err)
+		}
+	}
+	neo, _ := NewEngineVMNeoWithOptions(`10d / 0d`, opts)
+	for _, inst := range neo.neoBytecode.Instructions {
+		if inst.Op == NeoOpDivC {
+			t.Errorf("expected a plain DIV for a 0d divisor, got %v", neo.neoBytecode.Instructions)
+		}
+	}
+	neo, _ = NewEngineVMNeoWithOptions(`try(10d / 0d, 1) + try(10d / (d - 1d), 2)`, opts)
+	if got, err := neo.Execute(vars); err != nil || got != int64(3) {
+		t.Errorf("expected try to recover from decimal division by zero, got %v, %v", got, err)
+	}
+}
+
+// 常量区间上的 in 编译为一条 INRANGE，不构造区间
+func TestNeoExVM_InRange(t *testing.T) {
+	neo, _ := NewEngineVMNeo(`x in 1..100`)
+	if ops := neo.neoBytecode.Instructions; len(ops) != 3 || ops[1].Op != NeoOpInRange {
+		t.Errorf("expected GETG; INRANGE; RET, got %v", ops)
+	}
+}
+
+// 数组字面量相加在编译期合并，展开逐段收集
+func TestNeoExVM_ArrayLiterals(t *testing.T) {
+	neo, _ := NewEngineVMNeo(`[x, 1] + [2]`)
+	if ops := neo.neoBytecode.Instructions; len(ops) != 5 || ops[3].Op != NeoOpMakeArray || ops[3].Arg != 3 {
+		t.Errorf("expected GETG; PUSH; PUSH; MKARR 3; RET, got %v", ops)
+	}
+	neo, _ = NewEngineVMNeo(`[...a, 4]`)
+	if ops := neo.neoBytecode.Instructions; len(ops) != 7 || ops[2].Op != NeoOpSpread || ops[5].Op != NeoOpSpread {
+		t.Errorf("expected MKARR 0; GETG; SPREAD; PUSH; MKARR 1; SPREAD; RET, got %v", ops)
+	}
+}
+
+// 常量多边形、网段、glob 模式与集合在编译期构造，作为一个常量压栈
+func TestNeoExVM_PreparedConstants(t *testing.T) {
+	const square = `[[35.6, 139.6], [35.6, 139.9], [35.8, 139.9], [35.8, 139.6]]`
+	tests := []struct {
+		input string
+		at    int
+		check func(Value) bool
+	}{
+		{`inPolygon(lat, lon, ` + square + `)`, 2, func(v Value) bool { _, ok := v.Obj.(*geoPolygon); return ok }},
+		{`ipInCIDR(ip, "10.0.0.0/8")`, 1, func(v Value) bool { _, ok := v.Obj.(*cidrPrefix); return ok }},
+		{`name like "img_*.png"`, 1, func(v Value) bool { _, ok := v.Obj.(*globPattern); return ok }},
+		{`role in set("admin", "ops", "root")`, 1, func(v Value) bool { return v.Type == ValSet }},
+	}
+	for _, tt := range tests {
+		neo, _ := NewEngineVMNeo(tt.input)
+		ops := neo.neoBytecode.Instructions
+		if len(ops) != tt.at+3 || ops[tt.at].Op != NeoOpPush {
+			t.Errorf("%s: expected a PUSH of the prepared constant, got %v", tt.input, ops)
+		} else if c := neo.neoBytecode.Constants[ops[tt.at].Arg]; !tt.check(c) {
+			t.Errorf("%s: unexpected constant %v", tt.input, c)
+		}
+	}
+}
+
+// 单参数的转换编译为 CAST 指令，常量实参在编译期求值
+func TestNeoExVM_Cast(t *testing.T) {
+	neo, _ := NewEngineVMNeo(`str(i)`)
+	if ops := neo.neoBytecode.Instructions; len(ops) != 3 || ops[1].Op != NeoOpCast {
+		t.Errorf("expected GETG; CAST; RET, got %v", ops)
+	}
+	if e, _ := NewEngineVMNeo(`int("42") + 1`); e.constantResult != int64(43) {
+		t.Errorf("expected int(\"42\") + 1 to fold, got %v", e.constantResult)
+	}
+}
+
+// score 的常量条件计入初值，全部为常量时折叠
+func TestNeoExVM_Score(t *testing.T) {
+	neo, _ := NewEngineVMNeo(`score { true: 3, vip: 5 }`)
+	if ops := neo.neoBytecode.Instructions; len(ops) != 4 || ops[2].Op != NeoOpScore || neo.neoBytecode.Constants[ops[0].Arg] != (Value{Type: ValInt, Num: 3}) {
+		t.Errorf("expected PUSH 3; GETG; SCORE; RET, got %v", ops)
+	}
+	if e, _ := NewEngineVMNeo(`score { 2 > 1: 4, false: 1 }`); e.constantResult != int64(4) {
+		t.Errorf("expected constant score to fold, got %v", e.constantResult)
+	}
+}
+
+// 字符串字面量之间的比较在编译期折叠
+func TestNeoExVM_StringCompareFold(t *testing.T) {
+	if e, _ := NewEngineVMNeo(`"b" >= "c"`); e.constantResult != false || !e.isConstant {
+		t.Errorf("expected string comparison to fold, got %v", e.constantResult)
+	}
+}
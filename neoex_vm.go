@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"strings"
 	"sync"
 	"unsafe"
 )
@@ -20,12 +21,17 @@ var neoBufferPool = sync.Pool{
 func RunNeoVM[C Context](bc *NeoBytecode, ctx C) (any, error) {
 	if bc == nil || len(bc.Instructions) == 0 { return nil, nil }
 	if mctx, ok := any(ctx).(*MapContext); ok { return RunNeoVMWithMap(bc, mctx.vars) }
-	return runNeoVMGeneral(bc, ctx)
+	var stack [64]Value
+	return runNeoVMGeneral(bc, ctx, &stack, nil)
 }
 
 func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
-	if vars == nil { vars = make(map[string]any) }
 	var stack [64]Value
+	return runNeoVMMapped(bc, vars, &stack, nil)
+}
+
+func runNeoVMMapped(bc *NeoBytecode, vars map[string]any, stack *[64]Value, st *RunState) (any, error) {
+	if vars == nil { vars = make(map[string]any) }
 	insts := bc.Instructions
 	nInsts := len(insts)
 	if nInsts == 0 { return nil, nil }
@@ -33,8 +39,14 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 	pInsts := unsafe.SliceData(insts)
 	pConsts := unsafe.SliceData(bc.Constants)
 
-	sp := -1
+	sp := bc.Locals - 1 // 栈底 bc.Locals 个槽位留给 let 绑定
+	fp := 0             // 当前栈帧的起点，函数块中的 let 槽位与参数相对于它寻址
 	pc := 0
+	concat := bc.concat // 闭包入口块沿用调用方的计数
+	if concat == nil { concat = newConcatBudget(bc.MaxConcatBytes) }
+	functions := bc.Functions
+	var handlers []tryHandler // try 登记的错误处理现场，最内层在末尾
+	var fault error
 
 	const valSize = unsafe.Sizeof(Value{})
 	const instSize = unsafe.Sizeof(neoInstruction{})
@@ -45,12 +57,13 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 
 		switch inst.Op {
 		case NeoOpPush:
-			sp++; if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			stack[sp] = *(*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
 		case NeoOpPop: sp--
 		case NeoOpAdd:
 			r := stack[sp]; sp--; l := &stack[sp]
 			if l.Type == ValInt && r.Type == ValInt { l.Num += r.Num } else if l.Type == ValString && r.Type == ValString { l.Str += r.Str } else { *l = l.Add(r) }
+			if err := concat.chargeString(l); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpSub:
 			r := stack[sp]; sp--; l := &stack[sp]
 			if l.Type == ValInt && r.Type == ValInt { l.Num -= r.Num } else { *l = l.Sub(r) }
@@ -59,13 +72,16 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 			if l.Type == ValInt && r.Type == ValInt { l.Num *= r.Num } else { *l = l.Mul(r) }
 		case NeoOpDiv:
 			rv := stack[sp]; sp--; l := &stack[sp]
-			res, err := l.DivErr(rv); if err != nil { return nil, err }; *l = res
+			res, err := l.DivErr(rv); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; *l = res
 		case NeoOpMod:
 			rv := stack[sp]; sp--; l := &stack[sp]
-			res, err := l.ModErr(rv); if err != nil { return nil, err }; *l = res
+			res, err := l.ModErr(rv); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; *l = res
 		case NeoOpEqual:
 			rv := stack[sp]; sp--; l := &stack[sp]
 			*l = Value{Type: ValBool, Num: boolToUint64(l.Equal(rv))}
+		case NeoOpNotEqual:
+			rv := stack[sp]; sp--; l := &stack[sp]
+			*l = Value{Type: ValBool, Num: boolToUint64(!l.Equal(rv))}
 		case NeoOpGreater:
 			rv := stack[sp]; sp--; l := &stack[sp]
 			*l = Value{Type: ValBool, Num: boolToUint64(l.Greater(rv))}
@@ -87,6 +103,136 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 		case NeoOpNot:
 			l := &stack[sp]
 			*l = Value{Type: ValBool, Num: boolToUint64(!isValTruthy(*l))}
+		case NeoOpToBool:
+			l := &stack[sp]
+			*l = Value{Type: ValBool, Num: boolToUint64(isValTruthy(*l))}
+		case NeoOpMakeArray:
+			n := int(inst.Arg)
+			arr := makeArray(stack[sp-n+1 : sp+1])
+			sp -= n - 1; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = arr
+		case NeoOpIndex:
+			idx := stack[sp]; sp--
+			v, err := stack[sp].Index(idx); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpJumpIfNotMap:
+			if stack[sp].Type != ValMap { stack[sp] = Value{}; pc = int(inst.Arg) }
+		case NeoOpMapGetConst:
+			m, _ := stack[sp].Obj.(map[string]any)
+			stack[sp] = FromInterface(m[bc.Constants[inst.Arg].Str])
+		case NeoOpSetIndex:
+			val := stack[sp]; idx := stack[sp-1]; sp -= 2
+			if err := stack[sp].SetIndex(idx, val); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = val
+		case NeoOpMakeMap:
+			n := 2 * int(inst.Arg)
+			m, err := makeMap(stack[sp-n+1 : sp+1]); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			sp -= n - 1; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = m
+		case NeoOpCopyConst:
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = copyConst(*(*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize)))
+		case NeoOpCallMethod:
+			nameIdx := inst.Arg & 0xFFFF; numArgs := int(inst.Arg >> 16)
+			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(nameIdx)*valSize)).Str
+			var argsBuf [8]any
+			args := st.argScratch(argsBuf[:], numArgs)
+			for i := numArgs - 1; i >= 0; i-- {
+				args[i] = stack[sp].ToInterface(); sp--
+			}
+			res, err := CallMethodAny(stack[sp].ToInterface(), name, args); st.release(args); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = FromInterface(res)
+		case NeoOpEqualFold:
+			rv := stack[sp]; sp--; l := &stack[sp]
+			*l = Value{Type: ValBool, Num: boolToUint64(l.EqualFold(rv))}
+		case NeoOpIn:
+			r := stack[sp]; sp--
+			v, err := stack[sp].In(r); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpInRange:
+			v, err := inRange(stack[sp], bc.Constants[inst.Arg]); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpMakeRange:
+			r := stack[sp]; sp--
+			v, err := rangeValue(stack[sp], r); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpSpread:
+			r := stack[sp]; sp--
+			v, err := spreadInto(stack[sp], r); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpCast:
+			v, err := castValue(castKind(inst.Arg), stack[sp]); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpIter:
+			items, idx, err := iterStart(iterKind(inst.Arg), stack[sp]); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = items; sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = idx
+		case NeoOpIterNext:
+			el, ok := iterNext(stack[sp-2], &stack[sp-1])
+			if !ok { pc = int(inst.Arg); break }
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = el
+		case NeoOpAppend:
+			v := stack[sp]; sp--
+			stack[sp] = appendValue(stack[sp], v)
+		case NeoOpIterEnd:
+			stack[sp-2] = stack[sp]; sp -= 2
+		case NeoOpSlice:
+			v, err := sliceValue(stack[sp], inst.Arg); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpScore:
+			cond := stack[sp]; sp--
+			if isValTruthy(cond) { stack[sp] = stack[sp].Add(bc.Constants[inst.Arg]) }
+		case NeoOpDefined:
+			_, ok := vars[bc.Constants[inst.Arg].Str]; sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(ok)}
+		case NeoOpBitAnd, NeoOpBitOr, NeoOpBitXor, NeoOpShl, NeoOpShr:
+			r := stack[sp]; sp--
+			v, err := stack[sp].Bitwise(TokenBitAnd+TokenType(inst.Op-NeoOpBitAnd), r); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpGetLocal:
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = stack[fp+int(inst.Arg)]
+		case NeoOpSetLocal:
+			stack[fp+int(inst.Arg)] = stack[sp]; sp--
+		case NeoOpCallLocal:
+			fn := functions[inst.Arg]
+			nfp := sp - fn.Params + 1
+			if nfp+fn.Locals >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			sp = nfp + fn.Locals
+			stack[sp] = callFrame(bc, pc, fp)
+			bc, insts, pc, fp = fn, fn.Instructions, 0, nfp
+			nInsts, pInsts = len(insts), unsafe.SliceData(insts)
+		case NeoOpReturnLocal:
+			frame := stack[fp+bc.Locals]
+			stack[fp] = stack[sp]; sp = fp
+			bc, pc, fp = frame.Obj.(*NeoBytecode), int(frame.Num>>32), int(uint32(frame.Num))
+			insts = bc.Instructions; nInsts, pInsts = len(insts), unsafe.SliceData(insts)
+		case NeoOpMakeClosure:
+			// 捕获值是当前栈帧最低的槽位；闭包持有变量表本身而非可能被回收复用的上下文
+			k := int(inst.Arg >> 16)
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValFunc, Obj: newNeoClosure(functions, concat, int(inst.Arg&0xFFFF), append([]Value(nil), stack[fp:fp+k]...), &MapContext{vars: vars})}
+		case NeoOpMapGet:
+			key := stack[sp]; sp--
+			m, k, err := neoMapOperand(stack[sp], key, "get"); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = FromInterface(m[k])
+		case NeoOpMapHas:
+			key := stack[sp]; sp--
+			m, k, err := neoMapOperand(stack[sp], key, "has"); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			_, found := m[k]
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(found)}
+		case NeoOpMapSet:
+			val := stack[sp]; key := stack[sp-1]; sp -= 2
+			m, k, err := neoMapOperand(stack[sp], key, "set"); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			m[k] = val.ToInterface()
+		case NeoOpMapDel:
+			key := stack[sp]; sp--
+			m, k, err := neoMapOperand(stack[sp], key, "del"); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			delete(m, k)
 		case NeoOpJump: pc = int(inst.Arg)
 		case NeoOpJumpIfFalse:
 			l := stack[sp]; sp--
@@ -94,8 +240,12 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 		case NeoOpJumpIfTrue:
 			l := stack[sp]; sp--
 			if isValTruthy(l) { pc = int(inst.Arg) }
+		case NeoOpJumpIfFalseOrPop:
+			if isValTruthy(stack[sp]) { sp-- } else { pc = int(inst.Arg) }
+		case NeoOpJumpIfTrueOrPop:
+			if isValTruthy(stack[sp]) { pc = int(inst.Arg) } else { sp-- }
 		case NeoOpGetGlobal:
-			sp++; if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize)).Str
 			val := vars[name]
 			target := &stack[sp]
@@ -115,6 +265,10 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
 			l := &stack[sp]
 			*l = Value{Type: ValBool, Num: boolToUint64(l.Equal(*cv))}
+		case NeoOpNotEqualC:
+			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
+			l := &stack[sp]
+			*l = Value{Type: ValBool, Num: boolToUint64(!l.Equal(*cv))}
 		case NeoOpGreaterC:
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
 			l := &stack[sp]
@@ -130,16 +284,30 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 			val := vars[name]
 			res := false
 			switch v := val.(type) {
-			case int64: res = cv.Type == ValInt && v == int64(cv.Num)
-			case float64: res = cv.Type == ValFloat && v == math.Float64frombits(cv.Num)
+			case int64: if cv.Type == ValInt { res = v == int64(cv.Num) } else { res = EqualAny(val, cv.ToInterface()) }
+			case float64: if cv.Type == ValFloat { res = v == math.Float64frombits(cv.Num) } else { res = EqualAny(val, cv.ToInterface()) }
 			case string: res = cv.Type == ValString && v == cv.Str
 			default: res = EqualAny(val, cv.ToInterface())
 			}
-			sp++; if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(res)}
+		case NeoOpNotEqualGlobalConst:
+			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF
+			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
+			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
+			val := vars[name]
+			res := false
+			switch v := val.(type) {
+			case int64: if cv.Type == ValInt { res = v == int64(cv.Num) } else { res = EqualAny(val, cv.ToInterface()) }
+			case float64: if cv.Type == ValFloat { res = v == math.Float64frombits(cv.Num) } else { res = EqualAny(val, cv.ToInterface()) }
+			case string: res = cv.Type == ValString && v == cv.Str
+			default: res = EqualAny(val, cv.ToInterface())
+			}
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(!res)}
 		case NeoOpAddGlobal, NeoOpAddGC:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val := vars[name]
@@ -153,51 +321,53 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 				if cv.Type == ValString { *target = Value{Type: ValString, Str: v + cv.Str} } else { *target = AddAny(v, cv.ToInterface()) }
 			default: *target = AddAny(v, cv.ToInterface())
 			}
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpAddConstGlobal:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			stack[sp] = AddAny(cv.ToInterface(), vars[name])
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpSubGC:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			stack[sp] = SubAny(vars[name], cv.ToInterface())
 		case NeoOpMulGC:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			stack[sp] = MulAny(vars[name], cv.ToInterface())
 		case NeoOpDivGC:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
-			stack[sp] = DivAny(vars[name], cv.ToInterface())
+			res, err := DivAnyErr(vars[name], cv.ToInterface()); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; stack[sp] = res
 		case NeoOpSubCG:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			stack[sp] = SubAny(cv.ToInterface(), vars[name])
 		case NeoOpMulCG:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			stack[sp] = MulAny(cv.ToInterface(), vars[name])
 		case NeoOpDivCG:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
-			stack[sp] = DivAny(cv.ToInterface(), vars[name])
+			res, err := DivAnyErr(cv.ToInterface(), vars[name]); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; stack[sp] = res
 		case NeoOpGreaterGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val := vars[name]
@@ -212,7 +382,7 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(res)}
 		case NeoOpLessGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val := vars[name]
@@ -227,7 +397,7 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(res)}
 		case NeoOpAddGlobalGlobal:
 			g1Idx := inst.Arg >> 16; g2Idx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			n1 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g1Idx)*valSize)).Str
 			n2 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g2Idx)*valSize)).Str
 			v1 := vars[n1]; v2 := vars[n2]
@@ -235,9 +405,10 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 				if i2, ok2 := v2.(int64); ok2 { stack[sp] = Value{Type: ValInt, Num: uint64(i1 + i2)}; continue }
 			}
 			stack[sp] = AddAny(v1, v2)
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpSubGlobalGlobal:
 			g1Idx := inst.Arg >> 16; g2Idx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			n1 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g1Idx)*valSize)).Str
 			n2 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g2Idx)*valSize)).Str
 			v1 := vars[n1]; v2 := vars[n2]
@@ -247,7 +418,7 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 			stack[sp] = SubAny(v1, v2)
 		case NeoOpMulGlobalGlobal:
 			g1Idx := inst.Arg >> 16; g2Idx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			n1 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g1Idx)*valSize)).Str
 			n2 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g2Idx)*valSize)).Str
 			v1 := vars[n1]; v2 := vars[n2]
@@ -261,8 +432,8 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val := vars[name]; res := false
 			switch v := val.(type) {
-			case int64: res = cv.Type == ValInt && v == int64(cv.Num)
-			case float64: res = cv.Type == ValFloat && v == math.Float64frombits(cv.Num)
+			case int64: if cv.Type == ValInt { res = v == int64(cv.Num) } else { res = EqualAny(val, cv.ToInterface()) }
+			case float64: if cv.Type == ValFloat { res = v == math.Float64frombits(cv.Num) } else { res = EqualAny(val, cv.ToInterface()) }
 			case string: res = cv.Type == ValString && v == cv.Str
 			default: res = EqualAny(val, cv.ToInterface())
 			}
@@ -305,6 +476,7 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 			l := &stack[sp]
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
 			if l.Type == ValInt && cv.Type == ValInt { l.Num += cv.Num } else { *l = l.Add(*cv) }
+			if err := concat.chargeString(l); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpSubC:
 			l := &stack[sp]
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
@@ -316,7 +488,7 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 		case NeoOpDivC:
 			l := &stack[sp]
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
-			*l = l.Div(*cv)
+			res, err := l.DivErr(*cv); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; *l = res
 		case NeoOpAddInt:
 			r := stack[sp]; sp--; l := &stack[sp]
 			l.Num += r.Num
@@ -328,7 +500,7 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 			l.Num *= r.Num
 		case NeoOpConcat:
 			numArgs := int(inst.Arg); totalLen := 0; var argStringsBuf [8]string; var argStrings []string
-			if numArgs <= 8 { argStrings = argStringsBuf[:numArgs] } else { argStrings = make([]string, numArgs) }
+			argStrings = st.strScratch(argStringsBuf[:], numArgs)
 			for i := numArgs - 1; i >= 0; i-- {
 				v := stack[sp]; sp--
 				var s string
@@ -337,65 +509,80 @@ func RunNeoVMWithMap(bc *NeoBytecode, vars map[string]any) (any, error) {
 				case ValInt: s = fmt.Sprintf("%d", int64(v.Num))
 				case ValFloat: s = fmt.Sprintf("%g", math.Float64frombits(v.Num))
 				case ValBool: if v.Num != 0 { s = "true" } else { s = "false" }
-				default: s = fmt.Sprintf("%v", v.ToInterface())
+				default: s = formatAny(v.ToInterface())
 				}
 				argStrings[i] = s; totalLen += len(s)
 			}
-			buf := neoBufferPool.Get().(*bytes.Buffer); buf.Reset(); buf.Grow(totalLen)
-			for _, s := range argStrings { buf.WriteString(s) }
-			res := buf.String(); neoBufferPool.Put(buf)
-			sp++; if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if err := concat.charge(totalLen); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			res := st.join(&neoBufferPool, argStrings, totalLen)
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValString, Str: res}
 		case NeoOpConcat2:
 			r := stack[sp]; sp--; l := &stack[sp]
 			var s1, s2 string
-			if l.Type == ValString { s1 = l.Str } else { s1 = fmt.Sprintf("%v", l.ToInterface()) }
-			if r.Type == ValString { s2 = r.Str } else { s2 = fmt.Sprintf("%v", r.ToInterface()) }
+			if l.Type == ValString { s1 = l.Str } else { s1 = formatAny(l.ToInterface()) }
+			if r.Type == ValString { s2 = r.Str } else { s2 = formatAny(r.ToInterface()) }
+			if err := concat.charge(len(s1)+len(s2)); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 			*l = Value{Type: ValString, Str: s1 + s2}
 		case NeoOpConcatGC:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			lv := vars[name]; var s1, s2 string
-			if s, ok := lv.(string); ok { s1 = s } else { s1 = fmt.Sprintf("%v", lv) }
-			if cv.Type == ValString { s2 = cv.Str } else { s2 = fmt.Sprintf("%v", cv.ToInterface()) }
+			if s, ok := lv.(string); ok { s1 = s } else { s1 = formatAny(lv) }
+			if cv.Type == ValString { s2 = cv.Str } else { s2 = formatAny(cv.ToInterface()) }
+			if err := concat.charge(len(s1)+len(s2)); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 			stack[sp] = Value{Type: ValString, Str: s1 + s2}
 		case NeoOpConcatCG:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			rv := vars[name]; var s1, s2 string
-			if cv.Type == ValString { s1 = cv.Str } else { s1 = fmt.Sprintf("%v", cv.ToInterface()) }
-			if s, ok := rv.(string); ok { s2 = s } else { s2 = fmt.Sprintf("%v", rv) }
+			if cv.Type == ValString { s1 = cv.Str } else { s1 = formatAny(cv.ToInterface()) }
+			if s, ok := rv.(string); ok { s2 = s } else { s2 = formatAny(rv) }
+			if err := concat.charge(len(s1)+len(s2)); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 			stack[sp] = Value{Type: ValString, Str: s1 + s2}
 		case NeoOpCall:
 			nameIdx := inst.Arg & 0xFFFF; numArgs := int(inst.Arg >> 16)
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(nameIdx)*valSize)).Str
 			var argsBuf [8]any; var args []any
-			if numArgs <= 8 { args = argsBuf[:numArgs] } else { args = make([]any, numArgs) }
+			args = st.argScratch(argsBuf[:], numArgs)
 			for i := numArgs - 1; i >= 0; i-- {
 				args[i] = stack[sp].ToInterface(); sp--
 			}
-			if builtin, ok := builtins[name]; ok {
-				res, err := builtin(args...); if err != nil { return nil, err }
-				sp++; if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if builtin, ok := builtins.get(name); ok {
+				res, err := callBuiltin(name, builtin, args); st.release(args); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+				sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 				stack[sp] = FromInterface(res)
-			} else { return nil, fmt.Errorf("builtin function not found: %s", name) }
+			} else { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("builtin function not found: %s", name)); goto unwind }
 		case NeoOpReturn:
+			if bc.ResultCount > 1 { return collectTuple(stack[:sp+1], bc.ResultCount), nil }
 			if sp < 0 { return nil, nil }
 			return stack[sp].ToInterface(), nil
+		case NeoOpTry:
+			handlers = append(handlers, tryHandler{bc: bc, pc: int(inst.Arg), sp: sp, fp: fp})
+		case NeoOpEndTry:
+			handlers = handlers[:len(handlers)-1]
+			pc = int(inst.Arg)
 		default:
-			return nil, fmt.Errorf("unsupported NeoVM opcode: %v", inst.Op)
+			fault = bc.fault(pc-1, stack, sp, fmt.Errorf("unsupported NeoVM opcode: %v", inst.Op))
+			goto unwind
 		}
+		continue
+	unwind:
+		// 出错时回到最内层 try 的现场求备用值，不在 try 中时返回错误
+		if len(handlers) == 0 { return nil, fault }
+		h := handlers[len(handlers)-1]; handlers = handlers[:len(handlers)-1]
+		bc, pc, sp, fp = h.bc.(*NeoBytecode), h.pc, h.sp, h.fp
+		insts = bc.Instructions; nInsts, pInsts = len(insts), unsafe.SliceData(insts)
 	}
 	if sp < 0 { return nil, nil }
 	return stack[sp].ToInterface(), nil
 }
 
-func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
-	var stack [64]Value
+func runNeoVMGeneral(bc *NeoBytecode, ctx Context, stack *[64]Value, st *RunState) (any, error) {
 	insts := bc.Instructions
 	nInsts := len(insts)
 	if nInsts == 0 { return nil, nil }
@@ -403,8 +590,14 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 	pInsts := unsafe.SliceData(insts)
 	pConsts := unsafe.SliceData(bc.Constants)
 	
-	sp := -1
+	sp := bc.Locals - 1 // 栈底 bc.Locals 个槽位留给 let 绑定
+	fp := 0             // 当前栈帧的起点，函数块中的 let 槽位与参数相对于它寻址
 	pc := 0
+	concat := bc.concat // 闭包入口块沿用调用方的计数
+	if concat == nil { concat = newConcatBudget(bc.MaxConcatBytes) }
+	functions := bc.Functions
+	var handlers []tryHandler // try 登记的错误处理现场，最内层在末尾
+	var fault error
 	
 	const valSize = unsafe.Sizeof(Value{})
 	const instSize = unsafe.Sizeof(neoInstruction{})
@@ -416,12 +609,13 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 		switch inst.Op {
 		case NeoOpPush:
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			stack[sp] = *(*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
 		case NeoOpPop: sp--
 		case NeoOpAdd:
 			r := stack[sp]; sp--; l := &stack[sp]
 			*l = l.Add(r)
+			if err := concat.chargeString(l); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpSub:
 			r := stack[sp]; sp--; l := &stack[sp]
 			*l = l.Sub(r)
@@ -430,13 +624,16 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 			*l = l.Mul(r)
 		case NeoOpDiv:
 			rv := stack[sp]; sp--; l := &stack[sp]
-			*l = l.Div(rv)
+			res, err := l.DivErr(rv); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; *l = res
 		case NeoOpMod:
 			rv := stack[sp]; sp--; l := &stack[sp]
-			res, err := l.ModErr(rv); if err != nil { return nil, err }; *l = res
+			res, err := l.ModErr(rv); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; *l = res
 		case NeoOpEqual:
 			rv := stack[sp]; sp--; l := &stack[sp]
 			*l = Value{Type: ValBool, Num: boolToUint64(l.Equal(rv))}
+		case NeoOpNotEqual:
+			rv := stack[sp]; sp--; l := &stack[sp]
+			*l = Value{Type: ValBool, Num: boolToUint64(!l.Equal(rv))}
 		case NeoOpGreater:
 			rv := stack[sp]; sp--; l := &stack[sp]
 			*l = Value{Type: ValBool, Num: boolToUint64(l.Greater(rv))}
@@ -458,6 +655,135 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 		case NeoOpNot:
 			l := &stack[sp]
 			*l = Value{Type: ValBool, Num: boolToUint64(!isValTruthy(*l))}
+		case NeoOpToBool:
+			l := &stack[sp]
+			*l = Value{Type: ValBool, Num: boolToUint64(isValTruthy(*l))}
+		case NeoOpMakeArray:
+			n := int(inst.Arg)
+			arr := makeArray(stack[sp-n+1 : sp+1])
+			sp -= n - 1; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = arr
+		case NeoOpIndex:
+			idx := stack[sp]; sp--
+			v, err := stack[sp].Index(idx); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpJumpIfNotMap:
+			if stack[sp].Type != ValMap { stack[sp] = Value{}; pc = int(inst.Arg) }
+		case NeoOpMapGetConst:
+			m, _ := stack[sp].Obj.(map[string]any)
+			stack[sp] = FromInterface(m[bc.Constants[inst.Arg].Str])
+		case NeoOpSetIndex:
+			val := stack[sp]; idx := stack[sp-1]; sp -= 2
+			if err := stack[sp].SetIndex(idx, val); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = val
+		case NeoOpMakeMap:
+			n := 2 * int(inst.Arg)
+			m, err := makeMap(stack[sp-n+1 : sp+1]); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			sp -= n - 1; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = m
+		case NeoOpCopyConst:
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = copyConst(*(*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize)))
+		case NeoOpCallMethod:
+			nameIdx := inst.Arg & 0xFFFF; numArgs := int(inst.Arg >> 16)
+			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(nameIdx)*valSize)).Str
+			var argsBuf [8]any
+			args := st.argScratch(argsBuf[:], numArgs)
+			for i := numArgs - 1; i >= 0; i-- {
+				args[i] = stack[sp].ToInterface(); sp--
+			}
+			res, err := CallMethodAny(stack[sp].ToInterface(), name, args); st.release(args); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = FromInterface(res)
+		case NeoOpEqualFold:
+			rv := stack[sp]; sp--; l := &stack[sp]
+			*l = Value{Type: ValBool, Num: boolToUint64(l.EqualFold(rv))}
+		case NeoOpIn:
+			r := stack[sp]; sp--
+			v, err := stack[sp].In(r); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpInRange:
+			v, err := inRange(stack[sp], bc.Constants[inst.Arg]); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpMakeRange:
+			r := stack[sp]; sp--
+			v, err := rangeValue(stack[sp], r); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpSpread:
+			r := stack[sp]; sp--
+			v, err := spreadInto(stack[sp], r); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpCast:
+			v, err := castValue(castKind(inst.Arg), stack[sp]); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpIter:
+			items, idx, err := iterStart(iterKind(inst.Arg), stack[sp]); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = items; sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = idx
+		case NeoOpIterNext:
+			el, ok := iterNext(stack[sp-2], &stack[sp-1])
+			if !ok { pc = int(inst.Arg); break }
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = el
+		case NeoOpAppend:
+			v := stack[sp]; sp--
+			stack[sp] = appendValue(stack[sp], v)
+		case NeoOpIterEnd:
+			stack[sp-2] = stack[sp]; sp -= 2
+		case NeoOpSlice:
+			v, err := sliceValue(stack[sp], inst.Arg); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpScore:
+			cond := stack[sp]; sp--
+			if isValTruthy(cond) { stack[sp] = stack[sp].Add(bc.Constants[inst.Arg]) }
+		case NeoOpDefined:
+			_, ok := ctx.Get(bc.Constants[inst.Arg].Str); sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(ok)}
+		case NeoOpBitAnd, NeoOpBitOr, NeoOpBitXor, NeoOpShl, NeoOpShr:
+			r := stack[sp]; sp--
+			v, err := stack[sp].Bitwise(TokenBitAnd+TokenType(inst.Op-NeoOpBitAnd), r); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case NeoOpGetLocal:
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = stack[fp+int(inst.Arg)]
+		case NeoOpSetLocal:
+			stack[fp+int(inst.Arg)] = stack[sp]; sp--
+		case NeoOpCallLocal:
+			fn := functions[inst.Arg]
+			nfp := sp - fn.Params + 1
+			if nfp+fn.Locals >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			sp = nfp + fn.Locals
+			stack[sp] = callFrame(bc, pc, fp)
+			bc, insts, pc, fp = fn, fn.Instructions, 0, nfp
+			nInsts, pInsts = len(insts), unsafe.SliceData(insts)
+		case NeoOpReturnLocal:
+			frame := stack[fp+bc.Locals]
+			stack[fp] = stack[sp]; sp = fp
+			bc, pc, fp = frame.Obj.(*NeoBytecode), int(frame.Num>>32), int(uint32(frame.Num))
+			insts = bc.Instructions; nInsts, pInsts = len(insts), unsafe.SliceData(insts)
+		case NeoOpMakeClosure:
+			k := int(inst.Arg >> 16)
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValFunc, Obj: newNeoClosure(functions, concat, int(inst.Arg&0xFFFF), append([]Value(nil), stack[fp:fp+k]...), ctx)}
+		case NeoOpMapGet:
+			key := stack[sp]; sp--
+			m, k, err := neoMapOperand(stack[sp], key, "get"); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = FromInterface(m[k])
+		case NeoOpMapHas:
+			key := stack[sp]; sp--
+			m, k, err := neoMapOperand(stack[sp], key, "has"); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			_, found := m[k]
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(found)}
+		case NeoOpMapSet:
+			val := stack[sp]; key := stack[sp-1]; sp -= 2
+			m, k, err := neoMapOperand(stack[sp], key, "set"); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			m[k] = val.ToInterface()
+		case NeoOpMapDel:
+			key := stack[sp]; sp--
+			m, k, err := neoMapOperand(stack[sp], key, "del"); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			delete(m, k)
 		case NeoOpJump: pc = int(inst.Arg)
 		case NeoOpJumpIfFalse:
 			l := stack[sp]; sp--
@@ -465,21 +791,30 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 		case NeoOpJumpIfTrue:
 			l := stack[sp]; sp--
 			if isValTruthy(l) { pc = int(inst.Arg) }
+		case NeoOpJumpIfFalseOrPop:
+			if isValTruthy(stack[sp]) { sp-- } else { pc = int(inst.Arg) }
+		case NeoOpJumpIfTrueOrPop:
+			if isValTruthy(stack[sp]) { pc = int(inst.Arg) } else { sp-- }
 		case NeoOpGetGlobal:
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize)).Str
 			val, _ := ctx.Get(name); sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			stack[sp] = FromInterface(val)
 		case NeoOpSetGlobal:
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize)).Str
-			ctx.Set(name, stack[sp].ToInterface())
+			if err := setGlobal(ctx, bc.Name, pc-1, name, stack[sp].ToInterface()); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpReturn:
+			if bc.ResultCount > 1 { return collectTuple(stack[:sp+1], bc.ResultCount), nil }
 			if sp < 0 { return nil, nil }
 			return stack[sp].ToInterface(), nil
 		case NeoOpEqualConst, NeoOpEqualC:
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
 			l := &stack[sp]
 			*l = Value{Type: ValBool, Num: boolToUint64(l.Equal(*cv))}
+		case NeoOpNotEqualC:
+			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
+			l := &stack[sp]
+			*l = Value{Type: ValBool, Num: boolToUint64(!l.Equal(*cv))}
 		case NeoOpGreaterC:
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
 			l := &stack[sp]
@@ -493,95 +828,105 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
-			sp++; if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(EqualAny(val, cv.ToInterface()))}
+		case NeoOpNotEqualGlobalConst:
+			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF
+			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
+			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
+			val, _ := ctx.Get(name)
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(!EqualAny(val, cv.ToInterface()))}
 		case NeoOpAddGlobal, NeoOpAddGC:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
 			stack[sp] = AddAny(val, cv.ToInterface())
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpAddConstGlobal:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
 			stack[sp] = AddAny(cv.ToInterface(), val)
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpSubGC:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
 			stack[sp] = SubAny(val, cv.ToInterface())
 		case NeoOpMulGC:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
 			stack[sp] = MulAny(val, cv.ToInterface())
 		case NeoOpDivGC:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
-			stack[sp] = DivAny(val, cv.ToInterface())
+			res, err := DivAnyErr(val, cv.ToInterface()); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; stack[sp] = res
 		case NeoOpSubCG:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
 			stack[sp] = SubAny(cv.ToInterface(), val)
 		case NeoOpMulCG:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
 			stack[sp] = MulAny(cv.ToInterface(), val)
 		case NeoOpDivCG:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
-			stack[sp] = DivAny(cv.ToInterface(), val)
+			res, err := DivAnyErr(cv.ToInterface(), val); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; stack[sp] = res
 		case NeoOpGreaterGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(GreaterAny(val, cv.ToInterface()))}
 		case NeoOpLessGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
 			val, _ := ctx.Get(name)
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(LessAny(val, cv.ToInterface()))}
 		case NeoOpAddGlobalGlobal:
 			g1Idx := inst.Arg >> 16; g2Idx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			n1 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g1Idx)*valSize)).Str
 			n2 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g2Idx)*valSize)).Str
 			v1, _ := ctx.Get(n1); v2, _ := ctx.Get(n2)
 			stack[sp] = AddAny(v1, v2)
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpSubGlobalGlobal:
 			g1Idx := inst.Arg >> 16; g2Idx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			n1 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g1Idx)*valSize)).Str
 			n2 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g2Idx)*valSize)).Str
 			v1, _ := ctx.Get(n1); v2, _ := ctx.Get(n2)
 			stack[sp] = SubAny(v1, v2)
 		case NeoOpMulGlobalGlobal:
 			g1Idx := inst.Arg >> 16; g2Idx := inst.Arg & 0xFFFF; sp++
-			if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			n1 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g1Idx)*valSize)).Str
 			n2 := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(g2Idx)*valSize)).Str
 			v1, _ := ctx.Get(n1); v2, _ := ctx.Get(n2)
@@ -618,6 +963,7 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 			l := &stack[sp]
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
 			*l = l.Add(*cv)
+			if err := concat.chargeString(l); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case NeoOpSubC:
 			l := &stack[sp]
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
@@ -629,10 +975,10 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 		case NeoOpDivC:
 			l := &stack[sp]
 			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(inst.Arg)*valSize))
-			*l = l.Div(*cv)
+			res, err := l.DivErr(*cv); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }; *l = res
 		case NeoOpConcat:
 			numArgs := int(inst.Arg); totalLen := 0; var argStringsBuf [8]string; var argStrings []string
-			if numArgs <= 8 { argStrings = argStringsBuf[:numArgs] } else { argStrings = make([]string, numArgs) }
+			argStrings = st.strScratch(argStringsBuf[:], numArgs)
 			for i := numArgs - 1; i >= 0; i-- {
 				v := stack[sp]; sp--
 				var s string
@@ -641,31 +987,79 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 				case ValInt: s = fmt.Sprintf("%d", int64(v.Num))
 				case ValFloat: s = fmt.Sprintf("%g", math.Float64frombits(v.Num))
 				case ValBool: if v.Num != 0 { s = "true" } else { s = "false" }
-				default: s = fmt.Sprintf("%v", v.ToInterface())
+				default: s = formatAny(v.ToInterface())
 				}
 				argStrings[i] = s; totalLen += len(s)
 			}
-			buf := neoBufferPool.Get().(*bytes.Buffer); buf.Reset(); buf.Grow(totalLen)
-			for _, s := range argStrings { buf.WriteString(s) }
-			res := buf.String(); neoBufferPool.Put(buf)
-			sp++; if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if err := concat.charge(totalLen); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			res := st.join(&neoBufferPool, argStrings, totalLen)
+			sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValString, Str: res}
+		case NeoOpAddInt:
+			r := stack[sp]; sp--; l := &stack[sp]
+			l.Num += r.Num
+		case NeoOpSubInt:
+			r := stack[sp]; sp--; l := &stack[sp]
+			l.Num -= r.Num
+		case NeoOpMulInt:
+			r := stack[sp]; sp--; l := &stack[sp]
+			l.Num *= r.Num
+		case NeoOpConcat2:
+			r := stack[sp]; sp--; l := &stack[sp]
+			var s1, s2 string
+			if l.Type == ValString { s1 = l.Str } else { s1 = formatAny(l.ToInterface()) }
+			if r.Type == ValString { s2 = r.Str } else { s2 = formatAny(r.ToInterface()) }
+			if err := concat.charge(len(s1)+len(s2)); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			*l = Value{Type: ValString, Str: s1 + s2}
+		case NeoOpConcatGC:
+			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
+			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
+			lv, _ := ctx.Get(name); var s1, s2 string
+			if s, ok := lv.(string); ok { s1 = s } else { s1 = formatAny(lv) }
+			if cv.Type == ValString { s2 = cv.Str } else { s2 = formatAny(cv.ToInterface()) }
+			if err := concat.charge(len(s1)+len(s2)); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = Value{Type: ValString, Str: s1 + s2}
+		case NeoOpConcatCG:
+			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF; sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
+			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(gIdx)*valSize)).Str
+			cv := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(cIdx)*valSize))
+			rv, _ := ctx.Get(name); var s1, s2 string
+			if cv.Type == ValString { s1 = cv.Str } else { s1 = formatAny(cv.ToInterface()) }
+			if s, ok := rv.(string); ok { s2 = s } else { s2 = formatAny(rv) }
+			if err := concat.charge(len(s1)+len(s2)); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = Value{Type: ValString, Str: s1 + s2}
 		case NeoOpCall:
 			nameIdx := inst.Arg & 0xFFFF; numArgs := int(inst.Arg >> 16)
 			name := (*Value)(unsafe.Add(unsafe.Pointer(pConsts), uintptr(nameIdx)*valSize)).Str
 			var argsBuf [8]any; var args []any
-			if numArgs <= 8 { args = argsBuf[:numArgs] } else { args = make([]any, numArgs) }
+			args = st.argScratch(argsBuf[:], numArgs)
 			for i := numArgs - 1; i >= 0; i-- {
 				args[i] = stack[sp].ToInterface(); sp--
 			}
-			if builtin, ok := builtins[name]; ok {
-				res, err := builtin(args...); if err != nil { return nil, err }
-				sp++; if sp >= 64 { return nil, fmt.Errorf("NeoVM stack overflow") }
+			if builtin, ok := builtins.get(name); ok {
+				res, err := callBuiltin(name, builtin, args); st.release(args); if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+				sp++; if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("NeoVM stack overflow")); goto unwind }
 				stack[sp] = FromInterface(res)
-			} else { return nil, fmt.Errorf("builtin function not found: %s", name) }
+			} else { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("builtin function not found: %s", name)); goto unwind }
+		case NeoOpTry:
+			handlers = append(handlers, tryHandler{bc: bc, pc: int(inst.Arg), sp: sp, fp: fp})
+		case NeoOpEndTry:
+			handlers = handlers[:len(handlers)-1]
+			pc = int(inst.Arg)
 		default:
-			return nil, fmt.Errorf("unsupported NeoVM opcode: %v", inst.Op)
+			fault = bc.fault(pc-1, stack, sp, fmt.Errorf("unsupported NeoVM opcode: %v", inst.Op))
+			goto unwind
 		}
+		continue
+	unwind:
+		// 出错时回到最内层 try 的现场求备用值，不在 try 中时返回错误
+		if len(handlers) == 0 { return nil, fault }
+		h := handlers[len(handlers)-1]; handlers = handlers[:len(handlers)-1]
+		bc, pc, sp, fp = h.bc.(*NeoBytecode), h.pc, h.sp, h.fp
+		insts = bc.Instructions; nInsts, pInsts = len(insts), unsafe.SliceData(insts)
 	}
 	if sp < 0 { return nil, nil }
 	return stack[sp].ToInterface(), nil
@@ -674,9 +1068,16 @@ func runNeoVMGeneral(bc *NeoBytecode, ctx Context) (any, error) {
 func (l Value) Equal(r Value) bool {
 	if l.Type == r.Type {
 		switch l.Type {
-		case ValInt, ValFloat, ValBool: return l.Num == r.Num
+		case ValInt, ValFloat, ValBool, ValDuration: return l.Num == r.Num
 		case ValString: return l.Str == r.Str
+		case ValTime: return timeEqual(l, r)
+		case ValObject: return objectEqual(l, r)
+		case ValSet: return setEqual(l.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+		case ValDecimal: return decimalEqual(l, r)
+		case ValBytes: return bytesEqual(l, r)
 		case ValNil: return true
+		case ValArray: return arrayEqual(l.Obj.([]any), r.Obj.([]any))
+		case ValMap: return mapEqual(l.Obj.(map[string]any), r.Obj.(map[string]any))
 		}
 	}
 	lf, okL := valToFloat64(l); rf, okR := valToFloat64(r)
@@ -684,8 +1085,16 @@ func (l Value) Equal(r Value) bool {
 	return false
 }
 
+// EqualFold 为 `==*`：两个字符串按 Unicode 大小写折叠比较，不分配小写副本；其他类型同 Equal
+func (l Value) EqualFold(r Value) bool {
+	if l.Type == ValString && r.Type == ValString { return strings.EqualFold(l.Str, r.Str) }
+	return l.Equal(r)
+}
+
 func (l Value) Greater(r Value) bool {
 	if l.Type == ValInt && r.Type == ValInt { return int64(l.Num) > int64(r.Num) }
+	if l.Type == ValString && r.Type == ValString { return l.Str > r.Str }
+	if c, ok := compareExtended(l, r); ok { return c > 0 }
 	lf, okL := valToFloat64(l); rf, okR := valToFloat64(r)
 	if okL && okR { return lf > rf }
 	return false
@@ -694,40 +1103,54 @@ func (l Value) Greater(r Value) bool {
 func (l Value) Add(r Value) Value {
 	if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: l.Num + r.Num} }
 	if l.Type == ValString && r.Type == ValString { return Value{Type: ValString, Str: l.Str + r.Str} }
+	if l.Type == ValArray && r.Type == ValArray { return Value{Type: ValArray, Obj: concatArrays(l.Obj.([]any), r.Obj.([]any))} }
+	if v, ok := addTemporal(l, r); ok { return v }
+	if v, ok := addHost(l, r); ok { return v }
+	if v, ok, _ := arithDecimal(TokenPlus, l, r); ok { return v }
 	lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 	return Value{Type: ValFloat, Num: math.Float64bits(lf + rf)}
 }
 
 func (l Value) Sub(r Value) Value {
 	if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: l.Num - r.Num} }
+	if v, ok := subTemporal(l, r); ok { return v }
+	if v, ok := subHost(l, r); ok { return v }
+	if v, ok, _ := arithDecimal(TokenMinus, l, r); ok { return v }
+	if l.Type == ValSet && r.Type == ValSet { s, _ := setOp(TokenMinus, l.Obj.(map[string]struct{}), r.Obj.(map[string]struct{})); return Value{Type: ValSet, Obj: s} }
 	lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 	return Value{Type: ValFloat, Num: math.Float64bits(lf - rf)}
 }
 
 func (l Value) Mul(r Value) Value {
 	if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: l.Num * r.Num} }
+	if v, ok, _ := arithDecimal(TokenAsterisk, l, r); ok { return v }
 	lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 	return Value{Type: ValFloat, Num: math.Float64bits(lf * rf)}
 }
 
 func (l Value) Div(r Value) Value {
 	if (r.Type == ValInt && r.Num == 0) || (r.Type == ValFloat && math.Float64frombits(r.Num) == 0) { return Value{Type: ValFloat, Num: math.Float64bits(math.Inf(1))} }
-	if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: l.Num / r.Num} }
+	if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: uint64(int64(l.Num) / int64(r.Num))} }
+	if v, ok, err := arithDecimal(TokenSlash, l, r); ok {
+		if err != nil { return Value{Type: ValFloat, Num: math.Float64bits(math.Inf(1))} }
+		return v
+	}
 	lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 	return Value{Type: ValFloat, Num: math.Float64bits(lf / rf)}
 }
 
 func (l Value) DivErr(r Value) (Value, error) {
 	if (r.Type == ValInt && r.Num == 0) || (r.Type == ValFloat && math.Float64frombits(r.Num) == 0) { return Value{}, fmt.Errorf("division by zero") }
-	if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: l.Num / r.Num}, nil }
+	if l.Type == ValInt && r.Type == ValInt { return Value{Type: ValInt, Num: uint64(int64(l.Num) / int64(r.Num))}, nil }
+	if v, ok, err := arithDecimal(TokenSlash, l, r); ok { return v, err }
 	lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 	return Value{Type: ValFloat, Num: math.Float64bits(lf / rf)}, nil
 }
 
 func (l Value) ModErr(r Value) (Value, error) {
-	if r.Type != ValInt { return Value{}, fmt.Errorf("modulo operator supports only integers") }
+	if r.Type != ValInt || l.Type == ValDecimal { return Value{}, fmt.Errorf("modulo operator supports only integers") }
 	if r.Num == 0 { return Value{}, fmt.Errorf("division by zero") }
-	return Value{Type: ValInt, Num: l.Num % r.Num}, nil
+	return Value{Type: ValInt, Num: uint64(int64(l.Num) % int64(r.Num))}, nil
 }
 
 func AddAny(v1, v2 any) Value {
@@ -804,6 +1227,29 @@ func DivAny(v1, v2 any) Value {
 	return FromInterface(v1).Div(FromInterface(v2))
 }
 
+// DivAnyErr 与 DivAny 相同，但除数为 0 时与 DivErr 一样返回错误，供融合的除法指令报告除零
+func DivAnyErr(v1, v2 any) (Value, error) {
+	switch lv := v1.(type) {
+	case int64:
+		if rv, ok := v2.(int64); ok && rv != 0 { return Value{Type: ValInt, Num: uint64(lv / rv)}, nil }
+	case float64:
+		if rv, ok := v2.(float64); ok && rv != 0 { return Value{Type: ValFloat, Num: math.Float64bits(lv / rv)}, nil }
+	}
+	return FromInterface(v1).DivErr(FromInterface(v2))
+}
+
+// neoMapOperand 校验 MGET/MSET/MHAS/MDEL 的接收者与键，错误信息与 CallMethodAny 一致。
+// MSET/MDEL 执行后接收者原样留在栈顶，与 set/del 返回映射本身的语义相同。
+func neoMapOperand(recv, key Value, method string) (map[string]any, string, error) {
+	if recv.Type != ValMap {
+		return nil, "", fmt.Errorf("method %s not supported on %T", method, recv.ToInterface())
+	}
+	if key.Type != ValString {
+		return nil, "", fmt.Errorf("map key must be a string, got %T", key.ToInterface())
+	}
+	return recv.Obj.(map[string]any), key.Str, nil
+}
+
 func EqualAny(v1, v2 any) bool {
 	switch lv := v1.(type) {
 	case int64:
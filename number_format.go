@@ -0,0 +1,69 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import "strings"
+
+// NumberFormat 为规则中数字字面量的书写格式，见 EngineOptions.NumberFormat
+type NumberFormat uint8
+
+const (
+	// NumberDefault 以 . 为小数点，数字之间可以用 _ 分隔，如 1_234.56
+	NumberDefault NumberFormat = iota
+	// NumberGrouped 另外接受 , 作为千位分隔符，如 1,234,567.89
+	NumberGrouped
+	// NumberDecimalComma 以 , 为小数点、. 为千位分隔符，如 1.234.567,89（德语、法语等地区的写法）
+	NumberDecimalComma
+)
+
+// readLocaleNumber 按 l.numbers 读取十进制数字面量并转换为默认格式，如 1.234,56 读作 1234.56，
+// 之后的时长与十进制数后缀照常识别。千位分隔符只能出现在整数部分，且其后须恰好是三位数字：
+// NumberGrouped 中不满足条件的 , 不属于数字，`max(1,5)` 仍是两个实参；NumberDecimalComma 中
+// 不满足条件的 .（如 1.5、1,5.3）使整个数字成为非法记号，返回 false。0x 等前缀形式不受影响
+func (l *Lexer) readLocaleNumber() (string, bool) {
+	if l.ch == '0' && isBasePrefix(l.peekChar()) {
+		return l.readNumber(), true
+	}
+	group, point := byte(','), byte('.')
+	if l.numbers == NumberDecimalComma {
+		group, point = '.', ','
+	}
+	position := l.position
+	var b strings.Builder
+	fraction, bad := false, false
+loop:
+	for {
+		switch {
+		case isDigit(l.ch) || l.ch == '_':
+			b.WriteByte(l.ch)
+		case l.ch == group && !fraction && l.groupFollows():
+		case l.ch == '.' && l.peekChar() != '.' && (point == '.' || isDigit(l.peekChar())):
+			// `1..10` 中的 .. 是区间运算符，不属于数字；其余的 . 与默认格式一样读入，多余的由此报错
+			bad = bad || point == ',' || fraction
+			fraction = true
+			b.WriteByte('.')
+		case l.ch == ',' && point == ',' && !fraction && isDigit(l.peekChar()):
+			fraction = true
+			b.WriteByte('.')
+		default:
+			break loop
+		}
+		l.readChar()
+	}
+	exp := l.position
+	l.readExponent()
+	if bad {
+		return l.input[position:l.position], false
+	}
+	return b.String() + l.input[exp:l.position], true
+}
+
+// groupFollows 判断当前的千位分隔符之后是否恰好是三位数字
+func (l *Lexer) groupFollows() bool {
+	rest := l.input[l.readPosition:]
+	if len(rest) < 3 || !isDigit(rest[0]) || !isDigit(rest[1]) || !isDigit(rest[2]) {
+		return false
+	}
+	return len(rest) == 3 || !isDigit(rest[3]) && rest[3] != '_'
+}
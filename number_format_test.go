@@ -0,0 +1,52 @@
+package uwasa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNumberFormat(t *testing.T) {
+	tests := []struct {
+		numbers  NumberFormat
+		input    string
+		expected any
+	}{
+		{NumberGrouped, `amount >= 1,000,000.50`, true},
+		{NumberGrouped, `1,234 + 1`, int64(1235)},
+		{NumberGrouped, `len([1, 234])`, int64(2)},
+		{NumberGrouped, `len([1,234])`, int64(1)},
+		{NumberGrouped, `len([1,5])`, int64(2)},
+		{NumberGrouped, `1,234.5d * 2`, "2469.0"},
+		{NumberDecimalComma, `amount >= 1.000.000,50`, true},
+		{NumberDecimalComma, `1.234,5 * 2`, 2469.0},
+		{NumberDecimalComma, `[1,5, 2][0]`, 1.5},
+		{NumberDecimalComma, `len([1, 5])`, int64(2)},
+		{NumberDecimalComma, `1,5d + 1`, "2.5"},
+		{NumberDecimalComma, `1,5h == 90m`, true},
+		{NumberDecimalComma, `2 in 1..3`, true},
+		{NumberDefault, `len([1,234])`, int64(2)},
+	}
+	for _, tt := range tests {
+		opts := EngineOptions{OptimizationLevel: OptBasic, Decimal: true, NumberFormat: tt.numbers}
+		for name, engine := range allEngines(t, tt.input, opts) {
+			got, err := engine.Execute(map[string]any{"amount": 1000000.5})
+			if d, ok := got.(Decimal); ok {
+				got = d.String()
+			}
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+	}
+
+	// NumberDecimalComma 中 . 只能分隔三位一组的整数部分
+	for _, input := range []string{`1.5 + 1`, `1,5.3`, `1.2345`} {
+		opts := EngineOptions{NumberFormat: NumberDecimalComma}
+		if _, err := NewEngineWithOptions(input, opts); err == nil || !strings.Contains(err.Error(), "illegal token") {
+			t.Errorf("AST %s: expected an illegal token error, got %v", input, err)
+		}
+		if _, err := NewEngineVMNeoWithOptions(input, opts); err == nil || !strings.Contains(err.Error(), "illegal token") {
+			t.Errorf("NeoVM %s: expected an illegal token error, got %v", input, err)
+		}
+	}
+}
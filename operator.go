@@ -0,0 +1,151 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// OperatorOptions 为 RegisterOperator 注册的运算符的属性
+type OperatorOptions struct {
+	// Builtin 为运算符改写成的内置函数：中缀运算符 `a op b` 即 `Builtin(a, b)`，前缀运算符 `op a` 即 `Builtin(a)`
+	Builtin string
+	// Prefix 表示注册前缀运算符，否则为中缀运算符。同一个符号可以分别注册为前缀与中缀运算符
+	Prefix bool
+	// Precedence 为中缀运算符的优先级，取 OR 至 PRODUCT 的常量，如 EQUALS、SUM；
+	// 为 0 时与 < 、in 相同（LESSGREATER）。中缀运算符左结合，前缀运算符的优先级与 ! 相同
+	Precedence int
+}
+
+// operatorDef 为一个符号注册的运算符，infix 与 prefix 为对应的内置函数名，未注册的一侧为空
+type operatorDef struct {
+	infix, prefix string
+	precedence    int
+}
+
+var (
+	// operators 为 RegisterOperator 注册的运算符，键为其符号或单词。已发布的 operatorDef 不再修改
+	operators = newTable(map[string]*operatorDef{})
+	// symbolOperators 为符号形式的运算符，按长度从长到短排列，词法分析时依次尝试。
+	// 注册时在 operators 之后发布，词法分析读到的符号因此总能在 operators 中找到
+	symbolOperators atomic.Pointer[[]string]
+)
+
+// operatorChars 为符号运算符可以使用的字符
+const operatorChars = "+-*/%<>=!&|^~?:.@#$\\"
+
+// RegisterOperator 注册运算符 symbol，编译时改写为对 opts.Builtin 的调用，所有引擎因此无需修改即可支持它，
+// 面向 DSL 的部署可以写成 `tags has "vip"`、`a <=> b` 而不是 `hasTag(tags, "vip")`。
+// symbol 为单词（如 has）或由 operatorChars 中的字符组成的符号（如 <=>、~=）：单词不能是关键字或内置函数名，
+// 注册后不能再用作变量名或成员名；符号不能与已有的运算符相同，也不能是其前缀（如 ?，会吞掉 ?.）。
+// 符号在词法分析时优先于内置运算符按最长匹配，注册 <- 之后 `a<-1` 不再是 `a < -1`。
+// Builtin 须已通过 RegisterBuiltin 注册或为内置函数，其是否可以常量折叠沿用 BuiltinOptions.Pure。
+// 运算符在编译时改写，只对此后编译的规则可见。
+func RegisterOperator(symbol string, opts OperatorOptions) error {
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	if _, ok := builtins.get(opts.Builtin); !ok {
+		return fmt.Errorf("operator %s: unknown builtin %q", symbol, opts.Builtin)
+	}
+	if opts.Precedence == 0 {
+		opts.Precedence = LESSGREATER
+	}
+	if opts.Precedence < OR || opts.Precedence > PRODUCT {
+		return fmt.Errorf("operator %s: precedence %d out of range", symbol, opts.Precedence)
+	}
+	old, ok := operators.get(symbol)
+	if !ok {
+		if err := checkOperatorSymbol(symbol); err != nil {
+			return err
+		}
+		old = &operatorDef{}
+	}
+	op := *old
+	if opts.Prefix {
+		if op.prefix != "" {
+			return fmt.Errorf("prefix operator %s already registered", symbol)
+		}
+		op.prefix = opts.Builtin
+	} else {
+		if op.infix != "" {
+			return fmt.Errorf("infix operator %s already registered", symbol)
+		}
+		op.infix, op.precedence = opts.Builtin, opts.Precedence
+	}
+	operators.put(symbol, &op)
+	if !ok && !isLetter(symbol[0]) {
+		var symbols []string
+		if cur := symbolOperators.Load(); cur != nil {
+			symbols = slices.Clone(*cur)
+		}
+		symbols = append(symbols, symbol)
+		slices.SortStableFunc(symbols, func(a, b string) int { return len(b) - len(a) })
+		symbolOperators.Store(&symbols)
+	}
+	return nil
+}
+
+// checkOperatorSymbol 校验尚未注册的运算符符号
+func checkOperatorSymbol(symbol string) error {
+	if symbol == "" {
+		return fmt.Errorf("invalid operator %q", symbol)
+	}
+	if isLetter(symbol[0]) {
+		l := NewLexer(symbol)
+		tok, next := l.NextToken(), l.NextToken()
+		lexerPool.Put(l)
+		if tok.Type != TokenIdent || tok.Literal != symbol || next.Type != TokenEOF {
+			return fmt.Errorf("invalid operator %q: reserved word", symbol)
+		}
+		if _, ok := builtins.get(symbol); ok {
+			return fmt.Errorf("invalid operator %q: name of a builtin", symbol)
+		}
+		return nil
+	}
+	for i := range len(symbol) {
+		if !strings.ContainsRune(operatorChars, rune(symbol[i])) {
+			return fmt.Errorf("invalid operator %q", symbol)
+		}
+	}
+	for t := range TokenOperator {
+		if s := t.String(); strings.HasPrefix(s, symbol) {
+			return fmt.Errorf("invalid operator %q: conflicts with %s", symbol, s)
+		}
+	}
+	return nil
+}
+
+// readOperator 在当前位置匹配注册的符号运算符，匹配时消耗其字符
+func (l *Lexer) readOperator() (Token, bool) {
+	symbols := symbolOperators.Load()
+	if symbols == nil {
+		return Token{}, false
+	}
+	for _, symbol := range *symbols {
+		if strings.HasPrefix(l.input[l.position:], symbol) {
+			for range len(symbol) {
+				l.readChar()
+			}
+			return Token{Type: TokenOperator, Literal: symbol}, true
+		}
+	}
+	return Token{}, false
+}
+
+// tokenPrecedence 与 getPrecedence 相同，另外给出 like 与注册的中缀运算符的优先级
+func tokenPrecedence(tok Token) int {
+	if isLike(tok) {
+		return LESSGREATER
+	}
+	if tok.Type == TokenOperator {
+		if op, _ := operators.get(tok.Literal); op != nil && op.infix != "" {
+			return op.precedence
+		}
+		return LOWEST
+	}
+	return getPrecedence(tok.Type)
+}
@@ -0,0 +1,96 @@
+package uwasa
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+)
+
+// registerTestOperator 注册仅在当前测试中可见的运算符
+func registerTestOperator(t *testing.T, symbol string, opts OperatorOptions) {
+	t.Helper()
+	if err := RegisterOperator(symbol, opts); err != nil {
+		t.Fatalf("register %s: %v", symbol, err)
+	}
+	t.Cleanup(func() {
+		operators.del(symbol)
+		if cur := symbolOperators.Load(); cur != nil {
+			symbols := slices.DeleteFunc(slices.Clone(*cur), func(s string) bool { return s == symbol })
+			symbolOperators.Store(&symbols)
+		}
+	})
+}
+
+func TestRegisterOperator(t *testing.T) {
+	registerTestBuiltin(t, "hasTag", func(args ...any) (any, error) {
+		tags, _ := args[0].([]any)
+		return slices.Contains(tags, args[1]), nil
+	}, BuiltinOptions{Pure: true})
+	registerTestBuiltin(t, "cmp3", func(args ...any) (any, error) {
+		a, _ := toFloat64(args[0])
+		b, _ := toFloat64(args[1])
+		return int64(cmp.Compare(a, b)), nil
+	}, BuiltinOptions{Pure: true})
+	registerTestBuiltin(t, "countOf", func(args ...any) (any, error) {
+		arr, _ := args[0].([]any)
+		return int64(len(arr)), nil
+	}, BuiltinOptions{})
+	registerTestOperator(t, "has", OperatorOptions{Builtin: "hasTag", Precedence: EQUALS})
+	registerTestOperator(t, "<=>", OperatorOptions{Builtin: "cmp3"})
+	registerTestOperator(t, "<=>>", OperatorOptions{Builtin: "cmp3", Precedence: SUM})
+	registerTestOperator(t, "#", OperatorOptions{Builtin: "countOf", Prefix: true})
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`tags has "vip" && !(tags has "blocked")`, true},
+		{`tags has concat("n", "ew") == false`, false},
+		{`a <=> b`, int64(-1)},
+		{`a + 2 <=> b`, int64(1)},
+		{`1 <=>> 2 + 1`, int64(0)},
+		{`#tags * 2`, int64(4)},
+		{`#[1, 2, 3] <=> #tags`, int64(1)},
+		{`a<=>b == -1`, true},
+		{`a <= b`, true},
+		{`[1, 2] has 2`, true},
+	}
+	ctx := map[string]any{"tags": []any{"vip", "new"}, "a": int64(1), "b": int64(2)}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Fatalf("%s: %s: compile error: %v", name, tt.input, err)
+			}
+			if got, err := engine.Execute(ctx); err != nil || got != tt.expected {
+				t.Errorf("%s: %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		if _, err := newEngine(`has == 1`); err == nil {
+			t.Errorf("%s: expected operator word used as a variable to fail", name)
+		}
+		if _, err := newEngine(`<=> a`); err == nil {
+			t.Errorf("%s: expected infix operator in prefix position to fail", name)
+		}
+	}
+
+	for _, bad := range []struct {
+		symbol string
+		opts   OperatorOptions
+	}{
+		{"has", OperatorOptions{Builtin: "hasTag"}},
+		{"~", OperatorOptions{Builtin: "missing"}},
+		{"~", OperatorOptions{Builtin: "cmp3", Precedence: CALL}},
+		{"==", OperatorOptions{Builtin: "cmp3"}},
+		{"?", OperatorOptions{Builtin: "cmp3"}},
+		{"a b", OperatorOptions{Builtin: "cmp3"}},
+		{"~1", OperatorOptions{Builtin: "cmp3"}},
+		{"and", OperatorOptions{Builtin: "cmp3"}},
+		{"if", OperatorOptions{Builtin: "cmp3"}},
+		{"len", OperatorOptions{Builtin: "cmp3"}},
+	} {
+		if err := RegisterOperator(bad.symbol, bad.opts); err == nil {
+			t.Errorf("%q: expected registration to fail", bad.symbol)
+		}
+	}
+}
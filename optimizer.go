@@ -3,9 +3,10 @@
 
 package uwasa
 
-import "strings"
-
-import "fmt"
+import (
+	"math"
+	"strings"
+)
 
 func Fold(node Node) Node {
 	if node == nil {
@@ -45,6 +46,33 @@ func Fold(node Node) Node {
 			if okLS && okRS {
 				return &StringLiteral{Value: leftS.Value + rightS.Value}
 			}
+			// 两个数组字面量相加直接合并为一个字面量，元素仍按从左到右的顺序求值
+			leftA, okLA := n.Left.(*ArrayLiteral)
+			rightA, okRA := n.Right.(*ArrayLiteral)
+			if okLA && okRA {
+				return &ArrayLiteral{Elements: append(append([]Expression{}, leftA.Elements...), rightA.Elements...)}
+			}
+		}
+
+		// 两个字符串字面量的比较按字节序折叠
+		if leftS, ok := n.Left.(*StringLiteral); ok {
+			if rightS, ok := n.Right.(*StringLiteral); ok {
+				switch n.Operator {
+				case "==": return &BooleanLiteral{Value: leftS.Value == rightS.Value}
+				case "==*": return &BooleanLiteral{Value: strings.EqualFold(leftS.Value, rightS.Value)}
+				case "!=": return &BooleanLiteral{Value: leftS.Value != rightS.Value}
+				case ">": return &BooleanLiteral{Value: leftS.Value > rightS.Value}
+				case "<": return &BooleanLiteral{Value: leftS.Value < rightS.Value}
+				case ">=": return &BooleanLiteral{Value: leftS.Value >= rightS.Value}
+				case "<=": return &BooleanLiteral{Value: leftS.Value <= rightS.Value}
+				}
+			}
+		}
+
+		if n.Operator == "in" {
+			if folded, ok := foldIn(n.Left, n.Right); ok {
+				return folded
+			}
 		}
 
 		if okL && okR {
@@ -79,12 +107,25 @@ func Fold(node Node) Node {
 				if left.IsInt && right.IsInt && right.Int64Value != 0 {
 					return &NumberLiteral{Int64Value: left.Int64Value % right.Int64Value, IsInt: true}
 				}
+			case "&", "|", "^", "<<", ">>":
+				if left.IsInt && right.IsInt {
+					v, err := Value{Type: ValInt, Num: uint64(left.Int64Value)}.Bitwise(bitwiseOps[n.Operator], Value{Type: ValInt, Num: uint64(right.Int64Value)})
+					if err == nil {
+						return &NumberLiteral{Int64Value: int64(v.Num), IsInt: true}
+					}
+				}
 			case "==":
 				if left.IsInt && right.IsInt {
 					return &BooleanLiteral{Value: left.Int64Value == right.Int64Value}
 				}
 				lv, rv := getFloatValues(left, right)
 				return &BooleanLiteral{Value: lv == rv}
+			case "!=":
+				if left.IsInt && right.IsInt {
+					return &BooleanLiteral{Value: left.Int64Value != right.Int64Value}
+				}
+				lv, rv := getFloatValues(left, right)
+				return &BooleanLiteral{Value: lv != rv}
 			case ">":
 				if left.IsInt && right.IsInt {
 					return &BooleanLiteral{Value: left.Int64Value > right.Int64Value}
@@ -112,18 +153,32 @@ func Fold(node Node) Node {
 			}
 		}
 
+		// 返回操作数时只有左侧为字面量才能确定结果，`x && true` 这类右侧化简不再成立
+		if n.ReturnsOperand && (n.Operator == "&&" || n.Operator == "||") {
+			if lv, ok := literalValue(n.Left); ok {
+				if isValTruthy(lv) == (n.Operator == "&&") {
+					return n.Right
+				}
+				return n.Left
+			}
+			return n
+		}
+
 		// Handle Boolean logic folding
 		leftB, okLB := n.Left.(*BooleanLiteral)
 		rightB, okRB := n.Right.(*BooleanLiteral)
 
+		// 结果须是布尔值，留下的一侧不一定求得布尔值时保留运算
 		if n.Operator == "&&" {
 			if okLB {
 				if !leftB.Value {
 					return &BooleanLiteral{Value: false}
 				}
-				return n.Right
+				if yieldsBool(n.Right) {
+					return n.Right
+				}
 			}
-			if okRB && rightB.Value {
+			if okRB && rightB.Value && yieldsBool(n.Left) {
 				return n.Left
 			}
 		}
@@ -132,9 +187,11 @@ func Fold(node Node) Node {
 				if leftB.Value {
 					return &BooleanLiteral{Value: true}
 				}
-				return n.Right
+				if yieldsBool(n.Right) {
+					return n.Right
+				}
 			}
-			if okRB && !rightB.Value {
+			if okRB && !rightB.Value && yieldsBool(n.Left) {
 				return n.Left
 			}
 		}
@@ -142,6 +199,9 @@ func Fold(node Node) Node {
 		if okLB && okRB && n.Operator == "==" {
 			return &BooleanLiteral{Value: leftB.Value == rightB.Value}
 		}
+		if okLB && okRB && n.Operator == "!=" {
+			return &BooleanLiteral{Value: leftB.Value != rightB.Value}
+		}
 
 	case *IfExpression:
 		foldedCond := Fold(n.Condition)
@@ -175,40 +235,30 @@ func Fold(node Node) Node {
 			}
 		}
 	case *CallExpression:
-		allConst := true
 		for i, arg := range n.Arguments {
-			folded := Fold(arg)
-			if folded != nil {
+			if folded := Fold(arg); folded != nil {
 				n.Arguments[i] = folded.(Expression)
 			}
-			if _, ok := n.Arguments[i].(Literal); !ok {
-				// We consider StringLiteral, NumberLiteral, BooleanLiteral as constants
-				// Let's define a Literal interface or check specifically
-				switch n.Arguments[i].(type) {
-				case *StringLiteral, *NumberLiteral, *BooleanLiteral:
-				default:
-					allConst = false
-				}
-			}
-		}
-		// If it's a call to "concat" with all constant arguments, we can fold it
-		if ident, ok := n.Function.(*Identifier); ok && ident.Value == "concat" && allConst {
-			var res strings.Builder
-			for _, arg := range n.Arguments {
-				switch a := arg.(type) {
-				case *StringLiteral:
-					res.WriteString(a.Value)
-				case *NumberLiteral:
-					if a.IsInt {
-						res.WriteString(fmt.Sprintf("%d", a.Int64Value))
-					} else {
-						res.WriteString(fmt.Sprintf("%g", a.Float64Value))
-					}
-				case *BooleanLiteral:
-					res.WriteString(fmt.Sprintf("%v", a.Value))
+		}
+		// 字面量不会出错，try 直接取其值
+		if expr, _, ok := tryArgs(n); ok {
+			if _, ok := literalValue(expr); ok {
+				return expr
+			}
+		}
+		// 实参均为字面量的纯内置函数调用在编译期求值，如 concat("a", 1)、len("hello")
+		if ident, ok := n.Function.(*Identifier); ok {
+			if vals, ok := literalValues(n.Arguments); ok {
+				args := make([]any, len(vals))
+				for i, v := range vals {
+					args[i] = v.ToInterface()
+				}
+				// 集合没有对应的字面量节点，只由 NeoVM 折叠为常量
+				if res, ok := foldBuiltin(ident.Value, args); ok && res.Type != ValSet {
+					return valueLiteral(res)
 				}
 			}
-			return &StringLiteral{Value: res.String()}
+			prepareCallArgs(ident.Value, n.Arguments)
 		}
 
 	case *AssignExpression:
@@ -216,6 +266,138 @@ func Fold(node Node) Node {
 		if foldedVal != nil {
 			n.Value = foldedVal.(Expression)
 		}
+	case *DestructureExpression:
+		if folded := Fold(n.Value); folded != nil {
+			n.Value = folded.(Expression)
+		}
+		if folded := Fold(n.Body); folded != nil {
+			n.Body = folded.(Expression)
+		}
+	case *LetExpression:
+		if folded := Fold(n.Value); folded != nil {
+			n.Value = folded.(Expression)
+		}
+		if folded := Fold(n.Body); folded != nil {
+			n.Body = folded.(Expression)
+		}
+	case *Program:
+		for _, fn := range n.Functions {
+			if folded := Fold(fn.Body); folded != nil {
+				fn.Body = folded.(Expression)
+			}
+		}
+		if folded := Fold(n.Body); folded != nil {
+			n.Body = folded.(Expression)
+		}
+	case *LambdaLiteral:
+		if folded := Fold(n.Body); folded != nil {
+			n.Body = folded.(Expression)
+		}
+	case *ArrayLiteral:
+		spreads := false
+		for i, el := range n.Elements {
+			if folded := Fold(el); folded != nil {
+				n.Elements[i] = folded.(Expression)
+			}
+			if spread, ok := n.Elements[i].(*SpreadElement); ok {
+				_, lit := spread.Value.(*ArrayLiteral)
+				spreads = spreads || lit
+			}
+		}
+		// 展开数组字面量等同于直接写出其元素
+		if spreads {
+			elements := make([]Expression, 0, len(n.Elements))
+			for _, el := range n.Elements {
+				if spread, ok := el.(*SpreadElement); ok {
+					if arr, ok := spread.Value.(*ArrayLiteral); ok {
+						elements = append(elements, arr.Elements...)
+						continue
+					}
+				}
+				elements = append(elements, el)
+			}
+			n.Elements = elements
+		}
+	case *SpreadElement:
+		if folded := Fold(n.Value); folded != nil {
+			n.Value = folded.(Expression)
+		}
+	case *MapLiteral:
+		for i := range n.Keys {
+			if folded := Fold(n.Keys[i]); folded != nil {
+				n.Keys[i] = folded.(Expression)
+			}
+			if folded := Fold(n.Values[i]); folded != nil {
+				n.Values[i] = folded.(Expression)
+			}
+		}
+	case *MethodCallExpression:
+		if folded := Fold(n.Receiver); folded != nil {
+			n.Receiver = folded.(Expression)
+		}
+		for i, arg := range n.Arguments {
+			if folded := Fold(arg); folded != nil {
+				n.Arguments[i] = folded.(Expression)
+			}
+		}
+	case *OptionalMemberExpression:
+		if folded := Fold(n.Receiver); folded != nil {
+			n.Receiver = folded.(Expression)
+		}
+	case *RangeExpression:
+		if folded := Fold(n.Start); folded != nil {
+			n.Start = folded.(Expression)
+		}
+		if folded := Fold(n.End); folded != nil {
+			n.End = folded.(Expression)
+		}
+	case *ScoreExpression:
+		for i, cond := range n.Conditions {
+			if folded := Fold(cond); folded != nil {
+				n.Conditions[i] = folded.(Expression)
+			}
+		}
+		// 条件均为字面量时直接求出总分
+		if conds, ok := literalValues(n.Conditions); ok {
+			acc := scoreZero(n.Weights)
+			for i, c := range conds {
+				if isValTruthy(c) {
+					w, _ := literalValue(n.Weights[i])
+					acc = acc.Add(w)
+				}
+			}
+			return valueLiteral(acc)
+		}
+	case *IndexExpression:
+		if folded := Fold(n.Left); folded != nil {
+			n.Left = folded.(Expression)
+		}
+		if folded := Fold(n.Index); folded != nil {
+			n.Index = folded.(Expression)
+		}
+	case *IndexAssignExpression:
+		if folded := Fold(n.Left); folded != nil {
+			n.Left = folded.(Expression)
+		}
+		if folded := Fold(n.Index); folded != nil {
+			n.Index = folded.(Expression)
+		}
+		if folded := Fold(n.Value); folded != nil {
+			n.Value = folded.(Expression)
+		}
+	case *TupleExpression:
+		for i, el := range n.Elements {
+			// 折叠为 nil 的元素（如 if false then ...）保留原节点，其求值结果即为 nil
+			if folded := Fold(el); folded != nil {
+				n.Elements[i] = folded.(Expression)
+			}
+		}
+	case *SequenceExpression:
+		for i, stmt := range n.Statements {
+			if folded := Fold(stmt); folded != nil {
+				n.Statements[i] = folded.(Expression)
+			}
+		}
 	}
 	return node
 }
@@ -243,3 +425,156 @@ func getFloatValues(l, r *NumberLiteral) (float64, float64) {
 	}
 	return lv, rv
 }
+
+// constMapLiteral 在键均为字符串字面量、值均为字面量或全字面量 map 时，
+// 于编译期构建该 map，供编译器放入常量池（运行时由 COPYC 取副本）
+func constMapLiteral(n *MapLiteral) (map[string]any, bool) {
+	m := make(map[string]any, len(n.Keys))
+	for i, keyNode := range n.Keys {
+		key, ok := keyNode.(*StringLiteral)
+		if !ok {
+			return nil, false
+		}
+		switch v := n.Values[i].(type) {
+		case *NumberLiteral:
+			if v.IsInt {
+				m[key.Value] = v.Int64Value
+			} else {
+				m[key.Value] = v.Float64Value
+			}
+		case *StringLiteral:
+			m[key.Value] = v.Value
+		case *BooleanLiteral:
+			m[key.Value] = v.Value
+		case *MapLiteral:
+			inner, ok := constMapLiteral(v)
+			if !ok {
+				return nil, false
+			}
+			m[key.Value] = inner
+		default:
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// foldIn 在两侧均为常量时求出 `in` 的结果；右侧可以是字符串字面量、
+// 全字面量数组或 constMapLiteral 可折叠的映射。求值出错时保留原表达式，留待运行期报错。
+func foldIn(left, right Expression) (Expression, bool) {
+	needle, ok := literalValue(left)
+	if !ok {
+		return nil, false
+	}
+	var haystack any
+	switch r := right.(type) {
+	case *StringLiteral:
+		haystack = r.Value
+	case *ArrayLiteral:
+		vals, ok := literalValues(r.Elements)
+		if !ok {
+			return nil, false
+		}
+		arr := make([]any, len(vals))
+		for i, v := range vals {
+			arr[i] = v.ToInterface()
+		}
+		haystack = arr
+	case *MapLiteral:
+		m, ok := constMapLiteral(r)
+		if !ok {
+			return nil, false
+		}
+		haystack = m
+	case *RangeExpression:
+		rng, ok := constRange(r)
+		if !ok {
+			return nil, false
+		}
+		haystack = rng
+	default:
+		return nil, false
+	}
+	found, err := InAny(needle.ToInterface(), haystack)
+	if err != nil {
+		return nil, false
+	}
+	return &BooleanLiteral{Value: found}, true
+}
+
+// constRange 判断区间的两端是否均为整数字面量，是则返回对应的区间
+func constRange(n *RangeExpression) (Range, bool) {
+	start, ok1 := n.Start.(*NumberLiteral)
+	end, ok2 := n.End.(*NumberLiteral)
+	if !ok1 || !ok2 || !start.IsInt || !end.IsInt {
+		return Range{}, false
+	}
+	return Range{Start: start.Int64Value, End: end.Int64Value}, true
+}
+
+// foldBuiltin 在编译期调用纯内置函数 name。调用出错或结果不是数字、字符串、布尔值时返回 false，
+// 保留原调用留待运行期求值与报错
+func foldBuiltin(name string, args []any) (Value, bool) {
+	fn, ok := builtins.get(name)
+	if !ok || !pureBuiltins.load()[name] {
+		return Value{}, false
+	}
+	res, err := callBuiltin(name, fn, args)
+	if err != nil {
+		return Value{}, false
+	}
+	switch res.(type) {
+	case int64, float64, string, bool, map[string]struct{}, Decimal:
+		return FromInterface(res), true
+	}
+	return Value{}, false
+}
+
+// valueLiteral 把数字、字符串、布尔值或十进制数转换为对应的字面量节点
+func valueLiteral(v Value) Expression {
+	switch v.Type {
+	case ValInt:
+		return &NumberLiteral{Int64Value: int64(v.Num), IsInt: true}
+	case ValFloat:
+		return &NumberLiteral{Float64Value: math.Float64frombits(v.Num)}
+	case ValString:
+		return &StringLiteral{Value: v.Str}
+	case ValBool:
+		return &BooleanLiteral{Value: v.Num != 0}
+	case ValDecimal:
+		d := v.Obj.(Decimal)
+		return &DecimalLiteral{Value: d, Literal: d.String() + "d"}
+	}
+	return nil
+}
+
+// literalValues 在所有元素均为字面量时返回其值
+func literalValues(elems []Expression) ([]Value, bool) {
+	vals := make([]Value, len(elems))
+	for i, el := range elems {
+		v, ok := literalValue(el)
+		if !ok {
+			return nil, false
+		}
+		vals[i] = v
+	}
+	return vals, true
+}
+
+// yieldsBool 报告表达式是否总是求得布尔值
+func yieldsBool(e Expression) bool {
+	switch n := e.(type) {
+	case *BooleanLiteral:
+		return true
+	case *PrefixExpression:
+		return n.Operator == "!"
+	case *InfixExpression:
+		switch n.Operator {
+		case "==", "!=", "==*", "<", ">", "<=", ">=", "in":
+			return true
+		case "&&", "||":
+			return !n.ReturnsOperand
+		}
+	}
+	return false
+}
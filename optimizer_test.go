@@ -23,17 +23,37 @@ func TestConstantFolding(t *testing.T) {
 		{"if true is 1 else is 2", "1"},
 		{"if false is 1 else is 2", "2"},
 		{"if 1 == 1 is " + `"yes"` + " else is " + `"no"`, "yes"},
-		{"true && a", "a"},
+		{"true && a", "(true && a)"},
+		{"true && a > 1", "(a > 1)"},
 		{"false && a", "false"},
 		{"true || a", "true"},
-		{"false || a", "a"},
-		{"a && true", "a"},
-		{"a || false", "a"},
-		{"true && (a = 1)", "(a = 1)"},
+		{"false || a", "(false || a)"},
+		{"false || !a", "(!a)"},
+		{"a && true", "(a && true)"},
+		{"a == 1 && true", "(a == 1)"},
+		{"a || false", "(a || false)"},
+		{"a in b || false", "(a in b)"},
+		{"true && (a = 1)", "(true && (a = 1))"},
 		{"false && (a = 1)", "false"},
 		{`"hello " + "world"`, "hello world"},
 		{`concat("a", "b", "c")`, "abc"},
 		{`concat("v=", 100)`, "v=100"},
+		{`len("héllo")`, "5"},
+		{`len(concat("ab", 1)) > 2`, "true"},
+		{`"ab" |> concat("c") |> len`, "3"},
+		{`escape_html("<a href='x'>")`, "&lt;a href=&#39;x&#39;&gt;"},
+		{`escape_url(concat("a b", "&"))`, "a+b%26"},
+		{`escape_json("say \"hi\"\n")`, `say \"hi\"\n`},
+		{`len(1)`, "len(1)"},
+		{`len(a)`, "len(a)"},
+		{`filter("a", 1)`, "filter(a, 1)"},
+		{`"b" in ["a", "b"]`, "true"},
+		{`"z" in {"a": 1}`, "false"},
+		{`"ell" in "hello"`, "true"},
+		{"6 & 3", "2"},
+		{"6 | 3 ^ 1", "6"},
+		{"1 << 4 + 1", "32"},
+		{"256 >> 2", "64"},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,96 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import "reflect"
+
+// Overloads 为宿主类型 T 实现规则中的运算符，金额、版本号这类领域类型因此可以保持原值在规则中流转，
+// 直接写成 `price + shipping`、`version >= min_version`。两侧须同为 T，其他组合按原有规则处理
+type Overloads[T any] struct {
+	// Add 与 Sub 实现 a + b 与 a - b，为 nil 时不重载该运算符
+	Add, Sub func(a, b T) T
+	// Compare 实现 == != < <= > >=，返回负数、0 或正数。为 nil 时两个 T 互不相等且无大小之分
+	Compare func(a, b T) int
+}
+
+// hostOps 为 RegisterOverloads 注册的运算，实参与结果均为 T
+type hostOps struct {
+	add, sub func(a, b any) any
+	compare  func(a, b any) int
+}
+
+// overloads 为 RegisterOverloads 注册的运算，键为宿主值的动态类型
+var overloads = newTable(map[reflect.Type]*hostOps{})
+
+// RegisterOverloads 为 Go 类型 T 注册运算符重载，四种引擎的加减与比较遇到两个 T 时调用它们。
+// T 须为具体类型，且不能同时注册 RegisterAdapter：适配器把值转换为其他类型，重载不再生效。
+// 重复注册时后者覆盖前者，已编译的引擎随即使用新的运算
+func RegisterOverloads[T any](ov Overloads[T]) {
+	ops := &hostOps{}
+	if ov.Add != nil {
+		ops.add = func(a, b any) any { return ov.Add(a.(T), b.(T)) }
+	}
+	if ov.Sub != nil {
+		ops.sub = func(a, b any) any { return ov.Sub(a.(T), b.(T)) }
+	}
+	if ov.Compare != nil {
+		ops.compare = func(a, b any) int { return ov.Compare(a.(T), b.(T)) }
+	}
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	overloads.put(reflect.TypeFor[T](), ops)
+}
+
+// hostOpsOf 在 l 与 r 同为注册了重载的同一宿主类型时返回其运算
+func hostOpsOf(l, r Value) *hostOps {
+	if l.Type != ValObject || r.Type != ValObject {
+		return nil
+	}
+	ops := overloads.load()
+	if len(ops) == 0 {
+		return nil
+	}
+	t := reflect.TypeOf(l.Obj)
+	if reflect.TypeOf(r.Obj) != t {
+		return nil
+	}
+	return ops[t]
+}
+
+// addHost 以注册的 Add 计算 l + r，未重载时返回 false
+func addHost(l, r Value) (Value, bool) {
+	if ops := hostOpsOf(l, r); ops != nil && ops.add != nil {
+		return Value{Type: ValObject, Obj: ops.add(l.Obj, r.Obj)}, true
+	}
+	return Value{}, false
+}
+
+// subHost 以注册的 Sub 计算 l - r，未重载时返回 false
+func subHost(l, r Value) (Value, bool) {
+	if ops := hostOpsOf(l, r); ops != nil && ops.sub != nil {
+		return Value{Type: ValObject, Obj: ops.sub(l.Obj, r.Obj)}, true
+	}
+	return Value{}, false
+}
+
+// compareExtended 比较两个时间、两个时长、十进制数与数字或两个注册了 Compare 的宿主值，其他组合返回 false，
+// 由调用方按原有规则处理
+func compareExtended(l, r Value) (int, bool) {
+	if c, ok := compareTemporal(l, r); ok {
+		return c, true
+	}
+	if a, b, ok := decimalOperands(l, r); ok {
+		return a.Cmp(b), true
+	}
+	if ops := hostOpsOf(l, r); ops != nil && ops.compare != nil {
+		return ops.compare(l.Obj, r.Obj), true
+	}
+	return 0, false
+}
+
+// objectEqual 判断两个 ValObject 是否相等：注册了 Compare 时比较结果为 0 即相等，否则不相等
+func objectEqual(l, r Value) bool {
+	c, ok := compareExtended(l, r)
+	return ok && c == 0
+}
@@ -0,0 +1,62 @@
+package uwasa
+
+import (
+	"cmp"
+	"reflect"
+	"testing"
+)
+
+type testVersion struct{ major, minor, patch int }
+
+func TestRegisterOverloads(t *testing.T) {
+	RegisterOverloads(Overloads[testMoney]{
+		Add:     func(a, b testMoney) testMoney { return testMoney{a.cents + b.cents} },
+		Sub:     func(a, b testMoney) testMoney { return testMoney{a.cents - b.cents} },
+		Compare: func(a, b testMoney) int { return cmp.Compare(a.cents, b.cents) },
+	})
+	RegisterOverloads(Overloads[testVersion]{
+		Compare: func(a, b testVersion) int {
+			return cmp.Or(cmp.Compare(a.major, b.major), cmp.Compare(a.minor, b.minor), cmp.Compare(a.patch, b.patch))
+		},
+	})
+	t.Cleanup(func() {
+		overloads.del(reflect.TypeFor[testMoney]())
+		overloads.del(reflect.TypeFor[testVersion]())
+	})
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`price + shipping`, testMoney{1700}},
+		{`price - shipping - shipping`, testMoney{200}},
+		{`price + shipping > limit`, true},
+		{`price + shipping == limit + shipping`, false},
+		{`price - shipping <= limit`, true},
+		{`price != shipping && price == price`, true},
+		{`version >= min_version && version < max_version`, true},
+		{`version == min_version`, false},
+		{`max_version > version > min_version`, true},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{
+			"price":       testMoney{1200},
+			"shipping":    testMoney{500},
+			"limit":       testMoney{1500},
+			"version":     testVersion{1, 10, 2},
+			"min_version": testVersion{1, 9, 0},
+			"max_version": testVersion{2, 0, 0},
+		}
+	}
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				got, err := engine.ExecuteWithContext(ctx)
+				if err != nil || !reflect.DeepEqual(got, tt.expected) {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+}
@@ -4,8 +4,11 @@
 package uwasa
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -13,44 +16,86 @@ const (
 	_ int = iota
 	LOWEST
 	ASSIGN
+	PIPE
 	OR
 	AND
 	EQUALS
 	LESSGREATER
+	RANGE
+	BITOR
+	BITXOR
+	BITAND
+	SHIFT
 	SUM
 	PRODUCT
 	PREFIX
 	CALL
+	INDEX
 )
 
 func getPrecedence(t TokenType) int {
 	switch t {
 	case TokenAssign:
 		return ASSIGN
+	case TokenPipe:
+		return PIPE
 	case TokenOr:
 		return OR
 	case TokenAnd:
 		return AND
-	case TokenEq:
+	case TokenEq, TokenNotEq, TokenEqFold:
 		return EQUALS
-	case TokenGt, TokenLt, TokenGe, TokenLe:
+	case TokenGt, TokenLt, TokenGe, TokenLe, TokenIn:
 		return LESSGREATER
+	case TokenRange:
+		return RANGE
+	case TokenBitOr:
+		return BITOR
+	case TokenBitXor:
+		return BITXOR
+	case TokenBitAnd:
+		return BITAND
+	case TokenShl, TokenShr:
+		return SHIFT
 	case TokenPlus, TokenMinus:
 		return SUM
 	case TokenAsterisk, TokenSlash, TokenPercent:
 		return PRODUCT
 	case TokenLParen:
 		return CALL
+	case TokenLBracket, TokenDot, TokenOptDot:
+		return INDEX
 	default:
 		return LOWEST
 	}
 }
 
+// newStatement 判断 tok 是否开始新的语句。行首的 ( [ - 既可以延续上一行的表达式（调用、下标、减法），
+// 也可以开始新的语句，一律按后者处理；需要延续时把它们留在上一行末尾，或给表达式加括号
+func newStatement(tok Token) bool {
+	return tok.Newline && (tok.Type == TokenLParen || tok.Type == TokenLBracket || tok.Type == TokenMinus)
+}
+
 type Parser struct {
 	l      *Lexer
 	curTok Token
 	peekTok Token
 	errors []string
+	// locals 为当前可见的 let 绑定与函数参数，用于拒绝对其赋值
+	locals []string
+	// functions 为已定义的规则内函数，用于检查调用的参数个数；defining 为正在解析函数体的函数名
+	functions []*FunctionLiteral
+	defining  string
+	// lambdas 为已解析的 lambda 个数
+	lambdas int
+	// letStatement 表示当前的 let 位于语句开头，可以省略 => 写成 let 语句
+	letStatement bool
+	// inputDocument 对应 EngineOptions.InputDocument
+	inputDocument bool
+	// decimal 对应 EngineOptions.Decimal，开启时才接受十进制数字面量
+	decimal bool
+	// branches 为已解析的带分支的 if，常量折叠之后据此找出被移除的分支
+	branches []*IfExpression
 
 	prefixParseFns map[TokenType]prefixParseFn
 	infixParseFns  map[TokenType]infixParseFn
@@ -70,27 +115,50 @@ var parserPool = sync.Pool{
 		p.registerPrefix(TokenIdent, p.parseIdentifier)
 		p.registerPrefix(TokenNumber, p.parseNumberLiteral)
 		p.registerPrefix(TokenString, p.parseStringLiteral)
+		p.registerPrefix(TokenTime, p.parseTimeLiteral)
+		p.registerPrefix(TokenDuration, p.parseDurationLiteral)
+		p.registerPrefix(TokenDecimal, p.parseDecimalLiteral)
 		p.registerPrefix(TokenTrue, p.parseBooleanLiteral)
 		p.registerPrefix(TokenFalse, p.parseBooleanLiteral)
 		p.registerPrefix(TokenMinus, p.parsePrefixExpression)
 		p.registerPrefix(TokenBang, p.parsePrefixExpression)
 		p.registerPrefix(TokenLParen, p.parseGroupedExpression)
 		p.registerPrefix(TokenIf, p.parseIfExpression)
+		p.registerPrefix(TokenMatch, p.parseMatchExpression)
+		p.registerPrefix(TokenLet, p.parseLetExpression)
+		p.registerPrefix(TokenLBracket, p.parseArrayLiteral)
+		p.registerPrefix(TokenLBrace, p.parseMapLiteral)
+		p.registerPrefix(TokenOperator, p.parseOperatorPrefix)
 
 		p.registerInfix(TokenOr, p.parseInfixExpression)
 		p.registerInfix(TokenAnd, p.parseInfixExpression)
 		p.registerInfix(TokenEq, p.parseInfixExpression)
+		p.registerInfix(TokenEqFold, p.parseInfixExpression)
 		p.registerInfix(TokenGt, p.parseInfixExpression)
 		p.registerInfix(TokenLt, p.parseInfixExpression)
 		p.registerInfix(TokenGe, p.parseInfixExpression)
 		p.registerInfix(TokenLe, p.parseInfixExpression)
+		p.registerInfix(TokenIn, p.parseInfixExpression)
+		p.registerInfix(TokenNotEq, p.parseInfixExpression)
+		p.registerInfix(TokenBitAnd, p.parseInfixExpression)
+		p.registerInfix(TokenBitOr, p.parseInfixExpression)
+		p.registerInfix(TokenBitXor, p.parseInfixExpression)
+		p.registerInfix(TokenShl, p.parseInfixExpression)
+		p.registerInfix(TokenShr, p.parseInfixExpression)
 		p.registerInfix(TokenPlus, p.parseInfixExpression)
 		p.registerInfix(TokenMinus, p.parseInfixExpression)
 		p.registerInfix(TokenAsterisk, p.parseInfixExpression)
 		p.registerInfix(TokenSlash, p.parseInfixExpression)
 		p.registerInfix(TokenPercent, p.parseInfixExpression)
 		p.registerInfix(TokenLParen, p.parseCallExpression)
+		p.registerInfix(TokenLBracket, p.parseIndexExpression)
+		p.registerInfix(TokenDot, p.parseMethodCallExpression)
+		p.registerInfix(TokenOptDot, p.parseOptionalMemberExpression)
 		p.registerInfix(TokenAssign, p.parseAssignExpression)
+		p.registerInfix(TokenPipe, p.parsePipeExpression)
+		p.registerInfix(TokenRange, p.parseRangeExpression)
+		p.registerInfix(TokenOperator, p.parseOperatorInfix)
+		p.registerInfix(TokenIdent, p.parseLikeExpression)
 
 		return p
 	},
@@ -105,6 +173,13 @@ func NewParser(l *Lexer) *Parser {
 func (p *Parser) Reset(l *Lexer) {
 	p.l = l
 	p.errors = p.errors[:0]
+	p.locals = p.locals[:0]
+	p.functions = p.functions[:0]
+	p.lambdas = 0
+	p.letStatement = false
+	p.inputDocument = false
+	p.decimal = false
+	p.branches = p.branches[:0]
 	p.nextToken()
 	p.nextToken()
 }
@@ -117,12 +192,12 @@ func (p *Parser) nextToken() {
 func (p *Parser) parseExpression(precedence int) Expression {
 	prefix := p.prefixParseFns[p.curTok.Type]
 	if prefix == nil {
-		p.noPrefixParseFnError(p.curTok.Type)
+		p.noPrefixParseFnError(p.curTok)
 		return nil
 	}
 	leftExp := prefix()
 
-	for !p.peekTokenIs(TokenEOF) && precedence < p.peekPrecedence() {
+	for !p.peekTokenIs(TokenEOF) && precedence < p.peekPrecedence() && !newStatement(p.peekTok) {
 		infix := p.infixParseFns[p.peekTok.Type]
 		if infix == nil {
 			return leftExp
@@ -144,29 +219,127 @@ func (p *Parser) registerInfix(tokenType TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) peekPrecedence() int {
-	return getPrecedence(p.peekTok.Type)
+	return tokenPrecedence(p.peekTok)
 }
 
 func (p *Parser) curPrecedence() int {
-	return getPrecedence(p.curTok.Type)
+	return tokenPrecedence(p.curTok)
 }
 
 func (p *Parser) parseIdentifier() Expression {
-	return &Identifier{Value: p.curTok.Literal}
+	ident := &Identifier{Value: p.curTok.Literal}
+	// score 不是保留字：只有同一行紧跟 { 时才是加权求和，其余位置仍是普通的变量名
+	if ident.Value == "score" && p.peekTokenIs(TokenLBrace) && !p.peekTok.Newline {
+		return p.parseScore()
+	}
+	if p.inputDocument && ident.Value == "input" && !slices.Contains(p.locals, "input") {
+		return p.parseInputPath()
+	}
+	if p.peekTokenIs(TokenLambda) {
+		p.nextToken()
+		return p.parseLambdaLiteral([]*Identifier{ident})
+	}
+	return ident
+}
+
+// parseInputPath 解析输入文档模式下的 `input.name`：上下文本身就是输入文档，input.name 即变量 name
+func (p *Parser) parseInputPath() Expression {
+	if !p.peekTokenIs(TokenDot) {
+		p.errors = append(p.errors, "input must be followed by a field, e.g. input.user")
+		return nil
+	}
+	p.nextToken()
+	if !p.expectPeek(TokenIdent) {
+		return nil
+	}
+	name := p.curTok.Literal
+	if err := inputPathError(name, slices.Contains(p.locals, name), p.peekTokenIs(TokenLParen)); err != nil {
+		p.errors = append(p.errors, err.Error())
+		return nil
+	}
+	return &Identifier{Value: name}
+}
+
+// inputPathError 检查 input.name 能否读作变量 name，两种前端共用：同名的 let 绑定或参数会遮蔽该变量，
+// input.name(...) 也不是函数调用
+func inputPathError(name string, shadowed, call bool) error {
+	switch {
+	case shadowed:
+		return fmt.Errorf("input.%s is shadowed by a let binding or parameter named %s", name, name)
+	case call:
+		return fmt.Errorf("input.%s is not a function", name)
+	}
+	return nil
+}
+
+// errLocalCall 拒绝调用 let 绑定或参数，两种前端共用。lambda 只能作为实参传给 map、filter 等内置函数，
+// 同名的绑定也不会退回到内置函数，否则 `let len = x -> 0 => len(s)` 会悄悄调用内置的 len
+func errLocalCall(name string) error {
+	return fmt.Errorf("%s is a let binding or parameter, not a function; lambdas can only be passed to builtins", name)
 }
 
 func (p *Parser) parseNumberLiteral() Expression {
-	if i, err := strconv.ParseInt(p.curTok.Literal, 10, 64); err == nil {
-		return &NumberLiteral{Int64Value: i, IsInt: true}
+	n, err := parseNumber(p.curTok.Literal)
+	if err != nil {
+		p.errors = append(p.errors, err.Error())
+		return nil
+	}
+	return n
+}
+
+// parseNumber 解析数字记号，两种前端共用。0x、0b、0o 开头的为十六、二、八进制整数；
+// 十进制数中的 _ 必须位于两个数字之间。超出 int64 范围的十进制整数按浮点数处理，前缀形式则报错
+func parseNumber(lit string) (*NumberLiteral, error) {
+	if len(lit) > 2 && lit[0] == '0' && isBasePrefix(lit[1]) {
+		if i, err := strconv.ParseInt(lit, 0, 64); err == nil {
+			return &NumberLiteral{Int64Value: i, IsInt: true}, nil
+		}
+		return nil, fmt.Errorf("could not parse %q as number", lit)
+	}
+	digits := lit
+	for i := 0; i < len(lit); i++ {
+		if lit[i] == '_' && (i == 0 || i == len(lit)-1 || !isDigit(lit[i-1]) || !isDigit(lit[i+1])) {
+			return nil, fmt.Errorf("could not parse %q as number: _ must separate digits", lit)
+		}
+	}
+	if strings.Contains(lit, "_") {
+		digits = strings.ReplaceAll(lit, "_", "")
+	}
+	if i, err := strconv.ParseInt(digits, 10, 64); err == nil {
+		return &NumberLiteral{Int64Value: i, IsInt: true}, nil
+	}
+	f, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q as number", lit)
+	}
+	return &NumberLiteral{Float64Value: f}, nil
+}
+
+func (p *Parser) parseTimeLiteral() Expression {
+	t, err := parseTime(p.curTok.Literal)
+	if err != nil {
+		p.errors = append(p.errors, err.Error())
+		return nil
+	}
+	return t
+}
+
+func (p *Parser) parseDurationLiteral() Expression {
+	d, err := parseDuration(p.curTok.Literal)
+	if err != nil {
+		p.errors = append(p.errors, err.Error())
+		return nil
 	}
+	return d
+}
 
-	val, err := strconv.ParseFloat(p.curTok.Literal, 64)
+func (p *Parser) parseDecimalLiteral() Expression {
+	d, err := parseDecimalLiteral(p.curTok.Literal, p.decimal)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as number", p.curTok.Literal)
-		p.errors = append(p.errors, msg)
+		p.errors = append(p.errors, err.Error())
 		return nil
 	}
-	return &NumberLiteral{Float64Value: val, IsInt: false}
+	return d
 }
 
 func (p *Parser) parseStringLiteral() Expression {
@@ -191,14 +364,63 @@ func (p *Parser) parseInfixExpression(left Expression) Expression {
 		Operator: p.curTok.Literal,
 		Left:     left,
 	}
+	opType := p.curTok.Type
 	precedence := p.curPrecedence()
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
+	if isOrderingToken(opType) && isOrderingToken(p.peekTok.Type) {
+		return p.parseComparisonChain(expression)
+	}
 	return expression
 }
 
+// parseComparisonChain 将 `lo <= x < hi` 展开为 `(lo <= x) && (x < hi)`。
+// 中间操作数会被求值两次，因此只允许变量或字面量。
+func (p *Parser) parseComparisonChain(first *InfixExpression) Expression {
+	var chain Expression = first
+	prev := first
+	for isOrderingToken(p.peekTok.Type) {
+		if !isChainOperand(prev.Right) {
+			p.errors = append(p.errors, "chained comparison operand must be a variable or literal")
+			return nil
+		}
+		p.nextToken()
+		next := &InfixExpression{Operator: p.curTok.Literal, Left: prev.Right}
+		p.nextToken()
+		next.Right = p.parseExpression(LESSGREATER)
+		chain = &InfixExpression{Operator: "&&", Left: chain, Right: next}
+		prev = next
+	}
+	return chain
+}
+
+// isOrderingToken 判断 t 是否为可链式书写的大小比较运算符
+func isOrderingToken(t TokenType) bool {
+	return t == TokenLt || t == TokenLe || t == TokenGt || t == TokenGe
+}
+
+func isChainOperandToken(t TokenType) bool {
+	switch t {
+	case TokenIdent, TokenNumber, TokenString, TokenTrue, TokenFalse:
+		return true
+	}
+	return false
+}
+
+func isChainOperand(e Expression) bool {
+	switch e.(type) {
+	case *Identifier, *NumberLiteral, *StringLiteral, *BooleanLiteral:
+		return true
+	}
+	return false
+}
+
 func (p *Parser) parseGroupedExpression() Expression {
 	p.nextToken()
+	// `()` 与 `(x, ...` 不是合法的分组表达式，只能是 lambda 的参数表
+	if p.curTokenIs(TokenRParen) || (p.curTokenIs(TokenIdent) && p.peekTokenIs(TokenComma)) {
+		return p.parseLambdaParameters()
+	}
 	exp := p.parseExpression(LOWEST)
 	if !p.expectPeek(TokenRParen) {
 		return nil
@@ -209,9 +431,220 @@ func (p *Parser) parseGroupedExpression() Expression {
 func (p *Parser) parseCallExpression(function Expression) Expression {
 	exp := &CallExpression{Function: function}
 	exp.Arguments = p.parseExpressionList(TokenRParen)
+	return p.checkCall(exp)
+}
+
+// checkCall 检查对规则内函数的调用的参数个数，并拒绝函数体中对自身的调用与对局部绑定的调用
+func (p *Parser) checkCall(exp *CallExpression) Expression {
+	if ident, ok := exp.Function.(*Identifier); ok {
+		if ident.Value == p.defining {
+			p.errors = append(p.errors, fmt.Sprintf("fn %s is not defined yet; recursion is not supported", ident.Value))
+		} else if fn := p.function(ident.Value); fn != nil && len(exp.Arguments) != len(fn.Parameters) {
+			p.errors = append(p.errors, fmt.Sprintf("function %s expects %d arguments, got %d", ident.Value, len(fn.Parameters), len(exp.Arguments)))
+		} else if fn == nil && slices.Contains(p.locals, ident.Value) {
+			p.errors = append(p.errors, errLocalCall(ident.Value).Error())
+		}
+	}
+	return exp
+}
+
+// parseOperatorPrefix 把注册的前缀运算符 `op x` 改写为对其内置函数的调用 `builtin(x)`
+func (p *Parser) parseOperatorPrefix() Expression {
+	op, _ := operators.get(p.curTok.Literal)
+	if op.prefix == "" {
+		p.errors = append(p.errors, fmt.Sprintf("%s is not a prefix operator", p.curTok.Literal))
+		return nil
+	}
+	p.nextToken()
+	right := p.parseExpression(PREFIX)
+	return &CallExpression{Function: &Identifier{Value: op.prefix}, Arguments: []Expression{right}}
+}
+
+// parseOperatorInfix 把注册的中缀运算符 `a op b` 改写为 `builtin(a, b)`
+func (p *Parser) parseOperatorInfix(left Expression) Expression {
+	op, _ := operators.get(p.curTok.Literal)
+	p.nextToken()
+	right := p.parseExpression(op.precedence)
+	return &CallExpression{Function: &Identifier{Value: op.infix}, Arguments: []Expression{left, right}}
+}
+
+// parseLikeExpression 把 `s like pattern` 改写为 `glob(s, pattern)`。只有 like 会以标识符出现在中缀位置
+func (p *Parser) parseLikeExpression(left Expression) Expression {
+	p.nextToken()
+	right := p.parseExpression(LESSGREATER)
+	return &CallExpression{Function: &Identifier{Value: "glob"}, Arguments: []Expression{left, right}}
+}
+
+// errTryPipe 拒绝 `expr |> try(fallback)`：管道左侧在调用之前求值，try 保护不到它
+var errTryPipe = errors.New("try cannot follow |>, write try(expr, fallback)")
+
+// parsePipeExpression 把 `value |> f(args)` 改写为 `f(value, args)`，`value |> f` 改写为 `f(value)`。
+// 管道的优先级仅高于赋值，左侧取到整个表达式，如 `a + b |> f` 即 `f(a + b)`
+func (p *Parser) parsePipeExpression(left Expression) Expression {
+	if !p.expectPeek(TokenIdent) {
+		return nil
+	}
+	function := &Identifier{Value: p.curTok.Literal}
+	if function.Value == "try" {
+		p.errors = append(p.errors, errTryPipe.Error())
+	}
+	if !p.peekTokenIs(TokenLParen) {
+		return p.checkCall(&CallExpression{Function: function, Arguments: []Expression{left}})
+	}
+	p.nextToken()
+	args := p.parseExpressionList(TokenRParen)
+	return p.checkCall(&CallExpression{Function: function, Arguments: append([]Expression{left}, args...)})
+}
+
+// parseArrayLiteral 解析 `[a, ...b, c]`，元素之前的 ... 表示展开
+func (p *Parser) parseArrayLiteral() Expression {
+	arr := &ArrayLiteral{Elements: []Expression{}}
+	if p.peekTokenIs(TokenRBracket) {
+		p.nextToken()
+		return arr
+	}
+	if name, ok := comprehensionAhead(*p.l, p.peekTok); ok {
+		return p.parseComprehension(name)
+	}
+	for {
+		p.nextToken()
+		if p.curTokenIs(TokenSpread) {
+			p.nextToken()
+			arr.Elements = append(arr.Elements, &SpreadElement{Value: p.parseExpression(LOWEST)})
+		} else {
+			arr.Elements = append(arr.Elements, p.parseExpression(LOWEST))
+		}
+		if !p.peekTokenIs(TokenComma) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(TokenRBracket) {
+		return nil
+	}
+	return arr
+}
+
+// parseComprehension 解析数组推导式 `[expr for x in items if cond]`，当前记号为 `[`，name 为 for 之后的变量名。
+// 推导式在解析期改写为 `map(filter(items, x -> cond), x -> expr)`：没有 if 时省去 filter，有 if 且 expr 就是 x 时省去 map。
+// 因此先对全部元素求值 cond，再对保留的元素求值 expr；items 中看不到 x
+func (p *Parser) parseComprehension(name string) Expression {
+	param := []*Identifier{{Value: name}}
+	mapper, ok := p.parseLambdaLiteral(param).(*LambdaLiteral)
+	if !ok || !p.expectPeek(TokenFor) || !p.expectPeek(TokenIdent) || !p.expectPeek(TokenIn) {
+		return nil
+	}
+	p.nextToken()
+	items := p.parseExpression(LOWEST)
+	filtered := p.peekTokenIs(TokenIf)
+	if filtered {
+		p.nextToken()
+		cond := p.parseLambdaLiteral(param)
+		if cond == nil {
+			return nil
+		}
+		items = &CallExpression{Function: &Identifier{Value: "filter"}, Arguments: []Expression{items, cond}, Comprehension: true}
+	}
+	if !p.expectPeek(TokenRBracket) {
+		return nil
+	}
+	if ident, ok := mapper.Body.(*Identifier); ok && ident.Value == name && filtered {
+		return items
+	}
+	return &CallExpression{Function: &Identifier{Value: "map"}, Arguments: []Expression{items, mapper}, Comprehension: !filtered}
+}
+
+func (p *Parser) parseMapLiteral() Expression {
+	exp := &MapLiteral{}
+	if p.peekTokenIs(TokenRBrace) {
+		p.nextToken()
+		return exp
+	}
+	p.nextToken()
+	// `{a, b} = m` 是解构赋值：第一个成员是其后紧跟 , 或 } 的名称
+	if p.curTokenIs(TokenIdent) && (p.peekTokenIs(TokenComma) || p.peekTokenIs(TokenRBrace)) {
+		return p.parseDestructure()
+	}
+	// 以 let 开头、或第一项之后不是 : 的是块表达式
+	if p.curTokenIs(TokenLet) {
+		return p.parseBlock(nil)
+	}
+	for {
+		key := p.parseExpression(LOWEST)
+		if len(exp.Keys) == 0 && !p.peekTokenIs(TokenColon) {
+			return p.parseBlock(key)
+		}
+		if !p.expectPeek(TokenColon) {
+			return nil
+		}
+		p.nextToken()
+		exp.Keys = append(exp.Keys, key)
+		exp.Values = append(exp.Values, p.parseExpression(LOWEST))
+		if !p.peekTokenIs(TokenComma) {
+			break
+		}
+		p.nextToken()
+		p.nextToken()
+	}
+	if !p.expectPeek(TokenRBrace) {
+		return nil
+	}
+	return exp
+}
+
+// parseBlock 解析块表达式 `{ 语句; ... }`，first 为已解析的第一条语句，为 nil 时从当前记号开始解析。
+// 括号内的换行不分隔语句，因此块内的语句只能以分号分隔。块的值为最后一条语句的值，块内 let 语句的绑定只在块内可见
+func (p *Parser) parseBlock(first Expression) Expression {
+	body := p.parseStatements(TokenRBrace, first)
+	if body == nil || len(p.errors) > 0 || !p.expectPeek(TokenRBrace) {
+		return nil
+	}
+	return body
+}
+
+func (p *Parser) parseIndexExpression(left Expression) Expression {
+	exp := &IndexExpression{Left: left}
+	p.nextToken()
+	exp.Index = p.parseExpression(LOWEST)
+	if !p.expectPeek(TokenRBracket) {
+		return nil
+	}
+	return exp
+}
+
+func (p *Parser) parseMethodCallExpression(receiver Expression) Expression {
+	if !p.expectPeek(TokenIdent) {
+		return nil
+	}
+	if p.inputDocument && !p.peekTokenIs(TokenLParen) {
+		// 输入文档模式下不带括号的 .name 是成员访问，与 ?.name 相同，路径缺失时得到 nil
+		return &OptionalMemberExpression{Receiver: receiver, Name: p.curTok.Literal}
+	}
+	exp := &MethodCallExpression{Receiver: receiver, Method: p.curTok.Literal}
+	if !p.expectPeek(TokenLParen) {
+		return nil
+	}
+	exp.Arguments = p.parseExpressionList(TokenRParen)
 	return exp
 }
 
+func (p *Parser) parseOptionalMemberExpression(receiver Expression) Expression {
+	if !p.expectPeek(TokenIdent) {
+		return nil
+	}
+	return &OptionalMemberExpression{Receiver: receiver, Name: p.curTok.Literal}
+}
+
+// parseRangeExpression 解析 `start..end`。区间不能再作为区间的一端，`1..2..3` 报错
+func (p *Parser) parseRangeExpression(left Expression) Expression {
+	if _, ok := left.(*RangeExpression); ok {
+		p.errors = append(p.errors, "range bound cannot be a range")
+		return nil
+	}
+	p.nextToken()
+	return &RangeExpression{Start: left, End: p.parseExpression(RANGE)}
+}
+
 func (p *Parser) parseExpressionList(end TokenType) []Expression {
 	list := []Expression{}
 
@@ -236,10 +669,90 @@ func (p *Parser) parseExpressionList(end TokenType) []Expression {
 	return list
 }
 
+// parseScore 解析 `score { cond: weight, ... }`，当前记号为 score。权重可以带负号
+func (p *Parser) parseScore() Expression {
+	exp := &ScoreExpression{}
+	p.nextToken()
+	for !p.peekTokenIs(TokenRBrace) {
+		if len(exp.Conditions) > 0 && !p.expectPeek(TokenComma) {
+			return nil
+		}
+		p.nextToken()
+		exp.Conditions = append(exp.Conditions, p.parseExpression(LOWEST))
+		if !p.expectPeek(TokenColon) {
+			return nil
+		}
+		p.nextToken()
+		neg := p.curTokenIs(TokenMinus)
+		if neg {
+			p.nextToken()
+		}
+		if !p.curTokenIs(TokenNumber) {
+			p.errors = append(p.errors, fmt.Sprintf("score weight must be a number, got %s", p.curTok.Type))
+			return nil
+		}
+		w, ok := p.parseNumberLiteral().(*NumberLiteral)
+		if !ok {
+			return nil
+		}
+		if neg {
+			w.Int64Value, w.Float64Value = -w.Int64Value, -w.Float64Value
+		}
+		exp.Weights = append(exp.Weights, w)
+	}
+	p.nextToken()
+	return exp
+}
+
+// parseDestructure 解析 `{a, b} = m` 与 `{a, b} = m => body`，当前记号为第一个名称
+func (p *Parser) parseDestructure() Expression {
+	exp := &DestructureExpression{}
+	for {
+		name := p.curTok.Literal
+		if slices.Contains(p.locals, name) {
+			p.errors = append(p.errors, fmt.Sprintf("cannot assign to let binding %s", name))
+			return nil
+		}
+		if slices.ContainsFunc(exp.Names, func(id *Identifier) bool { return id.Value == name }) {
+			p.errors = append(p.errors, fmt.Sprintf("duplicate name %s in destructuring", name))
+			return nil
+		}
+		exp.Names = append(exp.Names, &Identifier{Value: name})
+		if !p.peekTokenIs(TokenComma) {
+			break
+		}
+		p.nextToken()
+		if !p.expectPeek(TokenIdent) {
+			return nil
+		}
+	}
+	if !p.expectPeek(TokenRBrace) || !p.expectPeek(TokenAssign) {
+		return nil
+	}
+	p.nextToken()
+	exp.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(TokenArrow) {
+		p.nextToken()
+		p.nextToken()
+		exp.Body = p.parseExpression(LOWEST)
+	}
+	return exp
+}
+
 func (p *Parser) parseAssignExpression(left Expression) Expression {
+	if idx, ok := left.(*IndexExpression); ok {
+		expression := &IndexAssignExpression{Left: idx.Left, Index: idx.Index}
+		p.nextToken()
+		expression.Value = p.parseExpression(LOWEST)
+		return expression
+	}
 	ident, ok := left.(*Identifier)
 	if !ok {
-		p.errors = append(p.errors, "left side of assignment must be an identifier")
+		p.errors = append(p.errors, "left side of assignment must be an identifier or index expression")
+		return nil
+	}
+	if slices.Contains(p.locals, ident.Value) {
+		p.errors = append(p.errors, fmt.Sprintf("cannot assign to let binding %s", ident.Value))
 		return nil
 	}
 	expression := &AssignExpression{Name: ident}
@@ -255,11 +768,18 @@ func (p *Parser) parseIfExpression() Expression {
 
 	if p.peekTokenIs(TokenIs) {
 		p.nextToken() // cur is 'is'
+		start := p.curTok.Pos
 		p.nextToken() // move to expression after 'is'
 		expression.Consequence = p.parseExpression(LOWEST)
+		expression.ConsequenceSpan = Span{Start: start, End: p.curTok.End}
 		expression.IsThen = false
 
-		if p.peekTokenIs(TokenElse) {
+		start = p.peekTok.Pos
+		if p.peekTokenIs(TokenElif) {
+			// `elif` 等价于 `else if`
+			p.nextToken() // cur is 'elif'
+			expression.Alternative = p.parseIfExpression()
+		} else if p.peekTokenIs(TokenElse) {
 			p.nextToken() // cur is 'else'
 			if p.peekTokenIs(TokenIf) {
 				p.nextToken() // cur is 'if'
@@ -274,20 +794,142 @@ func (p *Parser) parseIfExpression() Expression {
 				p.errors = append(p.errors, "expected 'if' or 'is' after 'else'")
 			}
 		}
+		if expression.Alternative != nil {
+			expression.AlternativeSpan = Span{Start: start, End: p.curTok.End}
+		}
 	} else if p.peekTokenIs(TokenThen) {
 		p.nextToken() // cur is 'then'
+		start := p.curTok.Pos
 		p.nextToken() // move to expression after 'then'
 		expression.Consequence = p.parseExpression(LOWEST)
+		expression.ConsequenceSpan = Span{Start: start, End: p.curTok.End}
 		expression.IsThen = true
 		// No 'else' mentioned for 'then' in spec, but we could support it
 	} else {
 		// Simple 'if <cond>'
 		expression.IsSimple = true
+		return expression
 	}
 
+	p.branches = append(p.branches, expression)
 	return expression
 }
 
+// matchSubject 是 match 的被匹配值不是变量时隐含的 let 绑定名，词法上不是标识符，不会与规则中的名字冲突
+const matchSubject = "$match"
+
+// parseMatchExpression 将 `match x { 1 => "a", 2 => "b", _ => "c" }` 展开为
+// `if x == 1 is "a" else if x == 2 is "b" else is "c"`，标准 VM 可据此生成跳转表。
+// 被匹配的值不是变量时，如 `match len(s) { ... }`，先求值一次绑定到隐含的 let，再由各分支比较；
+// `_` 为默认分支，须位于最后，省略时不匹配返回 nil。
+func (p *Parser) parseMatchExpression() Expression {
+	p.nextToken()
+	value := p.parseExpression(LOWEST)
+	if value == nil {
+		return nil
+	}
+	if !p.expectPeek(TokenLBrace) {
+		return nil
+	}
+	subject, ok := value.(*Identifier)
+	if !ok {
+		if len(p.locals) >= maxLetBindings {
+			p.errors = append(p.errors, fmt.Sprintf("too many nested let bindings (max %d)", maxLetBindings))
+			return nil
+		}
+		subject = &Identifier{Value: matchSubject}
+		p.locals = append(p.locals, matchSubject)
+		defer func() { p.locals = p.locals[:len(p.locals)-1] }()
+		arms := p.parseMatchArms(subject)
+		if arms == nil {
+			return nil
+		}
+		return &LetExpression{Name: subject, Value: value, Body: arms}
+	}
+	return p.parseMatchArms(subject)
+}
+
+// parseMatchArms 解析 match 的分支，当前记号为 `{`，各分支与 subject 比较
+func (p *Parser) parseMatchArms(subject *Identifier) Expression {
+	var head, def Expression
+	var tail *IfExpression
+	for !p.peekTokenIs(TokenRBrace) {
+		if def != nil {
+			p.errors = append(p.errors, "match default arm _ must be the last arm")
+			return nil
+		}
+		p.nextToken()
+		isDefault := p.curTokenIs(TokenIdent) && p.curTok.Literal == "_"
+		var pattern Expression
+		if !isDefault {
+			pattern = p.parseExpression(LOWEST)
+		}
+		if !p.expectPeek(TokenArrow) {
+			return nil
+		}
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+		if isDefault {
+			def = value
+		} else {
+			arm := &IfExpression{Condition: &InfixExpression{Operator: "==", Left: subject, Right: pattern}, Consequence: value}
+			if tail == nil {
+				head = arm
+			} else {
+				tail.Alternative = arm
+			}
+			tail = arm
+		}
+		if p.peekTokenIs(TokenComma) {
+			p.nextToken()
+		} else if !p.peekTokenIs(TokenRBrace) {
+			p.peekError(TokenRBrace)
+			return nil
+		}
+	}
+	p.nextToken() // cur is '}'
+	if head == nil {
+		if def == nil {
+			p.errors = append(p.errors, "match requires at least one arm")
+		}
+		return def
+	}
+	tail.Alternative = def
+	return head
+}
+
+// parseLetExpression 解析 `let tmp = a * 2 => tmp + 1`。绑定的作用域是 => 之后的整个表达式，
+// 内层同名绑定遮蔽外层；绑定不可再赋值。
+// 位于语句开头、值之后紧接着分号或换行时为 let 语句，返回 Body 为 nil 的节点，由 parseStatements 补全
+func (p *Parser) parseLetExpression() Expression {
+	statement := p.letStatement
+	p.letStatement = false
+	if !p.expectPeek(TokenIdent) {
+		return nil
+	}
+	name := &Identifier{Value: p.curTok.Literal}
+	if !p.expectPeek(TokenAssign) {
+		return nil
+	}
+	p.nextToken()
+	value := p.parseExpression(LOWEST)
+	if len(p.locals) >= maxLetBindings {
+		p.errors = append(p.errors, fmt.Sprintf("too many nested let bindings (max %d)", maxLetBindings))
+		return nil
+	}
+	if statement && (p.peekTokenIs(TokenSemicolon) || p.peekTokenIs(TokenEOF) || p.peekTok.Newline) {
+		return &LetExpression{Name: name, Value: value}
+	}
+	if !p.expectPeek(TokenArrow) {
+		return nil
+	}
+	p.nextToken()
+	p.locals = append(p.locals, name.Value)
+	body := p.parseExpression(LOWEST)
+	p.locals = p.locals[:len(p.locals)-1]
+	return &LetExpression{Name: name, Value: value, Body: body}
+}
+
 func (p *Parser) peekTokenIs(t TokenType) bool {
 	return p.peekTok.Type == t
 }
@@ -315,11 +957,246 @@ func (p *Parser) peekError(t TokenType) {
 	p.errors = append(p.errors, msg)
 }
 
-func (p *Parser) noPrefixParseFnError(t TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
+func (p *Parser) noPrefixParseFnError(tok Token) {
+	msg := fmt.Sprintf("no prefix parse function for %s found", tok.Type)
+	if tok.Type == TokenIllegal {
+		msg = fmt.Sprintf("illegal token %q", tok.Literal)
+	}
 	p.errors = append(p.errors, msg)
 }
 
 func (p *Parser) ParseProgram() Expression {
-	return p.parseExpression(LOWEST)
+	var functions []*FunctionLiteral
+	for p.curTokenIs(TokenFn) {
+		fn := p.parseFunctionLiteral()
+		if fn == nil {
+			return nil
+		}
+		// 函数定义以分号或换行结束
+		if p.peekTokenIs(TokenSemicolon) {
+			p.nextToken()
+		} else if !p.peekTok.Newline {
+			p.peekError(TokenSemicolon)
+			return nil
+		}
+		p.nextToken()
+		functions = append(functions, fn)
+	}
+	body := p.parseStatements(TokenEOF, nil)
+	if len(functions) == 0 {
+		return body
+	}
+	return &Program{Functions: functions, Body: body}
+}
+
+// parseStatements 解析规则主体或块中直到 end 为止的语句：以分号或换行分隔，值为最后一条语句的值；只有一条语句时直接返回该语句。
+// first 不为 nil 时为已解析的第一条语句。元组只能是规则主体的最后一条语句。
+// let 语句（省略 =>）的绑定在其后的全部语句中可见，解析为以其后语句为 Body 的 LetExpression
+func (p *Parser) parseStatements(end TokenType, first Expression) Expression {
+	var stmts []Expression
+	for {
+		stmt := first
+		if first == nil {
+			p.letStatement = p.curTokenIs(TokenLet)
+			stmt = p.parseTuple()
+		}
+		first = nil
+		if let, ok := stmt.(*LetExpression); ok && let.Body == nil {
+			if !p.nextStatement(end) {
+				p.errors = append(p.errors, fmt.Sprintf("let %s must be followed by a statement", let.Name.Value))
+				return nil
+			}
+			p.locals = append(p.locals, let.Name.Value)
+			let.Body = p.parseStatements(end, nil)
+			if let.Body == nil {
+				return nil
+			}
+			p.locals = p.locals[:len(p.locals)-1]
+			last := let.Body
+			if seq, ok := last.(*SequenceExpression); ok {
+				last = seq.Statements[len(seq.Statements)-1]
+			}
+			if _, ok := last.(*TupleExpression); ok {
+				p.errors = append(p.errors, fmt.Sprintf("tuple cannot follow let %s statement", let.Name.Value))
+				return nil
+			}
+			stmt = let
+		}
+		stmts = append(stmts, stmt)
+		if _, ok := stmt.(*TupleExpression); ok && end != TokenEOF {
+			p.errors = append(p.errors, "tuple cannot appear in a block")
+			return nil
+		}
+		if !p.nextStatement(end) {
+			break
+		}
+		if _, ok := stmt.(*TupleExpression); ok {
+			p.errors = append(p.errors, "tuple must be the last statement")
+			return nil
+		}
+	}
+	if len(stmts) == 1 {
+		return stmts[0]
+	}
+	return &SequenceExpression{Statements: stmts}
+}
+
+// tokenStream 是 Parser 与 NeoCompiler 共有的记号读取操作，两者经 nextStatement 以相同的规则处理语句分隔符
+type tokenStream interface {
+	peek() Token
+	nextToken()
+}
+
+func (p *Parser) peek() Token { return p.peekTok }
+
+// nextStatement 跳过语句之间的分隔符并移到下一条语句的开头；下一个记号为 end 或输入结尾时没有下一条语句，返回 false。
+// 末尾的分号可以省略；语句之后既不是分隔符也不是 end 时报错
+func nextStatement(ts tokenStream, end TokenType) (bool, error) {
+	switch tok := ts.peek(); {
+	case tok.Type == TokenSemicolon:
+		ts.nextToken()
+		if next := ts.peek().Type; next == end || next == TokenEOF {
+			return false, nil
+		}
+	case tok.Type == end, tok.Type == TokenEOF:
+		return false, nil
+	case !tok.Newline:
+		return false, fmt.Errorf("unexpected %s after expression", tok.Type)
+	}
+	ts.nextToken()
+	return true, nil
+}
+
+// nextStatement 以 Parser 的方式报告 nextStatement 的错误：只记录第一个错误
+func (p *Parser) nextStatement(end TokenType) bool {
+	more, err := nextStatement(p, end)
+	if err != nil && len(p.errors) == 0 {
+		p.errors = append(p.errors, err.Error())
+	}
+	return more
+}
+
+// parseTuple 解析规则主体：单个表达式，或顶层以逗号分隔的元组
+func (p *Parser) parseTuple() Expression {
+	exp := p.parseExpression(LOWEST)
+	if !p.peekTokenIs(TokenComma) {
+		return exp
+	}
+	tuple := &TupleExpression{Elements: []Expression{exp}}
+	for p.peekTokenIs(TokenComma) {
+		p.nextToken()
+		p.nextToken()
+		tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+	}
+	return tuple
+}
+
+// parseFunctionLiteral 解析 `fn name(a, b) => body`。参数与 let 绑定一样不可赋值，
+// 并计入同时可见的绑定数量；函数体中只能调用此前定义的函数，不能递归调用自身。
+func (p *Parser) parseFunctionLiteral() *FunctionLiteral {
+	if !p.expectPeek(TokenIdent) {
+		return nil
+	}
+	name := p.curTok.Literal
+	switch _, builtin := builtins.get(name); {
+	case builtin:
+		p.errors = append(p.errors, fmt.Sprintf("function %s shadows a builtin", name))
+		return nil
+	case p.function(name) != nil:
+		p.errors = append(p.errors, fmt.Sprintf("function %s already defined", name))
+		return nil
+	case len(p.functions) >= maxFunctions:
+		p.errors = append(p.errors, fmt.Sprintf("too many functions (max %d)", maxFunctions))
+		return nil
+	}
+	fn := &FunctionLiteral{Name: &Identifier{Value: name}}
+	defer func() { p.locals = p.locals[:0] }()
+	if !p.expectPeek(TokenLParen) {
+		return nil
+	}
+	for !p.peekTokenIs(TokenRParen) {
+		if len(fn.Parameters) > 0 && !p.expectPeek(TokenComma) {
+			return nil
+		}
+		if !p.expectPeek(TokenIdent) {
+			return nil
+		}
+		if slices.Contains(p.locals, p.curTok.Literal) {
+			p.errors = append(p.errors, fmt.Sprintf("duplicate parameter %s in function %s", p.curTok.Literal, name))
+			return nil
+		}
+		fn.Parameters = append(fn.Parameters, &Identifier{Value: p.curTok.Literal})
+		p.locals = append(p.locals, p.curTok.Literal)
+	}
+	p.nextToken()
+	if len(fn.Parameters) > maxLetBindings {
+		p.errors = append(p.errors, fmt.Sprintf("too many parameters in function %s (max %d)", name, maxLetBindings))
+		return nil
+	}
+	if !p.expectPeek(TokenArrow) {
+		return nil
+	}
+	p.nextToken()
+	p.defining = name
+	fn.Body = p.parseExpression(LOWEST)
+	p.defining = ""
+	p.functions = append(p.functions, fn)
+	return fn
+}
+
+// parseLambdaParameters 解析括号中的 lambda 参数表，当前记号为 `)` 或第一个参数
+func (p *Parser) parseLambdaParameters() Expression {
+	var params []*Identifier
+	if p.curTokenIs(TokenIdent) {
+		params = append(params, &Identifier{Value: p.curTok.Literal})
+		for p.peekTokenIs(TokenComma) {
+			p.nextToken()
+			if !p.expectPeek(TokenIdent) {
+				return nil
+			}
+			params = append(params, &Identifier{Value: p.curTok.Literal})
+		}
+		if !p.expectPeek(TokenRParen) {
+			return nil
+		}
+	}
+	if !p.expectPeek(TokenLambda) {
+		return nil
+	}
+	return p.parseLambdaLiteral(params)
+}
+
+// parseLambdaLiteral 解析 `->` 之后的函数体。参数与 let 绑定一样不可赋值；创建处可见的绑定
+// 被函数体按值捕获，因此与参数一起计入同时可见的绑定数量。
+func (p *Parser) parseLambdaLiteral(params []*Identifier) Expression {
+	if p.lambdas >= maxLambdas {
+		p.errors = append(p.errors, fmt.Sprintf("too many lambdas (max %d)", maxLambdas))
+		return nil
+	}
+	p.lambdas++
+	n := len(p.locals)
+	defer func() { p.locals = p.locals[:n] }()
+	for _, param := range params {
+		if slices.Contains(p.locals[n:], param.Value) {
+			p.errors = append(p.errors, fmt.Sprintf("duplicate lambda parameter %s", param.Value))
+			return nil
+		}
+		p.locals = append(p.locals, param.Value)
+	}
+	if len(p.locals) > maxLetBindings {
+		p.errors = append(p.errors, fmt.Sprintf("too many nested let bindings (max %d)", maxLetBindings))
+		return nil
+	}
+	p.nextToken()
+	return &LambdaLiteral{Parameters: params, Body: p.parseExpression(LOWEST)}
+}
+
+// function 返回此前定义的名为 name 的函数
+func (p *Parser) function(name string) *FunctionLiteral {
+	for _, fn := range p.functions {
+		if fn.Name.Value == name {
+			return fn
+		}
+	}
+	return nil
 }
@@ -118,6 +118,31 @@ func TestParserPrecedence(t *testing.T) {
 		{"a == b || c == d && e == f", "((a == b) || ((c == d) && (e == f)))"},
 		{"(a == b || c == d) && e == f", "(((a == b) || (c == d)) && (e == f))"},
 		{"a = b = c", "(a = (b = c))"},
+		{"a & b == c | d ^ e << 1", "((a & b) == (c | (d ^ (e << 1))))"},
+		{"1 <= x < y <= 9", "(((1 <= x) && (x < y)) && (y <= 9))"},
+		{"(a < b) < c", "((a < b) < c)"},
+		{"[1, a + b][0]", "([1, (a + b)][0])"},
+		{"-a[0] * b[i + 1]", "((-(a[0])) * (b[(i + 1)]))"},
+		{"a[0][1] = b = 2", "((a[0])[1] = (b = 2))"},
+		{`{"k": a + 1, b: {}}["k"]`, "({k: (a + 1), b: {}}[k])"},
+		{`-f(a).get("x", 1)[0] * 2`, "((-(f(a).get(x, 1)[0])) * 2)"},
+		{"let t = a + 1 => t * 2 == b", "(let t = (a + 1) => ((t * 2) == b))"},
+		{"fn f(x, y) => x * y + 1; fn g() => f(a, 2); g() > 3", "fn f(x, y) => ((x * y) + 1); fn g() => f(a, 2); (g() > 3)"},
+		{"filter(xs, x -> x > a && x < b)", "filter(xs, (x -> ((x > a) && (x < b))))"},
+		{"(a, b) -> a + b * c", "((a, b) -> (a + (b * c)))"},
+		{"a + b |> f |> g(1, c)", "g(f((a + b)), 1, c)"},
+		{"x = a || b |> len == 3", "(x = (len((a || b)) == 3))"},
+		{"user?.address?.city == c", "(((user?.address)?.city) == c)"},
+		{"-a?.b + m[0]?.c.len()", "((-(a?.b)) + ((m[0])?.c).len())"},
+		{"x = a; b\n(c) -\n  d;", "(x = a); b; (c - d)"},
+		{"a\n-b\n[c]", "a; (-b); [c]"},
+		{"x in 1..n + 1 == ok", "((x in (1..(n + 1))) == ok)"},
+		{"-1..a | b", "((-1)..(a | b))"},
+		{"[...a + b, 1, ...[c]] + d", "([...(a + b), 1, ...[c]] + d)"},
+		{"f(a\n-b)[c\n(d)]", "(f((a - b))[c(d)])"},
+		{"let t = a\nt + b; t", "(let t = a => (t + b); t)"},
+		{"{a, b} = m[c] => a + b; {a} = {\"a\": 1}", "({a, b} = (m[c]) => (a + b)); ({a} = {a: 1})"},
+		{"score { a > 1: 10, b: -2.5 } >= 10; score {}", "(score {(a > 1): 10, b: -2.5} >= 10); score {}"},
 	}
 
 	for _, tt := range tests {
@@ -143,6 +168,19 @@ func TestParserErrors(t *testing.T) {
 		"if a == 0 is 1 else",
 		"a +",
 		"= 1",
+		"[1, 2",
+		"a[0",
+		"1 = 2",
+		`{"a" 1}`,
+		`{"a": 1,}`,
+		`{"a": 1`,
+		"m.",
+		"m.get",
+		"m.1()",
+		"a b",
+		"a, b; c",
+		"let t = a",
+		"let t = a; t = 1",
 	}
 
 	for _, input := range tests {
@@ -0,0 +1,130 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package phone 为 uwasa 注册内置函数 normalizePhone(s, region)，把用户输入的电话号码
+// 规范化为 E.164 格式（如 "+819012345678"），号码不合法时返回 nil。以空白导入启用：
+//
+//	import _ "github.com/kamihama-railway/uwasa/phone"
+//
+// 只按各地区的国家代码、长途前缀与号码长度做规范化与粗略校验，不判断号段是否已分配，
+// 也不区分手机与固定电话。
+package phone
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// region 是一个地区的拨号规则：国家代码、国内长途前缀、国际冠码，以及去掉长途前缀后的国内号码位数范围
+type region struct {
+	code, trunk, intl string
+	min, max          int
+}
+
+// regions 为支持的地区，键为 ISO 3166-1 二位字母代码
+var regions = map[string]region{
+	"US": {"1", "1", "011", 10, 10},
+	"CA": {"1", "1", "011", 10, 10},
+	"CN": {"86", "0", "00", 9, 11},
+	"JP": {"81", "0", "010", 9, 10},
+	"KR": {"82", "0", "00", 8, 10},
+	"TW": {"886", "0", "00", 8, 9},
+	"HK": {"852", "", "00", 8, 8},
+	"SG": {"65", "", "000", 8, 8},
+	"GB": {"44", "0", "00", 9, 10},
+	"DE": {"49", "0", "00", 6, 13},
+	"FR": {"33", "0", "00", 9, 9},
+	"AU": {"61", "0", "0011", 9, 9},
+	"IN": {"91", "0", "00", 10, 10},
+}
+
+func init() {
+	if err := uwasa.RegisterBuiltin("normalizePhone", normalizePhone, uwasa.BuiltinOptions{Pure: true}); err != nil {
+		panic(err)
+	}
+}
+
+// Normalize 把 region 地区用户输入的号码 s 规范化为 E.164 格式。s 中可以有空格、连字符、点与括号；
+// 以 + 或该地区的国际冠码开头时按国际号码处理，否则去掉国内长途前缀后加上国家代码。
+// 号码含有其他字符或位数不符时返回 false；region 不受支持时返回错误
+func Normalize(s, regionCode string) (string, bool, error) {
+	r, ok := regions[strings.ToUpper(regionCode)]
+	if !ok {
+		return "", false, fmt.Errorf("normalizePhone: unsupported region %q", regionCode)
+	}
+	digits, plus, ok := digitsOf(s)
+	if !ok {
+		return "", false, nil
+	}
+	if !plus && r.intl != "" && strings.HasPrefix(digits, r.intl) {
+		digits, plus = digits[len(r.intl):], true
+	}
+	if plus {
+		return international(digits)
+	}
+	if r.trunk != "" && strings.HasPrefix(digits, r.trunk) && len(digits)-len(r.trunk) >= r.min {
+		digits = digits[len(r.trunk):]
+	}
+	if len(digits) < r.min || len(digits) > r.max || digits[0] == '0' {
+		return "", false, nil
+	}
+	return "+" + r.code + digits, true, nil
+}
+
+// digitsOf 去掉号码中的分隔符，返回其中的数字以及是否以 + 开头
+func digitsOf(s string) (string, bool, bool) {
+	s = strings.TrimSpace(s)
+	plus := strings.HasPrefix(s, "+")
+	if plus {
+		s = s[1:]
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case '0' <= c && c <= '9':
+			b.WriteByte(c)
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return "", false, false
+		}
+	}
+	if b.Len() == 0 {
+		return "", false, false
+	}
+	return b.String(), plus, true
+}
+
+// international 校验国家代码之后的号码：E.164 号码不超过 15 位；国家代码属于已知地区时按该地区校验国内号码位数
+func international(digits string) (string, bool, error) {
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", false, nil
+	}
+	for _, r := range regions {
+		if national, ok := strings.CutPrefix(digits, r.code); ok {
+			if len(national) < r.min || len(national) > r.max || national[0] == '0' {
+				return "", false, nil
+			}
+			break
+		}
+	}
+	return "+" + digits, true, nil
+}
+
+// normalizePhone 实现内置函数 normalizePhone(s, region)：返回 E.164 格式的号码，号码不合法时为 nil
+func normalizePhone(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("normalizePhone expects 2 arguments, got %d", len(args))
+	}
+	s, ok1 := args[0].(string)
+	regionCode, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("normalizePhone expects a number string and a region code, got %T and %T", args[0], args[1])
+	}
+	n, ok, err := Normalize(s, regionCode)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return n, nil
+}
@@ -0,0 +1,55 @@
+package phone
+
+import (
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input, region, expected string
+	}{
+		{"090-1234-5678", "JP", "+819012345678"},
+		{"03 1234 5678", "jp", "+81312345678"},
+		{"+81 90 1234 5678", "US", "+819012345678"},
+		{"010-81-90-1234-5678", "JP", "+819012345678"},
+		{"(415) 555-2671", "US", "+14155552671"},
+		{"1-415-555-2671", "CA", "+14155552671"},
+		{"011 44 20 7946 0958", "US", "+442079460958"},
+		{"138 0013 8000", "CN", "+8613800138000"},
+		{"010-1234-5678", "KR", "+821012345678"},
+		{"9123 4567", "SG", "+6591234567"},
+		{"+1 999 123 4567 89", "JP", ""},
+		{"090-1234", "JP", ""},
+		{"0120-abc", "JP", ""},
+		{"+0 123 4567 890", "US", ""},
+		{"", "JP", ""},
+	}
+	for _, tt := range tests {
+		got, ok, err := Normalize(tt.input, tt.region)
+		if err != nil || got != tt.expected || ok != (tt.expected != "") {
+			t.Errorf("Normalize(%q, %q): expected %q, got %q, %v (%v)", tt.input, tt.region, tt.expected, got, ok, err)
+		}
+	}
+	if _, _, err := Normalize("0312345678", "XX"); err == nil {
+		t.Errorf("expected unsupported region error")
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	engine, err := uwasa.NewEngineVMNeo(`if normalizePhone(tel, "JP") == nil is "invalid" else is normalizePhone(tel, "JP")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tel, want := range map[string]string{"090-1234-5678": "+819012345678", "12345": "invalid"} {
+		got, err := engine.Execute(map[string]any{"tel": tel})
+		if err != nil || got != want {
+			t.Errorf("%s: expected %s, got %v (%v)", tel, want, got, err)
+		}
+	}
+	engine, _ = uwasa.NewEngine(`normalizePhone(tel, "ZZ")`)
+	if _, err := engine.Execute(map[string]any{"tel": "090-1234-5678"}); err == nil {
+		t.Errorf("expected unsupported region error")
+	}
+}
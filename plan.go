@@ -0,0 +1,551 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RulePlan 是一组规则在同一个上下文上的执行计划，由 NewRulePlan 创建，适合变量读取代价高的 Context
+// （如按需查询数据库的上下文）：Run 按计划的顺序执行各规则，每个变量在一次执行中至多从 Context 读取一次，
+// 多条规则中相同的子表达式也只计算一次。计划创建后不再修改，可被多个协程同时使用
+type RulePlan struct {
+	rules  []plannedRule
+	shared []*sharedRef
+	// index 为占位名到 shared 下标的映射
+	index map[string]int
+}
+
+// plannedRule 是计划中的一条规则
+type plannedRule struct {
+	name   string
+	engine *Engine
+	// vars 为规则读取的变量；known 为 false 时引擎没有源码，读取哪些变量未知
+	vars  []string
+	known bool
+	cost  int
+	// program 为共享子表达式替换为 sharedRef 后的规则，由 AST 解释器求值；nil 时以 engine 执行
+	program Node
+}
+
+// NewRulePlan 为 rules 创建执行计划。规则的顺序按贪心法确定：每一步选出尚需读取的新变量最少的规则，
+// 相同时先执行 EstimatedCost 较小的，再按名称，因此 Run 提前停止时读取的变量尽量少；
+// 从字节码包加载的引擎没有源码，排在最后。
+//
+// 只由变量、字面量、运算符、下标与纯内置函数组成的子表达式出现在两条及以上的规则中时，
+// 在首次用到时计算一次，之后的规则直接使用其结果（出错时同样复用该错误）。读取了任一规则会赋值
+// 或原地修改的变量、或读取了规则内 let 绑定与参数的子表达式不参与共享。含共享子表达式的规则按
+// AST 解释器的语义求值，与创建引擎时选择的后端无关；NeoVM 的语义与之略有差异（如整数除以 0），
+// 因此 NeoVM 编译的规则与设置了 MaxConcatBytes 的规则不参与共享
+func NewRulePlan(rules map[string]*Engine) *RulePlan {
+	p := &RulePlan{index: map[string]int{}}
+	assigned := map[string]bool{}
+	programs := make(map[string]Node, len(rules))
+	for _, name := range slices.Sorted(maps.Keys(rules)) {
+		r := plannedRule{name: name, engine: rules[name], cost: rules[name].EstimatedCost()}
+		if r.engine.source != "" {
+			if node, err := parseRule(r.engine.source, r.engine.opts); err == nil {
+				programs[name] = node
+				r.vars, r.known = ruleVars(node), true
+				assignedNames(node, assigned)
+			}
+		}
+		p.rules = append(p.rules, r)
+	}
+	orderRules(p.rules)
+
+	scans := make(map[string]*shareScan, len(programs))
+	counts := map[string]int{}
+	for _, r := range p.rules {
+		if !r.known || r.engine.neoBytecode != nil || r.engine.maxConcatBytes > 0 {
+			continue
+		}
+		s := &shareScan{bound: boundNames(programs[r.name]), keys: map[Expression]string{}}
+		maps.Copy(s.bound, assigned)
+		s.visit(programs[r.name].(Expression))
+		for _, key := range slices.Compact(slices.Sorted(maps.Values(s.keys))) {
+			counts[key]++
+		}
+		scans[r.name] = s
+	}
+	b := &planBuilder{counts: counts, refs: map[string]*sharedRef{}, uses: map[*sharedRef]int{}}
+	for i := range p.rules {
+		r := &p.rules[i]
+		if s, ok := scans[r.name]; ok {
+			b.keys = s.keys
+			if node, changed := b.replace(programs[r.name].(Expression)); changed {
+				r.program = node
+			}
+		}
+	}
+	// 只在一处用到的引用（被更大的共享子表达式包含）直接求值，不再缓存
+	for _, ref := range b.order {
+		if b.uses[ref] < 2 {
+			ref.name = ""
+			continue
+		}
+		// 占位名含 '#'，不会与规则中的标识符重名
+		ref.name = fmt.Sprintf("shared#%d", len(p.shared))
+		p.index[ref.name] = len(p.shared)
+		p.shared = append(p.shared, ref)
+	}
+	return p
+}
+
+// parseRule 按编译时的选项解析规则源码，与 newEngineAST 得到的语法树相同
+func parseRule(source string, opts EngineOptions) (Node, error) {
+	l := NewLexer(source)
+	defer lexerPool.Put(l)
+	l.numbers = opts.NumberFormat
+	p := NewParser(l)
+	defer parserPool.Put(p)
+	p.inputDocument, p.decimal = opts.InputDocument, opts.Decimal
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors: %v", p.Errors())
+	}
+	seedHashCalls(program, opts.HashSeed)
+	if opts.OperandLogic {
+		markOperandLogic(program)
+	}
+	var node Node = program
+	if opts.OptimizationLevel >= OptBasic {
+		node = Fold(node)
+	}
+	if node == nil {
+		return nil, fmt.Errorf("empty rule")
+	}
+	return node, nil
+}
+
+// Order 返回各规则的执行顺序
+func (p *RulePlan) Order() []string {
+	names := make([]string, len(p.rules))
+	for i, r := range p.rules {
+		names[i] = r.name
+	}
+	return names
+}
+
+// Vars 返回规则 name 可能从 Context 读取的变量，按名称排序；规则不存在或其引擎没有源码时返回 nil
+func (p *RulePlan) Vars(name string) []string {
+	for _, r := range p.rules {
+		if r.name == name {
+			return r.vars
+		}
+	}
+	return nil
+}
+
+// Shared 返回各共享子表达式的文本，按计算的先后排列：被其他共享子表达式包含的排在前面
+func (p *RulePlan) Shared() []string {
+	texts := make([]string, len(p.shared))
+	for i, s := range p.shared {
+		texts[i] = s.text
+	}
+	return texts
+}
+
+// Run 以 ctx 按 Order 的顺序执行各规则，每条规则执行后以其结果调用 yield，yield 返回 false 时停止。
+// 规则对变量的赋值写入 ctx，之后的规则读取到的是赋值后的值
+func (p *RulePlan) Run(ctx Context, yield func(name string, result any, err error) bool) {
+	c := &planContext{ctx: ctx, plan: p, vars: map[string]planVar{}, results: make([]*sharedResult, len(p.shared))}
+	for _, r := range p.rules {
+		var res any
+		var err error
+		if r.program != nil {
+			res, err = Eval(r.program, c)
+		} else {
+			res, err = r.engine.ExecuteWithContext(c)
+		}
+		if !yield(r.name, res, err) {
+			return
+		}
+	}
+}
+
+// orderRules 按 NewRulePlan 所述的贪心法原地排列 rules
+func orderRules(rules []plannedRule) {
+	fetched := map[string]bool{}
+	fresh := func(r plannedRule) int {
+		n := 0
+		for _, v := range r.vars {
+			if !fetched[v] {
+				n++
+			}
+		}
+		return n
+	}
+	for i := range rules {
+		best := i
+		for j := i + 1; j < len(rules); j++ {
+			a, b := rules[j], rules[best]
+			c := cmp.Or(-cmp.Compare(boolInt(a.known), boolInt(b.known)), cmp.Compare(fresh(a), fresh(b)),
+				cmp.Compare(a.cost, b.cost), cmp.Compare(a.name, b.name))
+			if c < 0 {
+				best = j
+			}
+		}
+		rules[i], rules[best] = rules[best], rules[i]
+		for _, v := range rules[i].vars {
+			fetched[v] = true
+		}
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ruleVars 返回 node 读取的变量名，按名称排序；调用的函数名以及在作用域内的 let 绑定与参数不计在内
+func ruleVars(node Node) []string {
+	vars := map[string]bool{}
+	var visit func(n Node, scope map[string]bool)
+	visit = func(n Node, scope map[string]bool) {
+		switch n := n.(type) {
+		case *Identifier:
+			if !scope[n.Value] {
+				vars[n.Value] = true
+			}
+			return
+		case *LetExpression:
+			visit(n.Value, scope)
+			visit(n.Body, withNames(scope, n.Name))
+			return
+		case *LambdaLiteral:
+			visit(n.Body, withNames(scope, n.Parameters...))
+			return
+		case *Program:
+			for _, f := range n.Functions {
+				visit(f.Body, withNames(scope, f.Parameters...))
+			}
+			visit(n.Body, scope)
+			return
+		}
+		for _, slot := range childSlots(n) {
+			if *slot != nil {
+				visit(*slot, scope)
+			}
+		}
+	}
+	visit(node, nil)
+	return slices.Sorted(maps.Keys(vars))
+}
+
+// withNames 返回在 scope 之上加入 names 的新作用域
+func withNames(scope map[string]bool, names ...*Identifier) map[string]bool {
+	inner := maps.Clone(scope)
+	if inner == nil {
+		inner = make(map[string]bool, len(names))
+	}
+	for _, name := range names {
+		inner[name.Value] = true
+	}
+	return inner
+}
+
+// boundNames 收集 node 中 let 绑定、lambda 参数以及规则内函数的名称与参数
+func boundNames(node Node) map[string]bool {
+	names := declaredNames(node)
+	if prog, ok := node.(*Program); ok {
+		for _, f := range prog.Functions {
+			names[f.Name.Value] = true
+			for _, param := range f.Parameters {
+				names[param.Value] = true
+			}
+		}
+	}
+	return names
+}
+
+// assignedNames 把 node 赋值、解构写入或可能原地修改的变量加入 names
+func assignedNames(node Node, names map[string]bool) {
+	walk(node, func(n Node) {
+		switch n := n.(type) {
+		case *AssignExpression:
+			names[n.Name.Value] = true
+		case *DestructureExpression:
+			for _, name := range n.Names {
+				names[name.Value] = true
+			}
+		case *IndexAssignExpression:
+			if ident, ok := rootIdentifier(n.Left); ok {
+				names[ident.Value] = true
+			}
+		case *MethodCallExpression:
+			if ident, ok := rootIdentifier(n.Receiver); ok {
+				names[ident.Value] = true
+			}
+		}
+	})
+}
+
+// rootIdentifier 返回 a[i][j]、a?.b 等下标与成员访问最左侧的变量
+func rootIdentifier(node Expression) (*Identifier, bool) {
+	for {
+		switch n := node.(type) {
+		case *Identifier:
+			return n, true
+		case *IndexExpression:
+			node = n.Left
+		case *OptionalMemberExpression:
+			node = n.Receiver
+		case *MethodCallExpression:
+			node = n.Receiver
+		default:
+			return nil, false
+		}
+	}
+}
+
+// shareScan 找出一条规则中可以共享的子表达式，keys 为各子表达式的文本，文本相同即语义相同
+type shareScan struct {
+	// bound 为不能从全局读取的名称：规则内的绑定与参数，以及任一规则会写入的变量
+	bound map[string]bool
+	keys  map[Expression]string
+}
+
+// visit 返回 n 的文本、n 是否读取了变量，以及 n 能否共享；能共享且读取了变量的复合表达式记入 keys。
+// 字面量的文本带上类型，避免 "1" 与 1、1 与 1.0 混同
+func (s *shareScan) visit(n Expression) (string, bool, bool) {
+	slots := childSlots(n)
+	keys := make([]string, len(slots))
+	pure, reads := true, false
+	for i, slot := range slots {
+		if *slot == nil {
+			pure = false
+			continue
+		}
+		key, r, ok := s.visit(*slot)
+		keys[i], reads, pure = key, reads || r, pure && ok
+	}
+	var key string
+	switch n := n.(type) {
+	case *Identifier:
+		return n.Value, true, !s.bound[n.Value]
+	case *NumberLiteral:
+		if n.IsInt {
+			return n.String(), false, true
+		}
+		key = strconv.FormatFloat(n.Float64Value, 'g', -1, 64)
+		if !strings.ContainsAny(key, ".eIN") {
+			key += ".0"
+		}
+		return key, false, true
+	case *StringLiteral:
+		return strconv.Quote(n.Value), false, true
+	case *BooleanLiteral, *DecimalLiteral, *DurationLiteral, *TimeLiteral:
+		return n.String(), false, true
+	case *PrefixExpression:
+		key = "(" + n.Operator + keys[0] + ")"
+	case *InfixExpression:
+		// 返回操作数的 && 与 || 与普通的写法相同而语义不同，不参与共享
+		pure = pure && !n.ReturnsOperand
+		key = "(" + keys[0] + " " + n.Operator + " " + keys[1] + ")"
+	case *IndexExpression:
+		key = keys[0] + "[" + keys[1] + "]"
+	case *OptionalMemberExpression:
+		key = keys[0] + "?." + n.Name
+	case *CallExpression:
+		ident, ok := n.Function.(*Identifier)
+		pure = pure && ok && pureBuiltins.load()[ident.Value] && !s.bound[ident.Value]
+		if pure {
+			key = ident.Value + "(" + strings.Join(keys, ", ") + ")"
+		}
+	default:
+		return "", reads, false
+	}
+	if pure && reads {
+		s.keys[n] = key
+	}
+	return key, reads, pure
+}
+
+// planBuilder 依次改写各规则，把共享的子表达式替换为 sharedRef
+type planBuilder struct {
+	counts map[string]int // 各文本出现在多少条规则中
+	keys   map[Expression]string
+	refs   map[string]*sharedRef
+	// order 为创建各引用的顺序，内层的先于外层；uses 为各引用被使用的次数
+	order []*sharedRef
+	uses  map[*sharedRef]int
+}
+
+// replace 改写 n 中共享的子表达式，返回改写后的 n 以及是否有改动
+func (b *planBuilder) replace(n Expression) (Expression, bool) {
+	if key, ok := b.keys[n]; ok && b.counts[key] >= 2 {
+		return b.ref(key, n), true
+	}
+	changed := false
+	for _, slot := range childSlots(n) {
+		if *slot == nil {
+			continue
+		}
+		if r, ok := b.replace(*slot); ok {
+			*slot, changed = r, true
+		}
+	}
+	return n, changed
+}
+
+// ref 返回文本为 key 的共享子表达式的引用，首次遇到时以 n 为其定义；n 已从规则中摘下，
+// 其中更小的共享子表达式同样改写为引用
+func (b *planBuilder) ref(key string, n Expression) *sharedRef {
+	ref, ok := b.refs[key]
+	if !ok {
+		for _, slot := range childSlots(n) {
+			if *slot != nil {
+				*slot, _ = b.replace(*slot)
+			}
+		}
+		ref = &sharedRef{text: key, expr: n}
+		b.refs[key] = ref
+		b.order = append(b.order, ref)
+	}
+	b.uses[ref]++
+	return ref
+}
+
+// childSlots 返回 node 的各个子表达式所在的位置，供改写；函数名、参数与权重不是子表达式
+func childSlots(node Node) []*Expression {
+	switch n := node.(type) {
+	case *PrefixExpression:
+		return []*Expression{&n.Right}
+	case *InfixExpression:
+		return []*Expression{&n.Left, &n.Right}
+	case *IfExpression:
+		slots := []*Expression{&n.Condition}
+		if n.Consequence != nil {
+			slots = append(slots, &n.Consequence)
+		}
+		if n.Alternative != nil {
+			slots = append(slots, &n.Alternative)
+		}
+		return slots
+	case *AssignExpression:
+		return []*Expression{&n.Value}
+	case *LetExpression:
+		return []*Expression{&n.Value, &n.Body}
+	case *DestructureExpression:
+		if n.Body == nil {
+			return []*Expression{&n.Value}
+		}
+		return []*Expression{&n.Value, &n.Body}
+	case *Program:
+		slots := make([]*Expression, 0, len(n.Functions)+1)
+		for _, f := range n.Functions {
+			slots = append(slots, &f.Body)
+		}
+		return append(slots, &n.Body)
+	case *LambdaLiteral:
+		return []*Expression{&n.Body}
+	case *CallExpression:
+		return elementSlots(n.Arguments)
+	case *TupleExpression:
+		return elementSlots(n.Elements)
+	case *SequenceExpression:
+		return elementSlots(n.Statements)
+	case *ArrayLiteral:
+		return elementSlots(n.Elements)
+	case *SpreadElement:
+		return []*Expression{&n.Value}
+	case *MapLiteral:
+		return append(elementSlots(n.Keys), elementSlots(n.Values)...)
+	case *MethodCallExpression:
+		return append([]*Expression{&n.Receiver}, elementSlots(n.Arguments)...)
+	case *OptionalMemberExpression:
+		return []*Expression{&n.Receiver}
+	case *RangeExpression:
+		return []*Expression{&n.Start, &n.End}
+	case *ScoreExpression:
+		return elementSlots(n.Conditions)
+	case *IndexExpression:
+		return []*Expression{&n.Left, &n.Index}
+	case *IndexAssignExpression:
+		return []*Expression{&n.Left, &n.Index, &n.Value}
+	}
+	return nil
+}
+
+func elementSlots(elements []Expression) []*Expression {
+	slots := make([]*Expression, len(elements))
+	for i := range elements {
+		slots[i] = &elements[i]
+	}
+	return slots
+}
+
+// sharedRef 是执行计划中代替共享子表达式的节点，求值时取本次执行中已算出的结果。
+// name 为占位名，为空时不缓存；text 为子表达式的文本
+type sharedRef struct {
+	name, text string
+	expr       Expression
+}
+
+func (r *sharedRef) expressionNode() {}
+func (r *sharedRef) String() string  { return r.expr.String() }
+
+// eval 经 ctx 取得共享子表达式的结果；不缓存或不在 RulePlan.Run 中求值时直接计算
+func (r *sharedRef) eval(ctx Context) (any, error) {
+	if r.name != "" {
+		if v, ok := ctx.Get(r.name); ok {
+			if res, ok := v.(*sharedResult); ok {
+				return res.val, res.err
+			}
+		}
+	}
+	return Eval(r.expr, ctx)
+}
+
+type sharedResult struct {
+	val any
+	err error
+}
+
+type planVar struct {
+	val    any
+	exists bool
+}
+
+// planContext 是 RulePlan.Run 一次执行的上下文：缓存读取过的变量（包括不存在的变量）与已算出的共享子表达式，
+// 赋值写入底层的 Context 并更新缓存
+type planContext struct {
+	ctx     Context
+	plan    *RulePlan
+	vars    map[string]planVar
+	results []*sharedResult
+}
+
+func (c *planContext) Get(name string) (any, bool) {
+	if v, ok := c.vars[name]; ok {
+		return v.val, v.exists
+	}
+	if i, ok := c.plan.index[name]; ok {
+		if c.results[i] == nil {
+			res := &sharedResult{}
+			res.val, res.err = Eval(c.plan.shared[i].expr, c)
+			c.results[i] = res
+		}
+		return c.results[i], true
+	}
+	val, exists := c.ctx.Get(name)
+	c.vars[name] = planVar{val: val, exists: exists}
+	return val, exists
+}
+
+func (c *planContext) Set(name string, value any) error {
+	if err := c.ctx.Set(name, value); err != nil {
+		return err
+	}
+	c.vars[name] = planVar{val: value, exists: true}
+	return nil
+}
@@ -0,0 +1,88 @@
+package uwasa
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestRulePlan(t *testing.T) {
+	sources := map[string]string{
+		"big":    `amount > 100`,
+		"jp":     `amount > 100 && country == "JP"`,
+		"tagged": `len(tags) > 2 && amount > 100`,
+		"vip":    `if tier == "gold" is amount * 2 else is amount`,
+		"ratio":  `10 / d > 1`,
+		"safe":   `try(10 / d > 1, false)`,
+	}
+	vars := func() map[string]any {
+		return map[string]any{"amount": int64(150), "country": "JP", "tags": []any{"a", "b", "c"}, "tier": "gold", "d": int64(0)}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		rules := map[string]*Engine{}
+		for rule, src := range sources {
+			e, err := newEngine(src)
+			if err != nil {
+				t.Fatalf("%s %s: compile error: %v", name, rule, err)
+			}
+			rules[rule] = e
+		}
+		plan := NewRulePlan(rules)
+		if order := plan.Order(); len(order) != len(sources) || order[0] != "big" {
+			t.Errorf("%s: unexpected order %v", name, order)
+		}
+		if got := plan.Vars("jp"); !slices.Equal(got, []string{"amount", "country"}) {
+			t.Errorf("%s: unexpected vars %v", name, got)
+		}
+		shared := []string{"(amount > 100)", "((10 / d) > 1)"}
+		if name == "NeoVM" {
+			shared = []string{}
+		}
+		if got := plan.Shared(); !slices.Equal(got, shared) {
+			t.Errorf("%s: unexpected shared expressions %v", name, got)
+		}
+
+		ctx := &countingContext{MapContext: MapContext{vars: vars()}, gets: map[string]int{}}
+		results := map[string]any{}
+		plan.Run(ctx, func(rule string, res any, err error) bool {
+			want, wantErr := rules[rule].ExecuteWithContext(&MapContext{vars: vars()})
+			if (err != nil) != (wantErr != nil) || res != want {
+				t.Errorf("%s %s: got %v (%v), want %v (%v)", name, rule, res, err, want, wantErr)
+			}
+			results[rule] = res
+			return true
+		})
+		if len(results) != len(sources) || results["vip"] != int64(300) {
+			t.Errorf("%s: unexpected results %v", name, results)
+		}
+		for v, n := range ctx.gets {
+			if n != 1 {
+				t.Errorf("%s: variable %s read %d times", name, v, n)
+			}
+		}
+
+		ctx = &countingContext{MapContext: MapContext{vars: vars()}, gets: map[string]int{}}
+		plan.Run(ctx, func(string, any, error) bool { return false })
+		if !maps.Equal(ctx.gets, map[string]int{"amount": 1}) {
+			t.Errorf("%s: stopping after the first rule read %v", name, ctx.gets)
+		}
+	}
+
+	// 读取 let 绑定或被赋值的变量的子表达式不共享
+	for _, sources := range []map[string]string{
+		{"let": `let n = 5 => n * 2 > 1`, "global": `n * 2 > 1`},
+		{"set": `total = amount + 1; total > 0`, "a": `total + 1 > 0`, "b": `total + 1 > 0`},
+	} {
+		rules := map[string]*Engine{}
+		for rule, src := range sources {
+			e, err := NewEngine(src)
+			if err != nil {
+				t.Fatalf("%s: compile error: %v", rule, err)
+			}
+			rules[rule] = e
+		}
+		if got := NewRulePlan(rules).Shared(); len(got) != 0 {
+			t.Errorf("%v: expected no shared expressions, got %v", sources, got)
+		}
+	}
+}
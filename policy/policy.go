@@ -0,0 +1,154 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package policy 在 uwasa 之上实现基于属性的访问控制：规则以 uwasa 规则书写，
+// 读取请求的主体、动作与资源属性，按“拒绝优先”合并为一个决定，并给出决定所依据的规则以便审计：
+//
+//	p, err := policy.Compile(
+//		policy.Rule{ID: "owner", Effect: policy.Allow, When: `subject?.id != nil && resource?.owner == subject?.id`},
+//		policy.Rule{ID: "admin", Effect: policy.Allow, When: `subject["role"] == "admin"`},
+//		policy.Rule{ID: "locked", Effect: policy.Deny, Actions: []string{"write", "delete"}, When: `resource?.locked == true`},
+//	)
+//	d := p.Evaluate(policy.Request{Subject: user, Action: "write", Resource: doc})
+//	// d.Allowed(), d.RuleID
+//
+// 规则的上下文中，subject、resource 与 env 为请求中对应的映射（为 nil 时是空映射），action 为动作名。
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// Effect 为规则命中时的效果
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Rule 是一条访问规则
+type Rule struct {
+	// ID 标识规则，作为决定依据记录在 Decision.RuleID 中；非空的 ID 不能重复
+	ID string
+	// Effect 为 Allow 或 Deny
+	Effect Effect
+	// Actions 限定规则适用的动作，为空时适用于所有动作。不适用的规则不会执行
+	Actions []string
+	// When 为以请求为上下文执行的 uwasa 规则，结果为真（不是 nil 或 false）时命中
+	When string
+}
+
+// Request 是一次访问请求
+type Request struct {
+	Subject  map[string]any
+	Action   string
+	Resource map[string]any
+	// Env 为请求之外的环境属性，如时间、来源 IP
+	Env map[string]any
+}
+
+// Decision 是对一次请求的决定
+type Decision struct {
+	Effect Effect
+	// RuleIndex 与 RuleID 为决定所依据的规则：命中的拒绝规则、命中的允许规则或出错的规则。
+	// 没有规则命中而默认拒绝时 RuleIndex 为 -1
+	RuleIndex int
+	RuleID    string
+	// Err 为规则执行出错时的错误，此时 Effect 为 Deny
+	Err error
+}
+
+// Allowed 报告请求是否被允许
+func (d Decision) Allowed() bool {
+	return d.Effect == Allow
+}
+
+// Options 配置规则的编译
+type Options struct {
+	// Engine 交给 uwasa.NewEngineVMNeoWithOptions
+	Engine uwasa.EngineOptions
+}
+
+// Policy 是编译好的一组规则，可被多个协程同时使用
+type Policy struct {
+	rules []Rule
+	// order 为执行顺序：先是全部拒绝规则，再是全部允许规则，各自保持定义顺序
+	order   []int
+	engines []*uwasa.Engine
+}
+
+// Compile 使用默认选项编译各规则
+func Compile(rules ...Rule) (*Policy, error) {
+	return CompileWithOptions(rules, Options{})
+}
+
+// CompileWithOptions 按 opts 编译各规则。效果不是 Allow 或 Deny、ID 重复以及规则编译失败时返回带规则下标的错误
+func CompileWithOptions(rules []Rule, opts Options) (*Policy, error) {
+	if len(rules) == 0 {
+		return nil, errors.New("policy: no rules")
+	}
+	p := &Policy{rules: slices.Clone(rules), engines: make([]*uwasa.Engine, len(rules))}
+	ids := make(map[string]bool)
+	for _, effect := range []Effect{Deny, Allow} {
+		for i, r := range rules {
+			if r.Effect == effect {
+				p.order = append(p.order, i)
+			}
+		}
+	}
+	for i, r := range rules {
+		if r.Effect != Allow && r.Effect != Deny {
+			return nil, fmt.Errorf("policy: rule %d: unknown effect %q", i, r.Effect)
+		}
+		if r.ID != "" {
+			if ids[r.ID] {
+				return nil, fmt.Errorf("policy: rule %d: duplicate id %q", i, r.ID)
+			}
+			ids[r.ID] = true
+		}
+		engine, err := uwasa.NewEngineVMNeoWithOptions(r.When, opts.Engine)
+		if err != nil {
+			return nil, fmt.Errorf("policy: rule %d: %w", i, err)
+		}
+		p.engines[i] = engine
+	}
+	return p, nil
+}
+
+// Evaluate 按拒绝优先合并各规则：任一适用的拒绝规则命中即拒绝；否则任一适用的允许规则命中即允许；
+// 都不命中时默认拒绝。拒绝规则先于允许规则执行，各自按定义顺序，决定一旦确定就不再执行其余规则。
+// 规则执行出错时无法确认结果，按拒绝处理并返回错误
+func (p *Policy) Evaluate(req Request) Decision {
+	ctx := map[string]any{
+		"subject":  orEmpty(req.Subject),
+		"action":   req.Action,
+		"resource": orEmpty(req.Resource),
+		"env":      orEmpty(req.Env),
+	}
+	for _, i := range p.order {
+		r := &p.rules[i]
+		if len(r.Actions) > 0 && !slices.Contains(r.Actions, req.Action) {
+			continue
+		}
+		v, err := p.engines[i].Execute(ctx)
+		if err != nil {
+			return Decision{Effect: Deny, RuleIndex: i, RuleID: r.ID, Err: fmt.Errorf("policy: rule %d: %w", i, err)}
+		}
+		if v != nil && v != false {
+			return Decision{Effect: r.Effect, RuleIndex: i, RuleID: r.ID}
+		}
+	}
+	return Decision{Effect: Deny, RuleIndex: -1}
+}
+
+func orEmpty(m map[string]any) map[string]any {
+	if m == nil {
+		return map[string]any{}
+	}
+	return m
+}
@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	p, err := Compile(
+		Rule{ID: "owner", Effect: Allow, When: `subject?.id != nil && resource?.owner == subject?.id`},
+		Rule{ID: "admin", Effect: Allow, When: `subject["role"] == "admin"`},
+		Rule{ID: "read-public", Effect: Allow, Actions: []string{"read"}, When: `resource?.public`},
+		Rule{ID: "locked", Effect: Deny, Actions: []string{"write", "delete"}, When: `resource?.locked`},
+		Rule{ID: "banned", Effect: Deny, When: `subject?.banned == true`},
+		Rule{ID: "office-hours", Effect: Deny, Actions: []string{"delete"}, When: `env?.hour < 9`},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := map[string]any{"id": "alice", "role": "user"}
+	root := map[string]any{"id": "root", "role": "admin"}
+	doc := map[string]any{"owner": "alice"}
+	locked := map[string]any{"owner": "alice", "locked": true}
+	public := map[string]any{"owner": "bob", "public": true}
+	tests := []struct {
+		name    string
+		req     Request
+		allowed bool
+		ruleID  string
+	}{
+		{"owner writes", Request{Subject: alice, Action: "write", Resource: doc}, true, "owner"},
+		{"admin writes", Request{Subject: root, Action: "write", Resource: map[string]any{"owner": "bob"}}, true, "admin"},
+		{"locked overrides owner", Request{Subject: alice, Action: "write", Resource: locked}, false, "locked"},
+		{"locked overrides admin", Request{Subject: root, Action: "delete", Resource: locked}, false, "locked"},
+		{"locked only for writes", Request{Subject: alice, Action: "read", Resource: locked}, true, "owner"},
+		{"public read", Request{Subject: alice, Action: "read", Resource: public}, true, "read-public"},
+		{"public is read only", Request{Subject: alice, Action: "write", Resource: public}, false, ""},
+		{"banned", Request{Subject: map[string]any{"id": "alice", "banned": true}, Action: "read", Resource: doc}, false, "banned"},
+		{"env", Request{Subject: alice, Action: "delete", Resource: doc, Env: map[string]any{"hour": int64(7)}}, false, "office-hours"},
+		{"env later", Request{Subject: alice, Action: "delete", Resource: doc, Env: map[string]any{"hour": int64(10)}}, true, "owner"},
+		{"anonymous", Request{Action: "read"}, false, ""},
+	}
+	for _, tt := range tests {
+		d := p.Evaluate(tt.req)
+		if d.Allowed() != tt.allowed || d.RuleID != tt.ruleID || d.Err != nil {
+			t.Errorf("%s: got %+v", tt.name, d)
+		}
+		if tt.ruleID == "" && d.RuleIndex != -1 {
+			t.Errorf("%s: default deny should have no rule, got %d", tt.name, d.RuleIndex)
+		}
+	}
+}
+
+func TestEvaluateError(t *testing.T) {
+	p, err := Compile(
+		Rule{ID: "all", Effect: Allow, When: `true`},
+		Rule{ID: "broken", Effect: Deny, When: `subject["tags"][5] == "x"`},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := p.Evaluate(Request{Subject: map[string]any{"tags": []any{}}, Action: "read"})
+	if d.Allowed() || d.RuleID != "broken" || d.Err == nil {
+		t.Errorf("expected the failing deny rule to deny, got %+v", d)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		rules []Rule
+		want  string
+	}{
+		{nil, "no rules"},
+		{[]Rule{{Effect: "permit", When: "true"}}, `unknown effect "permit"`},
+		{[]Rule{{ID: "a", Effect: Allow, When: "true"}, {ID: "a", Effect: Deny, When: "false"}}, `rule 1: duplicate id "a"`},
+		{[]Rule{{Effect: Allow, When: "x =="}}, "rule 0"},
+	}
+	for _, tt := range tests {
+		if _, err := Compile(tt.rules...); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.rules, tt.want, err)
+		}
+	}
+}
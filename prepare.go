@@ -0,0 +1,76 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+// argPreparers 为可以在编译期预处理常量实参的内置函数，如 inPolygon 的多边形与 ipInCIDR 的网段。
+// 以实参下标与常量值返回预处理结果；该下标无需处理或常量不合法时返回 false，保留原常量留待运行期报错。
+// 内置函数须同时接受预处理结果与原始值
+var argPreparers = map[string]func(i int, v any) (preparedArg, bool){
+	"inPolygon":   preparePolygon,
+	"ipInCIDR":    prepareCIDR,
+	"matchLabels": prepareLabelSelector,
+	"glob":        prepareGlob,
+}
+
+// preparedArg 是预处理得到的实参，记录其来源：字节码包据此保存原常量，并在加载时重新预处理
+type preparedArg interface {
+	preparedFrom() (builtin string, index int, src any)
+}
+
+// prepareArg 以内置函数 name 的第 i 个常量实参 v 调用其预处理函数
+func prepareArg(name string, i int, v any) (preparedArg, bool) {
+	prepare, ok := argPreparers[name]
+	if !ok {
+		return nil, false
+	}
+	return prepare(i, v)
+}
+
+// preparedLiteral 是 Fold 以预处理结果替换的常量实参，只出现在优化后的 AST 中
+type preparedLiteral struct {
+	Source Expression
+	Value  preparedArg
+}
+
+func (pl *preparedLiteral) expressionNode() {}
+func (pl *preparedLiteral) String() string  { return pl.Source.String() }
+
+// prepareCallArgs 把对内置函数 name 的调用中可以预处理的常量实参替换为 preparedLiteral
+func prepareCallArgs(name string, args []Expression) {
+	if _, ok := argPreparers[name]; !ok {
+		return
+	}
+	for i, arg := range args {
+		v, ok := constExpression(arg)
+		if !ok {
+			continue
+		}
+		if p, ok := prepareArg(name, i, v); ok {
+			args[i] = &preparedLiteral{Source: arg, Value: p}
+		}
+	}
+}
+
+// constExpression 在 e 为字面量、元素均为常量的数组字面量或可折叠的映射字面量时返回其值
+func constExpression(e Expression) (any, bool) {
+	switch n := e.(type) {
+	case *ArrayLiteral:
+		arr := make([]any, len(n.Elements))
+		for i, el := range n.Elements {
+			v, ok := constExpression(el)
+			if !ok {
+				return nil, false
+			}
+			arr[i] = v
+		}
+		return arr, true
+	case *MapLiteral:
+		return constMapLiteral(n)
+	}
+	v, ok := literalValue(e)
+	if !ok {
+		return nil, false
+	}
+	return v.ToInterface(), true
+}
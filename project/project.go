@@ -0,0 +1,143 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package project 以一组规则为列定义，把输入行投影为输出行，可直接写为 CSV，
+// 用于在引擎之上做轻量的 ETL：
+//
+//	p, err := project.Compile(
+//		project.Column{Name: "id", Expr: "id"},
+//		project.Column{Name: "total", Expr: "price * qty"},
+//	)
+//	err = p.WriteCSV(w, rows)
+//
+// 每一行都是各列规则的上下文，各列按定义顺序以同一行求值；列中的赋值会写入该行，
+// 因而对其后的列可见。
+package project
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// Column 定义输出的一列
+type Column struct {
+	// Name 为列名，WriteCSV 以其作为表头
+	Name string
+	// Expr 为以输入行为上下文求值的规则
+	Expr string
+}
+
+// Options 配置各列规则的编译
+type Options struct {
+	// Engine 交给 uwasa.NewEngineVMNeoWithOptions，例如用 MaxConcatBytes 限制单个字段的长度
+	Engine uwasa.EngineOptions
+}
+
+// Projection 是编译好的列定义，可被多个协程同时使用
+type Projection struct {
+	names   []string
+	engines []*uwasa.Engine
+}
+
+// Compile 使用默认选项编译各列
+func Compile(cols ...Column) (*Projection, error) {
+	return CompileWithOptions(cols, Options{})
+}
+
+// CompileWithOptions 按 opts 编译各列；任一列编译失败时返回带列名的错误
+func CompileWithOptions(cols []Column, opts Options) (*Projection, error) {
+	if len(cols) == 0 {
+		return nil, errors.New("project: no columns")
+	}
+	p := &Projection{names: make([]string, len(cols)), engines: make([]*uwasa.Engine, len(cols))}
+	for i, col := range cols {
+		engine, err := uwasa.NewEngineVMNeoWithOptions(col.Expr, opts.Engine)
+		if err != nil {
+			return nil, fmt.Errorf("project: column %q: %w", col.Name, err)
+		}
+		p.names[i], p.engines[i] = col.Name, engine
+	}
+	return p, nil
+}
+
+// Columns 返回各列的列名
+func (p *Projection) Columns() []string {
+	return append([]string(nil), p.names...)
+}
+
+// Row 投影一行，返回与各列一一对应的值
+func (p *Projection) Row(row map[string]any) ([]any, error) {
+	out, err := p.row(nil, row)
+	if err != nil {
+		return nil, fmt.Errorf("project: %w", err)
+	}
+	return out, nil
+}
+
+func (p *Projection) row(st *uwasa.RunState, row map[string]any) ([]any, error) {
+	if row == nil {
+		row = make(map[string]any)
+	}
+	out := make([]any, len(p.engines))
+	for i, engine := range p.engines {
+		v, err := engine.ExecuteWithState(st, row)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", p.names[i], err)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// Rows 逐行投影 rows。某一行出错时产出 nil 与带行号（从 0 开始）的错误并停止，
+// 之后的行不再读取。迭代在调用方的协程中进行，各行复用同一个 RunState
+func (p *Projection) Rows(rows iter.Seq[map[string]any]) iter.Seq2[[]any, error] {
+	return func(yield func([]any, error) bool) {
+		st := uwasa.NewRunState()
+		n := 0
+		for row := range rows {
+			out, err := p.row(st, row)
+			if err != nil {
+				yield(nil, fmt.Errorf("project: row %d, %w", n, err))
+				return
+			}
+			if !yield(out, nil) {
+				return
+			}
+			n++
+		}
+	}
+}
+
+// WriteCSV 写入表头与各行的投影结果。字段的文本与 concat 的拼接结果相同（见 uwasa.FormatValue），
+// nil 写为空字段。出错时已写出的行保留在 w 中
+func (p *Projection) WriteCSV(w io.Writer, rows iter.Seq[map[string]any]) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(p.names); err != nil {
+		return err
+	}
+	record := make([]string, len(p.names))
+	for out, err := range p.Rows(rows) {
+		if err != nil {
+			cw.Flush()
+			return err
+		}
+		for i, v := range out {
+			if v == nil {
+				record[i] = ""
+			} else {
+				record[i] = uwasa.FormatValue(v)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,115 @@
+package project
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+func testRows() []map[string]any {
+	return []map[string]any{
+		{"id": int64(1), "name": "madoka", "price": int64(10), "qty": int64(3), "tags": []any{"a"}},
+		{"id": int64(2), "name": "homura, akemi", "price": 2.5, "qty": int64(4), "tags": []any{}},
+		{"id": int64(3), "name": `say "hi"`, "price": int64(0), "qty": int64(1)},
+	}
+}
+
+func TestProjection(t *testing.T) {
+	p, err := Compile(
+		Column{Name: "id", Expr: "id"},
+		Column{Name: "total", Expr: "total = price * qty"},
+		Column{Name: "big", Expr: "total > 10"},
+		Column{Name: "first_tag", Expr: `tags?.x`},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Columns(); !slices.Equal(got, []string{"id", "total", "big", "first_tag"}) {
+		t.Errorf("Columns: got %v", got)
+	}
+
+	var got [][]any
+	for out, err := range p.Rows(slices.Values(testRows())) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, out)
+	}
+	expected := [][]any{
+		{int64(1), int64(30), true, nil},
+		{int64(2), 10.0, false, nil},
+		{int64(3), int64(0), false, nil},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Rows: expected %v, got %v", expected, got)
+	}
+
+	row, err := p.Row(map[string]any{"id": "x", "price": int64(2), "qty": int64(6)})
+	if err != nil || !reflect.DeepEqual(row, []any{"x", int64(12), true, nil}) {
+		t.Errorf("Row: got %v (%v)", row, err)
+	}
+
+	// 出错的行之后不再读取
+	q, err := Compile(Column{Name: "id", Expr: "id"}, Column{Name: "tag", Expr: "tags[0]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for out, err := range q.Rows(slices.Values(testRows())) {
+		n++
+		if n == 2 && (err == nil || out != nil || !strings.Contains(err.Error(), `row 1, column "tag"`)) {
+			t.Errorf("expected row 1 tag error, got %v (%v)", out, err)
+		}
+	}
+	if n != 2 {
+		t.Errorf("expected iteration to stop after the failing row, got %d rows", n)
+	}
+
+	if _, err := Compile(Column{Name: "a", Expr: "a"}, Column{Name: "b", Expr: "b +"}); err == nil || !strings.Contains(err.Error(), `column "b"`) {
+		t.Errorf("expected compile error naming column b, got %v", err)
+	}
+	if _, err := Compile(); err == nil {
+		t.Errorf("expected error for no columns")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	p, err := Compile(
+		Column{Name: "id", Expr: "id"},
+		Column{Name: "name", Expr: "name"},
+		Column{Name: "total", Expr: "price * qty"},
+		Column{Name: "tags", Expr: "tags"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := p.WriteCSV(&b, slices.Values(testRows())); err != nil {
+		t.Fatal(err)
+	}
+	expected := "id,name,total,tags\n" +
+		"1,madoka,30,[a]\n" +
+		"2,\"homura, akemi\",10,[]\n" +
+		"3,\"say \"\"hi\"\"\",0,\n"
+	if b.String() != expected {
+		t.Errorf("expected %q, got %q", expected, b.String())
+	}
+
+	limited, err := CompileWithOptions([]Column{{Name: "name", Expr: `concat(name, name)`}}, Options{Engine: uwasa.EngineOptions{MaxConcatBytes: 16}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	err = limited.WriteCSV(&b, slices.Values(testRows()))
+	var limit *uwasa.ConcatLimitError
+	if !errors.As(err, &limit) {
+		t.Errorf("expected ConcatLimitError, got %v", err)
+	}
+	if b.String() != "name\nmadokamadoka\n" {
+		t.Errorf("expected rows before the error to be written, got %q", b.String())
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package promctx 把一组 Prometheus 风格的指标采样包装为 uwasa.Context，告警阈值可以写成 uwasa 规则，
+// 在进程内对采样求值，而无需部署 Prometheus 的告警规则：
+//
+//	ctx := promctx.New(samples)
+//	firing, err := engine.ExecuteWithContext(ctx)
+//
+// 规则中可用的变量：
+//
+//	<指标名>  该指标的最新值（float64），如 cpu_usage > 0.9；同名的多条序列（标签不同）取各序列最新值之和
+//	series    以指标名为键的区间数据，作为 increase 的实参，如 series["http_requests_total"]
+//
+// 以空白导入或直接使用本包时注册以下内置函数：
+//
+//	increase(series["x"], "5m")  计数器在窗口内的增量，计数器重置（值变小）后从新值继续累计；
+//	                             窗口以该指标最新采样的时间为终点，可写成 time.ParseDuration 的格式或秒数。
+//	                             指标不存在（实参为 nil）时增量为 0
+//	ratio(a, b)                  a / b，b 为 0 时返回 0，用于没有流量时的错误率等比值
+//
+// 例如 5 分钟错误率超过 5%：
+//
+//	ratio(increase(series["http_errors_total"], "5m"), increase(series["http_requests_total"], "5m")) > 0.05
+//
+// 同名序列总是合并求值；需要按实例等标签分别告警时，先按标签把采样分组，再为每组创建 Context。
+// 规则可以对 Context 赋值中间结果，赋值只保存在该 Context 中，不修改采样；
+// 同一个 Context 可供多条规则依次使用，但不能在多个协程中同时使用。
+package promctx
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+func init() {
+	for name, fn := range map[string]uwasa.BuiltinFunc{"increase": increase, "ratio": ratio} {
+		if err := uwasa.RegisterBuiltin(name, fn, uwasa.BuiltinOptions{Pure: true}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Sample 是一个指标采样
+type Sample struct {
+	Metric string
+	Labels map[string]string
+	Value  float64
+	Time   time.Time
+}
+
+// Metric 是同名指标的全部序列，规则中以 series["name"] 取得
+type Metric struct {
+	Name string
+	// series 为各条序列的采样，按时间升序
+	series [][]Sample
+}
+
+// Latest 返回各序列最新值之和
+func (m *Metric) Latest() float64 {
+	var sum float64
+	for _, s := range m.series {
+		sum += s[len(s)-1].Value
+	}
+	return sum
+}
+
+// Increase 返回各序列在以最新采样时间为终点、长为 window 的窗口内的增量之和。
+// 计数器重置（值变小）时按从 0 重新计数处理，与 Prometheus 的 increase 相同，但不做外推
+func (m *Metric) Increase(window time.Duration) float64 {
+	var end time.Time
+	for _, s := range m.series {
+		if t := s[len(s)-1].Time; t.After(end) {
+			end = t
+		}
+	}
+	start := end.Add(-window)
+	var sum float64
+	for _, s := range m.series {
+		i, _ := slices.BinarySearchFunc(s, start, func(x Sample, t time.Time) int { return x.Time.Compare(t) })
+		for ; i+1 < len(s); i++ {
+			if d := s[i+1].Value - s[i].Value; d >= 0 {
+				sum += d
+			} else {
+				sum += s[i+1].Value
+			}
+		}
+	}
+	return sum
+}
+
+// Context 是一组采样的上下文，实现 uwasa.Context
+type Context struct {
+	latest map[string]any
+	series map[string]any
+	vars   map[string]any
+}
+
+// New 返回 samples 的上下文。采样按指标名与标签分为序列，各序列内按时间排序，不要求 samples 有序
+func New(samples []Sample) *Context {
+	bySeries := make(map[string][]Sample)
+	var keys []string
+	for _, s := range samples {
+		k := seriesKey(s)
+		if _, ok := bySeries[k]; !ok {
+			keys = append(keys, k)
+		}
+		bySeries[k] = append(bySeries[k], s)
+	}
+	c := &Context{latest: make(map[string]any), series: make(map[string]any)}
+	for _, k := range keys {
+		s := bySeries[k]
+		slices.SortStableFunc(s, func(a, b Sample) int { return a.Time.Compare(b.Time) })
+		m, _ := c.series[s[0].Metric].(*Metric)
+		if m == nil {
+			m = &Metric{Name: s[0].Metric}
+			c.series[m.Name] = m
+		}
+		m.series = append(m.series, s)
+	}
+	for name, m := range c.series {
+		c.latest[name] = m.(*Metric).Latest()
+	}
+	return c
+}
+
+// seriesKey 以指标名与排序后的标签标识一条序列
+func seriesKey(s Sample) string {
+	var b strings.Builder
+	b.WriteString(s.Metric)
+	for _, k := range slices.Sorted(maps.Keys(s.Labels)) {
+		b.WriteString("\xff" + k + "=" + s.Labels[k])
+	}
+	return b.String()
+}
+
+// Get 实现 uwasa.Context：先查规则赋值的变量，再查 series 与各指标的最新值
+func (c *Context) Get(name string) (any, bool) {
+	if v, ok := c.vars[name]; ok {
+		return v, true
+	}
+	if name == "series" {
+		return c.series, true
+	}
+	v, ok := c.latest[name]
+	return v, ok
+}
+
+// Set 实现 uwasa.Context，把规则的赋值保存在本上下文中
+func (c *Context) Set(name string, value any) error {
+	if c.vars == nil {
+		c.vars = make(map[string]any)
+	}
+	c.vars[name] = value
+	return nil
+}
+
+// increase 实现内置函数 increase(series, window)
+func increase(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("increase expects 2 arguments, got %d", len(args))
+	}
+	var window time.Duration
+	switch w := args[1].(type) {
+	case string:
+		d, err := time.ParseDuration(w)
+		if err != nil {
+			return nil, fmt.Errorf("increase: invalid window %q", w)
+		}
+		window = d
+	case int64:
+		window = time.Duration(w) * time.Second
+	case float64:
+		window = time.Duration(w * float64(time.Second))
+	default:
+		return nil, fmt.Errorf("increase expects a duration string or seconds as window, got %T", args[1])
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("increase: window must be positive, got %v", window)
+	}
+	switch m := args[0].(type) {
+	case nil:
+		return 0.0, nil
+	case *Metric:
+		return m.Increase(window), nil
+	}
+	return nil, fmt.Errorf("increase expects a series such as series[\"name\"], got %T", args[0])
+}
+
+// ratio 实现内置函数 ratio(a, b)
+func ratio(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("ratio expects 2 arguments, got %d", len(args))
+	}
+	var f [2]float64
+	for i, a := range args {
+		switch v := a.(type) {
+		case float64:
+			f[i] = v
+		case int64:
+			f[i] = float64(v)
+		default:
+			return nil, fmt.Errorf("ratio expects numbers, got %T", a)
+		}
+	}
+	if f[1] == 0 {
+		return 0.0, nil
+	}
+	return f[0] / f[1], nil
+}
@@ -0,0 +1,65 @@
+package promctx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kamihama-railway/uwasa"
+	"github.com/kamihama-railway/uwasa/internal/enginetest"
+)
+
+func samples() []Sample {
+	t0 := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+	web1 := map[string]string{"instance": "web-1"}
+	web2 := map[string]string{"instance": "web-2"}
+	return []Sample{
+		{Metric: "cpu_usage", Labels: web1, Value: 0.5, Time: at(9)},
+		{Metric: "cpu_usage", Labels: web1, Value: 0.95, Time: at(10)},
+		// 乱序给出的采样按时间排序
+		{Metric: "http_requests_total", Labels: web1, Value: 400, Time: at(10)},
+		{Metric: "http_requests_total", Labels: web1, Value: 100, Time: at(0)},
+		{Metric: "http_requests_total", Labels: web1, Value: 200, Time: at(5)},
+		// web-2 在第 8 分钟重启，计数器从 0 重新开始
+		{Metric: "http_requests_total", Labels: web2, Value: 300, Time: at(5)},
+		{Metric: "http_requests_total", Labels: web2, Value: 350, Time: at(7)},
+		{Metric: "http_requests_total", Labels: web2, Value: 50, Time: at(10)},
+		{Metric: "http_errors_total", Labels: web1, Value: 10, Time: at(5)},
+		{Metric: "http_errors_total", Labels: web1, Value: 40, Time: at(10)},
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	enginetest.Run(t, enginetest.Table{
+		{`cpu_usage`, 0.95},
+		{`cpu_usage > 0.9`, true},
+		{`http_requests_total`, 450.0},
+		{`increase(series["http_requests_total"], "5m")`, 300.0},
+		{`increase(series["http_requests_total"], 300)`, 300.0},
+		{`increase(series["http_requests_total"], "1h")`, 400.0},
+		{`increase(series["missing"], "5m")`, 0.0},
+		{`ratio(increase(series["http_errors_total"], "5m"), increase(series["http_requests_total"], "5m")) > 0.05`, true},
+		{`ratio(1, 0)`, 0.0},
+		{`rate = ratio(increase(series["http_errors_total"], "5m"), 300); rate`, 0.1},
+		{`missing == nil`, true},
+	}, nil, func(engine *uwasa.Engine) (any, error) {
+		return engine.ExecuteWithContext(New(samples()))
+	})
+}
+
+func TestIncreaseErrors(t *testing.T) {
+	for _, input := range []string{
+		`increase(cpu_usage, "5m")`,
+		`increase(series["cpu_usage"], "5 minutes")`,
+		`increase(series["cpu_usage"], 0)`,
+		`ratio("a", 1)`,
+	} {
+		engine, err := uwasa.NewEngineVMNeo(input)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if _, err := engine.ExecuteWithContext(New(samples())); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
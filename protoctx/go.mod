@@ -0,0 +1,9 @@
+module github.com/kamihama-railway/uwasa/protoctx
+
+go 1.26
+
+require github.com/kamihama-railway/uwasa v0.0.0
+
+require google.golang.org/protobuf v1.36.12
+
+replace github.com/kamihama-railway/uwasa => ../
@@ -0,0 +1,216 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package protoctx 把 proto.Message 包装为只读的 uwasa.Context，envoy、gRPC 一类以 protobuf 传递的
+// 请求与元数据可以直接交给规则求值，无需在每次调用时把整个消息转换为映射：
+//
+//	ctx := protoctx.New(req)
+//	allowed, err := engine.ExecuteWithContext(ctx)
+//
+// 消息的顶层字段即规则中的变量，字段名（如 request_id）与 JSON 名（如 requestId）均可使用。字段值的转换：
+//
+//	整数           int64（uint64 超出 int64 范围时按位转换）
+//	float、double  float64
+//	string、bytes  string、[]byte（与消息共享，不应修改）
+//	枚举           值的名称，如 "GET"；未定义的值为其编号
+//	repeated、map  []any、map[string]any，map 的键转为字符串
+//	消息           map[string]any，未设置的消息字段为 nil
+//
+// 具有显式存在性的字段（消息、oneof 成员、proto2 与 proto3 optional）未设置时为 nil，其余未设置的字段为零值。
+// 常用的 well-known 类型转换为对应的值：Timestamp 为 time.Time（UTC），Duration 为 time.Duration，
+// Struct、ListValue、Value 与 StringValue 等包装类型为其中的值，envoy 的 filter_metadata 因此可以写成
+// metadata?.filter_metadata["envoy.lb"]?.canary == true。
+//
+// 每种消息类型的字段表在首次使用时构建并缓存，由所有 Context 共享；顶层的 repeated、map 与消息字段在首次读取时
+// 转换并缓存在该 Context 中。同一个 Context 可供多条规则依次使用，但不能在多个协程中同时使用。
+package protoctx
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrReadOnly 为规则向消息上下文赋值时返回的错误
+var ErrReadOnly = errors.New("protoctx: message context is read-only")
+
+// Context 是一个消息的只读上下文，实现 uwasa.Context
+type Context struct {
+	m    protoreflect.Message
+	info *messageInfo
+	vals map[string]any
+}
+
+// New 返回 m 的消息上下文
+func New(m proto.Message) *Context {
+	c := &Context{}
+	c.Reset(m)
+	return c
+}
+
+// Reset 让上下文改为读取 m，并丢弃已转换的字段，便于放入 sync.Pool 复用
+func (c *Context) Reset(m proto.Message) {
+	c.m = m.ProtoReflect()
+	c.info = infoOf(c.m.Descriptor())
+	clear(c.vals)
+}
+
+// Get 实现 uwasa.Context，按字段名或 JSON 名返回顶层字段的值；其他名称不存在
+func (c *Context) Get(name string) (any, bool) {
+	if v, ok := c.vals[name]; ok {
+		return v, true
+	}
+	fd, ok := c.info.fields[name]
+	if !ok {
+		return nil, false
+	}
+	v := fieldValue(c.m, fd)
+	if fd.IsList() || fd.IsMap() || fd.Message() != nil {
+		if c.vals == nil {
+			c.vals = make(map[string]any)
+		}
+		c.vals[name] = v
+	}
+	return v, true
+}
+
+// Set 实现 uwasa.Context。消息上下文是只读的，总是返回 ErrReadOnly
+func (c *Context) Set(name string, value any) error {
+	return ErrReadOnly
+}
+
+// wellKnown 标识需要特殊转换的 well-known 类型
+type wellKnown uint8
+
+const (
+	plainMessage wellKnown = iota
+	timestampMessage
+	durationMessage
+	valueMessage
+	// unwrapMessage 为 Struct、ListValue 与各包装类型，取其唯一字段的值
+	unwrapMessage
+)
+
+// messageInfo 是一种消息类型的字段表
+type messageInfo struct {
+	// fields 以字段名与 JSON 名为键
+	fields map[string]protoreflect.FieldDescriptor
+	kind   wellKnown
+}
+
+// infos 缓存各消息类型的 messageInfo，键为 protoreflect.MessageDescriptor
+var infos sync.Map
+
+func infoOf(md protoreflect.MessageDescriptor) *messageInfo {
+	if info, ok := infos.Load(md); ok {
+		return info.(*messageInfo)
+	}
+	fds := md.Fields()
+	info := &messageInfo{fields: make(map[string]protoreflect.FieldDescriptor, fds.Len())}
+	for i := range fds.Len() {
+		fd := fds.Get(i)
+		info.fields[string(fd.Name())] = fd
+		if _, ok := info.fields[fd.JSONName()]; !ok {
+			info.fields[fd.JSONName()] = fd
+		}
+	}
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		info.kind = timestampMessage
+	case "google.protobuf.Duration":
+		info.kind = durationMessage
+	case "google.protobuf.Value":
+		info.kind = valueMessage
+	case "google.protobuf.Struct", "google.protobuf.ListValue",
+		"google.protobuf.DoubleValue", "google.protobuf.FloatValue", "google.protobuf.Int64Value",
+		"google.protobuf.UInt64Value", "google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		info.kind = unwrapMessage
+	}
+	actual, _ := infos.LoadOrStore(md, info)
+	return actual.(*messageInfo)
+}
+
+// fieldValue 返回 m 中字段 fd 的值
+func fieldValue(m protoreflect.Message, fd protoreflect.FieldDescriptor) any {
+	switch {
+	case fd.IsList():
+		l := m.Get(fd).List()
+		arr := make([]any, l.Len())
+		for i := range arr {
+			arr[i] = singularValue(fd, l.Get(i))
+		}
+		return arr
+	case fd.IsMap():
+		mv := m.Get(fd).Map()
+		out := make(map[string]any, mv.Len())
+		mv.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			out[k.String()] = singularValue(fd.MapValue(), v)
+			return true
+		})
+		return out
+	case fd.HasPresence() && !m.Has(fd):
+		return nil
+	}
+	return singularValue(fd, m.Get(fd))
+}
+
+// singularValue 转换非 repeated 的值 v，fd 为其字段（map 的值为 MapValue）
+func singularValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return int64(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return v.Bytes()
+	case protoreflect.EnumKind:
+		if fd.Enum().FullName() == "google.protobuf.NullValue" {
+			return nil
+		}
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int64(v.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageValue(v.Message())
+	}
+	return nil
+}
+
+// messageValue 把消息转换为映射，well-known 类型转换为对应的值
+func messageValue(m protoreflect.Message) any {
+	info := infoOf(m.Descriptor())
+	fds := m.Descriptor().Fields()
+	switch info.kind {
+	case timestampMessage:
+		return time.Unix(m.Get(fds.ByNumber(1)).Int(), m.Get(fds.ByNumber(2)).Int()).UTC()
+	case durationMessage:
+		return time.Duration(m.Get(fds.ByNumber(1)).Int())*time.Second + time.Duration(m.Get(fds.ByNumber(2)).Int())
+	case valueMessage:
+		// Value 的各种取值为同一个 oneof 的成员，未设置时为 nil
+		fd := m.WhichOneof(fds.Get(0).ContainingOneof())
+		if fd == nil {
+			return nil
+		}
+		return singularValue(fd, m.Get(fd))
+	case unwrapMessage:
+		return fieldValue(m, fds.Get(0))
+	}
+	out := make(map[string]any, fds.Len())
+	for i := range fds.Len() {
+		fd := fds.Get(i)
+		out[string(fd.Name())] = fieldValue(m, fd)
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package protoctx
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kamihama-railway/uwasa"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// checkRequest 模仿 envoy 外部鉴权的请求
+const checkRequest = `
+name: "check.proto" package: "test" syntax: "proto3"
+dependency: ["google/protobuf/struct.proto", "google/protobuf/timestamp.proto", "google/protobuf/duration.proto", "google/protobuf/wrappers.proto"]
+message_type {
+  name: "Peer"
+  field { name: "address" number: 1 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "address" }
+  field { name: "port" number: 2 type: TYPE_UINT32 label: LABEL_OPTIONAL json_name: "port" }
+}
+message_type {
+  name: "CheckRequest"
+  field { name: "method" number: 1 type: TYPE_ENUM type_name: ".test.Method" label: LABEL_OPTIONAL json_name: "method" }
+  field { name: "path" number: 2 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "path" }
+  field { name: "headers" number: 3 type: TYPE_MESSAGE type_name: ".test.CheckRequest.HeadersEntry" label: LABEL_REPEATED json_name: "headers" }
+  field { name: "metadata" number: 4 type: TYPE_MESSAGE type_name: ".google.protobuf.Struct" label: LABEL_OPTIONAL json_name: "metadata" }
+  field { name: "time" number: 5 type: TYPE_MESSAGE type_name: ".google.protobuf.Timestamp" label: LABEL_OPTIONAL json_name: "time" }
+  field { name: "timeout" number: 6 type: TYPE_MESSAGE type_name: ".google.protobuf.Duration" label: LABEL_OPTIONAL json_name: "timeout" }
+  field { name: "tenant" number: 7 type: TYPE_MESSAGE type_name: ".google.protobuf.StringValue" label: LABEL_OPTIONAL json_name: "tenant" }
+  field { name: "peers" number: 8 type: TYPE_MESSAGE type_name: ".test.Peer" label: LABEL_REPEATED json_name: "peers" }
+  field { name: "source" number: 9 type: TYPE_MESSAGE type_name: ".test.Peer" label: LABEL_OPTIONAL json_name: "source" }
+  field { name: "retry_count" number: 10 type: TYPE_INT32 label: LABEL_OPTIONAL json_name: "retryCount" }
+  field { name: "priority" number: 11 type: TYPE_INT32 label: LABEL_OPTIONAL json_name: "priority" proto3_optional: true oneof_index: 0 }
+  nested_type {
+    name: "HeadersEntry"
+    field { name: "key" number: 1 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "key" }
+    field { name: "value" number: 2 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "value" }
+    options { map_entry: true }
+  }
+  oneof_decl { name: "_priority" }
+}
+enum_type {
+  name: "Method"
+  value { name: "METHOD_UNSPECIFIED" number: 0 }
+  value { name: "GET" number: 1 }
+  value { name: "POST" number: 2 }
+}
+`
+
+func newCheckRequest(t *testing.T, text string) proto.Message {
+	t.Helper()
+	var fdp descriptorpb.FileDescriptorProto
+	if err := prototext.Unmarshal([]byte(checkRequest), &fdp); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := protodesc.NewFile(&fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := dynamicpb.NewMessage(fd.Messages().ByName("CheckRequest"))
+	if err := prototext.Unmarshal([]byte(text), m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestContext(t *testing.T) {
+	req := newCheckRequest(t, `
+method: POST
+path: "/api/orders"
+headers { key: "x-user" value: "alice" }
+metadata { fields { key: "filter_metadata" value { struct_value { fields { key: "envoy.lb" value { struct_value { fields { key: "canary" value { bool_value: true } } } } } } } } }
+time { seconds: 1704067200 nanos: 500 }
+timeout { seconds: 2 nanos: 500000000 }
+tenant { value: "acme" }
+peers { address: "10.0.0.1" port: 8080 }
+peers { address: "10.0.0.2" port: 4294967295 }
+`)
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`method == "POST" && path == "/api/orders"`, true},
+		{`headers["x-user"]`, "alice"},
+		{`metadata?.filter_metadata["envoy.lb"]?.canary == true`, true},
+		{`time`, time.Date(2024, 1, 1, 0, 0, 0, 500, time.UTC)},
+		{`time > @2023-12-31`, true},
+		{`timeout`, 2500 * time.Millisecond},
+		{`tenant`, "acme"},
+		{`len(peers)`, int64(2)},
+		{`peers[1]?.port`, int64(4294967295)},
+		{`peers[0]`, map[string]any{"address": "10.0.0.1", "port": int64(8080)}},
+		{`source`, nil},
+		{`retryCount + retry_count`, int64(0)},
+		{`priority == nil`, true},
+		{`defined(missing)`, false},
+	}
+	ctx := New(req)
+	for _, tt := range tests {
+		engine, err := uwasa.NewEngineVMNeo(tt.input)
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", tt.input, err)
+		}
+		got, err := engine.ExecuteWithContext(ctx)
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.input, tt.expected, got, err)
+		}
+	}
+
+	engine, _ := uwasa.NewEngineVMNeo(`path = "/"`)
+	if _, err := engine.ExecuteWithContext(ctx); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	engine, _ := uwasa.NewEngineVMNeo(`len(peers) > 0 && priority > 1`)
+	ctx := New(newCheckRequest(t, `peers { address: "a" } priority: 3`))
+	if got, err := engine.ExecuteWithContext(ctx); err != nil || got != true {
+		t.Errorf("expected true, got %v (%v)", got, err)
+	}
+	// Reset 之后不能读到上一个消息缓存的字段
+	ctx.Reset(newCheckRequest(t, `priority: 3`))
+	if got, err := engine.ExecuteWithContext(ctx); err != nil || got != false {
+		t.Errorf("expected false after Reset, got %v (%v)", got, err)
+	}
+}
+
+func TestGeneratedMessage(t *testing.T) {
+	fdp := protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto)
+	engine, _ := uwasa.NewEngineVMNeo(`name == "google/protobuf/descriptor.proto" && messageType[0]?.name == "FileDescriptorSet" && options?.java_package != nil`)
+	if got, err := engine.ExecuteWithContext(New(fdp)); err != nil || got != true {
+		t.Errorf("expected true, got %v (%v)", got, err)
+	}
+	// 同一类型的字段表只构建一次
+	if infoOf(fdp.ProtoReflect().Descriptor()) != infoOf((&descriptorpb.FileDescriptorProto{}).ProtoReflect().Descriptor()) {
+		t.Errorf("expected descriptor info to be cached")
+	}
+}
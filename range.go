@@ -0,0 +1,69 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"math"
+)
+
+// Range 是区间字面量 `start..end` 求值得到的整数区间，两端均包含在内；start 大于 end 时为空区间。
+// 区间不展开为数组，`x in a..b` 只比较两端
+type Range struct {
+	Start, End int64
+}
+
+// String 返回 `start..end`，即 concat 拼接区间时的文本
+func (r Range) String() string {
+	return fmt.Sprintf("%d..%d", r.Start, r.End)
+}
+
+// Len 返回区间内的整数个数
+func (r Range) Len() int64 {
+	if r.Start > r.End {
+		return 0
+	}
+	return r.End - r.Start + 1
+}
+
+// makeRange 以两端构造区间，两端都必须是整数
+func makeRange(start, end any) (Range, error) {
+	s, ok1 := start.(int64)
+	e, ok2 := end.(int64)
+	if !ok1 || !ok2 {
+		return Range{}, fmt.Errorf("range bounds must be integers, got %T..%T", start, end)
+	}
+	return Range{Start: s, End: e}, nil
+}
+
+// contains 判断 v 是否落在区间内。浮点数按数值比较，其余类型返回错误
+func (r Range) contains(v Value) (bool, error) {
+	switch v.Type {
+	case ValInt:
+		n := int64(v.Num)
+		return r.Start <= n && n <= r.End, nil
+	case ValFloat:
+		f := math.Float64frombits(v.Num)
+		return float64(r.Start) <= f && f <= float64(r.End), nil
+	}
+	return false, fmt.Errorf("'in' range expects a number, got %T", v.ToInterface())
+}
+
+// rangeValue 把两端构造为区间值，供各 VM 的 MKRANGE 使用
+func rangeValue(start, end Value) (Value, error) {
+	r, err := makeRange(start.ToInterface(), end.ToInterface())
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Type: ValObject, Obj: r}, nil
+}
+
+// inRange 实现 INRANGE：needle 是否落在常量区间 c 内
+func inRange(needle, c Value) (Value, error) {
+	found, err := c.Obj.(Range).contains(needle)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Type: ValBool, Num: boolToUint64(found)}, nil
+}
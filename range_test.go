@@ -0,0 +1,67 @@
+package uwasa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`x in 1..10`, true},
+		{`x in 6..10`, false},
+		{`x in 10..1`, false},
+		{`x in lo..hi`, true},
+		{`x in 1..x`, true},
+		{`x + 1 in 2..lo + 5`, true},
+		{`score in 0..100`, true},
+		{`score in 0..99`, false},
+		{`neg in -5..-1`, true},
+		{`5 in 1..10`, true},
+		{`if x in 1..9 is "digit" else is "other"`, "digit"},
+		{`let r = lo..hi => [3 in r, 0 in r, len(r)]`, []any{true, false, int64(8)}},
+		{`r = 1..x; concat(r, "/", len(r))`, "1..5/5"},
+		{`1..3`, Range{Start: 1, End: 3}},
+		{`lo..hi`, Range{Start: 2, End: 9}},
+		{`len(3..1)`, int64(0)},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"x": int64(5), "lo": int64(2), "hi": int64(9), "score": 99.5, "neg": int64(-3)}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+
+		for _, bad := range []string{`x in 1.5..3`, `"a" in 1..3`, `"a" in lo..hi`, `x in lo..score`} {
+			engine, err := newEngine(bad)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, bad, err)
+				continue
+			}
+			if _, err := engine.Execute(vars()); err == nil {
+				t.Errorf("%s %s: expected execute error", name, bad)
+			}
+		}
+		for _, bad := range []string{`1..2..3`, `(1..2)..3`, `1..`} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected compile error", name, bad)
+			}
+		}
+	}
+}
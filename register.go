@@ -0,0 +1,42 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// registerMu 串行化各 Register 函数，使“检查后写入”的注册作为一个整体完成
+var registerMu sync.Mutex
+
+// table 是写时复制的注册表：写入时复制整张表后原子替换，编译与执行期经 load 无锁读取快照
+type table[K comparable, V any] struct {
+	p atomic.Pointer[map[K]V]
+}
+
+func newTable[K comparable, V any](m map[K]V) *table[K, V] {
+	t := &table[K, V]{}
+	t.p.Store(&m)
+	return t
+}
+
+func (t *table[K, V]) load() map[K]V {
+	return *t.p.Load()
+}
+
+func (t *table[K, V]) get(k K) (V, bool) {
+	v, ok := t.load()[k]
+	return v, ok
+}
+
+// put 须在持有 registerMu 时调用
+func (t *table[K, V]) put(k K, v V) {
+	old := t.load()
+	m := make(map[K]V, len(old)+1)
+	maps.Copy(m, old)
+	m[k] = v
+	t.p.Store(&m)
+}
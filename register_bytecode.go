@@ -31,6 +31,39 @@ const (
 	ROpCall
 	ROpConcat
 	ROpReturn
+	ROpInSet
+	ROpReturnTuple
+	ROpToBool
+	ROpMakeArray
+	ROpIndex
+	ROpSetIndex
+	ROpMakeMap
+	ROpCopyConst
+	ROpCallMethod
+	ROpIn
+	ROpBitAnd // 位运算指令的顺序与 TokenBitAnd 至 TokenShr 一致
+	ROpBitOr
+	ROpBitXor
+	ROpShl
+	ROpShr
+	ROpCallLocal // 调用 Functions[Arg]，实参位于 Src1 起的 Src2 个寄存器，结果写入 Dest
+	ROpReturnLocal // 函数块结束：以 Src1 为返回值回到调用处
+	ROpMakeClosure // 以 Functions[Arg] 构造闭包写入 Dest，捕获 Src1 起的 Src2 个寄存器
+	ROpJumpIfNotMap // Src1 不是映射时向 Dest 写入 nil 并跳转到 Arg，用于可选链 `a?.b`
+	ROpMapGetConst // Dest = Src1[常量 Arg]，成员缺失时为 nil
+	ROpMakeRange // Dest = Src1..Src2
+	ROpInRange // Dest = Src1 in 常量区间 Arg
+	ROpSpread // 把数组 Src1 的元素追加到 Dest 处 MKARR 新建的数组，用于 `[...a]`
+	ROpCast // Dest = Src1 转换为 castKind(Arg) 类型，用于单参数的 int、float、str、bool
+	ROpScore // Src1 为真时 Dest += 常量 Arg，用于 `score { ... }`
+	ROpDefined // Dest = 上下文中是否存在名为常量 Arg 的变量（值为 nil 也算存在），用于 `defined(x)`
+	ROpTry // 登记错误处理现场：此后出错时跳转到 Arg 处求备用值，用于 `try(expr, fallback)`
+	ROpEndTry // 撤销最近一次 TRY 的登记并跳转到 Arg，跳过备用值
+	ROpSlice // Dest = 按打包在 Arg 中的常量边界截取 Src1，用于 `slice(x, start, end)`
+	ROpIter // 检查 Src1 为数组后写入 Dest，并把 Dest+1 置为下标 0，开始内联的 map、filter、reduce 循环，Arg 为 iterKind
+	ROpIterNext // Src1 为数组、Src1+1 为下标：下标未越界时 Dest = 当前元素并把下标加一，否则跳转到 Arg
+	ROpAppend // 把 Src1 追加到 Dest 处循环新建的数组
+	ROpEqualFold // Dest = Src1 ==* Src2：字符串之间不区分大小写比较，其余同 EQ
 )
 
 func (o ROpCode) String() string {
@@ -58,6 +91,39 @@ func (o ROpCode) String() string {
 	case ROpCall: return "CALL"
 	case ROpConcat: return "CONCAT"
 	case ROpReturn: return "RET"
+	case ROpInSet: return "INSET"
+	case ROpReturnTuple: return "RETT"
+	case ROpToBool: return "TOBOOL"
+	case ROpMakeArray: return "MKARR"
+	case ROpIndex: return "INDEX"
+	case ROpSetIndex: return "SETIDX"
+	case ROpMakeMap: return "MKMAP"
+	case ROpCopyConst: return "COPYC"
+	case ROpCallMethod: return "CALLM"
+	case ROpIn: return "IN"
+	case ROpBitAnd: return "BAND"
+	case ROpBitOr: return "BOR"
+	case ROpBitXor: return "BXOR"
+	case ROpShl: return "SHL"
+	case ROpShr: return "SHR"
+	case ROpCallLocal: return "CALLL"
+	case ROpReturnLocal: return "RETL"
+	case ROpMakeClosure: return "MKCLOS"
+	case ROpJumpIfNotMap: return "JNMAP"
+	case ROpMapGetConst: return "MGETC"
+	case ROpMakeRange: return "MKRANGE"
+	case ROpInRange: return "INRANGE"
+	case ROpSpread: return "SPREAD"
+	case ROpCast: return "CAST"
+	case ROpScore: return "SCORE"
+	case ROpDefined: return "DEFINED"
+	case ROpTry: return "TRY"
+	case ROpEndTry: return "ENDTRY"
+	case ROpSlice: return "SLICE"
+	case ROpIter: return "ITER"
+	case ROpIterNext: return "ITERNEXT"
+	case ROpAppend: return "APPEND"
+	case ROpEqualFold: return "EQFOLD"
 	default: return fmt.Sprintf("RUNKNOWN(%d)", o)
 	}
 }
@@ -71,7 +137,16 @@ type regInstruction struct {
 }
 
 type RegisterBytecode struct {
-	Instructions []regInstruction
-	Constants    []Value
-	MaxRegisters uint8
+	Instructions   []regInstruction
+	Constants      []Value
+	MaxRegisters   uint8
+	Sets           []*ValueSet
+	MaxConcatBytes int // 单次执行拼接输出的累计字节上限，0 表示不限制
+	// Functions 为规则内 fn 定义编译出的独立字节码块，下标即 CALLL 的 Arg。
+	// 被调函数以实参所在的寄存器为窗口起点，Params 个实参即其最低的寄存器
+	Functions []*RegisterBytecode
+	Name      string
+	Params    int
+	// concat 仅由闭包入口块设置，使 lambda 调用沿用创建它的那次执行的拼接计数
+	concat *concatBudget
 }
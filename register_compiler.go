@@ -6,6 +6,8 @@ package uwasa
 import (
 	"fmt"
 	"math"
+	"sort"
+	"time"
 )
 
 type RegisterCompiler struct {
@@ -13,7 +15,28 @@ type RegisterCompiler struct {
 	constants    []Value
 	constMap     map[any]int32
 	maxReg       uint8
+	sets         []*ValueSet
+	hoisted      map[string]uint8
 	errors       []string
+	locals       []regLocal
+	// chunks 为整条规则共享的函数块表；written 为函数体与 lambda 中赋值过的全局变量，
+	// 调用函数或内置函数后其值可能改变，不参与提升
+	chunks  *chunkTable[*RegisterBytecode]
+	written map[string]bool
+	// memo 为当前块的调用复用规划，暂存位依次映射到 memoBase 起的寄存器；
+	// memoWritten 与 memoSafe 由 memoBarriers 对整条规则求出，子编译器共用
+	memo        *callMemoPlan
+	memoBase    int
+	memoWritten map[string]bool
+	// branchHints 为 branchHintKeys 化简后的分支提示，子编译器共用
+	branchHints map[string]BranchHint
+	memoSafe    bool
+}
+
+// regLocal 是一个 let 绑定及其所在寄存器；绑定寄存器之上的寄存器才会用于求值 body
+type regLocal struct {
+	name string
+	reg  uint8
 }
 
 func NewRegisterCompiler() *RegisterCompiler {
@@ -23,50 +46,219 @@ func NewRegisterCompiler() *RegisterCompiler {
 }
 
 func (c *RegisterCompiler) Compile(node Node) (*RegisterBytecode, error) {
-	finalReg, err := c.walk(node, 0)
-	if err != nil {
-		return nil, err
+	c.chunks = &chunkTable[*RegisterBytecode]{}
+	c.written = make(map[string]bool)
+	c.memoWritten, c.memoSafe = memoBarriers(node)
+	collectWritten := func(body Node) {
+		walk(body, func(n Node) {
+			switch n := n.(type) {
+			case *AssignExpression:
+				c.written[n.Name.Value] = true
+			case *DestructureExpression:
+				for _, name := range n.Names {
+					c.written[name.Value] = true
+				}
+			}
+		})
+	}
+	walk(node, func(n Node) {
+		if lambda, ok := n.(*LambdaLiteral); ok {
+			collectWritten(lambda.Body)
+		}
+	})
+	if prog, ok := node.(*Program); ok {
+		for _, fn := range prog.Functions {
+			collectWritten(fn.Body)
+		}
+		for _, fn := range prog.Functions {
+			chunk, err := c.compileFunction(fn)
+			if err != nil {
+				return nil, err
+			}
+			c.chunks.add(fn.Name.Value, chunk)
+		}
+		node = prog.Body
+	}
+	base := c.planMemo(node, c.hoistGlobals(node, 0))
+	if seq, ok := node.(*SequenceExpression); ok {
+		// 除最后一条外，语句的值被丢弃；最后一条可以是元组
+		last := len(seq.Statements) - 1
+		for _, stmt := range seq.Statements[:last] {
+			if _, err := c.walk(stmt, base); err != nil {
+				return nil, err
+			}
+		}
+		node = seq.Statements[last]
+	}
+	if tuple, ok := node.(*TupleExpression); ok {
+		// 元素依次落入连续寄存器，由 RETT 一并返回
+		for i, el := range tuple.Elements {
+			reg, err := c.walk(el, base+i)
+			if err != nil {
+				return nil, err
+			}
+			if reg != base+i {
+				c.emit(ROpMove, uint8(base+i), uint8(reg), 0, 0)
+			}
+		}
+		c.emit(ROpReturnTuple, 0, uint8(base), uint8(len(tuple.Elements)), 0)
+	} else {
+		finalReg, err := c.walk(node, base)
+		if err != nil {
+			return nil, err
+		}
+		c.emit(ROpReturn, 0, uint8(finalReg), 0, 0)
 	}
-	c.emit(ROpReturn, 0, uint8(finalReg), 0, 0)
 
 	bc := &RegisterBytecode{
 		Instructions: c.instructions,
 		Constants:    c.constants,
 		MaxRegisters: c.maxReg + 1,
+		Sets:         c.sets,
+		Functions:    c.chunks.chunks,
+	}
+
+	if err := checkRegisters(bc); err != nil {
+		return nil, err
 	}
+	return bc, nil
+}
 
-	// Safety check: ensure all instructions are within register bounds
+// compileFunction 用独立的编译器把函数体编译为字节码块：参数依次占据窗口最低的寄存器，
+// 函数体只能调用此前已编译的函数
+func (c *RegisterCompiler) compileFunction(fn *FunctionLiteral) (*RegisterBytecode, error) {
+	bc, err := c.compileChunk(nil, fn.Parameters, fn.Body)
+	if err != nil {
+		return nil, fmt.Errorf("function %s: %w", fn.Name.Value, err)
+	}
+	bc.Name = fn.Name.Value
+	return bc, nil
+}
+
+// compileLambda 把 lambda 编译为匿名函数块：当前可见的 let 绑定依次捕获到窗口最低的寄存器，
+// 参数紧随其后，返回登记的下标
+func (c *RegisterCompiler) compileLambda(n *LambdaLiteral) (int, error) {
+	captured := make([]string, len(c.locals))
+	for i, l := range c.locals {
+		captured[i] = l.name
+	}
+	bc, err := c.compileChunk(captured, n.Parameters, n.Body)
+	if err != nil {
+		return 0, err
+	}
+	bc.Name = lambdaName
+	return c.chunks.add("", bc), nil
+}
+
+// compileChunk 用独立的编译器编译函数体：captured 与 params 依次占据窗口最低的寄存器
+func (c *RegisterCompiler) compileChunk(captured []string, params []*Identifier, body Expression) (*RegisterBytecode, error) {
+	sub := NewRegisterCompiler()
+	sub.chunks, sub.written = c.chunks, c.written
+	sub.memoWritten, sub.memoSafe = c.memoWritten, c.memoSafe
+	sub.branchHints = c.branchHints
+	for _, name := range captured {
+		sub.locals = append(sub.locals, regLocal{name: name, reg: uint8(len(sub.locals))})
+	}
+	for _, param := range params {
+		sub.locals = append(sub.locals, regLocal{name: param.Value, reg: uint8(len(sub.locals))})
+	}
+	if len(sub.locals) > 0 {
+		sub.maxReg = uint8(len(sub.locals) - 1)
+	}
+	reg, err := sub.walk(body, sub.planMemo(body, sub.hoistGlobals(body, len(sub.locals))))
+	if err != nil {
+		return nil, err
+	}
+	sub.emit(ROpReturnLocal, 0, uint8(reg), 0, 0)
+	bc := &RegisterBytecode{
+		Instructions: sub.instructions,
+		Constants:    sub.constants,
+		MaxRegisters: sub.maxReg + 1,
+		Sets:         sub.sets,
+		Params:       len(sub.locals),
+	}
+	if err := checkRegisters(bc); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// checkRegisters 确认所有指令引用的寄存器都在 MaxRegisters 之内
+func checkRegisters(bc *RegisterBytecode) error {
 	for _, inst := range bc.Instructions {
 		switch inst.Op {
-		case ROpCall, ROpConcat:
+		case ROpCall, ROpConcat, ROpReturnTuple, ROpMakeArray, ROpMakeMap:
 			if int(inst.Src1)+int(inst.Src2) > int(bc.MaxRegisters) {
-				return nil, fmt.Errorf("register range out of bounds")
+				return fmt.Errorf("register range out of bounds")
 			}
-			if inst.Dest >= bc.MaxRegisters || inst.Src1 >= bc.MaxRegisters {
-				return nil, fmt.Errorf("register index out of bounds")
+			// 零参数时 Src1 仅是占位的起始寄存器，不会被读取
+			if inst.Dest >= bc.MaxRegisters || (inst.Src2 > 0 && inst.Src1 >= bc.MaxRegisters) {
+				return fmt.Errorf("register index out of bounds")
+			}
+		case ROpCallLocal:
+			// 调用现场暂存在 Dest 中，它必须紧邻实参之下
+			if int(inst.Src1) != int(inst.Dest)+1 || int(inst.Src1)+int(inst.Src2) > int(bc.MaxRegisters) {
+				return fmt.Errorf("register range out of bounds")
+			}
+		case ROpMakeClosure:
+			if inst.Dest >= bc.MaxRegisters || int(inst.Src1)+int(inst.Src2) > int(bc.MaxRegisters) {
+				return fmt.Errorf("register range out of bounds")
 			}
-		case ROpReturn, ROpNot, ROpMove, ROpJumpIfFalse, ROpJumpIfTrue:
+		case ROpCallMethod:
+			// 接收者位于 Src1，参数紧随其后
+			if inst.Dest >= bc.MaxRegisters || int(inst.Src1)+int(inst.Src2) >= int(bc.MaxRegisters) {
+				return fmt.Errorf("register range out of bounds")
+			}
+		case ROpReturn, ROpReturnLocal, ROpNot, ROpToBool, ROpMove, ROpInSet:
 			if inst.Dest >= bc.MaxRegisters || inst.Src1 >= bc.MaxRegisters {
-				return nil, fmt.Errorf("register index out of bounds")
+				return fmt.Errorf("register index out of bounds")
+			}
+		case ROpJumpIfFalse, ROpJumpIfTrue:
+			if inst.Src1 >= bc.MaxRegisters {
+				return fmt.Errorf("register index out of bounds")
+			}
+			if inst.Arg < 0 || int(inst.Arg) > len(bc.Instructions) {
+				return fmt.Errorf("jump target out of bounds")
 			}
 		case ROpLoadConst, ROpGetGlobal:
 			if inst.Dest >= bc.MaxRegisters {
-				return nil, fmt.Errorf("register index out of bounds")
+				return fmt.Errorf("register index out of bounds")
 			}
 		case ROpSetGlobal:
 			if inst.Src1 >= bc.MaxRegisters {
-				return nil, fmt.Errorf("register index out of bounds")
+				return fmt.Errorf("register index out of bounds")
+			}
+		case ROpJumpIfNotMap:
+			if inst.Dest >= bc.MaxRegisters || inst.Src1 >= bc.MaxRegisters {
+				return fmt.Errorf("register index out of bounds")
+			}
+			if inst.Arg < 0 || int(inst.Arg) > len(bc.Instructions) {
+				return fmt.Errorf("jump target out of bounds")
+			}
+		case ROpJump, ROpTry, ROpEndTry:
+			if inst.Arg < 0 || int(inst.Arg) > len(bc.Instructions) {
+				return fmt.Errorf("jump target out of bounds")
+			}
+		case ROpIter, ROpIterNext:
+			// 下标位于 ITER 的 Dest+1 与 ITERNEXT 的 Src1+1
+			if int(inst.Dest)+1 >= int(bc.MaxRegisters) || int(inst.Src1)+1 >= int(bc.MaxRegisters) {
+				return fmt.Errorf("register index out of bounds")
+			}
+			if inst.Op == ROpIterNext && (inst.Arg < 0 || int(inst.Arg) > len(bc.Instructions)) {
+				return fmt.Errorf("jump target out of bounds")
+			}
+		case ROpSetIndex:
+			if inst.Dest >= bc.MaxRegisters || inst.Src1 >= bc.MaxRegisters || inst.Src2 >= bc.MaxRegisters ||
+				inst.Arg < 0 || inst.Arg >= int32(bc.MaxRegisters) {
+				return fmt.Errorf("register index out of bounds")
 			}
-		case ROpJump:
-			// No registers to check
 		default:
 			if inst.Dest >= bc.MaxRegisters || inst.Src1 >= bc.MaxRegisters || inst.Src2 >= bc.MaxRegisters {
-				return nil, fmt.Errorf("register index out of bounds")
+				return fmt.Errorf("register index out of bounds")
 			}
 		}
 	}
-
-	return bc, nil
+	return nil
 }
 
 func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
@@ -81,7 +273,7 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 
 	switch n := node.(type) {
 	case *Identifier:
-		c.emit(ROpGetGlobal, uReg, 0, 0, c.addConstant(Value{Type: ValString, Str: n.Value}))
+		c.loadGlobal(uReg, n.Value)
 		return reg, nil
 
 	case *NumberLiteral:
@@ -96,6 +288,18 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 		c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(Value{Type: ValString, Str: n.Value}))
 		return reg, nil
 
+	case *TimeLiteral:
+		c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(Value{Type: ValTime, Obj: n.Value}))
+		return reg, nil
+
+	case *DurationLiteral:
+		c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(Value{Type: ValDuration, Num: uint64(n.Value)}))
+		return reg, nil
+
+	case *DecimalLiteral:
+		c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(Value{Type: ValDecimal, Obj: n.Value}))
+		return reg, nil
+
 	case *BooleanLiteral:
 		val := uint64(0)
 		if n.Value {
@@ -124,6 +328,9 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 
 	case *InfixExpression:
 		if n.Operator == "&&" {
+			if n.ReturnsOperand {
+				return c.walkOperandLogic(n, reg, ROpJumpIfFalse)
+			}
 			_, err := c.walk(n.Left, reg)
 			if err != nil {
 				return 0, err
@@ -134,8 +341,7 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 				return 0, err
 			}
 			// ensure result is boolean 0/1
-			c.emit(ROpNot, uReg, uReg, 0, 0)
-			c.emit(ROpNot, uReg, uReg, 0, 0)
+			c.emit(ROpToBool, uReg, uReg, 0, 0)
 			jumpEnd := c.emit(ROpJump, 0, 0, 0, 0)
 			c.patch(jumpFalse, int32(len(c.instructions)))
 			c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(Value{Type: ValBool, Num: 0}))
@@ -143,6 +349,15 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 			return reg, nil
 		}
 		if n.Operator == "||" {
+			if name, vals, ok := collectEqualityChain(n); ok && len(vals) >= minSetMatchSize {
+				c.loadGlobal(uReg, name)
+				c.sets = append(c.sets, NewValueSet(vals))
+				c.emit(ROpInSet, uReg, uReg, 0, int32(len(c.sets)-1))
+				return reg, nil
+			}
+			if n.ReturnsOperand {
+				return c.walkOperandLogic(n, reg, ROpJumpIfTrue)
+			}
 			_, err := c.walk(n.Left, reg)
 			if err != nil {
 				return 0, err
@@ -153,8 +368,7 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 				return 0, err
 			}
 			// ensure result is boolean 0/1
-			c.emit(ROpNot, uReg, uReg, 0, 0)
-			c.emit(ROpNot, uReg, uReg, 0, 0)
+			c.emit(ROpToBool, uReg, uReg, 0, 0)
 			jumpEnd := c.emit(ROpJump, 0, 0, 0, 0)
 			c.patch(jumpTrue, int32(len(c.instructions)))
 			c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(Value{Type: ValBool, Num: 1}))
@@ -162,6 +376,32 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 			return reg, nil
 		}
 
+		if n.Operator == "in" {
+			// `x in 1..10` 只比较两端，不构造区间
+			if r, ok := n.Right.(*RangeExpression); ok {
+				if rng, ok := constRange(r); ok {
+					lReg, err := c.walk(n.Left, reg)
+					if err != nil {
+						return 0, err
+					}
+					c.emit(ROpInRange, uReg, uint8(lReg), 0, c.addConstant(Value{Type: ValObject, Obj: rng}))
+					return reg, nil
+				}
+			}
+			// `x in [字面量...]` 与等值链同样编译为一次集合查找
+			if arr, ok := n.Right.(*ArrayLiteral); ok {
+				if vals, ok := literalValues(arr.Elements); ok && len(vals) >= minSetMatchSize {
+					lReg, err := c.walk(n.Left, reg)
+					if err != nil {
+						return 0, err
+					}
+					c.sets = append(c.sets, NewValueSet(vals))
+					c.emit(ROpInSet, uReg, uint8(lReg), 0, int32(len(c.sets)-1))
+					return reg, nil
+				}
+			}
+		}
+
 		lReg, err := c.walk(n.Left, reg)
 		if err != nil {
 			return 0, err
@@ -171,6 +411,13 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 			return 0, err
 		}
 
+		if n.Operator == "!=" {
+			// 寄存器 VM 没有专用的不等指令，以 EQ + NOT 实现
+			c.emit(ROpEqual, uReg, uint8(lReg), uint8(rReg), 0)
+			c.emit(ROpNot, uReg, uReg, 0, 0)
+			return reg, nil
+		}
+
 		var op ROpCode
 		switch n.Operator {
 		case "+": op = ROpAdd
@@ -179,10 +426,17 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 		case "/": op = ROpDiv
 		case "%": op = ROpMod
 		case "==": op = ROpEqual
+		case "==*": op = ROpEqualFold
 		case ">": op = ROpGreater
 		case "<": op = ROpLess
 		case ">=": op = ROpGreaterEqual
 		case "<=": op = ROpLessEqual
+		case "in": op = ROpIn
+		case "&": op = ROpBitAnd
+		case "|": op = ROpBitOr
+		case "^": op = ROpBitXor
+		case "<<": op = ROpShl
+		case ">>": op = ROpShr
 		default:
 			return 0, fmt.Errorf("unknown operator: %s", n.Operator)
 		}
@@ -199,6 +453,10 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 			return cReg, nil
 		}
 
+		if c.branchHints[n.Condition.String()] == BranchLikelyFalse {
+			return c.compileIfElseFirst(n, reg, cReg)
+		}
+
 		jumpFalse := c.emit(ROpJumpIfFalse, 0, uint8(cReg), 0, 0)
 		_, err = c.walk(n.Consequence, reg)
 		if err != nil {
@@ -227,35 +485,549 @@ func (c *RegisterCompiler) walk(node Node, reg int) (int, error) {
 		c.emit(ROpSetGlobal, 0, uint8(vReg), 0, c.addConstant(Value{Type: ValString, Str: n.Name.Value}))
 		return vReg, nil
 
-	case *CallExpression:
-		if ident, ok := n.Function.(*Identifier); ok && ident.Value == "concat" {
-			for i, arg := range n.Arguments {
-				_, err := c.walk(arg, reg+i)
+	case *DestructureExpression:
+		// 各成员依次经 reg+1 取出并写回，映射所在的寄存器保持不变
+		vReg, err := c.walk(n.Value, reg)
+		if err != nil {
+			return 0, err
+		}
+		if reg+1 > 250 {
+			return 0, fmt.Errorf("register limit exceeded")
+		}
+		c.maxReg = max(c.maxReg, uint8(reg+1))
+		for _, name := range n.Names {
+			k := c.addConstant(Value{Type: ValString, Str: name.Value})
+			c.emit(ROpMapGetConst, uint8(reg+1), uint8(vReg), 0, k)
+			c.emit(ROpSetGlobal, 0, uint8(reg+1), 0, k)
+		}
+		if n.Body == nil {
+			return vReg, nil
+		}
+		return c.walk(n.Body, reg)
+
+	case *SequenceExpression:
+		// let 语句之后的语句位于其 Body 中，不在顶层
+		var last int
+		for _, stmt := range n.Statements {
+			var err error
+			if last, err = c.walk(stmt, reg); err != nil {
+				return 0, err
+			}
+		}
+		return last, nil
+
+	case *LetExpression:
+		vReg, err := c.walk(n.Value, reg)
+		if err != nil {
+			return 0, err
+		}
+		if vReg != reg {
+			c.emit(ROpMove, uReg, uint8(vReg), 0, 0)
+		}
+		if len(c.locals) >= maxLetBindings {
+			return 0, fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings)
+		}
+		c.locals = append(c.locals, regLocal{name: n.Name.Value, reg: uReg})
+		bReg, err := c.walk(n.Body, reg+1)
+		c.locals = c.locals[:len(c.locals)-1]
+		if err != nil {
+			return 0, err
+		}
+		c.emit(ROpMove, uReg, uint8(bReg), 0, 0)
+		return reg, nil
+
+	case *LambdaLiteral:
+		// 捕获的绑定依次搬到 reg 起的连续寄存器，MKCLOS 将其复制进闭包
+		if reg+len(c.locals) > 250 {
+			return 0, fmt.Errorf("register limit exceeded")
+		}
+		i, err := c.compileLambda(n)
+		if err != nil {
+			return 0, err
+		}
+		for k, l := range c.locals {
+			c.emit(ROpMove, uint8(reg+k), l.reg, 0, 0)
+		}
+		if k := reg + len(c.locals) - 1; k > int(c.maxReg) {
+			c.maxReg = uint8(k)
+		}
+		c.emit(ROpMakeClosure, uReg, uReg, uint8(len(c.locals)), int32(i))
+		return reg, nil
+
+	case *ArrayLiteral:
+		// 展开之前的元素依次落入 reg 起的连续寄存器，MKARR 将其收集到 reg；其后每个展开的数组，
+		// 以及每段普通元素在 reg+1 起收集为数组后，依次由 SPREAD 追加到 reg
+		lead := spreadIndex(n.Elements, 0)
+		if err := c.collect(n.Elements[:lead], reg); err != nil {
+			return 0, err
+		}
+		for i := lead; i < len(n.Elements); {
+			if spread, ok := n.Elements[i].(*SpreadElement); ok {
+				sReg, err := c.walk(spread.Value, reg+1)
 				if err != nil {
 					return 0, err
 				}
+				c.emit(ROpSpread, uReg, uint8(sReg), 0, 0)
+				i++
+				continue
 			}
-			c.emit(ROpConcat, uReg, uReg, uint8(len(n.Arguments)), 0)
+			end := spreadIndex(n.Elements, i)
+			if err := c.collect(n.Elements[i:end], reg+1); err != nil {
+				return 0, err
+			}
+			c.emit(ROpSpread, uReg, uReg+1, 0, 0)
+			i = end
+		}
+		return reg, nil
+
+	case *MapLiteral:
+		if m, ok := constMapLiteral(n); ok {
+			c.emit(ROpCopyConst, uReg, 0, 0, c.addConstant(Value{Type: ValMap, Obj: m}))
 			return reg, nil
 		}
+		// 键值交替落入 reg 起的连续寄存器，MKMAP 将其收集到 reg
+		for i := range n.Keys {
+			for j, part := range [2]Expression{n.Keys[i], n.Values[i]} {
+				r := reg + 2*i + j
+				pReg, err := c.walk(part, r)
+				if err != nil {
+					return 0, err
+				}
+				if pReg != r {
+					c.emit(ROpMove, uint8(r), uint8(pReg), 0, 0)
+				}
+			}
+		}
+		c.emit(ROpMakeMap, uReg, uReg, uint8(2*len(n.Keys)), 0)
+		return reg, nil
 
-		for i, arg := range n.Arguments {
-			_, err := c.walk(arg, reg+i+1)
+	case *MethodCallExpression:
+		// 接收者与参数依次落入 reg 起的连续寄存器，Src2 为不含接收者的参数个数
+		for i, part := range append([]Expression{n.Receiver}, n.Arguments...) {
+			pReg, err := c.walk(part, reg+i)
 			if err != nil {
 				return 0, err
 			}
+			if pReg != reg+i {
+				c.emit(ROpMove, uint8(reg+i), uint8(pReg), 0, 0)
+			}
 		}
-		if ident, ok := n.Function.(*Identifier); ok {
-			c.emit(ROpCall, uReg, uint8(reg+1), uint8(len(n.Arguments)), c.addConstant(Value{Type: ValString, Str: ident.Value}))
-		} else {
-			return 0, fmt.Errorf("calling non-identifier functions not supported in Register VM yet")
+		c.emit(ROpCallMethod, uReg, uReg, uint8(len(n.Arguments)), c.addConstant(Value{Type: ValString, Str: n.Method}))
+		return reg, nil
+
+	case *preparedLiteral:
+		c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(Value{Type: ValObject, Obj: n.Value}))
+		return reg, nil
+
+	case *ScoreExpression:
+		// reg 保存累加值，各条件依次求值到 reg+1 后由 SCORE 在其为真时加上权重
+		c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(scoreZero(n.Weights)))
+		for i, cond := range n.Conditions {
+			cReg, err := c.walk(cond, reg+1)
+			if err != nil {
+				return 0, err
+			}
+			w, _ := literalValue(n.Weights[i])
+			c.emit(ROpScore, uReg, uint8(cReg), 0, c.addConstant(w))
+		}
+		return reg, nil
+
+	case *RangeExpression:
+		// 两端均为整数字面量的区间直接作为常量
+		if rng, ok := constRange(n); ok {
+			c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(Value{Type: ValObject, Obj: rng}))
+			return reg, nil
+		}
+		sReg, err := c.walk(n.Start, reg)
+		if err != nil {
+			return 0, err
+		}
+		eReg, err := c.walk(n.End, reg+1)
+		if err != nil {
+			return 0, err
+		}
+		c.emit(ROpMakeRange, uReg, uint8(sReg), uint8(eReg), 0)
+		return reg, nil
+
+	case *OptionalMemberExpression:
+		// 接收者可能留在 let 绑定的寄存器中，两条指令都从 rReg 读取、写入 reg
+		rReg, err := c.walk(n.Receiver, reg)
+		if err != nil {
+			return 0, err
+		}
+		skip := c.emit(ROpJumpIfNotMap, uReg, uint8(rReg), 0, 0)
+		c.emit(ROpMapGetConst, uReg, uint8(rReg), 0, c.addConstant(Value{Type: ValString, Str: n.Name}))
+		c.patch(skip, int32(len(c.instructions)))
+		return reg, nil
+
+	case *IndexExpression:
+		lReg, err := c.walk(n.Left, reg)
+		if err != nil {
+			return 0, err
+		}
+		iReg, err := c.walk(n.Index, reg+1)
+		if err != nil {
+			return 0, err
+		}
+		c.emit(ROpIndex, uReg, uint8(lReg), uint8(iReg), 0)
+		return reg, nil
+
+	case *IndexAssignExpression:
+		lReg, err := c.walk(n.Left, reg)
+		if err != nil {
+			return 0, err
+		}
+		iReg, err := c.walk(n.Index, reg+1)
+		if err != nil {
+			return 0, err
+		}
+		vReg, err := c.walk(n.Value, reg+2)
+		if err != nil {
+			return 0, err
+		}
+		c.emit(ROpSetIndex, uReg, uint8(lReg), uint8(iReg), int32(vReg))
+		return reg, nil
+
+	case *CallExpression:
+		if slot, ok := c.memo.reused(n); ok {
+			c.emit(ROpMove, uReg, uint8(c.memoBase+slot), 0, 0)
+			return reg, nil
+		}
+		if err := c.compileCall(n, reg); err != nil {
+			return 0, err
+		}
+		if slot, ok := c.memo.produced(n); ok {
+			// 结果暂存一份，供后续相同的调用读取
+			c.emit(ROpMove, uint8(c.memoBase+slot), uReg, 0, 0)
 		}
 		return reg, nil
 	}
 	return reg, nil
 }
 
+func (c *RegisterCompiler) compileCall(n *CallExpression, reg int) error {
+	uReg := uint8(reg)
+	if name, ok := definedName(n); ok {
+		// let 绑定与参数总是存在；提前装入寄存器的全局变量仍查询上下文
+		for _, l := range c.locals {
+			if l.name == name {
+				c.emit(ROpLoadConst, uReg, 0, 0, c.addConstant(Value{Type: ValBool, Num: 1}))
+				return nil
+			}
+		}
+		c.emit(ROpDefined, uReg, 0, 0, c.addConstant(Value{Type: ValString, Str: name}))
+		return nil
+	}
+	if expr, fallback, ok := tryArgs(n); ok {
+		// TRY 登记备用值的入口，expr 出错时 VM 从入口继续，由备用值写入 reg；expr 正常求值后 ENDTRY 跳过备用值
+		try := c.emit(ROpTry, 0, 0, 0, 0)
+		if _, err := c.walk(expr, reg); err != nil {
+			return err
+		}
+		end := c.emit(ROpEndTry, 0, 0, 0, 0)
+		c.patch(try, int32(len(c.instructions)))
+		if _, err := c.walk(fallback, reg); err != nil {
+			return err
+		}
+		c.patch(end, int32(len(c.instructions)))
+		return nil
+	}
+	if ident, ok := n.Function.(*Identifier); ok && ident.Value == "concat" {
+		for i, arg := range n.Arguments {
+			_, err := c.walk(arg, reg+i)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(ROpConcat, uReg, uReg, uint8(len(n.Arguments)), 0)
+		return nil
+	}
+
+	if kind, fn, ok := iterCall(n); ok && c.chunks.index(iterNames[kind]) < 0 {
+		return c.compileIteration(kind, n, fn, reg)
+	}
+	if arg, ok := sliceCallArg(n); ok && c.chunks.index("slice") < 0 {
+		src, err := c.walk(n.Arguments[0], reg)
+		if err != nil {
+			return err
+		}
+		c.emit(ROpSlice, uReg, uint8(src), 0, arg)
+		return nil
+	}
+
+	if ident, ok := n.Function.(*Identifier); ok && len(n.Arguments) == 1 && c.chunks.index(ident.Value) < 0 {
+		if kind, ok := castKindOf(ident.Value); ok {
+			src, err := c.walk(n.Arguments[0], reg)
+			if err != nil {
+				return err
+			}
+			c.emit(ROpCast, uReg, uint8(src), 0, int32(kind))
+			return nil
+		}
+	}
+
+	for i, arg := range n.Arguments {
+		_, err := c.walk(arg, reg+i+1)
+		if err != nil {
+			return err
+		}
+	}
+	if ident, ok := n.Function.(*Identifier); ok {
+		if i := c.chunks.index(ident.Value); i >= 0 {
+			c.emit(ROpCallLocal, uReg, uint8(reg+1), uint8(len(n.Arguments)), int32(i))
+			return nil
+		}
+		c.emit(ROpCall, uReg, uint8(reg+1), uint8(len(n.Arguments)), c.addConstant(Value{Type: ValString, Str: ident.Value}))
+	} else {
+		return fmt.Errorf("calling non-identifier functions not supported in Register VM yet")
+	}
+	return nil
+}
+
+// compileIteration 把以 lambda 字面量为实参的 map、filter、reduce 内联为循环：数组与下标位于 reg 与 reg+1，
+// 累加值位于 reg+2，元素逐个装入 reg+3；二者作为 lambda 的参数绑定到所在的寄存器，函数体在其上求值，
+// 不为每个元素重新进入 VM。循环结束后把累加值搬到 reg
+func (c *RegisterCompiler) compileIteration(kind iterKind, n *CallExpression, fn *LambdaLiteral, reg int) error {
+	uReg := uint8(reg)
+	src, err := c.walk(n.Arguments[0], reg)
+	if err != nil {
+		return err
+	}
+	arg := kind
+	if n.Comprehension {
+		arg |= iterComprehension
+	}
+	c.emit(ROpIter, uReg, uint8(src), 0, int32(arg))
+	if kind == iterReduce {
+		aReg, err := c.walk(n.Arguments[2], reg+2)
+		if err != nil {
+			return err
+		}
+		if aReg != reg+2 {
+			c.emit(ROpMove, uReg+2, uint8(aReg), 0, 0)
+		}
+	} else {
+		c.emit(ROpMakeArray, uReg+2, uReg+3, 0, 0)
+	}
+	if len(c.locals)+len(fn.Parameters) > maxLetBindings {
+		return fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings)
+	}
+	outer := len(c.locals)
+	defer func() { c.locals = c.locals[:outer] }()
+	if kind == iterReduce {
+		c.locals = append(c.locals, regLocal{name: fn.Parameters[0].Value, reg: uReg + 2})
+	}
+	c.locals = append(c.locals, regLocal{name: fn.Parameters[len(fn.Parameters)-1].Value, reg: uReg + 3})
+	loop := c.emit(ROpIterNext, uReg+3, uReg, 0, 0)
+	bReg, err := c.walk(fn.Body, reg+4)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case iterMap:
+		c.emit(ROpAppend, uReg+2, uint8(bReg), 0, 0)
+	case iterFilter:
+		skip := c.emit(ROpJumpIfFalse, 0, uint8(bReg), 0, 0)
+		c.emit(ROpAppend, uReg+2, uReg+3, 0, 0)
+		c.patch(skip, int32(len(c.instructions)))
+	case iterReduce:
+		if bReg != reg+2 {
+			c.emit(ROpMove, uReg+2, uint8(bReg), 0, 0)
+		}
+	}
+	c.emit(ROpJump, 0, 0, 0, int32(loop))
+	c.patch(loop, int32(len(c.instructions)))
+	c.emit(ROpMove, uReg, uReg+2, 0, 0)
+	return nil
+}
+
+// planMemo 为调用复用的暂存位保留从 first 起的寄存器，返回表达式求值可用的起始寄存器
+func (c *RegisterCompiler) planMemo(body Node, first int) int {
+	if !c.memoSafe {
+		return first
+	}
+	names := make([]string, len(c.locals))
+	for i, l := range c.locals {
+		names[i] = l.name
+	}
+	if c.memo = planCallMemo(body, names, c.memoWritten); c.memo == nil {
+		return first
+	}
+	c.memoBase = first
+	return first + c.memo.slots
+}
+
+// maxHoistedGlobals 限制常驻寄存器的变量数量，避免挤占表达式求值所需的寄存器。
+const maxHoistedGlobals = 16
+
+// hoistGlobals 将程序中被多次读取、且从未被赋值的变量在入口处一次性加载到
+// 低位寄存器，后续读取改为寄存器间 MOVE，省去重复的 map 查找。
+// 只提升每次执行都必然读取的变量（见 entryReads），短路与分支中才读取的变量仍在使用处加载，
+// 保证提升不会多出 Context.Get 调用。
+// first 为可用的第一个寄存器（函数块中位于参数之后），返回表达式求值可用的起始寄存器。
+func (c *RegisterCompiler) hoistGlobals(node Node, first int) int {
+	reads := make(map[string]int)
+	always := make(map[string]bool)
+	entryReads(node, func(name string) { always[name] = true })
+	assigned := make(map[string]bool)
+	for name := range c.written {
+		assigned[name] = true
+	}
+	for _, l := range c.locals {
+		assigned[l.name] = true
+	}
+	walk(node, func(n Node) {
+		switch n := n.(type) {
+		case *Identifier:
+			reads[n.Value]++
+		case *AssignExpression:
+			assigned[n.Name.Value] = true
+		case *DestructureExpression:
+			for _, name := range n.Names {
+				assigned[name.Value] = true
+			}
+		case *LetExpression:
+			// 与 let 绑定同名的变量可能指向寄存器中的局部值，不参与提升
+			assigned[n.Name.Value] = true
+		case *CallExpression:
+			if ident, ok := n.Function.(*Identifier); ok {
+				reads[ident.Value]--
+			}
+		}
+	})
+
+	var names []string
+	for name, count := range reads {
+		if count >= 2 && always[name] && !assigned[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return first
+	}
+	sort.Strings(names)
+	if len(names) > maxHoistedGlobals {
+		names = names[:maxHoistedGlobals]
+	}
+
+	c.hoisted = make(map[string]uint8, len(names))
+	for i, name := range names {
+		c.emit(ROpGetGlobal, uint8(first+i), 0, 0, c.addConstant(Value{Type: ValString, Str: name}))
+		c.hoisted[name] = uint8(first + i)
+	}
+	return first + len(names)
+}
+
+// entryReads 以 fn 报告每次求值 node 都必然读取的变量名：&& 与 || 的右侧、if 的分支、try 的备选值、
+// lambda 与规则内函数的函数体都可能不被求值，不计入；未列出的节点保守地视为不读取任何变量
+func entryReads(node Node, fn func(string)) {
+	switch n := node.(type) {
+	case *Identifier:
+		fn(n.Value)
+	case *PrefixExpression:
+		entryReads(n.Right, fn)
+	case *InfixExpression:
+		entryReads(n.Left, fn)
+		if n.Operator != "&&" && n.Operator != "||" {
+			entryReads(n.Right, fn)
+		}
+	case *IfExpression:
+		entryReads(n.Condition, fn)
+	case *AssignExpression:
+		entryReads(n.Value, fn)
+	case *IndexAssignExpression:
+		entryReads(n.Left, fn)
+		entryReads(n.Index, fn)
+		entryReads(n.Value, fn)
+	case *LetExpression:
+		entryReads(n.Value, fn)
+		entryReads(n.Body, fn)
+	case *DestructureExpression:
+		entryReads(n.Value, fn)
+		entryReads(n.Body, fn)
+	case *Program:
+		entryReads(n.Body, fn)
+	case *CallExpression:
+		if _, ok := definedName(n); ok {
+			return
+		}
+		if expr, _, ok := tryArgs(n); ok {
+			entryReads(expr, fn)
+			return
+		}
+		for _, arg := range n.Arguments {
+			entryReads(arg, fn)
+		}
+	case *MethodCallExpression:
+		entryReads(n.Receiver, fn)
+		for _, arg := range n.Arguments {
+			entryReads(arg, fn)
+		}
+	case *TupleExpression:
+		for _, el := range n.Elements {
+			entryReads(el, fn)
+		}
+	case *SequenceExpression:
+		for _, stmt := range n.Statements {
+			entryReads(stmt, fn)
+		}
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			entryReads(el, fn)
+		}
+	case *SpreadElement:
+		entryReads(n.Value, fn)
+	case *IndexExpression:
+		entryReads(n.Left, fn)
+		entryReads(n.Index, fn)
+	case *OptionalMemberExpression:
+		entryReads(n.Receiver, fn)
+	case *RangeExpression:
+		entryReads(n.Start, fn)
+		entryReads(n.End, fn)
+	case *ScoreExpression:
+		for _, cond := range n.Conditions {
+			entryReads(cond, fn)
+		}
+	}
+}
+
+// loadGlobal 将变量载入 dest；let 绑定优先于全局变量，内层绑定优先
+func (c *RegisterCompiler) loadGlobal(dest uint8, name string) {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].name == name {
+			c.emit(ROpMove, dest, c.locals[i].reg, 0, 0)
+			return
+		}
+	}
+	if r, ok := c.hoisted[name]; ok {
+		c.emit(ROpMove, dest, r, 0, 0)
+		return
+	}
+	c.emit(ROpGetGlobal, dest, 0, 0, c.addConstant(Value{Type: ValString, Str: name}))
+}
+
+// collect 把 elements 依次求值到 reg 起的连续寄存器，由 MKARR 收集为数组放入 reg
+func (c *RegisterCompiler) collect(elements []Expression, reg int) error {
+	for i, el := range elements {
+		eReg, err := c.walk(el, reg+i)
+		if err != nil {
+			return err
+		}
+		if eReg != reg+i {
+			c.emit(ROpMove, uint8(reg+i), uint8(eReg), 0, 0)
+		}
+	}
+	c.emit(ROpMakeArray, uint8(reg), uint8(reg), uint8(len(elements)), 0)
+	return nil
+}
+
 func (c *RegisterCompiler) addConstant(v Value) int32 {
+	if v.Obj != nil {
+		// 容器常量按引用区分，不参与去重
+		c.constants = append(c.constants, v)
+		return int32(len(c.constants) - 1)
+	}
 	var key any
 	switch v.Type {
 	case ValInt:
@@ -268,6 +1040,8 @@ func (c *RegisterCompiler) addConstant(v Value) int32 {
 		key = v.Str
 	case ValNil:
 		key = nil
+	case ValDuration:
+		key = time.Duration(v.Num)
 	}
 	if idx, ok := c.constMap[key]; ok {
 		return idx
@@ -278,6 +1052,39 @@ func (c *RegisterCompiler) addConstant(v Value) int32 {
 	return idx
 }
 
+// walkOperandLogic 编译返回操作数的 `&&` 或 `||`：左操作数决定结果时已在 reg 中，jumpOp 直接跳到末尾，
+// 否则用右操作数覆盖 reg
+func (c *RegisterCompiler) walkOperandLogic(n *InfixExpression, reg int, jumpOp ROpCode) (int, error) {
+	if _, err := c.walk(n.Left, reg); err != nil {
+		return 0, err
+	}
+	jump := c.emit(jumpOp, 0, uint8(reg), 0, 0)
+	if _, err := c.walk(n.Right, reg); err != nil {
+		return 0, err
+	}
+	c.patch(jump, int32(len(c.instructions)))
+	return reg, nil
+}
+
+// compileIfElseFirst 编译标记为 BranchLikelyFalse 的 if：else 分支紧随条件顺序执行，consequence 放在跳转之后
+func (c *RegisterCompiler) compileIfElseFirst(n *IfExpression, reg, cReg int) (int, error) {
+	jumpTrue := c.emit(ROpJumpIfTrue, 0, uint8(cReg), 0, 0)
+	if n.Alternative != nil {
+		if _, err := c.walk(n.Alternative, reg); err != nil {
+			return 0, err
+		}
+	} else {
+		c.emit(ROpLoadConst, uint8(reg), 0, 0, c.addConstant(Value{Type: ValNil}))
+	}
+	jumpEnd := c.emit(ROpJump, 0, 0, 0, 0)
+	c.patch(jumpTrue, int32(len(c.instructions)))
+	if _, err := c.walk(n.Consequence, reg); err != nil {
+		return 0, err
+	}
+	c.patch(jumpEnd, int32(len(c.instructions)))
+	return reg, nil
+}
+
 func (c *RegisterCompiler) emit(op ROpCode, dest, src1, src2 uint8, arg int32) int {
 	c.instructions = append(c.instructions, regInstruction{Op: op, Dest: dest, Src1: src1, Src2: src2, Arg: arg})
 	return len(c.instructions) - 1
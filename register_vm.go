@@ -4,7 +4,6 @@
 package uwasa
 
 import (
-	"bytes"
 	"fmt"
 	"math"
 )
@@ -19,12 +18,24 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 	// can never trigger a Go panic for out-of-bounds access,
 	// providing memory safety without per-instruction checks in the hot loop.
 	var registers [256]Value
+	return runRegisterVM(bc, ctx, &registers, nil)
+}
+
+func runRegisterVM(bc *RegisterBytecode, ctx Context, registers *[256]Value, st *RunState) (any, error) {
 	regs := registers[:]
 
 	pc := 0
+	base := 0 // 当前函数块寄存器窗口在 registers 中的起点
 	insts := bc.Instructions
 	consts := bc.Constants
 	nInsts := len(insts)
+	concat := bc.concat // 闭包入口块沿用调用方的计数
+	if concat == nil {
+		concat = newConcatBudget(bc.MaxConcatBytes)
+	}
+	functions := bc.Functions
+	var handlers []tryHandler // try 登记的错误处理现场，最内层在末尾
+	var fault error
 
 	mapCtx, isMapCtx := ctx.(*MapContext)
 
@@ -52,7 +63,10 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			if isMapCtx {
 				mapCtx.vars[name] = val.ToInterface()
 			} else {
-				ctx.Set(name, val.ToInterface())
+				if err := setGlobal(ctx, bc.Name, pc-1, name, val.ToInterface()); err != nil {
+					fault = bc.fault(pc-1, regs, err)
+					goto unwind
+				}
 			}
 
 		case ROpMove:
@@ -66,9 +80,11 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			} else if l.Type == ValString && r.Type == ValString {
 				regs[inst.Dest] = Value{Type: ValString, Str: l.Str + r.Str}
 			} else {
-				lf, _ := valToFloat64(l)
-				rf, _ := valToFloat64(r)
-				regs[inst.Dest] = Value{Type: ValFloat, Num: math.Float64bits(lf + rf)}
+				regs[inst.Dest] = l.Add(r)
+			}
+			if err := concat.chargeString(&regs[inst.Dest]); err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
 			}
 
 		case ROpSub:
@@ -77,9 +93,7 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			if l.Type == ValInt && r.Type == ValInt {
 				regs[inst.Dest] = Value{Type: ValInt, Num: l.Num - r.Num}
 			} else {
-				lf, _ := valToFloat64(l)
-				rf, _ := valToFloat64(r)
-				regs[inst.Dest] = Value{Type: ValFloat, Num: math.Float64bits(lf - rf)}
+				regs[inst.Dest] = l.Sub(r)
 			}
 
 		case ROpMul:
@@ -87,6 +101,8 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			r := regs[inst.Src2]
 			if l.Type == ValInt && r.Type == ValInt {
 				regs[inst.Dest] = Value{Type: ValInt, Num: l.Num * r.Num}
+			} else if v, ok, _ := arithDecimal(TokenAsterisk, l, r); ok {
+				regs[inst.Dest] = v
 			} else {
 				lf, _ := valToFloat64(l)
 				rf, _ := valToFloat64(r)
@@ -97,13 +113,21 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			l := regs[inst.Src1]
 			r := regs[inst.Src2]
 			if r.Type == ValInt && r.Num == 0 {
-				return nil, fmt.Errorf("division by zero")
+				fault = bc.fault(pc-1, regs, fmt.Errorf("division by zero"))
+				goto unwind
 			}
 			if r.Type == ValFloat && math.Float64frombits(r.Num) == 0 {
-				return nil, fmt.Errorf("division by zero")
+				fault = bc.fault(pc-1, regs, fmt.Errorf("division by zero"))
+				goto unwind
 			}
 			if l.Type == ValInt && r.Type == ValInt {
-				regs[inst.Dest] = Value{Type: ValInt, Num: l.Num / r.Num}
+				regs[inst.Dest] = Value{Type: ValInt, Num: uint64(int64(l.Num) / int64(r.Num))}
+			} else if v, ok, err := arithDecimal(TokenSlash, l, r); ok {
+				if err != nil {
+					fault = bc.fault(pc-1, regs, err)
+					goto unwind
+				}
+				regs[inst.Dest] = v
 			} else {
 				lf, _ := valToFloat64(l)
 				rf, _ := valToFloat64(r)
@@ -113,13 +137,15 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 		case ROpMod:
 			l := regs[inst.Src1]
 			r := regs[inst.Src2]
-			if r.Type != ValInt {
-				return nil, fmt.Errorf("modulo operator supports only integers")
+			if r.Type != ValInt || l.Type == ValDecimal {
+				fault = bc.fault(pc-1, regs, fmt.Errorf("modulo operator supports only integers"))
+				goto unwind
 			}
 			if r.Num == 0 {
-				return nil, fmt.Errorf("division by zero")
+				fault = bc.fault(pc-1, regs, fmt.Errorf("division by zero"))
+				goto unwind
 			}
-			regs[inst.Dest] = Value{Type: ValInt, Num: l.Num % r.Num}
+			regs[inst.Dest] = Value{Type: ValInt, Num: uint64(int64(l.Num) % int64(r.Num))}
 
 		case ROpEqual:
 			l := regs[inst.Src1]
@@ -127,12 +153,26 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			res := false
 			if l.Type == r.Type {
 				switch l.Type {
-				case ValInt, ValFloat, ValBool:
+				case ValInt, ValFloat, ValBool, ValDuration:
 					res = l.Num == r.Num
 				case ValString:
 					res = l.Str == r.Str
+				case ValTime:
+					res = timeEqual(l, r)
+				case ValObject:
+					res = objectEqual(l, r)
+				case ValSet:
+					res = setEqual(l.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+				case ValDecimal:
+					res = decimalEqual(l, r)
+				case ValBytes:
+					res = bytesEqual(l, r)
 				case ValNil:
 					res = true
+				case ValArray:
+					res = arrayEqual(l.Obj.([]any), r.Obj.([]any))
+				case ValMap:
+					res = mapEqual(l.Obj.(map[string]any), r.Obj.(map[string]any))
 				}
 			} else {
 				lf, okL := valToFloat64(l)
@@ -149,6 +189,10 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) > int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str > r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c > 0
 			} else {
 				lf, _ := valToFloat64(l)
 				rf, _ := valToFloat64(r)
@@ -162,6 +206,10 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) < int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str < r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c < 0
 			} else {
 				lf, _ := valToFloat64(l)
 				rf, _ := valToFloat64(r)
@@ -175,6 +223,10 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) >= int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str >= r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c >= 0
 			} else {
 				lf, _ := valToFloat64(l)
 				rf, _ := valToFloat64(r)
@@ -188,6 +240,10 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) <= int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str <= r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c <= 0
 			} else {
 				lf, _ := valToFloat64(l)
 				rf, _ := valToFloat64(r)
@@ -209,6 +265,9 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			l := regs[inst.Src1]
 			regs[inst.Dest] = Value{Type: ValBool, Num: boolToUint64(!isValTruthy(l))}
 
+		case ROpToBool:
+			regs[inst.Dest] = Value{Type: ValBool, Num: boolToUint64(isValTruthy(regs[inst.Src1]))}
+
 		case ROpJump:
 			pc = int(inst.Arg)
 
@@ -230,22 +289,26 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			argsStart := int(inst.Src1)
 
 			if argsStart+numArgs > len(regs) {
-				return nil, fmt.Errorf("register index out of bounds in CALL")
+				fault = bc.fault(pc-1, regs, fmt.Errorf("register index out of bounds in CALL"))
+				goto unwind
 			}
 
-			args := make([]any, numArgs)
+			args := st.argScratch(nil, numArgs)
 			for i := range numArgs {
 				args[i] = regs[argsStart+i].ToInterface()
 			}
 
-			if builtin, ok := builtins[name]; ok {
-				res, err := builtin(args...)
+			if builtin, ok := builtins.get(name); ok {
+				res, err := callBuiltin(name, builtin, args)
+				st.release(args)
 				if err != nil {
-					return nil, err
+					fault = bc.fault(pc-1, regs, err)
+					goto unwind
 				}
 				regs[inst.Dest] = FromInterface(res)
 			} else {
-				return nil, fmt.Errorf("builtin function not found: %s", name)
+				fault = bc.fault(pc-1, regs, fmt.Errorf("builtin function not found: %s", name))
+				goto unwind
 			}
 
 		case ROpConcat:
@@ -254,13 +317,10 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 			totalLen := 0
 			var argStringsBuf [8]string
 			var argStrings []string
-			if numArgs <= 8 {
-				argStrings = argStringsBuf[:numArgs]
-			} else {
-				argStrings = make([]string, numArgs)
-			}
+			argStrings = st.strScratch(argStringsBuf[:], numArgs)
 			if argsStart+numArgs > len(regs) {
-				return nil, fmt.Errorf("register index out of bounds in CONCAT")
+				fault = bc.fault(pc-1, regs, fmt.Errorf("register index out of bounds in CONCAT"))
+				goto unwind
 			}
 			for i := range numArgs {
 				v := regs[argsStart+i]
@@ -279,24 +339,226 @@ func RunRegisterVM(bc *RegisterBytecode, ctx Context) (any, error) {
 						s = "false"
 					}
 				default:
-					s = fmt.Sprintf("%v", v.ToInterface())
+					s = formatAny(v.ToInterface())
 				}
 				argStrings[i] = s
 				totalLen += len(s)
 			}
-			buf := bufferPool.Get().(*bytes.Buffer)
-			buf.Reset()
-			buf.Grow(totalLen)
-			for _, s := range argStrings {
-				buf.WriteString(s)
+			if err := concat.charge(totalLen); err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
 			}
-			res := buf.String()
-			bufferPool.Put(buf)
+			res := st.join(&bufferPool, argStrings, totalLen)
 			regs[inst.Dest] = Value{Type: ValString, Str: res}
 
+		case ROpInSet:
+			regs[inst.Dest] = Value{Type: ValBool, Num: boolToUint64(bc.Sets[inst.Arg].Contains(regs[inst.Src1]))}
+
+		case ROpMakeArray:
+			start := int(inst.Src1)
+			regs[inst.Dest] = makeArray(regs[start : start+int(inst.Src2)])
+
+		case ROpJumpIfNotMap:
+			if regs[inst.Src1].Type != ValMap {
+				regs[inst.Dest] = Value{}
+				pc = int(inst.Arg)
+			}
+
+		case ROpMapGetConst:
+			m, _ := regs[inst.Src1].Obj.(map[string]any)
+			regs[inst.Dest] = FromInterface(m[consts[inst.Arg].Str])
+
+		case ROpIndex:
+			v, err := regs[inst.Src1].Index(regs[inst.Src2])
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = v
+
+		case ROpSetIndex:
+			val := regs[inst.Arg]
+			if err := regs[inst.Src1].SetIndex(regs[inst.Src2], val); err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = val
+
+		case ROpMakeMap:
+			start := int(inst.Src1)
+			m, err := makeMap(regs[start : start+int(inst.Src2)])
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = m
+
+		case ROpEqualFold:
+			regs[inst.Dest] = Value{Type: ValBool, Num: boolToUint64(regs[inst.Src1].EqualFold(regs[inst.Src2]))}
+
+		case ROpIn:
+			v, err := regs[inst.Src1].In(regs[inst.Src2])
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = v
+
+		case ROpInRange:
+			v, err := inRange(regs[inst.Src1], consts[inst.Arg])
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = v
+
+		case ROpMakeRange:
+			v, err := rangeValue(regs[inst.Src1], regs[inst.Src2])
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = v
+
+		case ROpSpread:
+			v, err := spreadInto(regs[inst.Dest], regs[inst.Src1])
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = v
+
+		case ROpCast:
+			v, err := castValue(castKind(inst.Arg), regs[inst.Src1])
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = v
+
+		case ROpIter:
+			items, idx, err := iterStart(iterKind(inst.Arg), regs[inst.Src1])
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest], regs[inst.Dest+1] = items, idx
+
+		case ROpIterNext:
+			el, ok := iterNext(regs[inst.Src1], &regs[inst.Src1+1])
+			if !ok {
+				pc = int(inst.Arg)
+				break
+			}
+			regs[inst.Dest] = el
+
+		case ROpAppend:
+			regs[inst.Dest] = appendValue(regs[inst.Dest], regs[inst.Src1])
+
+		case ROpSlice:
+			v, err := sliceValue(regs[inst.Src1], inst.Arg)
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = v
+
+		case ROpScore:
+			if isValTruthy(regs[inst.Src1]) {
+				regs[inst.Dest] = regs[inst.Dest].Add(consts[inst.Arg])
+			}
+
+		case ROpDefined:
+			var ok bool
+			if isMapCtx {
+				_, ok = mapCtx.vars[consts[inst.Arg].Str]
+			} else {
+				_, ok = ctx.Get(consts[inst.Arg].Str)
+			}
+			regs[inst.Dest] = Value{Type: ValBool, Num: boolToUint64(ok)}
+
+		case ROpBitAnd, ROpBitOr, ROpBitXor, ROpShl, ROpShr:
+			v, err := regs[inst.Src1].Bitwise(TokenBitAnd+TokenType(inst.Op-ROpBitAnd), regs[inst.Src2])
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = v
+
+		case ROpCallMethod:
+			start := int(inst.Src1)
+			numArgs := int(inst.Src2)
+			args := st.argScratch(nil, numArgs)
+			for i := range numArgs {
+				args[i] = regs[start+1+i].ToInterface()
+			}
+			res, err := CallMethodAny(regs[start].ToInterface(), consts[inst.Arg].Str, args)
+			st.release(args)
+			if err != nil {
+				fault = bc.fault(pc-1, regs, err)
+				goto unwind
+			}
+			regs[inst.Dest] = FromInterface(res)
+
+		case ROpCopyConst:
+			regs[inst.Dest] = copyConst(consts[inst.Arg])
+
 		case ROpReturn:
 			return regs[inst.Src1].ToInterface(), nil
+
+		case ROpReturnTuple:
+			start := int(inst.Src1)
+			return collectTuple(regs[:start+int(inst.Src2)], int(inst.Src2)), nil
+
+		case ROpCallLocal:
+			// 被调函数的寄存器窗口从实参处开始；Dest 紧邻实参之下，在返回前一直空闲，
+			// 调用现场暂存在其中，RETL 取回现场后再写入返回值
+			fn := functions[inst.Arg]
+			start := base + int(inst.Src1)
+			if start+int(fn.MaxRegisters) > len(registers) {
+				fault = bc.fault(pc-1, regs, fmt.Errorf("register VM call stack overflow"))
+				goto unwind
+			}
+			regs[inst.Dest] = callFrame(bc, pc, base)
+			bc, insts, consts, nInsts, pc = fn, fn.Instructions, fn.Constants, len(fn.Instructions), 0
+			base = start
+			regs = registers[base:]
+
+		case ROpReturnLocal:
+			res := regs[inst.Src1]
+			frame := registers[base-1]
+			bc, pc, base = frame.Obj.(*RegisterBytecode), int(frame.Num>>32), int(uint32(frame.Num))
+			insts, consts, nInsts = bc.Instructions, bc.Constants, len(bc.Instructions)
+			regs = registers[base:]
+			registers[base+int(insts[pc-1].Dest)] = res
+
+		case ROpMakeClosure:
+			// 闭包持有变量表本身而非可能被回收复用的上下文
+			var closureCtx Context = ctx
+			if isMapCtx {
+				closureCtx = &MapContext{vars: mapCtx.vars}
+			}
+			captured := append([]Value(nil), regs[inst.Src1:int(inst.Src1)+int(inst.Src2)]...)
+			regs[inst.Dest] = Value{Type: ValFunc, Obj: newRegisterClosure(functions, concat, int(inst.Arg), captured, closureCtx)}
+
+		case ROpTry:
+			handlers = append(handlers, tryHandler{bc: bc, pc: int(inst.Arg), fp: base})
+
+		case ROpEndTry:
+			handlers = handlers[:len(handlers)-1]
+			pc = int(inst.Arg)
+		}
+		continue
+	unwind:
+		// 出错时回到最内层 try 的现场求备用值，不在 try 中时返回错误
+		if len(handlers) == 0 {
+			return nil, fault
 		}
+		h := handlers[len(handlers)-1]
+		handlers = handlers[:len(handlers)-1]
+		bc, pc, base = h.bc.(*RegisterBytecode), h.pc, h.fp
+		insts, consts, nInsts = bc.Instructions, bc.Constants, len(bc.Instructions)
+		regs = registers[base:]
 	}
 
 	return nil, nil
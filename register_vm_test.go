@@ -4,6 +4,8 @@
 package uwasa
 
 import (
+	"reflect"
+	"slices"
 	"testing"
 )
 
@@ -79,3 +81,153 @@ func TestRegisterVM_ShortCircuit(t *testing.T) {
 		t.Errorf("Short-circuit || side effect failed: expected 0, got %v", vars2["a"])
 	}
 }
+
+func TestRegisterVM_EqualityChainSet(t *testing.T) {
+	engine, err := NewEngineVMWithOptions(`level == 1 || level == 2 || level == 3`, EngineOptions{UseRegisterVM: true})
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	for _, tt := range []struct {
+		level    any
+		expected bool
+	}{
+		{int64(2), true},
+		{3.0, true},
+		{int64(5), false},
+	} {
+		got, err := engine.Execute(map[string]any{"level": tt.level})
+		if err != nil {
+			t.Fatalf("Execute error: %v", err)
+		}
+		if got != tt.expected {
+			t.Errorf("level=%v: expected %v, got %v", tt.level, tt.expected, got)
+		}
+	}
+}
+
+func TestRegisterVM_HoistGlobals(t *testing.T) {
+	engine, err := NewEngineVMWithOptions(`if a > 10 is a * 2 else is a + b`, EngineOptions{UseRegisterVM: true})
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	loads := 0
+	for _, inst := range engine.registerBytecode.Instructions {
+		if inst.Op == ROpGetGlobal && engine.registerBytecode.Constants[inst.Arg].Str == "a" {
+			loads++
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected a single load of a, got %d", loads)
+	}
+	if got, _ := engine.Execute(map[string]any{"a": int64(20), "b": int64(1)}); got != int64(40) {
+		t.Errorf("expected 40, got %v", got)
+	}
+	if got, _ := engine.Execute(map[string]any{"a": int64(5), "b": int64(1)}); got != int64(6) {
+		t.Errorf("expected 6, got %v", got)
+	}
+
+	// Assigned variables must be re-read after the write
+	engine2, err := NewEngineVMWithOptions(`if (a = a + 1) > 1 is a * 10 else is a`, EngineOptions{UseRegisterVM: true})
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if got, _ := engine2.Execute(map[string]any{"a": int64(1)}); got != int64(20) {
+		t.Errorf("expected 20, got %v", got)
+	}
+}
+
+func TestRegisterVM_BranchHints(t *testing.T) {
+	opts := EngineOptions{UseRegisterVM: true, BranchHints: map[string]BranchHint{"a > 10": BranchLikelyFalse}}
+	engine, err := NewEngineVMWithOptions(`if a > 10 is "big" else is "small"`, opts)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	insts := engine.registerBytecode.Instructions
+	found := false
+	for i, inst := range insts {
+		if inst.Op == ROpJumpIfTrue && i+1 < len(insts) && insts[i+1].Op == ROpLoadConst {
+			found = engine.registerBytecode.Constants[insts[i+1].Arg].Str == "small"
+		}
+	}
+	if !found {
+		t.Errorf("expected else branch to be laid out as fall-through")
+	}
+	for _, tt := range []struct {
+		a        int64
+		expected string
+	}{{20, "big"}, {5, "small"}} {
+		if got, _ := engine.Execute(map[string]any{"a": tt.a}); got != tt.expected {
+			t.Errorf("a=%d: expected %s, got %v", tt.a, tt.expected, got)
+		}
+	}
+
+	engine2, err := NewEngineVMWithOptions(`if a > 10 then b = 1`, opts)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	vars := map[string]any{"a": int64(1)}
+	if got, _ := engine2.Execute(vars); got != nil || vars["b"] != nil {
+		t.Errorf("expected nil without side effect, got %v (b=%v)", got, vars["b"])
+	}
+	vars["a"] = int64(11)
+	if got, _ := engine2.Execute(vars); got != int64(1) {
+		t.Errorf("expected 1, got %v", got)
+	}
+}
+
+func TestRegisterVM_CallMemo(t *testing.T) {
+	tests := []struct {
+		input string
+		calls int
+	}{
+		{`len(name) > 3 && len(name) < 20`, 1},
+		{`len(name), len(name) * 2, if ok is len(name)`, 1},
+		{`if ok is len(name) else is len(name) + 1`, 2},
+		{`len(name) > 3 && (name = "x") != "" && len(name) < 20`, 2},
+		{`len(tags) > 1 && tags.set("k", 1) != nil && len(tags) > 1`, 2},
+	}
+	for _, tt := range tests {
+		engine, err := NewEngineVMWithOptions(tt.input, EngineOptions{UseRegisterVM: true})
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", tt.input, err)
+		}
+		calls := 0
+		for _, inst := range engine.registerBytecode.Instructions {
+			if inst.Op == ROpCall {
+				calls++
+			}
+		}
+		if calls != tt.calls {
+			t.Errorf("%s: expected %d calls, got %d", tt.input, tt.calls, calls)
+		}
+		vars := func() map[string]any { return map[string]any{"name": "uwasa", "ok": true, "tags": map[string]any{}} }
+		ast, _ := NewEngine(tt.input)
+		want, _ := ast.Execute(vars())
+		if got, err := engine.Execute(vars()); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.input, want, got, err)
+		}
+	}
+}
+
+// 常量边界编译为一条 SLICE，单参数的转换编译为 CAST
+func TestRegisterVM_BuiltinOps(t *testing.T) {
+	opts := EngineOptions{OptimizationLevel: OptBasic, UseRegisterVM: true}
+	reg, _ := NewEngineVMWithOptions(`slice(a, 1, 3)`, opts)
+	if !slices.ContainsFunc(reg.registerBytecode.Instructions, func(inst regInstruction) bool { return inst.Op == ROpSlice }) {
+		t.Errorf("expected SLICE, got %v", reg.registerBytecode.Instructions)
+	}
+	reg, _ = NewEngineVMWithOptions(`float(i)`, opts)
+	if ops := reg.registerBytecode.Instructions; len(ops) != 3 || ops[1].Op != ROpCast {
+		t.Errorf("expected GETG; CAST; RET, got %v", ops)
+	}
+}
+
+// lambda 字面量内联为循环
+func TestRegisterVM_InlineIteration(t *testing.T) {
+	for name, src := range map[string]string{"map": `map(items, x -> x + a)`, "reduce": `items |> reduce((s, x) -> s + x, 0)`} {
+		reg, _ := NewEngineVMWithOptions(src, EngineOptions{OptimizationLevel: OptBasic, UseRegisterVM: true})
+		if !slices.ContainsFunc(reg.registerBytecode.Instructions, func(inst regInstruction) bool { return inst.Op == ROpIter }) {
+			t.Errorf("%s: expected an inline loop, got %v", name, reg.registerBytecode.Instructions)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// RuleVersion 是注册表中某条规则的一个已编译版本，版本号从 1 开始递增，剪除旧版本后也不会复用
+type RuleVersion struct {
+	Name    string
+	Version int
+	Source  string
+	Engine  *Engine
+}
+
+// RegistryEventKind 表示注册表变更的类型
+type RegistryEventKind int
+
+const (
+	RegistryAdded      RegistryEventKind = iota // 新增版本（尚未生效）
+	RegistryPromoted                            // 某版本被设为生效版本
+	RegistryRolledBack                          // 回滚到上一个生效版本
+)
+
+func (k RegistryEventKind) String() string {
+	switch k {
+	case RegistryAdded: return "added"
+	case RegistryPromoted: return "promoted"
+	case RegistryRolledBack: return "rolled back"
+	default: return fmt.Sprintf("RegistryEventKind(%d)", int(k))
+	}
+}
+
+// RegistryEvent 描述一次注册表变更。Previous 为变更前的生效版本号，没有生效版本时为 0
+type RegistryEvent struct {
+	Kind     RegistryEventKind
+	Name     string
+	Version  int
+	Previous int
+}
+
+// Registry 是按规则名管理多版本已编译引擎的内存注册表。
+// 新版本经 Add 编译入库，Promote 原子地切换生效版本，Rollback 退回上一个生效版本；
+// Get 在切换期间始终返回某个完整的生效版本，可与写操作并发调用。
+// 版本默认一直保留，长期运行且频繁入库时以 RegistryOptions.MaxVersions 或 Prune 限制其数量。
+type Registry struct {
+	mu        sync.RWMutex
+	rules     map[string]*registryEntry
+	listeners []func(RegistryEvent)
+	compile   func(string) (*Engine, error)
+	opts      RegistryOptions
+}
+
+// RegistryOptions 配置 Registry
+type RegistryOptions struct {
+	// MaxVersions 为每条规则保留的版本数上限，0 表示不限。Add 超出上限时与 Prune 一样丢弃最旧的版本
+	MaxVersions int
+}
+
+type registryEntry struct {
+	// versions 按版本号升序排列，剪除旧版本后版本号不再与下标对应
+	versions []*RuleVersion
+	// latest 为已分配的最大版本号
+	latest int
+	active atomic.Pointer[RuleVersion]
+	// previous 按生效顺序记录被替换下来的版本号，供 Rollback 逐级回退
+	previous []int
+}
+
+// NewRegistry 创建注册表，compile 用于编译新版本；传入 nil 时使用 NewEngineVM
+func NewRegistry(compile func(string) (*Engine, error)) *Registry {
+	return NewRegistryWithOptions(compile, RegistryOptions{})
+}
+
+// NewRegistryWithOptions 与 NewRegistry 相同，另以 opts 限制保留的版本数
+func NewRegistryWithOptions(compile func(string) (*Engine, error), opts RegistryOptions) *Registry {
+	if compile == nil {
+		compile = NewEngineVM
+	}
+	return &Registry{rules: make(map[string]*registryEntry), compile: compile, opts: opts}
+}
+
+// OnChange 注册变更监听器。监听器在变更完成后、于发起变更的协程中同步调用，
+// 调用时不持有注册表的锁，因此可以在监听器中读取或修改注册表。
+func (r *Registry) OnChange(fn func(RegistryEvent)) {
+	r.mu.Lock()
+	r.listeners = append(r.listeners, fn)
+	r.mu.Unlock()
+}
+
+// Add 编译 source 并作为 name 的新版本入库，新版本不会自动生效。
+// name 为空时取规则注解中的 @name；二者都为空时返回错误。
+func (r *Registry) Add(name, source string) (*RuleVersion, error) {
+	engine, err := r.compile(source)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = engine.Metadata().Name
+	}
+	if name == "" {
+		return nil, fmt.Errorf("registry: rule has no name: pass one or annotate the rule with @name")
+	}
+
+	r.mu.Lock()
+	entry := r.rules[name]
+	if entry == nil {
+		entry = &registryEntry{}
+		r.rules[name] = entry
+	}
+	entry.latest++
+	rv := &RuleVersion{Name: name, Version: entry.latest, Source: source, Engine: engine}
+	entry.versions = append(entry.versions, rv)
+	if r.opts.MaxVersions > 0 {
+		entry.prune(r.opts.MaxVersions)
+	}
+	ev := RegistryEvent{Kind: RegistryAdded, Name: name, Version: rv.Version, Previous: entry.activeVersion()}
+	listeners := r.listeners
+	r.mu.Unlock()
+
+	notify(listeners, ev)
+	return rv, nil
+}
+
+// Promote 将 name 的 version 设为生效版本
+func (r *Registry) Promote(name string, version int) error {
+	r.mu.Lock()
+	entry := r.rules[name]
+	if entry == nil {
+		r.mu.Unlock()
+		return fmt.Errorf("registry: unknown rule %q", name)
+	}
+	rv := entry.find(version)
+	if rv == nil {
+		r.mu.Unlock()
+		return fmt.Errorf("registry: rule %q has no version %d", name, version)
+	}
+	prev := entry.activeVersion()
+	if prev == version {
+		r.mu.Unlock()
+		return nil
+	}
+	if prev != 0 {
+		entry.previous = append(entry.previous, prev)
+	}
+	entry.active.Store(rv)
+	if r.opts.MaxVersions > 0 {
+		entry.prune(r.opts.MaxVersions)
+	}
+	ev := RegistryEvent{Kind: RegistryPromoted, Name: name, Version: version, Previous: prev}
+	listeners := r.listeners
+	r.mu.Unlock()
+
+	notify(listeners, ev)
+	return nil
+}
+
+// Rollback 将 name 退回到上一个生效版本，返回回退后的版本
+func (r *Registry) Rollback(name string) (*RuleVersion, error) {
+	r.mu.Lock()
+	entry := r.rules[name]
+	if entry == nil {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("registry: unknown rule %q", name)
+	}
+	if len(entry.previous) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("registry: rule %q has no earlier version to roll back to", name)
+	}
+	prev := entry.activeVersion()
+	version := entry.previous[len(entry.previous)-1]
+	entry.previous = entry.previous[:len(entry.previous)-1]
+	rv := entry.find(version)
+	entry.active.Store(rv)
+	ev := RegistryEvent{Kind: RegistryRolledBack, Name: name, Version: version, Previous: prev}
+	listeners := r.listeners
+	r.mu.Unlock()
+
+	notify(listeners, ev)
+	return rv, nil
+}
+
+// Get 返回 name 当前生效版本的引擎；规则不存在或尚无生效版本时返回 nil, false
+func (r *Registry) Get(name string) (*Engine, bool) {
+	rv, ok := r.Active(name)
+	if !ok {
+		return nil, false
+	}
+	return rv.Engine, true
+}
+
+// Active 返回 name 当前生效的版本
+func (r *Registry) Active(name string) (*RuleVersion, bool) {
+	r.mu.RLock()
+	entry := r.rules[name]
+	r.mu.RUnlock()
+	if entry == nil {
+		return nil, false
+	}
+	rv := entry.active.Load()
+	return rv, rv != nil
+}
+
+// Versions 返回 name 仍保留的全部版本，按版本号升序排列
+func (r *Registry) Versions(name string) []*RuleVersion {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry := r.rules[name]
+	if entry == nil {
+		return nil
+	}
+	return append([]*RuleVersion(nil), entry.versions...)
+}
+
+// Names 返回注册表中的全部规则名，顺序不定
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.rules))
+	for name := range r.rules {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Prune 丢弃 name 最旧的版本，只保留最新的 keep 个，返回丢弃的个数。生效版本总是保留并计入 keep；
+// 回滚历史中被丢弃的版本随之移除，历史本身也只保留最近的 keep 次切换，Rollback 因此不会退回已丢弃的版本
+func (r *Registry) Prune(name string, keep int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := r.rules[name]
+	if entry == nil {
+		return 0
+	}
+	return entry.prune(keep)
+}
+
+// find 返回版本号为 version 的版本，已丢弃或不存在时返回 nil
+func (e *registryEntry) find(version int) *RuleVersion {
+	i, ok := slices.BinarySearchFunc(e.versions, version, func(rv *RuleVersion, v int) int { return rv.Version - v })
+	if !ok {
+		return nil
+	}
+	return e.versions[i]
+}
+
+// prune 实现 Prune，调用方持有写锁
+func (e *registryEntry) prune(keep int) int {
+	active := e.activeVersion()
+	drop := len(e.versions) - max(keep, 0)
+	if active != 0 && keep <= 0 {
+		drop--
+	}
+	dropped := map[int]bool{}
+	e.versions = slices.DeleteFunc(e.versions, func(rv *RuleVersion) bool {
+		if len(dropped) >= drop || rv.Version == active {
+			return false
+		}
+		dropped[rv.Version] = true
+		return true
+	})
+	e.previous = slices.DeleteFunc(e.previous, func(v int) bool { return dropped[v] })
+	if n := len(e.previous) - max(keep, 0); n > 0 {
+		e.previous = slices.Delete(e.previous, 0, n)
+	}
+	return len(dropped)
+}
+
+func (e *registryEntry) activeVersion() int {
+	if rv := e.active.Load(); rv != nil {
+		return rv.Version
+	}
+	return 0
+}
+
+func notify(listeners []func(RegistryEvent), ev RegistryEvent) {
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
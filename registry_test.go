@@ -0,0 +1,199 @@
+package uwasa
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(nil)
+	var events []RegistryEvent
+	r.OnChange(func(ev RegistryEvent) { events = append(events, ev) })
+
+	if _, ok := r.Get("discount"); ok {
+		t.Fatalf("expected no engine before Add")
+	}
+	v1, err := r.Add("discount", `price * 0.9`)
+	if err != nil {
+		t.Fatalf("add error: %v", err)
+	}
+	if _, ok := r.Get("discount"); ok {
+		t.Errorf("Add should not promote")
+	}
+	if err := r.Promote("discount", v1.Version); err != nil {
+		t.Fatalf("promote error: %v", err)
+	}
+	v2, _ := r.Add("discount", `price * 0.8`)
+	v3, _ := r.Add("", `@name("discount") price * 0.5`)
+	if v2.Version != 2 || v3.Version != 3 || v3.Name != "discount" {
+		t.Fatalf("unexpected versions %d, %d (%s)", v2.Version, v3.Version, v3.Name)
+	}
+	r.Promote("discount", 2)
+	r.Promote("discount", 3)
+
+	run := func() any {
+		e, ok := r.Get("discount")
+		if !ok {
+			t.Fatalf("expected active engine")
+		}
+		res, err := e.Execute(map[string]any{"price": int64(100)})
+		if err != nil {
+			t.Fatalf("execute error: %v", err)
+		}
+		return res
+	}
+	if got := run(); got != 50.0 {
+		t.Errorf("expected v3 result 50, got %v", got)
+	}
+	if rv, err := r.Rollback("discount"); err != nil || rv.Version != 2 {
+		t.Fatalf("expected rollback to v2, got %v (%v)", rv, err)
+	}
+	if got := run(); got != 80.0 {
+		t.Errorf("expected v2 result 80, got %v", got)
+	}
+	r.Rollback("discount")
+	if _, err := r.Rollback("discount"); err == nil {
+		t.Errorf("expected error rolling back past the first promoted version")
+	}
+	if rv, _ := r.Active("discount"); rv.Version != 1 {
+		t.Errorf("expected v1 active, got %d", rv.Version)
+	}
+
+	want := []RegistryEvent{
+		{RegistryAdded, "discount", 1, 0},
+		{RegistryPromoted, "discount", 1, 0},
+		{RegistryAdded, "discount", 2, 1},
+		{RegistryAdded, "discount", 3, 1},
+		{RegistryPromoted, "discount", 2, 1},
+		{RegistryPromoted, "discount", 3, 2},
+		{RegistryRolledBack, "discount", 2, 3},
+		{RegistryRolledBack, "discount", 1, 2},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("unexpected events:\n got %v\nwant %v", events, want)
+	}
+
+	if _, err := r.Add("", `1 + 1`); err == nil {
+		t.Errorf("expected error for unnamed rule")
+	}
+	if _, err := r.Add("bad", `1 +`); err == nil {
+		t.Errorf("expected compile error")
+	}
+	if err := r.Promote("discount", 9); err == nil {
+		t.Errorf("expected error promoting unknown version")
+	}
+	if err := r.Promote("missing", 1); err == nil {
+		t.Errorf("expected error promoting unknown rule")
+	}
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"discount"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+}
+
+func TestRegistryConcurrentPromote(t *testing.T) {
+	r := NewRegistry(NewEngineVMNeo)
+	r.Add("k", `x + 1`)
+	r.Add("k", `x * 2 + 1`)
+	r.Promote("k", 1)
+	// 编译第二个版本不能影响已编译的第一个版本
+	if e, _ := r.Get("k"); e != nil {
+		if res, _ := e.Execute(map[string]any{"x": int64(1)}); res != int64(2) {
+			t.Fatalf("v1 expected 2, got %v", res)
+		}
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				e, _ := r.Get("k")
+				res, err := e.Execute(map[string]any{"x": int64(1)})
+				if err != nil || (res != int64(2) && res != int64(3)) {
+					t.Errorf("unexpected result %v (%v)", res, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := range 200 {
+		r.Promote("k", 1+i%2)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestRegistryRetention(t *testing.T) {
+	versions := func(r *Registry, name string) []int {
+		var vs []int
+		for _, rv := range r.Versions(name) {
+			vs = append(vs, rv.Version)
+		}
+		return vs
+	}
+
+	r := NewRegistryWithOptions(nil, RegistryOptions{MaxVersions: 2})
+	r.Add("k", `x + 1`)
+	r.Promote("k", 1)
+	r.Add("k", `x + 2`)
+	r.Add("k", `x + 3`)
+	// 超出上限时丢弃最旧的非生效版本，生效的 v1 保留
+	if got := versions(r, "k"); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Fatalf("expected versions [1 3], got %v", got)
+	}
+	if err := r.Promote("k", 2); err == nil {
+		t.Errorf("expected error promoting a dropped version")
+	}
+	r.Promote("k", 3)
+	v4, _ := r.Add("k", `x + 4`)
+	if got := versions(r, "k"); v4.Version != 4 || !reflect.DeepEqual(got, []int{3, 4}) {
+		t.Fatalf("expected v4 and versions [3 4], got v%d, %v", v4.Version, got)
+	}
+	// v1 已被丢弃，回滚历史随之清空
+	if _, err := r.Rollback("k"); err == nil {
+		t.Errorf("expected no rollback to a dropped version")
+	}
+	// 反复切换时回滚历史也不超过上限
+	for i := range 100 {
+		r.Promote("k", 3+i%2)
+	}
+	if _, err := r.Rollback("k"); err != nil {
+		t.Errorf("expected a rollback, got %v", err)
+	}
+	if _, err := r.Rollback("k"); err != nil {
+		t.Errorf("expected a second rollback, got %v", err)
+	}
+	if _, err := r.Rollback("k"); err == nil {
+		t.Errorf("expected the rollback history to be capped at 2")
+	}
+
+	r = NewRegistry(nil)
+	for i := range 5 {
+		r.Add("k", fmt.Sprintf("x + %d", i))
+	}
+	r.Promote("k", 2)
+	if n := r.Prune("k", 1); n != 4 {
+		t.Errorf("expected 4 dropped versions, got %d", n)
+	}
+	if got := versions(r, "k"); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("expected only the active v2, got %v", got)
+	}
+	if n := r.Prune("k", 0); n != 0 {
+		t.Errorf("expected the active version to survive Prune(0), dropped %d", n)
+	}
+	if rv, _ := r.Add("k", `x`); rv.Version != 6 {
+		t.Errorf("expected version numbers not to be reused, got v%d", rv.Version)
+	}
+	if n := r.Prune("missing", 1); n != 0 {
+		t.Errorf("expected 0 for an unknown rule, got %d", n)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package remote
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsBuckets 为滑动窗口划分的桶数，窗口以 1/metricsBuckets 的粒度向前滑动
+const metricsBuckets = 10
+
+// UsageOrder 指定 TopRules 的排序依据
+type UsageOrder int
+
+const (
+	ByTime UsageOrder = iota // 按累计执行耗时
+	ByCost                   // 按累计静态估计开销
+)
+
+// RuleUsage 是某条规则在统计窗口内的累计用量
+type RuleUsage struct {
+	Name       string
+	Executions int64
+	// Time 为累计执行耗时，包括执行出错的调用
+	Time time.Duration
+	// EstimatedCost 为每次执行计入一次 Engine.EstimatedCost 的累计值。它是编译时的静态估计，
+	// 不是实际执行的指令数：分支与短路跳过的指令同样计入
+	EstimatedCost int64
+}
+
+// metrics 按规则名累计用量。规则包重新加载后源码未变的规则沿用原来的计数，
+// 源码改变或被移除的规则丢弃其计数
+type metrics struct {
+	width int64 // 每个桶覆盖的纳秒数
+	now   func() time.Time
+	mu    sync.Mutex // 串行化 load
+	rules atomic.Pointer[map[string]*ruleMeter]
+}
+
+type ruleMeter struct {
+	source  string // 规则源码，重新加载时据此判断规则是否被替换
+	mu      sync.Mutex
+	buckets [metricsBuckets]usageBucket
+}
+
+// usageBucket 记录第 epoch 个时间片内的用量，epoch 过期的桶在下次写入时重置
+type usageBucket struct {
+	epoch int64
+	n     int64
+	time  time.Duration
+	cost  int64
+}
+
+func newMetrics(window time.Duration) *metrics {
+	return &metrics{width: max(int64(window)/metricsBuckets, 1), now: time.Now}
+}
+
+// load 为新加载的规则包换上一组计数器并返回：源码未变的规则沿用原计数器，其余规则使用新的计数器。
+// 旧规则集仍在执行的调用写入旧计数器，不会混入新规则的统计
+func (m *metrics) load(sources map[string]string) map[string]*ruleMeter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var prev map[string]*ruleMeter
+	if p := m.rules.Load(); p != nil {
+		prev = *p
+	}
+	meters := make(map[string]*ruleMeter, len(sources))
+	for name, src := range sources {
+		if r, ok := prev[name]; ok && r.source == src {
+			meters[name] = r
+		} else {
+			meters[name] = &ruleMeter{source: src}
+		}
+	}
+	m.rules.Store(&meters)
+	return meters
+}
+
+func (m *metrics) record(r *ruleMeter, cost int, d time.Duration) {
+	epoch := m.now().UnixNano() / m.width
+	r.mu.Lock()
+	b := &r.buckets[epoch%metricsBuckets]
+	if b.epoch != epoch {
+		*b = usageBucket{epoch: epoch}
+	}
+	b.n++
+	b.time += d
+	b.cost += int64(cost)
+	r.mu.Unlock()
+}
+
+func (m *metrics) top(n int, by UsageOrder) []RuleUsage {
+	epoch := m.now().UnixNano() / m.width
+	var out []RuleUsage
+	p := m.rules.Load()
+	if p == nil {
+		return nil
+	}
+	for name, r := range *p {
+		u := RuleUsage{Name: name}
+		r.mu.Lock()
+		for _, b := range r.buckets {
+			if b.n > 0 && b.epoch <= epoch && epoch-b.epoch < metricsBuckets {
+				u.Executions += b.n
+				u.Time += b.time
+				u.EstimatedCost += b.cost
+			}
+		}
+		r.mu.Unlock()
+		if u.Executions > 0 {
+			out = append(out, u)
+		}
+	}
+	slices.SortFunc(out, func(a, b RuleUsage) int {
+		if by == ByCost {
+			return cmp.Or(cmp.Compare(b.EstimatedCost, a.EstimatedCost), cmp.Compare(b.Time, a.Time), cmp.Compare(a.Name, b.Name))
+		}
+		return cmp.Or(cmp.Compare(b.Time, a.Time), cmp.Compare(b.EstimatedCost, a.EstimatedCost), cmp.Compare(a.Name, b.Name))
+	})
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+	return out
+}
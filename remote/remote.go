@@ -0,0 +1,249 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package remote 通过 HTTP(S) 拉取规则包，校验签名并编译后原子地替换当前规则集。
+//
+// 规则包是一个 JSON 对象 {"version": <n>, "rules": {"<name>": "<source>", ...}}；
+// 签名为对响应体的 ed25519 签名，以 base64 放在 X-Uwasa-Signature 响应头中。
+// version 位于签名覆盖的响应体内，Loader 拒绝低于当前规则集的版本，重放旧的签名规则包无法回滚规则。
+package remote
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// SignatureHeader 是携带规则包签名的响应头
+const SignatureHeader = "X-Uwasa-Signature"
+
+// Options 配置 Loader。PublicKey 与 Verify 至少提供一个
+type Options struct {
+	// Client 用于发起请求，默认 http.DefaultClient
+	Client *http.Client
+	// PublicKey 用于校验 SignatureHeader 中的 ed25519 签名
+	PublicKey ed25519.PublicKey
+	// Verify 自定义签名校验，设置后取代 PublicKey
+	Verify func(body []byte, header http.Header) error
+	// Compile 编译单条规则，默认 uwasa.NewEngineVM
+	Compile func(string) (*uwasa.Engine, error)
+	// MaxBytes 限制规则包大小，默认 8 MiB
+	MaxBytes int64
+	// MinVersion 是首次加载可接受的最低规则包版本。进程重启后没有当前规则集可供比较，
+	// 将上次运行时的 RuleSet.Version 持久化并在此传入，可防止重启后被回滚
+	MinVersion uint64
+	// MetricsWindow 大于 0 时启用执行统计：经 RuleSet.Execute 的执行计入该长度的滑动窗口，
+	// 由 RuleSet.TopRules 查询
+	MetricsWindow time.Duration
+}
+
+// RuleSet 是一次成功加载的规则包，加载后不再修改，可被多个协程同时读取
+type RuleSet struct {
+	ETag string
+	// Version 是规则包中经签名的版本号
+	Version uint64
+	Rules   map[string]*uwasa.Engine
+	// metrics 在 Loader 的各次加载间共享；meters 与 costs 为各规则的计数器与静态开销，加载时备好
+	metrics *metrics
+	meters  map[string]*ruleMeter
+	costs   map[string]int
+	// plan 由 Plan 在首次调用时创建
+	planOnce sync.Once
+	plan     *uwasa.RulePlan
+}
+
+// Get 返回名为 name 的规则
+func (s *RuleSet) Get(name string) (*uwasa.Engine, bool) {
+	e, ok := s.Rules[name]
+	return e, ok
+}
+
+// Execute 以 vars 执行名为 name 的规则；启用了 MetricsWindow 时记录本次执行的耗时与静态估计开销
+func (s *RuleSet) Execute(name string, vars map[string]any) (any, error) {
+	e, ok := s.Rules[name]
+	if !ok {
+		return nil, fmt.Errorf("remote: unknown rule %q", name)
+	}
+	if s.metrics == nil {
+		return e.Execute(vars)
+	}
+	start := time.Now()
+	res, err := e.Execute(vars)
+	s.metrics.record(s.meters[name], s.costs[name], time.Since(start))
+	return res, err
+}
+
+// TopRules 返回最近一次加载的规则在统计窗口内累计用量最高的 n 条，按 by 降序排列；n <= 0 时返回全部。
+// 源码未变的规则跨越规则包的重新加载继续累计，被替换或移除的规则从头计数；未启用 MetricsWindow 时返回 nil。
+func (s *RuleSet) TopRules(n int, by UsageOrder) []RuleUsage {
+	if s.metrics == nil {
+		return nil
+	}
+	return s.metrics.top(n, by)
+}
+
+// Plan 返回规则集的执行计划（见 uwasa.NewRulePlan），在首次调用时创建。以按需查询的 Context 对整个规则集求值时，
+// 经 Plan().Run 执行可使每个变量只读取一次；这样的执行不计入 MetricsWindow 的统计
+func (s *RuleSet) Plan() *uwasa.RulePlan {
+	s.planOnce.Do(func() { s.plan = uwasa.NewRulePlan(s.Rules) })
+	return s.plan
+}
+
+type bundle struct {
+	Version uint64            `json:"version"`
+	Rules   map[string]string `json:"rules"`
+}
+
+// Loader 从固定 URL 拉取规则包。Fetch 串行执行；Current 无锁，可随时调用。
+type Loader struct {
+	url     string
+	opts    Options
+	mu      sync.Mutex // 串行化 Fetch
+	current atomic.Pointer[RuleSet]
+	metrics *metrics
+}
+
+// New 创建 Loader，此时尚未发起请求
+func New(url string, opts Options) (*Loader, error) {
+	if opts.PublicKey == nil && opts.Verify == nil {
+		return nil, errors.New("remote: no signature verifier configured: set PublicKey or Verify")
+	}
+	if opts.PublicKey != nil && len(opts.PublicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("remote: invalid ed25519 public key length %d", len(opts.PublicKey))
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Compile == nil {
+		opts.Compile = uwasa.NewEngineVM
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 8 << 20
+	}
+	l := &Loader{url: url, opts: opts}
+	if opts.MetricsWindow > 0 {
+		l.metrics = newMetrics(opts.MetricsWindow)
+	}
+	return l, nil
+}
+
+// Current 返回最近一次成功加载的规则集；尚未加载成功时返回 nil
+func (l *Loader) Current() *RuleSet {
+	return l.current.Load()
+}
+
+// Fetch 拉取规则包。服务端返回 304 时 changed 为 false；
+// 下载、签名校验或任一规则编译失败，或规则包版本低于当前规则集（首次加载时低于 MinVersion）时返回错误，
+// 当前规则集保持不变。版本相同的规则包照常加载。
+func (l *Loader) Fetch(ctx context.Context) (changed bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if cur := l.current.Load(); cur != nil && cur.ETag != "" {
+		req.Header.Set("If-None-Match", cur.ETag)
+	}
+	resp, err := l.opts.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("remote: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, l.opts.MaxBytes+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(body)) > l.opts.MaxBytes {
+		return false, fmt.Errorf("remote: bundle exceeds %d bytes", l.opts.MaxBytes)
+	}
+	if err := l.verify(body, resp.Header); err != nil {
+		return false, err
+	}
+
+	var b bundle
+	if err := json.Unmarshal(body, &b); err != nil {
+		return false, fmt.Errorf("remote: decode bundle: %w", err)
+	}
+	floor := l.opts.MinVersion
+	if cur := l.current.Load(); cur != nil {
+		floor = max(floor, cur.Version)
+	}
+	if b.Version < floor {
+		return false, fmt.Errorf("remote: bundle version %d is older than %d", b.Version, floor)
+	}
+	rules := make(map[string]*uwasa.Engine, len(b.Rules))
+	for name, src := range b.Rules {
+		e, err := l.opts.Compile(src)
+		if err != nil {
+			return false, fmt.Errorf("remote: compile rule %q: %w", name, err)
+		}
+		rules[name] = e
+	}
+	set := l.newRuleSet(resp.Header.Get("ETag"), rules, b.Rules)
+	set.Version = b.Version
+	l.current.Store(set)
+	return true, nil
+}
+
+func (l *Loader) newRuleSet(etag string, rules map[string]*uwasa.Engine, sources map[string]string) *RuleSet {
+	set := &RuleSet{ETag: etag, Rules: rules, metrics: l.metrics}
+	if l.metrics != nil {
+		set.meters = l.metrics.load(sources)
+		set.costs = make(map[string]int, len(rules))
+		for name, e := range rules {
+			set.costs[name] = e.EstimatedCost()
+		}
+	}
+	return set
+}
+
+// Poll 每隔 interval 调用一次 Fetch，直到 ctx 结束。失败经 onError 报告（可为 nil），不会中止轮询
+func (l *Loader) Poll(ctx context.Context, interval time.Duration, onError func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := l.Fetch(ctx); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (l *Loader) verify(body []byte, header http.Header) error {
+	if l.opts.Verify != nil {
+		return l.opts.Verify(body, header)
+	}
+	sig, err := base64.StdEncoding.DecodeString(header.Get(SignatureHeader))
+	if err != nil {
+		return fmt.Errorf("remote: decode signature: %w", err)
+	}
+	if !ed25519.Verify(l.opts.PublicKey, body, sig) {
+		return errors.New("remote: bundle signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package remote
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+func TestLoaderFetch(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	body, etag, sig := "", "", ""
+	publish := func(b, tag string) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, etag = b, tag
+		sig = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(b)))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set(SignatureHeader, sig)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	if _, err := New(srv.URL, Options{}); err == nil {
+		t.Fatalf("expected error without a verifier")
+	}
+	l, err := New(srv.URL, Options{PublicKey: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	eval := func(name string) any {
+		t.Helper()
+		e, ok := l.Current().Get(name)
+		if !ok {
+			t.Fatalf("rule %s not loaded", name)
+		}
+		res, err := e.Execute(map[string]any{"price": int64(100)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	publish(`{"rules": {"discount": "price * 0.9", "vip": "price > 50"}}`, `"v1"`)
+	if changed, err := l.Fetch(ctx); err != nil || !changed {
+		t.Fatalf("first fetch: changed=%v err=%v", changed, err)
+	}
+	if got := eval("discount"); got != 90.0 {
+		t.Errorf("expected 90, got %v", got)
+	}
+	if changed, err := l.Fetch(ctx); err != nil || changed {
+		t.Errorf("expected 304 on unchanged bundle: changed=%v err=%v", changed, err)
+	}
+	if l.Current().ETag != `"v1"` {
+		t.Errorf("unexpected etag %s", l.Current().ETag)
+	}
+	if order := l.Current().Plan().Order(); len(order) != 2 || l.Current().Plan().Vars("vip")[0] != "price" {
+		t.Errorf("unexpected plan %v", order)
+	}
+
+	// 签名不匹配：保留 v1
+	publish(`{"rules": {"discount": "price * 0.5"}}`, `"v2"`)
+	mu.Lock()
+	body = `{"rules": {"discount": "price * 0.1"}}`
+	mu.Unlock()
+	if _, err := l.Fetch(ctx); err == nil {
+		t.Errorf("expected signature error")
+	}
+	// 任一规则编译失败：整个规则包被拒绝
+	publish(`{"rules": {"discount": "price * 0.5", "broken": "price *"}}`, `"v3"`)
+	if _, err := l.Fetch(ctx); err == nil {
+		t.Errorf("expected compile error")
+	}
+	if got := eval("discount"); got != 90.0 {
+		t.Errorf("failed fetches should keep v1, got %v", got)
+	}
+
+	publish(`{"rules": {"discount": "price * 0.5"}}`, `"v4"`)
+	if changed, err := l.Fetch(ctx); err != nil || !changed {
+		t.Fatalf("fetch v4: changed=%v err=%v", changed, err)
+	}
+	if got := eval("discount"); got != 50.0 {
+		t.Errorf("expected 50, got %v", got)
+	}
+	if _, ok := l.Current().Get("vip"); ok {
+		t.Errorf("rules absent from the new bundle should be dropped")
+	}
+}
+
+func TestLoaderRejectsRollback(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	body, etag := "", ""
+	publish := func(b, tag string) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, etag = b, tag
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("ETag", etag)
+		w.Header().Set(SignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(body))))
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	l, err := New(srv.URL, Options{PublicKey: pub, MinVersion: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	old := `{"version": 1, "rules": {"discount": "price * 0.5"}}`
+	publish(old, `"v1"`)
+	if _, err := l.Fetch(ctx); err == nil {
+		t.Fatalf("expected a bundle below MinVersion to be rejected")
+	}
+	publish(`{"version": 3, "rules": {"discount": "price * 0.9"}}`, `"v3"`)
+	if changed, err := l.Fetch(ctx); err != nil || !changed {
+		t.Fatalf("fetch v3: changed=%v err=%v", changed, err)
+	}
+	if l.Current().Version != 3 {
+		t.Errorf("expected version 3, got %d", l.Current().Version)
+	}
+
+	// 重放旧的签名规则包，即使换上新的 ETag 也会被拒绝
+	for _, b := range []string{old, `{"version": 2, "rules": {"discount": "price * 0.1"}}`, `{"rules": {"discount": "price * 0.1"}}`} {
+		publish(b, `"replayed"`)
+		if _, err := l.Fetch(ctx); err == nil {
+			t.Errorf("expected rollback to be rejected: %s", b)
+		}
+		if l.Current().ETag != `"v3"` {
+			t.Errorf("rollback replaced the current rule set with %s", l.Current().ETag)
+		}
+	}
+
+	publish(`{"version": 3, "rules": {"discount": "price * 0.8"}}`, `"v3b"`)
+	if changed, err := l.Fetch(ctx); err != nil || !changed {
+		t.Errorf("expected the same version to load: changed=%v err=%v", changed, err)
+	}
+}
+
+func TestRuleSetTopRules(t *testing.T) {
+	l, err := New("http://unused", Options{PublicKey: make(ed25519.PublicKey, ed25519.PublicKeySize), MetricsWindow: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Unix(1000, 0)
+	l.metrics.now = func() time.Time { return clock }
+
+	sources := map[string]string{"cheap": `price > 50`, "heavy": `concat("id-", price, "-", price / 3)`}
+	cheap, _ := uwasa.NewEngineVM(sources["cheap"])
+	heavy, _ := uwasa.NewEngineVM(sources["heavy"])
+	set := l.newRuleSet("", map[string]*uwasa.Engine{"cheap": cheap, "heavy": heavy}, sources)
+	for range 3 {
+		if _, err := set.Execute("heavy", map[string]any{"price": int64(100)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := set.Execute("missing", nil); err == nil {
+		t.Errorf("expected error for unknown rule")
+	}
+	l.metrics.record(set.meters["cheap"], 1, time.Second)
+
+	top := set.TopRules(0, ByTime)
+	if len(top) != 2 || top[0].Name != "cheap" || top[0].Time != time.Second {
+		t.Fatalf("unexpected time ranking %+v", top)
+	}
+	top = set.TopRules(1, ByCost)
+	if len(top) != 1 || top[0].Name != "heavy" || top[0].Executions != 3 || top[0].EstimatedCost != 3*int64(heavy.EstimatedCost()) {
+		t.Fatalf("unexpected cost ranking %+v", top)
+	}
+
+	// 重新加载后源码未变的规则继续累计，被移除的规则不再列出；滑出窗口的用量不再计入
+	clock = clock.Add(40 * time.Second)
+	reloaded := l.newRuleSet("", map[string]*uwasa.Engine{"heavy": heavy}, map[string]string{"heavy": sources["heavy"]})
+	reloaded.Execute("heavy", map[string]any{"price": int64(1)})
+	if top := reloaded.TopRules(0, ByCost); len(top) != 1 || top[0].Executions != 4 {
+		t.Fatalf("expected usage to carry over reloads, got %+v", top)
+	}
+	clock = clock.Add(30 * time.Second)
+	if top := reloaded.TopRules(0, ByTime); len(top) != 1 || top[0].Name != "heavy" || top[0].Executions != 1 {
+		t.Fatalf("expected earlier usage to expire, got %+v", top)
+	}
+
+	// 源码改变的同名规则从头计数，旧规则集上的执行不计入新规则
+	replaced, _ := uwasa.NewEngineVM(`price * 2`)
+	next := l.newRuleSet("", map[string]*uwasa.Engine{"heavy": replaced}, map[string]string{"heavy": `price * 2`})
+	reloaded.Execute("heavy", map[string]any{"price": int64(1)})
+	if top := next.TopRules(0, ByTime); len(top) != 0 {
+		t.Fatalf("expected a replaced rule to start from zero, got %+v", top)
+	}
+	next.Execute("heavy", map[string]any{"price": int64(1)})
+	if top := next.TopRules(0, ByCost); len(top) != 1 || top[0].Executions != 1 || top[0].EstimatedCost != int64(replaced.EstimatedCost()) {
+		t.Fatalf("expected only the replacement's usage, got %+v", top)
+	}
+
+	if (&RuleSet{}).TopRules(5, ByTime) != nil {
+		t.Errorf("expected nil without metrics")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+)
+
+// ReplayOptions 配置执行录制。被采样的执行会生成一条 ReplayRecord 交给 Sink，
+// 之后可在本地用 Engine.Replay 复现。只有以变量映射执行的入口（Execute、ExecuteWithState）
+// 会被录制：自定义 Context 无法枚举变量，不能生成快照。
+type ReplayOptions struct {
+	// SampleRate 为录制比例：0 关闭录制，1 录制每一次执行
+	SampleRate float64
+	// Seed 决定采样序列；相同的 Seed 在相同的执行次序下选中相同的执行
+	Seed uint64
+	// Sink 接收录制结果，可能被多个协程并发调用
+	Sink func(*ReplayRecord)
+}
+
+// ReplayRecord 是一次执行的紧凑录制，足以在本地复现该次执行
+type ReplayRecord struct {
+	Hash   string         // 规则源码的 FNV-64a 哈希（十六进制），Replay 据此确认规则一致
+	Vars   map[string]any // 执行前变量的深拷贝
+	Result any            // 执行结果；出错时为 nil
+	Err    string         // 执行出错时的错误信息
+	Seed   uint64         // 选中本次执行的采样随机数
+}
+
+// ReplayMismatchError 表示复现结果与录制结果不一致
+type ReplayMismatchError struct {
+	Record *ReplayRecord
+	Result any
+	Err    string
+}
+
+func (e *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("replay mismatch: recorded (%v, %q), replayed (%v, %q)", e.Record.Result, e.Record.Err, e.Result, e.Err)
+}
+
+// recorder 以 splitmix64 从 Seed 与执行序号派生随机数，无锁且可复现
+type recorder struct {
+	threshold uint64
+	seed      uint64
+	seq       atomic.Uint64
+	sink      func(*ReplayRecord)
+}
+
+func newRecorder(opts ReplayOptions) *recorder {
+	if opts.SampleRate <= 0 || opts.Sink == nil {
+		return nil
+	}
+	threshold := uint64(math.MaxUint64)
+	if opts.SampleRate < 1 {
+		threshold = uint64(opts.SampleRate * (1 << 64))
+	}
+	return &recorder{threshold: threshold, seed: opts.Seed, sink: opts.Sink}
+}
+
+// sample 决定本次执行是否录制，返回抽到的随机数
+func (r *recorder) sample() (uint64, bool) {
+	z := r.seed + r.seq.Add(1)*0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z ^= z >> 31
+	return z, r.threshold == math.MaxUint64 || z < r.threshold
+}
+
+// attachReplay 记录规则源码哈希，并按 opts 启用录制
+func (e *Engine) attachReplay(input string, opts ReplayOptions) {
+	e.hash = sourceHash(input)
+	e.recorder = newRecorder(opts)
+}
+
+func sourceHash(input string) string {
+	h := fnv.New64a()
+	h.Write([]byte(input))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// record 快照 vars 后执行 run，并将结果交给 Sink
+func (e *Engine) record(seed uint64, vars map[string]any, run func(map[string]any) (any, error)) (any, error) {
+	rec := &ReplayRecord{Hash: e.hash, Seed: seed}
+	if vars != nil {
+		rec.Vars = deepCopyContainer(vars).(map[string]any)
+	}
+	res, err := run(vars)
+	if err != nil {
+		rec.Err = err.Error()
+	} else {
+		rec.Result = deepCopyContainer(res)
+	}
+	e.recorder.sink(rec)
+	return res, err
+}
+
+// Replay 以录制的变量快照重新执行规则。规则源码与录制时不同时返回错误；
+// 复现结果（或错误信息）与录制不一致时返回 *ReplayMismatchError。
+// 复现不会再次触发录制，也不会修改 rec.Vars。
+func (e *Engine) Replay(rec *ReplayRecord) (any, error) {
+	if rec.Hash != e.hash {
+		return nil, fmt.Errorf("replay record is for rule %s, engine compiled rule %s", rec.Hash, e.hash)
+	}
+	var vars map[string]any
+	if rec.Vars != nil {
+		vars = deepCopyContainer(rec.Vars).(map[string]any)
+	}
+	res, err := e.execute(vars)
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	if errMsg != rec.Err || !reflect.DeepEqual(res, rec.Result) {
+		return res, &ReplayMismatchError{Record: rec, Result: res, Err: errMsg}
+	}
+	return res, err
+}
@@ -0,0 +1,79 @@
+package uwasa
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	const rule = `if a > 1 then b = a * 2`
+	for name := range backends(EngineOptions{}) {
+		var recs []*ReplayRecord
+		opts := EngineOptions{OptimizationLevel: OptBasic, Replay: ReplayOptions{SampleRate: 1, Sink: func(r *ReplayRecord) { recs = append(recs, r) }}}
+		engine, err := backends(opts)[name](rule)
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", name, err)
+		}
+		vars := map[string]any{"a": int64(3)}
+		if _, err := engine.Execute(vars); err != nil {
+			t.Fatalf("%s: execute error: %v", name, err)
+		}
+		if _, err := engine.ExecuteWithState(NewRunState(), map[string]any{"a": int64(5)}); err != nil {
+			t.Fatalf("%s: execute error: %v", name, err)
+		}
+		if len(recs) != 2 {
+			t.Fatalf("%s: expected 2 records, got %d", name, len(recs))
+		}
+		if _, ok := recs[0].Vars["b"]; ok {
+			t.Errorf("%s: snapshot should be taken before execution, got %v", name, recs[0].Vars)
+		}
+		for i, want := range []int64{6, 10} {
+			if recs[i].Result != want {
+				t.Errorf("%s: record %d expected %v, got %v", name, i, want, recs[i].Result)
+			}
+			got, err := engine.Replay(recs[i])
+			if err != nil || got != want {
+				t.Errorf("%s: replay %d expected %v, got %v (%v)", name, i, want, got, err)
+			}
+		}
+		if len(recs) != 2 {
+			t.Errorf("%s: replay should not record, got %d records", name, len(recs))
+		}
+
+		// 复现不依赖后端：AST 引擎可复现任意后端的录制
+		plain, _ := NewEngine(rule)
+		if got, err := plain.Replay(recs[0]); err != nil || got != int64(6) {
+			t.Errorf("%s: cross-engine replay got %v (%v)", name, got, err)
+		}
+
+		tampered := *recs[0]
+		tampered.Result = int64(8)
+		var mismatch *ReplayMismatchError
+		if _, err := engine.Replay(&tampered); !errors.As(err, &mismatch) || mismatch.Result != int64(6) {
+			t.Errorf("%s: expected ReplayMismatchError, got %v", name, err)
+		}
+		other, _ := backends(EngineOptions{OptimizationLevel: OptBasic})[name](`a + 1`)
+		if _, err := other.Replay(recs[0]); err == nil || errors.As(err, &mismatch) {
+			t.Errorf("%s: expected hash mismatch error, got %v", name, err)
+		}
+	}
+
+	t.Run("Sampling", func(t *testing.T) {
+		run := func() []uint64 {
+			var seeds []uint64
+			engine, _ := NewEngineVMWithOptions(`a + 1`, EngineOptions{OptimizationLevel: OptBasic, Replay: ReplayOptions{SampleRate: 0.5, Seed: 7, Sink: func(r *ReplayRecord) { seeds = append(seeds, r.Seed) }}})
+			for i := 0; i < 200; i++ {
+				engine.Execute(map[string]any{"a": int64(i)})
+			}
+			return seeds
+		}
+		first := run()
+		if len(first) < 60 || len(first) > 140 {
+			t.Errorf("expected about half of 200 executions recorded, got %d", len(first))
+		}
+		if !reflect.DeepEqual(first, run()) {
+			t.Errorf("same seed should select the same executions")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// hashSeedArity 为按键哈希分桶的内置函数及其不含种子时的实参个数。EngineOptions.HashSeed 非空时，
+// 编译器为恰好这么多实参的调用追加种子作为最后一个实参，因此同一规则在不同种子下得到互相独立的分桶
+var hashSeedArity = map[string]int{"bucket": 2, "inRollout": 2}
+
+// seedHashCalls 为 node 中的 bucket 与 inRollout 调用追加种子实参；seed 为空时不做改动
+func seedHashCalls(node Node, seed string) {
+	if seed == "" {
+		return
+	}
+	walk(node, func(n Node) {
+		call, ok := n.(*CallExpression)
+		if !ok {
+			return
+		}
+		if id, ok := call.Function.(*Identifier); ok {
+			if arity, ok := hashSeedArity[id.Value]; ok && len(call.Arguments) == arity {
+				call.Arguments = append(call.Arguments, &StringLiteral{Value: seed})
+			}
+		}
+	})
+}
+
+// bucketHash 返回 seed 与 key 的 64 位哈希：FNV-1a 之后再做一次 splitmix64 混合，
+// 使高位同样均匀。分桶只依赖这个值，跨进程、跨版本保持稳定
+func bucketHash(seed, key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(seed); i++ {
+		h = (h ^ uint64(seed[i])) * 1099511628211
+	}
+	h = (h ^ 0xff) * 1099511628211 // 分隔种子与键，避免 ("a", "bc") 与 ("ab", "c") 相同
+	for i := 0; i < len(key); i++ {
+		h = (h ^ uint64(key[i])) * 1099511628211
+	}
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	return h ^ h>>31
+}
+
+// bucketOf 把哈希值按比例映射到 [0, n)：取 h*n 的高 64 位，而不是 h % n。
+// 这样 bucketOf(h, 100) < p 与 bucketOf(h, 10000) < 100*p 对整数 p 等价，放量只会加入新的键
+func bucketOf(h uint64, n int64) int64 {
+	hi, _ := bits.Mul64(h, uint64(n))
+	return int64(hi)
+}
+
+// bucketArgs 解析 bucket 与 inRollout 的键与可选的种子实参
+func bucketArgs(name string, args []any) (key, seed string, err error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", "", fmt.Errorf("%s expects 2 or 3 arguments, got %d", name, len(args))
+	}
+	if args[0] == nil {
+		return "", "", fmt.Errorf("%s expects a non-nil key", name)
+	}
+	if len(args) == 3 {
+		s, ok := args[2].(string)
+		if !ok {
+			return "", "", fmt.Errorf("%s expects a string seed, got %T", name, args[2])
+		}
+		seed = s
+	}
+	key, ok := args[0].(string)
+	if !ok {
+		key = concatText(args[0])
+	}
+	return key, seed, nil
+}
+
+// bucket 实现内置函数 bucket(key, n[, seed])：把 key 稳定地映射到 0 到 n-1 之一。
+// key 按 concat 的格式取得文本，因此整数 42 与字符串 "42" 落入同一个桶
+func bucket(args ...any) (any, error) {
+	key, seed, err := bucketArgs("bucket", args)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := args[1].(int64)
+	if !ok || n <= 0 {
+		return nil, fmt.Errorf("bucket expects a positive integer bucket count, got %v", args[1])
+	}
+	return bucketOf(bucketHash(seed, key), n), nil
+}
+
+// inRollout 实现内置函数 inRollout(key, percent[, seed])：key 是否落在前 percent% 的桶中。
+// 精度为 0.01%，对整数 percent 与 bucket(key, 100) < percent 结果相同
+func inRollout(args ...any) (any, error) {
+	key, seed, err := bucketArgs("inRollout", args)
+	if err != nil {
+		return nil, err
+	}
+	var percent float64
+	switch p := args[1].(type) {
+	case int64:
+		percent = float64(p)
+	case float64:
+		percent = p
+	default:
+		return nil, fmt.Errorf("inRollout expects a numeric percentage, got %T", args[1])
+	}
+	return float64(bucketOf(bucketHash(seed, key), 10000)) < percent*100, nil
+}
@@ -0,0 +1,88 @@
+package uwasa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		// 分桶结果在版本之间必须保持稳定，这里固定几个取值
+		{`bucket("user-42", 100)`, int64(28)},
+		{`bucket(42, 1000)`, int64(460)},
+		{`bucket("42", 1000)`, int64(460)},
+		{`bucket("user-42", 100, "exp-7")`, int64(67)},
+		{`bucket(uid, 100)`, int64(28)},
+		{`uid |> bucket(100)`, int64(28)},
+		{`bucket(uid, 1)`, int64(0)},
+		{`inRollout(uid, 28)`, false},
+		{`inRollout(uid, 29)`, true},
+		{`inRollout(uid, 28.5) || inRollout(uid, 0)`, true},
+		{`inRollout(uid, 0)`, false},
+		{`inRollout(uid, 100)`, true},
+	}
+
+	vars := map[string]any{"uid": "user-42"}
+	for name := range backends(EngineOptions{}) {
+		newEngine := func(s string, opts EngineOptions) (*Engine, error) { return backends(opts)[name](s) }
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input, EngineOptions{})
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars)
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`bucket(uid, 0)`, `bucket(uid, 2.5)`, `bucket(nil, 10)`, `bucket(uid)`, `inRollout(uid, "10")`, `bucket(uid, 10, 1)`} {
+			engine, err := newEngine(bad, EngineOptions{})
+			if err == nil {
+				_, err = engine.Execute(vars)
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+
+		// HashSeed 等同于显式传入的种子，且只作用于两参数的调用
+		seeded, err := newEngine(`[bucket(uid, 100), uid |> bucket(100), bucket(uid, 100, "other")]`, EngineOptions{HashSeed: "exp-7"})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := seeded.Execute(vars)
+		other, _ := NewEngine(`bucket(uid, 100, "other")`)
+		want, _ := other.Execute(vars)
+		if err != nil || !reflect.DeepEqual(got, []any{int64(67), int64(67), want}) {
+			t.Errorf("%s: seeded buckets = %v (%v)", name, got, err)
+		}
+		if e, _ := newEngine(`bucket("user-42", 100)`, EngineOptions{OptimizationLevel: OptBasic, HashSeed: "exp-7"}); e.constantResult != int64(67) {
+			t.Errorf("%s: expected seeded constant call to fold, got %v", name, e.constantResult)
+		}
+	}
+
+	// 对整数比例，inRollout 与 bucket(key, 100) < p 一致，且放量只会加入新的键
+	in, _ := NewEngineVMNeo(`[bucket(k, 100), inRollout(k, 10), inRollout(k, 30)]`)
+	counts := make([]int, 10)
+	for i := range 10000 {
+		got, err := in.Execute(map[string]any{"k": int64(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := got.([]any)
+		b := r[0].(int64)
+		if r[1] != (b < 10) || r[2] != (b < 30) {
+			t.Fatalf("key %d: bucket %d, inRollout 10%% = %v, 30%% = %v", i, b, r[1], r[2])
+		}
+		counts[b/10]++
+	}
+	for i, n := range counts {
+		if n < 900 || n > 1100 {
+			t.Errorf("decile %d has %d of 10000 keys", i, n)
+		}
+	}
+}
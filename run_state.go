@@ -0,0 +1,79 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"bytes"
+	"sync"
+)
+
+// RunState 持有一次执行所需的全部暂存区：操作数栈、寄存器帧、参数暂存、
+// 字符串暂存与拼接缓冲区，以及复用的 MapContext。
+// 它由调用方的工作协程独占并跨多次执行复用，从而绕开解释器局部变量与对象池；
+// 同一个 RunState 不能被多个协程同时使用。
+type RunState struct {
+	stack     [64]Value
+	registers [256]Value
+	args      []any
+	strs      []string
+	buf       bytes.Buffer
+	ctx       MapContext
+}
+
+func NewRunState() *RunState {
+	return &RunState{}
+}
+
+// argScratch 返回长度为 n 的参数暂存区；st 为 nil 时退回调用方提供的栈上缓冲
+func (st *RunState) argScratch(local []any, n int) []any {
+	if st == nil {
+		if n <= len(local) {
+			return local[:n]
+		}
+		return make([]any, n)
+	}
+	if cap(st.args) < n {
+		st.args = make([]any, n)
+	}
+	return st.args[:n]
+}
+
+// strScratch 与 argScratch 相同，用于拼接前的字符串暂存
+func (st *RunState) strScratch(local []string, n int) []string {
+	if st == nil {
+		if n <= len(local) {
+			return local[:n]
+		}
+		return make([]string, n)
+	}
+	if cap(st.strs) < n {
+		st.strs = make([]string, n)
+	}
+	return st.strs[:n]
+}
+
+// join 使用 RunState 自带的缓冲区拼接字符串；st 为 nil 时退回池化缓冲区
+func (st *RunState) join(pool *sync.Pool, parts []string, totalLen int) string {
+	if st == nil {
+		return joinPooled(pool, parts, totalLen)
+	}
+	st.buf.Reset()
+	st.buf.Grow(totalLen)
+	for _, s := range parts {
+		st.buf.WriteString(s)
+	}
+	res := st.buf.String()
+	if st.buf.Cap() > maxPooledBufferSize {
+		st.buf = bytes.Buffer{}
+	}
+	clear(parts)
+	return res
+}
+
+// release 清除暂存区中对参数的引用，避免复用期间延长其生命周期
+func (st *RunState) release(args []any) {
+	if st != nil {
+		clear(args)
+	}
+}
@@ -0,0 +1,29 @@
+package uwasa
+
+import "testing"
+
+func TestExecuteWithState(t *testing.T) {
+	input := `if a > 1 is concat("n=", a, "/", b) else is total = a + b`
+	for name, engine := range allEngines(t, input, EngineOptions{OptimizationLevel: OptBasic}) {
+		st := NewRunState()
+		// The same state is reused across runs; results must not leak between them
+		for i := int64(0); i < 4; i++ {
+			vars := map[string]any{"a": i, "b": int64(10)}
+			got, err := engine.ExecuteWithState(st, vars)
+			if err != nil {
+				t.Errorf("%s: execute error: %v", name, err)
+				continue
+			}
+			want, _ := engine.Execute(map[string]any{"a": i, "b": int64(10)})
+			if got != want {
+				t.Errorf("%s: a=%d expected %v, got %v", name, i, want, got)
+			}
+			if i <= 1 && vars["total"] != i+10 {
+				t.Errorf("%s: a=%d expected total=%d, got %v", name, i, i+10, vars["total"])
+			}
+		}
+		if st.ctx.vars != nil {
+			t.Errorf("%s: state must not retain the variable map", name)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuntimeError 是三种 VM 执行期错误的统一外壳，记录出错指令与现场，便于线上排查。
+// 原始错误保存在 Err 中，errors.Is/As 可以穿透，例如取出 *ConcatLimitError。
+// 目前尚无源码映射，因此不携带源码位置；AST 解释器没有指令概念，仍返回原始错误。
+type RuntimeError struct {
+	Op       string      // 出错指令的助记符，如 "DIV"、"CALL"
+	PC       int         // 出错指令在指令流中的下标
+	Variable string      // 指令直接引用的全局变量名；操作数来自栈或寄存器时为空
+	Operands []ValueType // 出错时该指令的操作数类型，按求值顺序排列
+	Function string      // 出错指令所在的规则内函数名，主程序中为空；此时 PC 是函数块内的下标
+	Err      error
+}
+
+func (e *RuntimeError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v [%s at pc %d", e.Err, e.Op, e.PC)
+	if e.Function != "" {
+		fmt.Fprintf(&b, " in fn %s", e.Function)
+	}
+	if e.Variable != "" {
+		fmt.Fprintf(&b, ", variable %s", e.Variable)
+	}
+	if len(e.Operands) > 0 {
+		b.WriteString(", operands ")
+		for i, t := range e.Operands {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(t.String())
+		}
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+func (t ValueType) String() string {
+	switch t {
+	case ValNil: return "nil"
+	case ValInt: return "int"
+	case ValFloat: return "float"
+	case ValBool: return "bool"
+	case ValString: return "string"
+	case ValArray: return "array"
+	case ValMap: return "map"
+	case ValFunc: return "func"
+	case ValObject: return "object"
+	case ValTime: return "time"
+	case ValDuration: return "duration"
+	case ValSet: return "set"
+	case ValDecimal: return "decimal"
+	case ValBytes: return "bytes"
+	default: return fmt.Sprintf("ValueType(%d)", byte(t))
+	}
+}
+
+// operandTypes 返回 vals[lo:hi] 的类型；范围越界（如栈溢出时）返回 nil
+func operandTypes(vals []Value, lo, hi int) []ValueType {
+	if lo < 0 || hi > len(vals) || lo >= hi {
+		return nil
+	}
+	types := make([]ValueType, hi-lo)
+	for i, v := range vals[lo:hi] {
+		types[i] = v.Type
+	}
+	return types
+}
+
+// fault 在栈式 VM 出错时构造 RuntimeError。sp 为出错时的栈顶：
+// 二元运算已弹出右操作数，左右操作数位于 stack[sp]、stack[sp+1]；调用类指令已弹出全部参数。
+func (bc *RenderedBytecode) fault(pc int, stack *[64]Value, sp int, err error) error {
+	inst := bc.Instructions[pc]
+	re := &RuntimeError{Op: inst.Op.String(), PC: pc, Function: bc.Name, Err: err}
+	switch inst.Op {
+	case OpDiv, OpMod, OpIndex, OpIn, OpBitAnd, OpBitOr, OpBitXor, OpShl, OpShr:
+		re.Operands = operandTypes(stack[:], sp, sp+2)
+	case OpSetIndex:
+		re.Operands = operandTypes(stack[:], sp, sp+3)
+	case OpMakeMap:
+		re.Operands = operandTypes(stack[:], sp-2*int(inst.Arg)+1, sp+1)
+	case OpCall:
+		re.Operands = operandTypes(stack[:], sp+1, sp+1+int(inst.Arg>>16))
+	case OpCallMethod:
+		re.Operands = operandTypes(stack[:], sp, sp+1+int(inst.Arg>>16))
+	case OpConcat:
+		re.Operands = operandTypes(stack[:], sp+1, sp+1+int(inst.Arg))
+	case OpGetGlobal, OpSetGlobal:
+		re.Variable = bc.Constants[inst.Arg].Str
+	case OpAddGlobal:
+		re.Variable = bc.Constants[inst.Arg&0xFFFF].Str
+	case OpAddGlobalGlobal, OpEqualGlobalConst, OpGreaterGlobalConst, OpLessGlobalConst, OpInSetGlobal:
+		re.Variable = bc.Constants[inst.Arg>>16].Str
+	}
+	return re
+}
+
+// fault 在 NeoVM 出错时构造 RuntimeError，sp 的约定与栈式 VM 相同
+func (bc *NeoBytecode) fault(pc int, stack *[64]Value, sp int, err error) error {
+	inst := bc.Instructions[pc]
+	re := &RuntimeError{Op: inst.Op.String(), PC: pc, Function: bc.Name, Err: err}
+	switch inst.Op {
+	case NeoOpDiv, NeoOpMod, NeoOpIndex, NeoOpIn, NeoOpMapGet, NeoOpMapHas, NeoOpMapDel, NeoOpConcat2,
+		NeoOpBitAnd, NeoOpBitOr, NeoOpBitXor, NeoOpShl, NeoOpShr:
+		re.Operands = operandTypes(stack[:], sp, sp+2)
+	case NeoOpSetIndex, NeoOpMapSet:
+		re.Operands = operandTypes(stack[:], sp, sp+3)
+	case NeoOpMakeMap:
+		re.Operands = operandTypes(stack[:], sp-2*int(inst.Arg)+1, sp+1)
+	case NeoOpCall:
+		re.Operands = operandTypes(stack[:], sp+1, sp+1+int(inst.Arg>>16))
+	case NeoOpCallMethod:
+		re.Operands = operandTypes(stack[:], sp, sp+1+int(inst.Arg>>16))
+	case NeoOpConcat:
+		re.Operands = operandTypes(stack[:], sp+1, sp+1+int(inst.Arg))
+	case NeoOpGetGlobal, NeoOpSetGlobal:
+		re.Variable = bc.Constants[inst.Arg].Str
+	case NeoOpEqualGlobalConst, NeoOpNotEqualGlobalConst, NeoOpGreaterGlobalConst, NeoOpLessGlobalConst,
+		NeoOpAddGlobal, NeoOpAddGC, NeoOpAddConstGlobal, NeoOpSubGC, NeoOpMulGC, NeoOpDivGC,
+		NeoOpSubCG, NeoOpMulCG, NeoOpDivCG, NeoOpAddGlobalGlobal, NeoOpSubGlobalGlobal, NeoOpMulGlobalGlobal,
+		NeoOpConcatGC, NeoOpConcatCG:
+		re.Variable = bc.Constants[inst.Arg>>16].Str
+	}
+	return re
+}
+
+// fault 在寄存器 VM 出错时构造 RuntimeError，操作数直接取自指令引用的寄存器
+func (bc *RegisterBytecode) fault(pc int, regs []Value, err error) error {
+	inst := bc.Instructions[pc]
+	re := &RuntimeError{Op: inst.Op.String(), PC: pc, Function: bc.Name, Err: err}
+	switch inst.Op {
+	case ROpDiv, ROpMod, ROpIndex, ROpIn, ROpBitAnd, ROpBitOr, ROpBitXor, ROpShl, ROpShr:
+		re.Operands = []ValueType{regs[inst.Src1].Type, regs[inst.Src2].Type}
+	case ROpSetIndex:
+		re.Operands = []ValueType{regs[inst.Src1].Type, regs[inst.Src2].Type, regs[inst.Arg].Type}
+	case ROpCall, ROpConcat, ROpMakeMap:
+		re.Operands = operandTypes(regs, int(inst.Src1), int(inst.Src1)+int(inst.Src2))
+	case ROpCallMethod:
+		re.Operands = operandTypes(regs, int(inst.Src1), int(inst.Src1)+int(inst.Src2)+1)
+	}
+	return re
+}
@@ -0,0 +1,27 @@
+package uwasa
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRuntimeError(t *testing.T) {
+	for name, engine := range allEngines(t, `1 + (n in x)`, EngineOptions{OptimizationLevel: OptBasic}) {
+		if name == "AST" {
+			// AST 解释器没有指令，错误不包装为 *RuntimeError
+			continue
+		}
+		_, err := engine.Execute(map[string]any{"n": int64(1), "x": "abc"})
+		var re *RuntimeError
+		if !errors.As(err, &re) {
+			t.Fatalf("%s: expected *RuntimeError, got %v", name, err)
+		}
+		if re.Op != "IN" || !reflect.DeepEqual(re.Operands, []ValueType{ValInt, ValString}) {
+			t.Errorf("%s: unexpected runtime error context: %+v", name, re)
+		}
+		if re.Err == nil || re.Err.Error() != "substring must be a string, got int64" {
+			t.Errorf("%s: unexpected wrapped error: %v", name, re.Err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// selectorOp 是标签选择器中一项要求的运算
+type selectorOp int
+
+const (
+	selExists selectorOp = iota
+	selNotExists
+	selEquals
+	selNotEquals
+	selIn
+	selNotIn
+)
+
+// labelRequirement 是标签选择器中的一项要求，如 `app=web`、`tier in (api, web)`、`!legacy`
+type labelRequirement struct {
+	key    string
+	op     selectorOp
+	values []string
+}
+
+// matches 判断标签 labels 是否满足要求。与 Kubernetes 一致，`!=` 与 notin 在标签缺失时也满足
+func (r *labelRequirement) matches(labels map[string]any) bool {
+	v, ok := labels[r.key]
+	switch r.op {
+	case selExists:
+		return ok
+	case selNotExists:
+		return !ok
+	case selEquals, selIn:
+		return ok && slices.Contains(r.values, labelText(v))
+	default:
+		return !ok || !slices.Contains(r.values, labelText(v))
+	}
+}
+
+// labelText 返回标签值的文本。清单中的标签值本应是字符串，YAML 解析出的数字与布尔值按 %v 比较
+func labelText(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// labelSelector 是解析后的字符串标签选择器，src 为原字符串
+type labelSelector struct {
+	src  string
+	reqs []labelRequirement
+}
+
+func (s *labelSelector) preparedFrom() (string, int, any) { return "matchLabels", 1, s.src }
+
+// parseLabelSelector 解析以逗号分隔的 Kubernetes 标签选择器，支持 `k`、`!k`、`k=v`、`k==v`、`k!=v`、
+// `k in (v1, v2)` 与 `k notin (v1, v2)`；空串不含任何要求
+func parseLabelSelector(src string) (*labelSelector, error) {
+	s := &labelSelector{src: src}
+	if strings.TrimSpace(src) == "" {
+		return s, nil
+	}
+	// 按括号之外的逗号拆分，in 与 notin 的值列表中也有逗号
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts, start = append(parts, src[start:i]), i+1
+			}
+		}
+	}
+	for _, part := range append(parts, src[start:]) {
+		r, err := parseLabelRequirement(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("matchLabels: invalid selector %q: %v", src, err)
+		}
+		s.reqs = append(s.reqs, r)
+	}
+	return s, nil
+}
+
+func parseLabelRequirement(part string) (labelRequirement, error) {
+	if key, ok := strings.CutPrefix(part, "!"); ok {
+		key = strings.TrimSpace(key)
+		return labelRequirement{key: key, op: selNotExists}, checkSelectorWord("key", key)
+	}
+	if i := strings.IndexAny(part, "=!"); i >= 0 {
+		key, rest := strings.TrimSpace(part[:i]), part[i:]
+		r := labelRequirement{key: key, op: selEquals}
+		switch {
+		case strings.HasPrefix(rest, "!="):
+			r.op, rest = selNotEquals, rest[2:]
+		case strings.HasPrefix(rest, "=="):
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "="):
+			rest = rest[1:]
+		default:
+			return r, fmt.Errorf("unexpected %q", rest)
+		}
+		value := strings.TrimSpace(rest)
+		if err := checkSelectorWord("key", key); err != nil {
+			return r, err
+		}
+		r.values = []string{value}
+		if value == "" {
+			return r, nil
+		}
+		return r, checkSelectorWord("value", value)
+	}
+	key, rest, ok := strings.Cut(part, " ")
+	if !ok {
+		return labelRequirement{key: part, op: selExists}, checkSelectorWord("key", part)
+	}
+	r := labelRequirement{key: key}
+	word, list, _ := strings.Cut(strings.TrimSpace(rest), "(")
+	switch strings.TrimSpace(word) {
+	case "in":
+		r.op = selIn
+	case "notin":
+		r.op = selNotIn
+	default:
+		return r, fmt.Errorf("unknown operator %q", strings.TrimSpace(word))
+	}
+	list, ok = strings.CutSuffix(strings.TrimSpace(list), ")")
+	if !ok {
+		return r, fmt.Errorf("expected a parenthesized value list after %s", word)
+	}
+	for v := range strings.SplitSeq(list, ",") {
+		v = strings.TrimSpace(v)
+		if err := checkSelectorWord("value", v); err != nil {
+			return r, err
+		}
+		r.values = append(r.values, v)
+	}
+	return r, checkSelectorWord("key", key)
+}
+
+// checkSelectorWord 检查键或值非空且不含空白与选择器的分隔符
+func checkSelectorWord(what, s string) error {
+	if s == "" || strings.ContainsAny(s, " \t\n(),=!") {
+		return fmt.Errorf("invalid %s %q", what, s)
+	}
+	return nil
+}
+
+// prepareLabelSelector 在编译期解析 matchLabels 的常量字符串选择器
+func prepareLabelSelector(i int, v any) (preparedArg, bool) {
+	src, ok := v.(string)
+	if i != 1 || !ok {
+		return nil, false
+	}
+	s, err := parseLabelSelector(src)
+	if err != nil {
+		return nil, false
+	}
+	return s, true
+}
+
+// objectMetadata 返回清单 obj 的 metadata 中名为 field 的映射，如 labels 与 annotations。
+// obj 为 nil 或缺少这些字段时返回 nil，即没有任何标签或注解
+func objectMetadata(name string, obj any, field string) (map[string]any, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	m, ok := obj.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a map object, got %T", name, obj)
+	}
+	meta, _ := m["metadata"].(map[string]any)
+	fields, _ := meta[field].(map[string]any)
+	return fields, nil
+}
+
+// matchLabels 实现内置函数 matchLabels(obj, selector)：清单 obj 的 metadata.labels 是否满足选择器。
+// selector 为映射时每个键值对都须相等，与 Kubernetes 的 matchLabels 相同；为字符串时按标签选择器语法解析，
+// 常量选择器在编译期解析。空选择器匹配任何对象
+func matchLabels(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("matchLabels expects 2 arguments, got %d", len(args))
+	}
+	labels, err := objectMetadata("matchLabels", args[0], "labels")
+	if err != nil {
+		return nil, err
+	}
+	var s *labelSelector
+	switch sel := args[1].(type) {
+	case map[string]any:
+		for k, want := range sel {
+			w, ok := want.(string)
+			if !ok {
+				return nil, fmt.Errorf("matchLabels: selector value for %q must be a string, got %T", k, want)
+			}
+			if v, ok := labels[k]; !ok || labelText(v) != w {
+				return false, nil
+			}
+		}
+		return true, nil
+	case *labelSelector:
+		s = sel
+	case string:
+		if s, err = parseLabelSelector(sel); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("matchLabels expects a map or string selector, got %T", args[1])
+	}
+	for _, r := range s.reqs {
+		if !r.matches(labels) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// hasAnnotation 实现内置函数 hasAnnotation(obj, key)：清单 obj 的 metadata.annotations 中是否有键 key
+func hasAnnotation(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("hasAnnotation expects 2 arguments, got %d", len(args))
+	}
+	key, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("hasAnnotation expects a string key, got %T", args[1])
+	}
+	annotations, err := objectMetadata("hasAnnotation", args[0], "annotations")
+	if err != nil {
+		return nil, err
+	}
+	_, ok = annotations[key]
+	return ok, nil
+}
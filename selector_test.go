@@ -0,0 +1,68 @@
+package uwasa
+
+import "testing"
+
+func TestMatchLabels(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`matchLabels(pod, {"app": "web"})`, true},
+		{`matchLabels(pod, {"app": "web", "tier": "frontend"})`, true},
+		{`matchLabels(pod, {"app": "api"})`, false},
+		{`matchLabels(pod, {"team": "web"})`, false},
+		{`matchLabels(pod, {})`, true},
+		{`matchLabels(pod, sel)`, true},
+		{`matchLabels(pod, "app=web,tier==frontend")`, true},
+		{`matchLabels(pod, "app = web, env != prod")`, true},
+		{`matchLabels(pod, "env in (staging, dev), tier notin (db)")`, true},
+		{`matchLabels(pod, "env in (prod)")`, false},
+		{`matchLabels(pod, "app, !legacy")`, true},
+		{`matchLabels(pod, "!app")`, false},
+		{`matchLabels(pod, "version=2")`, true},
+		{`matchLabels(pod, "")`, true},
+		{`matchLabels(pod, selText)`, false},
+		{`matchLabels(bare, "team!=core")`, true},
+		{`matchLabels(nil, {"app": "web"})`, false},
+		{`hasAnnotation(pod, "sidecar.istio.io/inject")`, true},
+		{`hasAnnotation(pod, "missing")`, false},
+		{`hasAnnotation(bare, "x")`, false},
+		{`pods |> filter(p -> matchLabels(p, "app=web")) |> len`, int64(1)},
+	}
+
+	pod := map[string]any{
+		"kind": "Pod",
+		"metadata": map[string]any{
+			"name":        "web-1",
+			"labels":      map[string]any{"app": "web", "tier": "frontend", "env": "staging", "version": int64(2)},
+			"annotations": map[string]any{"sidecar.istio.io/inject": "true"},
+		},
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{
+				"pod": pod, "bare": map[string]any{"kind": "Pod"},
+				"sel": map[string]any{"env": "staging"}, "selText": "tier in (db, cache)",
+				"pods": []any{pod, map[string]any{"metadata": map[string]any{"labels": map[string]any{"app": "api"}}}},
+			}
+			got, err := engine.Execute(vars)
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`matchLabels(pod, "app in web")`, `matchLabels(pod, "a=b=c")`, `matchLabels(pod, "app,")`, `matchLabels(pod, {"app": 1})`, `matchLabels("pod", "app")`, `matchLabels(pod, 1)`, `hasAnnotation(pod, 1)`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(map[string]any{"pod": pod})
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// makeSet 实现内置函数 set(a, b, ...)：由字符串构成的集合（ValSet，以 map[string]struct{} 保存）。
+// 唯一的实参为数组时以其元素构成集合，便于把上下文中的名单转换为集合；元素须为字符串
+func makeSet(args ...any) (any, error) {
+	if len(args) == 1 {
+		if arr, ok := args[0].([]any); ok {
+			args = arr
+		}
+	}
+	s := make(map[string]struct{}, len(args))
+	for _, arg := range args {
+		k, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("set expects strings, got %T", arg)
+		}
+		s[k] = struct{}{}
+	}
+	return s, nil
+}
+
+// setOp 计算两个集合的并集（|）、交集（&）、对称差（^）或差集（-），结果为新集合。op 为其他运算符时返回 false
+func setOp(op TokenType, l, r map[string]struct{}) (map[string]struct{}, bool) {
+	out := make(map[string]struct{})
+	switch op {
+	case TokenBitOr:
+		for k := range l {
+			out[k] = struct{}{}
+		}
+		for k := range r {
+			out[k] = struct{}{}
+		}
+	case TokenBitAnd:
+		if len(l) > len(r) {
+			l, r = r, l
+		}
+		for k := range l {
+			if _, ok := r[k]; ok {
+				out[k] = struct{}{}
+			}
+		}
+	case TokenBitXor, TokenMinus:
+		for k := range l {
+			if _, ok := r[k]; !ok {
+				out[k] = struct{}{}
+			}
+		}
+		if op == TokenBitXor {
+			for k := range r {
+				if _, ok := l[k]; !ok {
+					out[k] = struct{}{}
+				}
+			}
+		}
+	default:
+		return nil, false
+	}
+	return out, true
+}
+
+// setEqual 判断两个集合的元素是否相同
+func setEqual(l, r map[string]struct{}) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	for k := range l {
+		if _, ok := r[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// setString 按元素的字典序格式化集合，如 set("b", "a") 为 {a, b}，供 concat 与 str 使用
+func setString(s map[string]struct{}) string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return "{" + strings.Join(keys, ", ") + "}"
+}
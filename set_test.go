@@ -0,0 +1,57 @@
+package uwasa
+
+import "testing"
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`role in set("admin", "ops")`, true},
+		{`"guest" in set("admin", "ops")`, false},
+		{`role in set(admins)`, true},
+		{`role in denied`, false},
+		{`str(set("b", "a", "b"))`, "{a, b}"},
+		{`str(set("a", "b") | set("b", "c"))`, "{a, b, c}"},
+		{`str(set("a", "b") & set("b", "c"))`, "{b}"},
+		{`str(set("a", "b") - set("b", "c"))`, "{a}"},
+		{`str(set("a", "b") ^ set("b", "c"))`, "{a, c}"},
+		{`str(set(admins) - denied)`, "{admin, ops}"},
+		{`len(set(admins) | denied)`, int64(4)},
+		{`len(set())`, int64(0)},
+		{`set("a", "b") == set("b", "a")`, true},
+		{`set("a") != set(role)`, true},
+		{`set(admins) & denied == set("root")`, true},
+		{`typeof(set("a"))`, "set"},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{
+			"role":   "admin",
+			"admins": []any{"admin", "ops", "root"},
+			"denied": map[string]struct{}{"root": {}, "guest": {}},
+		}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`set(1)`, `set("a", role, 2)`, `1 in set("a")`, `set("a") | 1`, `len(set("a") & 1)`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// sliceBuiltin 实现 slice(x, start, end)：返回数组或字符串中下标从 start（含）到 end（不含）的部分。
+// 负数边界从末尾倒数，越界的边界截断到两端，end 不大于 start 时结果为空；字符串按字符计数，
+// 数组的结果是新数组，不与 x 共享
+func sliceBuiltin(args ...any) (any, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("slice expects 3 arguments, got %d", len(args))
+	}
+	start, err := sliceBound(args[1])
+	if err != nil {
+		return nil, err
+	}
+	end, err := sliceBound(args[2])
+	if err != nil {
+		return nil, err
+	}
+	return sliceAny(args[0], start, end)
+}
+
+func sliceBound(v any) (int64, error) {
+	switch b := v.(type) {
+	case int64:
+		return b, nil
+	case int:
+		return int64(b), nil
+	case float64:
+		if b == math.Trunc(b) {
+			return int64(b), nil
+		}
+	}
+	return 0, fmt.Errorf("slice bounds must be integers, got %v", v)
+}
+
+// clampBound 把边界换算为 [0, n] 内的下标
+func clampBound(i int64, n int) int {
+	if i < 0 {
+		i += int64(n)
+	}
+	return int(min(max(i, 0), int64(n)))
+}
+
+func sliceAny(x any, start, end int64) (any, error) {
+	switch v := x.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		i, j := clampBound(start, n), clampBound(end, n)
+		if j <= i {
+			return "", nil
+		}
+		if n == len(v) {
+			return v[i:j], nil
+		}
+		// 把字符下标换算为字节偏移
+		lo, hi, k := len(v), len(v), 0
+		for off := range v {
+			if k == i {
+				lo = off
+			}
+			if k == j {
+				hi = off
+				break
+			}
+			k++
+		}
+		return v[lo:hi], nil
+	case []any:
+		i, j := clampBound(start, len(v)), clampBound(end, len(v))
+		if j <= i {
+			return []any{}, nil
+		}
+		return append([]any(nil), v[i:j]...), nil
+	case []byte:
+		// 字节串按字节截取，结果与原字节串共享底层数组，截取大的载荷时不复制
+		i, j := clampBound(start, len(v)), clampBound(end, len(v))
+		return v[i:max(i, j):max(i, j)], nil
+	}
+	return nil, fmt.Errorf("slice expects an array, string or bytes, got %T", x)
+}
+
+// sliceBoundsArg 把常量边界打包为各 VM 中 SLICE 指令的参数，低 16 位为 start，高 16 位为 end；
+// 边界超出 int16 时返回 false，调用照常编译为 CALL
+func sliceBoundsArg(start, end any) (int32, bool) {
+	s, ok1 := start.(int64)
+	e, ok2 := end.(int64)
+	if !ok1 || !ok2 || s != int64(int16(s)) || e != int64(int16(e)) {
+		return 0, false
+	}
+	return int32(uint16(s)) | int32(e)<<16, true
+}
+
+// sliceValue 以 sliceBoundsArg 打包的边界截取 v，由各 VM 的 SLICE 指令调用
+func sliceValue(v Value, arg int32) (Value, error) {
+	start, end := int64(int16(arg)), int64(arg>>16)
+	if v.Type == ValString {
+		s, err := sliceAny(v.Str, start, end)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Type: ValString, Str: s.(string)}, nil
+	}
+	res, err := sliceAny(v.ToInterface(), start, end)
+	if err != nil {
+		return Value{}, err
+	}
+	return FromInterface(res), nil
+}
+
+// sliceCallArg 在 call 为边界均是整数字面量的 slice(x, start, end) 时返回 SLICE 指令的参数，
+// 供按 AST 编译的标准 VM 与寄存器 VM 使用。负数边界未经折叠时为前缀 - 与字面量
+func sliceCallArg(call *CallExpression) (int32, bool) {
+	if fn, ok := call.Function.(*Identifier); !ok || fn.Value != "slice" || len(call.Arguments) != 3 {
+		return 0, false
+	}
+	start, ok1 := intLiteralOf(call.Arguments[1])
+	end, ok2 := intLiteralOf(call.Arguments[2])
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return sliceBoundsArg(start, end)
+}
+
+func intLiteralOf(e Expression) (any, bool) {
+	if p, ok := e.(*PrefixExpression); ok && p.Operator == "-" {
+		if n, ok := p.Right.(*NumberLiteral); ok && n.IsInt {
+			return -n.Int64Value, true
+		}
+	}
+	if n, ok := e.(*NumberLiteral); ok && n.IsInt {
+		return n.Int64Value, true
+	}
+	return nil, false
+}
@@ -0,0 +1,53 @@
+package uwasa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSlice(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`slice(a, 1, 3)`, []any{int64(2), int64(3)}, false},
+		{`slice(a, -2, 10)`, []any{int64(3), int64(4)}, false},
+		{`slice(a, -10, -3)`, []any{int64(1)}, false},
+		{`slice(a, 3, 1)`, []any{}, false},
+		{`slice(a, 0, len(a) - 1)`, []any{int64(1), int64(2), int64(3)}, false},
+		{`slice(a, i, i + 2.0)`, []any{int64(1), int64(2)}, false},
+		{`a |> slice(1, -1)`, []any{int64(2), int64(3)}, false},
+		{`slice(s, 1, -1)`, "字x", false},
+		{`slice(s, -2, 100) + slice("hello", 0, 2)`, "xyhe", false},
+		{`slice(s, 5, 2) == ""`, true, false},
+		{`slice([1, 2, 3], 0, 1)`, []any{int64(1)}, false},
+		{`b = slice(a, 0, 2); b[0] = 9; a[0]`, int64(1), false},
+		{`slice(a, 0, 40000)`, []any{int64(1), int64(2), int64(3), int64(4)}, false},
+		{`slice(a, 0.5, 2)`, nil, true},
+		{`slice(a, "0", 2)`, nil, true},
+		{`slice(m, 0, 1)`, nil, true},
+		{`slice(nothing, 0, 1)`, nil, true},
+		{`slice(a, 1)`, nil, true},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"a": []any{int64(1), int64(2), int64(3), int64(4)}, "s": "名字xy", "i": int64(0), "m": map[string]any{}, "nothing": nil}
+	}
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				got, err := engine.ExecuteWithContext(ctx)
+				if tt.err {
+					if err == nil {
+						t.Errorf("%s %s: expected error, got %v", name, tt.input, got)
+					}
+					continue
+				}
+				if err != nil || !reflect.DeepEqual(got, tt.expected) {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package sqlwhere 把 SQL WHERE 子句风格的条件翻译为 uwasa 规则，习惯写 SQL 的分析人员可以直接书写
+// 规则中的条件：
+//
+//	engine, err := sqlwhere.Compile(`status = 'active' AND amount >= 100 AND name LIKE 'A%'`)
+//	ok, err := engine.Execute(row)
+//
+// 支持的语法（关键字不区分大小写）：
+//
+//	比较        = <> != < <= > >=
+//	逻辑        AND OR NOT，优先级 NOT > AND > OR
+//	算术        + - * / % 与一元 -
+//	模式匹配    [NOT] LIKE，% 匹配任意个字符，_ 匹配一个字符，\ 转义其后的字符
+//	集合与区间  [NOT] IN (v1, v2, ...)、[NOT] BETWEEN lo AND hi（含两端）
+//	空值        IS [NOT] NULL
+//	字面量      数字（可带指数，如 1e3、2.5E-1）、'字符串'（'' 表示一个单引号）、TRUE、FALSE、NULL
+//	列          name、"name" 或 `name`；a.b 读取映射 a 的成员 b，a 缺失时为 NULL
+//
+// 条件被翻译为 uwasa 源码（见 Translate），再由 NeoVM 编译，因此与手写的规则共用同一套字节码与内置函数。
+// 与 SQL 不同，没有三值逻辑：NULL 即 nil，`x = NULL` 与 `x IS NULL` 等价，对 NULL 比较大小是执行期错误，
+// 需要时先以 `x IS NOT NULL AND x > 0` 排除。LIKE 由本包注册的内置函数 like 实现，区分大小写，
+// 任一侧为 NULL 时不匹配。
+package sqlwhere
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kamihama-railway/uwasa"
+	"github.com/kamihama-railway/uwasa/internal/lex"
+)
+
+func init() {
+	if err := uwasa.RegisterBuiltin("like", like, uwasa.BuiltinOptions{Pure: true}); err != nil {
+		panic(err)
+	}
+}
+
+// Compile 使用默认选项编译 WHERE 条件
+func Compile(where string) (*uwasa.Engine, error) {
+	return CompileWithOptions(where, uwasa.EngineOptions{})
+}
+
+// CompileWithOptions 翻译 WHERE 条件并以 opts 交给 uwasa.NewEngineVMNeoWithOptions 编译
+func CompileWithOptions(where string, opts uwasa.EngineOptions) (*uwasa.Engine, error) {
+	source, err := Translate(where)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := uwasa.NewEngineVMNeoWithOptions(source, opts)
+	if err != nil {
+		return nil, fmt.Errorf("sqlwhere: %w", err)
+	}
+	return engine, nil
+}
+
+// Translate 把 WHERE 条件翻译为等价的 uwasa 源码，如 `a = 1 AND b LIKE 'x%'` 翻译为
+// `((a == 1) && like(b, "x%"))`。翻译结果可交给任意引擎编译
+func Translate(where string) (string, error) {
+	p := &parser{lex.New("sqlwhere", where, 0, scan)}
+	p.Next()
+	out, err := p.parseOr()
+	if err != nil {
+		return "", err
+	}
+	if p.Err() != nil || p.Tok.Kind != lex.EOF {
+		return "", p.Fail("unexpected %s", p.Tok)
+	}
+	return out, nil
+}
+
+// parser 以递归下降解析 WHERE 条件，每个 parse 方法返回对应子表达式的 uwasa 源码
+type parser struct {
+	lex.Scanner
+}
+
+var operators = []string{"<>", "!=", "<=", ">=", "=", "<", ">", "+", "-", "*", "/", "%", "(", ")", ",", "."}
+
+// scan 读取一个记号：单引号为字符串，双引号与反引号为带引号的列名
+func scan(s *lex.Scanner, c byte) {
+	switch {
+	case c == '\'':
+		s.Quoted(lex.String, "'")
+	case c == '"' || c == '`':
+		s.Quoted(lex.QuotedName, string(c))
+	case isDigit(c) || c == '.' && isDigit(s.Peek(1)):
+		s.Skip(isDigit)
+		if s.Peek(0) == '.' {
+			s.Advance(1)
+			s.Skip(isDigit)
+		}
+		// 指数部分须有数字，1e 中的 e 留作下一个记号
+		if e := s.Peek(0); e == 'e' || e == 'E' {
+			n := 1
+			if sign := s.Peek(1); sign == '+' || sign == '-' {
+				n = 2
+			}
+			if isDigit(s.Peek(n)) {
+				s.Advance(n)
+				s.Skip(isDigit)
+			}
+		}
+		mant, exp, hasExp := strings.Cut(strings.ToLower(s.Text()), "e")
+		text := strings.TrimSuffix(mant, ".")
+		if text[0] == '.' {
+			text = "0" + text
+		}
+		if hasExp {
+			text += "e" + exp
+		}
+		s.Emit(lex.Number, text)
+	case isLetter(c):
+		s.Skip(func(c byte) bool { return isLetter(c) || isDigit(c) })
+		s.Emit(lex.Name, s.Text())
+	default:
+		s.Ops(operators)
+	}
+}
+
+func isDigit(c byte) bool  { return '0' <= c && c <= '9' }
+func isLetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' }
+
+// keyword 判断当前记号是否为关键字 kw（不区分大小写），带引号的列名不是关键字
+func (p *parser) keyword(kw string) bool {
+	return p.Tok.Kind == lex.Name && strings.EqualFold(p.Tok.Text, kw)
+}
+
+func (p *parser) parseOr() (string, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.keyword("OR") {
+		p.Next()
+		var right string
+		right, err = p.parseAnd()
+		left = "(" + left + " || " + right + ")"
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (string, error) {
+	left, err := p.parseNot()
+	for err == nil && p.keyword("AND") {
+		p.Next()
+		var right string
+		right, err = p.parseNot()
+		left = "(" + left + " && " + right + ")"
+	}
+	return left, err
+}
+
+func (p *parser) parseNot() (string, error) {
+	if p.keyword("NOT") {
+		p.Next()
+		operand, err := p.parseNot()
+		return "!" + operand, err
+	}
+	return p.parsePredicate()
+}
+
+var comparisons = map[string]string{"=": "==", "<>": "!=", "!=": "!=", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+
+// parsePredicate 解析比较、LIKE、IN、BETWEEN 与 IS NULL，它们不能连写
+func (p *parser) parsePredicate() (string, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return "", err
+	}
+	if p.Tok.Kind == lex.Op {
+		if op, ok := comparisons[p.Tok.Text]; ok {
+			p.Next()
+			right, err := p.parseAdditive()
+			return "(" + left + " " + op + " " + right + ")", err
+		}
+	}
+	if p.keyword("IS") {
+		p.Next()
+		op := "=="
+		if p.keyword("NOT") {
+			op = "!="
+			p.Next()
+		}
+		if !p.keyword("NULL") {
+			return "", p.Fail("expected NULL after IS, got %s", p.Tok)
+		}
+		p.Next()
+		return "(" + left + " " + op + " nil)", nil
+	}
+	negate := ""
+	if p.keyword("NOT") {
+		negate = "!"
+		p.Next()
+	}
+	switch {
+	case p.keyword("LIKE"):
+		p.Next()
+		pattern, err := p.parseAdditive()
+		return negate + "like(" + left + ", " + pattern + ")", err
+	case p.keyword("IN"):
+		p.Next()
+		if err := p.Expect("("); err != nil {
+			return "", err
+		}
+		var items []string
+		for {
+			item, err := p.parseAdditive()
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+			if !p.Is(",") {
+				break
+			}
+			p.Next()
+		}
+		if err := p.Expect(")"); err != nil {
+			return "", err
+		}
+		return negate + "(" + left + " in [" + strings.Join(items, ", ") + "])", nil
+	case p.keyword("BETWEEN"):
+		p.Next()
+		lo, err := p.parseAdditive()
+		if err != nil {
+			return "", err
+		}
+		if !p.keyword("AND") {
+			return "", p.Fail("expected AND in BETWEEN, got %s", p.Tok)
+		}
+		p.Next()
+		hi, err := p.parseAdditive()
+		return negate + "(" + left + " >= " + lo + " && " + left + " <= " + hi + ")", err
+	}
+	if negate != "" {
+		return "", p.Fail("expected LIKE, IN or BETWEEN after NOT, got %s", p.Tok)
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (string, error) {
+	left, err := p.parseTerm()
+	for err == nil && (p.Is("+") || p.Is("-")) {
+		op := p.Tok.Text
+		p.Next()
+		var right string
+		right, err = p.parseTerm()
+		left = "(" + left + " " + op + " " + right + ")"
+	}
+	return left, err
+}
+
+func (p *parser) parseTerm() (string, error) {
+	left, err := p.parseUnary()
+	for err == nil && (p.Is("*") || p.Is("/") || p.Is("%")) {
+		op := p.Tok.Text
+		p.Next()
+		var right string
+		right, err = p.parseUnary()
+		left = "(" + left + " " + op + " " + right + ")"
+	}
+	return left, err
+}
+
+func (p *parser) parseUnary() (string, error) {
+	if p.Is("-") {
+		p.Next()
+		operand, err := p.parseUnary()
+		return "(-" + operand + ")", err
+	}
+	return p.parsePrimary()
+}
+
+// reserved 为不能用作列名的关键字
+var reserved = []string{"AND", "OR", "NOT", "LIKE", "IN", "BETWEEN", "IS", "NULL", "TRUE", "FALSE"}
+
+func (p *parser) parsePrimary() (string, error) {
+	tok := p.Tok
+	switch tok.Kind {
+	case lex.Number:
+		p.Next()
+		return tok.Text, nil
+	case lex.String:
+		p.Next()
+		return uwasa.Quote(tok.Text), nil
+	case lex.Op:
+		if tok.Text != "(" {
+			break
+		}
+		p.Next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		return inner, p.Expect(")")
+	case lex.Name:
+		switch strings.ToUpper(tok.Text) {
+		case "TRUE":
+			p.Next()
+			return "true", nil
+		case "FALSE":
+			p.Next()
+			return "false", nil
+		case "NULL":
+			p.Next()
+			return "nil", nil
+		}
+		for _, kw := range reserved {
+			if strings.EqualFold(tok.Text, kw) {
+				return "", p.Fail("unexpected %s", tok)
+			}
+		}
+		return p.parseColumn()
+	case lex.QuotedName:
+		return p.parseColumn()
+	}
+	return "", p.Fail("unexpected %s", tok)
+}
+
+// parseColumn 解析列名与其后以 . 分隔的成员名，成员访问翻译为 ?.，缺失的映射得到 nil
+func (p *parser) parseColumn() (string, error) {
+	var b strings.Builder
+	for {
+		if p.Tok.Kind != lex.Name && p.Tok.Kind != lex.QuotedName {
+			return "", p.Fail("expected column name, got %s", p.Tok)
+		}
+		if !uwasa.IsIdentifier(p.Tok.Text) {
+			return "", p.Fail("column name %q is not a valid uwasa identifier", p.Tok.Text)
+		}
+		b.WriteString(p.Tok.Text)
+		p.Next()
+		if !p.Is(".") {
+			return b.String(), nil
+		}
+		p.Next()
+		b.WriteString("?.")
+	}
+}
+
+// like 实现内置函数 like(s, pattern)：SQL 的 LIKE 匹配，任一实参为 nil 时不匹配
+func like(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("like expects 2 arguments, got %d", len(args))
+	}
+	if args[0] == nil || args[1] == nil {
+		return false, nil
+	}
+	s, ok1 := args[0].(string)
+	pattern, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("like expects strings, got %T and %T", args[0], args[1])
+	}
+	return matchLike([]rune(s), []rune(pattern))
+}
+
+// likeElem 是 LIKE 模式中的一个元素：字面字符、_ 或 %
+type likeElem struct {
+	r    rune
+	kind byte
+}
+
+// matchLike 按字符（而非字节）匹配，% 遇到不匹配时回退到最近的 % 重试，时间为 O(len(s)·len(pattern))
+func matchLike(s, pattern []rune) (bool, error) {
+	elems := make([]likeElem, 0, len(pattern))
+	for i := 0; i < len(pattern); i++ {
+		switch r := pattern[i]; r {
+		case '%', '_':
+			elems = append(elems, likeElem{kind: byte(r)})
+		case '\\':
+			if i++; i == len(pattern) {
+				return false, fmt.Errorf("like: pattern %q ends with an escape", string(pattern))
+			}
+			elems = append(elems, likeElem{r: pattern[i]})
+		default:
+			elems = append(elems, likeElem{r: r})
+		}
+	}
+	si, pi, star, mark := 0, 0, -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(elems) && (elems[pi].kind == '_' || elems[pi].kind == 0 && elems[pi].r == s[si]):
+			si, pi = si+1, pi+1
+		case pi < len(elems) && elems[pi].kind == '%':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			mark++
+			si, pi = mark, star+1
+		default:
+			return false, nil
+		}
+	}
+	for pi < len(elems) && elems[pi].kind == '%' {
+		pi++
+	}
+	return pi == len(elems), nil
+}
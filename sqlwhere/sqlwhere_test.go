@@ -0,0 +1,97 @@
+package sqlwhere
+
+import (
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+	"github.com/kamihama-railway/uwasa/internal/enginetest"
+)
+
+func row() map[string]any {
+	return map[string]any{
+		"status": "active",
+		"amount": int64(150),
+		"name":   "Alice",
+		"tier":   "gold",
+		"note":   nil,
+		"rate":   0.25,
+		"user":   map[string]any{"country": "JP"},
+		"select": "kw",
+		"path":   `100%_done`,
+	}
+}
+
+func TestWhere(t *testing.T) {
+	enginetest.Run(t, enginetest.Table{
+		{`status = 'active' AND amount >= 100 AND name LIKE 'A%'`, true},
+		{`status = 'active' and amount >= 200`, false},
+		{`status <> 'active' OR amount > 100`, true},
+		{`NOT status = 'closed'`, true},
+		{`name LIKE '_lic_'`, true},
+		{`name LIKE 'a%'`, false},
+		{`name NOT LIKE '%z%'`, true},
+		{`path LIKE '100\%\_%'`, true},
+		{`tier IN ('gold', 'platinum')`, true},
+		{`tier NOT IN ('gold')`, false},
+		{`amount BETWEEN 100 AND 150`, true},
+		{`amount NOT BETWEEN 100 AND 150`, false},
+		{`note IS NULL AND missing IS NULL`, true},
+		{`name IS NOT NULL`, true},
+		{`note = NULL`, true},
+		{`note LIKE '%'`, false},
+		{`amount * 2 - 50 = 250 AND amount % 7 = 3`, true},
+		{`-amount < 0 AND rate < .5`, true},
+		{`user.country = 'JP' AND profile.country IS NULL`, true},
+		{`"select" = 'kw' AND (status = 'x' OR TRUE)`, true},
+		{`name = 'O''Brien' OR name = 'Alice'`, true},
+		{`FALSE OR amount IS NOT NULL AND amount > 100`, true},
+		{`amount < 1e3 AND amount > 1.2E+2`, true},
+		{`rate = 2.5E-1 AND rate < .3e0`, true},
+		{`amount = 15.e1`, true},
+	}, Translate, func(engine *uwasa.Engine) (any, error) {
+		return engine.Execute(row())
+	})
+}
+
+func TestTranslate(t *testing.T) {
+	got, err := Translate(`a = 1 AND b LIKE 'x%'`)
+	if want := `((a == 1) && like(b, "x%"))`; err != nil || got != want {
+		t.Errorf("expected %s, got %s (%v)", want, got, err)
+	}
+	got, err = Translate(`a > 2.5E-1 OR a < .5e+2`)
+	if want := `((a > 2.5e-1) || (a < 0.5e+2))`; err != nil || got != want {
+		t.Errorf("expected %s, got %s (%v)", want, got, err)
+	}
+	engine, err := Compile(`amount > 100 AND status = 'active'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := engine.Execute(row()); err != nil || got != true {
+		t.Errorf("expected true, got %v (%v)", got, err)
+	}
+
+	for _, bad := range []string{
+		`status = 'active`,
+		`status = `,
+		`amount > 1 = 2`,
+		`status IS 'x'`,
+		`tier NOT 'gold'`,
+		`tier IN ()`,
+		`amount BETWEEN 1 OR 2`,
+		`"if" = 1`,
+		`user.in = 1`,
+		`amount ; 1`,
+		`AND = 1`,
+		`amount = 1e`,
+		`amount = 1e+`,
+	} {
+		if _, err := Compile(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+	// 模式末尾的转义符是执行期错误
+	engine, _ = Compile(`name LIKE 'A\'`)
+	if _, err := engine.Execute(row()); err == nil {
+		t.Errorf("expected an error for a trailing escape")
+	}
+}
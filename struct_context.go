@@ -0,0 +1,192 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StructContext 通过反射把结构体的导出字段作为规则变量，宿主无需为每次调用手工构造映射。
+// 变量名默认为字段名，可以用 `uwasa:"order_total"` 标签指定，`uwasa:"-"` 的字段不可见；
+// 匿名嵌入的结构体与 encoding/json 一样提升其字段，外层的同名字段优先。
+//
+// 整数、无符号整数与浮点数字段分别读作 int64 与 float64，嵌套的结构体（及其指针、切片）按同样的规则
+// 转换为映射与数组，time.Time 与 Decimal 保持原样。规则对顶层字段赋值时写回结构体，要求 NewStructContext 收到的是指针，
+// 且值可以转换为字段的类型；转换得到的映射与数组是副本，对其下标赋值不修改结构体。
+// 其他名称的赋值保存在该 Context 中。各结构体类型的字段表在首次使用时构建并缓存。
+type StructContext struct {
+	v      reflect.Value
+	fields *structFields
+	vars   map[string]any
+}
+
+// structFields 是一种结构体类型的字段表，键为变量名，值为 reflect.Value.FieldByIndex 的下标路径
+type structFields struct {
+	index map[string][]int
+}
+
+// structFieldCache 缓存各结构体类型的字段表，键为 reflect.Type
+var structFieldCache sync.Map
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	durationType = reflect.TypeFor[time.Duration]()
+	decimalType  = reflect.TypeFor[Decimal]()
+)
+
+// NewStructContext 返回结构体 v 的上下文，v 为结构体或指向结构体的指针
+func NewStructContext(v any) (*StructContext, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("NewStructContext expects a struct or pointer to struct, got %T", v)
+	}
+	return &StructContext{v: rv, fields: fieldsOf(rv.Type())}, nil
+}
+
+func fieldsOf(t reflect.Type) *structFields {
+	if f, ok := structFieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f := &structFields{index: make(map[string][]int)}
+	f.collect(t, nil)
+	actual, _ := structFieldCache.LoadOrStore(t, f)
+	return actual.(*structFields)
+}
+
+// collect 收集 t 的字段，先登记本层字段，再展开匿名嵌入的结构体，已有的名称不被覆盖
+func (f *structFields) collect(t reflect.Type, prefix []int) {
+	var embedded []reflect.StructField
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag, hasTag := sf.Tag.Lookup("uwasa")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		// 嵌入的结构体指针可能为 nil，按普通字段处理
+		if sf.Anonymous && !hasTag && sf.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, sf)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if _, ok := f.index[name]; !ok {
+			f.index[name] = append(append([]int(nil), prefix...), i)
+		}
+	}
+	for _, sf := range embedded {
+		f.collect(sf.Type, append(append([]int(nil), prefix...), sf.Index...))
+	}
+}
+
+// Get 实现 Context：先查规则赋值的其他变量，再查结构体字段
+func (c *StructContext) Get(name string) (any, bool) {
+	if v, ok := c.vars[name]; ok {
+		return v, true
+	}
+	index, ok := c.fields.index[name]
+	if !ok {
+		return nil, false
+	}
+	return reflectValue(c.v.FieldByIndex(index)), true
+}
+
+// Set 实现 Context。字段不可寻址（NewStructContext 收到的不是指针）或值无法转换为字段类型时返回错误
+func (c *StructContext) Set(name string, value any) error {
+	index, ok := c.fields.index[name]
+	if !ok {
+		if c.vars == nil {
+			c.vars = make(map[string]any)
+		}
+		c.vars[name] = value
+		return nil
+	}
+	field := c.v.FieldByIndex(index)
+	if !field.CanSet() {
+		return fmt.Errorf("cannot assign to field %s: struct is not addressable", name)
+	}
+	if value == nil {
+		field.SetZero()
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	if !assignable(rv.Type(), field.Type()) {
+		return fmt.Errorf("cannot assign %T to field %s of type %s", value, name, field.Type())
+	}
+	field.Set(rv.Convert(field.Type()))
+	return nil
+}
+
+// assignable 判断 from 类型的值能否写入 to 类型的字段：数字之间按 Go 的转换规则（浮点数向零取整），
+// 其余要求种类相同且可转换，避免 reflect 把整数转换为对应码点的字符串
+func assignable(from, to reflect.Type) bool {
+	if isNumberKind(from.Kind()) && isNumberKind(to.Kind()) {
+		return to != durationType || from == durationType
+	}
+	return from.Kind() == to.Kind() && from.ConvertibleTo(to)
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return reflect.Int <= k && k <= reflect.Float64
+}
+
+// reflectValue 把字段值转换为引擎使用的类型
+func reflectValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			return time.Duration(v.Int())
+		}
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Elem().Kind() == reflect.Struct && v.Elem().Type() != timeType && v.Elem().Type() != decimalType {
+			return reflectValue(v.Elem())
+		}
+	case reflect.Struct:
+		if v.Type() == timeType || v.Type() == decimalType {
+			break
+		}
+		fields := fieldsOf(v.Type())
+		m := make(map[string]any, len(fields.index))
+		for name, index := range fields.index {
+			m[name] = reflectValue(v.FieldByIndex(index))
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		arr := make([]any, v.Len())
+		for i := range arr {
+			arr[i] = reflectValue(v.Index(i))
+		}
+		return arr
+	}
+	if !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
@@ -0,0 +1,103 @@
+package uwasa
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type structAudit struct {
+	Reviewer string `uwasa:"reviewer"`
+}
+
+type structOrder struct {
+	structAudit
+	ID       string        `uwasa:"id"`
+	Total    float64       `uwasa:"order_total"`
+	Quantity int32         `uwasa:"qty"`
+	Tier     uint8         `uwasa:"tier"`
+	Discount float64       `uwasa:"discount,omitempty"`
+	Secret   string        `uwasa:"-"`
+	Placed   time.Time     `uwasa:"placed"`
+	TTL      time.Duration `uwasa:"ttl"`
+	Buyer    *structBuyer  `uwasa:"buyer"`
+	Lines    []structLine  `uwasa:"lines"`
+	Note     string
+	internal int
+}
+
+type structBuyer struct {
+	Name string `uwasa:"name"`
+	VIP  bool   `uwasa:"vip"`
+}
+
+type structLine struct {
+	SKU   string `uwasa:"sku"`
+	Price int    `uwasa:"price"`
+}
+
+func TestStructContext(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`order_total > 100 && qty == 3 && tier == 2`, true},
+		{`id`, "o-1"},
+		{`buyer?.name`, "alice"},
+		{`buyer?.vip`, true},
+		{`lines[1]?.price + len(lines)`, int64(52)},
+		{`[l?.sku for l in lines]`, []any{"a", "b"}},
+		{`placed < @2024-02-01 && ttl == 2h`, true},
+		{`reviewer`, "bob"},
+		{`Note`, "fragile"},
+		{`defined(Total) || defined(Secret) || defined(secret) || defined(internal)`, false},
+		{`discount = order_total * 0.1; discount`, 12.5},
+		{`qty = qty + 1; qty`, int64(4)},
+		{`extra = 1; extra + qty`, int64(4)},
+	}
+
+	newOrder := func() *structOrder {
+		return &structOrder{
+			structAudit: structAudit{Reviewer: "bob"},
+			ID:          "o-1", Total: 125, Quantity: 3, Tier: 2, Secret: "s", Note: "fragile",
+			Placed: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), TTL: 2 * time.Hour,
+			Buyer: &structBuyer{Name: "alice", VIP: true},
+			Lines: []structLine{{"a", 10}, {"b", 50}},
+		}
+	}
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			order := newOrder()
+			ctx, err := NewStructContext(order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := engine.ExecuteWithContext(ctx)
+			if err != nil || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+	}
+
+	// 赋值写回结构体
+	order := newOrder()
+	ctx, _ := NewStructContext(order)
+	engine, _ := NewEngineVMNeo(`qty = 7; discount = 5; 1`)
+	if _, err := engine.ExecuteWithContext(ctx); err != nil || order.Quantity != 7 || order.Discount != 5 {
+		t.Errorf("expected assignments to update the struct, got %+v (%v)", order, err)
+	}
+	for _, src := range []string{`qty = "7"`, `id = 7`, `ttl = 5`} {
+		engine, _ := NewEngineVMNeo(src)
+		if _, err := engine.ExecuteWithContext(ctx); err == nil {
+			t.Errorf("%s: expected assignment error", src)
+		}
+	}
+	// 按值传入的结构体只读
+	byValue, _ := NewStructContext(*newOrder())
+	if _, err := engine.ExecuteWithContext(byValue); err == nil {
+		t.Errorf("expected error assigning to a struct passed by value")
+	}
+	if _, err := NewStructContext(map[string]any{}); err == nil {
+		t.Errorf("expected error for non-struct value")
+	}
+}
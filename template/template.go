@@ -0,0 +1,195 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package template 把嵌有 uwasa 表达式的文本编译为一条规则，如
+// "Hello {{name}}, total {{price * qty}}"。
+//
+// 文本片段与各表达式按顺序作为 concat 的参数，整个模板编译为一个以单条 Concat 指令收尾的
+// NeoVM 程序；表达式结果的格式与 concat 相同。输出中需要字面的 "{{" 时写作 {{"{{"}}。
+//
+// 表达式的结果可以按输出位置转义：Options.Escape 指定整个模板的默认方式，
+// 单个表达式可以用 {{html: x}}、{{url: x}}、{{json: x}} 或 {{raw: x}} 覆盖。
+package template
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+// Escape 指定表达式结果写入输出前的转义方式
+type Escape int
+
+const (
+	EscapeNone Escape = iota // 原样输出
+	EscapeHTML               // HTML 文本与带引号的属性值，转义 <>&'"
+	EscapeURL                // URL 查询参数，按 url.QueryEscape 编码
+	EscapeJSON               // JSON 字符串的引号之内，同时转义 <>&
+)
+
+// escapers 为各转义方式对应的内置函数与 {{名称: x}} 中的名称
+var escapers = []struct {
+	name    string
+	builtin string
+}{
+	EscapeNone: {"raw", ""},
+	EscapeHTML: {"html", "escape_html"},
+	EscapeURL:  {"url", "escape_url"},
+	EscapeJSON: {"json", "escape_json"},
+}
+
+// Options 配置模板的编译
+type Options struct {
+	// Engine 交给 uwasa.NewEngineVMNeoWithOptions，例如用 MaxConcatBytes 限制输出的长度
+	Engine uwasa.EngineOptions
+	// Escape 为未标注转义方式的表达式的默认转义
+	Escape Escape
+}
+
+// Template 是编译好的模板，可被多个协程同时执行
+type Template struct {
+	source string
+	engine *uwasa.Engine
+}
+
+// Parse 使用默认选项编译模板，表达式结果不转义
+func Parse(text string) (*Template, error) {
+	return ParseWithOptions(text, Options{})
+}
+
+// ParseHTML 编译输出 HTML 的模板，表达式结果默认按 EscapeHTML 转义
+func ParseHTML(text string) (*Template, error) {
+	return ParseWithOptions(text, Options{Escape: EscapeHTML})
+}
+
+// ParseWithOptions 按 opts 编译模板
+func ParseWithOptions(text string, opts Options) (*Template, error) {
+	if opts.Escape < 0 || int(opts.Escape) >= len(escapers) {
+		return nil, fmt.Errorf("template: unknown escape %d", opts.Escape)
+	}
+	source, err := compile(text, opts.Escape)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := uwasa.NewEngineVMNeoWithOptions(source, opts.Engine)
+	if err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+	return &Template{source: source, engine: engine}, nil
+}
+
+// Source 返回模板编译成的规则源码
+func (t *Template) Source() string {
+	return t.source
+}
+
+// Execute 以 vars 为上下文渲染模板
+func (t *Template) Execute(vars map[string]any) (string, error) {
+	res, err := t.engine.Execute(vars)
+	if err != nil {
+		return "", err
+	}
+	s, ok := res.(string)
+	if !ok {
+		return "", fmt.Errorf("template: expected string result, got %T", res)
+	}
+	return s, nil
+}
+
+// ExecuteTo 渲染模板并写入 w；执行出错时不写入任何内容
+func (t *Template) ExecuteTo(w io.Writer, vars map[string]any) error {
+	s, err := t.Execute(vars)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, s)
+	return err
+}
+
+// compile 把模板改写为 concat("文本", (表达式), ...) 形式的规则源码，
+// 需要转义的表达式包在对应的内置函数中，如 escape_html((表达式))
+func compile(text string, escape Escape) (string, error) {
+	var b strings.Builder
+	b.WriteString("concat(")
+	first := true
+	arg := func(s string) {
+		if !first {
+			b.WriteString(", ")
+		}
+		first = false
+		b.WriteString(s)
+	}
+	for pos := 0; pos < len(text); {
+		open := strings.Index(text[pos:], "{{")
+		if open < 0 {
+			arg(uwasa.Quote(text[pos:]))
+			break
+		}
+		open += pos
+		if open > pos {
+			arg(uwasa.Quote(text[pos:open]))
+		}
+		end, err := actionEnd(text, open+2)
+		if err != nil {
+			return "", err
+		}
+		expr := strings.TrimSpace(text[open+2 : end])
+		if expr == "" {
+			return "", fmt.Errorf("template: empty action at offset %d", open)
+		}
+		esc := escape
+		if name, rest, ok := strings.Cut(expr, ":"); ok {
+			for i, e := range escapers {
+				if e.name == strings.TrimSpace(name) {
+					esc, expr = Escape(i), strings.TrimSpace(rest)
+					break
+				}
+			}
+		}
+		if expr == "" {
+			return "", fmt.Errorf("template: empty action at offset %d", open)
+		}
+		if fn := escapers[esc].builtin; fn != "" {
+			arg(fn + "((" + expr + "))")
+		} else {
+			arg("(" + expr + ")")
+		}
+		pos = end + 2
+	}
+	b.WriteString(")")
+	return b.String(), nil
+}
+
+// actionEnd 返回从 start 开始的表达式之后 "}}" 的位置。字符串中的内容与映射字面量的
+// 花括号不会结束表达式
+func actionEnd(text string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"', '`':
+			j := i + 1
+			for j < len(text) && text[j] != c {
+				if c == '"' && text[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(text) {
+				return 0, fmt.Errorf("template: unterminated string at offset %d", i)
+			}
+			i = j
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 && i+1 < len(text) && text[i+1] == '}' {
+				return i, nil
+			}
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+	return 0, fmt.Errorf("template: unclosed action at offset %d", start-2)
+}
@@ -0,0 +1,105 @@
+package template
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{`Hello {{name}}, total {{price * qty}}`, "Hello 小明, total 30"},
+		{`{{ if vip is "尊敬的" else is "" }}{{name}}`, "尊敬的小明"},
+		{`plain "quoted" \ text`, `plain "quoted" \ text`},
+		{`{{"{{"}} literal braces }}`, "{{ literal braces }}"},
+		{`{{ {"a": {"b": 7}}["a"]["b"] }} and {{ "}}" }}`, "7 and }}"},
+		{`{{ name |> len }} chars`, "2 chars"},
+		{"multi\nline {{qty}}", "multi\nline 3"},
+		{"", ""},
+	}
+	vars := map[string]any{"name": "小明", "price": int64(10), "qty": int64(3), "vip": true}
+	for _, tt := range tests {
+		tmpl, err := Parse(tt.text)
+		if err != nil {
+			t.Errorf("%q: parse error: %v", tt.text, err)
+			continue
+		}
+		got, err := tmpl.Execute(vars)
+		if err != nil || got != tt.expected {
+			t.Errorf("%q: expected %q, got %q (%v)\nsource: %s", tt.text, tt.expected, got, err, tmpl.Source())
+		}
+		var b strings.Builder
+		if err := tmpl.ExecuteTo(&b, vars); err != nil || b.String() != tt.expected {
+			t.Errorf("%q: ExecuteTo wrote %q (%v)", tt.text, b.String(), err)
+		}
+	}
+
+	for _, bad := range []string{`{{name`, `{{ }}`, `{{ "}} }}`, `{{ a + }}`, `{{ fn f(x) => x; f(1) }}`} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("%q: expected parse error", bad)
+		}
+	}
+}
+
+func TestTemplateEscape(t *testing.T) {
+	tests := []struct {
+		text     string
+		escape   Escape
+		expected string
+	}{
+		{`<p>{{ name }}</p>`, EscapeHTML, `<p>&lt;b&gt;Tom &amp; &#34;Jerry&#34;&lt;/b&gt;</p>`},
+		{`<a href="/s?q={{url: q}}">{{ raw: "<i>" }}{{ n * 2 }}</a>`, EscapeHTML, `<a href="/s?q=a+b%26c%3D%E4%B8%AD">` + "<i>14</a>"},
+		{`{"name": "{{json: name}}"}`, EscapeNone, `{"name": "\u003cb\u003eTom \u0026 \"Jerry\"\u003c/b\u003e"}`},
+		{`{{ name }}`, EscapeNone, `<b>Tom & "Jerry"</b>`},
+		{`{{ html: q }}|{{url:q}}`, EscapeJSON, "a b&amp;c=中|a+b%26c%3D%E4%B8%AD"},
+		{`{{ {"k": "v:w"}["k"] }}`, EscapeURL, "v%3Aw"},
+	}
+	vars := map[string]any{"name": `<b>Tom & "Jerry"</b>`, "q": "a b&c=中", "n": int64(7)}
+	for _, tt := range tests {
+		tmpl, err := ParseWithOptions(tt.text, Options{Escape: tt.escape})
+		if err != nil {
+			t.Errorf("%q: parse error: %v", tt.text, err)
+			continue
+		}
+		if got, err := tmpl.Execute(vars); err != nil || got != tt.expected {
+			t.Errorf("%q: expected %q, got %q (%v)\nsource: %s", tt.text, tt.expected, got, err, tmpl.Source())
+		}
+	}
+
+	tmpl, err := ParseHTML(`<li>{{ item }}</li>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := tmpl.Execute(map[string]any{"item": "<script>"}); got != "<li>&lt;script&gt;</li>" {
+		t.Errorf("ParseHTML: got %q", got)
+	}
+	for _, bad := range []string{`{{ html: }}`, `{{ url: a + }}`} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("%q: expected parse error", bad)
+		}
+	}
+	if _, err := ParseWithOptions("x", Options{Escape: Escape(9)}); err == nil {
+		t.Errorf("expected unknown escape to be rejected")
+	}
+}
+
+func TestTemplateLimits(t *testing.T) {
+	tmpl, err := ParseWithOptions(`<{{ body }}>`, Options{Engine: uwasa.EngineOptions{MaxConcatBytes: 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	err = tmpl.ExecuteTo(&b, map[string]any{"body": "0123456789"})
+	var limit *uwasa.ConcatLimitError
+	if !errors.As(err, &limit) {
+		t.Errorf("expected ConcatLimitError, got %v", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("expected nothing written on error, got %q", b.String())
+	}
+}
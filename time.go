@@ -0,0 +1,94 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parseTime 解析 @ 之后的时间字面量，两种前端共用：RFC 3339 格式的时间（可带小数秒与时区偏移），
+// 或只有日期的 2006-01-02，表示该日 UTC 零点
+func parseTime(lit string) (*TimeLiteral, error) {
+	layout := time.RFC3339Nano
+	if len(lit) == len(time.DateOnly) {
+		layout = time.DateOnly
+	}
+	t, err := time.Parse(layout, lit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time literal @%s", lit)
+	}
+	return &TimeLiteral{Value: t, Literal: lit}, nil
+}
+
+// parseDuration 解析 2h30m 形式的时长字面量，格式同 time.ParseDuration，数字中可以用 _ 分隔
+func parseDuration(lit string) (*DurationLiteral, error) {
+	d, err := time.ParseDuration(strings.ReplaceAll(lit, "_", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration literal %s", lit)
+	}
+	return &DurationLiteral{Value: d, Literal: lit}, nil
+}
+
+// isTemporal 判断 v 是否为时间或时长
+func isTemporal(v Value) bool {
+	return v.Type == ValTime || v.Type == ValDuration
+}
+
+// isTemporalAny 判断 AST 解释器中的值是否为时间或时长
+func isTemporalAny(v any) bool {
+	switch v.(type) {
+	case time.Time, time.Duration:
+		return true
+	}
+	return false
+}
+
+// compareTemporal 比较两个时间的先后或两个时长的长短，返回 -1、0 或 1；
+// 两侧不是同为时间或同为时长时返回 false，由调用方按原有规则处理
+func compareTemporal(l, r Value) (int, bool) {
+	if l.Type == ValTime && r.Type == ValTime {
+		return l.Obj.(time.Time).Compare(r.Obj.(time.Time)), true
+	}
+	if l.Type == ValDuration && r.Type == ValDuration {
+		return cmp.Compare(int64(l.Num), int64(r.Num)), true
+	}
+	return 0, false
+}
+
+// timeEqual 判断两个 ValTime 是否为同一时刻，时区不同的同一时刻相等
+func timeEqual(l, r Value) bool {
+	return l.Obj.(time.Time).Equal(r.Obj.(time.Time))
+}
+
+// addTemporal 计算 l + r：时间加时长（两侧可以互换）为时间，时长相加为时长。其他组合返回 false
+func addTemporal(l, r Value) (Value, bool) {
+	switch {
+	case l.Type == ValTime && r.Type == ValDuration:
+		return Value{Type: ValTime, Obj: l.Obj.(time.Time).Add(time.Duration(r.Num))}, true
+	case l.Type == ValDuration && r.Type == ValTime:
+		return Value{Type: ValTime, Obj: r.Obj.(time.Time).Add(time.Duration(l.Num))}, true
+	case l.Type == ValDuration && r.Type == ValDuration:
+		return Value{Type: ValDuration, Num: l.Num + r.Num}, true
+	}
+	return Value{}, false
+}
+
+// subTemporal 计算 l - r：两个时间之差为时长，时间减时长为时间，时长相减为时长；
+// -d 编译为 0 - d，因此整数 0 减时长为相反的时长。其他组合返回 false
+func subTemporal(l, r Value) (Value, bool) {
+	switch {
+	case l.Type == ValTime && r.Type == ValTime:
+		return Value{Type: ValDuration, Num: uint64(l.Obj.(time.Time).Sub(r.Obj.(time.Time)))}, true
+	case l.Type == ValTime && r.Type == ValDuration:
+		return Value{Type: ValTime, Obj: l.Obj.(time.Time).Add(-time.Duration(r.Num))}, true
+	case l.Type == ValDuration && r.Type == ValDuration:
+		return Value{Type: ValDuration, Num: l.Num - r.Num}, true
+	case l.Type == ValInt && l.Num == 0 && r.Type == ValDuration:
+		return Value{Type: ValDuration, Num: -r.Num}, true
+	}
+	return Value{}, false
+}
@@ -0,0 +1,118 @@
+package uwasa
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTime(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`@2024-01-01T00:00:00Z < @2024-06-01T12:30:00Z`, true, false},
+		{`@2024-01-01T00:00:00Z >= @2024-01-01T00:00:00Z`, true, false},
+		{`@2024-01-01T09:00:00+09:00 == @2024-01-01T00:00:00Z`, true, false},
+		{`@2024-01-01T09:00:00+09:00 != @2024-01-01T00:00:00Z`, false, false},
+		{`@2024-01-02 - @2024-01-01`, 24 * time.Hour, false},
+		{`@2024-01-01T00:00:00.5Z - @2024-01-01T00:00:01Z`, -500 * time.Millisecond, false},
+		{`@2024-03-01`, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), false},
+		{`now - @2024-01-01`, 36 * time.Hour, false},
+		{`now > @2024-01-01 && now < @2024-02-01`, true, false},
+		{`now == @2024-01-02T12:00:00Z`, true, false},
+		{`expires - now`, -2 * time.Hour, false},
+		{`if now >= expires is "expired" else is "valid"`, "expired", false},
+		{`typeof(@2024-01-01)`, "time", false},
+		{`typeof(now - expires)`, "duration", false},
+		{`@name("t") now > @2024-01-01`, true, false},
+		{`@2024-13-01`, nil, true},
+		{`@2024-01-01T25:00:00Z`, nil, true},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{
+			"now":     time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC),
+			"expires": time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+		}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				if !tt.err {
+					t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				}
+				continue
+			}
+			if tt.err {
+				t.Errorf("%s %s: expected compile error", name, tt.input)
+				continue
+			}
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				got, err := engine.ExecuteWithContext(ctx)
+				if err != nil || !reflect.DeepEqual(got, tt.expected) {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`5m`, 5 * time.Minute, false},
+		{`2h30m + 1_000ms`, 2*time.Hour + 30*time.Minute + time.Second, false},
+		{`1.5s - 2s`, -500 * time.Millisecond, false},
+		{`-5m`, -5 * time.Minute, false},
+		{`90m > 1h && 1h == 60m && 59s < 1m`, true, false},
+		{`@2024-01-01 + 36h`, time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), false},
+		{`24h + @2024-01-01 == @2024-01-02`, true, false},
+		{`@2024-01-02T12:00:00Z - 12h`, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{`now - started > 1h`, true, false},
+		{`now - started`, 2 * time.Hour, false},
+		{`now > started + timeout`, true, false},
+		{`timeout <= 30m`, true, false},
+		{`timeout == 30m`, true, false},
+		{`now - 2h == started`, true, false},
+		{`if now - started > sla is "breached" else is "ok"`, "ok", false},
+		{`typeof(30s)`, "duration", false},
+		{`5x`, nil, true},
+		{`1e3s`, nil, true},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{
+			"now":     time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC),
+			"started": time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+			"timeout": 30 * time.Minute,
+			"sla":     4 * time.Hour,
+		}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				if !tt.err {
+					t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				}
+				continue
+			}
+			if tt.err {
+				t.Errorf("%s %s: expected compile error", name, tt.input)
+				continue
+			}
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				got, err := engine.ExecuteWithContext(ctx)
+				if err != nil || !reflect.DeepEqual(got, tt.expected) {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"time"
+)
+
+// typeName 返回 v 在规则中的类型名。宿主传入的 int、int32 与 float32 分别视为 int 与 float，
+// 其余无法识别的宿主值为 "object"
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case int64, int, int32:
+		return "int"
+	case float64, float32:
+		return "float"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "map"
+	case Range:
+		return "range"
+	case *Closure:
+		return "function"
+	case time.Time:
+		return "time"
+	case time.Duration:
+		return "duration"
+	case map[string]struct{}:
+		return "set"
+	case Decimal:
+		return "decimal"
+	case []byte:
+		return "bytes"
+	}
+	return "object"
+}
+
+// typeOf 实现内置函数 typeof(x)
+func typeOf(args ...any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("typeof expects 1 argument, got %d", len(args))
+	}
+	return typeName(args[0]), nil
+}
+
+// typeIs 返回类型判断内置函数 name(x)：x 的类型名为 types 之一时为 true
+func typeIs(name string, types ...string) func(args ...any) (any, error) {
+	return func(args ...any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+		}
+		t := typeName(args[0])
+		for _, want := range types {
+			if t == want {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
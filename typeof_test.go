@@ -0,0 +1,61 @@
+package uwasa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTypeOf(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`typeof(i)`, "int"},
+		{`typeof(n)`, "int"},
+		{`typeof(f)`, "float"},
+		{`typeof(i * 1.0)`, "float"},
+		{`typeof(i + 0.0) == typeof(f * 1)`, true},
+		{`typeof(s)`, "string"},
+		{`typeof(true)`, "bool"},
+		{`typeof(arr)`, "array"},
+		{`typeof(m)`, "map"},
+		{`typeof(missing)`, "nil"},
+		{`typeof(1..3)`, "range"},
+		{`typeof(x -> x)`, "function"},
+		{`typeof(obj)`, "object"},
+		{`typeof(typeof(1))`, "string"},
+		{`if is_string(s) is len(s) else is -1`, int64(2)},
+		{`[is_int(i), is_int(f), is_float(f), is_number(i), is_number(f), is_number(s)]`, []any{true, false, true, true, true, false}},
+		{`[is_bool(false), is_array(arr), is_map(m), is_map(arr), is_nil(missing), is_nil(0)]`, []any{true, true, true, false, true, false}},
+		{`m |> is_map`, true},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{
+			"i": int64(3), "n": 7, "f": 1.5, "s": "ok", "arr": []any{int64(1)}, "m": map[string]any{},
+			"obj": struct{}{},
+		}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`typeof()`, `is_int(1, 2)`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package uwasa
 
 import (
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -135,3 +140,1411 @@ func TestEngineConcurrency(t *testing.T) {
 		<-done
 	}
 }
+
+// backends 返回四种后端以 opts 编译规则的函数；寄存器 VM 另设 UseRegisterVM，NeoVM 总是做常量折叠
+func backends(opts EngineOptions) map[string]func(string) (*Engine, error) {
+	reg := opts
+	reg.UseRegisterVM = true
+	return map[string]func(string) (*Engine, error){
+		"AST":        func(s string) (*Engine, error) { return NewEngineWithOptions(s, opts) },
+		"VM":         func(s string) (*Engine, error) { return NewEngineVMWithOptions(s, opts) },
+		"RegisterVM": func(s string) (*Engine, error) { return NewEngineVMWithOptions(s, reg) },
+		"NeoVM":      func(s string) (*Engine, error) { return NewEngineVMNeoWithOptions(s, opts) },
+	}
+}
+
+// allEngines 以 opts 在四种后端上编译 src，按后端名返回；编译失败的后端记为测试错误并略去
+func allEngines(t *testing.T, src string, opts EngineOptions) map[string]*Engine {
+	t.Helper()
+	engines := map[string]*Engine{}
+	for name, compile := range backends(opts) {
+		engine, err := compile(src)
+		if err != nil {
+			t.Errorf("%s %s: compile error: %v", name, src, err)
+			continue
+		}
+		engines[name] = engine
+	}
+	return engines
+}
+
+func TestShortCircuitSideEffects(t *testing.T) {
+	calls := 0
+	builtins.put("tick", func(args ...any) (any, error) {
+		calls++
+		return true, nil
+	})
+	defer builtins.del("tick")
+
+	tests := []struct {
+		input    string
+		vars     map[string]any
+		expected any
+		b        any // expected value of b afterwards
+		calls    int
+	}{
+		{"a > 1 && (b = 2)", map[string]any{"a": int64(0)}, false, nil, 0},
+		{"a > 1 && tick()", map[string]any{"a": int64(0)}, false, nil, 0},
+		{"a < 1 || (b = tick())", map[string]any{"a": int64(0)}, true, nil, 0},
+		{"x && (b = 1) && tick()", map[string]any{"x": false}, false, nil, 0},
+		{"x || (b = 1) || tick()", map[string]any{"x": false}, true, int64(1), 0},
+		{"a > 1 && (b = 2), tick()", map[string]any{"a": int64(5)}, []any{true, true}, int64(2), 1},
+		{"false && (if a is (b = 1) else is 2)", map[string]any{"a": int64(0)}, false, nil, 0},
+		// The join point of a branch must not be fused with the code that follows it
+		{"(if x || x is a > 1 else is a) || (b = 3)", map[string]any{"a": int64(0), "x": true}, true, int64(3), 0},
+		{"(if x is b else is a) && (b = 4)", map[string]any{"a": int64(0), "x": true, "b": false}, false, false, 0},
+		// A constant left operand still yields a boolean
+		{"5 || y", map[string]any{}, true, nil, 0},
+		{"true && n", map[string]any{}, false, nil, 0},
+		{"b = 5 && y", map[string]any{"y": int64(2)}, true, true, 0},
+		{`0 || "s"`, map[string]any{}, true, nil, 0},
+		{"1 && tick()", map[string]any{}, true, nil, 1},
+		{"nil || tick()", map[string]any{}, true, nil, 1},
+		{`"s" && x`, map[string]any{"x": "t"}, true, nil, 0},
+		{"1 || tick()", map[string]any{}, true, nil, 0},
+		{"(b = 2) && 3", map[string]any{}, true, int64(2), 0},
+	}
+
+	type outcome struct {
+		result any
+		b      any
+		calls  int
+	}
+	run := func(engine *Engine, tt map[string]any) (outcome, error) {
+		vars := make(map[string]any, len(tt))
+		for k, v := range tt {
+			vars[k] = v
+		}
+		calls = 0
+		got, err := engine.Execute(vars)
+		return outcome{got, vars["b"], calls}, err
+	}
+
+	// 各后端在两个优化级别下都须与未优化的 AST 求值器给出相同的结果与副作用，融合不得让短路跳转落进右操作数
+	for _, tt := range tests {
+		ref, err := NewEngineWithOptions(tt.input, EngineOptions{OptimizationLevel: OptNone})
+		if err != nil {
+			t.Errorf("%s: compile error: %v", tt.input, err)
+			continue
+		}
+		want, err := run(ref, tt.vars)
+		if err != nil {
+			t.Errorf("%s: execute error: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(want, outcome{tt.expected, tt.b, tt.calls}) {
+			t.Errorf("AST %s: expected %v, got %v", tt.input, outcome{tt.expected, tt.b, tt.calls}, want)
+		}
+
+		engines := map[string]*Engine{}
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptNone}) {
+			engines[name+"/OptNone"] = engine
+		}
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			engines[name+"/OptBasic"] = engine
+		}
+		for name, engine := range engines {
+			got, err := run(engine, tt.vars)
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %s: expected %v as the AST evaluator gives, got %v", name, tt.input, want, got)
+			}
+		}
+	}
+}
+
+func TestArrays(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`[1, "x", true, [2]]`, []any{int64(1), "x", true, []any{int64(2)}}, false},
+		{`[]`, []any{}, false},
+		{`a[1] + a[2]`, int64(5), false},
+		{`[a[0], "n" + s, "m" + s][2]`, "mq", false},
+		{`[[1, 2], [3]][0][1]`, int64(2), false},
+		{`a[1.0]`, int64(2), false},
+		{`[1, 2] == [1, 2.0]`, true, false},
+		{`a == [1, 2]`, false, false},
+		{`if a[0] == 1 then a[2]`, int64(3), false},
+		{`a[3]`, nil, true},
+		{`a[0 - 1]`, int64(3), false},
+		{`a[-1] + a[-3]`, int64(4), false},
+		{`i = 0 - 2; a[i]`, int64(2), false},
+		{`a[-1] = 9; a[2]`, int64(9), false},
+		{`a[-4]`, nil, true},
+		{`a["0"]`, nil, true},
+		{`s[0] + s[-1]`, "qq", false},
+		{`"名字x"[-2] + "名字x"[i]`, "字名", false},
+		{`s[1]`, nil, true},
+		{`s[-2]`, nil, true},
+		{`s[0] = "x"`, nil, true},
+		{`len(a) + len([]) + len("名字")`, int64(5), false},
+		{`len(a) > 2 && len(a) < 4`, true, false},
+		{`len(1)`, nil, true},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"a": []any{int64(1), int64(2), int64(3)}, "s": "q", "i": int64(0)})
+			if tt.err {
+				if err == nil {
+					t.Errorf("%s %s: expected error, got %v", name, tt.input, got)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+
+		// Index assignment mutates the caller's array in place
+		engine, err := newEngine(`a[1] = a[0] + 10, b = [a[1]], b[0] = "x", b`)
+		if err != nil {
+			t.Errorf("%s: compile error: %v", name, err)
+			continue
+		}
+		arr := []any{int64(1), int64(2)}
+		vars := map[string]any{"a": arr}
+		got, err := engine.Execute(vars)
+		if err != nil {
+			t.Errorf("%s: execute error: %v", name, err)
+			continue
+		}
+		if want := []any{int64(11), []any{"x"}, "x", []any{"x"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+		if arr[1] != int64(11) {
+			t.Errorf("%s: expected a[1]=11 in caller array, got %v", name, arr[1])
+		}
+	}
+}
+
+func TestMaps(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`{"a": 1, "b": {"c": "d"}}`, map[string]any{"a": int64(1), "b": map[string]any{"c": "d"}}, false},
+		{`{}`, map[string]any{}, false},
+		{`{"n": n + 1, k: [n]}`, map[string]any{"n": int64(6), "kk": []any{int64(5)}}, false},
+		{`{"a": 1, "a": 2}["a"]`, int64(2), false},
+		{`m["q"] + 1`, int64(2), false},
+		{`m["missing"]`, nil, false},
+		{`{"a": 1} == {"a": 1.0}`, true, false},
+		{`{"a": 1} == {"a": 1, "b": 2}`, false, false},
+		{`m == {"q": 1}`, true, false},
+		{`{1: "x"}`, nil, true},
+		{`m[0]`, nil, true},
+		{`len(m) + len({})`, int64(1), false},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"m": map[string]any{"q": int64(1)}, "n": int64(5), "k": "kk"})
+			if tt.err {
+				if err == nil {
+					t.Errorf("%s %s: expected error, got %v", name, tt.input, got)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+
+		// A folded constant map must yield an independent copy on every execution
+		engine, err := newEngine(`r = {"a": 1, "b": {"c": 2}}, r["a"] = r["a"] + 1, r`)
+		if err != nil {
+			t.Errorf("%s: compile error: %v", name, err)
+			continue
+		}
+		for i := 0; i < 2; i++ {
+			got, err := engine.Execute(nil)
+			if err != nil {
+				t.Errorf("%s: execute error: %v", name, err)
+				break
+			}
+			res := got.([]any)[2].(map[string]any)
+			if res["a"] != int64(2) {
+				t.Errorf("%s run %d: expected a=2, got %v", name, i, res["a"])
+			}
+			res["b"].(map[string]any)["c"] = "mutated"
+		}
+	}
+}
+
+func TestMapMethods(t *testing.T) {
+	builtins.put("obj", func(args ...any) (any, error) {
+		return map[string]any{"x": int64(1)}, nil
+	})
+	defer builtins.del("obj")
+
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`m.has("q")`, true, false},
+		{`m["n"].has("x")`, true, false},
+		{`obj().has("x") && !obj().has("y")`, true, false},
+		{`obj().get("y", 7) + {"a": 1}.get("a")`, int64(8), false},
+		{`(m).get(k)`, int64(1), false},
+		{`m.del("q").has("q")`, false, false},
+		{`m.set("z", 3).set("w", 4).get("z")`, int64(3), false},
+		{`if m.get("q") == 1 then m["n"].get("x")`, true, false},
+		{`s.has("x")`, nil, true},
+		{`m.nope(1)`, nil, true},
+		{`m.has()`, nil, true},
+		{`m.get(1)`, nil, true},
+	}
+
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			vars := map[string]any{"m": map[string]any{"q": int64(1), "n": map[string]any{"x": true}}, "k": "q", "s": "x"}
+			got, err := engine.Execute(vars)
+			if tt.err {
+				if err == nil {
+					t.Errorf("%s %s: expected error, got %v", name, tt.input, got)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestAssignmentInCondition(t *testing.T) {
+	builtins.put("compute", func(args ...any) (any, error) {
+		return int64(5), nil
+	})
+	defer builtins.del("compute")
+
+	tests := []struct {
+		input    string
+		expected any
+		x        any // expected value of x afterwards
+	}{
+		{`if (x = compute()) > 0 is x * 2 else is 0`, int64(10), int64(5)},
+		{`if (x = a - 3) is "set" else is "unset"`, "set", int64(0)},
+		{`(x = a) > 2 && x < 5`, true, int64(3)},
+		{`b + (x = a)`, int64(13), int64(3)},
+		{`x = y = a`, int64(3), int64(3)},
+		{`(x) = 1`, int64(1), int64(1)},
+		{`if x = a > 1 is x else is 0`, true, true},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{"a": int64(3), "b": int64(10)}
+			got, err := engine.Execute(vars)
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if got != tt.expected || vars["x"] != tt.x {
+				t.Errorf("%s %s: expected %v with x=%v, got %v with x=%v", name, tt.input, tt.expected, tt.x, got, vars["x"])
+			}
+		}
+
+		// Operations that simplify away at compile time still do not yield an assignable target
+		for _, input := range []string{`x * 1 = 5`, `x + 0 = 5`, `true && x = 5`, `-x = 1`, `compute() = 1`, `false && (x * 1 = 2)`} {
+			if _, err := newEngine(input); err == nil {
+				t.Errorf("%s %s: expected compile error", name, input)
+			}
+		}
+	}
+}
+
+func TestInOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`x in ["a", "b", "c"]`, true, false},
+		{`x in ["b", "c"]`, false, false},
+		{`x in ["a", "b", "c", "d"] && n in [1, 2, 3]`, true, false},
+		{`n in [1.0, 5]`, true, false},
+		{`"key" in m`, true, false},
+		{`"nope" in m`, false, false},
+		{`x in tags`, true, false},
+		{`"ell" in "hello"`, true, false},
+		{`x in "abc"`, true, false},
+		{`1 + 1 in [2]`, true, false},
+		{`if x in m is 1 else is 2`, int64(2), false},
+		{`!(x in tags)`, false, false},
+		{`n in m`, nil, true},
+		{`n in "abc"`, nil, true},
+		{`x in n`, nil, true},
+	}
+
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			vars := map[string]any{"x": "a", "n": int64(1), "m": map[string]any{"key": true}, "tags": []any{"a", "z"}}
+			got, err := engine.Execute(vars)
+			if tt.err {
+				if err == nil {
+					t.Errorf("%s %s: expected error, got %v", name, tt.input, got)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestNotEqual(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`a != 1`, true},
+		{`a != 2`, false},
+		{`1 != a`, true},
+		{`s != "x"`, false},
+		{`s != "y"`, true},
+		{`a != 2.0`, false},
+		{`a != b`, true},
+		{`a + 1 != 3`, false},
+		{`if a != 2 is "ne" else is "eq"`, "eq"},
+		{`[1, a] != [1, 2]`, false},
+		{`1 != 2`, true},
+		{`true != false`, true},
+		{`"p" != "p"`, false},
+		{`n != nil_var`, false},
+	}
+
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			got, err := engine.Execute(map[string]any{"a": int64(2), "b": int64(3), "s": "x", "n": nil})
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		err      bool
+	}{
+		{`flags & 4`, int64(4), false},
+		{`flags & 8`, int64(0), false},
+		{`flags | 8`, int64(15), false},
+		{`flags ^ 5`, int64(2), false},
+		{`1 << n`, int64(8), false},
+		{`flags << 2`, int64(28), false},
+		{`neg >> 1`, int64(-8), false},
+		{`flags >> 64`, int64(0), false},
+		{`flags & 4 == 4`, true, false},
+		{`flags & mask | 1`, int64(3), false},
+		{`flags | 8 ^ 1`, int64(15), false},
+		{`1 + 1 << 2`, int64(8), false},
+		{`if flags & 1 == 1 is "odd" else is "even"`, "odd", false},
+		{`12 & 10`, int64(8), false},
+		{`flags & 1.5`, nil, true},
+		{`s | 1`, nil, true},
+		{`1 << -1`, nil, true},
+		{`flags >> neg`, nil, true},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				if !tt.err {
+					t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				}
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"flags": int64(7), "mask": int64(2), "n": int64(3), "neg": int64(-15), "s": "x"})
+			if tt.err {
+				if err == nil {
+					t.Errorf("%s %s: expected error, got %v", name, tt.input, got)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestSignedArithmetic(t *testing.T) {
+	// 整数除法与取模按有符号数计算，向零截断，余数与被除数同号；常量折叠与运行期结果一致
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`-7 / 2`, int64(-3)},
+		{`7 / -2`, int64(-3)},
+		{`-7 / -2`, int64(3)},
+		{`-7 % 2`, int64(-1)},
+		{`7 % -3`, int64(1)},
+		{`-7 % -3`, int64(-1)},
+		{`(0 - 9) / 3 + 1`, int64(-2)},
+		{`(a - 10) / 2`, int64(-3)},
+		{`(a - 10) % 2`, int64(-1)},
+		{`b / a`, int64(-2)},
+		{`b % a`, int64(-1)},
+		{`a / b`, int64(0)},
+		{`a % b`, int64(3)},
+		{`a / neg3`, int64(-1)},
+		{`a % neg3`, int64(0)},
+		{`let x = -7 => x / 2`, int64(-3)},
+		{`let x = -7 => x % 2`, int64(-1)},
+	}
+
+	for _, level := range []OptimizationLevel{OptNone, OptBasic} {
+		for name, newEngine := range backends(EngineOptions{OptimizationLevel: level}) {
+			for _, tt := range tests {
+				engine, err := newEngine(tt.input)
+				if err != nil {
+					t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+					continue
+				}
+				got, err := engine.Execute(map[string]any{"a": int64(3), "b": int64(-7), "neg3": int64(-3)})
+				if err != nil || got != tt.expected {
+					t.Errorf("%s/%d %s: expected %v, got %v (%v)", name, level, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+}
+
+func TestChainedComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`10 <= x <= 20`, true},
+		{`10 <= y <= 20`, false},
+		{`10 < x < 15`, false},
+		{`lo <= x < hi`, true},
+		{`lo < y`, true},
+		{`0 < lo < x <= 15 < hi`, true},
+		{`20 > x > 10`, true},
+		{`1 < 2 < 3`, true},
+		{`3 > 2 > 5`, false},
+		{`if 10 <= x <= 20 is "in" else is "out"`, "in"},
+		{`(1 < 2) == true`, true},
+		{`10 <= x <= 20 && y > 20`, true},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"x": int64(15), "y": int64(25), "lo": int64(5), "hi": 30.5})
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+		for _, bad := range []string{`1 < x + 1 < 20`, `0 < f(x) < 2`, `0 < m[1] < 2`} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected error for non-simple middle operand", name, bad)
+			}
+		}
+	}
+}
+
+func TestElif(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`if score >= 90 is "A" elif score >= 80 is "B" elif score >= 70 is "C" else is "D"`, "B"},
+		{`if score >= 90 is "A" elif score >= 85 is "B"`, nil},
+		{`if score >= 90 is "A" elif score >= 80 is "B" else if score >= 70 is "C" else is "D"`, "B"},
+		{`if score >= 90 is "A" else if score >= 85 is "B" elif score >= 60 is "C" else is "D"`, "C"},
+		{`if true is 1 elif score > 0 is 2 else is 3`, int64(1)},
+		{`if false is 1 elif true is 2 elif score > 0 is 3 else is 4`, int64(2)},
+		{`if false is 1 elif false is 2 else is 3`, int64(3)},
+	}
+
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			got, err := engine.Execute(map[string]any{"score": int64(82)})
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`match code { 1 => "a", 2 => "b", _ => "c" }`, "b"},
+		{`match code { 1 => "a", 3 => "b", _ => "c" }`, "c"},
+		{`match code { 1 => "a", 3 => "b" }`, nil},
+		{`match code { 1 => "a", 2 => "b", }`, "b"},
+		{`match code { _ => "only" }`, "only"},
+		{`match name { "bob" => 1, "alice" => 2, _ => 0 }`, int64(2)},
+		{`match code { limit - 1 => "prev", limit => "eq", _ => "other" }`, "prev"},
+		{`match code { 0 => "z", 1 => "o", 2 => "t", 3 => "th", _ => "m" }`, "t"},
+		{`match code { 2 => hits = hits + 1, _ => 0 }`, int64(6)},
+		{`(match code { 2 => 10, _ => 0 }) + 1`, int64(11)},
+		{`match code { 2 => match name { "alice" => "nested", _ => "n" }, _ => "x" }`, "nested"},
+		// 被匹配的值不是变量时只求值一次
+		{`match code + 1 { 1 => 2 }`, nil},
+		{`match len(name) { 4 => "four", 5 => "five", _ => "?" }`, "five"},
+		{`match m?.x { 1 => "one", _ => "?" }`, "one"},
+		{`match m.get("x") { 1 => "one", _ => "?" }`, "one"},
+		{`match m["x"] + code { 3 => "three", _ => "?" }`, "three"},
+		{`match 1 + 1 { 2 => "two", _ => "?" }`, "two"},
+		{`match (hits = hits + 1) { 7 => "b", 8 => "c", _ => hits }`, int64(6)},
+		{`match len(name) { 5 => match code * 2 { 4 => "both", _ => "n" }, _ => "x" }`, "both"},
+		{`let k = 2 => match k * 2 { 4 => match k { 2 => "k" }, _ => "x" }`, "k"},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"code": int64(2), "name": "alice", "limit": int64(3), "hits": int64(5), "m": map[string]any{"x": int64(1)}})
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+		for _, bad := range []string{
+			`match code { }`,
+			`match len(name) { }`,
+			`match { 1 => 2 }`,
+			`match code { _ => 1, 2 => 3 }`,
+			`match code { 1 2 }`,
+			`match code { 1 => 2 3 => 4 }`,
+		} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
+
+func TestLet(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`let tmp = a * 2 => tmp + 1`, int64(7)},
+		{`let t = 3 => t * a`, int64(9)},
+		{`let a = a + 1 => let a = a * 10 => a + 1`, int64(41)},
+		{`(let x = a => x) + (let y = a * 2 => y)`, int64(9)},
+		{`let s = "x" => s + "y"`, "xy"},
+		{`let c = a => match c { 1 => "one", 2 => "two", 3 => "three", _ => "many" }`, "three"},
+		{`let c = a => c == 1 || c == 3 || c == 5`, true},
+		{`let n = hits + a => if n > 5 then hits = n`, int64(8)},
+		{`let n = a => n, n`, nil},
+	}
+
+	deep := strings.Repeat("let v = a => ", maxLetBindings+1) + "v"
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{"a": int64(3), "hits": int64(5)}
+			got, err := engine.Execute(vars)
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if tt.expected == nil {
+				// 元组的逗号结束 let 的作用域，第二个元素中的 n 是未定义的全局变量
+				if s, ok := got.([]any); !ok || len(s) != 2 || s[0] != int64(3) || s[1] != nil {
+					t.Errorf("%s %s: expected [3 <nil>], got %v", name, tt.input, got)
+				}
+				continue
+			}
+			if got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+			if _, ok := vars["tmp"]; ok || vars["a"] != int64(3) {
+				t.Errorf("%s %s: let binding leaked into vars: %v", name, tt.input, vars)
+			}
+		}
+		for _, bad := range []string{
+			`let t = a => t = 1`,
+			`let t = a`,
+			`let 1 = a => 2`,
+			deep,
+		} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`fn double(x) => x * 2; double(a) + 1`, int64(7)},
+		{`fn add(x, y) => x + y; fn twice(x) => add(x, x); twice(a) + add(1, 2)`, int64(9)},
+		{`fn k() => 42; k()`, int64(42)},
+		{`fn bump() => hits = hits + 1; bump() + bump()`, int64(13)},
+		{`fn f(x) => let y = x * 10 => y + x; let z = a => f(z) + z`, int64(36)},
+		{`fn cat(x) => concat("v", x); cat(a)`, "v3"},
+		{`fn sel(c) => match c { 1 => "one", 3 => "three", _ => "?" }; sel(a)`, "three"},
+		{`fn g(x) => x; a + g(a * 2) * g(3)`, int64(21)},
+		{`fn pick(x) => if x > 2 is "big" else is "small"; pick(a), pick(1)`, []any{"big", "small"}},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{"a": int64(3), "hits": int64(5)}
+			got, err := engine.Execute(vars)
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+			if _, ok := vars["x"]; ok || vars["a"] != int64(3) {
+				t.Errorf("%s %s: parameter leaked into vars: %v", name, tt.input, vars)
+			}
+		}
+
+		engine, err := newEngine(`fn d(x) => 10 / x; d(a - 3)`)
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", name, err)
+		}
+		_, err = engine.Execute(map[string]any{"a": int64(3)})
+		if err == nil || !strings.Contains(err.Error(), "division by zero") {
+			t.Errorf("%s: expected division by zero, got %v", name, err)
+		}
+		var re *RuntimeError
+		if name != "AST" && (!errors.As(err, &re) || re.Function != "d") {
+			t.Errorf("%s: expected RuntimeError in fn d, got %v", name, err)
+		}
+
+		// 每个调用处都计入一次函数体的开销
+		once, _ := newEngine(`fn h(x) => x / 3 + x * x; h(a)`)
+		twice, _ := newEngine(`fn h(x) => x / 3 + x * x; h(a) + h(b)`)
+		if c1, c2 := once.EstimatedCost(), twice.EstimatedCost(); c2 <= 2*(c1-costGlobal-costCall) {
+			t.Errorf("%s: expected both calls to count the function body, got %d and %d", name, c1, c2)
+		}
+
+		// 函数体只能看到更早定义的函数，前向引用按未知的内置函数处理，调用自身在编译期报错
+		engine, err = newEngine(`fn f(x) => g(x); fn g(x) => x; f(1)`)
+		if err == nil {
+			_, err = engine.Execute(map[string]any{})
+		}
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("%s: expected unknown function error, got %v", name, err)
+		}
+		for _, in := range []string{
+			`fn f(x) => if x > 0 is f(x - 1) else is 0; f(3)`,
+			`fn f(x) => x |> f; f(1)`,
+			`fn f(xs) => map(xs, x -> f(x)); f([1])`,
+		} {
+			if _, err := newEngine(in); err == nil || !strings.Contains(err.Error(), "fn f is not defined yet; recursion is not supported") {
+				t.Errorf("%s %s: expected recursion error, got %v", name, in, err)
+			}
+		}
+
+		for _, bad := range []string{
+			`fn f(x, y) => x; f(1)`,
+			`fn f(x) => x; fn f(y) => y; f(1)`,
+			`fn concat(x) => x; 1`,
+			`fn f(x, x) => x; f(1, 2)`,
+			`fn f(x) => x = 1; f(2)`,
+			`fn f(x) => x f(1)`,
+			`fn f(x) => x;`,
+		} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
+
+func TestHostSlices(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`"vip" in tags`, true},
+		{`len(tags) + len(ids)`, int64(5)},
+		{`ids[1] * 2 + scores[0]`, 4.5},
+		{`tags == ["vip", "new"]`, true},
+		{`flags[1] && rows[0]["name"] == "a"`, true},
+		{`typeof(ids)`, "array"},
+		{`len(filter(scores, s -> s > 1))`, int64(1)},
+		{`concat(tags, ids)`, "[vip new][1 2 3]"},
+		{`concat([set("b", "a"), 1.5])`, "[{a, b} 1.5]"},
+	}
+	vars := func() map[string]any {
+		return map[string]any{
+			"tags":   []string{"vip", "new"},
+			"ids":    []int{1, 2, 3},
+			"scores": []float64{0.5, 2},
+			"flags":  []bool{false, true},
+			"rows":   []map[string]any{{"name": "a"}},
+		}
+	}
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				if got, err := engine.ExecuteWithContext(ctx); err != nil || got != tt.expected {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+	if !EqualAny([]string{"a", "b"}, []any{"a", "b"}) || EqualAny([]int64{1}, []any{int64(2)}) {
+		t.Error("EqualAny should compare host slices element by element")
+	}
+}
+
+// countingContext 记录每个变量被读取的次数
+type countingContext struct {
+	MapContext
+	gets map[string]int
+}
+
+func (c *countingContext) Get(name string) (any, bool) {
+	c.gets[name]++
+	return c.MapContext.Get(name)
+}
+
+func TestLazyGlobalReads(t *testing.T) {
+	tests := []struct {
+		input string
+		reads []string // 本次执行读取过的变量
+	}{
+		{`flag && expensive > 0 && expensive < 9`, []string{"flag"}},
+		{`!flag || expensive > 0 || expensive < 9`, []string{"flag"}},
+		{`if flag is expensive + expensive else is 0`, []string{"flag"}},
+		{`try(n, expensive + expensive)`, []string{"n"}},
+		{`[x -> expensive + expensive, n + n]`, []string{"n"}},
+		{`n + n > 0 && (flag && n > 1)`, []string{"flag", "n"}},
+		{`expensive + expensive`, []string{"expensive"}},
+	}
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			ctx := &countingContext{MapContext: MapContext{vars: map[string]any{"flag": false, "expensive": int64(5), "n": int64(1)}}, gets: map[string]int{}}
+			if _, err := engine.ExecuteWithContext(ctx); err != nil {
+				t.Errorf("%s %s: %v", name, tt.input, err)
+			}
+			if got := slices.Sorted(maps.Keys(ctx.gets)); !slices.Equal(got, tt.reads) {
+				t.Errorf("%s %s: expected reads of %v, got %v", name, tt.input, tt.reads, ctx.gets)
+			}
+		}
+	}
+}
+
+func TestEqualFold(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`host ==* "api.example.com"`, true},
+		{`host == "api.example.com"`, false},
+		{`"Straße" ==* "STRASSE"`, false},
+		{`"Σίσυφος" ==* "ΣΊΣΥΦΟΣ"`, true},
+		{`"GET" ==* "get" && "a" ==* "b" == false`, true},
+		{`method ==* "post" || method ==* "put"`, true},
+		{`n ==* 2`, true},
+		{`n ==* "2"`, false},
+		{`nil ==* nil`, true},
+		{`if header ==* "GZIP" is "compressed" else is "plain"`, "compressed"},
+		{`[h for h in hosts if h ==* "B.example"]`, []any{"b.EXAMPLE"}},
+		{`!(host ==* "other")`, true},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{
+			"host": "API.Example.com", "method": "PUT", "n": 2.0, "header": "gzip",
+			"hosts": []any{"a.example", "b.EXAMPLE"},
+		}
+	}
+	for _, tt := range tests {
+		for name, engine := range allEngines(t, tt.input, EngineOptions{OptimizationLevel: OptBasic}) {
+			for _, ctx := range []Context{&MapContext{vars: vars()}, &benchContext{vars: vars()}} {
+				got, err := engine.ExecuteWithContext(ctx)
+				if err != nil || !reflect.DeepEqual(got, tt.expected) {
+					t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+				}
+			}
+		}
+	}
+}
+
+func TestPipe(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`name |> len`, int64(5)},
+		{`name |> concat("!") |> len > 5`, true},
+		{`name |> concat(" ", a) |> concat("[", "]")`, "alice 3[]"},
+		{`a * 2 + 1 |> concat("#")`, "7#"},
+		{`items |> filter(x -> x > a) |> len`, int64(2)},
+		{`fn wrap(s, l, r) => concat(l, s, r); name |> wrap("<", ">")`, "<alice>"},
+		{`fn sq(x) => x * x; let t = a => t |> sq |> sq`, int64(81)},
+		{`n = name |> len, n`, []any{int64(5), int64(5)}},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{"a": int64(3), "name": "alice", "items": []any{int64(1), int64(3), int64(5), int64(6)}}
+			got, err := engine.Execute(vars)
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+
+		for _, bad := range []string{
+			`a |> 1`,
+			`a |> (len)`,
+			`a |> len(`,
+			`fn f(x, y) => x; a |> f`,
+			`fn f(x) => x; a |> f(1)`,
+		} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
+
+func TestOptionalChaining(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`user?.address?.city`, "Mitakihara"},
+		{`user?.name`, "madoka"},
+		{`user?.phone`, nil},
+		{`user?.phone?.area`, nil},
+		{`user?.name?.first`, nil},
+		{`guest?.address?.city`, nil},
+		{`missing?.address`, nil},
+		{`1?.x`, nil},
+		{`{"a": {"b": 2}}?.a?.b * 10`, int64(20)},
+		{`users[1]?.address?.city == "Kazamino"`, true},
+		{`if user?.address?.city == "Mitakihara" is "local" else is "visitor"`, "local"},
+		{`if guest?.address?.city == "Mitakihara" is "local" else is "visitor"`, "visitor"},
+		{`concat(guest?.name, "|", user?.name)`, "<nil>|madoka"},
+		{`let u = user => u?.address?.zip + 1`, int64(101)},
+		{`fn city(u) => u?.address?.city; [city(user), city(guest)]`, []any{"Mitakihara", nil}},
+		{`user?.address.get("city")`, "Mitakihara"},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{
+				"user":  map[string]any{"name": "madoka", "address": map[string]any{"city": "Mitakihara", "zip": int64(100)}},
+				"guest": "anonymous",
+				"users": []any{nil, map[string]any{"address": map[string]any{"city": "Kazamino"}}},
+			}
+			got, err := engine.Execute(vars)
+			if err != nil {
+				t.Errorf("%s %s: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+
+		for _, bad := range []string{`user?.`, `user?.1`, `user?.name = "x"`} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`a = 1; b = a + 2; a + b`, int64(4)},
+		{"total = price * qty\ndiscount = if total > 100 is 0.1 else is 0\ntotal - total * discount", 135.0},
+		{"let t = price * qty\nt + 1", int64(151)},
+		{`let t = price * qty; let u = t * 2; u - t`, int64(150)},
+		{"let k = 2\nfilter([1, 2, 3], x -> x >= k)", []any{int64(2), int64(3)}},
+		{"x = 1\n-x", int64(-1)},
+		{"x = [1, 2]\n[x[1]]", []any{int64(2)}},
+		{"x = qty\n(x)", int64(5)},
+		{"price\n  * 2 +\n  1", int64(61)},
+		{"(price\n-1)", int64(29)},
+		{`x = 5;`, int64(5)},
+		{`1; "a"; price`, int64(30)},
+		{"fn d(x) => x * 2\nd(qty)", int64(10)},
+		{`fn d(x) => x * 2; n = d(qty); n, n + 1`, []any{int64(10), int64(11)}},
+		{"let n = qty => n * 2, qty\n", []any{int64(10), int64(5)}},
+		{`c = if qty > 1 then qty = 0; [c, qty]`, []any{int64(0), int64(0)}},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %q: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"price": int64(30), "qty": int64(5)})
+			if err != nil {
+				t.Errorf("%s %q: execute error: %v", name, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %q: expected %v, got %v", name, tt.input, tt.expected, got)
+			}
+		}
+
+		for _, bad := range []string{`a, b; c`, `let t = 1`, `let t = 1;`, `let t = qty; t, 2`, `let t = 1; t = 2`, `a b`, `;`, `a;; b`, `fn d(x) => x d(1)`} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %q: expected error", name, bad)
+			}
+		}
+	}
+}
+
+func TestArraySpread(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`a + b`, []any{int64(1), int64(2), int64(3)}},
+		{`a + [4, x]`, []any{int64(1), int64(2), int64(4), int64(5)}},
+		{`[1, 2] + [3] + []`, []any{int64(1), int64(2), int64(3)}},
+		{`[x, x + 1] + [0]`, []any{int64(5), int64(6), int64(0)}},
+		{`[...a, 4]`, []any{int64(1), int64(2), int64(4)}},
+		{`[0, ...a, ...b, x]`, []any{int64(0), int64(1), int64(2), int64(3), int64(5)}},
+		{`[...[], ...a]`, []any{int64(1), int64(2)}},
+		{`[...[x, 1], 2]`, []any{int64(5), int64(1), int64(2)}},
+		{`len([...a, ...a + b])`, int64(5)},
+		{`3 in [...a, ...b]`, true},
+		{`let c = [...a] => [c == a, len(a + c)]`, []any{true, int64(4)}},
+		{`[...filter(a + b, v -> v > 1)]`, []any{int64(2), int64(3)}},
+		{`[..."ab"]`, nil},
+		{`[...x]`, nil},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{"a": []any{int64(1), int64(2)}, "b": []any{int64(3)}, "x": int64(5)}
+			got, err := engine.Execute(vars)
+			if tt.expected == nil {
+				if err == nil || !strings.Contains(err.Error(), "spread expects an array") {
+					t.Errorf("%s %s: expected spread error, got %v (%v)", name, tt.input, got, err)
+				}
+				continue
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+			// 拼接与展开都生成新数组，不修改参与运算的数组
+			if len(vars["a"].([]any)) != 2 || len(vars["b"].([]any)) != 1 {
+				t.Errorf("%s %s: operands modified: %v", name, tt.input, vars)
+			}
+		}
+		for _, bad := range []string{`...a`, `f(...a)`, `[...]`, `[1, ...]`} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected compile error", name, bad)
+			}
+		}
+	}
+}
+
+func TestDestructure(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+		vars     map[string]any // 执行后上下文中应有的变量
+	}{
+		{`{name, age} = user => concat(name, age)`, "bob30", map[string]any{"name": "bob", "age": int64(30)}},
+		{`{name, age} = user; concat(name, ":", age)`, "bob:30", map[string]any{"name": "bob"}},
+		{`{name} = user`, map[string]any{"name": "bob", "age": int64(30)}, map[string]any{"name": "bob"}},
+		{`{name, missing} = user => missing == nil`, true, map[string]any{"missing": nil}},
+		{`{name} = x => name`, nil, map[string]any{"name": nil}},
+		{`{a, b} = {"a": x, "b": x + 1} => a * b`, int64(30), map[string]any{"a": int64(5), "b": int64(6)}},
+		{`let u = user => {name} = u => name`, "bob", map[string]any{"name": "bob"}},
+		{`if x > 0 is ({age} = user => age) else is 0`, int64(30), map[string]any{"age": int64(30)}},
+		{`{x} = {"x": x + 1}; {x} = {"x": x + 1}; x`, int64(7), map[string]any{"x": int64(7)}},
+		{`let k = 1 => filter([user, x], u -> ({age} = u => age != nil))`, []any{map[string]any{"name": "bob", "age": int64(30)}}, map[string]any{"age": nil}},
+		{`fn years(u) => {age} = u => age; years(user) + 1`, int64(31), map[string]any{"age": int64(30)}},
+		{`{"k": x}["k"]`, int64(5), nil},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{"user": map[string]any{"name": "bob", "age": int64(30)}, "x": int64(5)}
+			got, err := engine.Execute(vars)
+			if err != nil || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+			for k, v := range tt.vars {
+				if got, ok := vars[k]; !ok || !reflect.DeepEqual(got, v) {
+					t.Errorf("%s %s: expected %s = %v, got %v", name, tt.input, k, v, got)
+				}
+			}
+		}
+		for _, bad := range []string{`{a, a} = user`, `let a = 1 => {a} = user => a`, `{a, 1} = user`, `{a, b}`, `{a} = `} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected compile error", name, bad)
+			}
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`score { amount > 1000: 10, country != "JP": 20, vip: -5 }`, int64(25)},
+		{`score { amount > 1000: 10, vip: -5 }`, int64(5)},
+		{`score { amount > 5000: 10, vip: -5 }`, int64(-5)},
+		{`score { amount > 1000: 0.5, vip: 1 }`, 1.5},
+		{`score { amount > 5000: 0.5 }`, 0.0},
+		{`score {}`, int64(0)},
+		{`score { true: 3, amount > 1000: 4, false: 100 }`, int64(7)},
+		{`score { true: 3, 1 > 2: 4 }`, int64(3)},
+		{`score { tags: 1, missing: 2 }`, int64(1)},
+		{`score { amount > 1000: 30, vip: 50 } >= 50`, true},
+		{`if score { country == "CN": 60, amount > 1000: 30 } > 50 is "review" else is "pass"`, "review"},
+		{`score { score > 1: 2 }`, int64(2)},
+		{`score + 1`, int64(4)},
+		{"score\n{a} = m; a", int64(7)},
+		{`let s = score { vip: 5 } => s * 2`, int64(10)},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"amount": int64(2000), "country": "CN", "vip": true, "tags": []any{}, "score": int64(3), "m": map[string]any{"a": int64(7)}}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`score { vip: x }`, `score { vip 1 }`, `score { vip: 1 amount: 2 }`, `score { vip: "1" }`} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected compile error", name, bad)
+			}
+		}
+	}
+}
+
+func TestStringOrdering(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`"b" > "a"`, true},
+		{`"a" < "b"`, true},
+		{`"abc" >= "abd"`, false},
+		{`"abc" <= "abc"`, true},
+		{`"Z" < "a"`, true},
+		{`name > "m"`, true},
+		{`name < "m"`, false},
+		{`name >= "mami"`, true},
+		{`name <= other`, false},
+		{`other < name`, true},
+		{`"a" < name < "z"`, true},
+		{`if day >= "2026-01-01" && day < "2026-02-01" is "jan" else is "other"`, "jan"},
+		{`filter(["pear", "apple", "fig"], s -> s < "g")`, []any{"apple", "fig"}},
+		{`n > 1`, true},
+		{`n < 2.5`, true},
+	}
+
+	engines := backends(EngineOptions{OptimizationLevel: OptBasic})
+	engines["Recompiler"] = func(s string) (*Engine, error) {
+		return NewEngineVMWithOptions(s, EngineOptions{OptimizationLevel: OptBasic, UseRecompiler: true})
+	}
+	vars := func() map[string]any {
+		return map[string]any{"name": "sayaka", "other": "kyoko", "day": "2026-01-15", "n": int64(2)}
+	}
+	for name, newEngine := range engines {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+	}
+}
+
+func TestDefined(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`defined(name)`, true},
+		{`defined(nothing)`, true},
+		{`defined(missing)`, false},
+		{`nothing == nil && missing == nil`, true},
+		{`defined(nothing) && !defined(missing)`, true},
+		{`if defined(discount) is price - discount else is price`, int64(100)},
+		{`missing |> defined`, false},
+		{`name |> defined`, true},
+		{`missing = 1; defined(missing)`, true},
+		{`let missing = nil => defined(missing)`, true},
+		{`let v = 1 => v |> defined`, true},
+		{`fn has(missing) => defined(missing); has(nil)`, true},
+		{`filter([1, 2], x -> defined(x) && !defined(missing)) |> len`, int64(2)},
+		{`defined(missing) == defined(missing)`, true},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			vars := map[string]any{"name": "kyoko", "nothing": nil, "price": int64(100)}
+			got, err := engine.Execute(vars)
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+			// 自定义 Context 经过各 VM 的通用执行路径
+			got, err = engine.ExecuteWithContext(&benchContext{vars: map[string]any{"name": "kyoko", "nothing": nil, "price": int64(100)}})
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s (context): expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		for _, bad := range []string{`defined("name")`, `defined(name + 1)`, `defined(a, b)`, `defined()`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(map[string]any{"name": "kyoko"})
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
+
+func TestTry(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`try(a % b, 0)`, int64(0)},
+		{`try(a / c, 0)`, int64(5)},
+		{`try(a % b, a / c)`, int64(5)},
+		{`try(5, a % b)`, int64(5)},
+		{`try(try(a % b, a % b), 7)`, int64(7)},
+		{`1 + try(a % b + 1, 2) * 3`, int64(7)},
+		{`try(arr[5], "none")`, "none"},
+		{`try(a % b, nil) == nil`, true},
+		{`let k = 3 => try(a % b, k)`, int64(3)},
+		{`fn rem(x, y) => x % y; try(rem(a, b), -1) + rem(a, c)`, int64(-1)},
+		{`filter([0, 1, 2], v -> try(a % v, -1) >= 0) |> len`, int64(2)},
+		{`if try(a % b > 1, false) is "big" else is "small"`, "small"},
+		{`try(x = a % b, 1); defined(x)`, false},
+		{`try(a % b + len(s), 0) + len(s)`, int64(1)},
+		// 常量除数为 0 时各后端都在运行期出错，NeoVM 不在折叠时报告编译错误
+		{`try(1/0, 7)`, int64(7)},
+		{`try(a / 0, 7) + try(a % 0, 1)`, int64(8)},
+		{`try(if a < 1 is 1 / 0 else is 2, "x")`, int64(2)},
+		{`try(if a > 1 is 1 / 0 else is 2, "x")`, "x"},
+		// 除数是值为 0 的全局变量时，NeoVM 融合的除法指令同样出错
+		{`try(10 / b, 1)`, int64(1)},
+		{`try(10 / z, 1)`, int64(1)},
+		{`try(a / b, 1) + try(2.5 / z, 1)`, int64(2)},
+	}
+
+	vars := func() map[string]any {
+		return map[string]any{"a": int64(10), "b": int64(0), "c": int64(2), "z": 0.0, "s": "x", "arr": []any{int64(1)}}
+	}
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(vars())
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+			got, err = engine.ExecuteWithContext(&benchContext{vars: vars()})
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s (context): expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		// 备用值出错、实参个数不对以及用在管道之后都是错误
+		for _, bad := range []string{`try(a % b, c % b)`, `try(a % b)`, `try(a, b, c)`, `a % b |> try(0)`, `10 / b`, `2.5 / z`} {
+			engine, err := newEngine(bad)
+			if err == nil {
+				_, err = engine.Execute(vars())
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, bad)
+			}
+		}
+	}
+}
+
+func TestBlock(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`if a > 1 then { b = a * 2; b + 1 }`, int64(7)},
+		{`if a > 10 then { b = 1; 2 }`, nil},
+		{`if a > 1 is { let t = 1; t + a } else is 0`, int64(4)},
+		{`{ let x = a * 2; x + 1 } * 10`, int64(70)},
+		{`{ let x = 1; let y = 2; x + y + a }`, int64(6)},
+		{`let x = 5 => { let x = 1; x } + x`, int64(6)},
+		{`{ let y = 2; y } + { let z = 3; z }`, int64(5)},
+		{`x = { let t = a + 1; t * t }; x`, int64(16)},
+		{`{ 1; 2; "x"; }`, "x"},
+		{"fn sq(v) => { let w = v + 1; w * w }\nsq(a)", int64(16)},
+		{`filter([1, 2, 3], v -> { let w = v * a; w > 5 }) |> len`, int64(2)},
+		{`try({ let z = a % 0; z }, -1)`, int64(-1)},
+		{`{"k": a}["k"]`, int64(3)},
+		// 块内的 let 绑定在块外不可见，x 为未定义的变量
+		{`{ let x = 1; x }; x`, nil},
+	}
+
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		for _, tt := range tests {
+			engine, err := newEngine(tt.input)
+			if err != nil {
+				t.Errorf("%s %s: compile error: %v", name, tt.input, err)
+				continue
+			}
+			got, err := engine.Execute(map[string]any{"a": int64(3)})
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s: expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+			got, err = engine.ExecuteWithContext(&benchContext{vars: map[string]any{"a": int64(3)}})
+			if err != nil || got != tt.expected {
+				t.Errorf("%s %s (context): expected %v, got %v (%v)", name, tt.input, tt.expected, got, err)
+			}
+		}
+		// 块内不能有元组，let 语句之后须有语句，块须闭合
+		for _, bad := range []string{`{ 1, 2 }`, `{ let x = 1 }`, `{ a + 1`} {
+			if _, err := newEngine(bad); err == nil {
+				t.Errorf("%s %s: expected compile error", name, bad)
+			}
+		}
+	}
+}
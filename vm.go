@@ -4,7 +4,6 @@
 package uwasa
 
 import (
-	"bytes"
 	"fmt"
 	"math"
 )
@@ -14,20 +13,44 @@ func RunVM(bc *RenderedBytecode, ctx Context) (any, error) {
 		return nil, nil
 	}
 
+	var stack [64]Value
+	return runVM(bc, ctx, &stack, nil)
+}
+
+// callFrame 把调用处的字节码块、返回地址与栈帧起点打包为一个栈槽，
+// 置于被调函数的 let 槽位之上，RETL 据此返回；不调用函数的规则无需额外的帧存储
+func callFrame(caller any, pc, fp int) Value {
+	return Value{Type: ValNil, Num: uint64(pc)<<32 | uint64(uint32(fp)), Obj: caller}
+}
+
+// tryHandler 是 try 登记的错误处理现场：出错时回到字节码块 bc 的 pc 处求备用值，
+// 栈顶与栈帧起点恢复为登记时的 sp 与 fp；寄存器 VM 只用 fp 记录寄存器窗口的起点
+type tryHandler struct {
+	bc         any
+	pc, sp, fp int
+}
+
+// runVM 在给定的栈上执行字节码；st 非 nil 时参数与字符串暂存区也取自 st
+func runVM(bc *RenderedBytecode, ctx Context, stack *[64]Value, st *RunState) (any, error) {
 	mapCtx, isMapCtx := ctx.(*MapContext)
 	if isMapCtx {
-		return runVMMapped(bc, mapCtx)
+		return runVMMapped(bc, mapCtx, stack, st)
 	}
-	return runVMGeneral(bc, ctx)
+	return runVMGeneral(bc, ctx, stack, st)
 }
 
-func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
-	var stack [64]Value
-	sp := -1
+func runVMMapped(bc *RenderedBytecode, ctx *MapContext, stack *[64]Value, st *RunState) (any, error) {
+	sp := bc.Locals - 1 // 栈底 bc.Locals 个槽位留给 let 绑定
+	fp := 0             // 当前栈帧的起点，函数块中的 let 槽位与参数相对于它寻址
 	pc := 0
 	insts := bc.Instructions
 	consts := bc.Constants
 	nInsts := len(insts)
+	concat := bc.concat // 闭包入口块沿用调用方的计数
+	if concat == nil { concat = newConcatBudget(bc.MaxConcatBytes) }
+	functions := bc.Functions
+	var handlers []tryHandler // try 登记的错误处理现场，最内层在末尾
+	var fault error
 	vars := ctx.vars
 
 	for pc < nInsts {
@@ -37,7 +60,7 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 		switch inst.Op {
 		case OpPush:
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = consts[inst.Arg]
 		case OpPop:
 			sp--
@@ -48,23 +71,22 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			} else if l.Type == ValString && r.Type == ValString {
 				stack[sp] = Value{Type: ValString, Str: l.Str + r.Str}
 			} else {
-				lf, _ := valToFloat64(l)
-				rf, _ := valToFloat64(r)
-				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf + rf)}
+				stack[sp] = l.Add(r)
 			}
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case OpSub:
 			r := stack[sp]; sp--; l := stack[sp]
 			if l.Type == ValInt && r.Type == ValInt {
 				stack[sp] = Value{Type: ValInt, Num: l.Num - r.Num}
 			} else {
-				lf, _ := valToFloat64(l)
-				rf, _ := valToFloat64(r)
-				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf - rf)}
+				stack[sp] = l.Sub(r)
 			}
 		case OpMul:
 			r := stack[sp]; sp--; l := stack[sp]
 			if l.Type == ValInt && r.Type == ValInt {
 				stack[sp] = Value{Type: ValInt, Num: l.Num * r.Num}
+			} else if v, ok, _ := arithDecimal(TokenAsterisk, l, r); ok {
+				stack[sp] = v
 			} else {
 				lf, _ := valToFloat64(l)
 				rf, _ := valToFloat64(r)
@@ -72,10 +94,13 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			}
 		case OpDiv:
 			r := stack[sp]; sp--; l := stack[sp]
-			if r.Type == ValInt && r.Num == 0 { return nil, fmt.Errorf("division by zero") }
-			if r.Type == ValFloat && math.Float64frombits(r.Num) == 0 { return nil, fmt.Errorf("division by zero") }
+			if r.Type == ValInt && r.Num == 0 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("division by zero")); goto unwind }
+			if r.Type == ValFloat && math.Float64frombits(r.Num) == 0 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("division by zero")); goto unwind }
 			if l.Type == ValInt && r.Type == ValInt {
-				stack[sp] = Value{Type: ValInt, Num: l.Num / r.Num}
+				stack[sp] = Value{Type: ValInt, Num: uint64(int64(l.Num) / int64(r.Num))}
+			} else if v, ok, err := arithDecimal(TokenSlash, l, r); ok {
+				if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+				stack[sp] = v
 			} else {
 				lf, _ := valToFloat64(l)
 				rf, _ := valToFloat64(r)
@@ -83,17 +108,24 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			}
 		case OpMod:
 			r := stack[sp]; sp--; l := stack[sp]
-			if r.Type != ValInt { return nil, fmt.Errorf("modulo operator supports only integers") }
-			if r.Num == 0 { return nil, fmt.Errorf("division by zero") }
-			stack[sp] = Value{Type: ValInt, Num: l.Num % r.Num}
+			if r.Type != ValInt || l.Type == ValDecimal { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("modulo operator supports only integers")); goto unwind }
+			if r.Num == 0 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("division by zero")); goto unwind }
+			stack[sp] = Value{Type: ValInt, Num: uint64(int64(l.Num) % int64(r.Num))}
 		case OpEqual:
 			r := stack[sp]; sp--; l := stack[sp]
 			res := false
 			if l.Type == r.Type {
 				switch l.Type {
-				case ValInt, ValFloat, ValBool: res = l.Num == r.Num
+				case ValInt, ValFloat, ValBool, ValDuration: res = l.Num == r.Num
 				case ValString: res = l.Str == r.Str
+				case ValTime: res = timeEqual(l, r)
+				case ValObject: res = objectEqual(l, r)
+				case ValSet: res = setEqual(l.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+				case ValDecimal: res = decimalEqual(l, r)
+				case ValBytes: res = bytesEqual(l, r)
 				case ValNil: res = true
+				case ValArray: res = arrayEqual(l.Obj.([]any), r.Obj.([]any))
+				case ValMap: res = mapEqual(l.Obj.(map[string]any), r.Obj.(map[string]any))
 				}
 			} else {
 				lf, okL := valToFloat64(l); rf, okR := valToFloat64(r)
@@ -105,6 +137,10 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) > int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str > r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c > 0
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				res = lf > rf
@@ -115,6 +151,10 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) < int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str < r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c < 0
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				res = lf < rf
@@ -125,6 +165,10 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) >= int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str >= r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c >= 0
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				res = lf >= rf
@@ -135,6 +179,10 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) <= int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str <= r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c <= 0
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				res = lf <= rf
@@ -149,6 +197,8 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 		case OpNot:
 			l := stack[sp]
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(!isValTruthy(l))}
+		case OpToBool:
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(isValTruthy(stack[sp]))}
 		case OpJump:
 			pc = int(inst.Arg)
 		case OpJumpIfFalse:
@@ -157,10 +207,14 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 		case OpJumpIfTrue:
 			l := stack[sp]; sp--
 			if isValTruthy(l) { pc = int(inst.Arg) }
+		case OpJumpIfFalseOrPop:
+			if isValTruthy(stack[sp]) { sp-- } else { pc = int(inst.Arg) }
+		case OpJumpIfTrueOrPop:
+			if isValTruthy(stack[sp]) { pc = int(inst.Arg) } else { sp-- }
 		case OpGetGlobal:
 			name := consts[inst.Arg].Str
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = FromInterface(vars[name])
 		case OpSetGlobal:
 			name := consts[inst.Arg].Str
@@ -170,26 +224,33 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			nameIdx := inst.Arg & 0xFFFF
 			numArgs := int(inst.Arg >> 16)
 			name := consts[nameIdx].Str
-			args := make([]any, numArgs)
+			args := st.argScratch(nil, numArgs)
 			for i := numArgs - 1; i >= 0; i-- {
 				args[i] = stack[sp].ToInterface(); sp--
 			}
-			if builtin, ok := builtins[name]; ok {
-				res, err := builtin(args...)
-				if err != nil { return nil, err }
+			if builtin, ok := builtins.get(name); ok {
+				res, err := callBuiltin(name, builtin, args)
+				st.release(args)
+				if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 				sp++
-				if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+				if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 				stack[sp] = FromInterface(res)
 			} else {
-				return nil, fmt.Errorf("builtin function not found: %s", name)
+				fault = bc.fault(pc-1, stack, sp, fmt.Errorf("builtin function not found: %s", name))
+				goto unwind
 			}
 		case OpEqualConst:
 			r := consts[inst.Arg]; l := stack[sp]
 			res := false
 			if l.Type == r.Type {
 				switch l.Type {
-				case ValInt, ValFloat, ValBool: res = l.Num == r.Num
+				case ValInt, ValFloat, ValBool, ValDuration: res = l.Num == r.Num
 				case ValString: res = l.Str == r.Str
+				case ValTime: res = timeEqual(l, r)
+				case ValObject: res = objectEqual(l, r)
+				case ValSet: res = setEqual(l.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+				case ValDecimal: res = decimalEqual(l, r)
+				case ValBytes: res = bytesEqual(l, r)
 				case ValNil: res = true
 				}
 			} else {
@@ -203,29 +264,29 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			lv := FromInterface(vars[name])
 			rv := consts[cIdx]
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			if lv.Type == ValInt && rv.Type == ValInt {
 				stack[sp] = Value{Type: ValInt, Num: lv.Num + rv.Num}
 			} else if lv.Type == ValString && rv.Type == ValString {
 				stack[sp] = Value{Type: ValString, Str: lv.Str + rv.Str}
 			} else {
-				lf, _ := valToFloat64(lv); rf, _ := valToFloat64(rv)
-				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf + rf)}
+				stack[sp] = lv.Add(rv)
 			}
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case OpAddGlobalGlobal:
 			g1Idx := inst.Arg >> 16; g2Idx := inst.Arg & 0xFFFF
 			lv := FromInterface(vars[consts[g1Idx].Str])
 			rv := FromInterface(vars[consts[g2Idx].Str])
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			if lv.Type == ValInt && rv.Type == ValInt {
 				stack[sp] = Value{Type: ValInt, Num: lv.Num + rv.Num}
 			} else if lv.Type == ValString && rv.Type == ValString {
 				stack[sp] = Value{Type: ValString, Str: lv.Str + rv.Str}
 			} else {
-				lf, _ := valToFloat64(lv); rf, _ := valToFloat64(rv)
-				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf + rf)}
+				stack[sp] = lv.Add(rv)
 			}
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case OpEqualGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF
 			lv := FromInterface(vars[consts[gIdx].Str])
@@ -233,8 +294,13 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			res := false
 			if lv.Type == r.Type {
 				switch lv.Type {
-				case ValInt, ValFloat, ValBool: res = lv.Num == r.Num
+				case ValInt, ValFloat, ValBool, ValDuration: res = lv.Num == r.Num
 				case ValString: res = lv.Str == r.Str
+				case ValTime: res = timeEqual(lv, r)
+				case ValObject: res = objectEqual(lv, r)
+				case ValSet: res = setEqual(lv.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+				case ValDecimal: res = decimalEqual(lv, r)
+				case ValBytes: res = bytesEqual(lv, r)
 				case ValNil: res = true
 				}
 			} else {
@@ -242,7 +308,7 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 				if okL && okR { res = lf == rf }
 			}
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(res)}
 		case OpGreaterGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF
@@ -251,12 +317,16 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			res := false
 			if lv.Type == ValInt && r.Type == ValInt {
 				res = int64(lv.Num) > int64(r.Num)
+			} else if lv.Type == ValString && r.Type == ValString {
+				res = lv.Str > r.Str
+			} else if c, ok := compareExtended(lv, r); ok {
+				res = c > 0
 			} else {
 				lf, _ := valToFloat64(lv); rf, _ := valToFloat64(r)
 				res = lf > rf
 			}
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(res)}
 		case OpLessGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF
@@ -265,12 +335,16 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			res := false
 			if lv.Type == ValInt && r.Type == ValInt {
 				res = int64(lv.Num) < int64(r.Num)
+			} else if lv.Type == ValString && r.Type == ValString {
+				res = lv.Str < r.Str
+			} else if c, ok := compareExtended(lv, r); ok {
+				res = c < 0
 			} else {
 				lf, _ := valToFloat64(lv); rf, _ := valToFloat64(r)
 				res = lf < rf
 			}
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(res)}
 		case OpFusedCompareGlobalConstJumpIfFalse:
 			gIdx := int(inst.Arg >> 22) & 0x3FF
@@ -281,8 +355,13 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			res := false
 			if lv.Type == r.Type {
 				switch lv.Type {
-				case ValInt, ValFloat, ValBool: res = lv.Num == r.Num
+				case ValInt, ValFloat, ValBool, ValDuration: res = lv.Num == r.Num
 				case ValString: res = lv.Str == r.Str
+				case ValTime: res = timeEqual(lv, r)
+				case ValObject: res = objectEqual(lv, r)
+				case ValSet: res = setEqual(lv.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+				case ValDecimal: res = decimalEqual(lv, r)
+				case ValBytes: res = bytesEqual(lv, r)
 				case ValNil: res = true
 				}
 			} else {
@@ -301,7 +380,7 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 			totalLen := 0
 			var argStringsBuf [8]string
 			var argStrings []string
-			if numArgs <= 8 { argStrings = argStringsBuf[:numArgs] } else { argStrings = make([]string, numArgs) }
+			argStrings = st.strScratch(argStringsBuf[:], numArgs)
 			for i := numArgs - 1; i >= 0; i-- {
 				v := stack[sp]; sp--; var s string
 				switch v.Type {
@@ -310,30 +389,184 @@ func runVMMapped(bc *RenderedBytecode, ctx *MapContext) (any, error) {
 				case ValFloat: s = fmt.Sprintf("%g", math.Float64frombits(v.Num))
 				case ValBool:
 					if v.Num != 0 { s = "true" } else { s = "false" }
-				default: s = fmt.Sprintf("%v", v.ToInterface())
+				default: s = formatAny(v.ToInterface())
 				}
 				argStrings[i] = s; totalLen += len(s)
 			}
-			buf := bufferPool.Get().(*bytes.Buffer)
-			buf.Reset(); buf.Grow(totalLen)
-			for _, s := range argStrings { buf.WriteString(s) }
-			res := buf.String(); bufferPool.Put(buf)
+			if err := concat.charge(totalLen); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			res := st.join(&bufferPool, argStrings, totalLen)
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValString, Str: res}
+		case OpInSetGlobal:
+			gIdx := inst.Arg >> 16; setIdx := inst.Arg & 0xFFFF
+			lv := FromInterface(vars[consts[gIdx].Str])
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(bc.Sets[setIdx].Contains(lv))}
+		case OpJumpTableGlobal:
+			gIdx := inst.Arg >> 16; tIdx := inst.Arg & 0xFFFF
+			pc = int(bc.JumpTables[tIdx].Lookup(FromInterface(vars[consts[gIdx].Str])))
+		case OpMakeArray:
+			n := int(inst.Arg)
+			arr := makeArray(stack[sp-n+1 : sp+1])
+			sp -= n - 1
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = arr
+		case OpIndex:
+			idx := stack[sp]; sp--
+			v, err := stack[sp].Index(idx)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpJumpIfNotMap:
+			if stack[sp].Type != ValMap { stack[sp] = Value{}; pc = int(inst.Arg) }
+		case OpMapGetConst:
+			m, _ := stack[sp].Obj.(map[string]any)
+			stack[sp] = FromInterface(m[consts[inst.Arg].Str])
+		case OpSetIndex:
+			val := stack[sp]; idx := stack[sp-1]; sp -= 2
+			if err := stack[sp].SetIndex(idx, val); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = val
+		case OpMakeMap:
+			n := 2 * int(inst.Arg)
+			m, err := makeMap(stack[sp-n+1 : sp+1])
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			sp -= n - 1
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = m
+		case OpCopyConst:
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = copyConst(consts[inst.Arg])
+		case OpCallMethod:
+			numArgs := int(inst.Arg >> 16)
+			args := st.argScratch(nil, numArgs)
+			for i := numArgs - 1; i >= 0; i-- {
+				args[i] = stack[sp].ToInterface(); sp--
+			}
+			res, err := CallMethodAny(stack[sp].ToInterface(), consts[inst.Arg&0xFFFF].Str, args)
+			st.release(args)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = FromInterface(res)
+		case OpEqualFold:
+			r := stack[sp]; sp--
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(stack[sp].EqualFold(r))}
+		case OpIn:
+			r := stack[sp]; sp--
+			v, err := stack[sp].In(r)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpInRange:
+			v, err := inRange(stack[sp], consts[inst.Arg])
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpMakeRange:
+			r := stack[sp]; sp--
+			v, err := rangeValue(stack[sp], r)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpSpread:
+			r := stack[sp]; sp--
+			v, err := spreadInto(stack[sp], r)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpCast:
+			v, err := castValue(castKind(inst.Arg), stack[sp])
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpIter:
+			items, idx, err := iterStart(iterKind(inst.Arg), stack[sp])
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = items
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = idx
+		case OpIterNext:
+			el, ok := iterNext(stack[sp-2], &stack[sp-1])
+			if !ok { pc = int(inst.Arg); break }
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = el
+		case OpAppend:
+			v := stack[sp]; sp--
+			stack[sp] = appendValue(stack[sp], v)
+		case OpIterEnd:
+			stack[sp-2] = stack[sp]; sp -= 2
+		case OpSlice:
+			v, err := sliceValue(stack[sp], inst.Arg)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpScore:
+			cond := stack[sp]; sp--
+			if isValTruthy(cond) { stack[sp] = stack[sp].Add(consts[inst.Arg]) }
+		case OpDefined:
+			_, ok := vars[consts[inst.Arg].Str]
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(ok)}
+		case OpBitAnd, OpBitOr, OpBitXor, OpShl, OpShr:
+			r := stack[sp]; sp--
+			v, err := stack[sp].Bitwise(TokenBitAnd+TokenType(inst.Op-OpBitAnd), r)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpGetLocal:
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = stack[fp+int(inst.Arg)]
+		case OpSetLocal:
+			stack[fp+int(inst.Arg)] = stack[sp]; sp--
+		case OpCallLocal:
+			fn := functions[inst.Arg]
+			nfp := sp - fn.Params + 1
+			if nfp+fn.Locals >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			sp = nfp + fn.Locals
+			stack[sp] = callFrame(bc, pc, fp)
+			bc, insts, consts, nInsts, pc, fp = fn, fn.Instructions, fn.Constants, len(fn.Instructions), 0, nfp
+		case OpReturnLocal:
+			frame := stack[fp+bc.Locals]
+			stack[fp] = stack[sp]; sp = fp
+			bc, pc, fp = frame.Obj.(*RenderedBytecode), int(frame.Num>>32), int(uint32(frame.Num))
+			insts, consts, nInsts = bc.Instructions, bc.Constants, len(bc.Instructions)
+		case OpMakeClosure:
+			// 捕获值是当前栈帧最低的槽位；闭包持有变量表本身而非可能被回收复用的上下文
+			k := int(inst.Arg >> 16)
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValFunc, Obj: newVMClosure(functions, concat, int(inst.Arg&0xFFFF), append([]Value(nil), stack[fp:fp+k]...), &MapContext{vars: vars})}
+		case OpTry:
+			handlers = append(handlers, tryHandler{bc: bc, pc: int(inst.Arg), sp: sp, fp: fp})
+		case OpEndTry:
+			handlers = handlers[:len(handlers)-1]
+			pc = int(inst.Arg)
+		default:
+			fault = bc.fault(pc-1, stack, sp, fmt.Errorf("unsupported VM opcode: %v", inst.Op))
+			goto unwind
 		}
+		continue
+	unwind:
+		// 出错时回到最内层 try 的现场求备用值，不在 try 中时返回错误
+		if len(handlers) == 0 { return nil, fault }
+		h := handlers[len(handlers)-1]; handlers = handlers[:len(handlers)-1]
+		bc, pc, sp, fp = h.bc.(*RenderedBytecode), h.pc, h.sp, h.fp
+		insts, consts, nInsts = bc.Instructions, bc.Constants, len(bc.Instructions)
 	}
+	if bc.ResultCount > 1 { return collectTuple(stack[:sp+1], bc.ResultCount), nil }
 	if sp < 0 { return nil, nil }
 	return stack[sp].ToInterface(), nil
 }
 
-func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
-	var stack [64]Value
-	sp := -1
+func runVMGeneral(bc *RenderedBytecode, ctx Context, stack *[64]Value, st *RunState) (any, error) {
+	sp := bc.Locals - 1 // 栈底 bc.Locals 个槽位留给 let 绑定
+	fp := 0             // 当前栈帧的起点，函数块中的 let 槽位与参数相对于它寻址
 	pc := 0
 	insts := bc.Instructions
 	consts := bc.Constants
 	nInsts := len(insts)
+	concat := bc.concat // 闭包入口块沿用调用方的计数
+	if concat == nil { concat = newConcatBudget(bc.MaxConcatBytes) }
+	functions := bc.Functions
+	var handlers []tryHandler // try 登记的错误处理现场，最内层在末尾
+	var fault error
 
 	for pc < nInsts {
 		inst := insts[pc]
@@ -342,7 +575,7 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 		switch inst.Op {
 		case OpPush:
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = consts[inst.Arg]
 		case OpPop:
 			sp--
@@ -353,48 +586,59 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			} else if l.Type == ValString && r.Type == ValString {
 				stack[sp] = Value{Type: ValString, Str: l.Str + r.Str}
 			} else {
-				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
-				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf + rf)}
+				stack[sp] = l.Add(r)
 			}
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case OpSub:
 			r := stack[sp]; sp--; l := stack[sp]
 			if l.Type == ValInt && r.Type == ValInt {
 				stack[sp] = Value{Type: ValInt, Num: l.Num - r.Num}
 			} else {
-				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
-				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf - rf)}
+				stack[sp] = l.Sub(r)
 			}
 		case OpMul:
 			r := stack[sp]; sp--; l := stack[sp]
 			if l.Type == ValInt && r.Type == ValInt {
 				stack[sp] = Value{Type: ValInt, Num: l.Num * r.Num}
+			} else if v, ok, _ := arithDecimal(TokenAsterisk, l, r); ok {
+				stack[sp] = v
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf * rf)}
 			}
 		case OpDiv:
 			r := stack[sp]; sp--; l := stack[sp]
-			if r.Type == ValInt && r.Num == 0 { return nil, fmt.Errorf("division by zero") }
-			if r.Type == ValFloat && math.Float64frombits(r.Num) == 0 { return nil, fmt.Errorf("division by zero") }
+			if r.Type == ValInt && r.Num == 0 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("division by zero")); goto unwind }
+			if r.Type == ValFloat && math.Float64frombits(r.Num) == 0 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("division by zero")); goto unwind }
 			if l.Type == ValInt && r.Type == ValInt {
-				stack[sp] = Value{Type: ValInt, Num: l.Num / r.Num}
+				stack[sp] = Value{Type: ValInt, Num: uint64(int64(l.Num) / int64(r.Num))}
+			} else if v, ok, err := arithDecimal(TokenSlash, l, r); ok {
+				if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+				stack[sp] = v
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf / rf)}
 			}
 		case OpMod:
 			r := stack[sp]; sp--; l := stack[sp]
-			if r.Type != ValInt { return nil, fmt.Errorf("modulo operator supports only integers") }
-			if r.Num == 0 { return nil, fmt.Errorf("division by zero") }
-			stack[sp] = Value{Type: ValInt, Num: l.Num % r.Num}
+			if r.Type != ValInt || l.Type == ValDecimal { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("modulo operator supports only integers")); goto unwind }
+			if r.Num == 0 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("division by zero")); goto unwind }
+			stack[sp] = Value{Type: ValInt, Num: uint64(int64(l.Num) % int64(r.Num))}
 		case OpEqual:
 			r := stack[sp]; sp--; l := stack[sp]
 			res := false
 			if l.Type == r.Type {
 				switch l.Type {
-				case ValInt, ValFloat, ValBool: res = l.Num == r.Num
+				case ValInt, ValFloat, ValBool, ValDuration: res = l.Num == r.Num
 				case ValString: res = l.Str == r.Str
+				case ValTime: res = timeEqual(l, r)
+				case ValObject: res = objectEqual(l, r)
+				case ValSet: res = setEqual(l.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+				case ValDecimal: res = decimalEqual(l, r)
+				case ValBytes: res = bytesEqual(l, r)
 				case ValNil: res = true
+				case ValArray: res = arrayEqual(l.Obj.([]any), r.Obj.([]any))
+				case ValMap: res = mapEqual(l.Obj.(map[string]any), r.Obj.(map[string]any))
 				}
 			} else {
 				lf, okL := valToFloat64(l); rf, okR := valToFloat64(r)
@@ -406,6 +650,10 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) > int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str > r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c > 0
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				res = lf > rf
@@ -416,6 +664,10 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) < int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str < r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c < 0
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				res = lf < rf
@@ -426,6 +678,10 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) >= int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str >= r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c >= 0
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				res = lf >= rf
@@ -436,6 +692,10 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			res := false
 			if l.Type == ValInt && r.Type == ValInt {
 				res = int64(l.Num) <= int64(r.Num)
+			} else if l.Type == ValString && r.Type == ValString {
+				res = l.Str <= r.Str
+			} else if c, ok := compareExtended(l, r); ok {
+				res = c <= 0
 			} else {
 				lf, _ := valToFloat64(l); rf, _ := valToFloat64(r)
 				res = lf <= rf
@@ -450,6 +710,8 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 		case OpNot:
 			l := stack[sp]
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(!isValTruthy(l))}
+		case OpToBool:
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(isValTruthy(stack[sp]))}
 		case OpJump:
 			pc = int(inst.Arg)
 		case OpJumpIfFalse:
@@ -458,40 +720,51 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 		case OpJumpIfTrue:
 			l := stack[sp]; sp--
 			if isValTruthy(l) { pc = int(inst.Arg) }
+		case OpJumpIfFalseOrPop:
+			if isValTruthy(stack[sp]) { sp-- } else { pc = int(inst.Arg) }
+		case OpJumpIfTrueOrPop:
+			if isValTruthy(stack[sp]) { pc = int(inst.Arg) } else { sp-- }
 		case OpGetGlobal:
 			name := consts[inst.Arg].Str
 			val, _ := ctx.Get(name)
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = FromInterface(val)
 		case OpSetGlobal:
 			name := consts[inst.Arg].Str
 			val := stack[sp]
-			ctx.Set(name, val.ToInterface())
+			if err := setGlobal(ctx, bc.Name, pc-1, name, val.ToInterface()); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case OpCall:
 			nameIdx := inst.Arg & 0xFFFF
 			numArgs := int(inst.Arg >> 16)
 			name := consts[nameIdx].Str
-			args := make([]any, numArgs)
+			args := st.argScratch(nil, numArgs)
 			for i := numArgs - 1; i >= 0; i-- {
 				args[i] = stack[sp].ToInterface(); sp--
 			}
-			if builtin, ok := builtins[name]; ok {
-				res, err := builtin(args...)
-				if err != nil { return nil, err }
+			if builtin, ok := builtins.get(name); ok {
+				res, err := callBuiltin(name, builtin, args)
+				st.release(args)
+				if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 				sp++
-				if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+				if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 				stack[sp] = FromInterface(res)
 			} else {
-				return nil, fmt.Errorf("builtin function not found: %s", name)
+				fault = bc.fault(pc-1, stack, sp, fmt.Errorf("builtin function not found: %s", name))
+				goto unwind
 			}
 		case OpEqualConst:
 			r := consts[inst.Arg]; l := stack[sp]
 			res := false
 			if l.Type == r.Type {
 				switch l.Type {
-				case ValInt, ValFloat, ValBool: res = l.Num == r.Num
+				case ValInt, ValFloat, ValBool, ValDuration: res = l.Num == r.Num
 				case ValString: res = l.Str == r.Str
+				case ValTime: res = timeEqual(l, r)
+				case ValObject: res = objectEqual(l, r)
+				case ValSet: res = setEqual(l.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+				case ValDecimal: res = decimalEqual(l, r)
+				case ValBytes: res = bytesEqual(l, r)
 				case ValNil: res = true
 				}
 			} else {
@@ -505,30 +778,30 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			lv := FromInterface(val)
 			rv := consts[cIdx]
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			if lv.Type == ValInt && rv.Type == ValInt {
 				stack[sp] = Value{Type: ValInt, Num: lv.Num + rv.Num}
 			} else if lv.Type == ValString && rv.Type == ValString {
 				stack[sp] = Value{Type: ValString, Str: lv.Str + rv.Str}
 			} else {
-				lf, _ := valToFloat64(lv); rf, _ := valToFloat64(rv)
-				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf + rf)}
+				stack[sp] = lv.Add(rv)
 			}
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case OpAddGlobalGlobal:
 			g1Idx := inst.Arg >> 16; g2Idx := inst.Arg & 0xFFFF
 			v1, _ := ctx.Get(consts[g1Idx].Str)
 			v2, _ := ctx.Get(consts[g2Idx].Str)
 			lv := FromInterface(v1); rv := FromInterface(v2)
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			if lv.Type == ValInt && rv.Type == ValInt {
 				stack[sp] = Value{Type: ValInt, Num: lv.Num + rv.Num}
 			} else if lv.Type == ValString && rv.Type == ValString {
 				stack[sp] = Value{Type: ValString, Str: lv.Str + rv.Str}
 			} else {
-				lf, _ := valToFloat64(lv); rf, _ := valToFloat64(rv)
-				stack[sp] = Value{Type: ValFloat, Num: math.Float64bits(lf + rf)}
+				stack[sp] = lv.Add(rv)
 			}
+			if err := concat.chargeString(&stack[sp]); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
 		case OpEqualGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF
 			val, _ := ctx.Get(consts[gIdx].Str)
@@ -536,8 +809,13 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			res := false
 			if lv.Type == r.Type {
 				switch lv.Type {
-				case ValInt, ValFloat, ValBool: res = lv.Num == r.Num
+				case ValInt, ValFloat, ValBool, ValDuration: res = lv.Num == r.Num
 				case ValString: res = lv.Str == r.Str
+				case ValTime: res = timeEqual(lv, r)
+				case ValObject: res = objectEqual(lv, r)
+				case ValSet: res = setEqual(lv.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+				case ValDecimal: res = decimalEqual(lv, r)
+				case ValBytes: res = bytesEqual(lv, r)
 				case ValNil: res = true
 				}
 			} else {
@@ -545,7 +823,7 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 				if okL && okR { res = lf == rf }
 			}
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(res)}
 		case OpGreaterGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF
@@ -554,12 +832,16 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			res := false
 			if lv.Type == ValInt && r.Type == ValInt {
 				res = int64(lv.Num) > int64(r.Num)
+			} else if lv.Type == ValString && r.Type == ValString {
+				res = lv.Str > r.Str
+			} else if c, ok := compareExtended(lv, r); ok {
+				res = c > 0
 			} else {
 				lf, _ := valToFloat64(lv); rf, _ := valToFloat64(r)
 				res = lf > rf
 			}
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(res)}
 		case OpLessGlobalConst:
 			gIdx := inst.Arg >> 16; cIdx := inst.Arg & 0xFFFF
@@ -568,12 +850,16 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			res := false
 			if lv.Type == ValInt && r.Type == ValInt {
 				res = int64(lv.Num) < int64(r.Num)
+			} else if lv.Type == ValString && r.Type == ValString {
+				res = lv.Str < r.Str
+			} else if c, ok := compareExtended(lv, r); ok {
+				res = c < 0
 			} else {
 				lf, _ := valToFloat64(lv); rf, _ := valToFloat64(r)
 				res = lf < rf
 			}
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValBool, Num: boolToUint64(res)}
 		case OpFusedCompareGlobalConstJumpIfFalse:
 			gIdx := int(inst.Arg >> 22) & 0x3FF
@@ -584,8 +870,13 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			res := false
 			if lv.Type == r.Type {
 				switch lv.Type {
-				case ValInt, ValFloat, ValBool: res = lv.Num == r.Num
+				case ValInt, ValFloat, ValBool, ValDuration: res = lv.Num == r.Num
 				case ValString: res = lv.Str == r.Str
+				case ValTime: res = timeEqual(lv, r)
+				case ValObject: res = objectEqual(lv, r)
+				case ValSet: res = setEqual(lv.Obj.(map[string]struct{}), r.Obj.(map[string]struct{}))
+				case ValDecimal: res = decimalEqual(lv, r)
+				case ValBytes: res = bytesEqual(lv, r)
 				case ValNil: res = true
 				}
 			} else {
@@ -606,7 +897,7 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 			totalLen := 0
 			var argStringsBuf [8]string
 			var argStrings []string
-			if numArgs <= 8 { argStrings = argStringsBuf[:numArgs] } else { argStrings = make([]string, numArgs) }
+			argStrings = st.strScratch(argStringsBuf[:], numArgs)
 			for i := numArgs - 1; i >= 0; i-- {
 				v := stack[sp]; sp--; var s string
 				switch v.Type {
@@ -615,27 +906,187 @@ func runVMGeneral(bc *RenderedBytecode, ctx Context) (any, error) {
 				case ValFloat: s = fmt.Sprintf("%g", math.Float64frombits(v.Num))
 				case ValBool:
 					if v.Num != 0 { s = "true" } else { s = "false" }
-				default: s = fmt.Sprintf("%v", v.ToInterface())
+				default: s = formatAny(v.ToInterface())
 				}
 				argStrings[i] = s; totalLen += len(s)
 			}
-			buf := bufferPool.Get().(*bytes.Buffer)
-			buf.Reset(); buf.Grow(totalLen)
-			for _, s := range argStrings { buf.WriteString(s) }
-			res := buf.String(); bufferPool.Put(buf)
+			if err := concat.charge(totalLen); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			res := st.join(&bufferPool, argStrings, totalLen)
 			sp++
-			if sp >= 64 { return nil, fmt.Errorf("VM stack overflow") }
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
 			stack[sp] = Value{Type: ValString, Str: res}
+		case OpInSetGlobal:
+			gIdx := inst.Arg >> 16; setIdx := inst.Arg & 0xFFFF
+			val, _ := ctx.Get(consts[gIdx].Str)
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(bc.Sets[setIdx].Contains(FromInterface(val)))}
+		case OpJumpTableGlobal:
+			gIdx := inst.Arg >> 16; tIdx := inst.Arg & 0xFFFF
+			val, _ := ctx.Get(consts[gIdx].Str)
+			pc = int(bc.JumpTables[tIdx].Lookup(FromInterface(val)))
+		case OpMakeArray:
+			n := int(inst.Arg)
+			arr := makeArray(stack[sp-n+1 : sp+1])
+			sp -= n - 1
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = arr
+		case OpIndex:
+			idx := stack[sp]; sp--
+			v, err := stack[sp].Index(idx)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpJumpIfNotMap:
+			if stack[sp].Type != ValMap { stack[sp] = Value{}; pc = int(inst.Arg) }
+		case OpMapGetConst:
+			m, _ := stack[sp].Obj.(map[string]any)
+			stack[sp] = FromInterface(m[consts[inst.Arg].Str])
+		case OpSetIndex:
+			val := stack[sp]; idx := stack[sp-1]; sp -= 2
+			if err := stack[sp].SetIndex(idx, val); err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = val
+		case OpMakeMap:
+			n := 2 * int(inst.Arg)
+			m, err := makeMap(stack[sp-n+1 : sp+1])
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			sp -= n - 1
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = m
+		case OpCopyConst:
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = copyConst(consts[inst.Arg])
+		case OpCallMethod:
+			numArgs := int(inst.Arg >> 16)
+			args := st.argScratch(nil, numArgs)
+			for i := numArgs - 1; i >= 0; i-- {
+				args[i] = stack[sp].ToInterface(); sp--
+			}
+			res, err := CallMethodAny(stack[sp].ToInterface(), consts[inst.Arg&0xFFFF].Str, args)
+			st.release(args)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = FromInterface(res)
+		case OpEqualFold:
+			r := stack[sp]; sp--
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(stack[sp].EqualFold(r))}
+		case OpIn:
+			r := stack[sp]; sp--
+			v, err := stack[sp].In(r)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpInRange:
+			v, err := inRange(stack[sp], consts[inst.Arg])
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpMakeRange:
+			r := stack[sp]; sp--
+			v, err := rangeValue(stack[sp], r)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpSpread:
+			r := stack[sp]; sp--
+			v, err := spreadInto(stack[sp], r)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpCast:
+			v, err := castValue(castKind(inst.Arg), stack[sp])
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpIter:
+			items, idx, err := iterStart(iterKind(inst.Arg), stack[sp])
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = items
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = idx
+		case OpIterNext:
+			el, ok := iterNext(stack[sp-2], &stack[sp-1])
+			if !ok { pc = int(inst.Arg); break }
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = el
+		case OpAppend:
+			v := stack[sp]; sp--
+			stack[sp] = appendValue(stack[sp], v)
+		case OpIterEnd:
+			stack[sp-2] = stack[sp]; sp -= 2
+		case OpSlice:
+			v, err := sliceValue(stack[sp], inst.Arg)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpScore:
+			cond := stack[sp]; sp--
+			if isValTruthy(cond) { stack[sp] = stack[sp].Add(consts[inst.Arg]) }
+		case OpDefined:
+			_, ok := ctx.Get(consts[inst.Arg].Str)
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValBool, Num: boolToUint64(ok)}
+		case OpBitAnd, OpBitOr, OpBitXor, OpShl, OpShr:
+			r := stack[sp]; sp--
+			v, err := stack[sp].Bitwise(TokenBitAnd+TokenType(inst.Op-OpBitAnd), r)
+			if err != nil { fault = bc.fault(pc-1, stack, sp, err); goto unwind }
+			stack[sp] = v
+		case OpGetLocal:
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = stack[fp+int(inst.Arg)]
+		case OpSetLocal:
+			stack[fp+int(inst.Arg)] = stack[sp]; sp--
+		case OpCallLocal:
+			fn := functions[inst.Arg]
+			nfp := sp - fn.Params + 1
+			if nfp+fn.Locals >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			sp = nfp + fn.Locals
+			stack[sp] = callFrame(bc, pc, fp)
+			bc, insts, consts, nInsts, pc, fp = fn, fn.Instructions, fn.Constants, len(fn.Instructions), 0, nfp
+		case OpReturnLocal:
+			frame := stack[fp+bc.Locals]
+			stack[fp] = stack[sp]; sp = fp
+			bc, pc, fp = frame.Obj.(*RenderedBytecode), int(frame.Num>>32), int(uint32(frame.Num))
+			insts, consts, nInsts = bc.Instructions, bc.Constants, len(bc.Instructions)
+		case OpMakeClosure:
+			k := int(inst.Arg >> 16)
+			sp++
+			if sp >= 64 { fault = bc.fault(pc-1, stack, sp, fmt.Errorf("VM stack overflow")); goto unwind }
+			stack[sp] = Value{Type: ValFunc, Obj: newVMClosure(functions, concat, int(inst.Arg&0xFFFF), append([]Value(nil), stack[fp:fp+k]...), ctx)}
+		case OpTry:
+			handlers = append(handlers, tryHandler{bc: bc, pc: int(inst.Arg), sp: sp, fp: fp})
+		case OpEndTry:
+			handlers = handlers[:len(handlers)-1]
+			pc = int(inst.Arg)
+		default:
+			fault = bc.fault(pc-1, stack, sp, fmt.Errorf("unsupported VM opcode: %v", inst.Op))
+			goto unwind
 		}
+		continue
+	unwind:
+		// 出错时回到最内层 try 的现场求备用值，不在 try 中时返回错误
+		if len(handlers) == 0 { return nil, fault }
+		h := handlers[len(handlers)-1]; handlers = handlers[:len(handlers)-1]
+		bc, pc, sp, fp = h.bc.(*RenderedBytecode), h.pc, h.sp, h.fp
+		insts, consts, nInsts = bc.Instructions, bc.Constants, len(bc.Instructions)
 	}
+	if bc.ResultCount > 1 { return collectTuple(stack[:sp+1], bc.ResultCount), nil }
 	if sp < 0 { return nil, nil }
 	return stack[sp].ToInterface(), nil
 }
 
+// collectTuple 将栈顶的 n 个值按入栈顺序转换为 []any
+func collectTuple(stack []Value, n int) []any {
+	res := make([]any, n)
+	base := len(stack) - n
+	for i := range res {
+		res[i] = stack[base+i].ToInterface()
+	}
+	return res
+}
+
 func valToFloat64(v Value) (float64, bool) {
 	switch v.Type {
 	case ValFloat: return math.Float64frombits(v.Num), true
 	case ValInt: return float64(int64(v.Num)), true
+	case ValDecimal: return v.Obj.(Decimal).Float64(), true
 	}
 	return 0, false
 }
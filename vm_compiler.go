@@ -6,13 +6,29 @@ package uwasa
 import (
 	"fmt"
 	"math"
+	"time"
 )
 
 type VMCompiler struct {
 	instructions []vmInstruction
 	constants    []Value
 	constMap     map[any]int32
+	sets         []*ValueSet
+	tables       []*JumpTable
+	branchHints  map[string]BranchHint
+	resultCount  int
 	errors       []string
+	// locals 为当前可见的 let 绑定，下标即栈底槽位；maxLocals 为同时可见的最大数量
+	locals    []string
+	maxLocals int
+	// chunks 为整条规则共享的函数块表
+	chunks *chunkTable[*RenderedBytecode]
+	// memo 为当前块的调用复用规划，memoInsts 为读写暂存位的指令，编译结束后把暂存位排在 let 槽位之上；
+	// memoWritten 与 memoSafe 由 memoBarriers 对整条规则求出，子编译器共用
+	memo        *callMemoPlan
+	memoInsts   []int
+	memoWritten map[string]bool
+	memoSafe    bool
 }
 
 func NewVMCompiler() *VMCompiler {
@@ -22,17 +38,132 @@ func NewVMCompiler() *VMCompiler {
 }
 
 func (c *VMCompiler) Compile(node Node) (*RenderedBytecode, error) {
+	c.chunks = &chunkTable[*RenderedBytecode]{}
+	c.memoWritten, c.memoSafe = memoBarriers(node)
+	if prog, ok := node.(*Program); ok {
+		for _, fn := range prog.Functions {
+			chunk, err := c.compileFunction(fn)
+			if err != nil {
+				return nil, err
+			}
+			c.chunks.add(fn.Name.Value, chunk)
+		}
+		node = prog.Body
+	}
+	c.planMemo(node)
 	err := c.walk(node)
 	if err != nil {
 		return nil, err
 	}
+	c.placeMemo()
 	c.peephole()
 	return &RenderedBytecode{
 		Instructions: c.instructions,
 		Constants:    c.constants,
+		Sets:         c.sets,
+		JumpTables:   c.tables,
+		ResultCount:  c.resultCount,
+		Locals:       c.maxLocals,
+		Functions:    c.chunks.chunks,
+	}, nil
+}
+
+// compileFunction 用独立的编译器把函数体编译为字节码块：参数依次占据最低的槽位，
+// 函数体只能调用此前已编译的函数
+func (c *VMCompiler) compileFunction(fn *FunctionLiteral) (*RenderedBytecode, error) {
+	chunk, err := c.compileChunk(nil, fn.Parameters, fn.Body)
+	if err != nil {
+		return nil, fmt.Errorf("function %s: %w", fn.Name.Value, err)
+	}
+	chunk.Name = fn.Name.Value
+	return chunk, nil
+}
+
+// compileLambda 把 lambda 编译为匿名函数块：当前可见的 let 绑定按原槽位捕获，参数紧随其后，
+// 返回登记的下标
+func (c *VMCompiler) compileLambda(n *LambdaLiteral) (int, error) {
+	chunk, err := c.compileChunk(c.locals, n.Parameters, n.Body)
+	if err != nil {
+		return 0, err
+	}
+	chunk.Name = lambdaName
+	return c.chunks.add("", chunk), nil
+}
+
+// compileChunk 用独立的编译器编译函数体：captured 与 params 依次占据最低的槽位
+func (c *VMCompiler) compileChunk(captured []string, params []*Identifier, body Expression) (*RenderedBytecode, error) {
+	sub := NewVMCompiler()
+	sub.branchHints = c.branchHints
+	sub.chunks = c.chunks
+	sub.memoWritten, sub.memoSafe = c.memoWritten, c.memoSafe
+	sub.locals = append(sub.locals, captured...)
+	for _, param := range params {
+		sub.locals = append(sub.locals, param.Value)
+	}
+	sub.maxLocals = len(sub.locals)
+	sub.planMemo(body)
+	if err := sub.walk(body); err != nil {
+		return nil, err
+	}
+	sub.placeMemo()
+	sub.emit(OpReturnLocal, 0)
+	sub.peephole()
+	return &RenderedBytecode{
+		Instructions: sub.instructions,
+		Constants:    sub.constants,
+		Sets:         sub.sets,
+		JumpTables:   sub.tables,
+		Locals:       sub.maxLocals,
+		Params:       len(sub.locals),
 	}, nil
 }
 
+func (c *VMCompiler) planMemo(body Node) {
+	if c.memoSafe {
+		c.memo = planCallMemo(body, c.locals, c.memoWritten)
+	}
+}
+
+// placeMemo 把暂存位编号换算为 let 槽位之上的槽位
+func (c *VMCompiler) placeMemo() {
+	if c.memo == nil {
+		return
+	}
+	for _, pos := range c.memoInsts {
+		c.instructions[pos].Arg += int32(c.maxLocals)
+	}
+	c.maxLocals += c.memo.slots
+}
+
+// jumpTargets 标记所有跳转目标（含跳转表的各分支入口）
+func (c *VMCompiler) jumpTargets() []bool {
+	targets := make([]bool, len(c.instructions)+1)
+	for _, inst := range c.instructions {
+		switch inst.Op {
+		case OpJump, OpJumpIfFalse, OpJumpIfTrue, OpJumpIfNotMap, OpJumpIfFalseOrPop, OpJumpIfTrueOrPop, OpTry, OpEndTry, OpIterNext:
+			targets[inst.Arg] = true
+		}
+	}
+	for _, t := range c.tables {
+		for _, target := range t.Targets {
+			targets[target] = true
+		}
+		targets[t.Default] = true
+	}
+	return targets
+}
+
+// fusible 判断从 i 开始的 n+1 条指令能否融合为一条：窗口内首条之后的指令若是跳转目标，
+// 融合后跳转会落到融合指令上并重复执行窗口前部（例如重新读取已被分支覆盖的变量）。
+func fusible(targets []bool, i, n int) bool {
+	for k := i + 1; k <= i+n; k++ {
+		if targets[k] {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *VMCompiler) peephole() {
 	if len(c.instructions) < 2 {
 		return
@@ -40,13 +171,14 @@ func (c *VMCompiler) peephole() {
 
 	newInsts := make([]vmInstruction, 0, len(c.instructions))
 	oldToNew := make([]int, len(c.instructions)+1)
+	targets := c.jumpTargets()
 
 	for i := 0; i < len(c.instructions); i++ {
 		oldToNew[i] = len(newInsts)
 		inst := c.instructions[i]
 
 		// 3-instruction fusion: GetGlobal + Push + Equal/Greater/Less + JumpIfFalse
-		if i+3 < len(c.instructions) &&
+		if i+3 < len(c.instructions) && fusible(targets, i, 3) &&
 			inst.Op == OpGetGlobal &&
 			c.instructions[i+1].Op == OpPush &&
 			(c.instructions[i+2].Op == OpEqual || c.instructions[i+2].Op == OpGreater || c.instructions[i+2].Op == OpLess) &&
@@ -68,14 +200,14 @@ func (c *VMCompiler) peephole() {
 		}
 
 		// 2-instruction fusion
-		if i+2 < len(c.instructions) &&
+		if i+2 < len(c.instructions) && fusible(targets, i, 2) &&
 			inst.Op == OpGetGlobal &&
 			c.instructions[i+1].Op == OpPush {
 
 			gIdx := inst.Arg
 			cIdx := c.instructions[i+1].Arg
 
-			if gIdx < 65536 && cIdx < 65536 {
+			if gIdx < maxPackedIndex && cIdx < maxPackedIndex {
 				// GetGlobal + Push + Add -> AddGlobal
 				if c.instructions[i+2].Op == OpAdd {
 					newInsts = append(newInsts, vmInstruction{Op: OpAddGlobal, Arg: (cIdx << 16) | gIdx})
@@ -104,14 +236,14 @@ func (c *VMCompiler) peephole() {
 		}
 
 		// 3-instruction fusion: GetGlobal + GetGlobal + Add -> AddGlobalGlobal
-		if i+2 < len(c.instructions) &&
+		if i+2 < len(c.instructions) && fusible(targets, i, 2) &&
 			inst.Op == OpGetGlobal &&
 			c.instructions[i+1].Op == OpGetGlobal &&
 			c.instructions[i+2].Op == OpAdd {
 
 			g1Idx := inst.Arg
 			g2Idx := c.instructions[i+1].Arg
-			if g1Idx < 65536 && g2Idx < 65536 {
+			if g1Idx < maxPackedIndex && g2Idx < maxPackedIndex {
 				newInsts = append(newInsts, vmInstruction{Op: OpAddGlobalGlobal, Arg: (g1Idx << 16) | g2Idx})
 				oldToNew[i+1] = len(newInsts) - 1
 				oldToNew[i+2] = len(newInsts) - 1
@@ -121,13 +253,13 @@ func (c *VMCompiler) peephole() {
 		}
 
 		// 2-instruction fusion: GetGlobal + JumpIfFalse/True
-		if i+1 < len(c.instructions) &&
+		if i+1 < len(c.instructions) && fusible(targets, i, 1) &&
 			inst.Op == OpGetGlobal {
 
 			gIdx := inst.Arg
 			jTarget := c.instructions[i+1].Arg
 
-			if gIdx < 65536 && jTarget < 65536 {
+			if gIdx < maxPackedIndex && jTarget < maxPackedIndex {
 				op := OpCode(0)
 				switch c.instructions[i+1].Op {
 				case OpJumpIfFalse: op = OpGetGlobalJumpIfFalse
@@ -150,7 +282,7 @@ func (c *VMCompiler) peephole() {
 	// Fix jump targets
 	for i := range newInsts {
 		switch newInsts[i].Op {
-		case OpJump, OpJumpIfFalse, OpJumpIfTrue:
+		case OpJump, OpJumpIfFalse, OpJumpIfTrue, OpJumpIfNotMap, OpJumpIfFalseOrPop, OpJumpIfTrueOrPop, OpTry, OpEndTry, OpIterNext:
 			newInsts[i].Arg = int32(oldToNew[newInsts[i].Arg])
 		case OpFusedCompareGlobalConstJumpIfFalse:
 			gIdx := (newInsts[i].Arg >> 22) & 0x3FF
@@ -163,11 +295,22 @@ func (c *VMCompiler) peephole() {
 			newInsts[i].Arg = (gIdx << 16) | int32(oldToNew[jTarget])
 		}
 	}
+	for _, t := range c.tables {
+		for i, target := range t.Targets {
+			t.Targets[i] = int32(oldToNew[target])
+		}
+		t.Default = int32(oldToNew[t.Default])
+	}
 
 	c.instructions = newInsts
 }
 
 func (c *VMCompiler) CompileOptimized(node Node, opts EngineOptions) (*RenderedBytecode, error) {
+	hints, err := branchHintKeys(opts)
+	if err != nil {
+		return nil, err
+	}
+	c.branchHints = hints
 	optimized := node
 	if opts.OptimizationLevel >= OptBasic {
 		optimized = Fold(optimized)
@@ -181,7 +324,12 @@ func (c *VMCompiler) CompileOptimized(node Node, opts EngineOptions) (*RenderedB
 		}
 	}
 
-	return c.Compile(optimized)
+	bc, err := c.Compile(optimized)
+	if err != nil {
+		return nil, err
+	}
+	bc.MaxConcatBytes = opts.MaxConcatBytes
+	return bc, nil
 }
 
 func (c *VMCompiler) optimize(node Node) (Node, error) {
@@ -234,6 +382,10 @@ func (c *VMCompiler) simplify(node Node) Node {
 			if isSameIdentifier(n.Left, n.Right) && !hasSideEffects(n.Left) {
 				return &BooleanLiteral{Value: true}
 			}
+		case "!=":
+			if isSameIdentifier(n.Left, n.Right) && !hasSideEffects(n.Left) {
+				return &BooleanLiteral{Value: false}
+			}
 		}
 		return n
 	case *IfExpression:
@@ -244,6 +396,74 @@ func (c *VMCompiler) simplify(node Node) Node {
 	case *AssignExpression:
 		n.Value = c.simplify(n.Value).(Expression)
 		return n
+	case *LetExpression:
+		n.Value = c.simplify(n.Value).(Expression)
+		n.Body = c.simplify(n.Body).(Expression)
+		return n
+	case *DestructureExpression:
+		n.Value = c.simplify(n.Value).(Expression)
+		if n.Body != nil { n.Body = c.simplify(n.Body).(Expression) }
+		return n
+	case *Program:
+		for _, fn := range n.Functions {
+			fn.Body = c.simplify(fn.Body).(Expression)
+		}
+		n.Body = c.simplify(n.Body).(Expression)
+		return n
+	case *LambdaLiteral:
+		n.Body = c.simplify(n.Body).(Expression)
+		return n
+	case *TupleExpression:
+		for i, el := range n.Elements {
+			n.Elements[i] = c.simplify(el).(Expression)
+		}
+		return n
+	case *SequenceExpression:
+		for i, stmt := range n.Statements {
+			n.Statements[i] = c.simplify(stmt).(Expression)
+		}
+		return n
+	case *ArrayLiteral:
+		for i, el := range n.Elements {
+			n.Elements[i] = c.simplify(el).(Expression)
+		}
+		return n
+	case *SpreadElement:
+		n.Value = c.simplify(n.Value).(Expression)
+		return n
+	case *MapLiteral:
+		for i := range n.Keys {
+			n.Keys[i] = c.simplify(n.Keys[i]).(Expression)
+			n.Values[i] = c.simplify(n.Values[i]).(Expression)
+		}
+		return n
+	case *MethodCallExpression:
+		n.Receiver = c.simplify(n.Receiver).(Expression)
+		for i, arg := range n.Arguments {
+			n.Arguments[i] = c.simplify(arg).(Expression)
+		}
+		return n
+	case *OptionalMemberExpression:
+		n.Receiver = c.simplify(n.Receiver).(Expression)
+		return n
+	case *RangeExpression:
+		n.Start = c.simplify(n.Start).(Expression)
+		n.End = c.simplify(n.End).(Expression)
+		return n
+	case *ScoreExpression:
+		for i, cond := range n.Conditions {
+			n.Conditions[i] = c.simplify(cond).(Expression)
+		}
+		return n
+	case *IndexExpression:
+		n.Left = c.simplify(n.Left).(Expression)
+		n.Index = c.simplify(n.Index).(Expression)
+		return n
+	case *IndexAssignExpression:
+		n.Left = c.simplify(n.Left).(Expression)
+		n.Index = c.simplify(n.Index).(Expression)
+		n.Value = c.simplify(n.Value).(Expression)
+		return n
 	default:
 		return n
 	}
@@ -258,7 +478,12 @@ func (c *VMCompiler) checkTypeMismatch(ie *InfixExpression) {
 	_, okRN := right.(*NumberLiteral)
 
 	switch ie.Operator {
-	case "-", "*", "/", "%", ">", "<", ">=", "<=":
+	case ">", "<", ">=", "<=":
+		// 字符串之间按字节序比较，只有字符串与数字的比较一定不成立
+		if (okLS && okRN) || (okLN && okRS) {
+			c.errors = append(c.errors, fmt.Sprintf("invalid comparison: string %s number", ie.Operator))
+		}
+	case "-", "*", "/", "%", "&", "|", "^", "<<", ">>":
 		if okLS || okRS {
 			c.errors = append(c.errors, fmt.Sprintf("invalid operation: string %s string/number", ie.Operator))
 		}
@@ -272,6 +497,10 @@ func (c *VMCompiler) checkTypeMismatch(ie *InfixExpression) {
 func (c *VMCompiler) walk(node Node) error {
 	switch n := node.(type) {
 	case *Identifier:
+		if slot, ok := c.local(n.Value); ok {
+			c.emit(OpGetLocal, slot)
+			break
+		}
 		c.emit(OpGetGlobal, c.addConstant(Value{Type: ValString, Str: n.Value}))
 	case *NumberLiteral:
 		if n.IsInt {
@@ -281,6 +510,12 @@ func (c *VMCompiler) walk(node Node) error {
 		}
 	case *StringLiteral:
 		c.emit(OpPush, c.addConstant(Value{Type: ValString, Str: n.Value}))
+	case *TimeLiteral:
+		c.emit(OpPush, c.addConstant(Value{Type: ValTime, Obj: n.Value}))
+	case *DurationLiteral:
+		c.emit(OpPush, c.addConstant(Value{Type: ValDuration, Num: uint64(n.Value)}))
+	case *DecimalLiteral:
+		c.emit(OpPush, c.addConstant(Value{Type: ValDecimal, Obj: n.Value}))
 	case *BooleanLiteral:
 		val := uint64(0)
 		if n.Value { val = 1 }
@@ -298,13 +533,13 @@ func (c *VMCompiler) walk(node Node) error {
 		}
 	case *InfixExpression:
 		if n.Operator == "&&" {
+			if n.ReturnsOperand { return c.walkOperandLogic(n, OpJumpIfFalseOrPop) }
 			err := c.walk(n.Left)
 			if err != nil { return err }
 			jumpFalse := c.emit(OpJumpIfFalse, 0)
 			err = c.walk(n.Right)
 			if err != nil { return err }
-			c.emit(OpNot, 0)
-			c.emit(OpNot, 0)
+			c.emit(OpToBool, 0)
 			jumpEnd := c.emit(OpJump, 0)
 			c.patch(jumpFalse, int32(len(c.instructions)))
 			c.emit(OpPush, c.addConstant(Value{Type: ValBool, Num: 0}))
@@ -312,13 +547,16 @@ func (c *VMCompiler) walk(node Node) error {
 			return nil
 		}
 		if n.Operator == "||" {
+			if name, vals, ok := collectEqualityChain(n); ok && len(vals) >= minSetMatchSize && !c.isLocal(name) && c.emitInSetGlobal(name, vals) {
+				return nil
+			}
+			if n.ReturnsOperand { return c.walkOperandLogic(n, OpJumpIfTrueOrPop) }
 			err := c.walk(n.Left)
 			if err != nil { return err }
 			jumpTrue := c.emit(OpJumpIfTrue, 0)
 			err = c.walk(n.Right)
 			if err != nil { return err }
-			c.emit(OpNot, 0)
-			c.emit(OpNot, 0)
+			c.emit(OpToBool, 0)
 			jumpEnd := c.emit(OpJump, 0)
 			c.patch(jumpTrue, int32(len(c.instructions)))
 			c.emit(OpPush, c.addConstant(Value{Type: ValBool, Num: 1}))
@@ -326,6 +564,25 @@ func (c *VMCompiler) walk(node Node) error {
 			return nil
 		}
 
+		if n.Operator == "in" {
+			// `x in 1..10` 只比较两端，不构造区间
+			if r, ok := n.Right.(*RangeExpression); ok {
+				if rng, ok := constRange(r); ok {
+					if err := c.walk(n.Left); err != nil { return err }
+					c.emit(OpInRange, c.addConstant(Value{Type: ValObject, Obj: rng}))
+					return nil
+				}
+			}
+			// `x in [字面量...]` 与等值链同样编译为一次集合查找
+			if ident, ok := n.Left.(*Identifier); ok && !c.isLocal(ident.Value) {
+				if arr, ok := n.Right.(*ArrayLiteral); ok {
+					if vals, ok := literalValues(arr.Elements); ok && len(vals) >= minSetMatchSize && c.emitInSetGlobal(ident.Value, vals) {
+						return nil
+					}
+				}
+			}
+		}
+
 		err := c.walk(n.Left)
 		if err != nil { return err }
 		err = c.walk(n.Right)
@@ -338,13 +595,25 @@ func (c *VMCompiler) walk(node Node) error {
 		case "/": c.emit(OpDiv, 0)
 		case "%": c.emit(OpMod, 0)
 		case "==": c.emit(OpEqual, 0)
+		case "==*": c.emit(OpEqualFold, 0)
+		case "!=": c.emit(OpEqual, 0); c.emit(OpNot, 0)
 		case ">": c.emit(OpGreater, 0)
 		case "<": c.emit(OpLess, 0)
 		case ">=": c.emit(OpGreaterEqual, 0)
 		case "<=": c.emit(OpLessEqual, 0)
+		case "in": c.emit(OpIn, 0)
+		case "&": c.emit(OpBitAnd, 0)
+		case "|": c.emit(OpBitOr, 0)
+		case "^": c.emit(OpBitXor, 0)
+		case "<<": c.emit(OpShl, 0)
+		case ">>": c.emit(OpShr, 0)
 		default: return fmt.Errorf("unknown operator: %s", n.Operator)
 		}
 	case *IfExpression:
+		if name, keys, bodies, def, ok := collectIntSwitch(n); ok && !c.isLocal(name) {
+			return c.compileJumpTable(name, keys, bodies, def)
+		}
+
 		err := c.walk(n.Condition)
 		if err != nil { return err }
 
@@ -352,6 +621,10 @@ func (c *VMCompiler) walk(node Node) error {
 			return nil
 		}
 
+		if c.branchHints[n.Condition.String()] == BranchLikelyFalse {
+			return c.compileIfElseFirst(n)
+		}
+
 		jumpFalse := c.emit(OpJumpIfFalse, 0)
 		err = c.walk(n.Consequence)
 		if err != nil { return err }
@@ -367,36 +640,236 @@ func (c *VMCompiler) walk(node Node) error {
 		}
 		c.patch(jumpEnd, int32(len(c.instructions)))
 
+	case *TupleExpression:
+		// 每个元素在栈上留下一个值，RunVM 结束时按 ResultCount 收集
+		for _, el := range n.Elements {
+			if err := c.walk(el); err != nil { return err }
+		}
+		c.resultCount = len(n.Elements)
+
+	case *SequenceExpression:
+		// 除最后一条外，语句的值被丢弃
+		for i, stmt := range n.Statements {
+			if err := c.walk(stmt); err != nil { return err }
+			if i < len(n.Statements)-1 { c.emit(OpPop, 0) }
+		}
+
 	case *AssignExpression:
 		err := c.walk(n.Value)
 		if err != nil { return err }
 		c.emit(OpSetGlobal, c.addConstant(Value{Type: ValString, Str: n.Name.Value}))
 
-	case *CallExpression:
-		if ident, ok := n.Function.(*Identifier); ok && ident.Value == "concat" {
-			for _, arg := range n.Arguments {
-				err := c.walk(arg)
-				if err != nil { return err }
+	case *LetExpression:
+		if err := c.walk(n.Value); err != nil { return err }
+		slot := len(c.locals)
+		if slot >= maxLetBindings { return fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings) }
+		c.emit(OpSetLocal, int32(slot))
+		c.locals = append(c.locals, n.Name.Value)
+		c.maxLocals = max(c.maxLocals, len(c.locals))
+		err := c.walk(n.Body)
+		c.locals = c.locals[:slot]
+		if err != nil { return err }
+
+	case *DestructureExpression:
+		// 映射暂存在一个无名的 let 栈槽中，每个名称依次 GETL + MGETC + SETG + POP
+		if err := c.walk(n.Value); err != nil { return err }
+		slot := len(c.locals)
+		if slot >= maxLetBindings { return fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings) }
+		c.emit(OpSetLocal, int32(slot))
+		c.locals = append(c.locals, "")
+		c.maxLocals = max(c.maxLocals, len(c.locals))
+		for _, name := range n.Names {
+			k := c.addConstant(Value{Type: ValString, Str: name.Value})
+			c.emit(OpGetLocal, int32(slot))
+			c.emit(OpMapGetConst, k)
+			c.emit(OpSetGlobal, k)
+			c.emit(OpPop, 0)
+		}
+		var err error
+		if n.Body == nil {
+			c.emit(OpGetLocal, int32(slot))
+		} else {
+			err = c.walk(n.Body)
+		}
+		c.locals = c.locals[:slot]
+		if err != nil { return err }
+
+	case *LambdaLiteral:
+		i, err := c.compileLambda(n)
+		if err != nil { return err }
+		c.emit(OpMakeClosure, int32(i)|int32(len(c.locals))<<16)
+
+	case *ArrayLiteral:
+		// 展开之前的元素由 MKARR 收集；其后每个展开的数组，以及每段普通元素由 MKARR 收集后，依次由 SPREAD 追加
+		lead := spreadIndex(n.Elements, 0)
+		for _, el := range n.Elements[:lead] {
+			if err := c.walk(el); err != nil { return err }
+		}
+		c.emit(OpMakeArray, int32(lead))
+		for i := lead; i < len(n.Elements); {
+			if spread, ok := n.Elements[i].(*SpreadElement); ok {
+				if err := c.walk(spread.Value); err != nil { return err }
+				c.emit(OpSpread, 0)
+				i++
+				continue
+			}
+			end := spreadIndex(n.Elements, i)
+			for _, el := range n.Elements[i:end] {
+				if err := c.walk(el); err != nil { return err }
 			}
-			c.emit(OpConcat, int32(len(n.Arguments)))
+			c.emit(OpMakeArray, int32(end-i))
+			c.emit(OpSpread, 0)
+			i = end
+		}
+
+	case *MapLiteral:
+		if m, ok := constMapLiteral(n); ok {
+			c.emit(OpCopyConst, c.addConstant(Value{Type: ValMap, Obj: m}))
+			break
+		}
+		for i := range n.Keys {
+			if err := c.walk(n.Keys[i]); err != nil { return err }
+			if err := c.walk(n.Values[i]); err != nil { return err }
+		}
+		c.emit(OpMakeMap, int32(len(n.Keys)))
+
+	case *MethodCallExpression:
+		// 栈上依次为 接收者、各参数；CALLM 的 Arg 低 16 位为方法名常量，高 16 位为参数个数
+		if err := c.walk(n.Receiver); err != nil { return err }
+		for _, arg := range n.Arguments {
+			if err := c.walk(arg); err != nil { return err }
+		}
+		c.emit(OpCallMethod, c.addConstant(Value{Type: ValString, Str: n.Method})|int32(len(n.Arguments))<<16)
+
+	case *preparedLiteral:
+		c.emit(OpPush, c.addConstant(Value{Type: ValObject, Obj: n.Value}))
+
+	case *ScoreExpression:
+		// 累加值留在栈上，每个条件之后由 SCORE 弹出条件并在其为真时加上权重
+		c.emit(OpPush, c.addConstant(scoreZero(n.Weights)))
+		for i, cond := range n.Conditions {
+			if err := c.walk(cond); err != nil { return err }
+			w, _ := literalValue(n.Weights[i])
+			c.emit(OpScore, c.addConstant(w))
+		}
+
+	case *RangeExpression:
+		// 两端均为整数字面量的区间直接作为常量
+		if rng, ok := constRange(n); ok {
+			c.emit(OpPush, c.addConstant(Value{Type: ValObject, Obj: rng}))
 			return nil
 		}
+		if err := c.walk(n.Start); err != nil { return err }
+		if err := c.walk(n.End); err != nil { return err }
+		c.emit(OpMakeRange, 0)
+
+	case *OptionalMemberExpression:
+		// 接收者不是映射时 JNMAP 把它替换为 nil 并跳过成员读取
+		if err := c.walk(n.Receiver); err != nil { return err }
+		skip := c.emit(OpJumpIfNotMap, 0)
+		c.emit(OpMapGetConst, c.addConstant(Value{Type: ValString, Str: n.Name}))
+		c.patch(skip, int32(len(c.instructions)))
 
+	case *IndexExpression:
+		if err := c.walk(n.Left); err != nil { return err }
+		if err := c.walk(n.Index); err != nil { return err }
+		c.emit(OpIndex, 0)
+
+	case *IndexAssignExpression:
+		// 栈上依次为 数组、下标、新值；SETIDX 弹出三者并压回新值
+		if err := c.walk(n.Left); err != nil { return err }
+		if err := c.walk(n.Index); err != nil { return err }
+		if err := c.walk(n.Value); err != nil { return err }
+		c.emit(OpSetIndex, 0)
+
+	case *CallExpression:
+		if slot, ok := c.memo.reused(n); ok {
+			c.memoInsts = append(c.memoInsts, c.emit(OpGetLocal, int32(slot)))
+			return nil
+		}
+		if err := c.compileCall(n); err != nil { return err }
+		if slot, ok := c.memo.produced(n); ok {
+			// 结果暂存一份，供后续相同的调用读取
+			c.memoInsts = append(c.memoInsts, c.emit(OpSetLocal, int32(slot)), c.emit(OpGetLocal, int32(slot)))
+		}
+	}
+	return nil
+}
+
+func (c *VMCompiler) compileCall(n *CallExpression) error {
+	if name, ok := definedName(n); ok {
+		// let 绑定与参数总是存在
+		if c.isLocal(name) { c.emit(OpPush, c.addConstant(Value{Type: ValBool, Num: 1})) } else { c.emit(OpDefined, c.addConstant(Value{Type: ValString, Str: name})) }
+		return nil
+	}
+	if expr, fallback, ok := tryArgs(n); ok {
+		// TRY 登记备用值的入口，expr 出错时 VM 恢复栈顶后从入口继续；expr 正常求值后 ENDTRY 跳过备用值
+		try := c.emit(OpTry, 0)
+		if err := c.walk(expr); err != nil { return err }
+		end := c.emit(OpEndTry, 0)
+		c.patch(try, int32(len(c.instructions)))
+		if err := c.walk(fallback); err != nil { return err }
+		c.patch(end, int32(len(c.instructions)))
+		return nil
+	}
+	if kind, fn, ok := iterCall(n); ok && c.chunks.index(iterNames[kind]) < 0 {
+		return c.compileIteration(kind, n, fn)
+	}
+	if arg, ok := sliceCallArg(n); ok && c.chunks.index("slice") < 0 {
+		// 常量边界打包进 SLICE 的参数，只需求值第一个实参
+		if err := c.walk(n.Arguments[0]); err != nil { return err }
+		c.emit(OpSlice, arg)
+		return nil
+	}
+	if ident, ok := n.Function.(*Identifier); ok && ident.Value == "concat" {
 		for _, arg := range n.Arguments {
 			err := c.walk(arg)
 			if err != nil { return err }
 		}
-		if ident, ok := n.Function.(*Identifier); ok {
-			c.emit(OpCall, c.addConstant(Value{Type: ValString, Str: ident.Value}))
-			c.instructions[len(c.instructions)-1].Arg |= int32(len(n.Arguments)) << 16
-		} else {
-			return fmt.Errorf("calling non-identifier functions not supported in VM yet")
+		c.emit(OpConcat, int32(len(n.Arguments)))
+		return nil
+	}
+
+	for _, arg := range n.Arguments {
+		err := c.walk(arg)
+		if err != nil { return err }
+	}
+	if ident, ok := n.Function.(*Identifier); ok {
+		if i := c.chunks.index(ident.Value); i >= 0 {
+			c.emit(OpCallLocal, int32(i))
+			return nil
+		}
+		if kind, ok := castKindOf(ident.Value); ok && len(n.Arguments) == 1 {
+			c.emit(OpCast, int32(kind))
+			return nil
 		}
+		c.emit(OpCall, c.addConstant(Value{Type: ValString, Str: ident.Value}))
+		c.instructions[len(c.instructions)-1].Arg |= int32(len(n.Arguments)) << 16
+	} else {
+		return fmt.Errorf("calling non-identifier functions not supported in VM yet")
 	}
 	return nil
 }
 
+// local 查找 name 对应的 let 槽位，内层绑定优先
+func (c *VMCompiler) local(name string) (int32, bool) {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i] == name { return int32(i), true }
+	}
+	return 0, false
+}
+
+func (c *VMCompiler) isLocal(name string) bool {
+	_, ok := c.local(name)
+	return ok
+}
+
 func (c *VMCompiler) addConstant(v Value) int32 {
+	if v.Obj != nil {
+		// 容器常量按引用区分，不参与去重
+		c.constants = append(c.constants, v)
+		return int32(len(c.constants) - 1)
+	}
 	var key any
 	switch v.Type {
 	case ValInt: key = int64(v.Num)
@@ -404,6 +877,7 @@ func (c *VMCompiler) addConstant(v Value) int32 {
 	case ValBool: key = v.Num != 0
 	case ValString: key = v.Str
 	case ValNil: key = nil
+	case ValDuration: key = time.Duration(v.Num)
 	}
 	if idx, ok := c.constMap[key]; ok {
 		return idx
@@ -414,6 +888,115 @@ func (c *VMCompiler) addConstant(v Value) int32 {
 	return idx
 }
 
+// compileIfElseFirst 在条件计算完成后按预期更可能的 else 分支优先布局：
+// else 分支顺序执行，consequence 放到跳转目标处。
+// branchHintKeys 把 opts.BranchHints 的键按规则的编译流程解析并化简，返回以化简后条件的 String() 为键的提示。
+// 键因此可以按源码书写（如 "a > 10"），不必与 Fold 之后的形式逐字一致；无法解析的键是编译错误
+func branchHintKeys(opts EngineOptions) (map[string]BranchHint, error) {
+	if len(opts.BranchHints) == 0 {
+		return nil, nil
+	}
+	keys := make(map[string]BranchHint, len(opts.BranchHints))
+	for src, hint := range opts.BranchHints {
+		l := NewLexer(src)
+		l.numbers = opts.NumberFormat
+		p := NewParser(l)
+		p.inputDocument, p.decimal = opts.InputDocument, opts.Decimal
+		var cond Node = p.ParseProgram()
+		var err error
+		if len(p.Errors()) != 0 {
+			err = fmt.Errorf("branch hint %q: %v", src, p.Errors())
+		}
+		parserPool.Put(p)
+		lexerPool.Put(l)
+		if err != nil {
+			return nil, err
+		}
+		seedHashCalls(cond, opts.HashSeed)
+		if opts.OperandLogic {
+			markOperandLogic(cond)
+		}
+		if opts.OptimizationLevel >= OptBasic {
+			cond = Fold(cond)
+		}
+		keys[cond.String()] = hint
+	}
+	return keys, nil
+}
+
+func (c *VMCompiler) compileIfElseFirst(n *IfExpression) error {
+	jumpTrue := c.emit(OpJumpIfTrue, 0)
+	if n.Alternative != nil {
+		if err := c.walk(n.Alternative); err != nil { return err }
+	} else {
+		c.emit(OpPush, c.addConstant(Value{Type: ValNil}))
+	}
+	jumpEnd := c.emit(OpJump, 0)
+	c.patch(jumpTrue, int32(len(c.instructions)))
+	if err := c.walk(n.Consequence); err != nil { return err }
+	c.patch(jumpEnd, int32(len(c.instructions)))
+	return nil
+}
+
+func (c *VMCompiler) compileJumpTable(name string, keys []int64, bodies []Expression, def Expression) error {
+	minKey, maxKey := keys[0], keys[0]
+	for _, k := range keys {
+		minKey = min(minKey, k)
+		maxKey = max(maxKey, k)
+	}
+	table := &JumpTable{Min: minKey, Targets: make([]int32, maxKey-minKey+1)}
+	for i := range table.Targets {
+		table.Targets[i] = -1
+	}
+	gIdx := c.addConstant(Value{Type: ValString, Str: name})
+	c.tables = append(c.tables, table)
+	c.emit(OpJumpTableGlobal, (gIdx<<16)|int32(len(c.tables)-1))
+
+	var jumpEnds []int
+	for i, key := range keys {
+		if table.Targets[key-minKey] != -1 {
+			// 重复的键永远不会命中，与 else-if 的顺序语义一致
+			continue
+		}
+		table.Targets[key-minKey] = int32(len(c.instructions))
+		if err := c.walk(bodies[i]); err != nil { return err }
+		jumpEnds = append(jumpEnds, c.emit(OpJump, 0))
+	}
+
+	table.Default = int32(len(c.instructions))
+	if def != nil {
+		if err := c.walk(def); err != nil { return err }
+	} else {
+		c.emit(OpPush, c.addConstant(Value{Type: ValNil}))
+	}
+	for _, pos := range jumpEnds {
+		c.patch(pos, int32(len(c.instructions)))
+	}
+	for i, target := range table.Targets {
+		if target == -1 {
+			table.Targets[i] = table.Default
+		}
+	}
+	return nil
+}
+
+// maxPackedIndex 是打包进同一个 int32 操作数（高低各 16 位）的两个下标的上限，高位不超过 15 位才能保持操作数非负
+const maxPackedIndex = 1 << 15
+
+// emitInSetGlobal 将变量 name 是否属于 vals 编译为一条 INSETG，操作数打包变量名常量与集合的下标；
+// 任一下标超出 maxPackedIndex 时不生成指令并返回 false，由调用方按原表达式逐项比较
+func (c *VMCompiler) emitInSetGlobal(name string, vals []Value) bool {
+	gIdx := c.addConstant(Value{Type: ValString, Str: name})
+	if gIdx >= maxPackedIndex || len(c.sets) >= maxPackedIndex { return false }
+	c.emit(OpInSetGlobal, (gIdx<<16)|c.addSet(vals))
+	return true
+}
+
+func (c *VMCompiler) addSet(vals []Value) int32 {
+	c.sets = append(c.sets, NewValueSet(vals))
+	return int32(len(c.sets) - 1)
+}
+
 func (c *VMCompiler) emit(op OpCode, arg int32) int {
 	c.instructions = append(c.instructions, vmInstruction{Op: op, Arg: arg})
 	return len(c.instructions) - 1
@@ -422,3 +1005,179 @@ func (c *VMCompiler) emit(op OpCode, arg int32) int {
 func (c *VMCompiler) patch(pos int, arg int32) {
 	c.instructions[pos].Arg = arg
 }
+
+// compileIteration 把以 lambda 字面量为实参的 map、filter、reduce 内联为循环：数组、下标与累加值依次留在栈上，
+// lambda 的参数绑定到新的 let 槽位，函数体直接在当前栈帧中求值，不为每个元素重新进入 VM
+func (c *VMCompiler) compileIteration(kind iterKind, n *CallExpression, fn *LambdaLiteral) error {
+	if err := c.walk(n.Arguments[0]); err != nil { return err }
+	arg := kind
+	if n.Comprehension { arg |= iterComprehension }
+	c.emit(OpIter, int32(arg))
+	if kind == iterReduce {
+		if err := c.walk(n.Arguments[2]); err != nil { return err }
+	} else {
+		c.emit(OpMakeArray, 0)
+	}
+	slot := len(c.locals)
+	if slot+len(fn.Parameters) > maxLetBindings { return fmt.Errorf("too many nested let bindings (max %d)", maxLetBindings) }
+	for _, param := range fn.Parameters {
+		c.locals = append(c.locals, param.Value)
+	}
+	c.maxLocals = max(c.maxLocals, len(c.locals))
+	defer func() { c.locals = c.locals[:slot] }()
+	loop := c.emit(OpIterNext, 0)
+	// 元素在栈顶，reduce 的累加值在其下，依次写入从后往前的参数槽位
+	for i := len(fn.Parameters) - 1; i >= 0; i-- {
+		c.emit(OpSetLocal, int32(slot+i))
+	}
+	if err := c.walk(fn.Body); err != nil { return err }
+	switch kind {
+	case iterMap:
+		c.emit(OpAppend, 0)
+	case iterFilter:
+		skip := c.emit(OpJumpIfFalse, 0)
+		c.emit(OpGetLocal, int32(slot))
+		c.emit(OpAppend, 0)
+		c.patch(skip, int32(len(c.instructions)))
+	}
+	c.emit(OpJump, int32(loop))
+	c.patch(loop, int32(len(c.instructions)))
+	c.emit(OpIterEnd, 0)
+	return nil
+}
+
+// walkOperandLogic 编译返回操作数的 `&&` 或 `||`：左操作数决定结果时由 jumpOp 留在栈上并跳到末尾，
+// 否则弹出后求值右操作数
+func (c *VMCompiler) walkOperandLogic(n *InfixExpression, jumpOp OpCode) error {
+	if err := c.walk(n.Left); err != nil { return err }
+	jump := c.emit(jumpOp, 0)
+	if err := c.walk(n.Right); err != nil { return err }
+	c.patch(jump, int32(len(c.instructions)))
+	return nil
+}
+
+// minSetMatchSize 是将等值链编译为集合查找的最小分支数，
+// 少于该数量时融合比较指令已足够快。
+const minSetMatchSize = 3
+
+// collectEqualityChain 将 `x == c1 || x == c2 || ...` 展开为变量名与常量列表。
+// 任意一环不满足“同一变量与字面量比较”时返回 ok = false。
+func collectEqualityChain(node Expression) (string, []Value, bool) {
+	switch n := node.(type) {
+	case *InfixExpression:
+		switch n.Operator {
+		case "||":
+			lName, lVals, ok := collectEqualityChain(n.Left)
+			if !ok {
+				return "", nil, false
+			}
+			rName, rVals, ok := collectEqualityChain(n.Right)
+			if !ok || lName != rName {
+				return "", nil, false
+			}
+			return lName, append(lVals, rVals...), true
+		case "==":
+			ident, okI := n.Left.(*Identifier)
+			lit := n.Right
+			if !okI {
+				ident, okI = n.Right.(*Identifier)
+				lit = n.Left
+			}
+			if !okI {
+				return "", nil, false
+			}
+			val, ok := literalValue(lit)
+			if !ok {
+				return "", nil, false
+			}
+			return ident.Value, []Value{val}, true
+		}
+	}
+	return "", nil, false
+}
+
+func literalValue(node Expression) (Value, bool) {
+	switch n := node.(type) {
+	case *NumberLiteral:
+		if n.IsInt {
+			return Value{Type: ValInt, Num: uint64(n.Int64Value)}, true
+		}
+		return Value{Type: ValFloat, Num: math.Float64bits(n.Float64Value)}, true
+	case *StringLiteral:
+		return Value{Type: ValString, Str: n.Value}, true
+	case *BooleanLiteral:
+		return Value{Type: ValBool, Num: boolToUint64(n.Value)}, true
+	}
+	return Value{}, false
+}
+
+// scoreZero 返回加权求和的初值：权重均为整数时为整数 0，否则为浮点数 0
+func scoreZero(weights []*NumberLiteral) Value {
+	for _, w := range weights {
+		if !w.IsInt { return Value{Type: ValFloat} }
+	}
+	return Value{Type: ValInt}
+}
+
+// minJumpTableSize 与 maxJumpTableSpan 控制 else-if 链何时编译为跳转表：
+// 分支数足够多，且键分布足够稠密（跨度不超过分支数的两倍）。
+const (
+	minJumpTableSize = 3
+	maxJumpTableSpan = 1024
+)
+
+// collectIntSwitch 识别 `if x == 0 is .. else if x == 1 is .. else is ..` 形式的分支链。
+// 第一个不满足模式的 else 分支（或缺省的 nil）作为默认分支返回。
+func collectIntSwitch(n *IfExpression) (string, []int64, []Expression, Expression, bool) {
+	var name string
+	var keys []int64
+	var bodies []Expression
+	var cur Expression = n
+	for {
+		ie, ok := cur.(*IfExpression)
+		if !ok || ie.IsThen || ie.IsSimple {
+			break
+		}
+		ident, key, ok := intEqualityOperands(ie.Condition)
+		if !ok || (name != "" && ident != name) {
+			break
+		}
+		name = ident
+		keys = append(keys, key)
+		bodies = append(bodies, ie.Consequence)
+		cur = ie.Alternative
+	}
+	if len(keys) < minJumpTableSize {
+		return "", nil, nil, nil, false
+	}
+
+	seen := make(map[int64]struct{}, len(keys))
+	minKey, maxKey := keys[0], keys[0]
+	for _, k := range keys {
+		seen[k] = struct{}{}
+		minKey = min(minKey, k)
+		maxKey = max(maxKey, k)
+	}
+	span := uint64(maxKey - minKey)
+	if span >= maxJumpTableSpan || span >= uint64(2*len(seen)) {
+		return "", nil, nil, nil, false
+	}
+	return name, keys, bodies, cur, true
+}
+
+func intEqualityOperands(node Expression) (string, int64, bool) {
+	ie, ok := node.(*InfixExpression)
+	if !ok || ie.Operator != "==" {
+		return "", 0, false
+	}
+	ident, okI := ie.Left.(*Identifier)
+	lit, okL := ie.Right.(*NumberLiteral)
+	if !okI {
+		ident, okI = ie.Right.(*Identifier)
+		lit, okL = ie.Left.(*NumberLiteral)
+	}
+	if !okI || !okL || !lit.IsInt {
+		return "", 0, false
+	}
+	return ident.Value, lit.Int64Value, true
+}
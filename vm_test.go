@@ -4,6 +4,10 @@
 package uwasa
 
 import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -96,7 +100,7 @@ func TestVMStackOverflow(t *testing.T) {
 		t.Fatalf("NewEngineVM failed: %v", err)
 	}
 	_, err = engine.Execute(map[string]any{"a": 1})
-	if err == nil || err.Error() != "VM stack overflow" {
+	if err == nil || err.Error() != "VM stack overflow [GETG at pc 64, variable a]" {
 		t.Errorf("Expected stack overflow error, got: %v", err)
 	}
 
@@ -110,7 +114,7 @@ func TestVMStackOverflow(t *testing.T) {
 		t.Fatalf("NewEngineVM failed: %v", err)
 	}
 	_, err = engine2.Execute(map[string]any{"a": 1})
-	if err == nil || err.Error() != "VM stack overflow" {
+	if err == nil || err.Error() != "VM stack overflow [ADDG at pc 64, variable a]" {
 		t.Errorf("Expected stack overflow error, got: %v", err)
 	}
 }
@@ -143,3 +147,402 @@ func TestVM_FusedStringConcat(t *testing.T) {
 		t.Errorf("Expected %q, got %q (OpAddGlobalGlobal failed for strings)", "hello world", got2)
 	}
 }
+
+func TestVM_EqualityChainSet(t *testing.T) {
+	input := `status == "a" || status == "b" || "c" == status || status == 4`
+	engine, err := NewEngineVM(input)
+	if err != nil {
+		t.Fatalf("NewEngineVM failed: %v", err)
+	}
+	if op := engine.bytecode.Instructions[0].Op; op != OpInSetGlobal {
+		t.Fatalf("expected chain to compile to %s, got %s", OpInSetGlobal, op)
+	}
+
+	tests := []struct {
+		status   any
+		expected bool
+	}{
+		{"a", true},
+		{"c", true},
+		{"d", false},
+		{int64(4), true},
+		{4.0, true},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		got, err := engine.Execute(map[string]any{"status": tt.status})
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if got != tt.expected {
+			t.Errorf("status=%v: expected %v, got %v", tt.status, tt.expected, got)
+		}
+	}
+
+	// Mixed variables must keep the regular short-circuit chain
+	engine2, _ := NewEngineVM(`a == 1 || b == 2 || a == 3`)
+	for _, inst := range engine2.bytecode.Instructions {
+		if inst.Op == OpInSetGlobal {
+			t.Fatalf("mixed-variable chain must not compile to %s", OpInSetGlobal)
+		}
+	}
+	got, _ := engine2.Execute(map[string]any{"a": int64(0), "b": int64(2)})
+	if got != true {
+		t.Errorf("expected true, got %v", got)
+	}
+
+	// 变量名常量的下标放不进打包的操作数时退回逐项比较
+	var src strings.Builder
+	for i := range maxPackedIndex {
+		fmt.Fprintf(&src, "n = %d; ", i+1000)
+	}
+	for _, cond := range []string{`status == "a" || status == "b" || status == "c"`, `status in ["a", "b", "c"]`} {
+		engine3, err := NewEngineVMWithOptions(src.String()+cond, EngineOptions{OptimizationLevel: OptBasic})
+		if err != nil {
+			t.Fatalf("NewEngineVM failed: %v", err)
+		}
+		for _, inst := range engine3.bytecode.Instructions {
+			if inst.Op == OpInSetGlobal {
+				t.Fatalf("%s: expected the plain chain with %d constants", cond, len(engine3.bytecode.Constants))
+			}
+		}
+		if got, err := engine3.Execute(map[string]any{"status": "b"}); err != nil || got != true {
+			t.Errorf("%s: expected true, got %v (%v)", cond, got, err)
+		}
+	}
+}
+
+func TestVM_JumpTable(t *testing.T) {
+	input := `if a == 0 is "zero" else if a == 1 is "one" else if 2 == a is "two" else if a == 4 is "four" else is "other"`
+	engine, err := NewEngineVM(input)
+	if err != nil {
+		t.Fatalf("NewEngineVM failed: %v", err)
+	}
+	if op := engine.bytecode.Instructions[0].Op; op != OpJumpTableGlobal {
+		t.Fatalf("expected dense chain to compile to %s, got %s", OpJumpTableGlobal, op)
+	}
+
+	tests := []struct {
+		a        any
+		expected any
+	}{
+		{int64(0), "zero"},
+		{int64(1), "one"},
+		{2.0, "two"},
+		{int64(3), "other"},
+		{int64(4), "four"},
+		{int64(-1), "other"},
+		{"1", "other"},
+		{nil, "other"},
+	}
+	for _, tt := range tests {
+		got, err := engine.Execute(map[string]any{"a": tt.a})
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if got != tt.expected {
+			t.Errorf("a=%v: expected %v, got %v", tt.a, tt.expected, got)
+		}
+	}
+
+	// Without a default branch the result is nil, and bodies may contain side effects
+	engine2, _ := NewEngineVM(`if a == 1 is b = 10 else if a == 2 is b = 20 else if a == 3 is b = 30`)
+	vars := map[string]any{"a": int64(2)}
+	if got, _ := engine2.Execute(vars); got != int64(20) || vars["b"] != int64(20) {
+		t.Errorf("expected 20, got %v (b=%v)", got, vars["b"])
+	}
+	if got, _ := engine2.Execute(map[string]any{"a": int64(9)}); got != nil {
+		t.Errorf("expected nil, got %v", got)
+	}
+
+	// Sparse keys keep the sequential compare chain
+	engine3, _ := NewEngineVM(`if a == 1 is 1 else if a == 100 is 2 else if a == 1000 is 3`)
+	if engine3.bytecode.Instructions[0].Op == OpJumpTableGlobal {
+		t.Errorf("sparse chain must not compile to a jump table")
+	}
+
+	// match 展开为同样的 else if 链，同样生成跳转表
+	engine4, _ := NewEngineVM(`match a { 0 => "zero", 1 => "one", 2 => "two", 4 => "four", _ => "other" }`)
+	if op := engine4.bytecode.Instructions[0].Op; op != OpJumpTableGlobal {
+		t.Errorf("expected match to compile to %s, got %s", OpJumpTableGlobal, op)
+	}
+}
+
+func TestVM_BranchHints(t *testing.T) {
+	input := `if a > 10 is "big" else is "small"`
+	opts := EngineOptions{
+		OptimizationLevel: OptBasic,
+		BranchHints:       map[string]BranchHint{"(a > 10)": BranchLikelyFalse},
+	}
+	engine, err := NewEngineVMWithOptions(input, opts)
+	if err != nil {
+		t.Fatalf("NewEngineVM failed: %v", err)
+	}
+	insts := engine.bytecode.Instructions
+	// The likely else branch must directly follow the condition
+	found := false
+	for i, inst := range insts {
+		if inst.Op == OpJumpIfTrue && i+1 < len(insts) && insts[i+1].Op == OpPush {
+			found = engine.bytecode.Constants[insts[i+1].Arg].Str == "small"
+		}
+	}
+	if !found {
+		t.Errorf("expected else branch to be laid out as fall-through")
+	}
+
+	// 未标注时 consequence 紧随条件顺序执行
+	plain, _ := NewEngineVMWithOptions(input, EngineOptions{OptimizationLevel: OptBasic})
+	found = false
+	for i, inst := range plain.bytecode.Instructions {
+		if inst.Op == OpJumpIfFalse && i+1 < len(plain.bytecode.Instructions) && plain.bytecode.Instructions[i+1].Op == OpPush {
+			found = plain.bytecode.Constants[plain.bytecode.Instructions[i+1].Arg].Str == "big"
+		}
+	}
+	if !found {
+		t.Errorf("expected consequence to be laid out as fall-through by default")
+	}
+
+	for _, tt := range []struct {
+		a        int64
+		expected string
+	}{{20, "big"}, {5, "small"}} {
+		got, _ := engine.Execute(map[string]any{"a": tt.a})
+		if got != tt.expected {
+			t.Errorf("a=%d: expected %s, got %v", tt.a, tt.expected, got)
+		}
+	}
+
+	engine2, _ := NewEngineVMWithOptions(`if a > 10 then b = 1`, opts)
+	vars := map[string]any{"a": int64(1)}
+	if got, _ := engine2.Execute(vars); got != nil || vars["b"] != nil {
+		t.Errorf("expected nil without side effect, got %v (b=%v)", got, vars["b"])
+	}
+	vars["a"] = int64(11)
+	if got, _ := engine2.Execute(vars); got != int64(1) {
+		t.Errorf("expected 1, got %v", got)
+	}
+}
+
+func TestVM_BranchHintKeys(t *testing.T) {
+	input := `if a > 5 + 5 is "big" else is "small"`
+	// 键按源码书写，与规则一样经过常量折叠后匹配
+	for _, key := range []string{"a > 10", "a>10", "(a > 10)", "a > 5 + 5"} {
+		engine, err := NewEngineVMWithOptions(input, EngineOptions{
+			OptimizationLevel: OptBasic,
+			BranchHints:       map[string]BranchHint{key: BranchLikelyFalse},
+		})
+		if err != nil {
+			t.Fatalf("key %q: %v", key, err)
+		}
+		hinted := false
+		for _, inst := range engine.bytecode.Instructions {
+			hinted = hinted || inst.Op == OpJumpIfTrue
+		}
+		if !hinted {
+			t.Errorf("key %q did not match the condition", key)
+		}
+	}
+
+	if _, err := NewEngineVMWithOptions(input, EngineOptions{BranchHints: map[string]BranchHint{"a >": BranchLikelyFalse}}); err == nil {
+		t.Errorf("expected an error for an unparsable hint key")
+	}
+	if _, err := NewEngineVMNeoWithOptions(input, EngineOptions{BranchHints: map[string]BranchHint{"a > 10": BranchLikelyFalse}}); err == nil {
+		t.Errorf("expected NeoVM to reject branch hints")
+	}
+}
+
+func TestVM_ContainerOps(t *testing.T) {
+	input := `r = {"tags": [a, "x"], "n": 1}, r["tags"][1] = m.get("k"), r.set("n", r["n"] + 1), r`
+	want := map[string]any{"tags": []any{int64(4), "v"}, "n": int64(2)}
+	configs := map[string]EngineOptions{
+		"OptNone":    {OptimizationLevel: OptNone},
+		"OptBasic":   {OptimizationLevel: OptBasic},
+		"Recompiler": {OptimizationLevel: OptBasic, UseRecompiler: true},
+	}
+	for name, opts := range configs {
+		engine, err := NewEngineVMWithOptions(input, opts)
+		if err != nil {
+			t.Fatalf("%s: NewEngineVMWithOptions failed: %v", name, err)
+		}
+		ops := map[OpCode]bool{}
+		for _, inst := range engine.bytecode.Instructions {
+			ops[inst.Op] = true
+		}
+		for _, op := range []OpCode{OpMakeArray, OpMakeMap, OpIndex, OpSetIndex, OpCallMethod} {
+			if !ops[op] {
+				t.Errorf("%s: expected %s in bytecode", name, op)
+			}
+		}
+		// Run twice: the literal map must not leak state between executions
+		for i := 0; i < 2; i++ {
+			got, err := engine.Execute(map[string]any{"a": int64(4), "m": map[string]any{"k": "v"}})
+			if err != nil {
+				t.Fatalf("%s: Execute failed: %v", name, err)
+			}
+			if res := got.([]any)[3]; !reflect.DeepEqual(res, want) {
+				t.Errorf("%s run %d: expected %v, got %v", name, i, want, res)
+			}
+		}
+	}
+
+	// A constant map literal is folded into the pool and copied on each run
+	engine, _ := NewEngineVM(`{"a": 1, "b": {"c": true}}`)
+	if op := engine.bytecode.Instructions[0].Op; len(engine.bytecode.Instructions) != 1 || op != OpCopyConst {
+		t.Errorf("expected a single %s, got %v", OpCopyConst, engine.bytecode.Instructions)
+	}
+}
+
+func TestVM_UnsupportedOpcode(t *testing.T) {
+	bc := &RenderedBytecode{Instructions: []vmInstruction{{Op: OpCode(255)}}}
+	if _, err := RunVM(bc, NewMapContext(nil)); err == nil || err.Error() != "unsupported VM opcode: UNKNOWN(255) [UNKNOWN(255) at pc 0]" {
+		t.Errorf("mapped: expected unsupported opcode error, got %v", err)
+	}
+	if _, err := runVMGeneral(bc, &MapContext{}, new([64]Value), nil); err == nil {
+		t.Errorf("general: expected unsupported opcode error")
+	}
+}
+
+func TestVM_CallMemo(t *testing.T) {
+	tests := []struct {
+		input string
+		calls int
+	}{
+		// 同一条件区域内的重复调用只求值一次；&& 右侧由左侧的调用支配
+		{`len(name) > 3 && len(name) < 20`, 1},
+		{`if len(name) > 3 is len(name) else is len(name) - 1`, 1},
+		{`let n = 2 => len(name) * n + len(name)`, 1},
+		// 互斥的分支不能相互复用
+		{`if ok is len(name) else is len(name) + 1`, 2},
+		{`ok && len(name) > 3 || len(name) == 0`, 2},
+		// 参数被赋值或是 let 绑定时不复用
+		{`len(name) > 3 && (name = "x") != "" && len(name) < 20`, 2},
+		{`let name = "abc" => len(name) + len(name)`, 2},
+		{`len(name) + len(tags) + len("name")`, 2}, // len("name") 在编译期折叠
+		// 容器可能被修改时整条规则都不复用，非纯内置函数同样如此
+		{`len(tags) > 1 && (tags[0] = 1) == 1 && len(tags) > 1`, 2},
+		{`len(tags) > 1 && touch(tags) && len(tags) > 1`, 3},
+		{`same(tags) > 1 && same(tags) > 1`, 1},
+	}
+	registerTestBuiltin(t, "touch", func(args ...any) (any, error) { return true, nil }, BuiltinOptions{})
+	registerTestBuiltin(t, "same", func(args ...any) (any, error) { return int64(2), nil }, BuiltinOptions{Pure: true})
+	vars := func() map[string]any {
+		return map[string]any{"name": "uwasa", "ok": true, "tags": []any{int64(1), int64(2)}}
+	}
+	for _, tt := range tests {
+		memo, err := NewEngineVM(tt.input)
+		if err != nil {
+			t.Fatalf("%s: compile error: %v", tt.input, err)
+		}
+		calls := 0
+		for _, inst := range memo.bytecode.Instructions {
+			if inst.Op == OpCall {
+				calls++
+			}
+		}
+		if calls != tt.calls {
+			t.Errorf("%s: expected %d calls, got %d", tt.input, tt.calls, calls)
+		}
+		ast, _ := NewEngine(tt.input)
+		want, _ := ast.Execute(vars())
+		if got, err := memo.Execute(vars()); err != nil || got != want {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.input, want, got, err)
+		}
+	}
+
+	// 暂存位排在 let 槽位之上，规则内函数各自规划
+	engine, _ := NewEngineVM(`fn f(s) => len(concat(s, s)); let a = 1 => let b = 2 => f(name) + len(name) * a + len(name) * b`)
+	if got, err := engine.Execute(vars()); err != nil || got != int64(25) {
+		t.Errorf("expected 25, got %v (%v)", got, err)
+	}
+	if engine.bytecode.Locals != 3 {
+		t.Errorf("expected 2 let slots and 1 memo slot, got %d", engine.bytecode.Locals)
+	}
+}
+
+// 常量边界编译为一条 SLICE，不再压入边界与调用内置函数
+func TestVM_Slice(t *testing.T) {
+	vm, _ := NewEngineVM(`slice(a, -2, 3)`)
+	if ops := vm.bytecode.Instructions; len(ops) != 2 || ops[1].Op != OpSlice {
+		t.Errorf("expected GETG; SLICE, got %v", ops)
+	}
+}
+
+// lambda 字面量内联为循环，不再创建闭包并调用内置函数
+func TestVM_InlineIteration(t *testing.T) {
+	for name, src := range map[string]string{"map": `map(items, x -> x + a)`, "reduce": `items |> reduce((s, x) -> s + x, 0)`} {
+		vm, _ := NewEngineVM(src)
+		if !slices.ContainsFunc(vm.bytecode.Instructions, func(inst vmInstruction) bool { return inst.Op == OpIter }) ||
+			slices.ContainsFunc(vm.bytecode.Instructions, func(inst vmInstruction) bool { return inst.Op == OpMakeClosure }) {
+			t.Errorf("%s: expected an inline loop, got %v", name, vm.bytecode.Instructions)
+		}
+	}
+}
+
+// 常量区间上的 in 编译为一条 INRANGE，不构造区间
+func TestVM_InRange(t *testing.T) {
+	vm, _ := NewEngineVM(`x in 1..100`)
+	if ops := vm.bytecode.Instructions; len(ops) != 2 || ops[1].Op != OpInRange {
+		t.Errorf("expected GETG; INRANGE, got %v", ops)
+	}
+}
+
+// 两个数组字面量相加在编译期合并为一个字面量，只收集一次
+func TestVM_ArrayConcatFold(t *testing.T) {
+	vm, _ := NewEngineVM(`[x, 1] + [2]`)
+	if ops := vm.bytecode.Instructions; len(ops) != 4 || ops[3].Op != OpMakeArray || ops[3].Arg != 3 {
+		t.Errorf("expected GETG; PUSH; PUSH; MKARR 3, got %v", ops)
+	}
+}
+
+// 常量多边形与网段在编译期解析，作为一个常量压栈
+func TestVM_PreparedConstants(t *testing.T) {
+	const square = `[[35.6, 139.6], [35.6, 139.9], [35.8, 139.9], [35.8, 139.6]]`
+	vm, _ := NewEngineVM(`inPolygon(lat, lon, ` + square + `)`)
+	if ops := vm.bytecode.Instructions; len(ops) != 4 || ops[2].Op != OpPush {
+		t.Errorf("inPolygon: expected GETG; GETG; PUSH; CALL, got %v", ops)
+	} else if _, ok := vm.bytecode.Constants[ops[2].Arg].Obj.(*geoPolygon); !ok {
+		t.Errorf("expected a prepared polygon constant, got %v", vm.bytecode.Constants[ops[2].Arg])
+	}
+	vm, _ = NewEngineVM(`ipInCIDR(ip, "10.0.0.0/8")`)
+	if ops := vm.bytecode.Instructions; len(ops) != 3 || ops[1].Op != OpPush {
+		t.Errorf("ipInCIDR: expected GETG; PUSH; CALL, got %v", ops)
+	} else if _, ok := vm.bytecode.Constants[ops[1].Arg].Obj.(*cidrPrefix); !ok {
+		t.Errorf("expected a prepared prefix constant, got %v", vm.bytecode.Constants[ops[1].Arg])
+	}
+}
+
+// 常量实参的 typeof 与字符串字面量之间的比较在编译期求值
+func TestVM_ConstantBuiltins(t *testing.T) {
+	vm, _ := NewEngineVM(`typeof("a")`)
+	if vm.bytecode != nil || vm.constantResult != "string" {
+		t.Errorf("expected typeof to fold, got %v", vm.constantResult)
+	}
+	if e, _ := NewEngineVM(`"b" > "a"`); e.constantResult != true {
+		t.Errorf("expected string comparison to fold, got %v", e.constantResult)
+	}
+	if _, err := NewEngineVMWithOptions(`x > "a" && "a" > 1`, EngineOptions{UseRecompiler: true}); err == nil {
+		t.Errorf("Recompiler: expected string/number comparison to be rejected")
+	}
+}
+
+// 单参数的转换编译为 CAST 指令
+func TestVM_Cast(t *testing.T) {
+	vm, _ := NewEngineVM(`int(s)`)
+	if ops := vm.bytecode.Instructions; len(ops) != 2 || ops[1].Op != OpCast {
+		t.Errorf("expected GETG; CAST, got %v", ops)
+	}
+}
+
+// score 的每个条件编译为一条 SCORE
+func TestVM_Score(t *testing.T) {
+	vm, _ := NewEngineVM(`score { vip: 5, amount > 1000: 10 }`)
+	n := 0
+	for _, inst := range vm.bytecode.Instructions {
+		if inst.Op == OpScore {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("expected 2 SCORE instructions, got %v", vm.bytecode.Instructions)
+	}
+}
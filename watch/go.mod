@@ -0,0 +1,12 @@
+module github.com/kamihama-railway/uwasa/watch
+
+go 1.26
+
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/kamihama-railway/uwasa v0.0.0
+)
+
+require golang.org/x/sys v0.13.0 // indirect
+
+replace github.com/kamihama-railway/uwasa => ../
@@ -0,0 +1,154 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+// Package watch 监视规则目录，在规则文件变更时重新编译并热替换到 uwasa.Registry。
+// 它依赖 fsnotify，因此作为独立模块发布，不使用热加载的项目无需引入该依赖。
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kamihama-railway/uwasa"
+)
+
+// Options 配置 Watcher
+type Options struct {
+	// Ext 为规则文件扩展名，默认 ".uwasa"；规则名取文件名去掉扩展名的部分
+	Ext string
+	// Keep 为每条规则在注册表中保留的版本数，默认 10，小于 0 时不限。每次重新加载后以 Registry.Prune 丢弃更旧的版本，
+	// 频繁保存的规则文件不会让注册表无限增长
+	Keep int
+	// Validate 在新版本编译成功后、生效前调用，返回错误时该版本保留在注册表中但不生效
+	Validate func(name string, e *uwasa.Engine) error
+	// OnReload 在新版本生效后调用
+	OnReload func(rv *uwasa.RuleVersion)
+	// OnError 报告读取、编译或校验失败，此时注册表中原有的生效版本保持不变
+	OnError func(path string, err error)
+}
+
+// Watcher 监视一个目录中的规则文件。删除文件不会下线规则，注册表保留最后一个生效版本。
+type Watcher struct {
+	reg  *uwasa.Registry
+	dir  string
+	opts Options
+	fs   *fsnotify.Watcher
+	wg   sync.WaitGroup
+}
+
+// New 加载 dir 中已有的规则文件并开始监视。初次加载的失败同样经 OnError 报告，不会中止启动。
+func New(reg *uwasa.Registry, dir string, opts Options) (*Watcher, error) {
+	if opts.Ext == "" {
+		opts.Ext = ".uwasa"
+	}
+	if opts.Keep == 0 {
+		opts.Keep = 10
+	}
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.Add(dir); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	w := &Watcher{reg: reg, dir: dir, opts: opts, fs: fs}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == opts.Ext {
+			w.reload(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// Close 停止监视并等待正在进行的重新加载完成
+func (w *Watcher) Close() error {
+	err := w.fs.Close()
+	w.wg.Wait()
+	return err
+}
+
+func (w *Watcher) run() {
+	defer w.wg.Done()
+	for {
+		select {
+		case ev, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			// 编辑器常以写临时文件再改名的方式保存，目标文件表现为 Create
+			if ev.Has(fsnotify.Write) || ev.Has(fsnotify.Create) {
+				if filepath.Ext(ev.Name) == w.opts.Ext {
+					w.reload(ev.Name)
+				}
+			}
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			w.report(w.dir, err)
+		}
+	}
+}
+
+// reload 编译 path 并在成功后提升为生效版本。内容与最新版本相同时跳过，
+// 避免一次保存触发的多个写事件产生重复版本。
+func (w *Watcher) reload(path string) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			w.report(path, err)
+		}
+		return
+	}
+	// 截断写入时会先观察到空文件，等待随后的写事件
+	if strings.TrimSpace(string(src)) == "" {
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(path), w.opts.Ext)
+	if versions := w.reg.Versions(name); len(versions) > 0 && versions[len(versions)-1].Source == string(src) {
+		return
+	}
+	rv, err := w.reg.Add(name, string(src))
+	if err != nil {
+		w.report(path, err)
+		return
+	}
+	// 在提升之后剪除，新版本与原生效版本都不会在切换前被丢弃
+	if w.opts.Keep > 0 {
+		defer w.reg.Prune(name, w.opts.Keep)
+	}
+	if w.opts.Validate != nil {
+		if err := w.opts.Validate(name, rv.Engine); err != nil {
+			w.report(path, fmt.Errorf("validate %s v%d: %w", name, rv.Version, err))
+			return
+		}
+	}
+	if err := w.reg.Promote(name, rv.Version); err != nil {
+		w.report(path, err)
+		return
+	}
+	if w.opts.OnReload != nil {
+		w.opts.OnReload(rv)
+	}
+}
+
+func (w *Watcher) report(path string, err error) {
+	if w.opts.OnError != nil {
+		w.opts.OnError(path, err)
+	}
+}
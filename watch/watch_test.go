@@ -0,0 +1,127 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kamihama-railway/uwasa"
+)
+
+func TestWatcherReload(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "discount.uwasa"), []byte(`price * 0.9`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte(`not a rule`), 0o644)
+
+	reg := uwasa.NewRegistry(nil)
+	reloads := make(chan *uwasa.RuleVersion, 8)
+	errs := make(chan error, 8)
+	w, err := New(reg, dir, Options{
+		Validate: func(name string, e *uwasa.Engine) error {
+			if e.Metadata().Annotations["reject"] != nil {
+				return errors.New("rejected")
+			}
+			return nil
+		},
+		OnReload: func(rv *uwasa.RuleVersion) { reloads <- rv },
+		OnError:  func(path string, err error) { errs <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	<-reloads
+
+	price := func() any {
+		e, ok := reg.Get("discount")
+		if !ok {
+			t.Fatalf("discount not active")
+		}
+		res, _ := e.Execute(map[string]any{"price": int64(100)})
+		return res
+	}
+	if got := price(); got != 90.0 {
+		t.Fatalf("expected 90, got %v", got)
+	}
+	if names := reg.Names(); len(names) != 1 {
+		t.Errorf("expected only discount to be loaded, got %v", names)
+	}
+
+	wait := func() {
+		t.Helper()
+		select {
+		case <-reloads:
+		case err := <-errs:
+			t.Fatalf("unexpected error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for reload")
+		}
+	}
+	waitErr := func() {
+		t.Helper()
+		select {
+		case <-errs:
+		case rv := <-reloads:
+			t.Fatalf("unexpected reload of v%d", rv.Version)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for error")
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, "discount.uwasa"), []byte(`price * 0.8`), 0o644)
+	wait()
+	if got := price(); got != 80.0 {
+		t.Errorf("expected 80 after reload, got %v", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "discount.uwasa"), []byte(`price *`), 0o644)
+	waitErr()
+	if got := price(); got != 80.0 {
+		t.Errorf("compile error should keep the active version, got %v", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "discount.uwasa"), []byte(`@reject("yes") price * 0.1`), 0o644)
+	waitErr()
+	if got := price(); got != 80.0 {
+		t.Errorf("validation failure should keep the active version, got %v", got)
+	}
+}
+
+func TestWatcherKeep(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "discount.uwasa")
+	if err := os.WriteFile(path, []byte(`price * 0.9`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reg := uwasa.NewRegistry(nil)
+	reloads := make(chan *uwasa.RuleVersion, 8)
+	w, err := New(reg, dir, Options{Keep: 2, OnReload: func(rv *uwasa.RuleVersion) { reloads <- rv }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	<-reloads
+
+	for i := range 5 {
+		os.WriteFile(path, []byte(fmt.Sprintf("price * 0.%d", i+1)), 0o644)
+		select {
+		case <-reloads:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for reload %d", i)
+		}
+	}
+	// Prune 在 OnReload 之后执行，关闭后再检查
+	w.Close()
+	versions := reg.Versions("discount")
+	if len(versions) != 2 || versions[1].Version != 6 {
+		t.Fatalf("expected the last 2 of 6 versions, got %d versions", len(versions))
+	}
+	if rv, _ := reg.Active("discount"); rv.Version != 6 {
+		t.Errorf("expected v6 active, got v%d", rv.Version)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2026 WJQserver, Kamihama Railway Group. All rights reserved.
+// Licensed under the GNU Affero General Public License, version 3.0 (the "AGPL").
+
+package uwasa
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StateStore 保存滑动窗口内置函数 rate 与 countDistinct 的事件，由宿主实现。
+// 多个实例共同判定时应以 Redis 等共享存储实现；单进程可以使用 MemoryStateStore
+type StateStore interface {
+	// Rate 记录 key 的一次事件，返回最近 window 内（含本次）key 的事件数
+	Rate(key string, window time.Duration) (int64, error)
+	// CountDistinct 记录 key 下出现的 value，返回最近 window 内（含本次）key 下不同 value 的个数
+	CountDistinct(key, value string, window time.Duration) (int64, error)
+}
+
+// stateStoreRef 包装 StateStore，使不同实现可以存入同一个 atomic.Pointer
+type stateStoreRef struct {
+	store StateStore
+}
+
+var stateStore atomic.Pointer[stateStoreRef]
+
+// SetStateStore 设置 rate 与 countDistinct 使用的事件存储，传入 nil 清除。
+// 可以在规则执行期间并发调用；正在执行的调用使用调用时的存储。
+func SetStateStore(s StateStore) {
+	if s == nil {
+		stateStore.Store(nil)
+		return
+	}
+	stateStore.Store(&stateStoreRef{store: s})
+}
+
+// windowArgs 检查滑动窗口内置函数的 key 与窗口参数。窗口为 time.ParseDuration 格式的字符串
+// （如 "10m"），或以秒为单位的整数
+func windowArgs(name string, key, window any) (string, time.Duration, StateStore, error) {
+	k, ok := key.(string)
+	if !ok {
+		return "", 0, nil, fmt.Errorf("%s expects a string key, got %T", name, key)
+	}
+	var d time.Duration
+	switch w := window.(type) {
+	case string:
+		var err error
+		if d, err = time.ParseDuration(w); err != nil {
+			return "", 0, nil, fmt.Errorf("%s: %w", name, err)
+		}
+	case int64:
+		d = time.Duration(w) * time.Second
+	default:
+		return "", 0, nil, fmt.Errorf("%s expects a duration string or seconds as window, got %T", name, window)
+	}
+	if d <= 0 {
+		return "", 0, nil, fmt.Errorf("%s: window must be positive, got %s", name, d)
+	}
+	ref := stateStore.Load()
+	if ref == nil {
+		return "", 0, nil, fmt.Errorf("%s: no state store set", name)
+	}
+	return k, d, ref.store, nil
+}
+
+// rate 实现内置函数 rate(key, window)：记录一次事件并返回窗口内的事件数。每次调用都会记录，不是纯函数
+func rate(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("rate expects 2 arguments, got %d", len(args))
+	}
+	key, window, store, err := windowArgs("rate", args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return store.Rate(key, window)
+}
+
+// countDistinct 实现内置函数 countDistinct(key, value, window)：value 按 concat 的格式取得文本，
+// 返回窗口内 key 下不同取值的个数
+func countDistinct(args ...any) (any, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("countDistinct expects 3 arguments, got %d", len(args))
+	}
+	key, window, store, err := windowArgs("countDistinct", args[0], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return store.CountDistinct(key, concatText(args[1]), window)
+}
+
+// MemoryStateStore 是保存在进程内存中的 StateStore，可被多个协程同时使用。
+// 每个 key 保留其最长窗口内的事件；窗口越长、事件越密集，占用的内存越多
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	events map[string][]windowEvent
+}
+
+// windowEvent 是一次事件：Rate 的 value 为空
+type windowEvent struct {
+	at    time.Time
+	value string
+}
+
+// NewMemoryStateStore 返回空的 MemoryStateStore
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{now: time.Now, events: make(map[string][]windowEvent)}
+}
+
+// record 追加一次事件并丢弃 window 之前的事件，返回窗口内的事件。
+// Rate 与 CountDistinct 的 key 分开保存，同名不会互相计数
+func (m *MemoryStateStore) record(key string, value string, window time.Duration) []windowEvent {
+	now := m.now()
+	events := m.events[key]
+	i := 0
+	for i < len(events) && !events[i].at.After(now.Add(-window)) {
+		i++
+	}
+	events = append(events[i:], windowEvent{at: now, value: value})
+	m.events[key] = events
+	return events
+}
+
+func (m *MemoryStateStore) Rate(key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.record("r:"+key, "", window))), nil
+}
+
+func (m *MemoryStateStore) CountDistinct(key, value string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := m.record("d:"+key, value, window)
+	seen := make(map[string]struct{}, len(events))
+	for _, ev := range events {
+		seen[ev.value] = struct{}{}
+	}
+	return int64(len(seen)), nil
+}
@@ -0,0 +1,80 @@
+package uwasa
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateStore(t *testing.T) {
+	t.Cleanup(func() { SetStateStore(nil) })
+	for name, newEngine := range backends(EngineOptions{OptimizationLevel: OptBasic}) {
+		store := NewMemoryStateStore()
+		now := time.Unix(1000, 0)
+		store.now = func() time.Time { return now }
+		SetStateStore(store)
+
+		logins, err := newEngine(`rate(concat("login:", user), "10m") > 2`)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		devices, err := newEngine(`countDistinct(concat("device:", device), user, 60)`)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		login := func(user string) any {
+			got, err := logins.Execute(map[string]any{"user": user})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			return got
+		}
+		for i, expected := range []any{false, false, true} {
+			if got := login("madoka"); got != expected {
+				t.Errorf("%s: login %d: expected %v, got %v", name, i, expected, got)
+			}
+		}
+		if got := login("homura"); got != false {
+			t.Errorf("%s: expected keys to be counted separately, got %v", name, got)
+		}
+		now = now.Add(10 * time.Minute)
+		if got := login("madoka"); got != false {
+			t.Errorf("%s: expected events outside the window to expire, got %v", name, got)
+		}
+
+		for i, tt := range []struct {
+			user     string
+			expected int64
+		}{{"madoka", 1}, {"madoka", 1}, {"homura", 2}, {"sayaka", 3}} {
+			got, err := devices.Execute(map[string]any{"device": "d1", "user": tt.user})
+			if err != nil || got != tt.expected {
+				t.Errorf("%s: device %d: expected %d, got %v (%v)", name, i, tt.expected, got, err)
+			}
+		}
+		now = now.Add(61 * time.Second)
+		if got, err := devices.Execute(map[string]any{"device": "d1", "user": "kyoko"}); err != nil || got != int64(1) {
+			t.Errorf("%s: expected distinct values to expire, got %v (%v)", name, got, err)
+		}
+
+		// 短路而未执行的调用不记录事件
+		short, _ := newEngine(`false && rate("skip", 10) > 0`)
+		short.Execute(nil)
+		if n, _ := store.Rate("skip", time.Minute); n != 1 {
+			t.Errorf("%s: expected short-circuited rate not to record, got %d", name, n)
+		}
+
+		for _, in := range []string{`rate(1, "1m")`, `rate("k", "soon")`, `rate("k", 0)`, `rate("k")`, `countDistinct("k", 1, true)`} {
+			engine, err := newEngine(in)
+			if err == nil {
+				_, err = engine.Execute(nil)
+			}
+			if err == nil {
+				t.Errorf("%s %s: expected error", name, in)
+			}
+		}
+		SetStateStore(nil)
+		if _, err := logins.Execute(map[string]any{"user": "madoka"}); err == nil || !strings.Contains(err.Error(), "no state store") {
+			t.Errorf("%s: expected missing store error, got %v", name, err)
+		}
+	}
+}